@@ -0,0 +1,43 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+// Command auth-token issues capability tokens of private swarms.
+package main
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/swarm/network/stream"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func authToken(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) != 1 {
+		utils.Fatalf("Usage: swarm --auth-secret <secret> auth-token <bzz key>")
+	}
+	secret, err := hexutil.Decode(ctx.GlobalString(SwarmAuthSecretFlag.Name))
+	if err != nil {
+		utils.Fatalf("Invalid or missing --auth-secret: %v", err)
+	}
+	overlay, err := hexutil.Decode(args[0])
+	if err != nil {
+		utils.Fatalf("Invalid bzz key: %v", err)
+	}
+	fmt.Println(hexutil.Encode(stream.IssueAuthToken(secret, overlay)))
+}
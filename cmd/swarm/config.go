@@ -59,25 +59,72 @@ var (
 
 //constants for environment variables
 const (
-	SWARM_ENV_CHEQUEBOOK_ADDR      = "SWARM_CHEQUEBOOK_ADDR"
-	SWARM_ENV_ACCOUNT              = "SWARM_ACCOUNT"
-	SWARM_ENV_LISTEN_ADDR          = "SWARM_LISTEN_ADDR"
-	SWARM_ENV_PORT                 = "SWARM_PORT"
-	SWARM_ENV_NETWORK_ID           = "SWARM_NETWORK_ID"
-	SWARM_ENV_SWAP_ENABLE          = "SWARM_SWAP_ENABLE"
-	SWARM_ENV_SWAP_API             = "SWARM_SWAP_API"
-	SWARM_ENV_SYNC_DISABLE         = "SWARM_SYNC_DISABLE"
-	SWARM_ENV_SYNC_UPDATE_DELAY    = "SWARM_ENV_SYNC_UPDATE_DELAY"
-	SWARM_ENV_DELIVERY_SKIP_CHECK  = "SWARM_DELIVERY_SKIP_CHECK"
-	SWARM_ENV_ENS_API              = "SWARM_ENS_API"
-	SWARM_ENV_ENS_ADDR             = "SWARM_ENS_ADDR"
-	SWARM_ENV_CORS                 = "SWARM_CORS"
-	SWARM_ENV_BOOTNODES            = "SWARM_BOOTNODES"
-	SWARM_ENV_PSS_ENABLE           = "SWARM_PSS_ENABLE"
-	SWARM_ENV_STORE_PATH           = "SWARM_STORE_PATH"
-	SWARM_ENV_STORE_CAPACITY       = "SWARM_STORE_CAPACITY"
-	SWARM_ENV_STORE_CACHE_CAPACITY = "SWARM_STORE_CACHE_CAPACITY"
-	GETH_ENV_DATADIR               = "GETH_DATADIR"
+	SWARM_ENV_CHEQUEBOOK_ADDR           = "SWARM_CHEQUEBOOK_ADDR"
+	SWARM_ENV_ACCOUNT                   = "SWARM_ACCOUNT"
+	SWARM_ENV_PUBLISHER_ACCOUNT         = "SWARM_PUBLISHER_ACCOUNT"
+	SWARM_ENV_LISTEN_ADDR               = "SWARM_LISTEN_ADDR"
+	SWARM_ENV_PORT                      = "SWARM_PORT"
+	SWARM_ENV_S3_PORT                   = "SWARM_S3_PORT"
+	SWARM_ENV_IPFS_GATEWAY              = "SWARM_IPFS_GATEWAY"
+	SWARM_ENV_POW_DIFFICULTY            = "SWARM_POW_DIFFICULTY"
+	SWARM_ENV_VIRTUAL_HOSTS             = "SWARM_VIRTUAL_HOSTS"
+	SWARM_ENV_TLS_PORT                  = "SWARM_TLS_PORT"
+	SWARM_ENV_TLS_CERT                  = "SWARM_TLS_CERT"
+	SWARM_ENV_TLS_KEY                   = "SWARM_TLS_KEY"
+	SWARM_ENV_TLS_CERT_DIR              = "SWARM_TLS_CERT_DIR"
+	SWARM_ENV_WRITE_ADDR                = "SWARM_WRITE_ADDR"
+	SWARM_ENV_ADMIN_ADDR                = "SWARM_ADMIN_ADDR"
+	SWARM_ENV_NETWORK_ID                = "SWARM_NETWORK_ID"
+	SWARM_ENV_SWAP_ENABLE               = "SWARM_SWAP_ENABLE"
+	SWARM_ENV_SWAP_API                  = "SWARM_SWAP_API"
+	SWARM_ENV_SYNC_DISABLE              = "SWARM_SYNC_DISABLE"
+	SWARM_ENV_SYNC_UPDATE_DELAY         = "SWARM_ENV_SYNC_UPDATE_DELAY"
+	SWARM_ENV_SYNC_HEALTH               = "SWARM_SYNC_HEALTH"
+	SWARM_ENV_SYNC_HISTORY_RATE         = "SWARM_SYNC_HISTORY_RATE"
+	SWARM_ENV_RESOURCE_FANOUT           = "SWARM_RESOURCE_FANOUT"
+	SWARM_ENV_RESOURCE_RETRIEVE_TIMEOUT = "SWARM_RESOURCE_RETRIEVE_TIMEOUT"
+	SWARM_ENV_RESOURCE_STORE_TIMEOUT    = "SWARM_RESOURCE_STORE_TIMEOUT"
+	SWARM_ENV_RESOURCE_INDEX_SIZE       = "SWARM_RESOURCE_INDEX_SIZE"
+	SWARM_ENV_RESOURCE_INDEX_TTL        = "SWARM_RESOURCE_INDEX_TTL"
+	SWARM_ENV_RESOURCE_REFRESH_INTERVAL = "SWARM_RESOURCE_REFRESH_INTERVAL"
+	SWARM_ENV_RESOURCE_PREFETCH_AHEAD   = "SWARM_RESOURCE_PREFETCH_AHEAD"
+	SWARM_ENV_RESOURCE_STRICT           = "SWARM_RESOURCE_STRICT"
+	SWARM_ENV_RESOURCE_ANY_MULTIHASH    = "SWARM_RESOURCE_ALLOW_UNKNOWN_MULTIHASH"
+	SWARM_ENV_RESOURCE_ENS_UPDATE       = "SWARM_RESOURCE_ENS_UPDATE"
+	SWARM_ENV_RESOURCE_NAME_RATE        = "SWARM_RESOURCE_NAME_RATE"
+	SWARM_ENV_RESOURCE_SIGNER_RATE      = "SWARM_RESOURCE_SIGNER_RATE"
+	SWARM_ENV_RESOURCE_UPDATE_RATE      = "SWARM_RESOURCE_UPDATE_RATE"
+	SWARM_ENV_RESOURCE_CHAIN_API        = "SWARM_RESOURCE_CHAIN_API"
+	SWARM_ENV_RESOURCE_MAX_HEADER_AGE   = "SWARM_RESOURCE_MAX_HEADER_AGE"
+	SWARM_ENV_RESOURCE_OFFLINE          = "SWARM_RESOURCE_OFFLINE"
+	SWARM_ENV_RESOURCE_SHARD            = "SWARM_RESOURCE_SHARD"
+	SWARM_ENV_RESOURCE_NESTING          = "SWARM_RESOURCE_NESTING"
+	SWARM_ENV_DELIVERY_SKIP_CHECK       = "SWARM_DELIVERY_SKIP_CHECK"
+	SWARM_ENV_TRANSIT_ENCRYPTION        = "SWARM_TRANSIT_ENCRYPTION"
+	SWARM_ENV_AUTH_ALLOW                = "SWARM_AUTH_ALLOW"
+	SWARM_ENV_AUTH_SECRET               = "SWARM_AUTH_SECRET"
+	SWARM_ENV_AUTH_TOKEN                = "SWARM_AUTH_TOKEN"
+	SWARM_ENV_RELAY                     = "SWARM_RELAY"
+	SWARM_ENV_PROBE_TIMEOUT             = "SWARM_PROBE_TIMEOUT"
+	SWARM_ENV_MANAGED_KEYS              = "SWARM_MANAGED_KEYS"
+	SWARM_ENV_GATEWAY_KEYS              = "SWARM_GATEWAY_KEYS"
+	SWARM_ENV_NOTARY_API                = "SWARM_NOTARY_API"
+	SWARM_ENV_NOTARY_REGISTRY           = "SWARM_NOTARY_REGISTRY"
+	SWARM_ENV_NOTARY_CHAIN_ID           = "SWARM_NOTARY_CHAIN_ID"
+	SWARM_ENV_SEARCH_INDEX              = "SWARM_SEARCH_INDEX"
+	SWARM_ENV_ACCESS_NODE_KEY           = "SWARM_ACCESS_NODE_KEY"
+	SWARM_ENV_EVENT_SINK                = "SWARM_EVENT_SINK"
+	SWARM_ENV_ENS_API                   = "SWARM_ENS_API"
+	SWARM_ENV_ENS_ADDR                  = "SWARM_ENS_ADDR"
+	SWARM_ENV_CORS                      = "SWARM_CORS"
+	SWARM_ENV_BOOTNODES                 = "SWARM_BOOTNODES"
+	SWARM_ENV_PSS_ENABLE                = "SWARM_PSS_ENABLE"
+	SWARM_ENV_STORE_PATH                = "SWARM_STORE_PATH"
+	SWARM_ENV_STORE_CAPACITY            = "SWARM_STORE_CAPACITY"
+	SWARM_ENV_STORE_CACHE_CAPACITY      = "SWARM_STORE_CACHE_CAPACITY"
+	SWARM_ENV_STORE_COLD_PATH           = "SWARM_STORE_COLD_PATH"
+	SWARM_ENV_STORE_HOT_CAPACITY        = "SWARM_STORE_HOT_CAPACITY"
+	GETH_ENV_DATADIR                    = "GETH_DATADIR"
 )
 
 // These settings ensure that TOML keys use the same names as Go struct fields.
@@ -121,6 +168,10 @@ func initSwarmNode(config *bzzapi.Config, stack *node.Node, ctx *cli.Context) {
 	//at this point, all vars should be set in the Config
 	//get the account for the provided swarm account
 	prvkey := getAccount(config.BzzAccount, ctx, stack)
+	//the resource updates are signed with the publisher account if one is set
+	if config.PublisherAccount != "" {
+		config.SetPublisherKey(getPublisherAccount(config.PublisherAccount, ctx, stack))
+	}
 	//set the resolved config path (geth --datadir)
 	config.Path = stack.InstanceDir()
 	//finally, initialize the configuration
@@ -167,6 +218,10 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.BzzAccount = keyid
 	}
 
+	if keyid := ctx.GlobalString(SwarmPublisherAccountFlag.Name); keyid != "" {
+		currentConfig.PublisherAccount = keyid
+	}
+
 	if chbookaddr := ctx.GlobalString(ChequebookAddrFlag.Name); chbookaddr != "" {
 		currentConfig.Contract = common.HexToAddress(chbookaddr)
 	}
@@ -188,6 +243,46 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.Port = bzzport
 	}
 
+	if s3port := ctx.GlobalString(SwarmS3PortFlag.Name); s3port != "" {
+		currentConfig.S3Port = s3port
+	}
+
+	if gateway := ctx.GlobalString(SwarmIPFSGatewayFlag.Name); gateway != "" {
+		currentConfig.IPFSGateway = gateway
+	}
+
+	if pow := ctx.GlobalString(SwarmPowDifficultyFlag.Name); pow != "" {
+		currentConfig.PowDifficulty = pow
+	}
+
+	if vhosts := ctx.GlobalString(SwarmVirtualHostsFlag.Name); vhosts != "" {
+		currentConfig.VirtualHosts = vhosts
+	}
+
+	if tlsport := ctx.GlobalString(SwarmTLSPortFlag.Name); tlsport != "" {
+		currentConfig.TLSPort = tlsport
+	}
+
+	if tlscert := ctx.GlobalString(SwarmTLSCertFlag.Name); tlscert != "" {
+		currentConfig.TLSCert = tlscert
+	}
+
+	if tlskey := ctx.GlobalString(SwarmTLSKeyFlag.Name); tlskey != "" {
+		currentConfig.TLSKey = tlskey
+	}
+
+	if tlscertdir := ctx.GlobalString(SwarmTLSCertDirFlag.Name); tlscertdir != "" {
+		currentConfig.TLSCertDir = tlscertdir
+	}
+
+	if writeaddr := ctx.GlobalString(SwarmWriteAddrFlag.Name); writeaddr != "" {
+		currentConfig.WriteAddr = writeaddr
+	}
+
+	if adminaddr := ctx.GlobalString(SwarmAdminAddrFlag.Name); adminaddr != "" {
+		currentConfig.AdminAddr = adminaddr
+	}
+
 	if bzzaddr := ctx.GlobalString(SwarmListenAddrFlag.Name); bzzaddr != "" {
 		currentConfig.ListenAddr = bzzaddr
 	}
@@ -204,10 +299,149 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.SyncUpdateDelay = d
 	}
 
+	if ctx.GlobalIsSet(SwarmSyncHealthFlag.Name) {
+		currentConfig.SyncHealth = ctx.GlobalInt(SwarmSyncHealthFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(SwarmSyncHistoryRateFlag.Name) {
+		currentConfig.SyncHistoryRate = ctx.GlobalInt(SwarmSyncHistoryRateFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceFanOutFlag.Name) {
+		currentConfig.ResourceFanOut = ctx.GlobalInt(SwarmResourceFanOutFlag.Name)
+	}
+
+	if d := ctx.GlobalDuration(SwarmResourceRetrieveTimeoutFlag.Name); d > 0 {
+		currentConfig.ResourceRetrieveTimeout = d
+	}
+
+	if d := ctx.GlobalDuration(SwarmResourceStoreTimeoutFlag.Name); d > 0 {
+		currentConfig.ResourceStoreTimeout = d
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceIndexSizeFlag.Name) {
+		currentConfig.ResourceIndexSize = ctx.GlobalInt(SwarmResourceIndexSizeFlag.Name)
+	}
+
+	if d := ctx.GlobalDuration(SwarmResourceIndexTTLFlag.Name); d > 0 {
+		currentConfig.ResourceIndexTTL = d
+	}
+
+	if d := ctx.GlobalDuration(SwarmResourceRefreshIntervalFlag.Name); d > 0 {
+		currentConfig.ResourceRefreshInterval = d
+	}
+
+	if d := ctx.GlobalDuration(SwarmResourcePrefetchAheadFlag.Name); d > 0 {
+		currentConfig.ResourcePrefetchAhead = d
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceNestingFlag.Name) {
+		currentConfig.ResourceNesting = ctx.GlobalInt(SwarmResourceNestingFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceStrictFlag.Name) {
+		currentConfig.ResourceStrict = true
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceAnyMultihashFlag.Name) {
+		currentConfig.ResourceAnyMultihash = true
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceENSUpdateFlag.Name) {
+		currentConfig.ResourceENSUpdate = true
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceNameRateFlag.Name) {
+		currentConfig.ResourceNameRate = ctx.GlobalInt(SwarmResourceNameRateFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceSignerRateFlag.Name) {
+		currentConfig.ResourceSignerRate = ctx.GlobalInt(SwarmResourceSignerRateFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceUpdateRateFlag.Name) {
+		currentConfig.ResourceUpdateRate = ctx.GlobalInt(SwarmResourceUpdateRateFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceChainAPIFlag.Name) {
+		currentConfig.ResourceChainAPIs = ctx.GlobalStringSlice(SwarmResourceChainAPIFlag.Name)
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceShardFlag.Name) {
+		currentConfig.ResourceShards = ctx.GlobalStringSlice(SwarmResourceShardFlag.Name)
+	}
+
+	if d := ctx.GlobalDuration(SwarmResourceMaxHeaderAgeFlag.Name); d > 0 {
+		currentConfig.ResourceMaxHeaderAge = d
+	}
+
+	if ctx.GlobalIsSet(SwarmResourceOfflineFlag.Name) {
+		currentConfig.ResourceOffline = true
+	}
+
 	if ctx.GlobalIsSet(SwarmDeliverySkipCheckFlag.Name) {
 		currentConfig.DeliverySkipCheck = true
 	}
 
+	if ctx.GlobalIsSet(SwarmTransitEncryptionFlag.Name) {
+		currentConfig.TransitEncryption = true
+	}
+
+	if auth := ctx.GlobalString(SwarmAuthAllowFlag.Name); auth != "" {
+		currentConfig.AuthAllow = auth
+	}
+
+	if auth := ctx.GlobalString(SwarmAuthSecretFlag.Name); auth != "" {
+		currentConfig.AuthSecret = auth
+	}
+
+	if auth := ctx.GlobalString(SwarmAuthTokenFlag.Name); auth != "" {
+		currentConfig.AuthToken = auth
+	}
+
+	if ctx.GlobalIsSet(SwarmRelayFlag.Name) {
+		currentConfig.HiveParams.Relay = true
+	}
+
+	if d := ctx.GlobalDuration(SwarmProbeTimeoutFlag.Name); d > 0 {
+		currentConfig.HiveParams.ProbeTimeout = d
+	}
+
+	if ctx.GlobalIsSet(SwarmManagedKeysFlag.Name) {
+		currentConfig.ManagedKeys = true
+	}
+
+	if ctx.GlobalIsSet(SwarmGatewayKeysFlag.Name) {
+		currentConfig.GatewayKeys = true
+	}
+
+	if ctx.GlobalIsSet(SwarmSearchIndexFlag.Name) {
+		currentConfig.SearchIndex = true
+	}
+
+	if ctx.GlobalIsSet(SwarmAccessNodeKeyFlag.Name) {
+		currentConfig.AccessNodeKey = true
+	}
+
+	if ctx.GlobalIsSet(SwarmEventSinkFlag.Name) {
+		currentConfig.EventSinks = ctx.GlobalStringSlice(SwarmEventSinkFlag.Name)
+	}
+
+	if notaryapi := ctx.GlobalString(SwarmNotaryAPIFlag.Name); notaryapi != "" {
+		currentConfig.NotaryAPI = notaryapi
+	}
+
+	if registry := ctx.GlobalString(SwarmNotaryRegistryFlag.Name); registry != "" {
+		if !common.IsHexAddress(registry) {
+			utils.Fatalf("Invalid notary registry address %q", registry)
+		}
+		currentConfig.NotaryRegistry = common.HexToAddress(registry)
+	}
+
+	if ctx.GlobalIsSet(SwarmNotaryChainIDFlag.Name) {
+		currentConfig.NotaryChainID = ctx.GlobalUint64(SwarmNotaryChainIDFlag.Name)
+	}
+
 	currentConfig.SwapApi = ctx.GlobalString(SwarmSwapAPIFlag.Name)
 	if currentConfig.SwapEnabled && currentConfig.SwapApi == "" {
 		utils.Fatalf(SWARM_ERR_SWAP_SET_NO_API)
@@ -242,6 +476,14 @@ func cmdLineOverride(currentConfig *bzzapi.Config, ctx *cli.Context) *bzzapi.Con
 		currentConfig.LocalStoreParams.CacheCapacity = storeCacheCapacity
 	}
 
+	if coldPath := ctx.GlobalString(SwarmStoreColdPath.Name); coldPath != "" {
+		currentConfig.LocalStoreParams.ColdDbPath = coldPath
+	}
+
+	if hotCapacity := ctx.GlobalUint64(SwarmStoreHotCapacity.Name); hotCapacity != 0 {
+		currentConfig.LocalStoreParams.HotDbCapacity = hotCapacity
+	}
+
 	return currentConfig
 
 }
@@ -254,6 +496,10 @@ func envVarsOverride(currentConfig *bzzapi.Config) (config *bzzapi.Config) {
 		currentConfig.BzzAccount = keyid
 	}
 
+	if keyid := os.Getenv(SWARM_ENV_PUBLISHER_ACCOUNT); keyid != "" {
+		currentConfig.PublisherAccount = keyid
+	}
+
 	if chbookaddr := os.Getenv(SWARM_ENV_CHEQUEBOOK_ADDR); chbookaddr != "" {
 		currentConfig.Contract = common.HexToAddress(chbookaddr)
 	}
@@ -273,6 +519,46 @@ func envVarsOverride(currentConfig *bzzapi.Config) (config *bzzapi.Config) {
 		currentConfig.Port = bzzport
 	}
 
+	if s3port := os.Getenv(SWARM_ENV_S3_PORT); s3port != "" {
+		currentConfig.S3Port = s3port
+	}
+
+	if gateway := os.Getenv(SWARM_ENV_IPFS_GATEWAY); gateway != "" {
+		currentConfig.IPFSGateway = gateway
+	}
+
+	if pow := os.Getenv(SWARM_ENV_POW_DIFFICULTY); pow != "" {
+		currentConfig.PowDifficulty = pow
+	}
+
+	if vhosts := os.Getenv(SWARM_ENV_VIRTUAL_HOSTS); vhosts != "" {
+		currentConfig.VirtualHosts = vhosts
+	}
+
+	if tlsport := os.Getenv(SWARM_ENV_TLS_PORT); tlsport != "" {
+		currentConfig.TLSPort = tlsport
+	}
+
+	if tlscert := os.Getenv(SWARM_ENV_TLS_CERT); tlscert != "" {
+		currentConfig.TLSCert = tlscert
+	}
+
+	if tlskey := os.Getenv(SWARM_ENV_TLS_KEY); tlskey != "" {
+		currentConfig.TLSKey = tlskey
+	}
+
+	if tlscertdir := os.Getenv(SWARM_ENV_TLS_CERT_DIR); tlscertdir != "" {
+		currentConfig.TLSCertDir = tlscertdir
+	}
+
+	if writeaddr := os.Getenv(SWARM_ENV_WRITE_ADDR); writeaddr != "" {
+		currentConfig.WriteAddr = writeaddr
+	}
+
+	if adminaddr := os.Getenv(SWARM_ENV_ADMIN_ADDR); adminaddr != "" {
+		currentConfig.AdminAddr = adminaddr
+	}
+
 	if bzzaddr := os.Getenv(SWARM_ENV_LISTEN_ADDR); bzzaddr != "" {
 		currentConfig.ListenAddr = bzzaddr
 	}
@@ -301,6 +587,194 @@ func envVarsOverride(currentConfig *bzzapi.Config) (config *bzzapi.Config) {
 		}
 	}
 
+	if v := os.Getenv(SWARM_ENV_SYNC_HEALTH); v != "" {
+		if health, err := strconv.Atoi(v); err == nil {
+			currentConfig.SyncHealth = health
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_SYNC_HISTORY_RATE); v != "" {
+		if rate, err := strconv.Atoi(v); err == nil {
+			currentConfig.SyncHistoryRate = rate
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_RETRIEVE_TIMEOUT); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			currentConfig.ResourceRetrieveTimeout = d
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_STORE_TIMEOUT); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			currentConfig.ResourceStoreTimeout = d
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_INDEX_SIZE); v != "" {
+		if size, err := strconv.Atoi(v); err == nil {
+			currentConfig.ResourceIndexSize = size
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_INDEX_TTL); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			currentConfig.ResourceIndexTTL = d
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_REFRESH_INTERVAL); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			currentConfig.ResourceRefreshInterval = d
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_PREFETCH_AHEAD); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			currentConfig.ResourcePrefetchAhead = d
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_NESTING); v != "" {
+		if depth, err := strconv.Atoi(v); err == nil {
+			currentConfig.ResourceNesting = depth
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_STRICT); v != "" {
+		if strict, err := strconv.ParseBool(v); err == nil {
+			currentConfig.ResourceStrict = strict
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_ANY_MULTIHASH); v != "" {
+		if allow, err := strconv.ParseBool(v); err == nil {
+			currentConfig.ResourceAnyMultihash = allow
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_ENS_UPDATE); v != "" {
+		if update, err := strconv.ParseBool(v); err == nil {
+			currentConfig.ResourceENSUpdate = update
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_NAME_RATE); v != "" {
+		if rate, err := strconv.Atoi(v); err == nil {
+			currentConfig.ResourceNameRate = rate
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_SIGNER_RATE); v != "" {
+		if rate, err := strconv.Atoi(v); err == nil {
+			currentConfig.ResourceSignerRate = rate
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_UPDATE_RATE); v != "" {
+		if rate, err := strconv.Atoi(v); err == nil {
+			currentConfig.ResourceUpdateRate = rate
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_CHAIN_API); v != "" {
+		currentConfig.ResourceChainAPIs = strings.Split(v, ",")
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_SHARD); v != "" {
+		currentConfig.ResourceShards = strings.Split(v, ",")
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_MAX_HEADER_AGE); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			currentConfig.ResourceMaxHeaderAge = d
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_OFFLINE); v != "" {
+		if offline, err := strconv.ParseBool(v); err == nil {
+			currentConfig.ResourceOffline = offline
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_TRANSIT_ENCRYPTION); v != "" {
+		if encrypt, err := strconv.ParseBool(v); err == nil {
+			currentConfig.TransitEncryption = encrypt
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_AUTH_ALLOW); v != "" {
+		currentConfig.AuthAllow = v
+	}
+
+	if v := os.Getenv(SWARM_ENV_AUTH_SECRET); v != "" {
+		currentConfig.AuthSecret = v
+	}
+
+	if v := os.Getenv(SWARM_ENV_AUTH_TOKEN); v != "" {
+		currentConfig.AuthToken = v
+	}
+
+	if v := os.Getenv(SWARM_ENV_RELAY); v != "" {
+		if relay, err := strconv.ParseBool(v); err == nil {
+			currentConfig.HiveParams.Relay = relay
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_PROBE_TIMEOUT); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			currentConfig.HiveParams.ProbeTimeout = d
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_MANAGED_KEYS); v != "" {
+		if managed, err := strconv.ParseBool(v); err == nil {
+			currentConfig.ManagedKeys = managed
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_GATEWAY_KEYS); v != "" {
+		if gateway, err := strconv.ParseBool(v); err == nil {
+			currentConfig.GatewayKeys = gateway
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_SEARCH_INDEX); v != "" {
+		if search, err := strconv.ParseBool(v); err == nil {
+			currentConfig.SearchIndex = search
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_ACCESS_NODE_KEY); v != "" {
+		if nodekey, err := strconv.ParseBool(v); err == nil {
+			currentConfig.AccessNodeKey = nodekey
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_EVENT_SINK); v != "" {
+		currentConfig.EventSinks = strings.Split(v, ",")
+	}
+
+	if notaryapi := os.Getenv(SWARM_ENV_NOTARY_API); notaryapi != "" {
+		currentConfig.NotaryAPI = notaryapi
+	}
+
+	if registry := os.Getenv(SWARM_ENV_NOTARY_REGISTRY); common.IsHexAddress(registry) {
+		currentConfig.NotaryRegistry = common.HexToAddress(registry)
+	}
+
+	if v := os.Getenv(SWARM_ENV_NOTARY_CHAIN_ID); v != "" {
+		if chainID, err := strconv.ParseUint(v, 10, 64); err == nil {
+			currentConfig.NotaryChainID = chainID
+		}
+	}
+
+	if v := os.Getenv(SWARM_ENV_RESOURCE_FANOUT); v != "" {
+		if fanOut, err := strconv.Atoi(v); err == nil {
+			currentConfig.ResourceFanOut = fanOut
+		}
+	}
+
 	if swapapi := os.Getenv(SWARM_ENV_SWAP_API); swapapi != "" {
 		currentConfig.SwapApi = swapapi
 	}
@@ -347,6 +821,18 @@ func dumpConfig(ctx *cli.Context) error {
 
 //validate configuration parameters
 func validateConfig(cfg *bzzapi.Config) (err error) {
+	if cfg.GatewayKeys && cfg.AdminAddr == "" {
+		return fmt.Errorf("--%s requires --%s, the API keys are issued on the admin API", SwarmGatewayKeysFlag.Name, SwarmAdminAddrFlag.Name)
+	}
+	if cfg.ManagedKeys && cfg.AdminAddr == "" {
+		return fmt.Errorf("--%s requires --%s, the publisher keys are created on the admin API", SwarmManagedKeysFlag.Name, SwarmAdminAddrFlag.Name)
+	}
+	if cfg.TLSPort != "" && cfg.TLSCert == "" && cfg.TLSCertDir == "" {
+		return fmt.Errorf("--%s requires --%s or --%s", SwarmTLSPortFlag.Name, SwarmTLSCertFlag.Name, SwarmTLSCertDirFlag.Name)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return fmt.Errorf("--%s and --%s must be given together", SwarmTLSCertFlag.Name, SwarmTLSKeyFlag.Name)
+	}
 	for _, ensAPI := range cfg.EnsAPIs {
 		if ensAPI != "" {
 			if err := validateEnsAPIs(ensAPI); err != nil {
@@ -549,6 +549,28 @@ func TestValidateConfig(t *testing.T) {
 			}},
 			err: "invalid format [tld:][contract-addr@]url for ENS API endpoint configuration \"@/data/testnet/geth.ipc\": missing contract address",
 		},
+		{
+			cfg: &api.Config{GatewayKeys: true, AdminAddr: "127.0.0.1:8502"},
+		},
+		{
+			cfg: &api.Config{GatewayKeys: true},
+			err: "--gateway-keys requires --bzzadminaddr, the API keys are issued on the admin API",
+		},
+		{
+			cfg: &api.Config{ManagedKeys: true},
+			err: "--managed-keys requires --bzzadminaddr, the publisher keys are created on the admin API",
+		},
+		{
+			cfg: &api.Config{TLSPort: "8443", TLSCertDir: "certs"},
+		},
+		{
+			cfg: &api.Config{TLSPort: "8443"},
+			err: "--tls.port requires --tls.cert or --tls.certdir",
+		},
+		{
+			cfg: &api.Config{TLSPort: "8443", TLSCert: "gateway.crt"},
+			err: "--tls.cert and --tls.key must be given together",
+		},
 	} {
 		err := validateConfig(c.cfg)
 		if c.err != "" && err.Error() != c.err {
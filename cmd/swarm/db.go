@@ -17,10 +17,12 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/ethereum/go-ethereum/cmd/utils"
 	"github.com/ethereum/go-ethereum/common"
@@ -35,7 +37,7 @@ func dbExport(ctx *cli.Context) {
 		utils.Fatalf("invalid arguments, please specify both <chunkdb> (path to a local chunk database), <file> (path to write the tar archive to, - for stdout) and the base key")
 	}
 
-	store, err := openLDBStore(args[0], common.Hex2Bytes(args[2]))
+	store, err := openLDBStore(args[0], ctx.String(DbColdPathFlag.Name), parseBaseKey(args[2]))
 	if err != nil {
 		utils.Fatalf("error opening local chunk database: %s", err)
 	}
@@ -67,7 +69,7 @@ func dbImport(ctx *cli.Context) {
 		utils.Fatalf("invalid arguments, please specify both <chunkdb> (path to a local chunk database), <file> (path to read the tar archive from, - for stdin) and the base key")
 	}
 
-	store, err := openLDBStore(args[0], common.Hex2Bytes(args[2]))
+	store, err := openLDBStore(args[0], ctx.String(DbColdPathFlag.Name), parseBaseKey(args[2]))
 	if err != nil {
 		utils.Fatalf("error opening local chunk database: %s", err)
 	}
@@ -99,7 +101,7 @@ func dbClean(ctx *cli.Context) {
 		utils.Fatalf("invalid arguments, please specify <chunkdb> (path to a local chunk database) and the base key")
 	}
 
-	store, err := openLDBStore(args[0], common.Hex2Bytes(args[1]))
+	store, err := openLDBStore(args[0], ctx.String(DbColdPathFlag.Name), parseBaseKey(args[1]))
 	if err != nil {
 		utils.Fatalf("error opening local chunk database: %s", err)
 	}
@@ -108,13 +110,158 @@ func dbClean(ctx *cli.Context) {
 	store.Cleanup()
 }
 
-func openLDBStore(path string, basekey []byte) (*storage.LDBStore, error) {
+func dbCheckPins(ctx *cli.Context) {
+	dbPins(ctx, false)
+}
+
+func dbRepairPins(ctx *cli.Context) {
+	dbPins(ctx, true)
+}
+
+func dbPins(ctx *cli.Context, repair bool) {
+	args := ctx.Args()
+	if len(args) != 2 {
+		utils.Fatalf("invalid arguments, please specify <chunkdb> (path to a local chunk database) and the base key")
+	}
+
+	store, err := openLDBStore(args[0], ctx.String(DbColdPathFlag.Name), parseBaseKey(args[1]))
+	if err != nil {
+		utils.Fatalf("error opening local chunk database: %s", err)
+	}
+	defer store.Close()
+
+	var report *storage.PinReport
+	if repair {
+		report, err = store.RepairPins()
+	} else {
+		report, err = store.CheckPins()
+	}
+	if err != nil {
+		utils.Fatalf("error checking pins of local chunk database: %s", err)
+	}
+	for _, key := range report.Missing {
+		log.Warn("pinned chunk missing", "key", key)
+	}
+	log.Info(fmt.Sprintf("checked pins: %s", report))
+	if repair && report.Mismatched > 0 {
+		log.Info(fmt.Sprintf("repaired %d reference counts", report.Mismatched))
+	}
+	if !repair && !report.Consistent() {
+		utils.Fatalf("local chunk database pins are inconsistent, run swarm db repair-pins")
+	}
+}
+
+func dbRepair(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) != 2 {
+		utils.Fatalf("invalid arguments, please specify <chunkdb> (path to a local chunk database) and the base key")
+	}
+	fix := ctx.Bool(DbFixFlag.Name)
+
+	coldPath := ctx.String(DbColdPathFlag.Name)
+	basekey := parseBaseKey(args[1])
+	store, err := openLDBStore(args[0], coldPath, basekey)
+	if storage.IsCorruptedLDBDatabase(err) {
+		if !fix {
+			utils.Fatalf("local chunk database is corrupt, run with --%s to recover it: %s", DbFixFlag.Name, err)
+		}
+		log.Warn("local chunk database is corrupt, recovering", "err", err)
+		for _, path := range []string{args[0], coldPath} {
+			if path == "" {
+				continue
+			}
+			if err := storage.RecoverLDBDatabase(path); err != nil {
+				utils.Fatalf("error recovering local chunk database %s: %s", path, err)
+			}
+		}
+		store, err = openLDBStore(args[0], coldPath, basekey)
+	}
+	if err != nil {
+		utils.Fatalf("error opening local chunk database: %s", err)
+	}
+	defer store.Close()
+
+	if !fix {
+		report, err := store.CheckConsistency()
+		if err != nil {
+			utils.Fatalf("error checking local chunk database: %s", err)
+		}
+		for _, key := range report.Dangling {
+			log.Warn("dangling index entry", "key", key)
+		}
+		log.Info(fmt.Sprintf("checked local chunk database: %s", report))
+		if report.MostlyDangling() {
+			log.Warn(fmt.Sprintf("local chunk database: %s", storage.ErrMostlyDangling))
+		} else if !report.Consistent() {
+			log.Warn(fmt.Sprintf("local chunk database is inconsistent, run with --%s to repair it", DbFixFlag.Name))
+		}
+		return
+	}
+
+	report, err := store.Repair()
+	if err != nil {
+		utils.Fatalf("error repairing local chunk database (%s): %s", report, err)
+	}
+	for _, key := range report.Dangling {
+		log.Warn("removed dangling index entry", "key", key)
+	}
+	log.Info(fmt.Sprintf("checked local chunk database: %s", report))
+
+	// removed chunks may have been pinned
+	pins, err := store.CheckPins()
+	if err != nil {
+		utils.Fatalf("error checking pins of local chunk database: %s", err)
+	}
+	if !pins.Consistent() {
+		log.Warn(fmt.Sprintf("local chunk database pins are inconsistent, run swarm db repair-pins: %s", pins))
+	}
+}
+
+func dbStats(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) != 2 {
+		utils.Fatalf("invalid arguments, please specify <chunkdb> (path to a local chunk database) and the base key")
+	}
+
+	coldPath := ctx.String(DbColdPathFlag.Name)
+	store, err := openLDBStore(args[0], coldPath, parseBaseKey(args[1]))
+	if err != nil {
+		utils.Fatalf("error opening local chunk database: %s", err)
+	}
+	defer store.Close()
+
+	stats := store.TierStats()
+	fmt.Printf("chunks\t%d\n", stats.Chunks)
+	if coldPath != "" {
+		fmt.Printf("hot\t%d\n", stats.HotChunks)
+		fmt.Printf("cold\t%d\n", stats.ColdChunks)
+	}
+}
+
+// decodes the hex encoded base key of a chunk database, the bzz key of the
+// node, with or without 0x prefix
+func parseBaseKey(s string) []byte {
+	key, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil || len(key) != common.HashLength {
+		utils.Fatalf("invalid base key %q, expected %d hex encoded bytes", s, common.HashLength)
+	}
+	return key
+}
+
+// opens a chunk database with its cold tier, if coldPath is not empty
+func openLDBStore(path string, coldPath string, basekey []byte) (*storage.LDBStore, error) {
 	if _, err := os.Stat(filepath.Join(path, "CURRENT")); err != nil {
 		return nil, fmt.Errorf("invalid chunkdb path: %s", err)
 	}
+	if coldPath != "" {
+		if _, err := os.Stat(filepath.Join(coldPath, "CURRENT")); err != nil {
+			return nil, fmt.Errorf("invalid cold chunkdb path: %s", err)
+		}
+	}
 
 	storeparams := storage.NewDefaultStoreParams()
 	ldbparams := storage.NewLDBStoreParams(storeparams, path)
 	ldbparams.BaseKey = basekey
+	ldbparams.ColdPath = coldPath
 	return storage.NewLDBStore(ldbparams)
 }
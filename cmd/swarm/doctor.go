@@ -0,0 +1,305 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	doctorTimeout      = 10 * time.Second
+	maxHeaderAge       = 5 * time.Minute  // the chain is considered not synced with older head blocks
+	maxClockDrift      = 30 * time.Second // tolerated difference of the local clock ahead of the head block
+	maxEstimatorBlocks = 100              // tolerated difference of the block estimator from the chain
+)
+
+var (
+	DoctorEthAPIFlag = cli.StringFlag{
+		Name:  "ethapi",
+		Usage: "Ethereum API endpoint to check the clock and the block estimator against (e.g. http://127.0.0.1:8545)",
+	}
+	DoctorENSNameFlag = cli.StringFlag{
+		Name:  "ens-name",
+		Usage: "ENS name to check the resolution of (e.g. theswarm.eth)",
+	}
+)
+
+const (
+	doctorOK   = "ok"
+	doctorWarn = "warning"
+	doctorFail = "FAIL"
+	doctorSkip = "skipped"
+)
+
+// the result of a doctor check
+type doctorFinding struct {
+	check   string
+	status  string
+	message string
+	advice  string // what to do about a warning or failure
+}
+
+// the subset of the node configuration the doctor needs, see swarm.Info
+type doctorNodeInfo struct {
+	Path       string
+	Port       string
+	ListenAddr string
+	EnsAPIs    []string
+}
+
+func doctor(ctx *cli.Context) {
+	args := ctx.Args()
+	if len(args) > 1 {
+		utils.Fatalf("Too many arguments - usage 'swarm doctor [<ipc endpoint>]'")
+	}
+	endpoint := filepath.Join(ctx.GlobalString(utils.DataDirFlag.Name), "bzzd.ipc")
+	if len(args) == 1 {
+		endpoint = args[0]
+	}
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		utils.Fatalf("Cannot connect to the swarm node at %s, is it running? %v", endpoint, err)
+	}
+	defer client.Close()
+
+	var info doctorNodeInfo
+	if err := client.Call(&info, "bzz_info"); err != nil {
+		utils.Fatalf("Cannot get the node info: %v", err)
+	}
+	bzzapi := strings.TrimRight(ctx.GlobalString(SwarmApiFlag.Name), "/")
+
+	findings := []*doctorFinding{
+		doctorKademlia(client),
+		doctorENS(info, bzzapi, ctx.String(DoctorENSNameFlag.Name)),
+		doctorChunkStore(info, bzzapi),
+		doctorClock(ctx.String(DoctorEthAPIFlag.Name)),
+		doctorHTTPPort(bzzapi),
+		doctorP2PPort(client),
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tFINDING")
+	var failed int
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.check, f.status, f.message)
+		if f.status == doctorFail {
+			failed++
+		}
+	}
+	w.Flush()
+	for _, f := range findings {
+		if f.advice != "" && (f.status == doctorWarn || f.status == doctorFail) {
+			fmt.Printf("\n%s: %s\n", f.check, f.advice)
+		}
+	}
+	if failed > 0 {
+		utils.Fatalf("\n%d of %d checks failed", failed, len(findings))
+	}
+}
+
+// checks that the kademlia bins are saturated
+func doctorKademlia(client *rpc.Client) *doctorFinding {
+	f := &doctorFinding{check: "kademlia"}
+	var s network.Saturation
+	if err := client.Call(&s, "bzz_saturation"); err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot get the kademlia saturation: %v", err)
+		return f
+	}
+	switch {
+	case s.Connected == 0:
+		f.status, f.message = doctorFail, fmt.Sprintf("no peers connected, %d known", s.Known)
+		f.advice = "check the bootnodes (--bootnodes) and that the p2p port can be reached"
+	case len(s.Unsaturated) > 0:
+		f.status, f.message = doctorWarn, fmt.Sprintf("%d peers connected, depth %d, bins %v have less than %d peers", s.Connected, s.Depth, s.Unsaturated, s.MinBinSize)
+		f.advice = "the node is still discovering peers, or the network is small; retrieval may need more hops"
+	default:
+		f.status, f.message = doctorOK, fmt.Sprintf("%d peers connected, depth %d, all bins saturated", s.Connected, s.Depth)
+	}
+	return f
+}
+
+// checks that ENS endpoints are configured, and that a name resolves
+func doctorENS(info doctorNodeInfo, bzzapi string, name string) *doctorFinding {
+	f := &doctorFinding{check: "ens"}
+	if len(info.EnsAPIs) == 0 {
+		f.status, f.message = doctorWarn, "no ENS endpoint configured, names cannot be resolved"
+		f.advice = "set an ENS endpoint with --ens-api"
+		return f
+	}
+	if name == "" {
+		f.status, f.message = doctorOK, fmt.Sprintf("%d ENS endpoints configured, use --ens-name to check a resolution", len(info.EnsAPIs))
+		return f
+	}
+	httpClient := &http.Client{Timeout: doctorTimeout}
+	res, err := httpClient.Get(bzzapi + "/bzz-hash:/" + name + "/")
+	if err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot resolve %s: %v", name, err)
+		return f
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot resolve %s: %s", name, res.Status)
+		f.advice = "check that the ENS endpoints are reachable and synced, and that the name is registered"
+		return f
+	}
+	f.status, f.message = doctorOK, fmt.Sprintf("%s resolves to %s", name, strings.TrimSpace(string(body)))
+	return f
+}
+
+// checks that chunks can be stored and retrieved again
+func doctorChunkStore(info doctorNodeInfo, bzzapi string) *doctorFinding {
+	f := &doctorFinding{check: "chunk store"}
+	f.advice = fmt.Sprintf("check the free disk space and the permissions of %s", info.Path)
+	data := make([]byte, 64)
+	rand.Read(data)
+	client := swarm.NewClient(bzzapi)
+	hash, err := client.UploadRaw(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot store a chunk: %v", err)
+		return f
+	}
+	reader, _, err := client.DownloadRaw(hash)
+	if err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot retrieve the stored chunk %s: %v", hash, err)
+		return f
+	}
+	defer reader.Close()
+	got, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(got, data) {
+		f.status, f.message = doctorFail, fmt.Sprintf("the stored chunk %s was not retrieved intact", hash)
+		return f
+	}
+	f.status, f.message = doctorOK, "chunks can be stored and retrieved"
+	return f
+}
+
+// checks the local clock and the block estimator used by mutable resources
+// without a chain against the head block of the chain
+func doctorClock(ethapi string) *doctorFinding {
+	f := &doctorFinding{check: "clock"}
+	if ethapi == "" {
+		f.status, f.message = doctorSkip, "use --ethapi to check the clock against the chain"
+		return f
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	client, err := ethclient.DialContext(ctx, ethapi)
+	if err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot connect to %s: %v", ethapi, err)
+		return f
+	}
+	defer client.Close()
+	head, err := client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot get the head block: %v", err)
+		return f
+	}
+	estimate, err := storage.NewBlockEstimator().HeaderByNumber(ctx, "", nil)
+	if err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot estimate the block height: %v", err)
+		return f
+	}
+	estimated := estimate.Number.Int64() - head.Number.Int64()
+	drift := time.Since(time.Unix(head.Time.Int64(), 0))
+	switch {
+	case drift > maxHeaderAge:
+		f.status, f.message = doctorWarn, fmt.Sprintf("head block %d is %v old", head.Number, drift.Round(time.Second))
+		f.advice = "the chain is not synced, or the local clock is ahead; resource periods will be off"
+	case drift < -maxClockDrift:
+		f.status, f.message = doctorWarn, fmt.Sprintf("head block %d is %v ahead of the local clock", head.Number, (-drift).Round(time.Second))
+		f.advice = "synchronise the local clock (e.g. with ntp), time based resources rely on it"
+	case estimated > maxEstimatorBlocks || estimated < -maxEstimatorBlocks:
+		f.status, f.message = doctorWarn, fmt.Sprintf("block estimator is off by %d blocks from head block %d", estimated, head.Number)
+		f.advice = "block based resources need the chain, set an ENS endpoint with --ens-api"
+	default:
+		f.status, f.message = doctorOK, fmt.Sprintf("head block %d, clock drift %v, block estimator off by %d blocks", head.Number, drift.Round(time.Second), estimated)
+	}
+	return f
+}
+
+// checks that the http api is listening
+func doctorHTTPPort(bzzapi string) *doctorFinding {
+	f := &doctorFinding{check: "http port"}
+	host := strings.TrimPrefix(strings.TrimPrefix(bzzapi, "http://"), "https://")
+	conn, err := net.DialTimeout("tcp", host, doctorTimeout)
+	if err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot connect to %s: %v", host, err)
+		f.advice = "check --bzzapi, and the --httpaddr and --bzzport of the node"
+		return f
+	}
+	conn.Close()
+	f.status, f.message = doctorOK, fmt.Sprintf("%s is reachable", host)
+	return f
+}
+
+// checks that the p2p port can be reached at the address advertised to peers
+func doctorP2PPort(client *rpc.Client) *doctorFinding {
+	f := &doctorFinding{check: "p2p port"}
+	var info p2p.NodeInfo
+	if err := client.Call(&info, "admin_nodeInfo"); err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot get the p2p node info: %v", err)
+		return f
+	}
+	addr := net.JoinHostPort(info.IP, fmt.Sprintf("%d", info.Ports.Listener))
+	conn, err := net.DialTimeout("tcp", addr, doctorTimeout)
+	if err != nil {
+		f.status, f.message = doctorFail, fmt.Sprintf("cannot connect to the advertised address %s: %v", addr, err)
+		f.advice = "check the firewall and the --port of the node"
+		return f
+	}
+	conn.Close()
+	if ip := net.ParseIP(info.IP); ip == nil || ip.IsLoopback() || ip.IsUnspecified() || isPrivateIP(ip) {
+		f.status, f.message = doctorWarn, fmt.Sprintf("%s is reachable, but it is not a public address", addr)
+		f.advice = "peers outside the local network cannot connect, set the public address with --nat extip:<ip> and forward the port"
+		return f
+	}
+	f.status, f.message = doctorOK, fmt.Sprintf("%s is reachable", addr)
+	return f
+}
+
+var privateNets = []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"}
+
+func isPrivateIP(ip net.IP) bool {
+	for _, cidr := range privateNets {
+		_, n, _ := net.ParseCIDR(cidr)
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
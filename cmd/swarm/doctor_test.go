@@ -0,0 +1,45 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestCLISwarmDoctor tests that 'swarm doctor' reports the findings of its
+// checks against a running node
+func TestCLISwarmDoctor(t *testing.T) {
+	cluster := newTestCluster(t, 1)
+	defer cluster.Shutdown()
+	node := cluster.Nodes[0]
+
+	ipc, err := filepath.Glob(filepath.Join(node.Dir, "*.ipc"))
+	if err != nil || len(ipc) != 1 {
+		t.Fatalf("expected the ipc endpoint of the node, got %v: %v", ipc, err)
+	}
+	doctor := runSwarm(t, "--bzzapi", node.URL, "doctor", ipc[0])
+	doctor.ExpectRegexp(`(?s)CHECK +STATUS +FINDING
+kademlia +FAIL +no peers connected.*
+ens +warning +no ENS endpoint configured.*
+chunk store +ok +chunks can be stored and retrieved
+clock +skipped .*
+http port +ok .*
+p2p port .*
+kademlia: check the bootnodes`)
+	doctor.WaitExit()
+}
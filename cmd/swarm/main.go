@@ -71,6 +71,11 @@ var (
 		Usage:  "Swarm account key file",
 		EnvVar: SWARM_ENV_ACCOUNT,
 	}
+	SwarmPublisherAccountFlag = cli.StringFlag{
+		Name:   "publisher-account",
+		Usage:  "Key file or keystore account signing the mutable resource updates in place of the bzz account, can be shared by several nodes",
+		EnvVar: SWARM_ENV_PUBLISHER_ACCOUNT,
+	}
 	SwarmListenAddrFlag = cli.StringFlag{
 		Name:   "httpaddr",
 		Usage:  "Swarm HTTP API listening interface",
@@ -81,6 +86,56 @@ var (
 		Usage:  "Swarm local http api port",
 		EnvVar: SWARM_ENV_PORT,
 	}
+	SwarmS3PortFlag = cli.StringFlag{
+		Name:   "s3port",
+		Usage:  "Port of the S3 compatible gateway (disabled if not set, unauthenticated, served on the host of the admin API)",
+		EnvVar: SWARM_ENV_S3_PORT,
+	}
+	SwarmIPFSGatewayFlag = cli.StringFlag{
+		Name:   "ipfs-gateway",
+		Usage:  "URL of an IPFS HTTP gateway used to serve ipfs content referenced by mutable resources (disabled if not set)",
+		EnvVar: SWARM_ENV_IPFS_GATEWAY,
+	}
+	SwarmPowDifficultyFlag = cli.StringFlag{
+		Name:   "pow.difficulty",
+		Usage:  "Proof-of-work difficulty in bits required for HTTP uploads and resource updates, per scheme (e.g. bzz:20,bzz-raw:20,bzz-resource:16)",
+		EnvVar: SWARM_ENV_POW_DIFFICULTY,
+	}
+	SwarmVirtualHostsFlag = cli.StringFlag{
+		Name:   "vhosts",
+		Usage:  "Virtual hosts served by the HTTP gateway, mapping domains to ENS names or manifest hashes (e.g. example.com=example.eth,blog.example.com=<hash>)",
+		EnvVar: SWARM_ENV_VIRTUAL_HOSTS,
+	}
+	SwarmTLSPortFlag = cli.StringFlag{
+		Name:   "tls.port",
+		Usage:  "Swarm local https api port, disabled if empty",
+		EnvVar: SWARM_ENV_TLS_PORT,
+	}
+	SwarmTLSCertFlag = cli.StringFlag{
+		Name:   "tls.cert",
+		Usage:  "Default TLS certificate file (PEM) of the https api",
+		EnvVar: SWARM_ENV_TLS_CERT,
+	}
+	SwarmTLSKeyFlag = cli.StringFlag{
+		Name:   "tls.key",
+		Usage:  "Key file (PEM) of the default TLS certificate",
+		EnvVar: SWARM_ENV_TLS_KEY,
+	}
+	SwarmTLSCertDirFlag = cli.StringFlag{
+		Name:   "tls.certdir",
+		Usage:  "Directory of per virtual host TLS certificates, stored as <host>.crt and <host>.key",
+		EnvVar: SWARM_ENV_TLS_CERT_DIR,
+	}
+	SwarmWriteAddrFlag = cli.StringFlag{
+		Name:   "bzzwriteaddr",
+		Usage:  "Address serving the HTTP write API (uploads, updates, deletes) instead of the main port (e.g. 10.0.0.1:8501)",
+		EnvVar: SWARM_ENV_WRITE_ADDR,
+	}
+	SwarmAdminAddrFlag = cli.StringFlag{
+		Name:   "bzzadminaddr",
+		Usage:  "Address serving the HTTP admin API (pinning, gateway and publisher keys) instead of the main port (e.g. 127.0.0.1:8502)",
+		EnvVar: SWARM_ENV_ADMIN_ADDR,
+	}
 	SwarmNetworkIdFlag = cli.IntFlag{
 		Name:   "bzznetworkid",
 		Usage:  "Network identifier (integer, default 3=swarm testnet)",
@@ -106,11 +161,181 @@ var (
 		Usage:  "Duration for sync subscriptions update after no new peers are added (default 15s)",
 		EnvVar: SWARM_ENV_SYNC_UPDATE_DELAY,
 	}
+	SwarmSyncHealthFlag = cli.IntFlag{
+		Name:   "sync-health",
+		Usage:  "Kademlia health score (0-100) required before syncing starts (default 0, sync right away)",
+		EnvVar: SWARM_ENV_SYNC_HEALTH,
+	}
+	SwarmSyncHistoryRateFlag = cli.IntFlag{
+		Name:   "sync-history-rate",
+		Usage:  "Bandwidth limit of initial (history) syncing in bytes per second (default 0, unlimited)",
+		EnvVar: SWARM_ENV_SYNC_HISTORY_RATE,
+	}
+	SwarmResourceFanOutFlag = cli.IntFlag{
+		Name:   "resource-fanout",
+		Usage:  "Number of periods probed concurrently by mutable resource lookups (default 8, 1 probes sequentially)",
+		EnvVar: SWARM_ENV_RESOURCE_FANOUT,
+	}
+	SwarmResourceRetrieveTimeoutFlag = cli.DurationFlag{
+		Name:   "resource-retrieve-timeout",
+		Usage:  "Timeout of retrieving a chunk in mutable resource lookups (default 100ms)",
+		EnvVar: SWARM_ENV_RESOURCE_RETRIEVE_TIMEOUT,
+	}
+	SwarmResourceStoreTimeoutFlag = cli.DurationFlag{
+		Name:   "resource-store-timeout",
+		Usage:  "Timeout of storing the chunks of a mutable resource update (default 4s)",
+		EnvVar: SWARM_ENV_RESOURCE_STORE_TIMEOUT,
+	}
+	SwarmResourceIndexSizeFlag = cli.IntFlag{
+		Name:   "resource-index-size",
+		Usage:  "Number of mutable resources kept in the index, the least recently used are evicted (default 10000)",
+		EnvVar: SWARM_ENV_RESOURCE_INDEX_SIZE,
+	}
+	SwarmResourceIndexTTLFlag = cli.DurationFlag{
+		Name:   "resource-index-ttl",
+		Usage:  "Mutable resources not synced for this long are dropped from the index (default 0, never)",
+		EnvVar: SWARM_ENV_RESOURCE_INDEX_TTL,
+	}
+	SwarmResourceRefreshIntervalFlag = cli.DurationFlag{
+		Name:   "resource-refresh-interval",
+		Usage:  "Interval of re-publishing the chunks of the mutable resources opted in with 'swarm resource refresh' (default 0, disabled)",
+		EnvVar: SWARM_ENV_RESOURCE_REFRESH_INTERVAL,
+	}
+	SwarmResourcePrefetchAheadFlag = cli.DurationFlag{
+		Name:   "resource-prefetch-ahead",
+		Usage:  "Retrieve the updates of subscribed mutable resources this long before their period starts (default 0, disabled)",
+		EnvVar: SWARM_ENV_RESOURCE_PREFETCH_AHEAD,
+	}
+	SwarmResourceNestingFlag = cli.IntFlag{
+		Name:   "resource-nesting",
+		Usage:  "Depth to which the gateway resolves mutable resources referencing other resources (default 4)",
+		EnvVar: SWARM_ENV_RESOURCE_NESTING,
+	}
+	SwarmResourceStrictFlag = cli.BoolFlag{
+		Name:   "resource-strict",
+		Usage:  "Reject mutable resource updates for periods earlier than the newest seen, and record conflicting versions (default false)",
+		EnvVar: SWARM_ENV_RESOURCE_STRICT,
+	}
+	SwarmResourceAnyMultihashFlag = cli.BoolFlag{
+		Name:   "resource-allow-unknown-multihash",
+		Usage:  "Accept multihash updates of mutable resources with codes other than sha2-256, keccak-256 and blake2b (default false)",
+		EnvVar: SWARM_ENV_RESOURCE_ANY_MULTIHASH,
+	}
+	SwarmResourceENSUpdateFlag = cli.BoolFlag{
+		Name:   "resource-ens-update",
+		Usage:  "Set the ENS content records of the names of new mutable resources to their metadata chunk, with a transaction of the bzz account (default false)",
+		EnvVar: SWARM_ENV_RESOURCE_ENS_UPDATE,
+	}
+	SwarmResourceNameRateFlag = cli.IntFlag{
+		Name:   "resource-name-rate",
+		Usage:  "Number of mutable resource update chunks accepted per name and minute (default 0, unlimited)",
+		EnvVar: SWARM_ENV_RESOURCE_NAME_RATE,
+	}
+	SwarmResourceSignerRateFlag = cli.IntFlag{
+		Name:   "resource-signer-rate",
+		Usage:  "Number of mutable resource update chunks accepted per signer and minute, unsigned ones share a signer (default 0, unlimited)",
+		EnvVar: SWARM_ENV_RESOURCE_SIGNER_RATE,
+	}
+	SwarmResourceUpdateRateFlag = cli.IntFlag{
+		Name:   "resource-update-rate",
+		Usage:  "Number of mutable resource update chunks accepted per minute (default 0, unlimited)",
+		EnvVar: SWARM_ENV_RESOURCE_UPDATE_RATE,
+	}
+	SwarmResourceChainAPIFlag = cli.StringSliceFlag{
+		Name:   "resource-chain-api",
+		Usage:  "Chain node queried for the block heights of mutable resources before the ENS APIs, can be repeated, light clients first",
+		EnvVar: SWARM_ENV_RESOURCE_CHAIN_API,
+	}
+	SwarmResourceShardFlag = cli.StringSliceFlag{
+		Name:   "resource-shard",
+		Usage:  "Directory of a chunk store the mutable resources are partitioned across by namehash, can be repeated, for nodes tracking many resources",
+		EnvVar: SWARM_ENV_RESOURCE_SHARD,
+	}
+	SwarmResourceMaxHeaderAgeFlag = cli.DurationFlag{
+		Name:   "resource-max-header-age",
+		Usage:  "Chain nodes with an older latest header are taken as not synced and skipped for mutable resources (default 0, not checked)",
+		EnvVar: SWARM_ENV_RESOURCE_MAX_HEADER_AGE,
+	}
+	SwarmResourceOfflineFlag = cli.BoolFlag{
+		Name:   "resource-offline",
+		Usage:  "Never estimate block heights, block based mutable resources fail without a chain node (default false)",
+		EnvVar: SWARM_ENV_RESOURCE_OFFLINE,
+	}
 	SwarmDeliverySkipCheckFlag = cli.BoolFlag{
 		Name:   "delivery-skip-check",
 		Usage:  "Skip chunk delivery check (default false)",
 		EnvVar: SWARM_ENV_DELIVERY_SKIP_CHECK,
 	}
+	SwarmTransitEncryptionFlag = cli.BoolFlag{
+		Name:   "transit-encryption",
+		Usage:  "Have retrieved chunks sealed to this node, so relaying peers cannot read them (default false)",
+		EnvVar: SWARM_ENV_TRANSIT_ENCRYPTION,
+	}
+	SwarmAuthAllowFlag = cli.StringFlag{
+		Name:   "auth-allow",
+		Usage:  "Serve and accept chunks only for the peers with these overlay addresses, comma separated (private swarm)",
+		EnvVar: SWARM_ENV_AUTH_ALLOW,
+	}
+	SwarmAuthSecretFlag = cli.StringFlag{
+		Name:   "auth-secret",
+		Usage:  "Serve and accept chunks only for the peers with a token issued with this shared secret (private swarm)",
+		EnvVar: SWARM_ENV_AUTH_SECRET,
+	}
+	SwarmAuthTokenFlag = cli.StringFlag{
+		Name:   "auth-token",
+		Usage:  "Capability token presented to the peers of a private swarm, issued with --auth-secret if not given",
+		EnvVar: SWARM_ENV_AUTH_TOKEN,
+	}
+	SwarmRelayFlag = cli.BoolFlag{
+		Name:   "relay",
+		Usage:  "Forward connection requests to peers behind NAT, for publicly reachable nodes (default false)",
+		EnvVar: SWARM_ENV_RELAY,
+	}
+	SwarmProbeTimeoutFlag = cli.DurationFlag{
+		Name:   "probe-timeout",
+		Usage:  "Timeout of the latency probes of peers contending for a full kademlia bin, the slower peer is dropped (default 0, no probes)",
+		EnvVar: SWARM_ENV_PROBE_TIMEOUT,
+	}
+	SwarmManagedKeysFlag = cli.BoolFlag{
+		Name:   "managed-keys",
+		Usage:  "Keep publisher keys on behalf of users of the http gateway and sign their mutable resource updates, created at bzz-signer:/ on the admin API, requires --bzzadminaddr (default false)",
+		EnvVar: SWARM_ENV_MANAGED_KEYS,
+	}
+	SwarmGatewayKeysFlag = cli.BoolFlag{
+		Name:   "gateway-keys",
+		Usage:  "Require API keys on writes to the http gateway, issued with quotas and usage accounting at bzz-keys:/ on the admin API, requires --bzzadminaddr (default false)",
+		EnvVar: SWARM_ENV_GATEWAY_KEYS,
+	}
+	SwarmSearchIndexFlag = cli.BoolFlag{
+		Name:   "search-index",
+		Usage:  "Index the text of the content uploaded to and pinned on the node and serve searches of it at bzz-search:/ (default false)",
+		EnvVar: SWARM_ENV_SEARCH_INDEX,
+	}
+	SwarmAccessNodeKeyFlag = cli.BoolFlag{
+		Name:   "access-node-key",
+		Usage:  "Unlock the access grants to the key of the node for any request to bzz-access, without credentials; not for public gateways (default false)",
+		EnvVar: SWARM_ENV_ACCESS_NODE_KEY,
+	}
+	SwarmEventSinkFlag = cli.StringSliceFlag{
+		Name:   "event-sink",
+		Usage:  "Export the node events to a webhook URL or a file as [types@]url, types being some of upload, resource, gc and peer separated by '+', can be repeated",
+		EnvVar: SWARM_ENV_EVENT_SINK,
+	}
+	SwarmNotaryAPIFlag = cli.StringFlag{
+		Name:   "notary-api",
+		Usage:  "URL of the Ethereum API root hashes are notarized on with the bzz account (disabled if not set)",
+		EnvVar: SWARM_ENV_NOTARY_API,
+	}
+	SwarmNotaryRegistryFlag = cli.StringFlag{
+		Name:   "notary-registry",
+		Usage:  "Address of a registry contract receiving the notarizations (default the bzz account)",
+		EnvVar: SWARM_ENV_NOTARY_REGISTRY,
+	}
+	SwarmNotaryChainIDFlag = cli.Uint64Flag{
+		Name:   "notary-chainid",
+		Usage:  "Chain id the notarizations are signed for (default the network id of the notary API)",
+		EnvVar: SWARM_ENV_NOTARY_CHAIN_ID,
+	}
 	EnsAPIFlag = cli.StringSliceFlag{
 		Name:   "ens-api",
 		Usage:  "ENS API endpoint for a TLD and with contract address, can be repeated, format [tld:][contract-addr@]url",
@@ -145,6 +370,10 @@ var (
 		Name:  "encrypt",
 		Usage: "use encrypted upload",
 	}
+	SwarmReproducibleFlag = cli.BoolFlag{
+		Name:  "reproducible",
+		Usage: "upload with the same hash on any machine, without modification times and modes",
+	}
 	CorsStringFlag = cli.StringFlag{
 		Name:   "corsdomain",
 		Usage:  "Domain on which to send Access-Control-Allow-Origin header (multiple domains can be supplied separated by a ',')",
@@ -165,6 +394,24 @@ var (
 		Usage:  "Number of recent chunks cached in memory (default 5000)",
 		EnvVar: SWARM_ENV_STORE_CACHE_CAPACITY,
 	}
+	SwarmStoreColdPath = cli.StringFlag{
+		Name:   "store.cold.path",
+		Usage:  "Path to leveldb DB the least accessed chunks are moved to, e.g. on a slower disk (default none, no tiering)",
+		EnvVar: SWARM_ENV_STORE_COLD_PATH,
+	}
+	SwarmStoreHotCapacity = cli.Uint64Flag{
+		Name:   "store.hot.size",
+		Usage:  "Number of chunks kept in the chunk DB with --store.cold.path (default a tenth of --store.size)",
+		EnvVar: SWARM_ENV_STORE_HOT_CAPACITY,
+	}
+	DbColdPathFlag = cli.StringFlag{
+		Name:  "cold",
+		Usage: "Path to the cold tier of the chunk DB, see --store.cold.path",
+	}
+	DbFixFlag = cli.BoolFlag{
+		Name:  "fix",
+		Usage: "Apply the repair, by default the problems are only reported",
+	}
 )
 
 //declare a few constant error messages, useful for later error check comparisons in test
@@ -207,9 +454,27 @@ The output of this command is supposed to be machine-readable.
 			Name:      "up",
 			Usage:     "upload a file or directory to swarm using the HTTP API",
 			ArgsUsage: " <file>",
-			Flags:     []cli.Flag{SwarmEncryptedFlag},
+			Flags:     []cli.Flag{SwarmEncryptedFlag, SwarmReproducibleFlag},
 			Description: `
 "upload a file or directory to swarm using the HTTP API and prints the root hash",
+
+With --reproducible, uploading the same file or directory on any machine
+yields the same hash. The files are uploaded with the same modification time
+and mode, and their content types only depend on their extensions unless set
+with --mime. It cannot be combined with --encrypt.
+`,
+		},
+		{
+			Action:    warcImport,
+			Name:      "warc",
+			Usage:     "import a WARC web archive into swarm using the HTTP API",
+			ArgsUsage: " <file> [<manifest>]",
+			Flags:     []cli.Flag{SwarmEncryptedFlag},
+			Description: `
+Imports the resources captured in a (optionally gzipped) WARC web archive into
+a new manifest, or into an existing one if given, and prints the manifest hash.
+The captured URLs are preserved as paths (host/path), so a page captured from
+http://example.com/about.html is served at bzz:/<manifest>/example.com/about.html
 `,
 		},
 		{
@@ -219,6 +484,17 @@ The output of this command is supposed to be machine-readable.
 			ArgsUsage: " <manifest> [<prefix>]",
 			Description: `
 Lists files and directories contained in a manifest.
+`,
+		},
+		{
+			Action:    authToken,
+			Name:      "auth-token",
+			Usage:     "issue the capability token of a node of a private swarm",
+			ArgsUsage: " <bzz key>",
+			Description: `
+Prints the capability token of the node with the bzz key, issued with the
+shared secret given by --auth-secret. The node presents it to its peers with
+--auth-token to retrieve and store chunks in the private swarm.
 `,
 		},
 		{
@@ -278,6 +554,167 @@ Removes a path from the manifest
 				},
 			},
 		},
+		{
+			Action:    doctor,
+			Name:      "doctor",
+			Usage:     "check the connectivity and the setup of a running node",
+			ArgsUsage: "[<ipc endpoint>]",
+			Flags:     []cli.Flag{DoctorEthAPIFlag, DoctorENSNameFlag},
+			Description: `
+Run checks against a running node and print what to do about the problems found:
+
+    - kademlia saturation: whether the bins of the routing table have enough peers
+    - ens: whether names can be resolved (use --ens-name to resolve a name)
+    - chunk store: whether chunks can be stored and retrieved
+    - clock: the local clock and the block estimator against the chain (needs --ethapi)
+    - http and p2p ports: whether the node can be reached
+
+The node is reached through its IPC endpoint, <datadir>/bzzd.ipc by default,
+and its HTTP API given by --bzzapi.
+
+    swarm doctor --ethapi http://127.0.0.1:8545 --ens-name theswarm.eth
+`,
+		},
+		{
+			Name:      "resource",
+			Usage:     "create, update and look up mutable resources",
+			ArgsUsage: "resource COMMAND",
+			Description: `
+Create, update and look up mutable resources through the IPC endpoint of a
+running node, <datadir>/bzzd.ipc by default. Updates are signed with the
+account of the node.
+`,
+			Subcommands: []cli.Command{
+				{
+					Action: resourceCreate,
+					Name:   "create",
+					Usage:  "create a mutable resource and print its root key",
+					Flags:  []cli.Flag{ResourceIPCFlag, ResourceNameFlag, ResourceFrequencyFlag, ResourceTimeFlag},
+					Description: `
+Create a mutable resource and print the hex encoded key of its metadata chunk,
+which is used to look up the resource.
+
+    swarm resource create --name feed.mydomain.eth --frequency 3600 --time
+`,
+				},
+				{
+					Action: resourceUpdate,
+					Name:   "update",
+					Usage:  "publish an update of a mutable resource",
+					Flags:  []cli.Flag{ResourceIPCFlag, ResourceNameFlag, ResourceDataFlag, ResourceMultihashFlag},
+					Description: `
+Publish an update of a mutable resource with the given data or multihash and
+print the hex encoded key of the update chunk with its period and version.
+
+    swarm resource update --name feed.mydomain.eth --data "hello world"
+    swarm resource update --name feed.mydomain.eth --multihash 0x1b20...
+`,
+				},
+				{
+					Action:    resourceInfo,
+					Name:      "info",
+					Usage:     "print the metadata of an update of a mutable resource",
+					ArgsUsage: "<root key>",
+					Flags:     []cli.Flag{ResourceIPCFlag, ResourcePeriodFlag, ResourceVersionFlag},
+					Description: `
+Look up the latest update of a mutable resource, or the one of --period and
+--version, and print its name, period, version, size, creation time and signer.
+`,
+				},
+				{
+					Action:    resourceConflicts,
+					Name:      "conflicts",
+					Usage:     "print the competing updates of a period of a mutable resource",
+					ArgsUsage: "<root key>",
+					Flags:     []cli.Flag{ResourceIPCFlag, ResourcePeriodFlag},
+					Description: `
+Retrieve all versions of the --period of a mutable resource and print their
+signers, and the versions seen with different data by a node with strict
+validation. The period has diverged if its updates were made by more than one
+signer, or if different data was seen for a version.
+`,
+				},
+				{
+					Action:    resourceGet,
+					Name:      "get",
+					Usage:     "print the data of an update of a mutable resource",
+					ArgsUsage: "<root key>",
+					Flags:     []cli.Flag{ResourceIPCFlag, ResourcePeriodFlag, ResourceVersionFlag},
+					Description: `
+Look up the latest update of a mutable resource, or the one of --period and
+--version, and write its data to stdout.
+`,
+				},
+				{
+					Action: resourceRefresh,
+					Name:   "refresh",
+					Usage:  "re-publish the chunks of a mutable resource periodically",
+					Flags:  []cli.Flag{ResourceIPCFlag, ResourceNameFlag, ResourceRefreshOffFlag},
+					Description: `
+Have the node offer the metadata chunk and the latest update chunk of a mutable
+resource to its peers again every --resource-refresh-interval, so that the
+feed stays retrievable while its publisher is offline. The resource must be
+loaded in the node, and refreshing stops when the node restarts.
+
+    swarm resource refresh --name feed.mydomain.eth
+    swarm resource refresh --name feed.mydomain.eth --off
+`,
+				},
+			},
+		},
+		{
+			Name:      "notary",
+			Usage:     "record root hashes on the chain and look up the records",
+			ArgsUsage: "notary COMMAND",
+			Description: `
+Record root hashes on the chain as a proof of existence of the content, through
+the IPC endpoint of a running node, <datadir>/bzzd.ipc by default. The node
+sends the notarizations with its bzz account to the chain of --notary-api.
+`,
+			Subcommands: []cli.Command{
+				{
+					Action:    notarize,
+					Name:      "submit",
+					Usage:     "record a root hash on the chain and print the transaction hash",
+					ArgsUsage: "<root hash>",
+					Flags:     []cli.Flag{ResourceIPCFlag},
+					Description: `
+Send a transaction recording the root hash of uploaded content (or of a mutable
+resource update) and print its hash. The time of the block it is included in
+proves that the content existed at that time.
+
+    swarm notary submit 2477cc8584cc61091b5cc084cdcdb45bf3c6210c263b0143f030cf7d750e894d
+`,
+				},
+				{
+					Action:    notarization,
+					Name:      "lookup",
+					Usage:     "print the root hash and time recorded by a notarization",
+					ArgsUsage: "<transaction hash>",
+					Flags:     []cli.Flag{ResourceIPCFlag},
+					Description: `
+Look up a notarization transaction and print the root hash it records, its
+sender and the block and time it was included at.
+`,
+				},
+			},
+		},
+		{
+			Action: stressResources,
+			Name:   "resource-stress",
+			Usage:  "run a stress test of the mutable resource subsystem",
+			Flags:  []cli.Flag{StressResourcesFlag, StressUpdatesFlag, StressWorkersFlag, StressRateFlag, StressSizeFlag, StressSeedFlag},
+			Description: `
+Create resources on a temporary local chunk store, publish updates to them from
+several workers and read back every update. Prints the number of failed and
+inconsistent reads and the percentiles of the update and lookup latencies.
+
+The names and the data of the updates only depend on --seed, so runs with the
+same options publish the same updates.
+
+    swarm resource-stress --resources 1000 --updates 10 --workers 16 --rate 500
+`,
+		},
 		{
 			Name:      "db",
 			Usage:     "manage the local chunk database",
@@ -291,6 +728,7 @@ Manage the local chunk database.
 					Name:      "export",
 					Usage:     "export a local chunk database as a tar archive (use - to send to stdout)",
 					ArgsUsage: "<chunkdb> <file>",
+					Flags:     []cli.Flag{DbColdPathFlag},
 					Description: `
 Export a local chunk database as a tar archive (use - to send to stdout).
 
@@ -307,6 +745,7 @@ pv(1) tool to get a progress bar:
 					Name:      "import",
 					Usage:     "import chunks from a tar archive into a local chunk database (use - to read from stdin)",
 					ArgsUsage: "<chunkdb> <file>",
+					Flags:     []cli.Flag{DbColdPathFlag},
 					Description: `
 Import chunks from a tar archive into a local chunk database (use - to read from stdin).
 
@@ -323,8 +762,65 @@ pv(1) tool to get a progress bar:
 					Name:      "clean",
 					Usage:     "remove corrupt entries from a local chunk database",
 					ArgsUsage: "<chunkdb>",
+					Flags:     []cli.Flag{DbColdPathFlag},
 					Description: `
 Remove corrupt entries from a local chunk database.
+`,
+				},
+				{
+					Action:    dbCheckPins,
+					Name:      "check-pins",
+					Usage:     "check the reference counts of pinned chunks in a local chunk database",
+					ArgsUsage: "<chunkdb>",
+					Flags:     []cli.Flag{DbColdPathFlag},
+					Description: `
+Check that the reference counts protecting pinned chunks from garbage collection
+match the pinned content and that all pinned chunks are present.
+
+    swarm db check-pins ~/.ethereum/swarm/bzz-KEY/chunks KEY
+`,
+				},
+				{
+					Action:    dbRepairPins,
+					Name:      "repair-pins",
+					Usage:     "rebuild the reference counts of pinned chunks in a local chunk database",
+					ArgsUsage: "<chunkdb>",
+					Flags:     []cli.Flag{DbColdPathFlag},
+					Description: `
+Rebuild the reference counts protecting pinned chunks from garbage collection
+from the pinned content. Missing chunks are reported, they can be retrieved
+again by pinning the content on a running node.
+`,
+				},
+				{
+					Action:    dbRepair,
+					Name:      "repair",
+					Usage:     "reconcile a local chunk database after an unclean shutdown",
+					ArgsUsage: "<chunkdb>",
+					Flags:     []cli.Flag{DbColdPathFlag, DbFixFlag},
+					Description: `
+Reconcile a local chunk database after an unclean shutdown. Without --fix the
+problems are only reported. With --fix a corrupt database is recovered first,
+losing the entries it cannot read. Index entries without the data of their
+chunk and data entries without an index entry are removed, as are resource
+roots referring to a missing metadata chunk. Nothing is changed if most index
+entries are missing their data, which usually means the base key is wrong.
+
+    swarm db repair ~/.ethereum/swarm/bzz-KEY/chunks KEY
+    swarm db repair --fix ~/.ethereum/swarm/bzz-KEY/chunks KEY
+`,
+				},
+				{
+					Action:    dbStats,
+					Name:      "stats",
+					Usage:     "print the chunk counts of a local chunk database and its tiers",
+					ArgsUsage: "<chunkdb>",
+					Flags:     []cli.Flag{DbColdPathFlag},
+					Description: `
+Print the number of chunks in a local chunk database, and how many of them are
+in the hot and the cold tier if it has a cold tier (see --store.cold.path).
+
+    swarm db stats --cold /mnt/hdd/swarm/chunks ~/.ethereum/swarm/bzz-KEY/chunks KEY
 `,
 				},
 			},
@@ -358,10 +854,55 @@ Remove corrupt entries from a local chunk database.
 		SwarmSwapAPIFlag,
 		SwarmSyncDisabledFlag,
 		SwarmSyncUpdateDelay,
+		SwarmSyncHealthFlag,
+		SwarmSyncHistoryRateFlag,
+		SwarmResourceFanOutFlag,
+		SwarmResourceRetrieveTimeoutFlag,
+		SwarmResourceStoreTimeoutFlag,
+		SwarmResourceIndexSizeFlag,
+		SwarmResourceIndexTTLFlag,
+		SwarmResourceRefreshIntervalFlag,
+		SwarmResourcePrefetchAheadFlag,
+		SwarmResourceNestingFlag,
+		SwarmResourceStrictFlag,
+		SwarmResourceAnyMultihashFlag,
+		SwarmResourceENSUpdateFlag,
+		SwarmResourceNameRateFlag,
+		SwarmResourceSignerRateFlag,
+		SwarmResourceUpdateRateFlag,
+		SwarmResourceChainAPIFlag,
+		SwarmResourceMaxHeaderAgeFlag,
+		SwarmResourceOfflineFlag,
+		SwarmResourceShardFlag,
 		SwarmDeliverySkipCheckFlag,
+		SwarmTransitEncryptionFlag,
+		SwarmAuthAllowFlag,
+		SwarmAuthSecretFlag,
+		SwarmAuthTokenFlag,
+		SwarmRelayFlag,
+		SwarmProbeTimeoutFlag,
+		SwarmManagedKeysFlag,
+		SwarmGatewayKeysFlag,
+		SwarmSearchIndexFlag,
+		SwarmAccessNodeKeyFlag,
+		SwarmEventSinkFlag,
+		SwarmNotaryAPIFlag,
+		SwarmNotaryRegistryFlag,
+		SwarmNotaryChainIDFlag,
 		SwarmListenAddrFlag,
 		SwarmPortFlag,
+		SwarmS3PortFlag,
+		SwarmIPFSGatewayFlag,
+		SwarmPowDifficultyFlag,
+		SwarmVirtualHostsFlag,
+		SwarmTLSPortFlag,
+		SwarmTLSCertFlag,
+		SwarmTLSKeyFlag,
+		SwarmTLSCertDirFlag,
+		SwarmWriteAddrFlag,
+		SwarmAdminAddrFlag,
 		SwarmAccountFlag,
+		SwarmPublisherAccountFlag,
 		SwarmNetworkIdFlag,
 		ChequebookAddrFlag,
 		// upload flags
@@ -373,6 +914,8 @@ Remove corrupt entries from a local chunk database.
 		SwarmUploadMimeType,
 		// storage flags
 		SwarmStorePath,
+		SwarmStoreColdPath,
+		SwarmStoreHotCapacity,
 		SwarmStoreCapacity,
 		SwarmStoreCacheCapacity,
 	}
@@ -509,6 +1052,19 @@ func getAccount(bzzaccount string, ctx *cli.Context, stack *node.Node) *ecdsa.Pr
 	return decryptStoreAccount(ks, bzzaccount, utils.MakePasswordList(ctx))
 }
 
+// getPublisherAccount loads the key of the publisher account, which is looked
+// up like the bzz account, a key file or an account of the keystore
+func getPublisherAccount(account string, ctx *cli.Context, stack *node.Node) *ecdsa.PrivateKey {
+	if key, err := crypto.LoadECDSA(account); err == nil {
+		log.Info("Swarm publisher key loaded", "address", crypto.PubkeyToAddress(key.PublicKey))
+		return key
+	}
+	am := stack.AccountManager()
+	ks := am.Backends(keystore.KeyStoreType)[0].(*keystore.KeyStore)
+
+	return decryptStoreAccount(ks, account, utils.MakePasswordList(ctx))
+}
+
 func decryptStoreAccount(ks *keystore.KeyStore, account string, passwords []string) *ecdsa.PrivateKey {
 	var a accounts.Account
 	var err error
@@ -0,0 +1,77 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// returns the only argument of the command as a hash
+func hashArg(ctx *cli.Context, what string) []byte {
+	args := ctx.Args()
+	if len(args) != 1 {
+		utils.Fatalf("Expected the %s as the only argument", what)
+	}
+	hash := common.FromHex(args[0])
+	if len(hash) < common.HashLength {
+		utils.Fatalf("Invalid %s %q", what, args[0])
+	}
+	return hash
+}
+
+func notarize(ctx *cli.Context) {
+	root := storage.Key(hashArg(ctx, "root hash"))
+	client := dialResourceNode(ctx)
+	defer client.Close()
+
+	var tx common.Hash
+	if err := client.Call(&tx, "bzz_notarize", root); err != nil {
+		utils.Fatalf("Cannot notarize the root hash: %v", err)
+	}
+	fmt.Println(tx.Hex())
+}
+
+func notarization(ctx *cli.Context) {
+	tx := common.BytesToHash(hashArg(ctx, "transaction hash"))
+	client := dialResourceNode(ctx)
+	defer client.Close()
+
+	var n api.Notarization
+	if err := client.Call(&n, "bzz_notarization", tx); err != nil {
+		utils.Fatalf("Cannot look up the notarization: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	fmt.Fprintf(w, "root hash\t%s\n", n.Root.Hex())
+	fmt.Fprintf(w, "sender\t%s\n", n.From.Hex())
+	if n.Block == 0 {
+		fmt.Fprintf(w, "block\tpending\n")
+	} else {
+		fmt.Fprintf(w, "block\t%d\n", n.Block)
+		fmt.Fprintf(w, "time\t%s\n", time.Unix(int64(n.Time), 0).UTC().Format(time.RFC3339))
+		fmt.Fprintf(w, "success\t%t\n", n.Success)
+	}
+	w.Flush()
+}
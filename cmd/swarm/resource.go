@@ -0,0 +1,235 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	ResourceIPCFlag = cli.StringFlag{
+		Name:  "ipc",
+		Usage: "IPC endpoint of the swarm node (default <datadir>/bzzd.ipc)",
+	}
+	ResourceNameFlag = cli.StringFlag{
+		Name:  "name",
+		Usage: "Name of the resource (e.g. feed.mydomain.eth)",
+	}
+	ResourceFrequencyFlag = cli.Uint64Flag{
+		Name:  "frequency",
+		Usage: "Number of blocks (or seconds with --time) between the update periods of the resource",
+	}
+	ResourceTimeFlag = cli.BoolFlag{
+		Name:  "time",
+		Usage: "Count the periods of the resource in seconds instead of blocks",
+	}
+	ResourceDataFlag = cli.StringFlag{
+		Name:  "data",
+		Usage: "Data of the update (use - to read from stdin)",
+	}
+	ResourceMultihashFlag = cli.StringFlag{
+		Name:  "multihash",
+		Usage: "Hex encoded multihash to publish as the update",
+	}
+	ResourcePeriodFlag = cli.UintFlag{
+		Name:  "period",
+		Usage: "Period of the update to look up (default the latest)",
+	}
+	ResourceVersionFlag = cli.UintFlag{
+		Name:  "version",
+		Usage: "Version of the update in --period to look up (default the latest)",
+	}
+	ResourceRefreshOffFlag = cli.BoolFlag{
+		Name:  "off",
+		Usage: "Stop refreshing the resource",
+	}
+)
+
+// connects to the admin RPC API of the node
+func dialResourceNode(ctx *cli.Context) *rpc.Client {
+	endpoint := ctx.String(ResourceIPCFlag.Name)
+	if endpoint == "" {
+		endpoint = filepath.Join(ctx.GlobalString(utils.DataDirFlag.Name), "bzzd.ipc")
+	}
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		utils.Fatalf("Cannot connect to the swarm node at %s, is it running? %v", endpoint, err)
+	}
+	return client
+}
+
+func resourceName(ctx *cli.Context) string {
+	name := ctx.String(ResourceNameFlag.Name)
+	if name == "" {
+		utils.Fatalf("Missing the name of the resource, use --%s", ResourceNameFlag.Name)
+	}
+	return name
+}
+
+func resourceCreate(ctx *cli.Context) {
+	name := resourceName(ctx)
+	frequency := ctx.Uint64(ResourceFrequencyFlag.Name)
+	if frequency == 0 {
+		utils.Fatalf("Missing the frequency of the resource, use --%s", ResourceFrequencyFlag.Name)
+	}
+	client := dialResourceNode(ctx)
+	defer client.Close()
+
+	var key storage.Key
+	if err := client.Call(&key, "mru_create", name, frequency, ctx.Bool(ResourceTimeFlag.Name)); err != nil {
+		utils.Fatalf("Cannot create the resource: %v", err)
+	}
+	fmt.Println(key.Hex())
+}
+
+func resourceUpdate(ctx *cli.Context) {
+	name := resourceName(ctx)
+	var data []byte
+	var multihash bool
+	switch {
+	case ctx.IsSet(ResourceDataFlag.Name) && ctx.IsSet(ResourceMultihashFlag.Name):
+		utils.Fatalf("Use either --%s or --%s", ResourceDataFlag.Name, ResourceMultihashFlag.Name)
+	case ctx.String(ResourceDataFlag.Name) == "-":
+		var err error
+		if data, err = ioutil.ReadAll(os.Stdin); err != nil {
+			utils.Fatalf("Cannot read the data from stdin: %v", err)
+		}
+	case ctx.IsSet(ResourceDataFlag.Name):
+		data = []byte(ctx.String(ResourceDataFlag.Name))
+	case ctx.IsSet(ResourceMultihashFlag.Name):
+		data, multihash = common.FromHex(ctx.String(ResourceMultihashFlag.Name)), true
+	default:
+		utils.Fatalf("Missing the data of the update, use --%s or --%s", ResourceDataFlag.Name, ResourceMultihashFlag.Name)
+	}
+	client := dialResourceNode(ctx)
+	defer client.Close()
+
+	var result api.ResourceUpdateResult
+	if err := client.Call(&result, "mru_update", name, hexutil.Bytes(data), multihash); err != nil {
+		utils.Fatalf("Cannot update the resource: %v", err)
+	}
+	fmt.Printf("%s (period %d, version %d)\n", result.Key.Hex(), result.Period, result.Version)
+}
+
+// returns the root key argument and the period and version flags of a lookup
+func resourceLookupArgs(ctx *cli.Context) (storage.Key, uint32, uint32) {
+	args := ctx.Args()
+	if len(args) != 1 {
+		utils.Fatalf("Expected the root key of the resource as the only argument")
+	}
+	key := storage.Key(common.FromHex(args[0]))
+	if len(key) != common.HashLength {
+		utils.Fatalf("Invalid root key %q", args[0])
+	}
+	period, version := uint32(ctx.Uint(ResourcePeriodFlag.Name)), uint32(ctx.Uint(ResourceVersionFlag.Name))
+	if version != 0 && period == 0 {
+		utils.Fatalf("--%s needs --%s", ResourceVersionFlag.Name, ResourcePeriodFlag.Name)
+	}
+	return key, period, version
+}
+
+// looks up the update given by the arguments, the latest one if no period is given
+func resourceLookup(ctx *cli.Context) *api.ResourceLookupResult {
+	key, period, version := resourceLookupArgs(ctx)
+	client := dialResourceNode(ctx)
+	defer client.Close()
+
+	var result api.ResourceLookupResult
+	var err error
+	if period == 0 {
+		err = client.Call(&result, "mru_lookupLatest", key)
+	} else {
+		err = client.Call(&result, "mru_lookupVersion", key, period, version)
+	}
+	if err != nil {
+		utils.Fatalf("Cannot look up the resource: %v", err)
+	}
+	return &result
+}
+
+func resourceInfo(ctx *cli.Context) {
+	info := resourceLookup(ctx)
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	fmt.Fprintf(w, "name\t%s\n", info.Name)
+	fmt.Fprintf(w, "root key\t%s\n", info.RootKey.Hex())
+	fmt.Fprintf(w, "period\t%d\n", info.Period)
+	fmt.Fprintf(w, "version\t%d\n", info.Version)
+	fmt.Fprintf(w, "multihash\t%t\n", info.Multihash)
+	fmt.Fprintf(w, "size\t%d\n", info.Size)
+	if !info.ModTime.IsZero() {
+		fmt.Fprintf(w, "created\t%s\n", info.ModTime.Format(time.RFC3339))
+	}
+	if info.Signer != (common.Address{}) {
+		fmt.Fprintf(w, "signer\t%s\n", info.Signer.Hex())
+	}
+	w.Flush()
+}
+
+func resourceConflicts(ctx *cli.Context) {
+	key, period, _ := resourceLookupArgs(ctx)
+	if period == 0 {
+		utils.Fatalf("--%s is required", ResourcePeriodFlag.Name)
+	}
+	client := dialResourceNode(ctx)
+	defer client.Close()
+
+	var report storage.ResourceConflictReport
+	if err := client.Call(&report, "mru_conflicts", key, period); err != nil {
+		utils.Fatalf("Cannot detect the conflicts of the resource: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 1, 2, 2, ' ', 0)
+	fmt.Fprintf(w, "version\tkey\tsigner\tcreated\trevoked\n")
+	for _, v := range report.Versions {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\n", v.Version, v.Key.Hex(), v.Signer.Hex(), v.ModTime.Format(time.RFC3339), v.Revoked)
+	}
+	w.Flush()
+	for _, c := range report.Conflicts {
+		fmt.Printf("version %d seen with data %s and %s at %s\n", c.Version, c.DataHash.Hex(), c.OtherHash.Hex(), c.Seen.Format(time.RFC3339))
+	}
+	if report.Diverged {
+		fmt.Printf("period %d diverged, %d conflicts\n", report.Period, len(report.Conflicts))
+	} else {
+		fmt.Printf("period %d has not diverged\n", report.Period)
+	}
+}
+
+func resourceRefresh(ctx *cli.Context) {
+	name := resourceName(ctx)
+	client := dialResourceNode(ctx)
+	defer client.Close()
+
+	if err := client.Call(nil, "mru_refresh", name, !ctx.Bool(ResourceRefreshOffFlag.Name)); err != nil {
+		utils.Fatalf("Cannot set the refresh of the resource: %v", err)
+	}
+}
+
+func resourceGet(ctx *cli.Context) {
+	os.Stdout.Write(resourceLookup(ctx).Data)
+}
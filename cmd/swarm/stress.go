@@ -0,0 +1,102 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of go-ethereum.
+//
+// go-ethereum is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-ethereum is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-ethereum. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"github.com/ethereum/go-ethereum/swarm/testutil"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	StressResourcesFlag = cli.IntFlag{
+		Name:  "resources",
+		Usage: "Number of resources to create",
+		Value: 100,
+	}
+	StressUpdatesFlag = cli.IntFlag{
+		Name:  "updates",
+		Usage: "Number of updates of each resource",
+		Value: 10,
+	}
+	StressWorkersFlag = cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of goroutines publishing updates",
+		Value: 8,
+	}
+	StressRateFlag = cli.Float64Flag{
+		Name:  "rate",
+		Usage: "Updates per second over all workers (0 for no limit)",
+	}
+	StressSizeFlag = cli.IntFlag{
+		Name:  "size",
+		Usage: "Size of the update data in bytes",
+		Value: 64,
+	}
+	StressSeedFlag = cli.Int64Flag{
+		Name:  "seed",
+		Usage: "Seed of the resource names and the update data",
+		Value: 1,
+	}
+)
+
+// runs a stress test of the resource subsystem against a resource handler
+// on a temporary chunk store
+func stressResources(ctx *cli.Context) {
+	if len(ctx.Args()) > 0 {
+		utils.Fatalf("Too many arguments - usage 'swarm resource-stress [options]'")
+	}
+	dir, err := ioutil.TempDir("", "swarm-resource-stress")
+	if err != nil {
+		utils.Fatalf("Cannot create the temporary chunk store: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		utils.Fatalf("Cannot create the signing key: %v", err)
+	}
+	rh, err := storage.NewTestResourceHandler(dir, &storage.ResourceHandlerParams{
+		Signer: &storage.GenericResourceSigner{PrivKey: privkey},
+	})
+	if err != nil {
+		utils.Fatalf("Cannot create the resource handler: %v", err)
+	}
+	defer rh.Close()
+
+	params := testutil.NewResourceStressParams()
+	params.Resources = ctx.Int(StressResourcesFlag.Name)
+	params.Updates = ctx.Int(StressUpdatesFlag.Name)
+	params.Workers = ctx.Int(StressWorkersFlag.Name)
+	params.Rate = ctx.Float64(StressRateFlag.Name)
+	params.DataSize = ctx.Int(StressSizeFlag.Name)
+	params.Seed = ctx.Int64(StressSeedFlag.Name)
+	report, err := testutil.RunResourceStress(context.Background(), &testutil.ResourceHandlerStressBackend{Handler: rh}, params)
+	if err != nil {
+		utils.Fatalf("Stress run failed: %v", err)
+	}
+	fmt.Println(report)
+	if !report.OK() {
+		utils.Fatalf("%d failures and %d inconsistent reads, the first: %v", report.Failures, report.Inconsistent, report.FirstError)
+	}
+}
@@ -47,8 +47,13 @@ func upload(ctx *cli.Context) {
 		mimeType     = ctx.GlobalString(SwarmUploadMimeType.Name)
 		client       = swarm.NewClient(bzzapi)
 		toEncrypt    = ctx.Bool(SwarmEncryptedFlag.Name)
+		reproducible = ctx.Bool(SwarmReproducibleFlag.Name)
 		file         string
 	)
+	if reproducible && toEncrypt {
+		utils.Fatalf("Encrypted uploads cannot be reproducible")
+	}
+	client.Reproducible = reproducible
 
 	if len(args) != 1 {
 		if fromStdin {
@@ -107,7 +112,9 @@ func upload(ctx *cli.Context) {
 				return "", fmt.Errorf("error opening file: %s", err)
 			}
 			defer f.Close()
-			if mimeType == "" {
+			if mimeType == "" && reproducible {
+				mimeType = swarm.ReproducibleContentType(file)
+			} else if mimeType == "" {
 				mimeType = detectMimeType(file)
 			}
 			f.ContentType = mimeType
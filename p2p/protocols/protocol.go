@@ -37,6 +37,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 // error codes used by this  protocol scheme
@@ -126,6 +127,11 @@ type Spec struct {
 	// each message must have a single unique data type
 	Messages []interface{}
 
+	// Record is called with the code and the RLP encoded payload of every
+	// message sent with the protocol if set, such as to record the messages
+	// exchanged in a simulation as fuzzing corpus
+	Record func(code uint64, payload []byte)
+
 	initOnce sync.Once
 	codes    map[reflect.Type]uint64
 	types    map[uint64]reflect.Type
@@ -225,6 +231,11 @@ func (p *Peer) Send(msg interface{}) error {
 	if !found {
 		return errorf(ErrInvalidMsgType, "%v", code)
 	}
+	if p.spec.Record != nil {
+		if payload, err := rlp.EncodeToBytes(msg); err == nil {
+			p.spec.Record(code, payload)
+		}
+	}
 	return p2p.Send(p.rw, code, msg)
 }
 
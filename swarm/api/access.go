@@ -0,0 +1,427 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// AccessTypePK is the type of the grants to the public key of a grantee
+	AccessTypePK = "pk"
+	// AccessTypePass is the type of the grants to a passphrase
+	AccessTypePass = "pass"
+
+	// AccessScryptN and AccessScryptP are the scrypt parameters the keys of
+	// passphrase grants are derived with. Documents with other parameters
+	// are rejected, they would let anyone make the node derive keys of
+	// arbitrary cost.
+	AccessScryptN = 1 << 15
+	AccessScryptP = 1
+
+	accessSaltLength  = 32
+	accessNonceLength = 12
+
+	// access documents are small, anything larger is not one
+	accessMaxDocumentSize = 1 << 20
+)
+
+var (
+	// ErrAccessDenied is returned when none of the credentials unlocks a grant
+	// of the access document
+	ErrAccessDenied = errors.New("access denied")
+
+	accessGrantCount  = metrics.NewRegisteredCounter("api.access.grant.count", nil)
+	accessUnlockCount = metrics.NewRegisteredCounter("api.access.unlock.count", nil)
+	accessDeniedCount = metrics.NewRegisteredCounter("api.access.denied.count", nil)
+)
+
+// AccessGrant is a grant blob, which unlocks the secret of an access document
+// for a single grantee
+type AccessGrant struct {
+	Type   string        `json:"type"`   // AccessTypePK or AccessTypePass
+	Lookup hexutil.Bytes `json:"lookup"` // derived from the credential of the grantee, to find the grant without trying all
+	Sealed hexutil.Bytes `json:"sealed"` // the secret, sealed with the access key of the grantee
+}
+
+// AccessDocument holds the grants of a secret, which is either the reference
+// of encrypted content, the storage key and the decryption key of an
+// encrypted manifest, or the secret of a private mutable resource.
+//
+// Documents of a private resource must be signed by the owner of the
+// resource, see Sign, since anyone can upload a document naming any resource.
+//
+// The grants to public keys are sealed with a key derived from the shared
+// secret of the grantee and the publisher key of the document, which is
+// created with the document, the grants to passphrases with a key derived
+// from the passphrase with scrypt. Either way the grant is found by a lookup
+// derived from the same key, the document does not tell who the grantees are.
+type AccessDocument struct {
+	Resource  string         `json:"resource,omitempty"`  // name of the private resource the secret belongs to, empty for encrypted content
+	Publisher hexutil.Bytes  `json:"publisher,omitempty"` // compressed public key the grants to public keys are sealed with
+	Salt      hexutil.Bytes  `json:"salt"`
+	ScryptN   int            `json:"scryptN,omitempty"`
+	ScryptP   int            `json:"scryptP,omitempty"`
+	Grants    []*AccessGrant `json:"grants"`
+	Signature hexutil.Bytes  `json:"signature,omitempty"` // of the owner of the resource over the rest of the document, see Digest
+
+	publisher *ecdsa.PrivateKey // only known to the creator of the document
+}
+
+// AccessCredential is a credential presented to unlock an access document,
+// a key of a grantee, a passphrase or both
+type AccessCredential struct {
+	Key        *ecdsa.PrivateKey
+	Passphrase string
+}
+
+// NewAccessDocument creates an empty access document of the secret of the
+// given resource, or of encrypted content if resource is empty
+func NewAccessDocument(resource string) (*AccessDocument, error) {
+	publisher, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, accessSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return &AccessDocument{
+		Resource:  resource,
+		Publisher: crypto.CompressPubkey(&publisher.PublicKey),
+		Salt:      salt,
+		ScryptN:   AccessScryptN,
+		ScryptP:   AccessScryptP,
+		publisher: publisher,
+	}, nil
+}
+
+// GrantKey adds a grant of the secret to the public key of a grantee. Only
+// the creator of the document can add such grants.
+func (d *AccessDocument) GrantKey(grantee *ecdsa.PublicKey, secret []byte) error {
+	if d.publisher == nil {
+		return errors.New("grants to public keys can only be added by the creator of the document")
+	}
+	return d.grant(AccessTypePK, d.sharedKey(d.publisher, grantee), secret)
+}
+
+// GrantPassphrase adds a grant of the secret to a passphrase
+func (d *AccessDocument) GrantPassphrase(passphrase string, secret []byte) error {
+	if passphrase == "" {
+		return errors.New("empty passphrase")
+	}
+	key, err := d.passphraseKey(passphrase)
+	if err != nil {
+		return err
+	}
+	return d.grant(AccessTypePass, key, secret)
+}
+
+// Unlock returns the secret of the document if one of the credentials
+// unlocks one of its grants, ErrAccessDenied otherwise
+func (d *AccessDocument) Unlock(creds ...*AccessCredential) ([]byte, error) {
+	for _, cred := range creds {
+		if cred == nil {
+			continue
+		}
+		if cred.Key != nil && len(d.Publisher) > 0 {
+			publisher, err := crypto.DecompressPubkey(d.Publisher)
+			if err != nil {
+				return nil, fmt.Errorf("invalid publisher key: %v", err)
+			}
+			if secret, ok := d.unlock(AccessTypePK, d.sharedKey(cred.Key, publisher)); ok {
+				accessUnlockCount.Inc(1)
+				return secret, nil
+			}
+		}
+		if cred.Passphrase != "" && d.hasGrants(AccessTypePass) {
+			key, err := d.passphraseKey(cred.Passphrase)
+			if err != nil {
+				return nil, err
+			}
+			if secret, ok := d.unlock(AccessTypePass, key); ok {
+				accessUnlockCount.Inc(1)
+				return secret, nil
+			}
+		}
+	}
+	accessDeniedCount.Inc(1)
+	return nil, ErrAccessDenied
+}
+
+// Digest returns the hash of the document without its signature, which the
+// owner of the resource signs
+func (d *AccessDocument) Digest() (common.Hash, error) {
+	unsigned := *d
+	unsigned.Signature = nil
+	data, err := json.Marshal(&unsigned)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+// Sign signs the document with the signer of the owner of the resource. The
+// grants cannot be changed afterwards without signing again.
+func (d *AccessDocument) Sign(signer storage.ResourceSigner) error {
+	digest, err := d.Digest()
+	if err != nil {
+		return err
+	}
+	signature, err := signer.Sign(digest)
+	if err != nil {
+		return err
+	}
+	d.Signature = signature[:]
+	return nil
+}
+
+// returns the address of the signer of the document
+func (d *AccessDocument) signer() (common.Address, error) {
+	if len(d.Signature) != len(storage.Signature{}) {
+		return common.Address{}, errors.New("access document is not signed")
+	}
+	digest, err := d.Digest()
+	if err != nil {
+		return common.Address{}, err
+	}
+	pub, err := crypto.SigToPub(digest[:], d.Signature)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid access document signature: %v", err)
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// checks that the scrypt parameters are the ones of AccessScryptN and
+// AccessScryptP
+func (d *AccessDocument) checkParams() error {
+	if d.ScryptN != AccessScryptN || d.ScryptP != AccessScryptP {
+		return fmt.Errorf("unsupported scrypt parameters N=%d, P=%d", d.ScryptN, d.ScryptP)
+	}
+	return nil
+}
+
+// adds a grant of the secret sealed with the access key derived from key
+func (d *AccessDocument) grant(typ string, key []byte, secret []byte) error {
+	aead, err := newAccessCipher(d.accessKey(key))
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, accessNonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	d.Grants = append(d.Grants, &AccessGrant{
+		Type:   typ,
+		Lookup: d.lookup(key),
+		Sealed: aead.Seal(nonce, nonce, secret, nil),
+	})
+	accessGrantCount.Inc(1)
+	return nil
+}
+
+// returns the secret of the grant of the given type found by key, if any
+func (d *AccessDocument) unlock(typ string, key []byte) ([]byte, bool) {
+	lookup := d.lookup(key)
+	for _, g := range d.Grants {
+		if g.Type != typ || !bytes.Equal(g.Lookup, lookup) || len(g.Sealed) < accessNonceLength {
+			continue
+		}
+		aead, err := newAccessCipher(d.accessKey(key))
+		if err != nil {
+			return nil, false
+		}
+		secret, err := aead.Open(nil, g.Sealed[:accessNonceLength], g.Sealed[accessNonceLength:], nil)
+		if err != nil {
+			log.Debug("access grant found but not unlocked", "type", typ, "err", err)
+			continue
+		}
+		return secret, true
+	}
+	return nil, false
+}
+
+func (d *AccessDocument) hasGrants(typ string) bool {
+	for _, g := range d.Grants {
+		if g.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// the shared secret of the ECDH of a private and a public key, the same for
+// the publisher and the grantee
+func (d *AccessDocument) sharedKey(priv *ecdsa.PrivateKey, pub *ecdsa.PublicKey) []byte {
+	x, _ := crypto.S256().ScalarMult(pub.X, pub.Y, math.PaddedBigBytes(priv.D, 32))
+	return math.PaddedBigBytes(x, 32)
+}
+
+func (d *AccessDocument) passphraseKey(passphrase string) ([]byte, error) {
+	if err := d.checkParams(); err != nil {
+		return nil, err
+	}
+	return scrypt.Key([]byte(passphrase), d.Salt, d.ScryptN, 8, d.ScryptP, 32)
+}
+
+func (d *AccessDocument) lookup(key []byte) []byte {
+	return crypto.Keccak256(key, d.Salt, []byte{0})
+}
+
+func (d *AccessDocument) accessKey(key []byte) []byte {
+	return crypto.Keccak256(key, d.Salt, []byte{1})
+}
+
+func newAccessCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SetAccessKey sets the key of the node, which unlocks the grants to the
+// public key of the node for any request, nil if none. Anything granted to the
+// node is then served to anyone, so it must only be set on nodes which are
+// not public gateways.
+func (self *Api) SetAccessKey(key *ecdsa.PrivateKey) {
+	self.accessKey = key
+}
+
+// PutAccess stores the access document, and returns the key of it which
+// readers resolve the grants with
+func (self *Api) PutAccess(doc *AccessDocument) (storage.Key, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	key, wait, err := self.dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		return nil, err
+	}
+	wait()
+	return key, nil
+}
+
+// GetAccess retrieves the access document stored with the key
+func (self *Api) GetAccess(key storage.Key) (*AccessDocument, error) {
+	reader, _ := self.dpa.Retrieve(key)
+	size, err := reader.Size(nil)
+	if err != nil {
+		return nil, fmt.Errorf("access document %s not found: %v", key, err)
+	}
+	if size > accessMaxDocumentSize {
+		return nil, fmt.Errorf("access document %s of %d bytes exceeds the %d byte limit", key, size, accessMaxDocumentSize)
+	}
+	data := make([]byte, size)
+	if n, err := reader.ReadAt(data, 0); int64(n) < size {
+		return nil, fmt.Errorf("cannot read access document %s: %v", key, err)
+	}
+	doc := &AccessDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("access document %s is malformed: %v", key, err)
+	}
+	if err := doc.checkParams(); err != nil {
+		return nil, fmt.Errorf("access document %s: %v", key, err)
+	}
+	return doc, nil
+}
+
+// SignAccess signs the access document of a private resource with the signer
+// of the resource on the node, which must be its owner
+func (self *Api) SignAccess(ctx context.Context, doc *AccessDocument) error {
+	if doc.Resource == "" {
+		return errors.New("access documents of encrypted content are not signed")
+	} else if self.resource == nil {
+		return errors.New("mutable resources are not supported")
+	}
+	digest, err := doc.Digest()
+	if err != nil {
+		return err
+	}
+	signature, err := self.resource.SignFor(ctx, doc.Resource, digest)
+	if err != nil {
+		return err
+	}
+	doc.Signature = signature[:]
+	return self.checkAccessOwner(doc)
+}
+
+// checks that the access document of a private resource is signed by the
+// owner of the resource
+func (self *Api) checkAccessOwner(doc *AccessDocument) error {
+	if self.resource == nil {
+		return errors.New("mutable resources are not supported")
+	}
+	addr, err := doc.signer()
+	if err != nil {
+		return err
+	}
+	ok, err := self.resource.IsOwner(doc.Resource, addr)
+	if err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("access document of %s is not signed by its owner", doc.Resource)
+	}
+	return nil
+}
+
+// ResolveAccess unlocks the access document stored with the key with the
+// given credentials, or with the key of the node, see SetAccessKey. It
+// returns the document and its secret.
+//
+// The secret is only returned to the caller, it is never set on the node,
+// since anyone can upload an access document. The documents of private
+// resources must be signed by the owner of the resource, the secret of such
+// a resource decrypts the updates looked up for the caller only, see
+// ResourceLookupPrivate.
+func (self *Api) ResolveAccess(key storage.Key, creds ...*AccessCredential) (*AccessDocument, []byte, error) {
+	doc, err := self.GetAccess(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if doc.Resource != "" {
+		if err := self.checkAccessOwner(doc); err != nil {
+			return nil, nil, err
+		}
+	}
+	if self.accessKey != nil {
+		creds = append(creds, &AccessCredential{Key: self.accessKey})
+	}
+	secret, err := doc.Unlock(creds...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, secret, nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// TestAccessDocument checks that the grants of an access document are only
+// unlocked by the key or passphrase of the grantees, also once the document
+// is stored and retrieved
+func TestAccessDocument(t *testing.T) {
+	testApi(t, func(api *Api, _ bool) {
+		secret := bytes.Repeat([]byte{0x42}, 64)
+		grantee, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := NewAccessDocument("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := doc.GrantKey(&grantee.PublicKey, secret); err != nil {
+			t.Fatal(err)
+		}
+		if err := doc.GrantPassphrase("open sesame", secret); err != nil {
+			t.Fatal(err)
+		}
+		key, err := api.PutAccess(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range []struct {
+			name string
+			cred *AccessCredential
+			err  error
+		}{
+			{"grantee key", &AccessCredential{Key: grantee}, nil},
+			{"passphrase", &AccessCredential{Passphrase: "open sesame"}, nil},
+			{"other key", &AccessCredential{Key: other}, ErrAccessDenied},
+			{"wrong passphrase", &AccessCredential{Passphrase: "open barley"}, ErrAccessDenied},
+		} {
+			_, got, err := api.ResolveAccess(key, c.cred)
+			if err != c.err {
+				t.Fatalf("%s: expected error %v, got %v", c.name, c.err, err)
+			}
+			if err == nil && !bytes.Equal(got, secret) {
+				t.Fatalf("%s: expected secret %x, got %x", c.name, secret, got)
+			}
+		}
+		if _, _, err := api.ResolveAccess(key); err != ErrAccessDenied {
+			t.Fatalf("expected %v without credentials, got %v", ErrAccessDenied, err)
+		}
+
+		// the key of the node unlocks the grants to it without credentials
+		api.SetAccessKey(grantee)
+		defer api.SetAccessKey(nil)
+		if _, got, err := api.ResolveAccess(key); err != nil || !bytes.Equal(got, secret) {
+			t.Fatalf("expected the node key to unlock %x, got %x (%v)", secret, got, err)
+		}
+
+		// only the creator of the document grants access to keys
+		stored, err := api.GetAccess(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stored.GrantKey(&other.PublicKey, secret); err == nil {
+			t.Fatal("expected a retrieved document not to grant access to keys")
+		}
+	})
+}
+
+// TestAccessDocumentChecks checks that documents with other scrypt parameters
+// are rejected, and that the documents of private resources are only resolved
+// if the owner of the resource signed them
+func TestAccessDocumentChecks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-access")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	owner, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, err := storage.NewTestResourceHandler(dir, &storage.ResourceHandlerParams{
+		Signer: &storage.GenericResourceSigner{PrivKey: owner},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+	dpa, err := storage.NewLocalDPA(dir, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewApi(dpa, nil, rh)
+
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	cred := &AccessCredential{Passphrase: "open sesame"}
+	put := func(doc *AccessDocument) storage.Key {
+		if err := doc.GrantPassphrase(cred.Passphrase, secret); err != nil {
+			t.Fatal(err)
+		}
+		key, err := api.PutAccess(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	// documents of costly scrypt parameters are not unlocked
+	doc, err := NewAccessDocument("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc.ScryptN = 1 << 28
+	if err := doc.GrantPassphrase(cred.Passphrase, secret); err == nil {
+		t.Fatal("expected a grant with other scrypt parameters to fail")
+	}
+	doc.ScryptN = AccessScryptN
+	key := put(doc)
+	doc.ScryptN = 1 << 28
+	forged, err := api.PutAccess(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := api.ResolveAccess(forged, cred); err == nil {
+		t.Fatal("expected a document with other scrypt parameters to be rejected")
+	}
+	if _, got, err := api.ResolveAccess(key, cred); err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("expected secret %x, got %x (%v)", secret, got, err)
+	}
+
+	// the documents of a topic feed are signed by the user of the feed
+	name := storage.NewTopic([]byte("foo")).Name(crypto.PubkeyToAddress(owner.PublicKey))
+	for _, c := range []struct {
+		desc   string
+		signer *ecdsa.PrivateKey
+		ok     bool
+	}{
+		{"unsigned", nil, false},
+		{"signed by other", other, false},
+		{"signed by owner", owner, true},
+	} {
+		doc, err := NewAccessDocument(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := doc.GrantPassphrase(cred.Passphrase, secret); err != nil {
+			t.Fatal(err)
+		}
+		if c.signer != nil {
+			if err := doc.Sign(&storage.GenericResourceSigner{PrivKey: c.signer}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		key, err := api.PutAccess(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, got, err := api.ResolveAccess(key, cred)
+		if c.ok && (err != nil || !bytes.Equal(got, secret)) {
+			t.Fatalf("%s: expected secret %x, got %x (%v)", c.desc, secret, got, err)
+		} else if !c.ok && err == nil {
+			t.Fatalf("%s: expected the document to be rejected", c.desc)
+		}
+	}
+
+	// the node signs the documents of the resources it owns
+	doc, err = NewAccessDocument(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.SignAccess(context.Background(), doc); err != nil {
+		t.Fatal(err)
+	}
+	doc, err = NewAccessDocument(storage.NewTopic([]byte("foo")).Name(crypto.PubkeyToAddress(other.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := api.SignAccess(context.Background(), doc); err == nil {
+		t.Fatal("expected signing the document of a resource of another owner to fail")
+	}
+}
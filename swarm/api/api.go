@@ -18,6 +18,7 @@ package api
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"fmt"
 	"io"
 	"math/big"
@@ -35,6 +36,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/events"
 	"github.com/ethereum/go-ethereum/swarm/multihash"
 	"github.com/ethereum/go-ethereum/swarm/storage"
 )
@@ -171,6 +173,56 @@ func (m *MultiResolver) ValidateOwner(name string, address common.Address) (bool
 	return false, err
 }
 
+// UpdaterValidator is implemented by resolvers which can query the contracts
+// authorizing the updaters of mutable resources
+type UpdaterValidator interface {
+	IsAuthorized(contract common.Address, node [32]byte, address common.Address) (bool, error)
+}
+
+// ValidateUpdater asks the access control contract of a mutable resource if
+// the address may update the resource with the given name
+func (m *MultiResolver) ValidateUpdater(contract common.Address, name string, address common.Address) (bool, error) {
+	rs, err := m.getResolveValidator(name)
+	if err != nil {
+		return false, err
+	}
+	err = fmt.Errorf("no resolver can query the access control contract of %s", name)
+	for _, r := range rs {
+		uv, ok := r.(UpdaterValidator)
+		if !ok {
+			continue
+		}
+		var authorized bool
+		authorized, err = uv.IsAuthorized(contract, m.nameHash(name), address)
+		// we hide the error if it is not for the last resolver we check
+		if err == nil {
+			return authorized, nil
+		}
+	}
+	return false, err
+}
+
+// ContentSetter is implemented by resolvers which can set the content records
+// of names
+type ContentSetter interface {
+	SetContentHash(name string, hash common.Hash) (*types.Transaction, error)
+}
+
+// SetContentHash submits the transaction setting the content record of the
+// name with the first resolver of its TLD which can set content records
+func (m *MultiResolver) SetContentHash(name string, hash common.Hash) (*types.Transaction, error) {
+	rs, err := m.getResolveValidator(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		if cs, ok := r.(ContentSetter); ok {
+			return cs.SetContentHash(name, hash)
+		}
+	}
+	return nil, fmt.Errorf("no resolver can set the content record of %s", name)
+}
+
 func (m *MultiResolver) HeaderByNumber(ctx context.Context, name string, blockNr *big.Int) (*types.Header, error) {
 	rs, err := m.getResolveValidator(name)
 	if err != nil {
@@ -207,6 +259,10 @@ func (m *MultiResolver) SetNameHash(nameHash func(string) common.Hash) {
 	m.nameHash = nameHash
 }
 
+// DefaultResourceNesting is how deep Get resolves resources referencing other
+// resources by default, see Api.SetResourceNesting
+const DefaultResourceNesting = 4
+
 /*
 Api implements webserver/file system related content storage and retrieval
 on top of the dpa
@@ -216,18 +272,51 @@ type Api struct {
 	resource *storage.ResourceHandler
 	dpa      *storage.DPA
 	dns      Resolver
+	ipfs     *IPFSBridge      // optional bridge serving ipfs content referenced by resources
+	managed  *ManagedSigners  // optional publisher keys kept on behalf of users
+	notary   *Notary          // optional notary recording root hashes on the chain
+	search   *SearchIndex     // optional full-text index of the content of the node
+	events   *events.Exporter // optional export of the events of the node
+
+	gatewayKeys *GatewayKeys      // optional API keys required on writes to the gateway
+	accessKey   *ecdsa.PrivateKey // optional key of the node unlocking access grants, see ResolveAccess
+
+	resourceNesting int // resources referenced by resources are resolved this deep, see SetResourceNesting
 }
 
 //the api constructor initialises
 func NewApi(dpa *storage.DPA, dns Resolver, resourceHandler *storage.ResourceHandler) (self *Api) {
 	self = &Api{
-		dpa:      dpa,
-		dns:      dns,
-		resource: resourceHandler,
+		dpa:             dpa,
+		dns:             dns,
+		resource:        resourceHandler,
+		resourceNesting: DefaultResourceNesting,
 	}
 	return
 }
 
+// SetResourceNesting sets how deep Get resolves resources whose latest update
+// references another resource, either by the multihash of its metadata chunk
+// or by a manifest whose entry is a resource. Deeper references are served
+// with status 508 Loop Detected. The default is used if depth is not positive.
+func (self *Api) SetResourceNesting(depth int) {
+	if depth <= 0 {
+		depth = DefaultResourceNesting
+	}
+	self.resourceNesting = depth
+}
+
+// SetEvents sets the exporter the uploads through the api are published to
+func (self *Api) SetEvents(exporter *events.Exporter) {
+	self.events = exporter
+}
+
+// Events returns the exporter of the events of the node, nil if events are
+// not exported. The nil exporter drops the events published to it.
+func (self *Api) Events() *events.Exporter {
+	return self.events
+}
+
 // to be used only in TEST
 func (self *Api) Upload(uploadDir, index string, toEncrypt bool) (hash string, err error) {
 	fs := NewFileSystem(self)
@@ -245,6 +334,12 @@ func (self *Api) Store(data io.Reader, size int64, toEncrypt bool) (key storage.
 	return self.dpa.Store(data, size, toEncrypt)
 }
 
+// StoreConvergent stores the data with convergent encryption, see storage.NewConvergentHasherStore
+func (self *Api) StoreConvergent(data io.Reader, size int64, secret []byte) (key storage.Key, wait func(), err error) {
+	log.Debug("api.store.convergent", "size", size)
+	return self.dpa.StoreConvergent(data, size, secret)
+}
+
 type ErrResolve error
 
 // DNS Resolver
@@ -311,7 +406,21 @@ func (self *Api) Put(content, contentType string, toEncrypt bool) (k storage.Key
 // to resolve basePath to content using dpa retrieve
 // it returns a section reader, mimeType, status, the key of the actual content and an error
 func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
-	log.Debug("api.get", "key", manifestKey, "path", path)
+	return self.get(manifestKey, path, 0)
+}
+
+// GetAt is like Get, but the resources on the way to the content are served
+// as they were at the block height or unix time on their period scale, so
+// that sites backed by resources can be browsed as they were, see
+// storage.ResourceHandler.LookupAt
+func (self *Api) GetAt(manifestKey storage.Key, path string, at uint64) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
+	return self.get(manifestKey, path, at)
+}
+
+// resolves the path like Get, looking up the latest updates of resources if
+// at is 0
+func (self *Api) get(manifestKey storage.Key, path string, at uint64) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
+	log.Debug("api.get", "key", manifestKey, "path", path, "at", at)
 	apiGetCount.Inc(1)
 	trie, err := loadManifest(self.dpa, manifestKey, nil)
 	if err != nil {
@@ -326,13 +435,21 @@ func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazyS
 
 	if entry != nil {
 		log.Debug("trie got entry", "key", manifestKey, "path", path, "entry.Hash", entry.Hash)
-		// we need to do some extra work if this is a mutable resource manifest
-		if entry.ContentType == ResourceContentType {
+		// we need to do some extra work if this is a mutable resource manifest,
+		// and again for each resource it references in turn
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		for depth := 0; entry.ContentType == ResourceContentType; depth++ {
+			if depth > self.resourceNesting {
+				apiGetInvalid.Inc(1)
+				status = http.StatusLoopDetected
+				err = fmt.Errorf("resources nested deeper than %d", self.resourceNesting)
+				log.Debug("resource nesting too deep", "key", manifestKey, "path", path, "depth", depth)
+				return reader, mimeType, status, nil, err
+			}
 
 			// get the resource root chunk key
-			log.Trace("resource type", "key", manifestKey, "hash", entry.Hash)
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
+			log.Trace("resource type", "key", manifestKey, "hash", entry.Hash, "depth", depth)
 			rsrc, err := self.resource.LoadResource(storage.Key(common.FromHex(entry.Hash)))
 			if err != nil {
 				apiGetNotFound.Inc(1)
@@ -341,8 +458,13 @@ func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazyS
 				return reader, mimeType, status, nil, err
 			}
 
-			// use this key to retrieve the latest update
-			rsrc, err = self.resource.LookupLatest(ctx, rsrc.NameHash(), true, &storage.ResourceLookupParams{})
+			// use this key to retrieve the latest update, or the one at the time asked for
+			var view *storage.ResourceView
+			if at == 0 {
+				view, err = self.resource.LookupLatest(ctx, rsrc.NameHash(), true, &storage.ResourceLookupParams{})
+			} else {
+				view, err = self.resource.LookupAt(ctx, rsrc.NameHash(), at, true, &storage.ResourceLookupParams{})
+			}
 			if err != nil {
 				apiGetNotFound.Inc(1)
 				status = http.StatusNotFound
@@ -352,10 +474,10 @@ func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazyS
 
 			// if it's multihash, we will transparently serve the content this multihash points to
 			// \TODO this resolve is rather expensive all in all, review to see if it can be achieved cheaper
-			if rsrc.Multihash {
+			if view.Multihash {
 
 				// get the data of the update
-				_, rsrcData, err := self.resource.GetContent(rsrc.NameHash().Hex())
+				rsrcData, err := view.Data()
 				if err != nil {
 					apiGetNotFound.Inc(1)
 					status = http.StatusNotFound
@@ -370,6 +492,15 @@ func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazyS
 					status = http.StatusInternalServerError
 					log.Warn(fmt.Sprintf("could not decode resource multihash: %v", err))
 					return reader, mimeType, status, nil, err
+				} else if decodedMultihash.Code == multihash.SHA2_256 {
+					// a sha2-256 multihash is an ipfs CIDv0
+					cid, err := multihash.NewCidV0(rsrcData)
+					if err != nil {
+						apiGetInvalid.Inc(1)
+						status = http.StatusUnprocessableEntity
+						return reader, mimeType, status, nil, err
+					}
+					return self.getIPFS(ctx, cid)
 				} else if decodedMultihash.Code != multihash.KECCAK_256 {
 					apiGetInvalid.Inc(1)
 					status = http.StatusUnprocessableEntity
@@ -379,6 +510,18 @@ func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazyS
 				manifestKey = storage.Key(decodedMultihash.Digest)
 				log.Trace("resource is multihash", "key", manifestKey)
 
+				// the multihash may reference the metadata chunk of another resource
+				if _, err := self.resource.LoadResource(manifestKey); err == nil {
+					log.Trace("resource multihash references a resource", "key", manifestKey)
+					entry = &manifestTrieEntry{
+						ManifestEntry: ManifestEntry{
+							Hash:        manifestKey.Hex(),
+							ContentType: ResourceContentType,
+						},
+					}
+					continue
+				}
+
 				// get the manifest the multihash digest points to
 				trie, err := loadManifest(self.dpa, manifestKey, nil)
 				if err != nil {
@@ -400,8 +543,21 @@ func (self *Api) Get(manifestKey storage.Key, path string) (reader storage.LazyS
 				}
 
 			} else {
+				// ipfs:// references are served through the ipfs bridge if it is enabled
+				if self.ipfs != nil {
+					rsrcData, err := view.Data()
+					if err == nil && isIPFSReference(rsrcData) {
+						cid, err := ParseIPFSReference(rsrcData)
+						if err != nil {
+							apiGetInvalid.Inc(1)
+							status = http.StatusUnprocessableEntity
+							return reader, mimeType, status, nil, err
+						}
+						return self.getIPFS(ctx, cid)
+					}
+				}
 				// data is returned verbatim since it's not a multihash
-				return rsrc, "application/octet-stream", http.StatusOK, nil, nil
+				return view.NewReader(), "application/octet-stream", http.StatusOK, nil, nil
 			}
 		}
 
@@ -653,31 +809,141 @@ func (self *Api) BuildDirectoryTree(mhash string, nameresolver bool) (key storag
 	return key, manifestEntryMap, nil
 }
 
+// ResourceUpdateInfo describes the update of a mutable resource returned by a lookup
+type ResourceUpdateInfo struct {
+	Name      string         `json:"name"`
+	RootKey   storage.Key    `json:"rootKey"`
+	Period    uint32         `json:"period"`
+	Version   uint32         `json:"version"`
+	Multihash bool           `json:"multihash"`
+	Size      int            `json:"size"`
+	ModTime   time.Time      `json:"modTime"` // creation time of the update, as claimed by its signer
+	Signer    common.Address `json:"signer"`  // zero if the update is not signed
+
+	view *storage.ResourceView // proves the update, see ResourceProof
+}
+
 // Look up mutable resource updates at specific periods and versions
 func (self *Api) ResourceLookup(ctx context.Context, key storage.Key, period uint32, version uint32, maxLookup *storage.ResourceLookupParams) (string, []byte, error) {
-	var err error
-	rsrc, err := self.resource.LoadResource(key)
+	info, data, err := self.ResourceLookupInfo(ctx, key, period, version, maxLookup)
 	if err != nil {
 		return "", nil, err
 	}
+	return info.Name, data, nil
+}
+
+// ResourceLookupInfo looks up mutable resource updates like ResourceLookup,
+// and also returns the period and version of the update found
+func (self *Api) ResourceLookupInfo(ctx context.Context, key storage.Key, period uint32, version uint32, maxLookup *storage.ResourceLookupParams) (*ResourceUpdateInfo, []byte, error) {
+	view, err := self.resourceLookup(ctx, key, period, version, maxLookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resourceInfo(key, view)
+}
+
+// ResourceLookupPrivate looks up the updates of a private mutable resource
+// like ResourceLookupInfo, and decrypts them with the secret, which is used
+// for this lookup only. The secret is typically unlocked from an access
+// document by the caller, see ResolveAccess.
+func (self *Api) ResourceLookupPrivate(ctx context.Context, key storage.Key, period uint32, version uint32, secret []byte, maxLookup *storage.ResourceLookupParams) (*ResourceUpdateInfo, []byte, error) {
+	view, err := self.resourceLookup(ctx, key, period, version, maxLookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	if view, err = view.Decrypt(secret); err != nil {
+		return nil, nil, err
+	}
+	return resourceInfo(key, view)
+}
+
+// looks up the update of the resource with the metadata chunk key at the
+// period and version, the latest ones if 0
+func (self *Api) resourceLookup(ctx context.Context, key storage.Key, period uint32, version uint32, maxLookup *storage.ResourceLookupParams) (*storage.ResourceView, error) {
+	rsrc, err := self.resource.LoadResource(key)
+	if err != nil {
+		return nil, err
+	}
 	if version != 0 {
 		if period == 0 {
-			return "", nil, storage.NewResourceError(storage.ErrInvalidValue, "Period can't be 0")
+			return nil, storage.NewResourceError(storage.ErrInvalidValue, "Period can't be 0")
 		}
-		_, err = self.resource.LookupVersion(ctx, rsrc.NameHash(), period, version, true, maxLookup)
+		return self.resource.LookupVersion(ctx, rsrc.NameHash(), period, version, true, maxLookup)
 	} else if period != 0 {
-		_, err = self.resource.LookupHistorical(ctx, rsrc.NameHash(), period, true, maxLookup)
-	} else {
-		_, err = self.resource.LookupLatest(ctx, rsrc.NameHash(), true, maxLookup)
+		return self.resource.LookupHistorical(ctx, rsrc.NameHash(), period, true, maxLookup)
 	}
+	return self.resource.LookupLatest(ctx, rsrc.NameHash(), true, maxLookup)
+}
+
+// ResourceLookupAt looks up the update of the resource with the metadata
+// chunk key as it was at the block height or unix time, on the period scale
+// of the resource, see storage.ResourceHandler.LookupAt
+func (self *Api) ResourceLookupAt(ctx context.Context, key storage.Key, at uint64, maxLookup *storage.ResourceLookupParams) (*ResourceUpdateInfo, []byte, error) {
+	rsrc, err := self.resource.LoadResource(key)
 	if err != nil {
-		return "", nil, err
+		return nil, nil, err
 	}
-	return self.resource.GetContent(rsrc.NameHash().Hex())
+	view, err := self.resource.LookupAt(ctx, rsrc.NameHash(), at, true, maxLookup)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resourceInfo(key, view)
+}
+
+// returns the information and the data of the update of the resource found by
+// a lookup
+func resourceInfo(key storage.Key, view *storage.ResourceView) (*ResourceUpdateInfo, []byte, error) {
+	data, err := view.Data()
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &ResourceUpdateInfo{
+		Name:      view.Name(),
+		RootKey:   key,
+		Period:    view.Period(),
+		Version:   view.Version(),
+		Multihash: view.Multihash,
+		Size:      len(data),
+		ModTime:   view.ModTime(),
+		Signer:    view.Signer(),
+		view:      view,
+	}
+	return info, data, nil
+}
+
+// ResourceProof returns the proof of the update of the resource described by
+// the info returned by a lookup, see storage.ResourceProof
+func (self *Api) ResourceProof(ctx context.Context, info *ResourceUpdateInfo) (*storage.ResourceProof, error) {
+	if info.view == nil {
+		return self.resource.Proof(ctx, storage.ResourceNameHash(info.Name))
+	}
+	return self.resource.ProofOf(ctx, info.view)
+}
+
+// ResourceConflicts reports whether the updates of the period of the resource
+// with the given metadata chunk key diverge, see
+// storage.ResourceHandler.DetectConflicts
+func (self *Api) ResourceConflicts(ctx context.Context, key storage.Key, period uint32) (*storage.ResourceConflictReport, error) {
+	rsrc, err := self.resource.LoadResource(key)
+	if err != nil {
+		return nil, err
+	}
+	return self.resource.DetectConflicts(ctx, rsrc.NameHash(), period)
 }
 
 func (self *Api) ResourceCreate(ctx context.Context, name string, frequency uint64) (storage.Key, error) {
-	key, _, err := self.resource.NewResource(ctx, name, frequency)
+	return self.ResourceCreateWithScheme(ctx, name, frequency, storage.BlockPeriods)
+}
+
+// ResourceCreateWithScheme creates a resource whose periods are counted in the given scheme
+func (self *Api) ResourceCreateWithScheme(ctx context.Context, name string, frequency uint64, scheme storage.ResourcePeriodScheme) (storage.Key, error) {
+	return self.ResourceCreateWithACL(ctx, name, frequency, scheme, nil)
+}
+
+// ResourceCreateWithACL creates a resource which can also be updated by the
+// addresses in the access control list
+func (self *Api) ResourceCreateWithACL(ctx context.Context, name string, frequency uint64, scheme storage.ResourcePeriodScheme, acl *storage.ResourceACL) (storage.Key, error) {
+	key, _, err := self.resource.NewResourceWithACL(ctx, name, frequency, scheme, acl)
 	if err != nil {
 		return nil, err
 	}
@@ -699,11 +965,30 @@ func (self *Api) resourceUpdate(ctx context.Context, name string, data []byte, m
 	} else {
 		key, err = self.resource.Update(ctx, name, data)
 	}
-	period, _ := self.resource.GetLastPeriod(name)
-	version, _ := self.resource.GetVersion(name)
+	nameHash := storage.ResourceNameHash(name).Hex()
+	period, _ := self.resource.GetLastPeriod(nameHash)
+	version, _ := self.resource.GetVersion(nameHash)
 	return key, period, version, err
 }
 
+// ResourceTombstone deletes a mutable resource, lookups of it fail with
+// storage.ErrGone afterwards
+func (self *Api) ResourceTombstone(ctx context.Context, name string) (storage.Key, error) {
+	return self.resource.UpdateTombstone(ctx, name)
+}
+
+// ResourceSetSecret sets the secret of a private mutable resource, whose
+// updates are encrypted with it, see storage.ResourceHandler.SetSecret
+func (self *Api) ResourceSetSecret(name string, secret []byte) {
+	self.resource.SetSecret(name, secret)
+}
+
+// ResourceSetRefresh opts the mutable resource in or out of the periodic
+// re-publication of its chunks, see storage.ResourceHandler.SetRefresh
+func (self *Api) ResourceSetRefresh(name string, refresh bool) error {
+	return self.resource.SetRefresh(name, refresh)
+}
+
 func (self *Api) ResourceHashSize() int {
 	return self.resource.HashSize
 }
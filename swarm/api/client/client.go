@@ -33,7 +33,10 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/swarm/api"
 )
 
@@ -51,6 +54,49 @@ func NewClient(gateway string) *Client {
 // Client wraps interaction with a swarm HTTP gateway.
 type Client struct {
 	Gateway string
+
+	// PowDifficulty is the proof-of-work difficulty the gateway requires for
+	// raw uploads. If set, UploadRaw buffers the data and stamps the request.
+	PowDifficulty uint8
+
+	// Reproducible makes uploads of the same files yield the same manifest
+	// hash on any machine. All files are uploaded with the same modification
+	// time and mode instead of those of the local files, and directory uploads
+	// take the content types from ReproducibleContentType instead of the mime
+	// tables of the system. Encrypted uploads are never reproducible.
+	Reproducible bool
+}
+
+// reproducibleContentTypes are the content types of reproducible uploads by
+// file extension, which must never change
+var reproducibleContentTypes = map[string]string{
+	".css":   "text/css; charset=utf-8",
+	".gif":   "image/gif",
+	".htm":   "text/html; charset=utf-8",
+	".html":  "text/html; charset=utf-8",
+	".ico":   "image/x-icon",
+	".jpeg":  "image/jpeg",
+	".jpg":   "image/jpeg",
+	".js":    "application/javascript",
+	".json":  "application/json",
+	".md":    "text/markdown; charset=utf-8",
+	".pdf":   "application/pdf",
+	".png":   "image/png",
+	".svg":   "image/svg+xml",
+	".txt":   "text/plain; charset=utf-8",
+	".wasm":  "application/wasm",
+	".webp":  "image/webp",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".xml":   "text/xml; charset=utf-8",
+}
+
+// ReproducibleContentType returns the content type of the file for
+// reproducible uploads, which depends on its extension only. It returns an
+// empty string for unknown extensions, leaving the content type to be
+// detected when the file is downloaded.
+func ReproducibleContentType(path string) string {
+	return reproducibleContentTypes[strings.ToLower(filepath.Ext(path))]
 }
 
 // UploadRaw uploads raw data to swarm and returns the resulting hash. If toEncrypt is true it
@@ -63,11 +109,28 @@ func (c *Client) UploadRaw(r io.Reader, size int64, toEncrypt bool) (string, err
 	if toEncrypt {
 		addr = "encrypt"
 	}
+	var nonce, timestamp, hash string
+	if c.PowDifficulty > 0 {
+		data, err := ioutil.ReadAll(io.LimitReader(r, size))
+		if err != nil {
+			return "", err
+		}
+		now := time.Now().Unix()
+		nonce = strconv.FormatUint(api.SolvePow(api.PowDigest(data, now), c.PowDifficulty), 10)
+		timestamp = strconv.FormatInt(now, 10)
+		hash = hexutil.Encode(crypto.Keccak256(data))
+		r = bytes.NewReader(data)
+	}
 	req, err := http.NewRequest("POST", c.Gateway+"/bzz-raw:/"+addr, r)
 	if err != nil {
 		return "", err
 	}
 	req.ContentLength = size
+	if nonce != "" {
+		req.Header.Set("X-Swarm-Pow-Nonce", nonce)
+		req.Header.Set("X-Swarm-Pow-Time", timestamp)
+		req.Header.Set("X-Swarm-Pow-Hash", hash)
+	}
 	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
@@ -174,7 +237,12 @@ func (c *Client) UploadDirectory(dir, defaultPath, manifest string, toEncrypt bo
 	} else if !stat.IsDir() {
 		return "", fmt.Errorf("not a directory: %s", dir)
 	}
-	return c.TarUpload(manifest, &DirectoryUploader{dir, defaultPath}, toEncrypt)
+	uploader := &DirectoryUploader{
+		Dir:          dir,
+		DefaultPath:  defaultPath,
+		Reproducible: c.Reproducible,
+	}
+	return c.TarUpload(manifest, uploader, toEncrypt)
 }
 
 // DownloadDirectory downloads the files contained in a swarm manifest under
@@ -390,12 +458,16 @@ func (u UploaderFunc) Upload(upload UploadFn) error {
 type DirectoryUploader struct {
 	Dir         string
 	DefaultPath string
+
+	// Reproducible takes the content types of the files from
+	// ReproducibleContentType
+	Reproducible bool
 }
 
 // Upload performs the upload of the directory and default path
 func (d *DirectoryUploader) Upload(upload UploadFn) error {
 	if d.DefaultPath != "" {
-		file, err := Open(d.DefaultPath)
+		file, err := d.open(d.DefaultPath)
 		if err != nil {
 			return err
 		}
@@ -410,7 +482,7 @@ func (d *DirectoryUploader) Upload(upload UploadFn) error {
 		if f.IsDir() {
 			return nil
 		}
-		file, err := Open(path)
+		file, err := d.open(path)
 		if err != nil {
 			return err
 		}
@@ -423,6 +495,17 @@ func (d *DirectoryUploader) Upload(upload UploadFn) error {
 	})
 }
 
+func (d *DirectoryUploader) open(path string) (*File, error) {
+	file, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if d.Reproducible {
+		file.ContentType = ReproducibleContentType(path)
+	}
+	return file, nil
+}
+
 // FileUploader uploads a single file
 type FileUploader struct {
 	File *File
@@ -433,13 +516,17 @@ func (f *FileUploader) Upload(upload UploadFn) error {
 	return upload(f.File)
 }
 
+// the mode of the files of reproducible uploads
+const reproducibleMode = 0644
+
 // UploadFn is the type of function passed to an Uploader to perform the upload
 // of a single file (for example, a directory uploader would call a provided
 // UploadFn for each file in the directory tree)
 type UploadFn func(file *File) error
 
 // TarUpload uses the given Uploader to upload files to swarm as a tar stream,
-// returning the resulting manifest hash
+// returning the resulting manifest hash. With Reproducible, the files are
+// sent with the same modification time and mode.
 func (c *Client) TarUpload(hash string, uploader Uploader, toEncrypt bool) (string, error) {
 	reqR, reqW := io.Pipe()
 	defer reqR.Close()
@@ -475,6 +562,10 @@ func (c *Client) TarUpload(hash string, uploader Uploader, toEncrypt bool) (stri
 				"user.swarm.content-type": file.ContentType,
 			},
 		}
+		if c.Reproducible {
+			hdr.Mode = reproducibleMode
+			hdr.ModTime = time.Unix(0, 0)
+		}
 		if err := tw.WriteHeader(hdr); err != nil {
 			return err
 		}
@@ -507,6 +598,34 @@ func (c *Client) TarUpload(hash string, uploader Uploader, toEncrypt bool) (stri
 	return string(data), nil
 }
 
+// UploadWarc uploads the resources captured in a WARC web archive to swarm,
+// adding them to the given manifest (or a new one if hash is empty) with the
+// captured URLs as paths, and returns the resulting manifest hash
+func (c *Client) UploadWarc(r io.Reader, hash string, toEncrypt bool) (string, error) {
+	addr := hash
+	if hash == "" && toEncrypt {
+		addr = "encrypt"
+	}
+	req, err := http.NewRequest("POST", c.Gateway+"/bzz:/"+addr, r)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", api.WarcContentType)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected HTTP status: %s", res.Status)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 // MultipartUpload uses the given Uploader to upload files to swarm as a
 // multipart form, returning the resulting manifest hash
 func (c *Client) MultipartUpload(hash string, uploader Uploader) (string, error) {
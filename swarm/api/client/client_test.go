@@ -24,6 +24,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/swarm/api"
 	swarmhttp "github.com/ethereum/go-ethereum/swarm/api/http"
@@ -238,6 +239,59 @@ func TestClientUploadDownloadDirectory(t *testing.T) {
 	}
 }
 
+// TestClientUploadDirectoryReproducible tests that reproducible uploads of
+// the same files with different modification times and modes yield the same
+// manifest hash
+func TestClientUploadDirectoryReproducible(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	dir1 := newTestDirectory(t)
+	defer os.RemoveAll(dir1)
+	dir2 := newTestDirectory(t)
+	defer os.RemoveAll(dir2)
+	mtime := time.Now().Add(-time.Hour)
+	for _, file := range testDirFiles {
+		path := filepath.Join(dir2, file)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := NewClient(srv.URL)
+	upload := func(dir string) string {
+		hash, err := client.UploadDirectory(dir, "", "", false)
+		if err != nil {
+			t.Fatalf("error uploading directory: %s", err)
+		}
+		return hash
+	}
+	if upload(dir1) == upload(dir2) {
+		t.Fatal("expected different hashes of uploads with different modification times")
+	}
+	client.Reproducible = true
+	hash := upload(dir1)
+	if hash != upload(dir2) {
+		t.Fatal("expected the same hash of reproducible uploads")
+	}
+
+	list, err := client.List(hash, "dir1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(list.Entries))
+	}
+	for _, entry := range list.Entries {
+		if entry.Mode != 0644 || entry.ModTime.Unix() != 0 || entry.ContentType != ReproducibleContentType(entry.Path) {
+			t.Fatalf("unexpected entry of reproducible upload %+v", entry)
+		}
+	}
+}
+
 // TestClientFileList tests listing files in a swarm manifest
 func TestClientFileList(t *testing.T) {
 	testClientFileList(false, t)
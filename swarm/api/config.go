@@ -49,25 +49,69 @@ type Config struct {
 	Swap *swap.LocalProfile
 	Pss  *pss.PssParams
 	//*network.SyncParams
-	Contract          common.Address
-	EnsRoot           common.Address
-	EnsAPIs           []string
-	Path              string
-	ListenAddr        string
-	Port              string
-	PublicKey         string
-	BzzKey            string
-	NodeID            string
-	NetworkId         uint64
-	SwapEnabled       bool
-	SyncEnabled       bool
-	DeliverySkipCheck bool
-	SyncUpdateDelay   time.Duration
-	SwapApi           string
-	Cors              string
-	BzzAccount        string
-	BootNodes         string
-	privateKey        *ecdsa.PrivateKey
+	Contract                common.Address
+	EnsRoot                 common.Address
+	EnsAPIs                 []string
+	Path                    string
+	ListenAddr              string
+	Port                    string
+	S3Port                  string // port of the s3 compatible gateway, disabled if empty
+	IPFSGateway             string // url of the ipfs gateway used to bridge ipfs content, disabled if empty
+	PowDifficulty           string // proof-of-work difficulty required for http writes by scheme, e.g. "bzz:20,bzz-raw:20"
+	VirtualHosts            string // domains served by the http gateway, e.g. "example.com=example.eth"
+	TLSPort                 string // port of the https gateway, disabled if empty
+	TLSCert                 string // default tls certificate file of the https gateway
+	TLSKey                  string // key file of the default tls certificate
+	TLSCertDir              string // directory of per virtual host tls certificates <host>.crt and <host>.key
+	WriteAddr               string // address serving the http write api instead of the main port, e.g. "10.0.0.1:8501"
+	AdminAddr               string // address serving the http admin api instead of the main port, e.g. "127.0.0.1:8502"
+	PublicKey               string
+	BzzKey                  string
+	NodeID                  string
+	NetworkId               uint64
+	SwapEnabled             bool
+	SyncEnabled             bool
+	DeliverySkipCheck       bool
+	TransitEncryption       bool   // retrieved chunks are sealed to this node, so relays cannot read them
+	AuthAllow               string // overlay addresses of the peers allowed to retrieve and store chunks, comma separated, open swarm if empty
+	AuthSecret              string // shared secret of the peers allowed to retrieve and store chunks, see stream.TokenAuthorizer
+	AuthToken               string // capability token presented to the peers, issued with AuthSecret if empty
+	SyncUpdateDelay         time.Duration
+	SyncHealth              int            // kademlia health score (0-100) required before syncing starts, see network.HealthScore
+	SyncHistoryRate         int            // bandwidth limit of initial (history) syncing in bytes per second, unlimited if 0
+	ResourceFanOut          int            // periods probed concurrently by resource lookups, default if 0
+	ResourceRetrieveTimeout time.Duration  // timeout of retrieving a chunk in resource lookups, default if 0
+	ResourceStoreTimeout    time.Duration  // timeout of storing the chunks of a resource update, default if 0
+	ResourceIndexSize       int            // resources kept in the index of the resource handler, default if 0
+	ResourceIndexTTL        time.Duration  // resources not synced for this long are dropped from the index, never if 0
+	ResourceRefreshInterval time.Duration  // interval of re-publishing the chunks of resources opted in, disabled if 0
+	ResourcePrefetchAhead   time.Duration  // the updates of subscribed resources are retrieved this long before their period starts, not prefetched if 0
+	ResourceNesting         int            // resources referencing other resources are resolved by the gateway this deep, default if 0
+	ResourceStrict          bool           // reject resource updates for periods earlier than the newest seen
+	ResourceAnyMultihash    bool           // accept multihash resource updates of codes other than sha2-256, keccak-256 and blake2b
+	ResourceENSUpdate       bool           // set the ENS content records of the names of new resources to their metadata chunk
+	ResourceNameRate        int            // resource update chunks accepted per name and minute, unlimited if 0
+	ResourceSignerRate      int            // resource update chunks accepted per signer and minute, unsigned ones share a signer, unlimited if 0
+	ResourceUpdateRate      int            // resource update chunks accepted per minute, unlimited if 0
+	ResourceChainAPIs       []string       // chain nodes queried for the block heights of resources before the ENS APIs, light clients first
+	ResourceMaxHeaderAge    time.Duration  // chain nodes with an older latest header are taken as not synced, not checked if 0
+	ResourceOffline         bool           // never estimate block heights, block based resources fail without a chain node
+	ResourceShards          []string       // directories of the chunk stores the resources are partitioned across by namehash, not sharded if empty
+	ManagedKeys             bool           // keep publisher keys on behalf of users and sign their resource updates
+	GatewayKeys             bool           // require API keys issued by the gateway on writes and enforce their quotas
+	NotaryAPI               string         // endpoint of the chain root hashes are notarized on, disabled if empty
+	NotaryRegistry          common.Address // registry contract of notarizations, they are sent to the node account if zero
+	NotaryChainID           uint64         // chain id the notarizations are signed for, the network id of NotaryAPI if zero
+	SearchIndex             bool           // index the text of the content uploaded to and pinned on the node for search
+	AccessNodeKey           bool           // unlock the access grants to the node key for any request, not for public gateways
+	EventSinks              []string       // sinks the events of the node are exported to, see events.ParseSink
+	SwapApi                 string
+	Cors                    string
+	BzzAccount              string
+	PublisherAccount        string // account signing the resource updates in place of BzzAccount, shared by the nodes of a publisher
+	BootNodes               string
+	privateKey              *ecdsa.PrivateKey
+	publisherKey            *ecdsa.PrivateKey
 }
 
 //create a default config with all parameters to set to defaults
@@ -122,12 +166,38 @@ func (self *Config) Init(prvKey *ecdsa.PrivateKey) {
 	}
 
 	self.privateKey = prvKey
+	// the chunks of networks other than the default one are kept apart, so
+	// that the nodes of several networks can share a data directory
+	self.LocalStoreParams.NetworkID = self.NetworkId
+	if self.LocalStoreParams.ChunkDbPath == "" && self.NetworkId != network.DefaultNetworkID {
+		self.LocalStoreParams.ChunkDbPath = self.networkChunkDbPath()
+	}
 	self.LocalStoreParams.Init(self.Path)
 	self.LocalStoreParams.BaseKey = common.FromHex(keyhex)
 
 	self.Pss = self.Pss.WithPrivateKey(self.privateKey)
 }
 
+// returns the chunk db path of a network other than the default one. Nodes
+// kept the chunks of all networks in the chunks directory before, so it is
+// still used if it holds the chunks of the network, or of no network yet,
+// unless the network has its own directory already.
+func (self *Config) networkChunkDbPath() string {
+	path := filepath.Join(self.Path, fmt.Sprintf("chunks-%d", self.NetworkId))
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	legacy := filepath.Join(self.Path, "chunks")
+	if _, err := os.Stat(legacy); err != nil {
+		return path
+	}
+	if id, err := storage.StoreNetworkID(legacy); err == nil && (id == 0 || id == self.NetworkId) {
+		log.Info("Using the chunk store of all networks", "path", legacy, "network", self.NetworkId)
+		return legacy
+	}
+	return path
+}
+
 func (self *Config) ShiftPrivateKey() (privKey *ecdsa.PrivateKey) {
 	if self.privateKey != nil {
 		privKey = self.privateKey
@@ -135,3 +205,21 @@ func (self *Config) ShiftPrivateKey() (privKey *ecdsa.PrivateKey) {
 	}
 	return privKey
 }
+
+// SetPublisherKey sets the key of the publisher account, which signs the
+// resource updates of the node instead of the key of the bzz account. Nodes
+// sharing the key publish the same feeds, which survive the replacement of
+// any of them.
+func (self *Config) SetPublisherKey(prvKey *ecdsa.PrivateKey) {
+	self.publisherKey = prvKey
+}
+
+// ShiftPublisherKey returns the key of the publisher account, nil if none is
+// set, and removes it from the config like ShiftPrivateKey
+func (self *Config) ShiftPublisherKey() (privKey *ecdsa.PrivateKey) {
+	if self.publisherKey != nil {
+		privKey = self.publisherKey
+		self.publisherKey = nil
+	}
+	return privKey
+}
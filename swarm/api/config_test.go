@@ -17,11 +17,15 @@
 package api
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/storage"
 )
 
 func TestConfig(t *testing.T) {
@@ -56,4 +60,59 @@ func TestConfig(t *testing.T) {
 	if one.ChunkDbPath == one.Path {
 		t.Fatal("Failed to correctly initialize StoreParams")
 	}
+	if one.LocalStoreParams.NetworkID != one.NetworkId {
+		t.Fatalf("Expected the chunk store to belong to network %d, got %d", one.NetworkId, one.LocalStoreParams.NetworkID)
+	}
+
+	// the chunks of other networks are kept apart
+	other := NewConfig()
+	other.NetworkId = 42
+	other.Init(prvkey)
+	if other.ChunkDbPath == one.ChunkDbPath {
+		t.Fatalf("Expected a chunk store path for network %d, got the one of network %d", other.NetworkId, one.NetworkId)
+	}
+}
+
+// the chunk store of all networks is kept by nodes of other networks than the
+// default one, unless it belongs to another network
+func TestConfigLegacyChunkDbPath(t *testing.T) {
+	prvkey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "bzz-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	initConfig := func(networkID uint64) *Config {
+		config := NewConfig()
+		config.Path = dir
+		config.NetworkId = networkID
+		config.Init(prvkey)
+		return config
+	}
+	legacy := filepath.Join(initConfig(42).Path, "chunks")
+	createStore := func(networkID uint64) {
+		params := storage.NewLDBStoreParams(storage.NewDefaultStoreParams(), legacy)
+		params.NetworkID = networkID
+		store, err := storage.NewLDBStore(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Close()
+	}
+
+	createStore(0)
+	if path := initConfig(42).ChunkDbPath; path != legacy {
+		t.Fatalf("expected chunk store %s of all networks, got %s", legacy, path)
+	}
+	createStore(42)
+	if path := initConfig(42).ChunkDbPath; path != legacy {
+		t.Fatalf("expected chunk store %s of the network, got %s", legacy, path)
+	}
+	if path := initConfig(43).ChunkDbPath; path == legacy {
+		t.Fatal("expected chunk store of another network not to be used")
+	}
 }
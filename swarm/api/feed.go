@@ -0,0 +1,115 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/swarm/multihash"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// DefaultResourceHistoryLimit is the number of updates returned by ResourceHistory if no limit is given
+const DefaultResourceHistoryLimit = 20
+
+// ResourceEnvelope is the structured form of a resource update used by
+// publishers of feeds such as blogs. Updates whose data is a JSON object of
+// this form are rendered with their metadata, other updates are rendered
+// from their raw data.
+type ResourceEnvelope struct {
+	Title       string    `json:"title,omitempty"`
+	Link        string    `json:"link,omitempty"`
+	Author      string    `json:"author,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Content     string    `json:"content,omitempty"`
+	ContentType string    `json:"contentType,omitempty"`
+	Date        time.Time `json:"date,omitempty"`
+}
+
+// ResourceHistoryEntry is a single update in the history of a resource
+type ResourceHistoryEntry struct {
+	Period    uint32
+	Version   uint32
+	Multihash bool
+	Data      []byte
+}
+
+// Envelope returns the structured envelope of the update. Updates which are
+// not structured get an envelope derived from their data: multihash updates
+// link to the content they reference, text updates become the content.
+func (e *ResourceHistoryEntry) Envelope() *ResourceEnvelope {
+	env := &ResourceEnvelope{}
+	if !e.Multihash && json.Unmarshal(e.Data, env) == nil && (env.Title != "" || env.Content != "" || env.Link != "") {
+		return env
+	}
+	env = &ResourceEnvelope{
+		Title: fmt.Sprintf("Update %d.%d", e.Period, e.Version),
+	}
+	if e.Multihash {
+		if dm, err := multihash.Decode(e.Data); err == nil && dm.Code == multihash.KECCAK_256 {
+			env.Link = "bzz:/" + common.Bytes2Hex(dm.Digest)
+		}
+	} else if utf8.Valid(e.Data) {
+		env.Content = string(e.Data)
+		env.ContentType = "text/plain"
+	}
+	return env
+}
+
+// ResourceHistory returns the most recent updates of the resource with the
+// given root chunk key, newest first, walking back at most limit updates
+func (self *Api) ResourceHistory(ctx context.Context, key storage.Key, limit int) (string, []*ResourceHistoryEntry, error) {
+	if limit <= 0 {
+		limit = DefaultResourceHistoryLimit
+	}
+	rsrc, err := self.resource.LoadResource(key)
+	if err != nil {
+		return "", nil, err
+	}
+	view, err := self.resource.LookupLatest(ctx, rsrc.NameHash(), true, nil)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var entries []*ResourceHistoryEntry
+	for len(entries) < limit {
+		data, err := view.Data()
+		if err != nil {
+			return "", nil, err
+		}
+		entries = append(entries, &ResourceHistoryEntry{
+			Period:    view.Period(),
+			Version:   view.Version(),
+			Multihash: view.Multihash,
+			Data:      data,
+		})
+		// the walk ends at the oldest update, or where earlier updates
+		// cannot be found
+		view, err = self.resource.LookupPrevious(ctx, view, nil)
+		if code, ok := storage.ResourceErrorCode(err); ok && (code == storage.ErrNothingToReturn || code == storage.ErrNotFound) {
+			break
+		} else if err != nil {
+			return "", nil, err
+		}
+	}
+	return rsrc.Name(), entries, nil
+}
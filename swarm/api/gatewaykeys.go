@@ -0,0 +1,449 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/state"
+)
+
+const (
+	gatewayKeyPrefix    = "gateway-key-"
+	gatewayKeysKey      = "gateway-keys"
+	gatewayKeyIDSize    = 8
+	gatewayKeyTokenSize = 32
+)
+
+var (
+	// ErrGatewayKeyAuth is returned when an API key does not exist or the token does not match
+	ErrGatewayKeyAuth = errors.New("invalid API key")
+	// ErrGatewayKeyDisabled is returned when an API key is used while disabled
+	ErrGatewayKeyDisabled = errors.New("API key is disabled")
+	// ErrGatewayKeyNotFound is returned when managing an API key which does not exist
+	ErrGatewayKeyNotFound = errors.New("API key not found")
+	// ErrGatewayRateLimited is returned when an API key exceeds its requests per minute
+	ErrGatewayRateLimited = errors.New("API key request rate exceeded")
+	// ErrGatewayQuotaExceeded is returned when an API key exceeds its upload or resource quota
+	ErrGatewayQuotaExceeded = errors.New("API key quota exceeded")
+	// ErrGatewayKeysDisabled is returned when the gateway does not issue API keys
+	ErrGatewayKeysDisabled = errors.New("gateway API keys are disabled")
+
+	gatewayKeyCreateCount = metrics.NewRegisteredCounter("api.gatewaykey.create.count", nil)
+	gatewayKeyAuthFail    = metrics.NewRegisteredCounter("api.gatewaykey.auth.fail", nil)
+	gatewayKeyRejectCount = metrics.NewRegisteredCounter("api.gatewaykey.reject.count", nil)
+)
+
+// GatewayQuota bounds the usage of an API key, zero values are unlimited
+type GatewayQuota struct {
+	UploadBytes       int64 `json:"uploadBytes"`       // bytes uploaded in total
+	Resources         int   `json:"resources"`         // mutable resources created in total
+	RequestsPerMinute int   `json:"requestsPerMinute"` // requests of any kind
+}
+
+// GatewayUsage counts the use of an API key since it was created
+type GatewayUsage struct {
+	UploadBytes int64     `json:"uploadBytes"`
+	Resources   int       `json:"resources"`
+	Requests    uint64    `json:"requests"`
+	Rejected    uint64    `json:"rejected"` // requests rejected for exceeding the quota
+	LastUsed    time.Time `json:"lastUsed,omitempty"`
+}
+
+// gatewayKey is the persisted API key, only the hash of its token is kept
+type gatewayKey struct {
+	ID        string        `json:"id"`
+	User      string        `json:"user"`
+	TokenHash hexutil.Bytes `json:"tokenHash"`
+	Created   time.Time     `json:"created"`
+	Disabled  bool          `json:"disabled"`
+	Quota     GatewayQuota  `json:"quota"`
+	Usage     GatewayUsage  `json:"usage"`
+
+	windowStart time.Time // start of the minute the requests are counted in
+	windowCount int
+}
+
+// GatewayKeyInfo describes an API key and its usage
+type GatewayKeyInfo struct {
+	ID       string       `json:"id"`
+	User     string       `json:"user"`
+	Token    string       `json:"token,omitempty"` // only returned when the key is created
+	Created  time.Time    `json:"created"`
+	Disabled bool         `json:"disabled"`
+	Quota    GatewayQuota `json:"quota"`
+	Usage    GatewayUsage `json:"usage"`
+}
+
+func (self *gatewayKey) info() *GatewayKeyInfo {
+	return &GatewayKeyInfo{
+		ID:       self.ID,
+		User:     self.User,
+		Created:  self.Created,
+		Disabled: self.Disabled,
+		Quota:    self.Quota,
+		Usage:    self.Usage,
+	}
+}
+
+/*
+GatewayKeys issues API keys to the users of a gateway offered as a
+multi-tenant service.
+
+Each key belongs to a user, who may hold several keys, e.g. one per
+application. A key is given by its token, <id>.<secret>, and is bounded by a
+quota of bytes uploaded, resources created and requests per minute. The usage
+of the keys is counted so that the operator can bill or throttle its users.
+
+The keys are kept in the state store of the node. Request counts are only
+persisted along with the other changes of a key and by Save.
+*/
+type GatewayKeys struct {
+	store state.Store
+	mu    sync.Mutex
+	keys  map[string]*gatewayKey
+	now   func() time.Time
+}
+
+// NewGatewayKeys creates a key store persisting the keys in the given state
+// store, keys are only kept in memory if it is nil
+func NewGatewayKeys(store state.Store) *GatewayKeys {
+	self := &GatewayKeys{
+		store: store,
+		keys:  make(map[string]*gatewayKey),
+		now:   time.Now,
+	}
+	if store == nil {
+		return self
+	}
+	var ids []string
+	if err := store.Get(gatewayKeysKey, &ids); err != nil {
+		return self
+	}
+	for _, id := range ids {
+		key := &gatewayKey{}
+		if err := store.Get(gatewayKeyPrefix+id, key); err != nil {
+			log.Warn("could not load gateway API key", "id", id, "err", err)
+			continue
+		}
+		self.keys[id] = key
+	}
+	log.Debug("gateway API keys loaded", "keys", len(self.keys))
+	return self
+}
+
+// Create issues a new API key for the user with the given quota and returns
+// its info including the token, which is not shown again
+func (self *GatewayKeys) Create(user string, quota GatewayQuota) (*GatewayKeyInfo, error) {
+	if !managedUserMatcher.MatchString(user) {
+		return nil, fmt.Errorf("invalid user name %q", user)
+	}
+	if err := quota.validate(); err != nil {
+		return nil, err
+	}
+	random := make([]byte, gatewayKeyIDSize+gatewayKeyTokenSize)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(random[:gatewayKeyIDSize])
+	token := id + "." + hex.EncodeToString(random[gatewayKeyIDSize:])
+	key := &gatewayKey{
+		ID:        id,
+		User:      user,
+		TokenHash: crypto.Keccak256([]byte(token)),
+		Created:   self.now(),
+		Quota:     quota,
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if err := self.save(key); err != nil {
+		return nil, err
+	}
+	self.keys[id] = key
+	if err := self.saveIndex(); err != nil {
+		delete(self.keys, id)
+		return nil, err
+	}
+	gatewayKeyCreateCount.Inc(1)
+	log.Info("created gateway API key", "user", user, "id", id)
+	info := key.info()
+	info.Token = token
+	return info, nil
+}
+
+// Keys returns the keys of the user, or of all users if user is empty,
+// ordered by user and creation time
+func (self *GatewayKeys) Keys(user string) []*GatewayKeyInfo {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	var infos []*GatewayKeyInfo
+	for _, key := range self.keys {
+		if user == "" || key.User == user {
+			infos = append(infos, key.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].User != infos[j].User {
+			return infos[i].User < infos[j].User
+		}
+		return infos[i].Created.Before(infos[j].Created)
+	})
+	return infos
+}
+
+// Info returns the info of the key with the id
+func (self *GatewayKeys) Info(id string) (*GatewayKeyInfo, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	key, ok := self.keys[id]
+	if !ok {
+		return nil, ErrGatewayKeyNotFound
+	}
+	return key.info(), nil
+}
+
+// SetQuota replaces the quota of the key with the id, the usage counted so
+// far is kept
+func (self *GatewayKeys) SetQuota(id string, quota GatewayQuota) (*GatewayKeyInfo, error) {
+	if err := quota.validate(); err != nil {
+		return nil, err
+	}
+	return self.update(id, func(key *gatewayKey) {
+		key.Quota = quota
+	})
+}
+
+// SetDisabled suspends the key with the id, or resumes it
+func (self *GatewayKeys) SetDisabled(id string, disabled bool) (*GatewayKeyInfo, error) {
+	return self.update(id, func(key *gatewayKey) {
+		key.Disabled = disabled
+	})
+}
+
+// Revoke deletes the key with the id and its usage
+func (self *GatewayKeys) Revoke(id string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	key, ok := self.keys[id]
+	if !ok {
+		return ErrGatewayKeyNotFound
+	}
+	delete(self.keys, id)
+	if err := self.saveIndex(); err != nil {
+		self.keys[id] = key
+		return err
+	}
+	if self.store != nil {
+		if err := self.store.Delete(gatewayKeyPrefix + id); err != nil {
+			return err
+		}
+	}
+	log.Info("revoked gateway API key", "user", key.User, "id", id)
+	return nil
+}
+
+// Authorize authenticates the token of a key and counts a request against
+// its rate, it returns the id of the key
+func (self *GatewayKeys) Authorize(token string) (string, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	key, err := self.authenticate(token)
+	if err != nil {
+		return "", err
+	}
+	now := self.now()
+	if key.Quota.RequestsPerMinute > 0 {
+		if now.Sub(key.windowStart) >= time.Minute {
+			key.windowStart, key.windowCount = now, 0
+		}
+		if key.windowCount >= key.Quota.RequestsPerMinute {
+			return "", self.reject(key, ErrGatewayRateLimited)
+		}
+		key.windowCount++
+	}
+	key.Usage.Requests++
+	key.Usage.LastUsed = now
+	return key.ID, nil
+}
+
+// CheckUpload returns an error if uploading size more bytes would exceed the
+// quota of the key with the id
+func (self *GatewayKeys) CheckUpload(id string, size int64) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	key, ok := self.keys[id]
+	if !ok {
+		return ErrGatewayKeyNotFound
+	}
+	if key.Quota.UploadBytes > 0 && key.Usage.UploadBytes+size > key.Quota.UploadBytes {
+		return self.reject(key, ErrGatewayQuotaExceeded)
+	}
+	return nil
+}
+
+// UploadAllowance returns the bytes which can still be uploaded with the key
+// with the id, -1 if its uploads are unlimited
+func (self *GatewayKeys) UploadAllowance(id string) (int64, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	key, ok := self.keys[id]
+	if !ok {
+		return 0, ErrGatewayKeyNotFound
+	}
+	if key.Quota.UploadBytes <= 0 {
+		return -1, nil
+	}
+	if key.Usage.UploadBytes >= key.Quota.UploadBytes {
+		return 0, nil
+	}
+	return key.Quota.UploadBytes - key.Usage.UploadBytes, nil
+}
+
+// AddUpload counts bytes uploaded with the key with the id
+func (self *GatewayKeys) AddUpload(id string, size int64) error {
+	_, err := self.update(id, func(key *gatewayKey) {
+		key.Usage.UploadBytes += size
+	})
+	return err
+}
+
+// CheckResource returns an error if creating another resource would exceed
+// the quota of the key with the id
+func (self *GatewayKeys) CheckResource(id string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	key, ok := self.keys[id]
+	if !ok {
+		return ErrGatewayKeyNotFound
+	}
+	if key.Quota.Resources > 0 && key.Usage.Resources >= key.Quota.Resources {
+		return self.reject(key, ErrGatewayQuotaExceeded)
+	}
+	return nil
+}
+
+// AddResource counts a resource created with the key with the id
+func (self *GatewayKeys) AddResource(id string) error {
+	_, err := self.update(id, func(key *gatewayKey) {
+		key.Usage.Resources++
+	})
+	return err
+}
+
+// Save persists the usage of all keys, it is called when the node stops
+func (self *GatewayKeys) Save() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, key := range self.keys {
+		if err := self.save(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applies the change to the key with the id and persists it
+func (self *GatewayKeys) update(id string, change func(*gatewayKey)) (*GatewayKeyInfo, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	key, ok := self.keys[id]
+	if !ok {
+		return nil, ErrGatewayKeyNotFound
+	}
+	change(key)
+	if err := self.save(key); err != nil {
+		return nil, err
+	}
+	return key.info(), nil
+}
+
+// returns the key of the token, the caller must hold the lock
+func (self *GatewayKeys) authenticate(token string) (*gatewayKey, error) {
+	var key *gatewayKey
+	if i := strings.IndexByte(token, '.'); i > 0 {
+		key = self.keys[token[:i]]
+	}
+	if key == nil || subtle.ConstantTimeCompare(key.TokenHash, crypto.Keccak256([]byte(token))) != 1 {
+		gatewayKeyAuthFail.Inc(1)
+		return nil, ErrGatewayKeyAuth
+	}
+	if key.Disabled {
+		return nil, self.reject(key, ErrGatewayKeyDisabled)
+	}
+	return key, nil
+}
+
+// counts a request rejected by the quota of the key, the caller must hold
+// the lock
+func (self *GatewayKeys) reject(key *gatewayKey, err error) error {
+	gatewayKeyRejectCount.Inc(1)
+	key.Usage.Rejected++
+	log.Debug("gateway API key rejected", "user", key.User, "id", key.ID, "err", err)
+	return err
+}
+
+// persists the key, the caller must hold the lock
+func (self *GatewayKeys) save(key *gatewayKey) error {
+	if self.store == nil {
+		return nil
+	}
+	return self.store.Put(gatewayKeyPrefix+key.ID, key)
+}
+
+// persists the ids of the keys, the caller must hold the lock
+func (self *GatewayKeys) saveIndex() error {
+	if self.store == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(self.keys))
+	for id := range self.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return self.store.Put(gatewayKeysKey, ids)
+}
+
+func (self GatewayQuota) validate() error {
+	if self.UploadBytes < 0 || self.Resources < 0 || self.RequestsPerMinute < 0 {
+		return errors.New("quotas cannot be negative")
+	}
+	return nil
+}
+
+// SetGatewayKeys requires API keys on the write requests to the gateway and
+// enforces their quotas
+func (self *Api) SetGatewayKeys(keys *GatewayKeys) {
+	self.gatewayKeys = keys
+}
+
+// GatewayKeys returns the API keys of the gateway, nil if disabled
+func (self *Api) GatewayKeys() *GatewayKeys {
+	return self.gatewayKeys
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/swarm/state"
+)
+
+// TestGatewayKeys checks that API keys are authenticated, held to their
+// quotas and keep their usage across a restart of the gateway
+func TestGatewayKeys(t *testing.T) {
+	store := state.NewInmemoryStore()
+	keys := NewGatewayKeys(store)
+	now := time.Unix(1500000000, 0)
+	keys.now = func() time.Time { return now }
+
+	if _, err := keys.Create("not/a user", GatewayQuota{}); err == nil {
+		t.Fatal("expected invalid user name to be rejected")
+	}
+	if _, err := keys.Create("alice", GatewayQuota{UploadBytes: -1}); err == nil {
+		t.Fatal("expected negative quota to be rejected")
+	}
+	alice, err := keys.Create("alice", GatewayQuota{UploadBytes: 100, Resources: 1, RequestsPerMinute: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Create("bob", GatewayQuota{}); err != nil {
+		t.Fatal(err)
+	}
+	if infos := keys.Keys("alice"); len(infos) != 1 || infos[0].ID != alice.ID || infos[0].Token != "" {
+		t.Fatalf("unexpected keys of alice %v", infos)
+	}
+	if infos := keys.Keys(""); len(infos) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(infos))
+	}
+
+	if _, err := keys.Authorize(alice.ID + ".wrong"); err != ErrGatewayKeyAuth {
+		t.Fatalf("expected %v, got %v", ErrGatewayKeyAuth, err)
+	}
+	// the rate is limited per minute
+	for i := 0; i < 2; i++ {
+		if _, err := keys.Authorize(alice.Token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := keys.Authorize(alice.Token); err != ErrGatewayRateLimited {
+		t.Fatalf("expected %v, got %v", ErrGatewayRateLimited, err)
+	}
+	now = now.Add(time.Minute)
+	id, err := keys.Authorize(alice.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != alice.ID {
+		t.Fatalf("expected key %s, got %s", alice.ID, id)
+	}
+
+	// uploads and resources are limited in total
+	if err := keys.CheckUpload(id, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.AddUpload(id, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.CheckUpload(id, 60); err != ErrGatewayQuotaExceeded {
+		t.Fatalf("expected %v, got %v", ErrGatewayQuotaExceeded, err)
+	}
+	if err := keys.CheckResource(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.AddResource(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.CheckResource(id); err != ErrGatewayQuotaExceeded {
+		t.Fatalf("expected %v, got %v", ErrGatewayQuotaExceeded, err)
+	}
+
+	// the usage is kept after a restart
+	if err := keys.Save(); err != nil {
+		t.Fatal(err)
+	}
+	keys = NewGatewayKeys(store)
+	info, err := keys.Info(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := GatewayUsage{UploadBytes: 60, Resources: 1, Requests: 3, Rejected: 3, LastUsed: now}
+	if !info.Usage.LastUsed.Equal(now) {
+		t.Fatalf("expected last use at %v, got %v", now, info.Usage.LastUsed)
+	}
+	info.Usage.LastUsed = now
+	if info.Usage != expected {
+		t.Fatalf("expected usage %+v, got %+v", expected, info.Usage)
+	}
+
+	// raising the quota lets the key upload again, disabling it rejects it
+	if _, err := keys.SetQuota(id, GatewayQuota{UploadBytes: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.CheckUpload(id, 60); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.SetDisabled(id, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Authorize(alice.Token); err != ErrGatewayKeyDisabled {
+		t.Fatalf("expected %v, got %v", ErrGatewayKeyDisabled, err)
+	}
+	if _, err := keys.SetDisabled(id, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Authorize(alice.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := keys.Revoke(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGatewayKeys(store).Authorize(alice.Token); err != ErrGatewayKeyAuth {
+		t.Fatalf("expected revoked key to be gone, got %v", err)
+	}
+	if err := keys.Revoke(id); err != ErrGatewayKeyNotFound {
+		t.Fatalf("expected %v, got %v", ErrGatewayKeyNotFound, err)
+	}
+}
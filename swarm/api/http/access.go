@@ -0,0 +1,267 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// accessRequest is the body of a POST request to bzz-access:/, the secret
+// and the grantees of a new access document
+type accessRequest struct {
+	Secret      hexutil.Bytes   `json:"secret"`      // reference of encrypted content, or the secret of the resource
+	Resource    string          `json:"resource"`    // name of the private resource, empty for encrypted content
+	Grantees    []hexutil.Bytes `json:"grantees"`    // public keys of the grantees, compressed or not
+	Passphrases []string        `json:"passphrases"` // passphrases granted access
+}
+
+// HandleAccess handles the requests to bzz-access: which grant access to
+// encrypted content and private resources, see api.AccessDocument.
+//
+// POST bzz-access:/ creates an access document from the JSON body, see
+// accessRequest, and returns its hash. The documents of private resources
+// are signed by the node, which must own the resource. Uploaders who do not
+// trust the gateway with the secret create the document with
+// api.NewAccessDocument and upload it with bzz-raw instead.
+//
+// GET bzz-access:/<hash>/<path> unlocks the document with the credentials of
+// the request and serves the content at the path of the encrypted manifest,
+// or the update of the private resource at the path, which is empty, a period
+// or a period and version as with bzz-resource. The update is decrypted for
+// this request only. The credentials are given with basic auth, the password
+// is tried as passphrase and together with the user name as the credentials
+// of a publisher key managed by the gateway, see HandleSigner. The grants to
+// the key of the node are unlocked without credentials if the node opted in,
+// see api.Api.SetAccessKey.
+func (s *Server) HandleAccess(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.access", "ruid", r.ruid, "method", r.Method, "addr", r.uri.Addr)
+	switch r.Method {
+	case "POST":
+		s.handlePostAccess(w, r)
+	case "GET":
+		s.handleGetAccess(w, r)
+	default:
+		Respond(w, r, fmt.Sprintf("%s method to %s not allowed", r.Method, r.uri), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePostAccess(w http.ResponseWriter, r *Request) {
+	if r.uri.Addr != "" {
+		Respond(w, r, "access documents are created at bzz-access:/", http.StatusBadRequest)
+		return
+	}
+	var req accessRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Respond(w, r, fmt.Sprintf("invalid access request: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Secret) == 0 {
+		Respond(w, r, "missing secret", http.StatusBadRequest)
+		return
+	}
+	if req.Resource == "" && len(req.Secret) != 2*storage.KeyLength {
+		Respond(w, r, fmt.Sprintf("the secret of encrypted content is its reference of %d bytes, got %d", 2*storage.KeyLength, len(req.Secret)), http.StatusBadRequest)
+		return
+	}
+	if len(req.Grantees) == 0 && len(req.Passphrases) == 0 {
+		Respond(w, r, "no grantees or passphrases", http.StatusBadRequest)
+		return
+	}
+	doc, err := api.NewAccessDocument(req.Resource)
+	if err != nil {
+		Respond(w, r, fmt.Sprintf("cannot create access document: %s", err), http.StatusInternalServerError)
+		return
+	}
+	for _, g := range req.Grantees {
+		grantee, err := parseGrantee(g)
+		if err != nil {
+			Respond(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := doc.GrantKey(grantee, req.Secret); err != nil {
+			Respond(w, r, fmt.Sprintf("cannot grant access: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, passphrase := range req.Passphrases {
+		if err := doc.GrantPassphrase(passphrase, req.Secret); err != nil {
+			Respond(w, r, fmt.Sprintf("cannot grant access: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if doc.Resource != "" {
+		if err := s.api.SignAccess(r.Context(), doc); err != nil {
+			Respond(w, r, fmt.Sprintf("cannot sign access document of %s: %s", doc.Resource, err), http.StatusForbidden)
+			return
+		}
+	}
+	key, err := s.api.PutAccess(doc)
+	if err != nil {
+		Respond(w, r, fmt.Sprintf("cannot store access document: %s", err), http.StatusInternalServerError)
+		return
+	}
+	log.Debug("stored access document", "ruid", r.ruid, "key", key, "grants", len(doc.Grants))
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprint(w, key)
+}
+
+func (s *Server) handleGetAccess(w http.ResponseWriter, r *Request) {
+	key := r.uri.Key()
+	if key == nil {
+		var err error
+		if key, err = s.api.Resolve(r.uri); err != nil {
+			Respond(w, r, fmt.Sprintf("cannot resolve %s: %s", r.uri.Addr, err), http.StatusNotFound)
+			return
+		}
+	}
+	doc, secret, err := s.api.ResolveAccess(key, s.accessCredentials(r)...)
+	switch {
+	case err == api.ErrAccessDenied:
+		w.Header().Set("WWW-Authenticate", `Basic realm="swarm"`)
+		Respond(w, r, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		Respond(w, r, err.Error(), http.StatusNotFound)
+		return
+	}
+	if doc.Resource != "" {
+		s.handleGetPrivateResource(&privateResponseWriter{ResponseWriter: w}, r, doc.Resource, secret)
+		return
+	}
+	if len(secret) != 2*storage.KeyLength {
+		Respond(w, r, fmt.Sprintf("access document %s does not unlock a reference", key), http.StatusNotFound)
+		return
+	}
+	// the encrypted content is served as if requested with its reference,
+	// which is not disclosed
+	r.uri = &api.URI{Scheme: "bzz", Addr: common.Bytes2Hex(secret), Path: r.uri.Path}
+	s.HandleGetFile(&privateResponseWriter{ResponseWriter: w}, r)
+}
+
+// serves the update of the private resource at the path of the request,
+// decrypted with the secret unlocked by the request
+func (s *Server) handleGetPrivateResource(w http.ResponseWriter, r *Request, name string, secret []byte) {
+	manifestKey, err := s.api.Resolve(&api.URI{Scheme: "bzz-resource", Addr: name})
+	if err != nil {
+		Respond(w, r, fmt.Sprintf("cannot resolve %s: %s", name, err), http.StatusNotFound)
+		return
+	}
+	key, err := s.api.ResolveResourceManifest(manifestKey)
+	if err != nil {
+		Respond(w, r, fmt.Sprintf("error resolving resource root chunk for %s: %s", name, err), http.StatusNotFound)
+		return
+	}
+	var period, version uint64
+	var params []string
+	if r.uri.Path != "" {
+		params = strings.Split(r.uri.Path, "/")
+	}
+	switch len(params) {
+	case 2:
+		if version, err = strconv.ParseUint(params[1], 10, 32); err != nil {
+			break
+		}
+		fallthrough
+	case 1:
+		period, err = strconv.ParseUint(params[0], 10, 32)
+	case 0:
+	default:
+		err = fmt.Errorf("invalid resource path %q", r.uri.Path)
+	}
+	if err != nil {
+		Respond(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	info, data, err := s.api.ResourceLookupPrivate(r.Context(), key, uint32(period), uint32(version), secret, nil)
+	if err != nil {
+		code, err2 := s.translateResourceError(w, r, "mutable resource lookup fail", err)
+		Respond(w, r, err2.Error(), code)
+		return
+	}
+	w.Header().Set("X-Swarm-Resource-Name", info.Name)
+	w.Header().Set("X-Swarm-Resource-Period", strconv.FormatUint(uint64(info.Period), 10))
+	w.Header().Set("X-Swarm-Resource-Version", strconv.FormatUint(uint64(info.Version), 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// privateResponseWriter keeps the content unlocked with the credentials of
+// a request out of shared caches, whatever the handler serving it sets
+type privateResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *privateResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *privateResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// accessCredentials returns the credentials of a request to bzz-access,
+// see HandleAccess
+func (s *Server) accessCredentials(r *Request) []*api.AccessCredential {
+	user, password, ok := r.BasicAuth()
+	if !ok || password == "" {
+		return nil
+	}
+	cred := &api.AccessCredential{Passphrase: password}
+	if signers := s.api.ManagedSigners(); signers != nil && user != "" {
+		if key, err := signers.Export(user, password); err == nil {
+			cred.Key = key
+		}
+	}
+	return []*api.AccessCredential{cred}
+}
+
+// parses the public key of a grantee, compressed or not
+func parseGrantee(b []byte) (*ecdsa.PublicKey, error) {
+	if len(b) == 33 {
+		pub, err := crypto.DecompressPubkey(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grantee %x: %v", b, err)
+		}
+		return pub, nil
+	}
+	if pub := crypto.ToECDSAPub(b); pub != nil && pub.X != nil {
+		return pub, nil
+	}
+	return nil, fmt.Errorf("invalid grantee %x", []byte(b))
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
+	"github.com/ethereum/go-ethereum/swarm/testutil"
+)
+
+// TestBzzAccess checks that encrypted content is served through an access
+// document to the holders of a granted passphrase only
+func TestBzzAccess(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	data := []byte("for your eyes only")
+	client := swarm.NewClient(srv.URL)
+	hash, err := client.Upload(&swarm.File{
+		ReadCloser: ioutil.NopCloser(bytes.NewReader(data)),
+		ManifestEntry: api.ManifestEntry{
+			Path:        "secret.txt",
+			ContentType: "text/plain",
+			Size:        int64(len(data)),
+		},
+	}, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"secret":      "0x" + hash,
+		"passphrases": []string{"open sesame"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(srv.URL+"/bzz-access:/", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, res.StatusCode, doc)
+	}
+	if _, err := hexutil.Decode("0x" + string(doc)); err != nil {
+		t.Fatalf("expected the hash of the access document, got %q", doc)
+	}
+
+	get := func(password string) *http.Response {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/bzz-access:/%s/secret.txt", srv.URL, doc), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, password := range []string{"", "open barley"} {
+		res := get(password)
+		res.Body.Close()
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status %d with password %q, got %d", http.StatusUnauthorized, password, res.StatusCode)
+		}
+		if res.Header.Get("WWW-Authenticate") == "" {
+			t.Fatal("expected a WWW-Authenticate header")
+		}
+	}
+
+	res = get("open sesame")
+	got, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, res.StatusCode, got)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("expected %q, got %q", data, got)
+	}
+	if cc := res.Header.Get("Cache-Control"); cc != "private, no-store" {
+		t.Fatalf("expected the content not to be cached, got Cache-Control %q", cc)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const (
+	// ChecksumRequestHeader is set by clients which want the served payload
+	// to be accompanied by its recomputed swarm root hash
+	ChecksumRequestHeader = "X-Swarm-Checksum"
+	// ChecksumTrailer is the HTTP trailer carrying the swarm root hash
+	// recomputed over the bytes actually written to the response body
+	ChecksumTrailer = "X-Swarm-Root-Hash"
+)
+
+// hashOnlyChunkStore is a ChunkStore which does not store anything.
+// It is used to run the chunker over a response body only to obtain its root hash.
+type hashOnlyChunkStore struct{}
+
+func (hashOnlyChunkStore) Put(*storage.Chunk) {}
+
+func (hashOnlyChunkStore) Get(storage.Key) (*storage.Chunk, error) {
+	return nil, storage.ErrChunkNotFound
+}
+
+func (hashOnlyChunkStore) Close() {}
+
+// checksumResponseWriter passes the response body to the chunker while it is
+// being written, so that the root hash of the served payload can be sent to
+// the client as a trailer once the body is complete
+type checksumResponseWriter struct {
+	http.ResponseWriter
+	pw      *io.PipeWriter
+	written int64
+	keyC    chan storage.Key
+}
+
+func newChecksumResponseWriter(w http.ResponseWriter) *checksumResponseWriter {
+	pr, pw := io.Pipe()
+	cw := &checksumResponseWriter{
+		ResponseWriter: w,
+		pw:             pw,
+		keyC:           make(chan storage.Key, 1),
+	}
+	go func() {
+		dpa := storage.NewDPA(hashOnlyChunkStore{}, storage.NewDPAParams())
+		key, _, err := dpa.Store(pr, -1, false)
+		if err != nil {
+			log.Warn("checksum: could not hash response body", "err", err)
+			pr.CloseWithError(err)
+			key = nil
+		}
+		cw.keyC <- key
+	}()
+	return cw
+}
+
+// WriteHeader drops the Content-Length header set by http.ServeContent, since
+// trailers are only sent using chunked transfer encoding
+func (cw *checksumResponseWriter) WriteHeader(code int) {
+	cw.Header().Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *checksumResponseWriter) Write(b []byte) (int, error) {
+	n, err := cw.ResponseWriter.Write(b)
+	if n > 0 {
+		cw.written += int64(n)
+		cw.pw.Write(b[:n])
+	}
+	return n, err
+}
+
+// close terminates the hashing of the response body and sets the root hash trailer
+func (cw *checksumResponseWriter) close() {
+	cw.pw.Close()
+	key := <-cw.keyC
+	if key == nil || cw.written == 0 {
+		return
+	}
+	cw.Header().Set(ChecksumTrailer, key.Hex())
+}
+
+// wantsChecksum returns true if the client opted in to the root hash trailer
+// and the full payload is going to be served (the recomputed hash would not
+// match the content key for partial or encrypted content)
+func wantsChecksum(r *Request, isEncrypted bool) bool {
+	if isEncrypted || r.Method != http.MethodGet || r.Header.Get("Range") != "" {
+		return false
+	}
+	ok, _ := strconv.ParseBool(r.Header.Get(ChecksumRequestHeader))
+	return ok
+}
+
+// serveContent serves the content of the reader using http.ServeContent,
+// adding the root hash trailer if the client requested it. The whole content
+// is streamed from its chunks if possible, see streamContent.
+func serveContent(w http.ResponseWriter, r *Request, modtime time.Time, reader io.ReadSeeker, isEncrypted bool) {
+	if !wantsChecksum(r, isEncrypted) {
+		if !streamContent(w, r, modtime, reader) {
+			http.ServeContent(w, &r.Request, "", modtime, reader)
+		}
+		return
+	}
+	w.Header().Set("Trailer", ChecksumTrailer)
+	cw := newChecksumResponseWriter(w)
+	if !streamContent(cw, r, modtime, reader) {
+		http.ServeContent(cw, &r.Request, "", modtime, reader)
+	}
+	cw.close()
+}
+
+// streamContent writes the whole content of readers implementing io.WriterTo,
+// like storage.LazyChunkReader, to the response without the copy buffer of
+// http.ServeContent. It returns false for the requests left to
+// http.ServeContent: HEAD, range and conditional requests, and content
+// without a content type.
+func streamContent(w http.ResponseWriter, r *Request, modtime time.Time, reader io.ReadSeeker) bool {
+	wt, ok := reader.(io.WriterTo)
+	if !ok || r.Method != http.MethodGet || w.Header().Get("Content-Type") == "" {
+		return false
+	}
+	for _, header := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		if r.Header.Get(header) != "" {
+			return false
+		}
+	}
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := wt.WriteTo(w); err != nil {
+		log.Warn("streaming content failed", "ruid", r.ruid, "err", err)
+	}
+	return true
+}
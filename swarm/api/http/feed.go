@@ -0,0 +1,289 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const (
+	feedFormatRSS  = "rss"
+	feedFormatAtom = "atom"
+
+	atomNamespace = "http://www.w3.org/2005/Atom"
+)
+
+var (
+	getResourceFeedCount = metrics.NewRegisteredCounter("api.http.get.resource.feed.count", nil)
+	getResourceFeedFail  = metrics.NewRegisteredCounter("api.http.get.resource.feed.fail", nil)
+	getTimelineCount     = metrics.NewRegisteredCounter("api.http.get.timeline.count", nil)
+	getTimelineFail      = metrics.NewRegisteredCounter("api.http.get.timeline.fail", nil)
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link,omitempty"`
+	Description string  `xml:"description,omitempty"`
+	Author      string  `xml:"author,omitempty"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    *atomLink   `xml:"link,omitempty"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Summary *atomText   `xml:"summary,omitempty"`
+	Content *atomText   `xml:"content,omitempty"`
+}
+
+// handleGetResourceFeed renders the history of a mutable resource as an RSS or Atom feed:
+// bzz-resource://<id>/rss
+// bzz-resource://<id>/atom
+// the number of updates is given with the limit query parameter
+func (s *Server) handleGetResourceFeed(w http.ResponseWriter, r *Request, key storage.Key, format string) {
+	log.Debug("handle.get.resource.feed", "ruid", r.ruid, "format", format)
+	getResourceFeedCount.Inc(1)
+
+	var limit int
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			getResourceFeedFail.Inc(1)
+			Respond(w, r, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	name, entries, err := s.api.ResourceHistory(r.Context(), key, limit)
+	if err != nil {
+		getResourceFeedFail.Inc(1)
+		code, err2 := s.translateResourceError(w, r, "mutable resource history fail", err)
+		Respond(w, r, err2.Error(), code)
+		return
+	}
+
+	link := fmt.Sprintf("bzz-resource:/%s", r.uri.Addr)
+	var feed interface{}
+	var contentType string
+	if format == feedFormatAtom {
+		feed, contentType = newAtomFeed(name, link, entries), "application/atom+xml; charset=utf-8"
+	} else {
+		feed, contentType = newRSSFeed(name, link, entries), "application/rss+xml; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		log.Error("resource feed encoding failed", "ruid", r.ruid, "err", err)
+	}
+}
+
+// HandleGetTimeline handles a GET request to
+// bzz-timeline:/?feed=<feed>&feed=<feed>...
+// and responds with the merged updates of the feeds as JSON, see
+// api.Api.ResourceTimeline. A feed is the namehash of a resource or the name
+// of a topic feed, optionally with a cursor in the form <feed>@<period>.<version>.
+// The next page is requested with the feeds returned in next. The number of
+// updates is given with the limit query parameter.
+func (s *Server) HandleGetTimeline(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.get.timeline", "ruid", r.ruid)
+	getTimelineCount.Inc(1)
+
+	query := r.URL.Query()
+	var limit int
+	if v := query.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			getTimelineFail.Inc(1)
+			Respond(w, r, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	var feeds []*api.ResourceTimelineFeed
+	for _, v := range query["feed"] {
+		feed, err := api.ParseResourceTimelineFeed(v)
+		if err != nil {
+			getTimelineFail.Inc(1)
+			Respond(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		feeds = append(feeds, feed)
+	}
+	if len(feeds) == 0 {
+		getTimelineFail.Inc(1)
+		Respond(w, r, "missing feed query parameter", http.StatusBadRequest)
+		return
+	}
+	timeline, err := s.api.ResourceTimeline(r.Context(), feeds, limit)
+	if err != nil {
+		getTimelineFail.Inc(1)
+		code, err2 := s.translateResourceError(w, r, "resource timeline fail", err)
+		Respond(w, r, err2.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// updateLink returns the link of the given update of the resource
+func updateLink(link string, entry *api.ResourceHistoryEntry) string {
+	return fmt.Sprintf("%s/%d/%d", link, entry.Period, entry.Version)
+}
+
+func newRSSFeed(name, link string, entries []*api.ResourceHistoryEntry) *rssFeed {
+	feed := &rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       name,
+			Link:        link,
+			Description: fmt.Sprintf("Updates of mutable resource %s", name),
+		},
+	}
+	for i, entry := range entries {
+		env := entry.Envelope()
+		item := rssItem{
+			Title:       env.Title,
+			Link:        env.Link,
+			Description: env.Summary,
+			Author:      env.Author,
+			GUID:        rssGUID{Value: updateLink(link, entry)},
+		}
+		if item.Description == "" {
+			item.Description = env.Content
+		}
+		if !env.Date.IsZero() {
+			item.PubDate = env.Date.UTC().Format(time.RFC1123Z)
+			if i == 0 {
+				feed.Channel.LastBuildDate = item.PubDate
+			}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	return feed
+}
+
+func newAtomFeed(name, link string, entries []*api.ResourceHistoryEntry) *atomFeed {
+	// atom requires an updated date; undated updates inherit the date of the
+	// closest dated one, or the time of rendering if there is none
+	updated := time.Now().UTC()
+	for _, entry := range entries {
+		if env := entry.Envelope(); !env.Date.IsZero() {
+			updated = env.Date.UTC()
+			break
+		}
+	}
+	feed := &atomFeed{
+		Xmlns:   atomNamespace,
+		ID:      link,
+		Title:   name,
+		Updated: updated.Format(time.RFC3339),
+		Link:    atomLink{Href: link, Rel: "self"},
+	}
+	date := updated
+	for _, entry := range entries {
+		env := entry.Envelope()
+		if !env.Date.IsZero() {
+			date = env.Date.UTC()
+		}
+		e := atomEntry{
+			ID:      updateLink(link, entry),
+			Title:   env.Title,
+			Updated: date.Format(time.RFC3339),
+		}
+		if env.Link != "" {
+			e.Link = &atomLink{Href: env.Link}
+		}
+		if env.Author != "" {
+			e.Author = &atomAuthor{Name: env.Author}
+		}
+		if env.Summary != "" {
+			e.Summary = &atomText{Value: env.Summary}
+		}
+		if env.Content != "" {
+			e.Content = &atomText{Type: atomContentType(env.ContentType), Value: env.Content}
+		}
+		feed.Entries = append(feed.Entries, e)
+	}
+	return feed
+}
+
+// atomContentType maps a mime type to the atom text construct type
+func atomContentType(mimeType string) string {
+	switch mimeType {
+	case "text/html", "application/xhtml+xml":
+		return "html"
+	}
+	return "text"
+}
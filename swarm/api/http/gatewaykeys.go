@@ -0,0 +1,259 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/api"
+)
+
+// GatewayKeyHeader carries the API key of a request to a gateway issuing
+// keys, see api.GatewayKeys
+const GatewayKeyHeader = "X-Swarm-Api-Key"
+
+// counts the bytes read from a request body, and fails reads beyond the
+// upload quota of the API key if limit is not negative
+type countingReader struct {
+	io.ReadCloser
+	n     int64
+	limit int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.limit >= 0 {
+		if c.n >= c.limit {
+			// the body may end exactly at the quota
+			var b [1]byte
+			if n, err := c.ReadCloser.Read(b[:]); n == 0 {
+				return 0, err
+			}
+			return 0, api.ErrGatewayQuotaExceeded
+		}
+		if int64(len(p)) > c.limit-c.n {
+			p = p[:c.limit-c.n]
+		}
+	}
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// checkGatewayKey authorizes the API key of the request if the gateway
+// issues keys, and reports whether the request may proceed. Write requests
+// need a key, other requests are counted against the key they carry. The
+// body of write requests is counted, and reading it fails with
+// api.ErrGatewayQuotaExceeded once it exceeds the upload quota, whether or
+// not its length is known in advance.
+//
+// Requests to bzz-keys are not checked, the gateway only issues keys if the
+// admin API is served on a listener of its own, see StartHttpServer, and
+// HandleGatewayKeys only serves them there.
+func (s *Server) checkGatewayKey(w http.ResponseWriter, r *Request) bool {
+	keys := s.api.GatewayKeys()
+	if keys == nil || r.uri.GatewayKeys() {
+		return true
+	}
+	write := uriSurface(r.Method, r.uri) == SurfaceWrite
+	token := r.Header.Get(GatewayKeyHeader)
+	if token == "" {
+		if !write {
+			return true
+		}
+		Respond(w, r, fmt.Sprintf("missing %s header, an API key is required", GatewayKeyHeader), http.StatusUnauthorized)
+		return false
+	}
+	id, err := keys.Authorize(token)
+	if err != nil {
+		respondGatewayKeyError(w, r, err)
+		return false
+	}
+	r.gatewayKey = id
+	if !write {
+		return true
+	}
+	if r.ContentLength > 0 {
+		if err := keys.CheckUpload(id, r.ContentLength); err != nil {
+			respondGatewayKeyError(w, r, err)
+			return false
+		}
+	}
+	limit, err := keys.UploadAllowance(id)
+	if err != nil {
+		respondGatewayKeyError(w, r, err)
+		return false
+	}
+	r.uploaded = &countingReader{ReadCloser: r.Body, limit: limit}
+	r.Body = r.uploaded
+	return true
+}
+
+// chargeGatewayKey counts the bytes uploaded by a successful write request
+// against its API key
+func (s *Server) chargeGatewayKey(w *loggingResponseWriter, r *Request) {
+	if r.uploaded == nil || r.uploaded.n == 0 || w.statusCode < 200 || w.statusCode > 299 {
+		return
+	}
+	if err := s.api.GatewayKeys().AddUpload(r.gatewayKey, r.uploaded.n); err != nil {
+		log.Warn("could not count upload of API key", "ruid", r.ruid, "id", r.gatewayKey, "err", err)
+	}
+}
+
+// checkGatewayResource reports whether the API key of the request may
+// create another resource
+func (s *Server) checkGatewayResource(w http.ResponseWriter, r *Request) bool {
+	if r.gatewayKey == "" {
+		return true
+	}
+	if err := s.api.GatewayKeys().CheckResource(r.gatewayKey); err != nil {
+		respondGatewayKeyError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// addGatewayResource counts a resource created with the API key of the
+// request
+func (s *Server) addGatewayResource(r *Request) {
+	if r.gatewayKey == "" {
+		return
+	}
+	if err := s.api.GatewayKeys().AddResource(r.gatewayKey); err != nil {
+		log.Warn("could not count resource of API key", "ruid", r.ruid, "id", r.gatewayKey, "err", err)
+	}
+}
+
+func respondGatewayKeyError(w http.ResponseWriter, r *Request, err error) {
+	switch err {
+	case api.ErrGatewayKeyAuth:
+		Respond(w, r, err.Error(), http.StatusUnauthorized)
+	case api.ErrGatewayRateLimited:
+		w.Header().Set("Retry-After", "60")
+		Respond(w, r, err.Error(), http.StatusTooManyRequests)
+	case api.ErrGatewayKeyDisabled, api.ErrGatewayQuotaExceeded:
+		Respond(w, r, err.Error(), http.StatusForbidden)
+	default:
+		Respond(w, r, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// gatewayKeyUpdate is the body of a PUT request to bzz-keys:/<user>/<id>,
+// fields which are not given are not changed
+type gatewayKeyUpdate struct {
+	Quota    *api.GatewayQuota `json:"quota"`
+	Disabled *bool             `json:"disabled"`
+}
+
+// HandleGatewayKeys handles the requests to bzz-keys: which manage the API
+// keys of the gateway and report their usage.
+//
+// GET bzz-keys:/ lists the keys of all users with their quota and usage,
+// bzz-keys:/<user> the keys of a user and bzz-keys:/<user>/<id> a single key.
+// POST bzz-keys:/<user> issues a key for the user with the quota in the JSON
+// body, if any, and returns it with its token, which is not shown again.
+// PUT bzz-keys:/<user>/<id> changes the quota of the key or disables it, see
+// gatewayKeyUpdate, and DELETE revokes it.
+func (s *Server) HandleGatewayKeys(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.gateway.keys", "ruid", r.ruid, "method", r.Method, "user", r.uri.Addr, "id", r.uri.Path)
+	if !checkAdmin(w, r) {
+		return
+	}
+	keys := s.api.GatewayKeys()
+	if keys == nil {
+		Respond(w, r, api.ErrGatewayKeysDisabled.Error(), http.StatusNotImplemented)
+		return
+	}
+	user, id := r.uri.Addr, r.uri.Path
+	if strings.Contains(id, "/") {
+		Respond(w, r, fmt.Sprintf("invalid path %q", id), http.StatusNotFound)
+		return
+	}
+	// the key must belong to the user of the path
+	if id != "" {
+		info, err := keys.Info(id)
+		if err != nil || info.User != user {
+			Respond(w, r, api.ErrGatewayKeyNotFound.Error(), http.StatusNotFound)
+			return
+		}
+	}
+
+	switch {
+	case r.Method == "GET" && id == "":
+		w.Header().Set("Content-Type", "application/json")
+		infos := keys.Keys(user)
+		if infos == nil {
+			infos = []*api.GatewayKeyInfo{}
+		}
+		json.NewEncoder(w).Encode(infos)
+
+	case r.Method == "GET":
+		info, _ := keys.Info(id)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+
+	case r.Method == "POST" && id == "":
+		var quota api.GatewayQuota
+		if err := json.NewDecoder(r.Body).Decode(&quota); err != nil && err != io.EOF {
+			Respond(w, r, fmt.Sprintf("invalid quota: %s", err), http.StatusBadRequest)
+			return
+		}
+		info, err := keys.Create(user, quota)
+		if err != nil {
+			Respond(w, r, fmt.Sprintf("cannot create API key of %s: %s", user, err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+
+	case r.Method == "PUT" && id != "":
+		var update gatewayKeyUpdate
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			Respond(w, r, fmt.Sprintf("invalid update: %s", err), http.StatusBadRequest)
+			return
+		}
+		info, err := keys.Info(id)
+		if update.Quota != nil && err == nil {
+			info, err = keys.SetQuota(id, *update.Quota)
+		}
+		if update.Disabled != nil && err == nil {
+			info, err = keys.SetDisabled(id, *update.Disabled)
+		}
+		if err != nil {
+			Respond(w, r, fmt.Sprintf("cannot update API key %s: %s", id, err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+
+	case r.Method == "DELETE" && id != "":
+		if err := keys.Revoke(id); err != nil {
+			Respond(w, r, fmt.Sprintf("cannot revoke API key %s: %s", id, err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, id)
+
+	default:
+		Respond(w, r, fmt.Sprintf("%s method to %s not allowed", r.Method, r.uri), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const (
+	// NotarizeRequestHeader is set by clients which want the root hash of
+	// their upload or resource update to be recorded on the chain
+	NotarizeRequestHeader = "X-Swarm-Notarize"
+	// NotarizationHeader carries the hash of the notarization transaction
+	NotarizationHeader = "X-Swarm-Notarization"
+)
+
+// checkNotarize reports whether the request may ask for a notarization.
+// Notarizations are paid by the node account, so only requests with an API
+// key of the gateway or received on a listener serving the admin API may ask
+// for them.
+func (s *Server) checkNotarize(w http.ResponseWriter, r *Request) bool {
+	if notarize, _ := strconv.ParseBool(r.Header.Get(NotarizeRequestHeader)); !notarize {
+		return true
+	}
+	if r.gatewayKey == "" && listenerSurface(&r.Request)&SurfaceAdmin == 0 {
+		Respond(w, r, fmt.Sprintf("%s requires an API key or the admin API", NotarizeRequestHeader), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// notarize records the key on the chain if the client asked for it and sets
+// the hash of the transaction in the response header. If the notarization
+// fails, an error is responded and false is returned, the content is stored
+// nonetheless.
+func (s *Server) notarize(w http.ResponseWriter, r *Request, key storage.Key) bool {
+	if notarize, _ := strconv.ParseBool(r.Header.Get(NotarizeRequestHeader)); !notarize {
+		return true
+	}
+	tx, err := s.api.Notarize(r.Context(), key)
+	if err != nil {
+		log.Warn("notarization failed", "ruid", r.ruid, "key", key, "err", err)
+		Respond(w, r, fmt.Sprintf("stored %s, but could not notarize it: %v", key, err), http.StatusInternalServerError)
+		return false
+	}
+	w.Header().Set(NotarizationHeader, tx.Hex())
+	return true
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// HandleGetPins handles a GET request to bzz-pin:/ and returns the hashes of
+// all pinned content as a JSON array
+func (s *Server) HandleGetPins(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.get.pins", "ruid", r.ruid)
+	if !checkAdmin(w, r) {
+		return
+	}
+	keys, err := s.api.Pinned()
+	if err != nil {
+		Respond(w, r, fmt.Sprintf("cannot list pins: %s", err), http.StatusInternalServerError)
+		return
+	}
+	hashes := make([]string, len(keys))
+	for i, key := range keys {
+		hashes[i] = key.Hex()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hashes)
+}
+
+// HandlePin handles a POST request to bzz-pin:/<addr> which pins the content
+// in the local store, and a DELETE request which releases the pin
+func (s *Server) HandlePin(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.pin", "ruid", r.ruid, "method", r.Method, "addr", r.uri.Addr)
+	if !checkAdmin(w, r) {
+		return
+	}
+	if r.uri.Addr == "" {
+		Respond(w, r, "missing hash or name of the content to pin", http.StatusBadRequest)
+		return
+	}
+	key, err := s.api.Resolve(r.uri)
+	if err != nil {
+		Respond(w, r, fmt.Sprintf("cannot resolve %s: %s", r.uri.Addr, err), http.StatusNotFound)
+		return
+	}
+	if r.Method == "DELETE" {
+		err = s.api.Unpin(key)
+	} else {
+		err = s.api.Pin(key)
+	}
+	switch {
+	case err == storage.ErrNotPinned:
+		Respond(w, r, err.Error(), http.StatusNotFound)
+	case err != nil:
+		Respond(w, r, fmt.Sprintf("cannot pin %s: %s", r.uri.Addr, err), http.StatusInternalServerError)
+	default:
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, key.Hex())
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/api"
+)
+
+const (
+	// PowNonceHeader carries the proof-of-work stamp of a write request
+	PowNonceHeader = "X-Swarm-Pow-Nonce"
+	// PowTimeHeader carries the unix time in seconds the stamp was made at
+	PowTimeHeader = "X-Swarm-Pow-Time"
+	// PowHashHeader carries the hex encoded keccak256 hash of the request
+	// body the stamp was made for
+	PowHashHeader = "X-Swarm-Pow-Hash"
+	// PowDifficultyHeader tells clients the difficulty required by the endpoint
+	PowDifficultyHeader = "X-Swarm-Pow-Difficulty"
+
+	// maxPowStamps is the number of accepted stamps remembered to reject
+	// replays, it bounds the memory used while stamps are within the window,
+	// new stamps are rejected while it is reached
+	maxPowStamps = 100000
+)
+
+var (
+	powRejectCount = metrics.NewRegisteredCounter("api.http.pow.reject.count", nil)
+
+	errPowPayload    = errors.New("request body does not match the hash of its proof of work")
+	errPowStampUsed  = errors.New("proof of work already used")
+	errPowStampsFull = errors.New("too many proofs of work within their window, try again later")
+)
+
+// RequireProofOfWork requires write requests on the given scheme to carry a
+// proof-of-work stamp of the given difficulty over the digest of the request
+// body (see api.VerifyPow). A difficulty of 0 disables the requirement.
+func (s *Server) RequireProofOfWork(scheme string, difficulty uint8) {
+	if difficulty == 0 {
+		delete(s.pow, scheme)
+		return
+	}
+	s.pow[scheme] = difficulty
+}
+
+// checkPow verifies the stamp of the request if its scheme requires one,
+// and reports whether the request may proceed. The stamp is verified for the
+// hash of the body given in its header, the body is not buffered but checked
+// against the hash as the handlers read it, see powReader. Authenticated
+// requests need no stamp, see authenticated.
+func (s *Server) checkPow(w http.ResponseWriter, r *Request) bool {
+	difficulty, ok := s.pow[r.uri.Scheme]
+	if !ok || s.authenticated(r) {
+		return true
+	}
+	reject := func(msg string, code int) bool {
+		powRejectCount.Inc(1)
+		log.Debug("proof of work rejected", "ruid", r.ruid, "scheme", r.uri.Scheme, "msg", msg)
+		w.Header().Set(PowDifficultyHeader, strconv.Itoa(int(difficulty)))
+		Respond(w, r, msg, code)
+		return false
+	}
+
+	nonce, err := strconv.ParseUint(r.Header.Get(PowNonceHeader), 10, 64)
+	if err != nil {
+		return reject(fmt.Sprintf("missing or invalid %s header, proof of work of difficulty %d required", PowNonceHeader, difficulty), http.StatusForbidden)
+	}
+	timestamp, err := strconv.ParseInt(r.Header.Get(PowTimeHeader), 10, 64)
+	if err != nil {
+		return reject(fmt.Sprintf("missing or invalid %s header, proof of work of difficulty %d required", PowTimeHeader, difficulty), http.StatusForbidden)
+	}
+	if offset := time.Since(time.Unix(timestamp, 0)); offset > api.PowStampWindow || offset < -api.PowStampWindow {
+		return reject(fmt.Sprintf("proof of work expired, stamps must be made within %v", api.PowStampWindow), http.StatusForbidden)
+	}
+	bodyHash, err := hexutil.Decode(r.Header.Get(PowHashHeader))
+	if err != nil || len(bodyHash) != 32 {
+		return reject(fmt.Sprintf("missing or invalid %s header, proof of work of difficulty %d required", PowHashHeader, difficulty), http.StatusForbidden)
+	}
+	digest := api.PowDigestHash(bodyHash, timestamp)
+	if !api.VerifyPow(digest, nonce, difficulty) {
+		return reject(fmt.Sprintf("invalid proof of work, difficulty %d required", difficulty), http.StatusForbidden)
+	}
+	stamp := fmt.Sprintf("%x:%d", digest, nonce)
+	if err := s.powStamps.add(stamp, timestamp, time.Now().Unix()); err == errPowStampsFull {
+		return reject(err.Error(), http.StatusServiceUnavailable)
+	} else if err != nil {
+		return reject(err.Error(), http.StatusForbidden)
+	}
+	r.Body = &powReader{ReadCloser: r.Body, hasher: sha3.NewKeccak256(), hash: bodyHash}
+	return true
+}
+
+// checks that a request body matches the hash its stamp was made for, the
+// read of its end fails with errPowPayload otherwise
+type powReader struct {
+	io.ReadCloser
+	hasher hash.Hash
+	hash   []byte
+}
+
+func (p *powReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.hasher.Write(b[:n])
+	if err == io.EOF && !bytes.Equal(p.hasher.Sum(nil), p.hash) {
+		return n, errPowPayload
+	}
+	return n, err
+}
+
+// powStamps remembers the accepted stamps while they are within
+// api.PowStampWindow to reject replays. Stamps are kept in buckets by their
+// time, the buckets are dropped as a whole once all their stamps expired.
+type powStamps struct {
+	lock    sync.Mutex
+	window  int64                         // seconds
+	limit   int                           // stamps remembered at most
+	count   int                           // stamps remembered
+	buckets map[int64]map[string]struct{} // stamps by time divided by window
+}
+
+func newPowStamps(limit int) *powStamps {
+	return &powStamps{
+		window:  int64(api.PowStampWindow / time.Second),
+		limit:   limit,
+		buckets: make(map[int64]map[string]struct{}),
+	}
+}
+
+// add remembers a stamp made at the given unix time. It fails with
+// errPowStampUsed if the stamp was added already and with errPowStampsFull
+// if the limit of stamps within the window is reached.
+func (p *powStamps) add(stamp string, timestamp, now int64) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for b, stamps := range p.buckets {
+		// the last stamp of the bucket is older than the window
+		if now-(b+1)*p.window > p.window {
+			p.count -= len(stamps)
+			delete(p.buckets, b)
+		}
+	}
+	b := timestamp / p.window
+	stamps, ok := p.buckets[b]
+	if _, used := stamps[stamp]; used {
+		return errPowStampUsed
+	}
+	if p.count >= p.limit {
+		return errPowStampsFull
+	}
+	if !ok {
+		stamps = make(map[string]struct{})
+		p.buckets[b] = stamps
+	}
+	stamps[stamp] = struct{}{}
+	p.count++
+	return nil
+}
+
+// authenticated reports whether the request carries a valid API key of the
+// gateway or the credentials of a managed publisher key. Proof of work
+// throttles anonymous writes, the writes of known users are accounted for
+// by their keys.
+func (s *Server) authenticated(r *Request) bool {
+	if r.gatewayKey != "" {
+		return true
+	}
+	signers := s.api.ManagedSigners()
+	user, token, ok := managedCredentials(r)
+	if signers == nil || !ok {
+		return false
+	}
+	_, err := signers.Info(user, token)
+	return err == nil
+}
+
+// isWrite reports whether the method of a request writes to swarm and so
+// needs a stamp on schemes requiring proof of work
+func isWrite(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return true
+}
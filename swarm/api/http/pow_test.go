@@ -0,0 +1,236 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
+	"github.com/ethereum/go-ethereum/swarm/testutil"
+)
+
+// TestBzzProofOfWork tests that writes on schemes requiring a stamp are only
+// accepted with a valid stamp, and that other schemes are not affected
+func TestBzzProofOfWork(t *testing.T) {
+	const difficulty = 8
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		s := NewServer(a)
+		s.RequireProofOfWork("bzz-raw", difficulty)
+		return s
+	})
+	defer srv.Close()
+
+	data := []byte("spam or ham")
+	body := data
+	send := func(method, nonce string, timestamp int64) *http.Response {
+		req, err := http.NewRequest(method, srv.URL+"/bzz-raw:/", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonce != "" {
+			req.Header.Set(PowNonceHeader, nonce)
+			req.Header.Set(PowTimeHeader, strconv.FormatInt(timestamp, 10))
+			req.Header.Set(PowHashHeader, hexutil.Encode(crypto.Keccak256(data)))
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	now := time.Now().Unix()
+
+	for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+		res := send(method, "", 0)
+		if res.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected status %d on %s without stamp, got %d", http.StatusForbidden, method, res.StatusCode)
+		}
+		if res.Header.Get(PowDifficultyHeader) != strconv.Itoa(difficulty) {
+			t.Fatalf("expected difficulty header %d, got %q", difficulty, res.Header.Get(PowDifficultyHeader))
+		}
+	}
+
+	digest := api.PowDigest(data, now)
+	nonce := api.SolvePow(digest, difficulty)
+	invalid := nonce + 1
+	for api.VerifyPow(digest, invalid, difficulty) {
+		invalid++
+	}
+	if res := send("POST", strconv.FormatUint(invalid, 10), now); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d with invalid stamp, got %d", http.StatusForbidden, res.StatusCode)
+	}
+	// a stamp is bound to its time
+	if res := send("POST", strconv.FormatUint(nonce, 10), now+1); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d with stamp of another time, got %d", http.StatusForbidden, res.StatusCode)
+	}
+	if res := send("POST", strconv.FormatUint(nonce, 10), now); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d with valid stamp, got %d", http.StatusOK, res.StatusCode)
+	}
+	// and can only be used once
+	if res := send("POST", strconv.FormatUint(nonce, 10), now); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d with replayed stamp, got %d", http.StatusForbidden, res.StatusCode)
+	}
+	// the body must match the hash the stamp was made for
+	body = []byte("spam and ham")
+	if res := send("POST", strconv.FormatUint(api.SolvePow(api.PowDigest(data, now-1), difficulty), 10), now-1); res.StatusCode == http.StatusOK {
+		t.Fatal("expected write of another body than the stamped one to fail")
+	}
+	body = data
+	// stamps outside the window are rejected
+	stale := now - int64(2*api.PowStampWindow/time.Second)
+	if res := send("POST", strconv.FormatUint(api.SolvePow(api.PowDigest(data, stale), difficulty), 10), stale); res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d with stale stamp, got %d", http.StatusForbidden, res.StatusCode)
+	}
+
+	// the client stamps its uploads, the payload differs from the one above
+	// since the same payload stamped in the same second yields the same stamp
+	data = []byte("ham or spam")
+	client := swarm.NewClient(srv.URL)
+	client.PowDifficulty = difficulty
+	hash, err := client.UploadRaw(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _, err := client.DownloadRaw(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("expected %q, got %q", data, got)
+	}
+
+	// other schemes do not require a stamp
+	client.PowDifficulty = 0
+	file := &swarm.File{
+		ReadCloser: ioutil.NopCloser(bytes.NewReader(data)),
+		ManifestEntry: api.ManifestEntry{
+			Path:        "ham.txt",
+			ContentType: "text/plain",
+			Size:        int64(len(data)),
+		},
+	}
+	if _, err := client.Upload(file, "", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBzzProofOfWorkAuthenticated tests that writes with an API key of the
+// gateway need no stamp
+func TestBzzProofOfWorkAuthenticated(t *testing.T) {
+	const difficulty = 8
+	keys := api.NewGatewayKeys(nil)
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		a.SetGatewayKeys(keys)
+		s := NewServer(a)
+		s.RequireProofOfWork("bzz-raw", difficulty)
+		return s
+	})
+	defer srv.Close()
+	info, err := keys.Create("alice", api.GatewayQuota{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(token string) *http.Response {
+		req, err := http.NewRequest("POST", srv.URL+"/bzz-raw:/", bytes.NewReader([]byte("ham")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(GatewayKeyHeader, token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	if res := send(info.Token); res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d with an API key and without stamp, got %d", http.StatusOK, res.StatusCode)
+	}
+	if res := send("invalid"); res.StatusCode == http.StatusOK {
+		t.Fatal("expected write with an invalid API key to fail")
+	}
+}
+
+func TestParsePowDifficulty(t *testing.T) {
+	difficulty, err := api.ParsePowDifficulty("bzz:20, bzz-raw:16,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(difficulty) != 2 || difficulty["bzz"] != 20 || difficulty["bzz-raw"] != 16 {
+		t.Fatalf("unexpected difficulty %v", difficulty)
+	}
+	for _, s := range []string{"bzz", "bzz:", ":20", "bzz:65", "bzz:x"} {
+		if _, err := api.ParsePowDifficulty(s); err == nil {
+			t.Fatalf("%q: expected error", s)
+		}
+	}
+}
+
+// TestPowStamps tests that stamps are remembered while they are within the
+// window, and that new stamps are rejected rather than old ones forgotten
+// once the limit is reached
+func TestPowStamps(t *testing.T) {
+	stamps := newPowStamps(2)
+	window := int64(api.PowStampWindow / time.Second)
+	now := time.Now().Unix()
+
+	if err := stamps.add("a", now, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := stamps.add("a", now, now); err != errPowStampUsed {
+		t.Fatalf("expected %v, got %v", errPowStampUsed, err)
+	}
+	if err := stamps.add("b", now-window, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := stamps.add("c", now, now); err != errPowStampsFull {
+		t.Fatalf("expected %v, got %v", errPowStampsFull, err)
+	}
+	// a full set still rejects replays
+	if err := stamps.add("b", now-window, now); err != errPowStampUsed {
+		t.Fatalf("expected %v, got %v", errPowStampUsed, err)
+	}
+
+	// once b expired there is room for c, while a is still remembered
+	later := now + window + 1
+	if err := stamps.add("c", later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := stamps.add("a", now, later); err != errPowStampUsed {
+		t.Fatalf("expected %v, got %v", errPowStampUsed, err)
+	}
+	// and is forgotten once it expired too
+	later = now + 3*window
+	if err := stamps.add("a", later, later); err != nil {
+		t.Fatal(err)
+	}
+}
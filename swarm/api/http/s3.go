@@ -0,0 +1,575 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"github.com/pborman/uuid"
+)
+
+/*
+S3Server is an optional facade offering a subset of the Amazon S3 REST API
+on top of swarm manifests, so that existing backup and sync tools can use
+swarm as their storage backend.
+
+Requests use path-style addressing (http://<addr>/<bucket>/<key>).
+Each bucket is bound to a manifest and objects are the entries of that
+manifest, with the object key as the manifest path. Since manifests are
+immutable, every write creates a new manifest and the bucket is rebound to
+it; the resulting manifest hash is returned in the X-Swarm-Manifest header
+and can be used with the bzz: scheme as usual.
+
+Supported operations are ListBuckets, CreateBucket (optionally bound to an
+existing manifest or ENS name given in the X-Swarm-Manifest header),
+DeleteBucket, HeadBucket, ListObjects (v1 and v2), PutObject, GetObject,
+HeadObject and DeleteObject. Requests are not authenticated and need no
+proof of work, so the facade must only be exposed to trusted clients: the
+node serves it on the host of the admin API, see Swarm.Start. Objects are
+streamed into swarm, up to the 5 GiB of a single S3 PutObject.
+*/
+type S3Server struct {
+	api   *api.Api
+	store state.Store
+	mu    sync.Mutex // serialises manifest updates of buckets
+}
+
+const (
+	s3ManifestHeader = "X-Swarm-Manifest"
+	s3BucketPrefix   = "s3-bucket-"
+	s3BucketsKey     = "s3-buckets"
+	s3XMLNamespace   = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3MaxKeys        = 1000
+	s3MaxObjectSize  = 5 << 30 // largest object of a single PutObject, as in S3
+)
+
+var (
+	s3RequestCount = metrics.NewRegisteredCounter("api.http.s3.request.count", nil)
+	s3RequestFail  = metrics.NewRegisteredCounter("api.http.s3.request.fail", nil)
+)
+
+// s3Bucket is the persisted binding of a bucket to a manifest
+type s3Bucket struct {
+	Name     string    `json:"name"`
+	Manifest string    `json:"manifest"`
+	Created  time.Time `json:"created"`
+}
+
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestId string   `xml:"RequestId"`
+}
+
+type s3BucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type s3ListAllMyBucketsResult struct {
+	XMLName xml.Name       `xml:"ListAllMyBucketsResult"`
+	Xmlns   string         `xml:"xmlns,attr"`
+	Owner   struct{}       `xml:"Owner"`
+	Buckets []s3BucketInfo `xml:"Buckets>Bucket"`
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListBucketResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	Marker                string           `xml:"Marker,omitempty"`
+	NextMarker            string           `xml:"NextMarker,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount,omitempty"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// NewS3Server creates an S3 facade persisting its bucket bindings in store
+func NewS3Server(api *api.Api, store state.Store) *S3Server {
+	return &S3Server{
+		api:   api,
+		store: store,
+	}
+}
+
+// StartS3Server starts the S3 facade on the given address, it returns an
+// error if the address cannot be listened on
+func StartS3Server(api *api.Api, store state.Store, addr string) error {
+	return listenAndServe("s3", addr, NewS3Server(api, store))
+}
+
+func (s *S3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s3RequestCount.Inc(1)
+	ruid := uuid.New()[:8]
+	log.Debug("s3 request", "ruid", ruid, "method", r.Method, "url", r.URL)
+	w.Header().Set("x-amz-request-id", ruid)
+
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	var bucket, key string
+	if i := strings.Index(p, "/"); i >= 0 {
+		bucket, key = p[:i], p[i+1:]
+	} else {
+		bucket = p
+	}
+
+	switch {
+	case bucket == "":
+		if r.Method != http.MethodGet {
+			s.error(w, r, ruid, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+			return
+		}
+		s.listBuckets(w, r, ruid)
+	case key == "":
+		s.handleBucket(w, r, ruid, bucket)
+	default:
+		s.handleObject(w, r, ruid, bucket, key)
+	}
+}
+
+func (s *S3Server) handleBucket(w http.ResponseWriter, r *http.Request, ruid, bucket string) {
+	switch r.Method {
+	case http.MethodPut:
+		s.createBucket(w, r, ruid, bucket)
+	case http.MethodDelete:
+		s.deleteBucket(w, r, ruid, bucket)
+	case http.MethodHead:
+		if _, err := s.getBucket(bucket); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		if _, ok := r.URL.Query()["uploads"]; ok {
+			s.error(w, r, ruid, http.StatusNotImplemented, "NotImplemented", "multipart uploads are not supported")
+			return
+		}
+		s.listObjects(w, r, ruid, bucket)
+	default:
+		s.error(w, r, ruid, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+func (s *S3Server) handleObject(w http.ResponseWriter, r *http.Request, ruid, bucket, key string) {
+	switch r.Method {
+	case http.MethodPut:
+		s.putObject(w, r, ruid, bucket, key)
+	case http.MethodGet, http.MethodHead:
+		s.getObject(w, r, ruid, bucket, key)
+	case http.MethodDelete:
+		s.deleteObject(w, r, ruid, bucket, key)
+	default:
+		s.error(w, r, ruid, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+	}
+}
+
+func (s *S3Server) listBuckets(w http.ResponseWriter, r *http.Request, ruid string) {
+	var names []string
+	if err := s.store.Get(s3BucketsKey, &names); err != nil && err != state.ErrNotFound {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	res := &s3ListAllMyBucketsResult{Xmlns: s3XMLNamespace}
+	for _, name := range names {
+		b, err := s.getBucket(name)
+		if err != nil {
+			continue
+		}
+		res.Buckets = append(res.Buckets, s3BucketInfo{
+			Name:         b.Name,
+			CreationDate: b.Created.UTC().Format(time.RFC3339),
+		})
+	}
+	s.respondXML(w, res)
+}
+
+func (s *S3Server) createBucket(w http.ResponseWriter, r *http.Request, ruid, bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getBucket(bucket); err == nil {
+		s.error(w, r, ruid, http.StatusConflict, "BucketAlreadyOwnedByYou", "bucket already exists")
+		return
+	}
+
+	// bind the bucket to the given manifest, or to a new empty one
+	var key storage.Key
+	var err error
+	if addr := r.Header.Get(s3ManifestHeader); addr != "" {
+		key, err = s.api.Resolve(&api.URI{Scheme: "bzz", Addr: addr})
+	} else {
+		key, err = s.api.NewManifest(false)
+	}
+	if err != nil {
+		s.error(w, r, ruid, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("cannot bind bucket to manifest: %v", err))
+		return
+	}
+
+	var names []string
+	if err := s.store.Get(s3BucketsKey, &names); err != nil && err != state.ErrNotFound {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	b := &s3Bucket{
+		Name:     bucket,
+		Manifest: key.Hex(),
+		Created:  time.Now(),
+	}
+	if err := s.store.Put(s3BucketPrefix+bucket, b); err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	names = append(names, bucket)
+	sort.Strings(names)
+	if err := s.store.Put(s3BucketsKey, names); err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	log.Debug("s3 bucket created", "ruid", ruid, "bucket", bucket, "manifest", b.Manifest)
+	w.Header().Set("Location", "/"+bucket)
+	w.Header().Set(s3ManifestHeader, b.Manifest)
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteBucket only removes the binding of the bucket, the content stays in swarm
+func (s *S3Server) deleteBucket(w http.ResponseWriter, r *http.Request, ruid, bucket string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.getBucket(bucket); err != nil {
+		s.error(w, r, ruid, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+	var names []string
+	if err := s.store.Get(s3BucketsKey, &names); err != nil && err != state.ErrNotFound {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	for i, name := range names {
+		if name == bucket {
+			names = append(names[:i], names[i+1:]...)
+			break
+		}
+	}
+	if err := s.store.Put(s3BucketsKey, names); err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	if err := s.store.Delete(s3BucketPrefix + bucket); err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *S3Server) listObjects(w http.ResponseWriter, r *http.Request, ruid, bucket string) {
+	b, err := s.getBucket(bucket)
+	if err != nil {
+		s.error(w, r, ruid, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	marker := query.Get("marker")
+	if query.Get("list-type") == "2" {
+		marker = query.Get("start-after")
+		if token := query.Get("continuation-token"); token != "" {
+			marker = token
+		}
+	}
+	maxKeys := s3MaxKeys
+	if v := query.Get("max-keys"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < s3MaxKeys {
+			maxKeys = n
+		}
+	}
+
+	walker, err := s.api.NewManifestWalker(common.Hex2Bytes(b.Manifest), nil)
+	if err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	var entries []*api.ManifestEntry
+	err = walker.Walk(func(entry *api.ManifestEntry) error {
+		if entry.ContentType == api.ManifestType {
+			return nil
+		}
+		if strings.HasPrefix(entry.Path, prefix) {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	res := &s3ListBucketResult{
+		Xmlns:     s3XMLNamespace,
+		Name:      bucket,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+	// the listing continues after the last key or common prefix of the
+	// previous page, common prefixes are not listed again
+	var last string
+	for _, entry := range entries {
+		key, common := entry.Path, false
+		if delimiter != "" {
+			suffix := strings.TrimPrefix(entry.Path, prefix)
+			if i := strings.Index(suffix, delimiter); i >= 0 {
+				key, common = prefix+suffix[:i+len(delimiter)], true
+			}
+		}
+		if key <= marker || (common && key == last) {
+			continue
+		}
+		if len(res.Contents)+len(res.CommonPrefixes) >= maxKeys {
+			res.IsTruncated = true
+			break
+		}
+		last = key
+		if common {
+			res.CommonPrefixes = append(res.CommonPrefixes, s3CommonPrefix{Prefix: key})
+			continue
+		}
+		res.Contents = append(res.Contents, s3Object{
+			Key:          entry.Path,
+			LastModified: entry.ModTime.UTC().Format(time.RFC3339),
+			ETag:         `"` + entry.Hash + `"`,
+			Size:         entry.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	if query.Get("list-type") == "2" {
+		res.KeyCount = len(res.Contents) + len(res.CommonPrefixes)
+		res.StartAfter = query.Get("start-after")
+		res.ContinuationToken = query.Get("continuation-token")
+		if res.IsTruncated {
+			res.NextContinuationToken = last
+		}
+	} else {
+		res.Marker = query.Get("marker")
+		if res.IsTruncated {
+			res.NextMarker = last
+		}
+	}
+	s.respondXML(w, res)
+}
+
+func (s *S3Server) putObject(w http.ResponseWriter, r *http.Request, ruid, bucket, key string) {
+	if r.Header.Get("x-amz-copy-source") != "" {
+		s.error(w, r, ruid, http.StatusNotImplemented, "NotImplemented", "object copy is not supported")
+		return
+	}
+	if r.ContentLength < 0 {
+		s.error(w, r, ruid, http.StatusLengthRequired, "MissingContentLength", "missing Content-Length header in request")
+		return
+	} else if r.ContentLength > s3MaxObjectSize {
+		s.error(w, r, ruid, http.StatusBadRequest, "EntityTooLarge", fmt.Sprintf("objects are limited to %d bytes", int64(s3MaxObjectSize)))
+		return
+	}
+	b, err := s.getBucket(bucket)
+	if err != nil {
+		s.error(w, r, ruid, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+	mw, err := s.api.NewManifestWriter(common.Hex2Bytes(b.Manifest), nil)
+	if err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	encrypted := mw.Encrypted()
+
+	// the body is streamed into swarm before the lock, so that slow clients
+	// do not hold up the writes of others
+	body := &countingReader{ReadCloser: http.MaxBytesReader(w, r.Body, r.ContentLength), limit: -1}
+	contentKey, wait, err := s.api.Store(body, r.ContentLength, encrypted)
+	if err == nil {
+		wait()
+	}
+	if err != nil || body.n != r.ContentLength {
+		s.error(w, r, ruid, http.StatusBadRequest, "IncompleteBody", "the request body is shorter than its Content-Length")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b, err = s.getBucket(bucket); err != nil {
+		s.error(w, r, ruid, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+	newKey, err := s.updateManifest(b, func(mw *api.ManifestWriter) error {
+		// the bucket may have been bound to another manifest meanwhile
+		if mw.Encrypted() != encrypted {
+			return errors.New("the encryption of the bucket changed during the upload")
+		}
+		mw.AddStoredEntry(contentKey, &api.ManifestEntry{
+			Path:        key,
+			ContentType: r.Header.Get("Content-Type"),
+			Mode:        0644,
+			Size:        r.ContentLength,
+			ModTime:     time.Now(),
+		})
+		return nil
+	})
+	if err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	log.Debug("s3 object stored", "ruid", ruid, "bucket", bucket, "key", key, "manifest", newKey)
+	w.Header().Set("ETag", `"`+contentKey.Hex()+`"`)
+	w.Header().Set(s3ManifestHeader, newKey.Hex())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *S3Server) getObject(w http.ResponseWriter, r *http.Request, ruid, bucket, key string) {
+	b, err := s.getBucket(bucket)
+	if err != nil {
+		s.error(w, r, ruid, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+	reader, contentType, status, contentKey, err := s.api.Get(common.Hex2Bytes(b.Manifest), key)
+	if err != nil || status == http.StatusMultipleChoices {
+		s.error(w, r, ruid, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+	if _, err := reader.Size(nil); err != nil {
+		s.error(w, r, ruid, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+		return
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+contentKey.Hex()+`"`)
+	w.Header().Set(s3ManifestHeader, b.Manifest)
+	http.ServeContent(w, r, "", time.Time{}, reader)
+}
+
+func (s *S3Server) deleteObject(w http.ResponseWriter, r *http.Request, ruid, bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, err := s.getBucket(bucket)
+	if err != nil {
+		s.error(w, r, ruid, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+	newKey, err := s.updateManifest(b, func(mw *api.ManifestWriter) error {
+		return mw.RemoveEntry(key)
+	})
+	if err != nil {
+		s.error(w, r, ruid, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set(s3ManifestHeader, newKey.Hex())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// updateManifest applies the update to the manifest of the bucket and rebinds
+// the bucket to the resulting manifest. It must be called with s.mu held.
+func (s *S3Server) updateManifest(b *s3Bucket, update func(mw *api.ManifestWriter) error) (storage.Key, error) {
+	mw, err := s.api.NewManifestWriter(common.Hex2Bytes(b.Manifest), nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(mw); err != nil {
+		return nil, err
+	}
+	key, err := mw.Store()
+	if err != nil {
+		return nil, err
+	}
+	b.Manifest = key.Hex()
+	if err := s.store.Put(s3BucketPrefix+b.Name, b); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *S3Server) getBucket(name string) (*s3Bucket, error) {
+	b := &s3Bucket{}
+	if err := s.store.Get(s3BucketPrefix+name, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *S3Server) respondXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.Error("s3 response encoding failed", "err", err)
+	}
+}
+
+func (s *S3Server) error(w http.ResponseWriter, r *http.Request, ruid string, code int, s3code, msg string) {
+	s3RequestFail.Inc(1)
+	log.Debug("s3 request failed", "ruid", ruid, "code", s3code, "msg", msg)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(&s3Error{
+		Code:      s3code,
+		Message:   msg,
+		Resource:  r.URL.Path,
+		RequestId: ruid,
+	})
+}
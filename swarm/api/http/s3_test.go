@@ -0,0 +1,259 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/testutil"
+)
+
+func s3Do(t *testing.T, method, url, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// TestS3Server tests the bucket and object lifecycle of the s3 facade
+func TestS3Server(t *testing.T) {
+	store := state.NewInmemoryStore()
+	var s3 *S3Server
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		s3 = NewS3Server(a, store)
+		return s3
+	})
+	defer srv.Close()
+
+	// objects cannot be put into a missing bucket
+	res := s3Do(t, "PUT", srv.URL+"/photos/a.txt", "foo")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, res.StatusCode)
+	}
+
+	res = s3Do(t, "PUT", srv.URL+"/photos", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("create bucket: expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	manifest := res.Header.Get(s3ManifestHeader)
+	if manifest == "" {
+		t.Fatal("create bucket: expected manifest header")
+	}
+
+	objects := map[string]string{
+		"a.txt":         "foo",
+		"dir/b.txt":     "bar",
+		"dir/sub/c.txt": "baz",
+	}
+	for key, content := range objects {
+		res = s3Do(t, "PUT", srv.URL+"/photos/"+key, content)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("put %s: expected status %d, got %d", key, http.StatusOK, res.StatusCode)
+		}
+		if res.Header.Get("ETag") == "" {
+			t.Fatalf("put %s: expected ETag", key)
+		}
+		if m := res.Header.Get(s3ManifestHeader); m == manifest {
+			t.Fatalf("put %s: expected bucket to be rebound to a new manifest", key)
+		} else {
+			manifest = m
+		}
+	}
+
+	// objects over the size limit are refused before their body is read, and
+	// bodies shorter than their length are not stored
+	for _, length := range []int64{s3MaxObjectSize + 1, 10} {
+		req := httptest.NewRequest("PUT", "/photos/broken", strings.NewReader("foo"))
+		req.ContentLength = length
+		rec := httptest.NewRecorder()
+		s3.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("put with length %d: expected status %d, got %d", length, http.StatusBadRequest, rec.Code)
+		}
+	}
+
+	for key, content := range objects {
+		res = s3Do(t, "GET", srv.URL+"/photos/"+key, "")
+		data, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("get %s: expected status %d, got %d", key, http.StatusOK, res.StatusCode)
+		}
+		if string(data) != content {
+			t.Fatalf("get %s: expected %q, got %q", key, content, data)
+		}
+	}
+
+	res = s3Do(t, "GET", srv.URL+"/photos?delimiter=/&prefix=dir/", "")
+	var list s3ListBucketResult
+	err := xml.NewDecoder(res.Body).Decode(&list)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Contents) != 1 || list.Contents[0].Key != "dir/b.txt" || list.Contents[0].Size != 3 {
+		t.Fatalf("unexpected contents %+v", list.Contents)
+	}
+	if len(list.CommonPrefixes) != 1 || list.CommonPrefixes[0].Prefix != "dir/sub/" {
+		t.Fatalf("unexpected common prefixes %+v", list.CommonPrefixes)
+	}
+
+	// listings are paged with the next marker or continuation token
+	for _, page := range []struct {
+		query    string
+		next     func(*s3ListBucketResult) string
+		param    string
+		expected string
+	}{
+		{"max-keys=1", func(l *s3ListBucketResult) string { return l.NextMarker }, "marker", "[a.txt dir/b.txt dir/sub/c.txt]"},
+		{"list-type=2&max-keys=1&delimiter=/", func(l *s3ListBucketResult) string { return l.NextContinuationToken }, "continuation-token", "[a.txt dir/]"},
+	} {
+		var keys []string
+		var token string
+		for i := 0; ; i++ {
+			if i > len(objects) {
+				t.Fatalf("%s: listing does not end", page.query)
+			}
+			u := srv.URL + "/photos?" + page.query
+			if token != "" {
+				u += "&" + page.param + "=" + url.QueryEscape(token)
+			}
+			res = s3Do(t, "GET", u, "")
+			var list s3ListBucketResult
+			err := xml.NewDecoder(res.Body).Decode(&list)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, object := range list.Contents {
+				keys = append(keys, object.Key)
+			}
+			for _, cp := range list.CommonPrefixes {
+				keys = append(keys, cp.Prefix)
+			}
+			if !list.IsTruncated {
+				break
+			}
+			if token = page.next(&list); token == "" {
+				t.Fatalf("%s: expected the token of the next page", page.query)
+			}
+		}
+		if fmt.Sprint(keys) != page.expected {
+			t.Fatalf("%s: expected %s, got %v", page.query, page.expected, keys)
+		}
+	}
+
+	res = s3Do(t, "DELETE", srv.URL+"/photos/a.txt", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: expected status %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	res = s3Do(t, "GET", srv.URL+"/photos/a.txt", "")
+	var s3err s3Error
+	err = xml.NewDecoder(res.Body).Decode(&s3err)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusNotFound || s3err.Code != "NoSuchKey" {
+		t.Fatalf("get deleted: expected NoSuchKey, got %d %q", res.StatusCode, s3err.Code)
+	}
+
+	// a second bucket can be bound to the manifest of the first one
+	req, err := http.NewRequest("PUT", srv.URL+"/backup", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(s3ManifestHeader, manifest)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("create bound bucket: expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	res = s3Do(t, "GET", srv.URL+"/backup/dir/sub/c.txt", "")
+	data, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "baz" {
+		t.Fatalf("get from bound bucket: expected %q, got %q", "baz", data)
+	}
+
+	res = s3Do(t, "GET", srv.URL+"/", "")
+	var buckets s3ListAllMyBucketsResult
+	err = xml.NewDecoder(res.Body).Decode(&buckets)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range buckets.Buckets {
+		names = append(names, b.Name)
+	}
+	if fmt.Sprint(names) != "[backup photos]" {
+		t.Fatalf("unexpected buckets %v", names)
+	}
+
+	res = s3Do(t, "DELETE", srv.URL+"/photos", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete bucket: expected status %d, got %d", http.StatusNoContent, res.StatusCode)
+	}
+	res = s3Do(t, "HEAD", srv.URL+"/photos", "")
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("head deleted bucket: expected status %d, got %d", http.StatusNotFound, res.StatusCode)
+	}
+}
+
+// TestStartS3ServerListenFail tests that the s3 facade fails to start if its
+// address cannot be listened on
+func TestStartS3ServerListenFail(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if err := StartS3Server(api.NewApi(nil, nil, nil), state.NewInmemoryStore(), l.Addr().String()); err == nil {
+		t.Fatal("expected startup to fail with the address in use")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// HandleSearch handles a GET request to bzz-search:/?q=<query> and returns
+// the files of the content uploaded to or pinned on the node matching the
+// query as a JSON array, best matches first. The search is restricted to a
+// manifest with bzz-search:/<addr>?q=<query>, the number of results with the
+// limit query parameter.
+func (s *Server) HandleSearch(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.search", "ruid", r.ruid, "addr", r.uri.Addr)
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		Respond(w, r, "missing query", http.StatusBadRequest)
+		return
+	}
+	var limit int
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			Respond(w, r, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	var root storage.Key
+	if r.uri.Addr != "" {
+		key, err := s.api.Resolve(r.uri)
+		if err != nil {
+			Respond(w, r, fmt.Sprintf("cannot resolve %s: %s", r.uri.Addr, err), http.StatusNotFound)
+			return
+		}
+		root = key
+	}
+	results, err := s.api.Search(query, root, limit)
+	switch {
+	case err == api.ErrSearchDisabled:
+		Respond(w, r, err.Error(), http.StatusNotImplemented)
+		return
+	case err != nil:
+		Respond(w, r, fmt.Sprintf("search failed: %s", err), http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []*api.SearchResult{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -29,12 +29,14 @@ import (
 	"io/ioutil"
 	"mime"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -42,6 +44,7 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/events"
 	"github.com/ethereum/go-ethereum/swarm/storage"
 	"github.com/pborman/uuid"
 	"github.com/rs/cors"
@@ -51,6 +54,16 @@ type resourceResponse struct {
 	Manifest storage.Key `json:"manifest"`
 	Resource string      `json:"resource"`
 	Update   storage.Key `json:"update"`
+	Period   uint32      `json:"period"`
+	Version  uint32      `json:"version"`
+}
+
+// resourceUpdateResponse is the json form of a mutable resource update returned
+// by GET requests accepting application/json
+type resourceUpdateResponse struct {
+	*api.ResourceUpdateInfo
+	Data  []byte                 `json:"data"`
+	Proof *storage.ResourceProof `json:"proof,omitempty"` // with the proof query parameter
 }
 
 var (
@@ -74,8 +87,13 @@ var (
 // ServerConfig is the basic configuration needed for the HTTP server and also
 // includes CORS settings.
 type ServerConfig struct {
-	Addr       string
-	CorsString string
+	Addr          string
+	CorsString    string
+	PowDifficulty map[string]uint8  // proof-of-work difficulty required for writes, by scheme
+	VirtualHosts  map[string]string // ENS name or manifest hash served on a host, see ParseVirtualHosts
+	TLS           *TLSConfig        // optional https listener
+	WriteAddr     string            // if set, the write API is served on this address instead of Addr
+	AdminAddr     string            // if set, the admin API is served on this address instead of Addr
 }
 
 // browser API for registering bzz url scheme handlers:
@@ -83,8 +101,8 @@ type ServerConfig struct {
 // electron (chromium) api for registering bzz url scheme handlers:
 // https://github.com/atom/electron/blob/master/docs/api/protocol.md
 
-// starts up http server
-func StartHttpServer(api *api.Api, config *ServerConfig) {
+// starts up http server, and the https server if TLS is configured
+func StartHttpServer(api *api.Api, config *ServerConfig) error {
 	var allowedOrigins []string
 	for _, domain := range strings.Split(config.CorsString, ",") {
 		allowedOrigins = append(allowedOrigins, strings.TrimSpace(domain))
@@ -95,25 +113,110 @@ func StartHttpServer(api *api.Api, config *ServerConfig) {
 		MaxAge:         600,
 		AllowedHeaders: []string{"*"},
 	})
-	hdlr := c.Handler(NewServer(api))
+	server := NewServer(api)
+	for scheme, difficulty := range config.PowDifficulty {
+		server.RequireProofOfWork(scheme, difficulty)
+	}
+	for host, target := range config.VirtualHosts {
+		server.SetVirtualHost(host, target)
+	}
+	// anyone reaching the admin API could issue API keys or publisher keys to themselves
+	if api.GatewayKeys() != nil && config.AdminAddr == "" {
+		return errors.New("gateway API keys require the admin API on a listener of its own")
+	} else if api.ManagedSigners() != nil && config.AdminAddr == "" {
+		return errors.New("managed publisher keys require the admin API on a listener of its own")
+	}
+	if config.TLS != nil && !config.TLS.Enabled() {
+		return errors.New("https listener without tls certificates")
+	}
+	// the write and admin APIs can be moved to listeners of their own
+	surfaces := SurfaceAll
+	if config.WriteAddr != "" {
+		surfaces &^= SurfaceWrite
+		if err := listenAndServe("write", config.WriteAddr, c.Handler(server.Surface(SurfaceWrite))); err != nil {
+			return err
+		}
+	}
+	if config.AdminAddr != "" {
+		surfaces &^= SurfaceAdmin
+		if err := listenAndServe("admin", config.AdminAddr, c.Handler(server.Surface(SurfaceAdmin))); err != nil {
+			return err
+		}
+	}
+	hdlr := c.Handler(server.Surface(surfaces))
+
+	if config.TLS.Enabled() {
+		tlsConfig, err := NewTLSConfig(config.TLS, config.VirtualHosts)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{
+			Addr:      config.TLS.Addr,
+			Handler:   hdlr,
+			TLSConfig: tlsConfig,
+		}
+		go func() {
+			if err := srv.ListenAndServeTLS("", ""); err != nil {
+				log.Error(fmt.Sprintf("https server failed: %v", err))
+			}
+		}()
+		// the certificate manager answers its challenges on the http listener
+		if config.TLS.Manager != nil {
+			hdlr = config.TLS.Manager.HTTPHandler(hdlr)
+		}
+	}
 
 	go http.ListenAndServe(config.Addr, hdlr)
+	return nil
+}
+
+// listens on addr right away, so that a listener which cannot be opened
+// fails the startup, and serves the handler on it in the background
+func listenAndServe(name, addr string, handler http.Handler) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("cannot listen for the %s API on %s: %v", name, addr, err)
+	}
+	go func() {
+		if err := http.Serve(l, handler); err != nil {
+			log.Error(fmt.Sprintf("%s API server failed: %v", name, err))
+		}
+	}()
+	return nil
 }
 
 func NewServer(api *api.Api) *Server {
-	return &Server{api}
+	return &Server{
+		api:       api,
+		pow:       make(map[string]uint8),
+		powStamps: newPowStamps(maxPowStamps),
+		hosts:     make(map[string]string),
+	}
 }
 
 type Server struct {
-	api *api.Api
+	api       *api.Api
+	pow       map[string]uint8  // proof-of-work difficulty required for writes, by scheme
+	powStamps *powStamps        // accepted proof-of-work stamps, see checkPow
+	hosts     map[string]string // virtual hosts, see SetVirtualHost
+	hostsMu   sync.RWMutex
 }
 
+// ConvergenceSecretHeader carries the optional secret of convergent uploads
+// to bzz-raw:/convergent, content only converges with uploads using the same
+// secret. See storage.NewConvergentHasherStore on the confirmation attacks
+// convergent encryption is exposed to.
+const ConvergenceSecretHeader = "X-Swarm-Convergence-Secret"
+
 // Request wraps http.Request and also includes the parsed bzz URI
 type Request struct {
 	http.Request
 
 	uri  *api.URI
 	ruid string // request unique id
+
+	gatewayKey string          // id of the API key of the request, see checkGatewayKey
+	uploaded   *countingReader // body of a write request with an API key
 }
 
 // HandlePostRaw handles a POST request to a raw bzz-raw:/ URI, stores the request
@@ -127,6 +230,7 @@ func (s *Server) HandlePostRaw(w http.ResponseWriter, r *Request) {
 	if r.uri.Addr == "encrypt" {
 		toEncrypt = true
 	}
+	convergent := r.uri.Addr == "convergent"
 
 	if r.uri.Path != "" {
 		postRawFail.Inc(1)
@@ -134,9 +238,9 @@ func (s *Server) HandlePostRaw(w http.ResponseWriter, r *Request) {
 		return
 	}
 
-	if r.uri.Addr != "" && r.uri.Addr != "encrypt" {
+	if r.uri.Addr != "" && r.uri.Addr != "encrypt" && !convergent {
 		postRawFail.Inc(1)
-		Respond(w, r, "raw POST request addr can only be empty or \"encrypt\"", http.StatusBadRequest)
+		Respond(w, r, "raw POST request addr can only be empty, \"encrypt\" or \"convergent\"", http.StatusBadRequest)
 		return
 	}
 
@@ -145,7 +249,13 @@ func (s *Server) HandlePostRaw(w http.ResponseWriter, r *Request) {
 		Respond(w, r, "missing Content-Length header in request", http.StatusBadRequest)
 		return
 	}
-	key, _, err := s.api.Store(r.Body, r.ContentLength, toEncrypt)
+	var key storage.Key
+	var err error
+	if convergent {
+		key, _, err = s.api.StoreConvergent(r.Body, r.ContentLength, []byte(r.Header.Get(ConvergenceSecretHeader)))
+	} else {
+		key, _, err = s.api.Store(r.Body, r.ContentLength, toEncrypt)
+	}
 	if err != nil {
 		postRawFail.Inc(1)
 		Respond(w, r, err.Error(), http.StatusInternalServerError)
@@ -153,6 +263,15 @@ func (s *Server) HandlePostRaw(w http.ResponseWriter, r *Request) {
 	}
 
 	log.Debug("stored content", "ruid", r.ruid, "key", key)
+	s.api.Events().Publish(events.Upload, &events.UploadData{
+		Key:  key.Hex(),
+		Size: r.ContentLength,
+	})
+
+	if !s.notarize(w, r, key) {
+		postRawFail.Inc(1)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
@@ -208,6 +327,9 @@ func (s *Server) HandlePostFiles(w http.ResponseWriter, r *Request) {
 		case "multipart/form-data":
 			return s.handleMultipartUpload(r, params["boundary"], mw)
 
+		case api.WarcContentType:
+			return s.handleWarcUpload(r, mw)
+
 		default:
 			return s.handleDirectUpload(r, mw)
 		}
@@ -219,6 +341,17 @@ func (s *Server) HandlePostFiles(w http.ResponseWriter, r *Request) {
 	}
 
 	log.Debug("stored content", "ruid", r.ruid, "key", newKey)
+	s.api.IndexContent(newKey)
+	s.api.Events().Publish(events.Upload, &events.UploadData{
+		Key:      newKey.Hex(),
+		Manifest: true,
+		Size:     r.ContentLength,
+	})
+
+	if !s.notarize(w, r, newKey) {
+		postFilesFail.Inc(1)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
@@ -259,6 +392,16 @@ func (s *Server) handleTarUpload(req *Request, mw *api.ManifestWriter) error {
 	}
 }
 
+func (s *Server) handleWarcUpload(req *Request, mw *api.ManifestWriter) error {
+	log.Debug("handle.warc.upload", "ruid", req.ruid)
+	count, err := mw.AddWarc(req.Body, req.uri.Path)
+	if err != nil {
+		return fmt.Errorf("error importing warc archive: %s", err)
+	}
+	log.Debug("imported warc archive", "ruid", req.ruid, "entries", count)
+	return nil
+}
+
 func (s *Server) handleMultipartUpload(req *Request, boundary string, mw *api.ManifestWriter) error {
 	log.Debug("handle.multipart.upload", "ruid", req.ruid)
 	mr := multipart.NewReader(req.Body, boundary)
@@ -397,10 +540,21 @@ func resourcePostMode(path string) (isRaw bool, frequency uint64, err error) {
 // The resource name will be verbatim what is passed as the address part of the url.
 // For example, if a POST is made to /bzz-resource:/foo.eth/raw/13 a new resource with frequency 13
 // and name "foo.eth" will be created
+//
+// The periods of new resources are counted in blocks, or in seconds if the
+// periods query parameter is "time"
+//
+// New resources may also be updated by the comma separated addresses in the
+// owners query parameter, and by the addresses authorized by the contract in
+// the acl-contract query parameter
+//
+// If the gateway manages publisher keys and the request carries the basic auth
+// credentials of a user (see HandleSigner), raw updates are signed with the
+// key of the user, and the user is added to the owners of new resources
 func (s *Server) HandlePostResource(w http.ResponseWriter, r *Request) {
 	log.Debug("handle.post.resource", "ruid", r.ruid)
 	var err error
-	var key storage.Key
+	var key, manifestKey storage.Key
 	var name string
 	var outdata []byte
 	isRaw, frequency, err := resourcePostMode(r.uri.Path)
@@ -409,19 +563,63 @@ func (s *Server) HandlePostResource(w http.ResponseWriter, r *Request) {
 		return
 	}
 
+	// updates are signed with a managed key if the user authenticates
+	var managedInfo *api.ManagedKeyInfo
+	user, token, managed := managedCredentials(r)
+	if signers := s.api.ManagedSigners(); managed && signers != nil {
+		managedInfo, err = signers.Info(user, token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="swarm"`)
+			Respond(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if !isRaw {
+			Respond(w, r, "managed publisher keys only sign raw updates", http.StatusBadRequest)
+			return
+		}
+	}
+
 	// new mutable resource creation will always have a frequency field larger than 0
 	if frequency > 0 {
 
 		name = r.uri.Addr
 
+		var scheme storage.ResourcePeriodScheme
+		switch periods := r.URL.Query().Get("periods"); periods {
+		case "", storage.BlockPeriods.String():
+			scheme = storage.BlockPeriods
+		case storage.TimePeriods.String():
+			scheme = storage.TimePeriods
+		default:
+			Respond(w, r, fmt.Sprintf("invalid periods %q", periods), http.StatusBadRequest)
+			return
+		}
+
+		acl, err := resourceACL(r)
+		if err != nil {
+			Respond(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if managedInfo != nil {
+			if acl == nil {
+				acl = &storage.ResourceACL{}
+			}
+			acl.Owners = append(acl.Owners, managedInfo.Address)
+		}
+
+		if !s.checkGatewayResource(w, r) {
+			return
+		}
+
 		// the key is the content addressed root chunk holding mutable resource metadata information
-		key, err = s.api.ResourceCreate(r.Context(), name, frequency)
+		key, err = s.api.ResourceCreateWithACL(r.Context(), name, frequency, scheme, acl)
 		if err != nil {
 			code, err2 := s.translateResourceError(w, r, "resource creation fail", err)
 
 			Respond(w, r, err2.Error(), code)
 			return
 		}
+		s.addGatewayResource(r)
 
 		// we create a manifest so we can retrieve the resource with bzz:// later
 		// this manifest has a special "resource type" manifest, and its hash is the key of the mutable resource
@@ -444,7 +642,7 @@ func (s *Server) HandlePostResource(w http.ResponseWriter, r *Request) {
 	} else {
 		// to update the resource through http we need to retrieve the key for the mutable resource root chunk
 		// that means that we retrieve the manifest and inspect its Hash member.
-		manifestKey := r.uri.Key()
+		manifestKey = r.uri.Key()
 		if manifestKey == nil {
 			manifestKey, err = s.api.Resolve(r.uri)
 			if err != nil {
@@ -452,8 +650,6 @@ func (s *Server) HandlePostResource(w http.ResponseWriter, r *Request) {
 				Respond(w, r, fmt.Sprintf("cannot resolve %s: %s", r.uri.Addr, err), http.StatusNotFound)
 				return
 			}
-		} else {
-			w.Header().Set("Cache-Control", "max-age=2147483648")
 		}
 
 		// get the root chunk key from the manifest
@@ -481,8 +677,16 @@ func (s *Server) HandlePostResource(w http.ResponseWriter, r *Request) {
 	}
 
 	// Multihash will be passed as hex-encoded data, so we need to parse this to bytes
-	if isRaw {
-		_, _, _, err = s.api.ResourceUpdate(r.Context(), name, data)
+	var updateKey storage.Key
+	var period, version uint32
+	if managedInfo != nil {
+		updateKey, period, version, err = s.api.ResourceUpdateAs(r.Context(), user, token, name, data)
+		if err != nil {
+			Respond(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if isRaw {
+		updateKey, period, version, err = s.api.ResourceUpdate(r.Context(), name, data)
 		if err != nil {
 			Respond(w, r, err.Error(), http.StatusBadRequest)
 			return
@@ -493,38 +697,192 @@ func (s *Server) HandlePostResource(w http.ResponseWriter, r *Request) {
 			Respond(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
-		_, _, _, err = s.api.ResourceUpdateMultihash(r.Context(), name, bytesdata)
+		updateKey, period, version, err = s.api.ResourceUpdateMultihash(r.Context(), name, bytesdata)
 		if err != nil {
 			Respond(w, r, err.Error(), http.StatusBadRequest)
 			return
 		}
 	}
 
-	// If we have data to return, write this now
-	// \TODO there should always be data to return here
-	if len(outdata) > 0 {
-		w.Header().Add("Content-type", "text/plain")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, string(outdata))
+	if !s.notarize(w, r, updateKey) {
 		return
 	}
+
+	// updates of existing resources return the key and position of the new update
+	if len(outdata) == 0 {
+		outdata, err = json.Marshal(&resourceResponse{
+			Manifest: manifestKey,
+			Resource: name,
+			Update:   updateKey,
+			Period:   period,
+			Version:  version,
+		})
+		if err != nil {
+			Respond(w, r, fmt.Sprintf("failed to create json response: %s", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Add("Content-type", "text/plain")
 	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, string(outdata))
+}
+
+// HandlePutResource adds an update to an existing mutable resource:
+// bzz-resource://<id> - add multihash update
+// bzz-resource://<id>/raw - add raw update
+// Unlike POST, PUT never creates a resource, so a frequency in the path is rejected.
+// The response is the json encoded key, period and version of the new update.
+func (s *Server) HandlePutResource(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.put.resource", "ruid", r.ruid)
+	_, frequency, err := resourcePostMode(r.uri.Path)
+	if err != nil {
+		Respond(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if frequency > 0 {
+		Respond(w, r, "mutable resources are created with POST", http.StatusMethodNotAllowed)
+		return
+	}
+	s.HandlePostResource(w, r)
+}
+
+// parses the access control list of a new resource from the owners and
+// acl-contract query parameters, returns nil if neither is set
+func resourceACL(r *Request) (*storage.ResourceACL, error) {
+	query := r.URL.Query()
+	owners, contract := query.Get("owners"), query.Get("acl-contract")
+	if owners == "" && contract == "" {
+		return nil, nil
+	}
+	acl := &storage.ResourceACL{}
+	if owners != "" {
+		for _, owner := range strings.Split(owners, ",") {
+			if !common.IsHexAddress(owner) {
+				return nil, fmt.Errorf("invalid owner address %q", owner)
+			}
+			acl.Owners = append(acl.Owners, common.HexToAddress(owner))
+		}
+	}
+	if contract != "" {
+		if !common.IsHexAddress(contract) {
+			return nil, fmt.Errorf("invalid acl-contract address %q", contract)
+		}
+		acl.Contract = common.HexToAddress(contract)
+	}
+	return acl, nil
+}
+
+// resourceAt returns the position the resources of the request are served
+// at, a block height or a unix time on their period scale, from the at query
+// parameter or the X-Swarm-Resource-At header, 0 if none is given. Times may
+// also be given in RFC 3339 format.
+func resourceAt(r *Request) (uint64, error) {
+	v := r.URL.Query().Get("at")
+	if v == "" {
+		v = r.Header.Get("X-Swarm-Resource-At")
+	}
+	if v == "" {
+		return 0, nil
+	}
+	if at, err := strconv.ParseUint(v, 10, 64); err == nil && at > 0 {
+		return at, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil && t.Unix() > 0 {
+		return uint64(t.Unix()), nil
+	}
+	return 0, fmt.Errorf("invalid at %q, expected a block height, a unix time or an RFC 3339 time", v)
+}
+
+// maxResourceLookupFanOut is the largest fanout query parameter accepted, so
+// that a request cannot make the node retrieve many periods at once
+const maxResourceLookupFanOut = 16
+
+// resourceLookupParams returns the lookup strategy of the strategy, fanout
+// and maxhops query parameters, nil if none is given
+func resourceLookupParams(r *Request) (*storage.ResourceLookupParams, error) {
+	query := r.URL.Query()
+	name, fanOut, maxHops := query.Get("strategy"), query.Get("fanout"), query.Get("maxhops")
+	if name == "" && fanOut == "" && maxHops == "" {
+		return nil, nil
+	}
+	params := &storage.ResourceLookupParams{}
+	var n int
+	if fanOut != "" {
+		fan, err := strconv.ParseUint(fanOut, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fanout %q", fanOut)
+		} else if fan > maxResourceLookupFanOut {
+			return nil, fmt.Errorf("fanout %d exceeds the maximum of %d", fan, maxResourceLookupFanOut)
+		}
+		n = int(fan)
+	}
+	if name == "" {
+		name = "parallel"
+	}
+	strategy, err := storage.ParseResourceLookupStrategy(name, n)
+	if err != nil {
+		return nil, err
+	}
+	params.Strategy = strategy
+	if maxHops != "" {
+		hops, err := strconv.ParseUint(maxHops, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxhops %q", maxHops)
+		}
+		params.MaxHops = uint32(hops)
+	}
+	return params, nil
 }
 
 // Retrieve mutable resource updates:
 // bzz-resource://<id> - get latest update
 // bzz-resource://<id>/<n> - get latest update on period n
 // bzz-resource://<id>/<n>/<m> - get update version m of period n
+// bzz-resource://<id>/rss - get the update history as RSS feed
+// bzz-resource://<id>/atom - get the update history as Atom feed
 // <id> = ens name or hash
+//
+// The update is served as raw content with its name, period and version in the
+// X-Swarm-Resource-* headers, or as json including the content if the request
+// accepts application/json.
+//
+// The strategy query parameter selects how the periods are searched, one of
+// linear, binary, parallel and hint, with the fanout parameter for the
+// parallel ones, at most maxResourceLookupFanOut. maxhops limits the periods
+// searched.
+//
+// With the proof query parameter set to true, the update is served as json
+// with the proof of the update, which clients verify without trusting the
+// node, see storage.ResourceProof.
+//
+// The at query parameter or the X-Swarm-Resource-At header select the update
+// the resource had at a block height, or a unix or RFC 3339 time for time based
+// resources, instead of the latest one. It cannot be combined with a period.
+//
+// The ETag of the response identifies the update by the namehash of the
+// resource and its period and version. Requests whose If-None-Match header
+// holds it are answered with 304 Not Modified and no content.
 func (s *Server) HandleGetResource(w http.ResponseWriter, r *Request) {
 	s.handleGetResource(w, r)
 }
 
-// TODO: Enable pass maxPeriod parameter
 func (s *Server) handleGetResource(w http.ResponseWriter, r *Request) {
 	log.Debug("handle.get.resource", "ruid", r.ruid)
 	var err error
 
+	lookupParams, err := resourceLookupParams(r)
+	if err != nil {
+		getFail.Inc(1)
+		Respond(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	at, err := resourceAt(r)
+	if err != nil {
+		getFail.Inc(1)
+		Respond(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// resolve the content key.
 	var manifestKey storage.Key
 	manifestKey = r.uri.Key()
@@ -554,15 +912,24 @@ func (s *Server) handleGetResource(w http.ResponseWriter, r *Request) {
 	if len(r.uri.Path) > 0 {
 		params = strings.Split(r.uri.Path, "/")
 	}
-	var name string
+	var info *api.ResourceUpdateInfo
 	var period uint64
 	var version uint64
 	var data []byte
 	now := time.Now()
 
+	if at != 0 && len(params) != 0 {
+		getFail.Inc(1)
+		Respond(w, r, "at cannot be combined with a period or version", http.StatusBadRequest)
+		return
+	}
 	switch len(params) {
-	case 0: // latest only
-		name, data, err = s.api.ResourceLookup(r.Context(), key, 0, 0, nil)
+	case 0: // latest only, or as it was at a block height or time
+		if at != 0 {
+			info, data, err = s.api.ResourceLookupAt(r.Context(), key, at, lookupParams)
+			break
+		}
+		info, data, err = s.api.ResourceLookupInfo(r.Context(), key, 0, 0, lookupParams)
 	case 2: // specific period and version
 		version, err = strconv.ParseUint(params[1], 10, 32)
 		if err != nil {
@@ -572,13 +939,17 @@ func (s *Server) handleGetResource(w http.ResponseWriter, r *Request) {
 		if err != nil {
 			break
 		}
-		name, data, err = s.api.ResourceLookup(r.Context(), key, uint32(period), uint32(version), nil)
-	case 1: // last version of specific period
+		info, data, err = s.api.ResourceLookupInfo(r.Context(), key, uint32(period), uint32(version), lookupParams)
+	case 1: // last version of specific period, or update history feed
+		if params[0] == feedFormatRSS || params[0] == feedFormatAtom {
+			s.handleGetResourceFeed(w, r, key, params[0])
+			return
+		}
 		period, err = strconv.ParseUint(params[0], 10, 32)
 		if err != nil {
 			break
 		}
-		name, data, err = s.api.ResourceLookup(r.Context(), key, uint32(period), uint32(version), nil)
+		info, data, err = s.api.ResourceLookupInfo(r.Context(), key, uint32(period), uint32(version), lookupParams)
 	default: // bogus
 		err = storage.NewResourceError(storage.ErrInvalidValue, "invalid mutable resource request")
 	}
@@ -591,27 +962,90 @@ func (s *Server) handleGetResource(w http.ResponseWriter, r *Request) {
 	}
 
 	// All ok, serve the retrieved update
-	log.Debug("Found update", "name", name, "ruid", r.ruid)
+	log.Debug("Found update", "name", info.Name, "period", info.Period, "version", info.Version, "ruid", r.ruid)
+
+	// the content of an update never changes, so polling clients are only
+	// served an update they do not have yet
+	etag := resourceETag(info)
+	w.Header().Set("ETag", etag)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.Header().Set("X-Swarm-Resource-Name", info.Name)
+		w.Header().Set("X-Swarm-Resource-Period", strconv.FormatUint(uint64(info.Period), 10))
+		w.Header().Set("X-Swarm-Resource-Version", strconv.FormatUint(uint64(info.Version), 10))
+		Respond(w, r, "Not Modified", http.StatusNotModified)
+		return
+	}
+
+	var proof *storage.ResourceProof
+	if r.URL.Query().Get("proof") == "true" {
+		proof, err = s.api.ResourceProof(r.Context(), info)
+		if err != nil {
+			code, err2 := s.translateResourceError(w, r, "mutable resource proof fail", err)
+			Respond(w, r, err2.Error(), code)
+			return
+		}
+	}
+	if proof != nil || strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(&resourceUpdateResponse{
+			ResourceUpdateInfo: info,
+			Data:               data,
+			Proof:              proof,
+		})
+		return
+	}
+	w.Header().Set("X-Swarm-Resource-Name", info.Name)
+	w.Header().Set("X-Swarm-Resource-Period", strconv.FormatUint(uint64(info.Period), 10))
+	w.Header().Set("X-Swarm-Resource-Version", strconv.FormatUint(uint64(info.Version), 10))
 	w.Header().Set("Content-Type", "application/octet-stream")
 	http.ServeContent(w, &r.Request, "", now, bytes.NewReader(data))
 }
 
+// returns the entity tag of the resource update, made of the namehash of the
+// resource and the period and version of the update
+func resourceETag(info *api.ResourceUpdateInfo) string {
+	return fmt.Sprintf("%x-%d-%d", storage.ResourceNameHash(info.Name).Bytes(), info.Period, info.Version)
+}
+
+// returns true if the entity tag is one of the comma separated tags of the
+// If-None-Match header, or if the header is "*". Quotes and weak tags are
+// accepted, since the tags only identify the content.
+func etagMatch(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		tag = strings.Trim(strings.TrimPrefix(tag, "W/"), `"`)
+		if tag != "" && tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) translateResourceError(w http.ResponseWriter, r *Request, supErr string, err error) (int, error) {
-	code := 0
 	defaultErr := fmt.Errorf("%s: %v", supErr, err)
-	rsrcErr, ok := err.(*storage.ResourceError)
-	if !ok && rsrcErr != nil {
-		code = rsrcErr.Code()
+	code, ok := storage.ResourceErrorCode(err)
+	if !ok {
+		return http.StatusInternalServerError, defaultErr
 	}
 	switch code {
-	case storage.ErrInvalidValue:
+	case storage.ErrInvalidValue, storage.ErrPeriodOverflow:
 		return http.StatusBadRequest, defaultErr
 	case storage.ErrNotFound, storage.ErrNotSynced, storage.ErrNothingToReturn, storage.ErrInit:
 		return http.StatusNotFound, defaultErr
 	case storage.ErrUnauthorized, storage.ErrInvalidSignature:
 		return http.StatusUnauthorized, defaultErr
+	case storage.ErrEncrypted:
+		return http.StatusForbidden, defaultErr
 	case storage.ErrDataOverflow:
 		return http.StatusRequestEntityTooLarge, defaultErr
+	case storage.ErrGone:
+		return http.StatusGone, defaultErr
+	case storage.ErrNoChain:
+		return http.StatusServiceUnavailable, defaultErr
 	}
 
 	return http.StatusInternalServerError, defaultErr
@@ -709,7 +1143,7 @@ func (s *Server) HandleGet(w http.ResponseWriter, r *Request) {
 			contentType = typ
 		}
 		w.Header().Set("Content-Type", contentType)
-		http.ServeContent(w, &r.Request, "", time.Now(), reader)
+		serveContent(w, r, time.Now(), reader, isEncrypted)
 	case r.uri.Hash():
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusOK)
@@ -926,7 +1360,14 @@ func (s *Server) HandleGetFile(w http.ResponseWriter, r *Request) {
 
 	log.Debug("handle.get.file: resolved", "ruid", r.ruid, "key", manifestKey)
 
-	reader, contentType, status, contentKey, err := s.api.Get(manifestKey, r.uri.Path)
+	// sites backed by resources are served as they were at the time asked for
+	at, err := resourceAt(r)
+	if err != nil {
+		getFileFail.Inc(1)
+		Respond(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	reader, contentType, status, contentKey, err := s.api.GetAt(manifestKey, r.uri.Path, at)
 
 	etag := common.Bytes2Hex(contentKey)
 	noneMatchEtag := r.Header.Get("If-None-Match")
@@ -943,6 +1384,10 @@ func (s *Server) HandleGetFile(w http.ResponseWriter, r *Request) {
 		case http.StatusNotFound:
 			getFileNotFound.Inc(1)
 			Respond(w, r, err.Error(), http.StatusNotFound)
+		case http.StatusLoopDetected:
+			// resources referencing each other, or nested too deep
+			getFileFail.Inc(1)
+			Respond(w, r, err.Error(), http.StatusLoopDetected)
 		default:
 			getFileFail.Inc(1)
 			Respond(w, r, err.Error(), http.StatusInternalServerError)
@@ -975,7 +1420,7 @@ func (s *Server) HandleGetFile(w http.ResponseWriter, r *Request) {
 	}
 
 	w.Header().Set("Content-Type", contentType)
-	http.ServeContent(w, &r.Request, "", time.Now(), reader)
+	serveContent(w, r, time.Now(), reader, len(contentKey) > storage.KeyLength)
 }
 
 func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
@@ -987,6 +1432,12 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	// wrapping the ResponseWriter, so that we get the response code set by http.ServeContent
 	w := newLoggingResponseWriter(rw)
 
+	if target, ok := s.virtualHost(r); ok {
+		s.serveVirtualHost(w, req, target)
+		log.Info("served response", "ruid", req.ruid, "code", w.statusCode)
+		return
+	}
+
 	if r.RequestURI == "/" && strings.Contains(r.Header.Get("Accept"), "text/html") {
 
 		err := landingPageTemplate.Execute(w, nil)
@@ -1019,16 +1470,43 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	log.Debug("parsed request path", "ruid", req.ruid, "method", req.Method, "uri.Addr", req.uri.Addr, "uri.Path", req.uri.Path, "uri.Scheme", req.uri.Scheme)
 
+	if !s.checkGatewayKey(w, req) {
+		return
+	}
+	defer s.chargeGatewayKey(w, req)
+
+	if !s.checkNotarize(w, req) {
+		return
+	}
+
+	if uri.GatewayKeys() {
+		s.HandleGatewayKeys(w, req)
+		return
+	}
+
+	if uri.Access() {
+		s.HandleAccess(w, req)
+		return
+	}
+
+	if isWrite(r.Method) && !s.checkPow(w, req) {
+		return
+	}
+
 	switch r.Method {
 	case "POST":
-		if uri.Raw() {
+		if uri.Pin() {
+			s.HandlePin(w, req)
+		} else if uri.Signer() {
+			s.HandleSigner(w, req)
+		} else if uri.Raw() {
 			log.Debug("handlePostRaw")
 			s.HandlePostRaw(w, req)
 		} else if uri.Resource() {
 			log.Debug("handlePostResource")
 			s.HandlePostResource(w, req)
-		} else if uri.Immutable() || uri.List() || uri.Hash() {
-			log.Debug("POST not allowed on immutable, list or hash")
+		} else if uri.Immutable() || uri.List() || uri.Hash() || uri.Timeline() || uri.Search() {
+			log.Debug("POST not allowed on immutable, list, hash, timeline or search")
 			Respond(w, req, fmt.Sprintf("POST method on scheme %s not allowed", uri.Scheme), http.StatusMethodNotAllowed)
 		} else {
 			log.Debug("handlePostFiles")
@@ -1036,11 +1514,23 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		}
 
 	case "PUT":
+		if uri.Resource() {
+			s.HandlePutResource(w, req)
+			return
+		}
 		Respond(w, req, fmt.Sprintf("PUT method to %s not allowed", uri), http.StatusBadRequest)
 		return
 
 	case "DELETE":
-		if uri.Raw() {
+		if uri.Pin() {
+			s.HandlePin(w, req)
+			return
+		}
+		if uri.Signer() {
+			s.HandleSigner(w, req)
+			return
+		}
+		if uri.Raw() || uri.Timeline() || uri.Search() {
 			Respond(w, req, fmt.Sprintf("DELETE method to %s not allowed", uri), http.StatusBadRequest)
 			return
 		}
@@ -1048,11 +1538,31 @@ func (s *Server) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	case "GET":
 
+		if uri.Pin() {
+			s.HandleGetPins(w, req)
+			return
+		}
+
+		if uri.Signer() {
+			s.HandleSigner(w, req)
+			return
+		}
+
 		if uri.Resource() {
 			s.HandleGetResource(w, req)
 			return
 		}
 
+		if uri.Timeline() {
+			s.HandleGetTimeline(w, req)
+			return
+		}
+
+		if uri.Search() {
+			s.HandleSearch(w, req)
+			return
+		}
+
 		if uri.Raw() || uri.Hash() {
 			s.HandleGet(w, req)
 			return
@@ -20,17 +20,22 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/swarm/api"
 	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
@@ -148,7 +153,7 @@ func TestBzzResourceMultihash(t *testing.T) {
 		t.Fatalf("data %s could not be unmarshaled: %v", b, err)
 	}
 
-	correctManifestKeyHex := "b606e1c22cae0b5173caf2c7b2bd429acd925285133b66a50d2999c388c1d48b"
+	correctManifestKeyHex := "da3aba5a15bfca2fcc418a64524c15128a81cead45b6507f9912064fee8a2afb"
 	if rsrcResp.Hex() != correctManifestKeyHex {
 		t.Fatalf("Response resource key mismatch, expected '%s', got '%s'", correctManifestKeyHex, rsrcResp)
 	}
@@ -172,184 +177,1104 @@ func TestBzzResourceMultihash(t *testing.T) {
 	}
 }
 
+// resources whose multihash references another resource, by its manifest or
+// its metadata chunk, are resolved to the content of the last one, unless they
+// are nested too deep
+func TestBzzResourceNested(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	post := func(url string, data []byte) []byte {
+		resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("post %s returned %s: %s", url, resp.Status, b)
+		}
+		return b
+	}
+	// returns the hex multihash of the hex key
+	mh := func(key string) []byte {
+		mh, err := multihash.Encode(common.FromHex(key), multihash.KECCAK_256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []byte(hexutil.Encode(mh))
+	}
+	// creates the resource with the multihash and returns its manifest key
+	create := func(name string, data []byte) string {
+		var key storage.Key
+		if err := json.Unmarshal(post(fmt.Sprintf("%s/bzz-resource:/%s/13", srv.URL, name), data), &key); err != nil {
+			t.Fatal(err)
+		}
+		return key.Hex()
+	}
+	get := func(manifestKey string) (int, []byte) {
+		resp, err := http.Get(fmt.Sprintf("%s/bzz:/%s", srv.URL, manifestKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, b
+	}
+
+	content := string(post(fmt.Sprintf("%s/bzz:/", srv.URL), []byte("bar")))
+	inner := create("inner.eth", mh(content))
+
+	// referenced by its manifest
+	outer := create("outer.eth", mh(inner))
+	if status, b := get(outer); status != http.StatusOK || string(b) != "bar" {
+		t.Fatalf("expected %q, got %d: %q", "bar", status, b)
+	}
+
+	// referenced by its metadata chunk
+	resp, err := http.Get(fmt.Sprintf("%s/bzz-raw:/%s", srv.URL, inner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &api.Manifest{}
+	err = json.NewDecoder(resp.Body).Decode(manifest)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alias := create("alias.eth", mh(manifest.Entries[0].Hash))
+	if status, b := get(alias); status != http.StatusOK || string(b) != "bar" {
+		t.Fatalf("expected %q, got %d: %q", "bar", status, b)
+	}
+
+	// a resource referencing itself
+	loop := create("loop.eth", mh(content))
+	post(fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, loop), mh(loop))
+	if status, _ := get(loop); status != http.StatusLoopDetected {
+		t.Fatalf("expected status %d, got %d", http.StatusLoopDetected, status)
+	}
+}
+
 // Test resource updates using the raw update methods
 func TestBzzResource(t *testing.T) {
 	srv := testutil.NewTestSwarmServer(t, serverFunc)
 	defer srv.Close()
 
-	// our mutable resource "name"
-	keybytes := "foo.eth"
+	// our mutable resource "name"
+	keybytes := "foo.eth"
+
+	// data of update 1
+	databytes := make([]byte, 666)
+	_, err := rand.Read(databytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// creates resource and sets update 1
+	url := fmt.Sprintf("%s/bzz-resource:/%s/raw/13", srv.URL, []byte(keybytes))
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(databytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsrcResp := &storage.Key{}
+	err = json.Unmarshal(b, rsrcResp)
+	if err != nil {
+		t.Fatalf("data %s could not be unmarshaled: %v", b, err)
+	}
+
+	correctManifestKeyHex := "da3aba5a15bfca2fcc418a64524c15128a81cead45b6507f9912064fee8a2afb"
+	if rsrcResp.Hex() != correctManifestKeyHex {
+		t.Fatalf("Response resource key mismatch, expected '%s', got '%s'", correctManifestKeyHex, rsrcResp.Hex())
+	}
+
+	// get the manifest
+	url = fmt.Sprintf("%s/bzz-raw:/%s", srv.URL, rsrcResp)
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := &api.Manifest{}
+	err = json.Unmarshal(b, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Entries) != 1 {
+		t.Fatalf("Manifest has %d entries", len(manifest.Entries))
+	}
+
+	correctRootKeyHex := "52121c1b82210e820c4ce7e91b77670f42cb2aefbc1433ca22e2b63ad69ffe70"
+	if manifest.Entries[0].Hash != correctRootKeyHex {
+		t.Fatalf("Expected manifest path '%s', got '%s'", correctRootKeyHex, manifest.Entries[0].Hash)
+	}
+
+	// get bzz manifest transparent resource resolve
+	url = fmt.Sprintf("%s/bzz:/%s", srv.URL, rsrcResp)
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get non-existent name, should fail
+	url = fmt.Sprintf("%s/bzz-resource:/bar", srv.URL)
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// get latest update (1.1) through resource directly
+	log.Info("get update latest = 1.1", "addr", correctManifestKeyHex)
+	url = fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, correctManifestKeyHex)
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(databytes, b) {
+		t.Fatalf("Expected body '%x', got '%x'", databytes, b)
+	}
+
+	// update 2
+	log.Info("update 2")
+	url = fmt.Sprintf("%s/bzz-resource:/%s/raw", srv.URL, correctManifestKeyHex)
+	data := []byte("foo")
+	resp, err = http.Post(url, "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Update returned %s", resp.Status)
+	}
+
+	// get latest update (1.2) through resource directly
+	log.Info("get update 1.2")
+	url = fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, correctManifestKeyHex)
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, b) {
+		t.Fatalf("Expected body '%x', got '%x'", data, b)
+	}
+
+	// get latest update (1.2) with specified period
+	log.Info("get update latest = 1.2")
+	url = fmt.Sprintf("%s/bzz-resource:/%s/1", srv.URL, correctManifestKeyHex)
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, b) {
+		t.Fatalf("Expected body '%x', got '%x'", data, b)
+	}
+
+	// get first update (1.1) with specified period and version
+	log.Info("get first update 1.1")
+	url = fmt.Sprintf("%s/bzz-resource:/%s/1/1", srv.URL, correctManifestKeyHex)
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(databytes, b) {
+		t.Fatalf("Expected body '%x', got '%x'", databytes, b)
+	}
+}
+
+// test updating a resource with PUT and retrieving its updates with their metadata
+func TestBzzResourcePutAndInfo(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	// create the resource with the first update
+	url := fmt.Sprintf("%s/bzz-resource:/bar.eth/raw/13", srv.URL)
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader([]byte("one")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("create returned %s", resp.Status)
+	}
+	var manifestKey storage.Key
+	if err := json.Unmarshal(b, &manifestKey); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(path string, data []byte) *http.Response {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, path), bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// PUT cannot create resources
+	resp = put("baz.eth/raw/13", []byte("nope"))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d creating with PUT, got %s", http.StatusMethodNotAllowed, resp.Status)
+	}
+
+	// second update with PUT
+	resp = put(manifestKey.Hex()+"/raw", []byte("two"))
+	b, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %s: %s", resp.Status, b)
+	}
+	update := &resourceResponse{}
+	if err := json.Unmarshal(b, update); err != nil {
+		t.Fatalf("data %s could not be unmarshaled: %v", b, err)
+	}
+	if update.Resource != "bar.eth" || update.Period != 1 || update.Version != 2 || len(update.Update) == 0 {
+		t.Fatalf("unexpected update response %s", b)
+	}
+	if !bytes.Equal(update.Manifest, manifestKey) {
+		t.Fatalf("expected manifest %v, got %v", manifestKey, update.Manifest)
+	}
+
+	// raw content carries its metadata in the headers
+	resp, err = http.Get(fmt.Sprintf("%s/bzz-resource:/%s/1/1", srv.URL, manifestKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get returned %s", resp.Status)
+	}
+	if string(b) != "one" {
+		t.Fatalf("expected update 1.1 to be %q, got %q", "one", b)
+	}
+	for header, value := range map[string]string{
+		"X-Swarm-Resource-Name":    "bar.eth",
+		"X-Swarm-Resource-Period":  "1",
+		"X-Swarm-Resource-Version": "1",
+	} {
+		if v := resp.Header.Get(header); v != value {
+			t.Fatalf("expected header %s to be %q, got %q", header, value, v)
+		}
+	}
+
+	// json responses contain the metadata and the content of the latest update
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, manifestKey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("get returned %s", resp.Status)
+	}
+	info := &resourceUpdateResponse{}
+	if err := json.Unmarshal(b, info); err != nil {
+		t.Fatalf("data %s could not be unmarshaled: %v", b, err)
+	}
+	if info.ResourceUpdateInfo == nil || info.Name != "bar.eth" || info.Period != 1 || info.Version != 2 || info.Multihash || info.Size != 3 {
+		t.Fatalf("unexpected update info %s", b)
+	}
+	if string(info.Data) != "two" {
+		t.Fatalf("expected latest update to be %q, got %q", "two", info.Data)
+	}
+
+	// unsigned updates cannot be proven
+	resp, err = http.Get(fmt.Sprintf("%s/bzz-resource:/%s?proof=true", srv.URL, manifestKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status %d for the proof of an unsigned update, got %s", http.StatusUnauthorized, resp.Status)
+	}
+
+	// the lookup strategy is selected with query parameters
+	for query, status := range map[string]int{
+		"strategy=binary":             http.StatusOK,
+		"strategy=hint&fanout=2":      http.StatusOK,
+		"strategy=linear&maxhops=0":   http.StatusOK,
+		"strategy=bogus":              http.StatusBadRequest,
+		"strategy=parallel&fanout=-1": http.StatusBadRequest,
+		"strategy=parallel&fanout=16": http.StatusOK,
+		"strategy=parallel&fanout=17": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(fmt.Sprintf("%s/bzz-resource:/%s?%s", srv.URL, manifestKey, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != status {
+			t.Fatalf("%s: expected status %d, got %s", query, status, resp.Status)
+		} else if status == http.StatusOK && string(b) != "two" {
+			t.Fatalf("%s: expected latest update to be %q, got %q", query, "two", b)
+		}
+	}
+}
+
+// test creating time based resources
+func TestBzzResourceTimePeriods(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	url := fmt.Sprintf("%s/bzz-resource:/clock.eth/raw/60?periods=sundials", srv.URL)
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader([]byte("tick")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status %d for invalid periods, got %s", http.StatusBadRequest, resp.Status)
+	}
+
+	url = fmt.Sprintf("%s/bzz-resource:/clock.eth/raw/60?periods=time", srv.URL)
+	resp, err = http.Post(url, "application/octet-stream", bytes.NewReader([]byte("tick")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("create returned %s: %s", resp.Status, b)
+	}
+	var manifestKey storage.Key
+	if err := json.Unmarshal(b, &manifestKey); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, manifestKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(b) != "tick" {
+		t.Fatalf("expected %q, got %s: %q", "tick", resp.Status, b)
+	}
+}
+
+// test that polling clients are answered with 304 Not Modified until the
+// resource is updated
+func TestBzzResourceConditional(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	url := fmt.Sprintf("%s/bzz-resource:/etag.eth/raw/13", srv.URL)
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader([]byte("foo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("create returned %s: %s", resp.Status, b)
+	}
+	var manifestKey storage.Key
+	if err := json.Unmarshal(b, &manifestKey); err != nil {
+		t.Fatal(err)
+	}
+
+	// gets the latest update, returning the status, etag and body
+	get := func(etag string) (int, string, []byte) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, manifestKey), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, resp.Header.Get("ETag"), b
+	}
+
+	status, etag, b := get("")
+	if status != http.StatusOK || string(b) != "foo" {
+		t.Fatalf("expected %q, got %d: %q", "foo", status, b)
+	}
+	expected := fmt.Sprintf("%x-1-1", storage.ResourceNameHash("etag.eth").Bytes())
+	if etag != expected {
+		t.Fatalf("expected etag %s, got %s", expected, etag)
+	}
+	for _, header := range []string{etag, `"` + etag + `"`, `"other", W/"` + etag + `"`, "*"} {
+		if status, _, b := get(header); status != http.StatusNotModified || len(b) != 0 {
+			t.Fatalf("If-None-Match %s: expected status %d without content, got %d: %q", header, http.StatusNotModified, status, b)
+		}
+	}
+
+	// the update has a new etag
+	url = fmt.Sprintf("%s/bzz-resource:/%s/raw", srv.URL, manifestKey)
+	resp, err = http.Post(url, "application/octet-stream", bytes.NewReader([]byte("bar")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %s", resp.Status)
+	}
+	status, newEtag, b := get(etag)
+	if status != http.StatusOK || string(b) != "bar" {
+		t.Fatalf("expected %q, got %d: %q", "bar", status, b)
+	}
+	if newEtag != fmt.Sprintf("%x-1-2", storage.ResourceNameHash("etag.eth").Bytes()) {
+		t.Fatalf("expected etag of version 2, got %s", newEtag)
+	}
+}
+
+// test getting a resource as it was at a block height
+func TestBzzResourceAt(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	// every block starts a period
+	url := fmt.Sprintf("%s/bzz-resource:/at.eth/raw/1", srv.URL)
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader([]byte("foo")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("create returned %s: %s", resp.Status, b)
+	}
+	var manifestKey storage.Key
+	if err := json.Unmarshal(b, &manifestKey); err != nil {
+		t.Fatal(err)
+	}
+	url = fmt.Sprintf("%s/bzz-resource:/%s/raw", srv.URL, manifestKey)
+	resp, err = http.Post(url, "application/octet-stream", bytes.NewReader([]byte("bar")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update returned %s", resp.Status)
+	}
+
+	// gets the resource with the at query parameter or header
+	get := func(path string, at string, header bool) (int, []byte) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("%s/bzz-resource:/%s%s", srv.URL, manifestKey, path), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header {
+			req.Header.Set("X-Swarm-Resource-At", at)
+		} else {
+			req.URL.RawQuery = "at=" + at
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, b
+	}
+
+	// the first block the resource has an update at serves the first update
+	var start uint64
+	for at := uint64(1); at < 1000 && start == 0; at++ {
+		if status, b := get("", fmt.Sprint(at), false); status == http.StatusOK {
+			if string(b) != "foo" {
+				t.Fatalf("at %d: expected %q, got %q", at, "foo", b)
+			}
+			start = at
+		}
+	}
+	if start == 0 {
+		t.Fatal("no update found at any block")
+	}
+	for _, header := range []bool{false, true} {
+		if status, b := get("", fmt.Sprint(start), header); status != http.StatusOK || string(b) != "foo" {
+			t.Fatalf("header %v: expected %q at start, got %d: %q", header, "foo", status, b)
+		}
+		if status, b := get("", "1000000", header); status != http.StatusOK || string(b) != "bar" {
+			t.Fatalf("header %v: expected %q in the future, got %d: %q", header, "bar", status, b)
+		}
+		if status, _ := get("", "yesterday", header); status != http.StatusBadRequest {
+			t.Fatalf("header %v: expected status %d for invalid at, got %d", header, http.StatusBadRequest, status)
+		}
+	}
+	if status, _ := get("/1", fmt.Sprint(start), false); status != http.StatusBadRequest {
+		t.Fatalf("expected status %d for at with a period, got %d", http.StatusBadRequest, status)
+	}
+}
+
+// test rendering the update history of a resource as RSS and Atom feeds
+func TestBzzResourceFeed(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	// the first update uses the structured envelope
+	envelope := &api.ResourceEnvelope{
+		Title:   "hello swarm",
+		Link:    "bzz:/theswarm.eth/hello.html",
+		Content: "<p>first post</p>",
+		Date:    time.Date(2018, 5, 4, 12, 0, 0, 0, time.UTC),
+	}
+	databytes, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := fmt.Sprintf("%s/bzz-resource:/%s/raw/13", srv.URL, "blog.eth")
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(databytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestKey := &storage.Key{}
+	if err := json.Unmarshal(b, manifestKey); err != nil {
+		t.Fatalf("data %s could not be unmarshaled: %v", b, err)
+	}
+
+	// the second update is plain text
+	url = fmt.Sprintf("%s/bzz-resource:/%s/raw", srv.URL, manifestKey)
+	resp, err = http.Post(url, "application/octet-stream", strings.NewReader("second post"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Update returned %s", resp.Status)
+	}
+
+	// rss lists the updates newest first
+	resp, err = http.Get(fmt.Sprintf("%s/bzz-resource:/%s/rss", srv.URL, manifestKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	rss := &rssFeed{}
+	if err := xml.NewDecoder(resp.Body).Decode(rss); err != nil {
+		t.Fatal(err)
+	}
+	if rss.Channel.Title != "blog.eth" {
+		t.Fatalf("expected channel title blog.eth, got %q", rss.Channel.Title)
+	}
+	if len(rss.Channel.Items) != 2 {
+		t.Fatalf("expected 2 items, got %d", len(rss.Channel.Items))
+	}
+	if item := rss.Channel.Items[0]; item.Title != "Update 1.2" || item.Description != "second post" {
+		t.Fatalf("unexpected plain update item %+v", item)
+	}
+	if item := rss.Channel.Items[1]; item.Title != envelope.Title || item.Link != envelope.Link || item.Description != envelope.Content || item.PubDate != "Fri, 04 May 2018 12:00:00 +0000" {
+		t.Fatalf("unexpected envelope item %+v", item)
+	}
+
+	// the limit applies to the number of updates
+	resp, err = http.Get(fmt.Sprintf("%s/bzz-resource:/%s/atom?limit=1", srv.URL, manifestKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("err %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	atom := &atomFeed{}
+	if err := xml.NewDecoder(resp.Body).Decode(atom); err != nil {
+		t.Fatal(err)
+	}
+	if len(atom.Entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(atom.Entries))
+	}
+	if entry := atom.Entries[0]; entry.Content == nil || entry.Content.Value != "second post" || !strings.HasSuffix(entry.ID, "/1/2") {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+}
+
+// test merging the updates of several resources into a timeline, one page at
+// a time
+func TestBzzTimeline(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	post := func(url string, data string) []byte {
+		resp, err := http.Post(url, "application/octet-stream", strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("err %s", resp.Status)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	b := post(fmt.Sprintf("%s/bzz-resource:/alice.eth/raw/13", srv.URL), "alice 1")
+	manifestKey := &storage.Key{}
+	if err := json.Unmarshal(b, manifestKey); err != nil {
+		t.Fatalf("data %s could not be unmarshaled: %v", b, err)
+	}
+	post(fmt.Sprintf("%s/bzz-resource:/%s/raw", srv.URL, manifestKey), "alice 2")
+	post(fmt.Sprintf("%s/bzz-resource:/bob.eth/raw/13", srv.URL), "bob 1")
+
+	getTimeline := func(query string) *api.ResourceTimeline {
+		resp, err := http.Get(fmt.Sprintf("%s/bzz-timeline:/?%s", srv.URL, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("err %s", resp.Status)
+		}
+		timeline := &api.ResourceTimeline{}
+		if err := json.NewDecoder(resp.Body).Decode(timeline); err != nil {
+			t.Fatal(err)
+		}
+		return timeline
+	}
 
-	// data of update 1
-	databytes := make([]byte, 666)
-	_, err := rand.Read(databytes)
-	if err != nil {
-		t.Fatal(err)
+	// page through the timeline two updates at a time
+	query := fmt.Sprintf("feed=%s&feed=%s&limit=2", storage.ResourceNameHash("alice.eth").Hex(), storage.ResourceNameHash("bob.eth").Hex())
+	var contents []string
+	for pages := 0; ; pages++ {
+		if pages > 2 {
+			t.Fatal("expected timeline to end after two pages")
+		}
+		timeline := getTimeline(query)
+		if len(timeline.Errors) != 0 {
+			t.Fatalf("unexpected errors %v", timeline.Errors)
+		}
+		for i, entry := range timeline.Entries {
+			if i > 0 && entry.ModTime.After(timeline.Entries[i-1].ModTime) {
+				t.Fatalf("expected entries newest first, got %v after %v", entry.ModTime, timeline.Entries[i-1].ModTime)
+			}
+			contents = append(contents, entry.Envelope.Content)
+		}
+		if len(timeline.Next) == 0 {
+			break
+		}
+		query = "limit=2"
+		for _, feed := range timeline.Next {
+			query += "&feed=" + feed.String()
+		}
+	}
+	sort.Strings(contents)
+	if strings.Join(contents, ",") != "alice 1,alice 2,bob 1" {
+		t.Fatalf("unexpected timeline updates %v", contents)
 	}
 
-	// creates resource and sets update 1
-	url := fmt.Sprintf("%s/bzz-resource:/%s/raw/13", srv.URL, []byte(keybytes))
-	resp, err := http.Post(url, "application/octet-stream", bytes.NewReader(databytes))
-	if err != nil {
-		t.Fatal(err)
+	// unknown feeds are reported without failing the timeline
+	unknown := storage.ResourceNameHash("carol.eth")
+	timeline := getTimeline(fmt.Sprintf("feed=%s&feed=%s", storage.ResourceNameHash("bob.eth").Hex(), unknown.Hex()))
+	if len(timeline.Entries) != 1 || timeline.Entries[0].Envelope.Content != "bob 1" {
+		t.Fatalf("unexpected timeline entries %v", timeline.Entries)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("err %s", resp.Status)
+	if _, ok := timeline.Errors[unknown]; !ok {
+		t.Fatalf("expected error of unknown feed, got %v", timeline.Errors)
 	}
-	b, err := ioutil.ReadAll(resp.Body)
+
+	resp, err := http.Get(fmt.Sprintf("%s/bzz-timeline:/?feed=foo", srv.URL))
 	if err != nil {
 		t.Fatal(err)
 	}
-	rsrcResp := &storage.Key{}
-	err = json.Unmarshal(b, rsrcResp)
-	if err != nil {
-		t.Fatalf("data %s could not be unmarshaled: %v", b, err)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected invalid feed to be a bad request, got %s", resp.Status)
 	}
+}
 
-	correctManifestKeyHex := "b606e1c22cae0b5173caf2c7b2bd429acd925285133b66a50d2999c388c1d48b"
-	if rsrcResp.Hex() != correctManifestKeyHex {
-		t.Fatalf("Response resource key mismatch, expected '%s', got '%s'", correctManifestKeyHex, rsrcResp.Hex())
+// TestBzzSigner tests the management of publisher keys kept by the gateway
+func TestBzzSigner(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		a.SetManagedSigners(api.NewManagedSigners(nil))
+		return serverFunc(a)
+	})
+	defer srv.Close()
+
+	do := func(method, path, user, token string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", srv.URL, path), bytes.NewReader([]byte("data")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != "" {
+			req.SetBasicAuth(user, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, b
 	}
 
-	// get the manifest
-	url = fmt.Sprintf("%s/bzz-raw:/%s", srv.URL, rsrcResp)
-	resp, err = http.Get(url)
-	if err != nil {
+	resp, b := do("POST", "bzz-signer:/alice", "", "")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d creating key, got %s", http.StatusCreated, resp.Status)
+	}
+	var info api.ManagedKeyInfo
+	if err := json.Unmarshal(b, &info); err != nil {
 		t.Fatal(err)
 	}
-	defer resp.Body.Close()
+	if resp, _ := do("POST", "bzz-signer:/alice", "", ""); resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected status %d creating key twice, got %s", http.StatusConflict, resp.Status)
+	}
+
+	for _, path := range []string{"bzz-signer:/alice", "bzz-signer:/alice/key"} {
+		if resp, _ := do("GET", path, "alice", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status %d getting %s with wrong token, got %s", http.StatusUnauthorized, path, resp.Status)
+		}
+	}
+	if resp, _ := do("POST", "bzz-resource:/foo.eth/raw/13", "alice", "wrong"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status %d updating with wrong token, got %s", http.StatusUnauthorized, resp.Status)
+	}
+
+	resp, b = do("GET", "bzz-signer:/alice/key", "alice", info.Token)
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("err %s", resp.Status)
+		t.Fatalf("expected status %d exporting key, got %s", http.StatusOK, resp.Status)
 	}
-	b, err = ioutil.ReadAll(resp.Body)
+	privKey, err := crypto.ToECDSA(common.FromHex(string(b)))
 	if err != nil {
 		t.Fatal(err)
 	}
-	manifest := &api.Manifest{}
-	err = json.Unmarshal(b, manifest)
-	if err != nil {
-		t.Fatal(err)
+	if addr := crypto.PubkeyToAddress(privKey.PublicKey); addr != info.Address {
+		t.Fatalf("expected exported key of %x, got %x", info.Address, addr)
 	}
-	if len(manifest.Entries) != 1 {
-		t.Fatalf("Manifest has %d entries", len(manifest.Entries))
+	// the private key is not exported in the clear to other hosts
+	if secureConn(&Request{Request: http.Request{RemoteAddr: "10.0.0.1:1234"}}) {
+		t.Fatal("expected plain http connection from another host to be insecure")
 	}
 
-	correctRootKeyHex := "f667277e004e8486c7a3631fd226802430e84e9a81b6085d31f512a591ae0065"
-	if manifest.Entries[0].Hash != correctRootKeyHex {
-		t.Fatalf("Expected manifest path '%s', got '%s'", correctRootKeyHex, manifest.Entries[0].Hash)
+	if resp, _ := do("DELETE", "bzz-signer:/alice", "alice", info.Token); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d deleting key, got %s", http.StatusOK, resp.Status)
+	}
+	if resp, _ := do("GET", "bzz-signer:/alice", "alice", info.Token); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status %d getting deleted key, got %s", http.StatusUnauthorized, resp.Status)
 	}
+}
 
-	// get bzz manifest transparent resource resolve
-	url = fmt.Sprintf("%s/bzz:/%s", srv.URL, rsrcResp)
-	resp, err = http.Get(url)
-	if err != nil {
+// TestBzzGatewayKeys tests the API keys required on writes to the gateway,
+// their quotas and the reports of their usage
+func TestBzzGatewayKeys(t *testing.T) {
+	var server *Server
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		a.SetGatewayKeys(api.NewGatewayKeys(nil))
+		server = NewServer(a)
+		return server
+	})
+	defer srv.Close()
+	writeSrv := httptest.NewServer(server.Surface(SurfaceWrite))
+	defer writeSrv.Close()
+
+	doAt := func(url, method, path, token, body string) (*http.Response, []byte) {
+		req, err := http.NewRequest(method, fmt.Sprintf("%s/%s", url, path), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set(GatewayKeyHeader, token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, b
+	}
+	do := func(method, path, token, body string) (*http.Response, []byte) {
+		return doAt(srv.URL, method, path, token, body)
+	}
+
+	if resp, _ := do("POST", "bzz-raw:/", "", "hello"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status %d uploading without key, got %s", http.StatusUnauthorized, resp.Status)
+	}
+	resp, b := do("POST", "bzz-keys:/alice", "", `{"uploadBytes":10}`)
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status %d creating key, got %s", http.StatusCreated, resp.Status)
+	}
+	var info api.GatewayKeyInfo
+	if err := json.Unmarshal(b, &info); err != nil {
 		t.Fatal(err)
 	}
-	defer resp.Body.Close()
+
+	resp, b = do("POST", "bzz-raw:/", info.Token, "hello")
 	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("err %s", resp.Status)
+		t.Fatalf("expected status %d uploading with key, got %s", http.StatusOK, resp.Status)
 	}
-	b, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		t.Fatal(err)
+	if resp, _ := do("GET", "bzz-raw:/"+string(b), "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d reading without key, got %s", http.StatusOK, resp.Status)
+	}
+	if resp, _ := do("POST", "bzz-raw:/", info.Token, "hello world"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d uploading over quota, got %s", http.StatusForbidden, resp.Status)
 	}
 
-	// get non-existent name, should fail
-	url = fmt.Sprintf("%s/bzz-resource:/bar", srv.URL)
-	resp, err = http.Get(url)
+	resp, b = do("GET", "bzz-keys:/alice/"+info.ID, "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d getting key, got %s", http.StatusOK, resp.Status)
+	}
+	var usage api.GatewayKeyInfo
+	if err := json.Unmarshal(b, &usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Usage.UploadBytes != 5 || usage.Usage.Requests != 2 || usage.Usage.Rejected != 1 || usage.Token != "" {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+	// a body of unknown length is held to the quota as well
+	req, err := http.NewRequest("POST", srv.URL+"/bzz-raw:/", ioutil.NopCloser(strings.NewReader("hello world")))
 	if err != nil {
 		t.Fatal(err)
 	}
-	resp.Body.Close()
-
-	// get latest update (1.1) through resource directly
-	log.Info("get update latest = 1.1", "addr", correctManifestKeyHex)
-	url = fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, correctManifestKeyHex)
-	resp, err = http.Get(url)
+	req.Header.Set(GatewayKeyHeader, info.Token)
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("err %s", resp.Status)
+	resp.Body.Close()
+	if req.ContentLength != 0 || resp.StatusCode == http.StatusOK {
+		t.Fatalf("expected chunked upload over quota to fail, got %s", resp.Status)
 	}
-	b, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if resp, _ := do("GET", "bzz-keys:/bob/"+info.ID, "", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status %d getting key of another user, got %s", http.StatusNotFound, resp.Status)
+	}
+	resp, b = do("GET", "bzz-keys:/", "", "")
+	var infos []*api.GatewayKeyInfo
+	if err := json.Unmarshal(b, &infos); err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(databytes, b) {
-		t.Fatalf("Expected body '%x', got '%x'", databytes, b)
+	if len(infos) != 1 || infos[0].ID != info.ID {
+		t.Fatalf("unexpected keys %v", infos)
 	}
 
-	// update 2
-	log.Info("update 2")
-	url = fmt.Sprintf("%s/bzz-resource:/%s/raw", srv.URL, correctManifestKeyHex)
-	data := []byte("foo")
-	resp, err = http.Post(url, "application/octet-stream", bytes.NewReader(data))
-	if err != nil {
+	// the holder of a key cannot manage keys on the write listener, whatever
+	// the case of the scheme
+	for _, x := range []struct {
+		method, path, body string
+	}{
+		{"POST", "BZZ-KEYS:/mallory", `{"uploadBytes":1000}`},
+		{"PUT", "Bzz-Keys:/alice/" + info.ID, `{"uploadBytes":1000}`},
+		{"DELETE", "BZZ-KEYS:/alice/" + info.ID, ""},
+	} {
+		if resp, _ := doAt(writeSrv.URL, x.method, x.path, info.Token, x.body); resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("expected status %d on %s %s at the write listener, got %s", http.StatusForbidden, x.method, x.path, resp.Status)
+		}
+	}
+	resp, b = do("GET", "bzz-keys:/", "", "")
+	if err := json.Unmarshal(b, &infos); err != nil {
 		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("Update returned %s", resp.Status)
+	if len(infos) != 1 || infos[0].ID != info.ID || infos[0].Quota.UploadBytes != 10 {
+		t.Fatalf("expected the keys to be unchanged, got %v", infos)
 	}
 
-	// get latest update (1.2) through resource directly
-	log.Info("get update 1.2")
-	url = fmt.Sprintf("%s/bzz-resource:/%s", srv.URL, correctManifestKeyHex)
-	resp, err = http.Get(url)
-	if err != nil {
-		t.Fatal(err)
+	if resp, _ := do("PUT", "bzz-keys:/alice/"+info.ID, "", `{"disabled":true}`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d disabling key, got %s", http.StatusOK, resp.Status)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("err %s", resp.Status)
+	if resp, _ := do("POST", "bzz-raw:/", info.Token, "a"); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status %d uploading with disabled key, got %s", http.StatusForbidden, resp.Status)
 	}
-	b, err = ioutil.ReadAll(resp.Body)
-	if err != nil {
-		t.Fatal(err)
+	if resp, _ := do("DELETE", "bzz-keys:/alice/"+info.ID, "", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d revoking key, got %s", http.StatusOK, resp.Status)
 	}
-	if !bytes.Equal(data, b) {
-		t.Fatalf("Expected body '%x', got '%x'", data, b)
+	if resp, _ := do("POST", "bzz-raw:/", info.Token, "a"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status %d uploading with revoked key, got %s", http.StatusUnauthorized, resp.Status)
 	}
+}
 
-	// get latest update (1.2) with specified period
-	log.Info("get update latest = 1.2")
-	url = fmt.Sprintf("%s/bzz-resource:/%s/1", srv.URL, correctManifestKeyHex)
-	resp, err = http.Get(url)
+// TestBzzSearch tests searching the content uploaded to the node
+func TestBzzSearch(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		a.SetSearchIndex(api.NewSearchIndex(a, nil))
+		return serverFunc(a)
+	})
+	defer srv.Close()
+
+	page := "<html><head><title>Install</title></head><body>Run the swarm binary</body></html>"
+	resp, err := http.Post(srv.URL+"/bzz:/", "text/html", strings.NewReader(page))
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("err %s", resp.Status)
-	}
-	b, err = ioutil.ReadAll(resp.Body)
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(data, b) {
-		t.Fatalf("Expected body '%x', got '%x'", data, b)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload returned %s: %s", resp.Status, b)
 	}
+	root := string(b)
 
-	// get first update (1.1) with specified period and version
-	log.Info("get first update 1.1")
-	url = fmt.Sprintf("%s/bzz-resource:/%s/1/1", srv.URL, correctManifestKeyHex)
-	resp, err = http.Get(url)
-	if err != nil {
-		t.Fatal(err)
+	search := func(path string) (int, []*api.SearchResult) {
+		resp, err := http.Get(srv.URL + "/" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var results []*api.SearchResult
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, results
+	}
+	// uploads are indexed in the background
+	var (
+		status  int
+		results []*api.SearchResult
+	)
+	for i := 0; i < 100; i++ {
+		if status, results = search("bzz-search:/?q=swarm+binary"); len(results) > 0 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		t.Fatalf("err %s", resp.Status)
+	if status != http.StatusOK || len(results) != 1 {
+		t.Fatalf("expected 1 result, got %d: %v", status, results)
 	}
-	b, err = ioutil.ReadAll(resp.Body)
+	if results[0].Root != root || results[0].Title != "Install" || results[0].Snippet != "Run the swarm binary" {
+		t.Fatalf("unexpected result %+v", results[0])
+	}
+	if status, results := search("bzz-search:/" + root + "?q=missing"); status != http.StatusOK || len(results) != 0 {
+		t.Fatalf("expected no results, got %d: %v", status, results)
+	}
+	for _, path := range []string{"bzz-search:/", "bzz-search:/?q=swarm&limit=none"} {
+		if status, _ := search(path); status != http.StatusBadRequest {
+			t.Fatalf("%s: expected status %d, got %d", path, http.StatusBadRequest, status)
+		}
+	}
+
+	// nodes without an index do not search
+	plain := testutil.NewTestSwarmServer(t, serverFunc)
+	defer plain.Close()
+	resp, err = http.Get(plain.URL + "/bzz-search:/?q=swarm")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(databytes, b) {
-		t.Fatalf("Expected body '%x', got '%x'", databytes, b)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("expected status %d without index, got %s", http.StatusNotImplemented, resp.Status)
 	}
 }
 
@@ -682,3 +1607,108 @@ func TestMethodsNotAllowed(t *testing.T) {
 	}
 
 }
+
+// test that the recomputed root hash of the served payload is sent as a trailer
+// if the client opts in, and that it is omitted otherwise
+func TestBzzChecksumTrailer(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	data := make([]byte, 3*4096+13)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(srv.URL+"/bzz-raw:/", "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, optIn := range []bool{true, false} {
+		req, err := http.NewRequest("GET", srv.URL+"/bzz-raw:/"+string(hash), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if optIn {
+			req.Header.Set(ChecksumRequestHeader, "true")
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(body, data) {
+			t.Fatalf("expected response body to equal uploaded data")
+		}
+		trailer := res.Trailer.Get(ChecksumTrailer)
+		if optIn && trailer != string(hash) {
+			t.Fatalf("expected trailer %s to be %q, got %q", ChecksumTrailer, hash, trailer)
+		}
+		if !optIn && trailer != "" {
+			t.Fatalf("expected no %s trailer, got %q", ChecksumTrailer, trailer)
+		}
+	}
+}
+
+// test that convergent uploads of the same content result in the same
+// encrypted reference, unless they use different convergence secrets
+func TestBzzRawConvergent(t *testing.T) {
+	srv := testutil.NewTestSwarmServer(t, serverFunc)
+	defer srv.Close()
+
+	data := make([]byte, 2*4096+13)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	upload := func(secret string) string {
+		req, err := http.NewRequest("POST", srv.URL+"/bzz-raw:/convergent", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret != "" {
+			req.Header.Set(ConvergenceSecretHeader, secret)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, res.StatusCode, hash)
+		}
+		return string(hash)
+	}
+
+	hash := upload("")
+	if hash2 := upload(""); hash2 != hash {
+		t.Fatalf("expected convergent uploads to have the same hash, got %s and %s", hash, hash2)
+	}
+	if hash2 := upload("secret"); hash2 == hash {
+		t.Fatal("expected uploads with different secrets to have different hashes")
+	}
+
+	res, err := http.Get(srv.URL + "/bzz-raw:/" + hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data) {
+		t.Fatal("expected response body to equal uploaded data")
+	}
+}
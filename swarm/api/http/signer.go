@@ -0,0 +1,135 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/api"
+)
+
+// HandleSigner handles requests to bzz-signer:/<user> which manage the
+// publisher keys the gateway keeps on behalf of its users.
+//
+// POST creates a key for the user and returns its address and the token which
+// authenticates the user, the token is not shown again. GET returns the
+// address of the key, or the private key itself when requested at
+// bzz-signer:/<user>/key. DELETE removes the key.
+//
+// Except for creation, the user authenticates with basic auth, using the user
+// name and the token as password. The same credentials sign resource updates
+// with the key of the user when given on requests to bzz-resource.
+//
+// Creation and export are on the admin surface, which the gateway only serves
+// on a listener of its own, see StartHttpServer. The private key is only
+// exported over https or to a client on the same host.
+func (s *Server) HandleSigner(w http.ResponseWriter, r *Request) {
+	log.Debug("handle.signer", "ruid", r.ruid, "method", r.Method, "user", r.uri.Addr)
+	if uriSurface(r.Method, r.uri) == SurfaceAdmin && !checkAdmin(w, r) {
+		return
+	}
+	signers := s.api.ManagedSigners()
+	if signers == nil {
+		Respond(w, r, api.ErrManagedDisabled.Error(), http.StatusNotImplemented)
+		return
+	}
+	user := r.uri.Addr
+	if user == "" {
+		Respond(w, r, "missing user", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "POST" {
+		info, err := signers.Create(user)
+		switch {
+		case err == api.ErrManagedUserExists:
+			Respond(w, r, err.Error(), http.StatusConflict)
+		case err != nil:
+			Respond(w, r, fmt.Sprintf("cannot create key of %s: %s", user, err), http.StatusBadRequest)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(info)
+		}
+		return
+	}
+
+	authUser, token, ok := managedCredentials(r)
+	if !ok || authUser != user {
+		w.Header().Set("WWW-Authenticate", `Basic realm="swarm"`)
+		Respond(w, r, api.ErrManagedAuth.Error(), http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == "DELETE":
+		if err := signers.Delete(user, token); err != nil {
+			Respond(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, user)
+	case r.uri.Path == "key":
+		if !secureConn(r) {
+			Respond(w, r, "the private key is only exported over https", http.StatusForbidden)
+			return
+		}
+		privKey, err := signers.Export(user, token)
+		if err != nil {
+			Respond(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, hexutil.Encode(crypto.FromECDSA(privKey)))
+	case r.uri.Path == "":
+		info, err := signers.Info(user, token)
+		if err != nil {
+			Respond(w, r, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	default:
+		Respond(w, r, fmt.Sprintf("invalid path %q", r.uri.Path), http.StatusNotFound)
+	}
+}
+
+// managedCredentials returns the user and token of a managed publisher key
+// given with basic auth
+func managedCredentials(r *Request) (user, token string, ok bool) {
+	user, token, ok = r.BasicAuth()
+	return user, token, ok && user != "" && token != ""
+}
+
+// secureConn returns true if the request was received over TLS or from a
+// client on the same host
+func secureConn(r *Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
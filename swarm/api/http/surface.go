@@ -0,0 +1,127 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/api"
+)
+
+// Surface is a set of APIs of the server which can be served on a listener,
+// so that operators can e.g. expose reads publicly, writes on an internal
+// network and admin on localhost only
+type Surface uint8
+
+const (
+	// SurfaceRead are the GET and HEAD requests retrieving content
+	SurfaceRead Surface = 1 << iota
+	// SurfaceWrite are the requests uploading, modifying or deleting content
+	SurfaceWrite
+	// SurfaceAdmin are the requests managing the node, e.g. pinning with
+	// bzz-pin, issuing API keys with bzz-keys, and creating and exporting
+	// managed publisher keys with bzz-signer
+	SurfaceAdmin
+
+	SurfaceAll = SurfaceRead | SurfaceWrite | SurfaceAdmin
+)
+
+var surfaceRejectCount = metrics.NewRegisteredCounter("api.http.surface.reject.count", nil)
+
+// key of the surfaces of the listener in the request context
+type surfaceContextKey struct{}
+
+func (s Surface) String() string {
+	var names []string
+	for _, x := range []struct {
+		surface Surface
+		name    string
+	}{
+		{SurfaceRead, "read"},
+		{SurfaceWrite, "write"},
+		{SurfaceAdmin, "admin"},
+	} {
+		if s&x.surface != 0 {
+			names = append(names, x.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// requestSurface returns the surface a request belongs to. The path is
+// parsed like ServeHTTP does, so that the scheme is matched whatever its case.
+func requestSurface(r *http.Request) Surface {
+	uri, _ := api.Parse(strings.TrimLeft(r.URL.Path, "/"))
+	return uriSurface(r.Method, uri)
+}
+
+// uriSurface returns the surface of a request with the method to the parsed
+// uri, by method only if uri is nil
+func uriSurface(method string, uri *api.URI) Surface {
+	if uri != nil {
+		if uri.Pin() || uri.GatewayKeys() {
+			return SurfaceAdmin
+		}
+		// creating managed publisher keys and exporting their private keys
+		if uri.Signer() && (method == "POST" || uri.Path == "key") {
+			return SurfaceAdmin
+		}
+	}
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return SurfaceRead
+	}
+	return SurfaceWrite
+}
+
+// Surface returns a handler serving only the requests of the given surfaces,
+// other requests are rejected with 403 Forbidden
+func (s *Server) Surface(surfaces Surface) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if surface := requestSurface(r); surfaces&surface == 0 {
+			surfaceRejectCount.Inc(1)
+			http.Error(w, fmt.Sprintf("%s API not served on this listener", surface), http.StatusForbidden)
+			return
+		}
+		s.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), surfaceContextKey{}, surfaces)))
+	})
+}
+
+// listenerSurface returns the surfaces served on the listener the request was
+// received on, all surfaces if it was not received through Surface
+func listenerSurface(r *http.Request) Surface {
+	if surfaces, ok := r.Context().Value(surfaceContextKey{}).(Surface); ok {
+		return surfaces
+	}
+	return SurfaceAll
+}
+
+// checkAdmin reports whether the request was received on a listener serving
+// the admin surface, and rejects it otherwise. Admin handlers check it in
+// addition to Surface.
+func checkAdmin(w http.ResponseWriter, r *Request) bool {
+	if listenerSurface(&r.Request)&SurfaceAdmin != 0 {
+		return true
+	}
+	surfaceRejectCount.Inc(1)
+	Respond(w, r, fmt.Sprintf("%s API not served on this listener", SurfaceAdmin), http.StatusForbidden)
+	return false
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/api"
+	"github.com/ethereum/go-ethereum/swarm/testutil"
+)
+
+// TestServerSurfaces tests that listeners only serve the requests of their
+// surfaces, and that the admin surface pins content
+func TestServerSurfaces(t *testing.T) {
+	var server *Server
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		server = NewServer(a)
+		return server.Surface(SurfaceRead)
+	})
+	defer srv.Close()
+	writeSrv := httptest.NewServer(server.Surface(SurfaceWrite))
+	defer writeSrv.Close()
+	adminSrv := httptest.NewServer(server.Surface(SurfaceAdmin))
+	defer adminSrv.Close()
+
+	do := func(method, url string, body []byte) (int, string) {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(data)
+	}
+
+	data := []byte("surfaces")
+	if code, _ := do("POST", srv.URL+"/bzz-raw:/", data); code != http.StatusForbidden {
+		t.Fatalf("expected write on read listener to be forbidden, got status %d", code)
+	}
+	code, hash := do("POST", writeSrv.URL+"/bzz-raw:/", data)
+	if code != http.StatusOK {
+		t.Fatalf("expected write on write listener to succeed, got status %d", code)
+	}
+	if code, _ := do("GET", writeSrv.URL+"/bzz-raw:/"+hash, nil); code != http.StatusForbidden {
+		t.Fatalf("expected read on write listener to be forbidden, got status %d", code)
+	}
+	if code, body := do("GET", srv.URL+"/bzz-raw:/"+hash, nil); code != http.StatusOK || body != string(data) {
+		t.Fatalf("expected read on read listener to succeed, got status %d content %q", code, body)
+	}
+
+	// managed publisher keys are created and exported on the admin listener
+	if code, _ := do("POST", writeSrv.URL+"/bzz-signer:/alice", nil); code != http.StatusForbidden {
+		t.Fatalf("expected publisher key creation on write listener to be forbidden, got status %d", code)
+	}
+	if code, _ := do("GET", srv.URL+"/bzz-signer:/alice/key", nil); code != http.StatusForbidden {
+		t.Fatalf("expected publisher key export on read listener to be forbidden, got status %d", code)
+	}
+
+	// the scheme is matched whatever its case, as it is parsed
+	for _, x := range []struct {
+		method, url string
+	}{
+		{"GET", srv.URL + "/BZZ-PIN:/"},
+		{"GET", srv.URL + "/BZZ-KEYS:/alice"},
+		{"GET", srv.URL + "/Bzz-Signer:/alice/key"},
+		{"POST", writeSrv.URL + "/BZZ-PIN:/" + hash},
+		{"POST", writeSrv.URL + "/BZZ-KEYS:/alice"},
+		{"PUT", writeSrv.URL + "/Bzz-Keys:/alice/id"},
+		{"POST", writeSrv.URL + "/BZZ-SIGNER:/alice"},
+	} {
+		if code, _ := do(x.method, x.url, nil); code != http.StatusForbidden {
+			t.Fatalf("expected %s %s to be forbidden, got status %d", x.method, x.url, code)
+		}
+	}
+
+	// the admin handlers reject requests which were not received on the
+	// admin listener, even if they get past Surface
+	for _, path := range []string{"/bzz-pin:/", "/bzz-keys:/alice", "/bzz-signer:/alice/key"} {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), surfaceContextKey{}, SurfaceRead)))
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected %s on read listener to be forbidden by its handler, got status %d", path, rec.Code)
+		}
+	}
+
+	// notarizations are paid by the node, they are refused on the write listener
+	req, err := http.NewRequest("POST", writeSrv.URL+"/bzz-raw:/", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(NotarizeRequestHeader, "true")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected notarization on write listener to be forbidden, got status %d", res.StatusCode)
+	}
+
+	// pinning is only served on the admin listener
+	for _, url := range []string{srv.URL, writeSrv.URL} {
+		if code, _ := do("POST", url+"/bzz-pin:/"+hash, nil); code != http.StatusForbidden {
+			t.Fatalf("expected pin on %s to be forbidden, got status %d", url, code)
+		}
+	}
+	if code, body := do("POST", adminSrv.URL+"/bzz-pin:/"+hash, nil); code != http.StatusOK || body != hash {
+		t.Fatalf("expected pin to succeed, got status %d content %q", code, body)
+	}
+	code, body := do("GET", adminSrv.URL+"/bzz-pin:/", nil)
+	if code != http.StatusOK {
+		t.Fatalf("expected pin list to succeed, got status %d", code)
+	}
+	var pinned []string
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&pinned); err != nil {
+		t.Fatal(err)
+	}
+	if len(pinned) != 1 || pinned[0] != hash {
+		t.Fatalf("expected pins [%s], got %v", hash, pinned)
+	}
+	if code, _ := do("DELETE", adminSrv.URL+"/bzz-pin:/"+hash, nil); code != http.StatusOK {
+		t.Fatalf("expected unpin to succeed, got status %d", code)
+	}
+	if code, _ := do("DELETE", adminSrv.URL+"/bzz-pin:/"+hash, nil); code != http.StatusNotFound {
+		t.Fatalf("expected unpin of content which is not pinned to fail, got status %d", code)
+	}
+}
+
+// TestStartHttpServerListenFail tests that the startup fails if the listener
+// of the write or admin API cannot be opened
+func TestStartHttpServerListenFail(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	a := api.NewApi(nil, nil, nil)
+	for _, config := range []*ServerConfig{
+		{Addr: "127.0.0.1:0", WriteAddr: l.Addr().String()},
+		{Addr: "127.0.0.1:0", AdminAddr: l.Addr().String()},
+	} {
+		if err := StartHttpServer(a, config); err == nil {
+			t.Fatalf("expected startup to fail with the address in use, config %+v", config)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+/*
+The gateway terminates TLS itself when a certificate is configured, so small
+operators can expose it without a reverse proxy. Certificates are selected by
+the server name the client asks for (SNI):
+
+1. a certificate of the host from the certificate directory, stored as
+   <host>.crt and <host>.key in PEM format
+2. a certificate obtained by the certificate manager, if the host is one of
+   the configured virtual hosts
+3. the default certificate
+
+The certificate manager is typically an ACME client obtaining certificates
+from Let's Encrypt, e.g. an autocert.Manager from golang.org/x/crypto/acme/autocert
+with a host policy built by VirtualHostPolicy. It also answers the http-01
+challenges on the plain HTTP listener. The swarm command does not configure a
+manager, so its https listener needs a default certificate or a certificate
+directory, and it fails to start without.
+*/
+
+// CertificateManager provides certificates on demand, e.g. using ACME
+type CertificateManager interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+	HTTPHandler(fallback http.Handler) http.Handler
+}
+
+// TLSConfig configures TLS termination of the gateway
+type TLSConfig struct {
+	Addr     string             // address of the https listener
+	CertFile string             // default certificate
+	KeyFile  string             // key of the default certificate
+	CertDir  string             // directory of per host certificates <host>.crt and <host>.key
+	Manager  CertificateManager // optional manager providing certificates for virtual hosts
+}
+
+// Enabled returns true if TLS termination is configured
+func (c *TLSConfig) Enabled() bool {
+	return c != nil && (c.CertFile != "" || c.CertDir != "" || c.Manager != nil)
+}
+
+// VirtualHostPolicy returns a host policy accepting only the given virtual
+// hosts, suitable for ACME managers so that certificates are only requested
+// for the domains the gateway serves
+func VirtualHostPolicy(hosts map[string]string) func(host string) error {
+	return func(host string) error {
+		if _, ok := hosts[normalizeHost(host)]; !ok {
+			return fmt.Errorf("host %q is not a virtual host of this gateway", host)
+		}
+		return nil
+	}
+}
+
+// certificates selects the certificate of a TLS connection
+type certificates struct {
+	hosts        map[string]*tls.Certificate
+	def          *tls.Certificate
+	manager      CertificateManager
+	managePolicy func(host string) error
+}
+
+// NewTLSConfig loads the configured certificates and returns the TLS
+// configuration of the gateway serving the given virtual hosts
+func NewTLSConfig(config *TLSConfig, virtualHosts map[string]string) (*tls.Config, error) {
+	certs := &certificates{
+		hosts:        make(map[string]*tls.Certificate),
+		manager:      config.Manager,
+		managePolicy: VirtualHostPolicy(virtualHosts),
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load tls certificate: %v", err)
+		}
+		certs.def = &cert
+	}
+	if config.CertDir != "" {
+		if err := certs.loadDir(config.CertDir); err != nil {
+			return nil, err
+		}
+	}
+	if certs.def == nil && len(certs.hosts) == 0 && certs.manager == nil {
+		return nil, errors.New("no tls certificates configured")
+	}
+	return &tls.Config{
+		GetCertificate: certs.get,
+		MinVersion:     tls.VersionTLS12,
+	}, nil
+}
+
+// loadDir loads the <host>.crt and <host>.key pairs of dir
+func (c *certificates) loadDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("cannot read tls certificate directory: %v", err)
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".crt") {
+			continue
+		}
+		host := strings.TrimSuffix(f.Name(), ".crt")
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, host+".crt"), filepath.Join(dir, host+".key"))
+		if err != nil {
+			return fmt.Errorf("cannot load tls certificate of %s: %v", host, err)
+		}
+		c.hosts[normalizeHost(host)] = &cert
+		log.Debug("loaded tls certificate", "host", host)
+	}
+	return nil
+}
+
+func (c *certificates) get(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := normalizeHost(hello.ServerName)
+	if cert, ok := c.hosts[host]; ok {
+		return cert, nil
+	}
+	if c.manager != nil && c.managePolicy(host) == nil {
+		return c.manager.GetCertificate(hello)
+	}
+	if c.def != nil {
+		return c.def, nil
+	}
+	return nil, fmt.Errorf("no tls certificate for %q", hello.ServerName)
+}
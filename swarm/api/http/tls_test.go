@@ -0,0 +1,136 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self signed certificate for host to dir/name.crt and dir/name.key
+func writeTestCert(t *testing.T, dir, name, host string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyder, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyder})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testCertManager struct {
+	cert *tls.Certificate
+}
+
+func (m *testCertManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return m.cert, nil
+}
+
+func (m *testCertManager) HTTPHandler(fallback http.Handler) http.Handler {
+	return fallback
+}
+
+// TestTLSCertificates tests that certificates are selected by server name
+func TestTLSCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-tls-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certDir := filepath.Join(dir, "certs")
+	if err := os.Mkdir(certDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestCert(t, dir, "default", "gateway.test")
+	writeTestCert(t, dir, "managed", "managed.test")
+	writeTestCert(t, certDir, "example.test", "example.test")
+
+	managed, err := tls.LoadX509KeyPair(filepath.Join(dir, "managed.crt"), filepath.Join(dir, "managed.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := NewTLSConfig(&TLSConfig{
+		CertFile: filepath.Join(dir, "default.crt"),
+		KeyFile:  filepath.Join(dir, "default.key"),
+		CertDir:  certDir,
+		Manager:  &testCertManager{&managed},
+	}, map[string]string{"managed.test": "managed.eth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = config
+	srv.StartTLS()
+	defer srv.Close()
+
+	for serverName, expected := range map[string]string{
+		"example.test": "example.test",
+		"EXAMPLE.test": "example.test",
+		"managed.test": "managed.test",
+		"other.test":   "gateway.test",
+	} {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+		}}
+		res, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if cn := res.TLS.PeerCertificates[0].Subject.CommonName; cn != expected {
+			t.Fatalf("%s: expected certificate of %s, got %s", serverName, expected, cn)
+		}
+	}
+
+	if _, err := NewTLSConfig(&TLSConfig{}, nil); err == nil {
+		t.Fatal("expected error without certificates")
+	}
+}
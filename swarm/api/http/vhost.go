@@ -0,0 +1,104 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/api"
+)
+
+/*
+Virtual hosts let one gateway serve many swarm sites under their own domains.
+A request whose Host header matches a virtual host is served as a bzz request
+for the configured ENS name or manifest hash, using the request path as the
+path in the manifest:
+
+	http://example.com/docs/index.html -> bzz:/example.eth/docs/index.html
+
+Since the site root is the root of the domain, relative and absolute paths in
+the served pages resolve as they would on any web server.
+*/
+
+// ParseVirtualHosts parses a list of virtual hosts of the form
+// example.com=example.eth,blog.example.com=<manifest hash>
+func ParseVirtualHosts(s string) (map[string]string, error) {
+	hosts := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.Index(item, "=")
+		if i <= 0 || i == len(item)-1 {
+			return nil, fmt.Errorf("invalid virtual host %q, expected <host>=<ens name or hash>", item)
+		}
+		hosts[normalizeHost(item[:i])] = item[i+1:]
+	}
+	return hosts, nil
+}
+
+// SetVirtualHost serves the site with the given ENS name or manifest hash on
+// requests for host. An empty target removes the virtual host.
+func (s *Server) SetVirtualHost(host, target string) {
+	s.hostsMu.Lock()
+	defer s.hostsMu.Unlock()
+	if target == "" {
+		delete(s.hosts, normalizeHost(host))
+		return
+	}
+	s.hosts[normalizeHost(host)] = target
+}
+
+// virtualHost returns the target of the virtual host of the request, if any
+func (s *Server) virtualHost(r *http.Request) (string, bool) {
+	s.hostsMu.RLock()
+	defer s.hostsMu.RUnlock()
+	if len(s.hosts) == 0 {
+		return "", false
+	}
+	target, ok := s.hosts[normalizeHost(r.Host)]
+	return target, ok
+}
+
+// serveVirtualHost serves a request to a virtual host from the site's manifest.
+// Virtual hosts are read only.
+func (s *Server) serveVirtualHost(w http.ResponseWriter, r *Request, target string) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		Respond(w, r, fmt.Sprintf("%s method is not supported on virtual host %s", r.Method, r.Host), http.StatusMethodNotAllowed)
+		return
+	}
+	r.uri = &api.URI{
+		Scheme: "bzz",
+		Addr:   target,
+		Path:   strings.TrimLeft(r.URL.Path, "/"),
+	}
+	log.Debug("serving virtual host", "ruid", r.ruid, "host", r.Host, "uri.Addr", r.uri.Addr, "uri.Path", r.uri.Path)
+	s.HandleGetFile(w, r)
+}
+
+// normalizeHost strips the port from host and lower cases it
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/api"
+	swarm "github.com/ethereum/go-ethereum/swarm/api/client"
+	"github.com/ethereum/go-ethereum/swarm/testutil"
+)
+
+// TestBzzVirtualHost tests that requests to a virtual host are served from
+// the configured site and that other hosts are served by the gateway
+func TestBzzVirtualHost(t *testing.T) {
+	var server *Server
+	srv := testutil.NewTestSwarmServer(t, func(a *api.Api) testutil.TestServer {
+		server = NewServer(a)
+		return server
+	})
+	defer srv.Close()
+
+	client := swarm.NewClient(srv.URL)
+	// the empty path is the default entry of the site
+	files := map[string]string{
+		"":                "<a href=\"docs/index.html\">docs</a>",
+		"docs/index.html": "documentation",
+		"docs/style.css":  "body {}",
+	}
+	var mhash string
+	var err error
+	for path, content := range files {
+		contentType := "text/html; charset=utf-8"
+		if strings.HasSuffix(path, ".css") {
+			contentType = "text/css"
+		}
+		file := &swarm.File{
+			ReadCloser: ioutil.NopCloser(strings.NewReader(content)),
+			ManifestEntry: api.ManifestEntry{
+				Path:        path,
+				ContentType: contentType,
+				Size:        int64(len(content)),
+			},
+		}
+		mhash, err = client.Upload(file, mhash, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.SetVirtualHost("Example.com", mhash)
+
+	get := func(host, path string) (int, string) {
+		req, err := http.NewRequest("GET", srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	for _, x := range []struct {
+		path    string
+		content string
+	}{
+		{"/", files[""]},
+		{"/docs/index.html", files["docs/index.html"]},
+		{"/docs/style.css", files["docs/style.css"]},
+	} {
+		code, body := get("example.com:8500", x.path)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d", x.path, http.StatusOK, code)
+		}
+		if body != x.content {
+			t.Fatalf("%s: expected content %q, got %q", x.path, x.content, body)
+		}
+	}
+	// writes are not allowed on virtual hosts
+	req, err := http.NewRequest("POST", srv.URL+"/bzz-raw:/", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "example.com"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected status %d for POST, got %d", http.StatusMethodNotAllowed, res.StatusCode)
+	}
+
+	// other hosts are served by the gateway
+	if code, body := get("gateway.com", "/bzz:/"+mhash+"/docs/style.css"); code != http.StatusOK || body != files["docs/style.css"] {
+		t.Fatalf("expected gateway to serve the site, got status %d content %q", code, body)
+	}
+
+	server.SetVirtualHost("example.com", "")
+	if code, _ := get("example.com", "/docs/style.css"); code == http.StatusOK {
+		t.Fatal("expected removed virtual host not to be served")
+	}
+}
+
+func TestParseVirtualHosts(t *testing.T) {
+	hosts, err := ParseVirtualHosts("Example.com=example.eth, blog.example.com:80=1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 || hosts["example.com"] != "example.eth" || hosts["blog.example.com"] != "1234" {
+		t.Fatalf("unexpected virtual hosts %v", hosts)
+	}
+	for _, s := range []string{"example.com", "=example.eth", "example.com="} {
+		if _, err := ParseVirtualHosts(s); err == nil {
+			t.Fatalf("expected error parsing %q", s)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/multihash"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const (
+	// IPFSMaxContentSize is the largest content the bridge fetches from the gateway
+	IPFSMaxContentSize = 64 * 1024 * 1024
+
+	ipfsScheme      = "ipfs://"
+	ipfsPathPrefix  = "/ipfs/"
+	ipfsCachePrefix = "ipfs-"
+	ipfsTimeout     = 60 * time.Second
+)
+
+var (
+	ipfsFetchCount = metrics.NewRegisteredCounter("api.ipfs.fetch.count", nil)
+	ipfsFetchFail  = metrics.NewRegisteredCounter("api.ipfs.fetch.fail", nil)
+	ipfsCacheHit   = metrics.NewRegisteredCounter("api.ipfs.cache.hit", nil)
+)
+
+// ipfsCacheEntry records where the content of a CID was stored in swarm
+type ipfsCacheEntry struct {
+	Key         string `json:"key"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+/*
+IPFSBridge is a read-through bridge to IPFS content.
+
+Resources can reference IPFS content either with a multihash update holding a
+CIDv0 (sha2-256 multihash), or with a plain update holding an ipfs://<cid>
+URI. When such a resource is retrieved through the bzz scheme, the bridge
+fetches the content from the configured IPFS HTTP gateway, stores it in swarm
+and serves it from there. The CID to swarm key mapping is kept in the state
+store, so content is only fetched from the gateway once.
+
+Content of raw CIDs is verified against the CID multihash. Other codecs (such
+as dag-pb files) encode the content in IPFS specific DAGs which cannot be
+verified here, so the gateway has to be trusted for those.
+*/
+type IPFSBridge struct {
+	gateway string
+	client  *http.Client
+	dpa     *storage.DPA
+	store   state.Store // may be nil, then the mapping is only kept in memory
+	mu      sync.Mutex
+	cache   map[string]*ipfsCacheEntry
+}
+
+// NewIPFSBridge creates a bridge fetching content from the given gateway URL (e.g. https://ipfs.io)
+func NewIPFSBridge(gateway string, dpa *storage.DPA, store state.Store) *IPFSBridge {
+	return &IPFSBridge{
+		gateway: strings.TrimSuffix(gateway, "/"),
+		client:  &http.Client{Timeout: ipfsTimeout},
+		dpa:     dpa,
+		store:   store,
+		cache:   make(map[string]*ipfsCacheEntry),
+	}
+}
+
+// ParseIPFSReference parses an ipfs://<cid> URI, an /ipfs/<cid> path, a
+// string CID or a binary CID as found in resource updates
+func ParseIPFSReference(data []byte) (*multihash.Cid, error) {
+	s := strings.TrimSpace(string(data))
+	switch {
+	case strings.HasPrefix(s, ipfsScheme):
+		s = strings.TrimPrefix(s, ipfsScheme)
+	case strings.HasPrefix(s, ipfsPathPrefix):
+		s = strings.TrimPrefix(s, ipfsPathPrefix)
+	default:
+		if cid, err := multihash.CastCid(data); err == nil {
+			return cid, nil
+		}
+	}
+	s = strings.TrimSuffix(s, "/")
+	if strings.Contains(s, "/") {
+		return nil, fmt.Errorf("ipfs paths are not supported: %s", s)
+	}
+	return multihash.ParseCid(s)
+}
+
+// isIPFSReference returns true if the data of a plain resource update is an ipfs:// URI
+func isIPFSReference(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ipfsScheme))
+}
+
+// Fetch returns the swarm key and content type of the content of the CID,
+// fetching it from the gateway and storing it in swarm if it is not cached yet
+func (b *IPFSBridge) Fetch(ctx context.Context, cid *multihash.Cid) (storage.Key, string, error) {
+	id := cid.String()
+	if entry := b.cached(id); entry != nil {
+		ipfsCacheHit.Inc(1)
+		return common.Hex2Bytes(entry.Key), entry.ContentType, nil
+	}
+
+	ipfsFetchCount.Inc(1)
+	log.Debug("ipfs bridge fetching", "cid", id, "gateway", b.gateway)
+	entry, err := b.fetch(ctx, cid)
+	if err != nil {
+		ipfsFetchFail.Inc(1)
+		return nil, "", err
+	}
+
+	b.mu.Lock()
+	b.cache[id] = entry
+	b.mu.Unlock()
+	if b.store != nil {
+		if err := b.store.Put(ipfsCachePrefix+id, entry); err != nil {
+			log.Warn("ipfs bridge could not persist cid mapping", "cid", id, "err", err)
+		}
+	}
+	return common.Hex2Bytes(entry.Key), entry.ContentType, nil
+}
+
+func (b *IPFSBridge) cached(id string) *ipfsCacheEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if entry, ok := b.cache[id]; ok {
+		return entry
+	}
+	if b.store == nil {
+		return nil
+	}
+	entry := &ipfsCacheEntry{}
+	if err := b.store.Get(ipfsCachePrefix+id, entry); err != nil {
+		return nil
+	}
+	b.cache[id] = entry
+	return entry
+}
+
+func (b *IPFSBridge) fetch(ctx context.Context, cid *multihash.Cid) (*ipfsCacheEntry, error) {
+	req, err := http.NewRequest("GET", b.gateway+ipfsPathPrefix+cid.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("ipfs gateway request failed: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ipfs gateway responded with %s", res.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, IPFSMaxContentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading ipfs gateway response: %v", err)
+	}
+	if len(data) > IPFSMaxContentSize {
+		return nil, fmt.Errorf("ipfs content exceeds %d bytes", IPFSMaxContentSize)
+	}
+	if cid.Codec == multihash.CodecRaw {
+		if err := verifyIPFSContent(cid, data); err != nil {
+			return nil, err
+		}
+	}
+
+	key, wait, err := b.dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		return nil, err
+	}
+	wait()
+	log.Debug("ipfs bridge stored content", "cid", cid, "key", key, "size", len(data))
+	return &ipfsCacheEntry{
+		Key:         key.Hex(),
+		ContentType: res.Header.Get("Content-Type"),
+		Size:        int64(len(data)),
+	}, nil
+}
+
+// verifyIPFSContent checks that the content hashes to the multihash of the CID
+func verifyIPFSContent(cid *multihash.Cid, data []byte) error {
+	dm, err := multihash.Decode(cid.Hash)
+	if err != nil {
+		return err
+	}
+	sum, err := multihash.Sum(data, dm.Code, dm.Length)
+	if err != nil {
+		return fmt.Errorf("cannot verify ipfs content: %v", err)
+	}
+	if !bytes.Equal(sum, cid.Hash) {
+		return fmt.Errorf("ipfs content does not match cid %s", cid)
+	}
+	return nil
+}
+
+// SetIPFSBridge enables serving IPFS content referenced by resources through the bridge
+func (self *Api) SetIPFSBridge(bridge *IPFSBridge) {
+	self.ipfs = bridge
+}
+
+// getIPFS serves the content of the CID through the IPFS bridge
+func (self *Api) getIPFS(ctx context.Context, cid *multihash.Cid) (reader storage.LazySectionReader, mimeType string, status int, contentKey storage.Key, err error) {
+	if self.ipfs == nil {
+		apiGetInvalid.Inc(1)
+		return nil, "", http.StatusUnprocessableEntity, nil, fmt.Errorf("resource references ipfs content %s, but the ipfs bridge is disabled", cid)
+	}
+	contentKey, mimeType, err = self.ipfs.Fetch(ctx, cid)
+	if err != nil {
+		apiGetNotFound.Inc(1)
+		log.Warn(fmt.Sprintf("ipfs bridge error: %v", err))
+		return nil, "", http.StatusBadGateway, nil, err
+	}
+	reader, _ = self.dpa.Retrieve(contentKey)
+	return reader, mimeType, http.StatusOK, contentKey, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/multihash"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+func TestParseIPFSReference(t *testing.T) {
+	sum, err := multihash.Sum([]byte("hello ipfs"), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v0, err := multihash.NewCidV0(sum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := multihash.NewCidV1(multihash.CodecRaw, sum)
+	if !strings.HasPrefix(v0.String(), "Qm") {
+		t.Fatalf("expected base58 CIDv0, got %s", v0)
+	}
+	if !strings.HasPrefix(v1.String(), "b") {
+		t.Fatalf("expected base32 CIDv1, got %s", v1)
+	}
+
+	for _, x := range []struct {
+		ref string
+		exp *multihash.Cid
+	}{
+		{v0.String(), v0},
+		{"ipfs://" + v0.String(), v0},
+		{"/ipfs/" + v0.String() + "/", v0},
+		{"ipfs://" + v1.String(), v1},
+		{"z" + multihash.Multihash(v1.Bytes()).B58String(), v1},
+		{"f" + hex.EncodeToString(v1.Bytes()), v1},
+		{"B" + strings.ToUpper(v1.String()[1:]), v1},
+		{string(v1.Bytes()), v1},
+	} {
+		cid, err := ParseIPFSReference([]byte(x.ref))
+		if err != nil {
+			t.Fatalf("%q: %v", x.ref, err)
+		}
+		if cid.String() != x.exp.String() || cid.Codec != x.exp.Codec || cid.Version != x.exp.Version {
+			t.Fatalf("%q: expected %s (codec %x), got %s (codec %x)", x.ref, x.exp, x.exp.Codec, cid, cid.Codec)
+		}
+	}
+
+	for _, ref := range []string{"", "ipfs://", "ipfs://" + v0.String() + "/index.html", "xfoo", "bfoo"} {
+		if _, err := ParseIPFSReference([]byte(ref)); err == nil {
+			t.Fatalf("%q: expected error", ref)
+		}
+	}
+}
+
+// TestIPFSBridge tests that content is fetched from the gateway once,
+// verified against raw CIDs and served from swarm afterwards
+func TestIPFSBridge(t *testing.T) {
+	content := []byte("hello ipfs")
+	sum, err := multihash.Sum(content, multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid := multihash.NewCidV1(multihash.CodecRaw, sum)
+	badSum, err := multihash.Sum([]byte("other"), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badCid := multihash.NewCidV1(multihash.CodecRaw, badSum)
+
+	var requests int
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/ipfs/" + cid.String(), "/ipfs/" + badCid.String():
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer gateway.Close()
+
+	datadir, err := ioutil.TempDir("", "bzz-ipfs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	dpa, err := storage.NewLocalDPA(datadir, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := state.NewInmemoryStore()
+	bridge := NewIPFSBridge(gateway.URL+"/", dpa, store)
+
+	key, contentType, err := bridge.Fetch(context.Background(), cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "text/plain" {
+		t.Fatalf("expected content type text/plain, got %s", contentType)
+	}
+	reader, _ := dpa.Retrieve(key)
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(content) {
+		t.Fatalf("expected %q, got %q", content, data)
+	}
+
+	// the mapping survives a new bridge using the same state store
+	bridge = NewIPFSBridge(gateway.URL, dpa, store)
+	cachedKey, _, err := bridge.Fetch(context.Background(), cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cachedKey, key) {
+		t.Fatalf("expected cached key %v, got %v", key, cachedKey)
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 gateway request, got %d", requests)
+	}
+
+	if _, _, err := bridge.Fetch(context.Background(), badCid); err == nil {
+		t.Fatal("expected error fetching content not matching the cid")
+	}
+	missing := multihash.NewCidV1(multihash.CodecDagProtobuf, badSum)
+	if _, _, err := bridge.Fetch(context.Background(), missing); err == nil {
+		t.Fatal("expected error fetching missing content")
+	}
+}
@@ -39,6 +39,14 @@ const (
 )
 
 // Manifest represents a swarm manifest
+//
+// The encoding of a manifest only depends on its entries, so that storing the
+// same entries yields the same hash on any node. Manifests are tries split on
+// the common prefixes of the entry paths, whose shape does not depend on the
+// order the entries were added in. Each manifest lists its entries in the
+// order of the first byte of their path, with the entry of the empty path
+// last, and encodes their fields in the order of ManifestEntry, leaving out
+// the empty ones other than the modification time, which is encoded in UTC.
 type Manifest struct {
 	Entries []ManifestEntry `json:"entries,omitempty"`
 }
@@ -117,6 +125,19 @@ func (m *ManifestWriter) AddEntry(data io.Reader, e *ManifestEntry) (storage.Key
 	return key, nil
 }
 
+// AddStoredEntry adds content which is stored already to the manifest, it
+// must be encrypted if the manifest is, see Encrypted
+func (m *ManifestWriter) AddStoredEntry(key storage.Key, e *ManifestEntry) {
+	entry := newManifestTrieEntry(e, nil)
+	entry.Hash = key.Hex()
+	m.trie.addEntry(entry, m.quitC)
+}
+
+// Encrypted returns true if the content added to the manifest is encrypted
+func (m *ManifestWriter) Encrypted() bool {
+	return m.trie.encrypted
+}
+
 // RemoveEntry removes the given path from the manifest
 func (m *ManifestWriter) RemoveEntry(path string) error {
 	m.trie.deleteEntry(path, m.quitC)
@@ -192,10 +213,13 @@ type manifestTrie struct {
 }
 
 func newManifestTrieEntry(entry *ManifestEntry, subtrie *manifestTrie) *manifestTrieEntry {
-	return &manifestTrieEntry{
+	e := &manifestTrieEntry{
 		ManifestEntry: *entry,
 		subtrie:       subtrie,
 	}
+	// the time zone of the node must not change the hash of the manifest
+	e.ModTime = e.ModTime.UTC()
+	return e
 }
 
 type manifestTrieEntry struct {
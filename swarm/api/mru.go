@@ -0,0 +1,126 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// ResourceLookupResult is an update of a mutable resource found by a lookup
+// of the mru RPC API, with its data
+type ResourceLookupResult struct {
+	ResourceUpdateInfo
+	Data hexutil.Bytes `json:"data"`
+}
+
+// ResourceUpdateResult is the key, period and version of a published update
+type ResourceUpdateResult struct {
+	Key     storage.Key `json:"key"`
+	Period  uint32      `json:"period"`
+	Version uint32      `json:"version"`
+}
+
+// PublicResourceAPI is the public part of the mru RPC API, looking up the
+// updates of mutable resources
+type PublicResourceAPI struct {
+	api *Api
+}
+
+func NewPublicResourceAPI(api *Api) *PublicResourceAPI {
+	return &PublicResourceAPI{api}
+}
+
+// LookupLatest returns the latest update of the resource with the given
+// metadata chunk key
+func (self *PublicResourceAPI) LookupLatest(ctx context.Context, rootKey storage.Key) (*ResourceLookupResult, error) {
+	return self.lookup(ctx, rootKey, 0, 0)
+}
+
+// LookupVersion returns the update of the resource with the given metadata
+// chunk key in the given period, the latest one of the period if version is 0
+func (self *PublicResourceAPI) LookupVersion(ctx context.Context, rootKey storage.Key, period uint32, version uint32) (*ResourceLookupResult, error) {
+	if period == 0 {
+		return nil, storage.NewResourceError(storage.ErrInvalidValue, "Period can't be 0")
+	}
+	return self.lookup(ctx, rootKey, period, version)
+}
+
+func (self *PublicResourceAPI) lookup(ctx context.Context, rootKey storage.Key, period uint32, version uint32) (*ResourceLookupResult, error) {
+	info, data, err := self.api.ResourceLookupInfo(ctx, rootKey, period, version, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceLookupResult{*info, data}, nil
+}
+
+// Conflicts reports the competing updates of the period of the resource,
+// see Api.ResourceConflicts
+func (self *PublicResourceAPI) Conflicts(ctx context.Context, rootKey storage.Key, period uint32) (*storage.ResourceConflictReport, error) {
+	return self.api.ResourceConflicts(ctx, rootKey, period)
+}
+
+// Timeline merges the updates of several feeds, see Api.ResourceTimeline
+func (self *PublicResourceAPI) Timeline(ctx context.Context, feeds []*ResourceTimelineFeed, limit int) (*ResourceTimeline, error) {
+	return self.api.ResourceTimeline(ctx, feeds, limit)
+}
+
+// ResourceAPI is the private part of the mru RPC API, creating and updating
+// mutable resources signed with the key of the node
+type ResourceAPI struct {
+	api *Api
+}
+
+func NewResourceAPI(api *Api) *ResourceAPI {
+	return &ResourceAPI{api}
+}
+
+// Create creates a mutable resource and returns the key of its metadata
+// chunk. Its periods are counted in seconds if timePeriods is set, and in
+// blocks otherwise
+func (self *ResourceAPI) Create(ctx context.Context, name string, frequency uint64, timePeriods bool) (storage.Key, error) {
+	scheme := storage.BlockPeriods
+	if timePeriods {
+		scheme = storage.TimePeriods
+	}
+	return self.api.ResourceCreateWithScheme(ctx, name, frequency, scheme)
+}
+
+// Update publishes an update of a mutable resource, the data is a multihash
+// if multihash is set
+func (self *ResourceAPI) Update(ctx context.Context, name string, data hexutil.Bytes, multihash bool) (*ResourceUpdateResult, error) {
+	var key storage.Key
+	var period, version uint32
+	var err error
+	if multihash {
+		key, period, version, err = self.api.ResourceUpdateMultihash(ctx, name, data)
+	} else {
+		key, period, version, err = self.api.ResourceUpdate(ctx, name, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceUpdateResult{key, period, version}, nil
+}
+
+// Refresh opts the resource in or out of the periodic re-publication of its
+// chunks by the node, see Api.ResourceSetRefresh
+func (self *ResourceAPI) Refresh(name string, refresh bool) error {
+	return self.api.ResourceSetRefresh(name, refresh)
+}
@@ -0,0 +1,99 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// TestResourceRPC manages a resource through the mru RPC namespace
+func TestResourceRPC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-mru-rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, err := storage.NewTestResourceHandler(dir, &storage.ResourceHandlerParams{
+		Signer: &storage.GenericResourceSigner{PrivKey: privkey},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+
+	a := NewApi(nil, nil, rh)
+	server := rpc.NewServer()
+	if err := server.RegisterName("mru", NewPublicResourceAPI(a)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("mru", NewResourceAPI(a)); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	var rootKey storage.Key
+	if err := client.Call(&rootKey, "mru_create", "foo.eth", 3600, true); err != nil {
+		t.Fatal(err)
+	}
+	var result ResourceUpdateResult
+	for _, data := range []string{"bar", "baz"} {
+		if err := client.Call(&result, "mru_update", "foo.eth", hexutil.Bytes(data), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if result.Period != 1 || result.Version != 2 || len(result.Key) != 32 {
+		t.Fatalf("expected update in period 1 version 2, got %+v", result)
+	}
+	if err := client.Call(&result, "mru_update", "foo.eth", hexutil.Bytes("not a multihash"), true); err == nil {
+		t.Fatal("expected invalid multihash update to fail")
+	}
+
+	var latest ResourceLookupResult
+	if err := client.Call(&latest, "mru_lookupLatest", rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if latest.Name != "foo.eth" || latest.Version != 2 || string(latest.Data) != "baz" || latest.Signer != crypto.PubkeyToAddress(privkey.PublicKey) {
+		t.Fatalf("expected version 2 with data 'baz', got %+v", latest)
+	}
+	var first ResourceLookupResult
+	if err := client.Call(&first, "mru_lookupVersion", rootKey, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if first.Version != 1 || string(first.Data) != "bar" {
+		t.Fatalf("expected version 1 with data 'bar', got %+v", first)
+	}
+	if err := client.Call(&first, "mru_lookupVersion", rootKey, 0, 1); err == nil {
+		t.Fatal("expected lookup of period 0 to fail")
+	}
+
+	var report storage.ResourceConflictReport
+	if err := client.Call(&report, "mru_conflicts", rootKey, 1); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+var (
+	// ErrNotaryDisabled is returned when the node has no notary configured
+	ErrNotaryDisabled = errors.New("notarization is disabled")
+	// ErrNotNotarization is returned when looking up a transaction which is not a notarization
+	ErrNotNotarization = errors.New("transaction is not a notarization")
+
+	// notarization transactions call notarize(bytes32) with the root hash,
+	// so a registry contract implementing it can log them as events
+	notarizeSelector = crypto.Keccak256([]byte("notarize(bytes32)"))[:4]
+
+	notarizeCount = metrics.NewRegisteredCounter("api.notarize.count", nil)
+	notarizeFail  = metrics.NewRegisteredCounter("api.notarize.fail", nil)
+)
+
+// Notarization is the on-chain record of a swarm root hash
+type Notarization struct {
+	Tx      common.Hash    `json:"tx"`
+	Root    common.Hash    `json:"root"`
+	From    common.Address `json:"from"`
+	Block   uint64         `json:"block,omitempty"` // 0 while the transaction is pending
+	Time    uint64         `json:"time,omitempty"`  // timestamp of the block
+	Success bool           `json:"success"`         // false if pending or the registry rejected it
+}
+
+/*
+Notary records swarm root hashes on the chain, which gives publishers a
+verifiable proof of the existence of content (or of a resource update) at the
+time of the block the record was included in.
+
+A notarization is a transaction sent from the account of the node, calling
+notarize(bytes32) with the root hash. It is sent to the registry contract if
+one is configured, which may log the hashes as events to make them searchable,
+and otherwise to the account itself, which costs no more than the gas of a
+plain transaction. Transactions are signed for the chain id given to
+NewNotary (EIP155) and sent one at a time, so that concurrent notarizations
+do not reuse a nonce.
+
+Only the content hash is recorded. For encrypted content, this is the hash of
+the encrypted root chunk, the decryption key is never published.
+*/
+type Notary struct {
+	backend  bind.ContractTransactor
+	client   *rpc.Client // client of the chain to look up notarizations, may be nil
+	opts     *bind.TransactOpts
+	signer   types.Signer
+	registry common.Address // recipient of the notarizations, the sender itself if zero
+	lock     sync.Mutex     // serializes nonce lookups and sends
+}
+
+// NewNotary creates a notary sending notarizations with the given transactor
+// through the client of the chain with the given id
+func NewNotary(client *rpc.Client, opts *bind.TransactOpts, registry common.Address, chainID *big.Int) *Notary {
+	return &Notary{
+		backend:  ethclient.NewClient(client),
+		client:   client,
+		opts:     opts,
+		signer:   types.NewEIP155Signer(chainID),
+		registry: registry,
+	}
+}
+
+// Notarize sends the transaction recording the root hash, and returns its hash
+// once it was submitted
+func (self *Notary) Notarize(ctx context.Context, root storage.Key) (common.Hash, error) {
+	notarizeCount.Inc(1)
+	tx, err := self.notarize(ctx, root)
+	if err != nil {
+		notarizeFail.Inc(1)
+		return common.Hash{}, err
+	}
+	log.Debug("Notarized root hash", "root", root, "tx", tx.Hash())
+	return tx.Hash(), nil
+}
+
+func (self *Notary) notarize(ctx context.Context, root storage.Key) (*types.Transaction, error) {
+	if len(root) < common.HashLength {
+		return nil, fmt.Errorf("invalid root hash %x", root)
+	}
+	to := self.registry
+	if to == (common.Address{}) {
+		to = self.opts.From
+	}
+	data := append(append([]byte{}, notarizeSelector...), root[:common.HashLength]...)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	nonce, err := self.backend.PendingNonceAt(ctx, self.opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve account nonce: %v", err)
+	}
+	gasPrice, err := self.backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to suggest gas price: %v", err)
+	}
+	gas, err := self.backend.EstimateGas(ctx, ethereum.CallMsg{From: self.opts.From, To: &to, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate gas: %v", err)
+	}
+	tx, err := self.opts.Signer(self.signer, self.opts.From, types.NewTransaction(nonce, to, new(big.Int), gas, gasPrice, data))
+	if err != nil {
+		return nil, err
+	}
+	if err := self.backend.SendTransaction(ctx, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Lookup returns the notarization recorded by the transaction
+func (self *Notary) Lookup(ctx context.Context, txHash common.Hash) (*Notarization, error) {
+	if self.client == nil {
+		return nil, errors.New("no chain client to look up notarizations")
+	}
+	var tx *struct {
+		BlockNumber *hexutil.Big   `json:"blockNumber"`
+		From        common.Address `json:"from"`
+		Input       hexutil.Bytes  `json:"input"`
+	}
+	if err := self.client.CallContext(ctx, &tx, "eth_getTransactionByHash", txHash); err != nil {
+		return nil, err
+	} else if tx == nil {
+		return nil, ethereum.NotFound
+	}
+	root, err := parseNotarization(tx.Input)
+	if err != nil {
+		return nil, err
+	}
+	n := &Notarization{
+		Tx:   txHash,
+		Root: root,
+		From: tx.From,
+	}
+	if tx.BlockNumber == nil {
+		return n, nil
+	}
+	client := ethclient.NewClient(self.client)
+	header, err := client.HeaderByNumber(ctx, tx.BlockNumber.ToInt())
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := client.TransactionReceipt(ctx, txHash)
+	if err != nil {
+		return nil, err
+	}
+	n.Block = header.Number.Uint64()
+	n.Time = header.Time.Uint64()
+	n.Success = receipt.Status == types.ReceiptStatusSuccessful
+	return n, nil
+}
+
+// returns the root hash recorded by the data of a notarization transaction
+func parseNotarization(data []byte) (common.Hash, error) {
+	if len(data) != len(notarizeSelector)+common.HashLength || !bytes.Equal(data[:len(notarizeSelector)], notarizeSelector) {
+		return common.Hash{}, ErrNotNotarization
+	}
+	return common.BytesToHash(data[len(notarizeSelector):]), nil
+}
+
+// SetNotary enables recording root hashes on the chain with the notary
+func (self *Api) SetNotary(notary *Notary) {
+	self.notary = notary
+}
+
+// Notarize records the root hash on the chain, see Notary
+func (self *Api) Notarize(ctx context.Context, root storage.Key) (common.Hash, error) {
+	if self.notary == nil {
+		return common.Hash{}, ErrNotaryDisabled
+	}
+	return self.notary.Notarize(ctx, root)
+}
+
+// Notarization looks up the notarization recorded by the transaction
+func (self *Api) Notarization(ctx context.Context, txHash common.Hash) (*Notarization, error) {
+	if self.notary == nil {
+		return nil, ErrNotaryDisabled
+	}
+	return self.notary.Lookup(ctx, txHash)
+}
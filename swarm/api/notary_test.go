@@ -0,0 +1,146 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// TestNotarize checks that the notarization transaction records the root
+// hash, is sent to the account itself unless a registry is given and is
+// signed for the chain id
+func TestNotarize(t *testing.T) {
+	privKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := bind.NewKeyedTransactor(privKey)
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		opts.From: {Balance: big.NewInt(1000000000000000000)},
+	})
+	registry := common.HexToAddress("0x1234")
+	root := storage.Key(crypto.Keccak256([]byte("content")))
+
+	// the simulated backend only accepts unprotected transactions
+	for _, to := range []common.Address{{}, registry} {
+		notary := &Notary{backend: backend, opts: opts, signer: types.HomesteadSigner{}, registry: to}
+		tx, err := notary.notarize(context.Background(), root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backend.Commit()
+
+		if to == (common.Address{}) {
+			to = opts.From
+		}
+		if *tx.To() != to {
+			t.Fatalf("expected notarization to be sent to %x, got %x", to, tx.To())
+		}
+		recorded, err := parseNotarization(tx.Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recorded != common.BytesToHash(root) {
+			t.Fatalf("expected root hash %x to be recorded, got %x", root, recorded)
+		}
+		receipt, err := backend.TransactionReceipt(context.Background(), tx.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			t.Fatal("expected notarization to succeed")
+		}
+	}
+
+	// notarizations are signed for the chain id, and concurrent ones get
+	// different nonces
+	chainID := params.AllEthashProtocolChanges.ChainId
+	notary := &Notary{backend: &sendingTransactor{}, opts: opts, signer: types.NewEIP155Signer(chainID)}
+	txs := make(chan *types.Transaction, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			tx, err := notary.notarize(context.Background(), root)
+			if err != nil {
+				t.Error(err)
+			}
+			txs <- tx
+		}()
+	}
+	tx1, tx2 := <-txs, <-txs
+	if tx1 == nil || tx2 == nil {
+		t.FailNow()
+	}
+	if tx1.Nonce() == tx2.Nonce() {
+		t.Fatalf("expected concurrent notarizations to use different nonces, got %d twice", tx1.Nonce())
+	}
+	for _, tx := range []*types.Transaction{tx1, tx2} {
+		if !tx.Protected() || tx.ChainId().Cmp(chainID) != 0 {
+			t.Fatalf("expected notarization to be signed for chain %v, got %v", chainID, tx.ChainId())
+		}
+	}
+
+	if _, err := parseNotarization(root); err != ErrNotNotarization {
+		t.Fatalf("expected %v, got %v", ErrNotNotarization, err)
+	}
+	if _, err := NewApi(nil, nil, nil).Notarize(context.Background(), root); err != ErrNotaryDisabled {
+		t.Fatalf("expected %v, got %v", ErrNotaryDisabled, err)
+	}
+}
+
+// sendingTransactor counts the transactions sent for the pending nonce
+type sendingTransactor struct {
+	bind.ContractTransactor
+	lock sync.Mutex
+	sent int
+}
+
+func (self *sendingTransactor) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return uint64(self.sent), nil
+}
+
+func (self *sendingTransactor) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+
+func (self *sendingTransactor) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	return params.TxGas, nil
+}
+
+func (self *sendingTransactor) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	// give concurrent notarizations a chance to look up the same nonce
+	time.Sleep(10 * time.Millisecond)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.sent++
+	return nil
+}
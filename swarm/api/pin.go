@@ -0,0 +1,141 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+var ErrPinningNotSupported = errors.New("chunk store does not support pinning")
+
+func (self *Api) pinner() (storage.Pinner, error) {
+	pinner, ok := self.dpa.ChunkStore.(storage.Pinner)
+	if !ok {
+		return nil, ErrPinningNotSupported
+	}
+	return pinner, nil
+}
+
+// Pin protects all chunks reachable from key from garbage collection in the
+// local store. If key is a manifest, the content of all its entries and
+// submanifests is pinned, resource entries pin their metadata chunk. Any
+// other key is pinned as raw content. Missing chunks are retrieved.
+func (self *Api) Pin(key storage.Key) error {
+	pinner, err := self.pinner()
+	if err != nil {
+		return err
+	}
+	keys, err := self.pinKeys(key)
+	if err != nil {
+		return err
+	}
+	log.Debug("api.pin", "key", key, "chunks", len(keys))
+	if err := pinner.Pin(key, keys); err != nil {
+		return err
+	}
+	self.IndexContent(key)
+	return nil
+}
+
+// Unpin releases the pin of key. Chunks shared with other pinned content stay
+// protected. The content is removed from the search index, as it may be
+// garbage collected.
+func (self *Api) Unpin(key storage.Key) error {
+	pinner, err := self.pinner()
+	if err != nil {
+		return err
+	}
+	if err := pinner.Unpin(key); err != nil {
+		return err
+	}
+	if self.search != nil {
+		if err := self.search.Remove(key); err != nil {
+			log.Warn("could not remove unpinned content from the search index", "key", key, "err", err)
+		}
+	}
+	return nil
+}
+
+// Pinned returns the keys of all pinned content
+func (self *Api) Pinned() ([]storage.Key, error) {
+	pinner, err := self.pinner()
+	if err != nil {
+		return nil, err
+	}
+	return pinner.PinnedRoots()
+}
+
+// pinKeys collects the keys of all chunks reachable from key
+func (self *Api) pinKeys(key storage.Key) ([]storage.Key, error) {
+	var keys []storage.Key
+	collect := func(k storage.Key) error {
+		keys = append(keys, k)
+		return nil
+	}
+	if err := self.dpa.Chunks(key, collect); err != nil {
+		return nil, err
+	}
+	walker, err := self.NewManifestWalker(key, nil)
+	if err != nil {
+		// not a manifest, the content is pinned as raw data
+		return keys, nil
+	}
+	err = walker.Walk(func(entry *ManifestEntry) error {
+		ref := storage.Key(common.Hex2Bytes(entry.Hash))
+		if len(ref) == 0 {
+			return fmt.Errorf("invalid hash %q of manifest entry %q", entry.Hash, entry.Path)
+		}
+		if entry.ContentType == ResourceContentType {
+			// resource updates are looked up on the network, only the
+			// metadata chunk is part of the content
+			return collect(ref)
+		}
+		return self.dpa.Chunks(ref, collect)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Pin pins the content with the given hex encoded hash, see Api.Pin
+func (self *Control) Pin(hash string) error {
+	return self.api.Pin(storage.Key(common.FromHex(hash)))
+}
+
+// Unpin releases the pin of the content with the given hex encoded hash
+func (self *Control) Unpin(hash string) error {
+	return self.api.Unpin(storage.Key(common.FromHex(hash)))
+}
+
+// Pinned returns the hashes of all pinned content
+func (self *Control) Pinned() ([]string, error) {
+	keys, err := self.api.Pinned()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(keys))
+	for i, key := range keys {
+		hashes[i] = key.Hex()
+	}
+	return hashes, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// TestApiPin checks that content shared by two pinned manifests stays
+// pinned when one of the manifests is unpinned
+func TestApiPin(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		ldb := api.dpa.ChunkStore.(*storage.LocalStore).DbStore
+
+		shared, wait, err := api.Put("hello", "text/plain", toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		_, _, _, contentKey, err := api.Get(shared, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := "world"
+		otherKey, wait, err := api.Store(strings.NewReader(other), int64(len(other)), toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		extended, err := api.Modify(shared, "other", otherKey.Hex(), "text/plain")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := api.Pin(shared); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.Pin(extended); err != nil {
+			t.Fatal(err)
+		}
+		pinned, err := api.Pinned()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pinned) != 2 {
+			t.Fatalf("expected 2 pinned roots, got %d", len(pinned))
+		}
+		chunk := contentKey[:storage.KeyLength]
+		if cnt := ldb.RefCount(chunk); cnt != 2 {
+			t.Fatalf("expected reference count 2 for shared content, got %d", cnt)
+		}
+		if cnt := ldb.RefCount(otherKey[:storage.KeyLength]); cnt != 1 {
+			t.Fatalf("expected reference count 1 for other content, got %d", cnt)
+		}
+
+		if err := api.Unpin(shared); err != nil {
+			t.Fatal(err)
+		}
+		if cnt := ldb.RefCount(chunk); cnt != 1 {
+			t.Fatalf("expected reference count 1 for shared content after unpin, got %d", cnt)
+		}
+		if cnt := ldb.RefCount(shared[:storage.KeyLength]); cnt != 0 {
+			t.Fatalf("expected unpinned manifest to be released, got reference count %d", cnt)
+		}
+		if err := api.Unpin(extended); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MaxPowDifficulty is the highest difficulty a gateway can require
+const MaxPowDifficulty = 64
+
+// PowStampWindow is how far the time of a stamp may be off the clock of the
+// gateway, older or newer stamps are rejected
+const PowStampWindow = 5 * time.Minute
+
+/*
+Proof-of-work stamps let public gateways throttle anonymous writes without
+accounts. A stamp is a nonce such that
+
+	keccak256(keccak256(keccak256(payload) | time) | nonce)
+
+has at least difficulty leading zero bits, where time is the unix time of the
+stamp in seconds and both time and nonce are encoded as 8 bytes big endian.
+Finding a stamp takes 2^difficulty hashes on average, checking it takes three.
+
+Clients send the hash of the payload along with the stamp, so that gateways
+check the stamp before they read the payload, and then check the payload
+against the hash as it is read.
+
+Gateways only accept stamps whose time is within PowStampWindow of their
+clock and accept each stamp once, so a stamp cannot be replayed to write the
+same payload again.
+*/
+
+// PowDigest returns the digest of the payload stamped at the given unix time
+// the stamp is computed over
+func PowDigest(payload []byte, timestamp int64) []byte {
+	return PowDigestHash(crypto.Keccak256(payload), timestamp)
+}
+
+// PowDigestHash returns the digest of the payload of the given keccak256
+// hash stamped at the given unix time, see PowDigest
+func PowDigestHash(hash []byte, timestamp int64) []byte {
+	b := make([]byte, len(hash)+8)
+	copy(b, hash)
+	binary.BigEndian.PutUint64(b[len(hash):], uint64(timestamp))
+	return crypto.Keccak256(b)
+}
+
+// VerifyPow returns true if nonce is a valid stamp for the digest with the given difficulty
+func VerifyPow(digest []byte, nonce uint64, difficulty uint8) bool {
+	return powLeadingZeros(digest, nonce) >= int(difficulty)
+}
+
+// SolvePow finds a stamp for the digest with the given difficulty
+func SolvePow(digest []byte, difficulty uint8) uint64 {
+	var nonce uint64
+	for !VerifyPow(digest, nonce, difficulty) {
+		nonce++
+	}
+	return nonce
+}
+
+func powLeadingZeros(digest []byte, nonce uint64) int {
+	b := make([]byte, len(digest)+8)
+	copy(b, digest)
+	binary.BigEndian.PutUint64(b[len(digest):], nonce)
+	var zeros int
+	for _, x := range crypto.Keccak256(b) {
+		zeros += bits.LeadingZeros8(x)
+		if x != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+// ParsePowDifficulty parses a list of per scheme difficulties of the form
+// bzz:20,bzz-raw:20,bzz-resource:16
+func ParsePowDifficulty(s string) (map[string]uint8, error) {
+	difficulty := make(map[string]uint8)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid pow difficulty %q, expected <scheme>:<difficulty>", item)
+		}
+		d, err := strconv.ParseUint(item[i+1:], 10, 8)
+		if err != nil || d > MaxPowDifficulty {
+			return nil, fmt.Errorf("invalid pow difficulty %q, expected a number of bits up to %d", item, MaxPowDifficulty)
+		}
+		difficulty[item[:i]] = uint8(d)
+	}
+	return difficulty, nil
+}
@@ -0,0 +1,609 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+	"golang.org/x/net/html"
+)
+
+const (
+	// SearchMaxDocumentSize is the largest file whose text is indexed
+	SearchMaxDocumentSize = 4 * 1024 * 1024
+	// SearchMaxDocuments is the number of documents kept in the index, the
+	// manifests indexed least recently are dropped beyond it
+	SearchMaxDocuments = 100000
+	// DefaultSearchLimit is the number of results returned if no limit is given
+	DefaultSearchLimit = 20
+
+	searchRootsKey    = "search-roots"
+	searchRootPrefix  = "search-root-"
+	searchSnippetSize = 200
+	searchTitleWeight = 3 // occurrences of a term in the title count this much
+	searchMinTermSize = 2
+	searchMaxTermSize = 64
+	searchQueueSize   = 64 // manifests waiting to be indexed
+)
+
+var (
+	// ErrSearchDisabled is returned when the node does not index its content
+	ErrSearchDisabled = errors.New("search index is disabled")
+
+	errSearchFull = errors.New("search index is full")
+
+	searchIndexCount = metrics.NewRegisteredCounter("api.search.index.count", nil)
+	searchIndexFail  = metrics.NewRegisteredCounter("api.search.index.fail", nil)
+	searchIndexDrop  = metrics.NewRegisteredCounter("api.search.index.drop", nil)
+	searchIndexEvict = metrics.NewRegisteredCounter("api.search.index.evict", nil)
+	searchQueryCount = metrics.NewRegisteredCounter("api.search.query.count", nil)
+)
+
+// searchDocument is the indexed text of a file of a manifest
+type searchDocument struct {
+	Root        string         `json:"root"`
+	Path        string         `json:"path"`
+	Hash        string         `json:"hash"`
+	ContentType string         `json:"contentType"`
+	Title       string         `json:"title,omitempty"`
+	Snippet     string         `json:"snippet,omitempty"`
+	Terms       map[string]int `json:"terms"`
+	Length      int            `json:"length"` // number of terms in the document
+}
+
+// SearchResult is a file matching a search query
+type SearchResult struct {
+	Root        string  `json:"root"`
+	Path        string  `json:"path"`
+	ContentType string  `json:"contentType"`
+	Title       string  `json:"title,omitempty"`
+	Snippet     string  `json:"snippet,omitempty"`
+	Score       float64 `json:"score"`
+}
+
+/*
+SearchIndex is a local full-text index of the content of the node.
+
+The text of the files of manifests uploaded to or pinned on the node is
+extracted according to their content type: the text of html documents without
+their markup, scripts and styles, and any other text content as is. Other
+files, such as images, are not indexed. The index only covers the content of
+the node, so that gateways hosting documentation sites can offer search on
+them, it does not search the swarm.
+
+Queries match the files containing all their terms, ranked by the frequency
+of the terms in the files, weighted by how rare they are in the index. Terms
+in the titles of html documents count more.
+
+The indexed documents are kept in the state store, so they survive restarts
+of the node.
+
+Manifests are indexed in the background, one at a time, so that uploads do
+not wait for their files to be read. Manifests queued while the queue is
+full are not indexed. The index keeps at most SearchMaxDocuments documents,
+the manifests indexed least recently are dropped to make room for new ones.
+*/
+type SearchIndex struct {
+	api     *Api
+	store   state.Store // may be nil, then the index is only kept in memory
+	mu      sync.RWMutex
+	roots   map[string][]*searchDocument
+	order   []string // indexed manifests, least recently indexed first
+	docs    int      // number of indexed documents
+	maxDocs int
+	terms   map[string]map[*searchDocument]int // term frequencies by document
+	pending map[string]bool                    // queued manifests
+	queue   chan storage.Key
+	quitC   chan struct{}
+	doneC   chan struct{}
+}
+
+// NewSearchIndex creates an index of content read through the api, persisting
+// the documents in the given state store
+func NewSearchIndex(api *Api, store state.Store) *SearchIndex {
+	self := &SearchIndex{
+		api:     api,
+		store:   store,
+		roots:   make(map[string][]*searchDocument),
+		terms:   make(map[string]map[*searchDocument]int),
+		maxDocs: SearchMaxDocuments,
+		pending: make(map[string]bool),
+		queue:   make(chan storage.Key, searchQueueSize),
+		quitC:   make(chan struct{}),
+		doneC:   make(chan struct{}),
+	}
+	go self.loop()
+	if store == nil {
+		return self
+	}
+	var roots []string
+	if err := store.Get(searchRootsKey, &roots); err != nil {
+		return self
+	}
+	for _, root := range roots {
+		var docs []*searchDocument
+		if err := store.Get(searchRootPrefix+root, &docs); err != nil {
+			log.Warn("search index could not load documents", "root", root, "err", err)
+			continue
+		}
+		self.add(root, docs)
+	}
+	log.Debug("search index loaded", "roots", len(self.roots), "terms", len(self.terms))
+	return self
+}
+
+// Close stops indexing queued manifests
+func (self *SearchIndex) Close() {
+	close(self.quitC)
+	<-self.doneC
+}
+
+// Enqueue queues the manifest to be indexed in the background. It returns
+// false if the queue is full, then the manifest is not indexed.
+func (self *SearchIndex) Enqueue(key storage.Key) bool {
+	root := key.Hex()
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.pending[root] {
+		return true
+	}
+	select {
+	case self.queue <- key:
+		self.pending[root] = true
+		return true
+	default:
+		searchIndexDrop.Inc(1)
+		return false
+	}
+}
+
+// indexes the queued manifests until the index is closed
+func (self *SearchIndex) loop() {
+	defer close(self.doneC)
+	for {
+		select {
+		case key := <-self.queue:
+			if _, err := self.index(key, true); err != nil {
+				log.Debug("content not indexed", "key", key, "err", err)
+			}
+		case <-self.quitC:
+			return
+		}
+	}
+}
+
+// Index indexes the text of the files of the manifest and returns the number
+// of indexed files. Indexing a manifest again replaces its documents.
+func (self *SearchIndex) Index(key storage.Key) (int, error) {
+	return self.index(key, false)
+}
+
+// indexes the manifest, if queued is true only if it was not removed since it
+// was queued
+func (self *SearchIndex) index(key storage.Key, queued bool) (int, error) {
+	searchIndexCount.Inc(1)
+	walker, err := self.api.NewManifestWalker(key, nil)
+	if err != nil {
+		self.dequeue(key)
+		searchIndexFail.Inc(1)
+		return 0, err
+	}
+	root := key.Hex()
+	var docs []*searchDocument
+	err = walker.Walk(func(entry *ManifestEntry) error {
+		if entry.ContentType == ManifestType || entry.ContentType == ResourceContentType {
+			return nil
+		}
+		if len(docs) >= self.maxDocs {
+			return errSearchFull
+		}
+		doc, err := self.document(root, entry)
+		if err != nil {
+			log.Debug("search index skipped file", "root", root, "path", entry.Path, "err", err)
+			return nil
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+		return nil
+	})
+	if err != nil && err != errSearchFull {
+		self.dequeue(key)
+		searchIndexFail.Inc(1)
+		return 0, err
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if queued {
+		if !self.pending[root] {
+			// removed while it was indexed
+			return 0, nil
+		}
+		delete(self.pending, root)
+	}
+	self.remove(root)
+	if err := self.evict(len(docs)); err != nil {
+		searchIndexFail.Inc(1)
+		return 0, err
+	}
+	if err := self.persist(root, docs); err != nil {
+		searchIndexFail.Inc(1)
+		return 0, err
+	}
+	self.add(root, docs)
+	log.Debug("search index added manifest", "root", root, "files", len(docs))
+	return len(docs), nil
+}
+
+// drops the queued manifest which could not be indexed
+func (self *SearchIndex) dequeue(key storage.Key) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.pending, key.Hex())
+}
+
+// drops the manifests indexed least recently until n more documents fit in
+// the index, the lock must be held
+func (self *SearchIndex) evict(n int) error {
+	var evicted bool
+	for len(self.order) > 0 && self.docs+n > self.maxDocs {
+		root := self.order[0]
+		if self.store != nil {
+			if err := self.store.Delete(searchRootPrefix + root); err != nil {
+				return err
+			}
+		}
+		self.remove(root)
+		searchIndexEvict.Inc(1)
+		evicted = true
+	}
+	if evicted {
+		return self.persistRoots()
+	}
+	return nil
+}
+
+// Remove drops the documents of the manifest from the index
+func (self *SearchIndex) Remove(key storage.Key) error {
+	root := key.Hex()
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.pending, root)
+	if _, ok := self.roots[root]; !ok {
+		return nil
+	}
+	if self.store != nil {
+		if err := self.store.Delete(searchRootPrefix + root); err != nil {
+			return err
+		}
+	}
+	self.remove(root)
+	return self.persistRoots()
+}
+
+// Search returns the files containing all terms of the query, best matches
+// first. If root is not nil, only the files of that manifest are searched. At
+// most limit results are returned, DefaultSearchLimit if it is not positive.
+//
+// Files with the same content and path in several manifests, such as
+// unchanged files of updated sites, are returned once.
+func (self *SearchIndex) Search(query string, root storage.Key, limit int) []*SearchResult {
+	searchQueryCount.Inc(1)
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	var rootHex string
+	if root != nil {
+		rootHex = root.Hex()
+	}
+
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	scores := make(map[*searchDocument]float64)
+	for i, term := range terms {
+		postings := self.terms[term]
+		idf := math.Log(1 + float64(self.docs)/float64(len(postings)+1))
+		for doc, freq := range postings {
+			if rootHex != "" && doc.Root != rootHex {
+				continue
+			}
+			// documents must contain all terms
+			if _, ok := scores[doc]; !ok && i > 0 {
+				continue
+			}
+			scores[doc] += float64(freq) / math.Sqrt(float64(doc.Length)) * idf
+		}
+		for doc := range scores {
+			if _, ok := postings[doc]; !ok {
+				delete(scores, doc)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var results []*SearchResult
+	for doc, score := range scores {
+		if seen[doc.Hash+"/"+doc.Path] {
+			continue
+		}
+		seen[doc.Hash+"/"+doc.Path] = true
+		results = append(results, &SearchResult{
+			Root:        doc.Root,
+			Path:        doc.Path,
+			ContentType: doc.ContentType,
+			Title:       doc.Title,
+			Snippet:     doc.Snippet,
+			Score:       score,
+		})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].Root < results[j].Root
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// returns the indexed text of the file of the manifest entry, nil if its
+// content type is not indexed
+func (self *SearchIndex) document(root string, entry *ManifestEntry) (*searchDocument, error) {
+	contentType := entry.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(entry.Path))
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !searchIndexed(mediaType) {
+		return nil, nil
+	}
+	if entry.Size > SearchMaxDocumentSize {
+		return nil, nil
+	}
+	reader, _ := self.api.Retrieve(storage.Key(common.Hex2Bytes(entry.Hash)))
+	data, err := ioutil.ReadAll(io.LimitReader(io.NewSectionReader(reader, 0, SearchMaxDocumentSize+1), SearchMaxDocumentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > SearchMaxDocumentSize {
+		return nil, nil
+	}
+
+	doc := &searchDocument{
+		Root:        root,
+		Path:        entry.Path,
+		Hash:        entry.Hash,
+		ContentType: entry.ContentType,
+		Terms:       make(map[string]int),
+	}
+	text := string(data)
+	if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+		doc.Title, text = htmlText(data)
+	}
+	for _, term := range searchTerms(doc.Title) {
+		doc.Terms[term] += searchTitleWeight
+		doc.Length += searchTitleWeight
+	}
+	for _, term := range searchTerms(text) {
+		doc.Terms[term]++
+		doc.Length++
+	}
+	if doc.Length == 0 {
+		return nil, nil
+	}
+	doc.Snippet = snippet(text)
+	return doc, nil
+}
+
+// adds the documents of the manifest to the index, the lock must be held
+func (self *SearchIndex) add(root string, docs []*searchDocument) {
+	self.roots[root] = docs
+	self.order = append(self.order, root)
+	self.docs += len(docs)
+	for _, doc := range docs {
+		for term, freq := range doc.Terms {
+			postings, ok := self.terms[term]
+			if !ok {
+				postings = make(map[*searchDocument]int)
+				self.terms[term] = postings
+			}
+			postings[doc] = freq
+		}
+	}
+}
+
+// removes the documents of the manifest from the index, the lock must be held
+func (self *SearchIndex) remove(root string) {
+	for _, doc := range self.roots[root] {
+		for term := range doc.Terms {
+			delete(self.terms[term], doc)
+			if len(self.terms[term]) == 0 {
+				delete(self.terms, term)
+			}
+		}
+	}
+	if docs, ok := self.roots[root]; ok {
+		self.docs -= len(docs)
+		for i, r := range self.order {
+			if r == root {
+				self.order = append(self.order[:i], self.order[i+1:]...)
+				break
+			}
+		}
+	}
+	delete(self.roots, root)
+}
+
+// stores the documents of the manifest in the state store, the lock must be
+// held
+func (self *SearchIndex) persist(root string, docs []*searchDocument) error {
+	if self.store == nil {
+		return nil
+	}
+	if err := self.store.Put(searchRootPrefix+root, docs); err != nil {
+		return err
+	}
+	roots := append(append([]string(nil), self.order...), root)
+	return self.store.Put(searchRootsKey, roots)
+}
+
+// stores the list of indexed manifests in the state store, least recently
+// indexed first, the lock must be held
+func (self *SearchIndex) persistRoots() error {
+	if self.store == nil {
+		return nil
+	}
+	return self.store.Put(searchRootsKey, self.order)
+}
+
+// searchIndexed returns true if the text of files of the media type is indexed
+func searchIndexed(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/xml", "application/xhtml+xml", "application/javascript":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// searchTerms splits the text into lower case words
+func searchTerms(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len(word) < searchMinTermSize || len(word) > searchMaxTermSize {
+			continue
+		}
+		terms = append(terms, strings.ToLower(word))
+	}
+	return terms
+}
+
+// htmlText returns the title and the text of the html document, without the
+// markup, scripts and styles
+func htmlText(data []byte) (title string, text string) {
+	var (
+		b       bytes.Buffer
+		skip    int
+		inTitle bool
+	)
+	z := html.NewTokenizer(strings.NewReader(string(data)))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.TrimSpace(title), b.String()
+		case html.StartTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "script", "style":
+				skip++
+			case "title":
+				inTitle = true
+			}
+		case html.EndTagToken:
+			switch name, _ := z.TagName(); string(name) {
+			case "script", "style":
+				if skip > 0 {
+					skip--
+				}
+			case "title":
+				inTitle = false
+			}
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			if inTitle {
+				title += string(z.Text())
+				continue
+			}
+			b.Write(z.Text())
+			b.WriteByte(' ')
+		}
+	}
+}
+
+// snippet returns the start of the text with collapsed whitespace
+func snippet(text string) string {
+	s := strings.Join(strings.Fields(text), " ")
+	if len(s) <= searchSnippetSize {
+		return s
+	}
+	// do not cut runes
+	end := 0
+	for i := range s {
+		if i > searchSnippetSize {
+			break
+		}
+		end = i
+	}
+	return s[:end] + "..."
+}
+
+// SetSearchIndex enables indexing the content uploaded to and pinned on the node
+func (self *Api) SetSearchIndex(index *SearchIndex) {
+	self.search = index
+}
+
+// SearchIndex returns the search index of the node, nil if it is disabled
+func (self *Api) SearchIndex() *SearchIndex {
+	return self.search
+}
+
+// Search searches the content of the node, see SearchIndex.Search
+func (self *Api) Search(query string, root storage.Key, limit int) ([]*SearchResult, error) {
+	if self.search == nil {
+		return nil, ErrSearchDisabled
+	}
+	return self.search.Search(query, root, limit), nil
+}
+
+// IndexContent queues the manifest uploaded to or pinned on the node to be
+// added to the search index, if it is enabled. Failures are only logged,
+// content which is not a manifest is not indexed.
+func (self *Api) IndexContent(key storage.Key) {
+	if self.search == nil {
+		return
+	}
+	if !self.search.Enqueue(key) {
+		log.Debug("content not indexed, queue is full", "key", key)
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// TestApiSearch checks that the text of pinned manifests is searchable
+// according to the content type of their files, and that unpinning removes
+// them from the index
+func TestApiSearch(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		store := state.NewInmemoryStore()
+		api.SetSearchIndex(NewSearchIndex(api, store))
+
+		page := `<html><head><title>Getting started</title><style>.swarm { color: red }</style></head>
+<body><h1>Install swarm</h1><p>Download the binary.</p><script>var hidden = "secretword";</script></body></html>`
+		root, wait, err := api.Put(page, "text/html; charset=utf-8", toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+		for _, file := range []struct {
+			path        string
+			contentType string
+			content     string
+		}{
+			{"reference.txt", "text/plain", "Swarm configuration reference, see the getting started guide"},
+			{"logo.png", "image/png", "swarm install"},
+		} {
+			key, wait, err := api.Store(strings.NewReader(file.content), int64(len(file.content)), toEncrypt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wait()
+			root, err = api.Modify(root, file.path, key.Hex(), file.contentType)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := api.Pin(root); err != nil {
+			t.Fatal(err)
+		}
+		waitSearchIndex(t, api, "swarm")
+
+		search := func(query string, root storage.Key, expected ...string) {
+			t.Helper()
+			results, err := api.Search(query, root, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, result := range results {
+				paths = append(paths, result.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(expected, ",") {
+				t.Fatalf("%q: expected %v, got %v", query, expected, paths)
+			}
+		}
+		search("swarm", nil, "reference.txt", "") // the shorter file ranks first
+		search("INSTALL Swarm", nil, "")
+		search("configuration", root, "reference.txt")
+		search("getting started", nil, "", "reference.txt") // the title counts more
+		search("secretword", nil)
+		search("red", nil)
+		search("swarm", storage.Key(make([]byte, len(root))))
+
+		// the index is restored from the state store
+		restored := NewSearchIndex(api, store)
+		if results := restored.Search("install", nil, 0); len(results) != 1 || results[0].Title != "Getting started" || results[0].Root != root.Hex() {
+			t.Fatalf("expected restored index to find the page, got %v", results)
+		}
+
+		if err := api.Unpin(root); err != nil {
+			t.Fatal(err)
+		}
+		search("swarm", nil)
+		if results := NewSearchIndex(api, store).Search("swarm", nil, 0); len(results) != 0 {
+			t.Fatalf("expected unpinned manifest to be removed from the state store, got %d results", len(results))
+		}
+	})
+}
+
+// waitSearchIndex waits until the queued manifests are indexed, so that the
+// query matches
+func waitSearchIndex(t *testing.T, api *Api, query string) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if results, _ := api.Search(query, nil, 0); len(results) > 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%q: content was not indexed", query)
+}
+
+// TestSearchIndexLimit checks that the manifests indexed least recently are
+// dropped when the index is full, and that manifests removed while they are
+// queued are not indexed
+func TestSearchIndexLimit(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		store := state.NewInmemoryStore()
+		index := NewSearchIndex(api, store)
+		defer index.Close()
+		index.maxDocs = 2
+
+		var roots []storage.Key
+		for _, content := range []string{"first page", "second page", "third page"} {
+			root, wait, err := api.Put(content, "text/plain", toEncrypt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wait()
+			if _, err := index.Index(root); err != nil {
+				t.Fatal(err)
+			}
+			roots = append(roots, root)
+		}
+		if results := index.Search("page", nil, 0); len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+		if results := index.Search("first", nil, 0); len(results) != 0 {
+			t.Fatalf("expected least recently indexed manifest to be dropped, got %v", results)
+		}
+		var stored []string
+		if err := store.Get(searchRootsKey, &stored); err != nil {
+			t.Fatal(err)
+		}
+		if len(stored) != 2 || stored[0] != roots[1].Hex() || stored[1] != roots[2].Hex() {
+			t.Fatalf("unexpected stored manifests %v", stored)
+		}
+
+		// a manifest removed after it is queued stays out of the index
+		if !index.Enqueue(roots[0]) {
+			t.Fatal("expected manifest to be queued")
+		}
+		if err := index.Remove(roots[0]); err != nil {
+			t.Fatal(err)
+		}
+		if !index.Enqueue(roots[2]) {
+			t.Fatal("expected manifest to be queued")
+		}
+		waitSearchIndexIdle(t, index)
+		if results := index.Search("first", nil, 0); len(results) != 0 {
+			t.Fatalf("expected removed manifest not to be indexed, got %v", results)
+		}
+	})
+}
+
+// waitSearchIndexIdle waits until the index has no queued manifests
+func waitSearchIndexIdle(t *testing.T, index *SearchIndex) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		index.mu.RLock()
+		pending := len(index.pending)
+		index.mu.RUnlock()
+		if pending == 0 {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatal("queued manifests were not indexed")
+}
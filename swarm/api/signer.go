@@ -0,0 +1,242 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const (
+	managedKeyPrefix = "managed-key-"
+	managedTokenSize = 32
+)
+
+var (
+	// ErrManagedAuth is returned when the user of a managed key does not exist or the token does not match
+	ErrManagedAuth = errors.New("invalid user or token")
+	// ErrManagedUserExists is returned when creating a key for a user who already has one
+	ErrManagedUserExists = errors.New("user already has a publisher key")
+	// ErrManagedDisabled is returned when the gateway does not manage publisher keys
+	ErrManagedDisabled = errors.New("managed publisher keys are disabled")
+
+	managedUserMatcher = regexp.MustCompile("^[A-Za-z0-9._@-]{1,64}$")
+
+	managedCreateCount = metrics.NewRegisteredCounter("api.managed.create.count", nil)
+	managedSignCount   = metrics.NewRegisteredCounter("api.managed.sign.count", nil)
+	managedAuthFail    = metrics.NewRegisteredCounter("api.managed.auth.fail", nil)
+)
+
+// managedKey is the persisted publisher key of a user, only the hash of the
+// token of the user is kept
+type managedKey struct {
+	User      string         `json:"user"`
+	Address   common.Address `json:"address"`
+	Key       hexutil.Bytes  `json:"key"`
+	TokenHash hexutil.Bytes  `json:"tokenHash"`
+}
+
+// ManagedKeyInfo describes the publisher key of a user
+type ManagedKeyInfo struct {
+	User    string         `json:"user"`
+	Address common.Address `json:"address"`
+	Token   string         `json:"token,omitempty"` // only returned when the key is created
+}
+
+/*
+ManagedSigners keeps publisher keys on behalf of the users of a gateway.
+
+Web applications can offer resources to users who have no wallet: the gateway
+creates a key for the user, returns a token which authenticates the user and
+signs resource updates with the key of the user whenever the token is given.
+The key can be exported by the user at any time, to take over the publishing
+of the resources with a wallet of its own.
+
+The keys are kept in the state store of the node unencrypted, so the gateway
+operator has full control over them.
+*/
+type ManagedSigners struct {
+	store state.Store
+	mu    sync.Mutex
+	keys  map[string]*managedKey
+}
+
+// NewManagedSigners creates a key store persisting the keys in the given
+// state store, keys are only kept in memory if it is nil
+func NewManagedSigners(store state.Store) *ManagedSigners {
+	return &ManagedSigners{
+		store: store,
+		keys:  make(map[string]*managedKey),
+	}
+}
+
+// Create creates a new publisher key for the user and returns its info
+// including the token which authenticates the user
+func (self *ManagedSigners) Create(user string) (*ManagedKeyInfo, error) {
+	if !managedUserMatcher.MatchString(user) {
+		return nil, fmt.Errorf("invalid user name %q", user)
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.get(user) != nil {
+		return nil, ErrManagedUserExists
+	}
+	privKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	token := make([]byte, managedTokenSize)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	tokenHex := hexutil.Encode(token)
+	entry := &managedKey{
+		User:      user,
+		Address:   crypto.PubkeyToAddress(privKey.PublicKey),
+		Key:       crypto.FromECDSA(privKey),
+		TokenHash: crypto.Keccak256([]byte(tokenHex)),
+	}
+	if self.store != nil {
+		if err := self.store.Put(managedKeyPrefix+user, entry); err != nil {
+			return nil, err
+		}
+	}
+	self.keys[user] = entry
+	managedCreateCount.Inc(1)
+	log.Info("created managed publisher key", "user", user, "address", entry.Address)
+	return &ManagedKeyInfo{
+		User:    user,
+		Address: entry.Address,
+		Token:   tokenHex,
+	}, nil
+}
+
+// Info returns the info of the key of an authenticated user
+func (self *ManagedSigners) Info(user, token string) (*ManagedKeyInfo, error) {
+	entry, err := self.authenticate(user, token)
+	if err != nil {
+		return nil, err
+	}
+	return &ManagedKeyInfo{
+		User:    entry.User,
+		Address: entry.Address,
+	}, nil
+}
+
+// Export returns the private key of an authenticated user
+func (self *ManagedSigners) Export(user, token string) (*ecdsa.PrivateKey, error) {
+	entry, err := self.authenticate(user, token)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.ToECDSA(entry.Key)
+}
+
+// Signer returns a resource signer using the key of an authenticated user
+func (self *ManagedSigners) Signer(user, token string) (storage.ResourceSigner, error) {
+	privKey, err := self.Export(user, token)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.GenericResourceSigner{PrivKey: privKey}, nil
+}
+
+// Delete removes the key of an authenticated user
+func (self *ManagedSigners) Delete(user, token string) error {
+	if _, err := self.authenticate(user, token); err != nil {
+		return err
+	}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.keys, user)
+	if self.store != nil {
+		return self.store.Delete(managedKeyPrefix + user)
+	}
+	return nil
+}
+
+// authenticate returns the key of the user if the token matches
+func (self *ManagedSigners) authenticate(user, token string) (*managedKey, error) {
+	self.mu.Lock()
+	entry := self.get(user)
+	self.mu.Unlock()
+	if entry == nil || subtle.ConstantTimeCompare(entry.TokenHash, crypto.Keccak256([]byte(token))) != 1 {
+		managedAuthFail.Inc(1)
+		return nil, ErrManagedAuth
+	}
+	return entry, nil
+}
+
+// get returns the key of the user, loading it from the state store if
+// needed, the caller must hold the lock
+func (self *ManagedSigners) get(user string) *managedKey {
+	if entry, ok := self.keys[user]; ok {
+		return entry
+	}
+	if self.store == nil {
+		return nil
+	}
+	entry := &managedKey{}
+	if err := self.store.Get(managedKeyPrefix+user, entry); err != nil {
+		return nil
+	}
+	self.keys[user] = entry
+	return entry
+}
+
+// SetManagedSigners enables signing resource updates with the publisher keys
+// the gateway manages on behalf of its users
+func (self *Api) SetManagedSigners(signers *ManagedSigners) {
+	self.managed = signers
+}
+
+// ManagedSigners returns the managed publisher keys, nil if disabled
+func (self *Api) ManagedSigners() *ManagedSigners {
+	return self.managed
+}
+
+// ResourceUpdateAs adds a raw update to the resource signed with the
+// publisher key of an authenticated user
+func (self *Api) ResourceUpdateAs(ctx context.Context, user, token, name string, data []byte) (storage.Key, uint32, uint32, error) {
+	if self.managed == nil {
+		return nil, 0, 0, ErrManagedDisabled
+	}
+	signer, err := self.managed.Signer(user, token)
+	if err != nil {
+		return nil, 0, 0, storage.NewResourceError(storage.ErrUnauthorized, err.Error())
+	}
+	managedSignCount.Inc(1)
+	key, err := self.resource.UpdateWithSigner(ctx, name, data, signer)
+	nameHash := storage.ResourceNameHash(name).Hex()
+	period, _ := self.resource.GetLastPeriod(nameHash)
+	version, _ := self.resource.GetVersion(nameHash)
+	return key, period, version, err
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/state"
+)
+
+// TestManagedSigners checks that keys are only handed out to authenticated
+// users and survive a restart of the gateway
+func TestManagedSigners(t *testing.T) {
+	store := state.NewInmemoryStore()
+	signers := NewManagedSigners(store)
+
+	if _, err := signers.Create("not/a user"); err == nil {
+		t.Fatal("expected invalid user name to be rejected")
+	}
+	info, err := signers.Create("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Token == "" {
+		t.Fatal("expected token of new key")
+	}
+	if _, err := signers.Create("alice"); err != ErrManagedUserExists {
+		t.Fatalf("expected %v, got %v", ErrManagedUserExists, err)
+	}
+	if _, err := signers.Signer("alice", "wrong"); err != ErrManagedAuth {
+		t.Fatalf("expected %v, got %v", ErrManagedAuth, err)
+	}
+	if _, err := signers.Signer("bob", info.Token); err != ErrManagedAuth {
+		t.Fatalf("expected %v, got %v", ErrManagedAuth, err)
+	}
+
+	// the key is loaded from the store after a restart
+	signers = NewManagedSigners(store)
+	privKey, err := signers.Export("alice", info.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := crypto.PubkeyToAddress(privKey.PublicKey); addr != info.Address {
+		t.Fatalf("expected exported key of %x, got %x", info.Address, addr)
+	}
+	stored, err := signers.Info("alice", info.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Address != info.Address || stored.Token != "" {
+		t.Fatalf("unexpected key info %v", stored)
+	}
+
+	if err := signers.Delete("alice", "wrong"); err != ErrManagedAuth {
+		t.Fatalf("expected %v, got %v", ErrManagedAuth, err)
+	}
+	if err := signers.Delete("alice", info.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewManagedSigners(store).Info("alice", info.Token); err != ErrManagedAuth {
+		t.Fatalf("expected deleted key to be gone, got %v", err)
+	}
+}
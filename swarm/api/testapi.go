@@ -17,7 +17,13 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/storage"
 )
 
 type Control struct {
@@ -44,3 +50,75 @@ func NewControl(api *Api, hive *network.Hive) *Control {
 func (self *Control) Hive() string {
 	return self.hive.String()
 }
+
+// Saturation returns how well the bins of the kademlia table are filled
+func (self *Control) Saturation() (*network.Saturation, error) {
+	kad, ok := self.hive.Overlay.(*network.Kademlia)
+	if !ok {
+		return nil, fmt.Errorf("overlay %T is not a kademlia table", self.hive.Overlay)
+	}
+	return kad.Saturation(), nil
+}
+
+// HealthScore rates the connectivity of the kademlia table from 0 to 100
+func (self *Control) HealthScore() (*network.HealthScore, error) {
+	kad, ok := self.hive.Overlay.(*network.Kademlia)
+	if !ok {
+		return nil, fmt.Errorf("overlay %T is not a kademlia table", self.hive.Overlay)
+	}
+	return kad.HealthScore(), nil
+}
+
+// HealthInfo returns the diagnosis of the connectivity of the kademlia table,
+// the bins, the missing nearest neighbours and the empty bins
+func (self *Control) HealthInfo() (*network.HealthInfo, error) {
+	kad, ok := self.hive.Overlay.(*network.Kademlia)
+	if !ok {
+		return nil, fmt.Errorf("overlay %T is not a kademlia table", self.hive.Overlay)
+	}
+	return kad.HealthInfo(), nil
+}
+
+// ResetRetries resets the redial attempts of the bootnodes, or of all known
+// peers if all is set, so that peers given up are dialed again. It returns
+// the number of peers reset.
+func (self *Control) ResetRetries(all bool) (int, error) {
+	kad, ok := self.hive.Overlay.(*network.Kademlia)
+	if !ok {
+		return 0, fmt.Errorf("overlay %T is not a kademlia table", self.hive.Overlay)
+	}
+	return kad.ResetRetries(all), nil
+}
+
+// ExportPeers writes a snapshot of the known peers with their dial history
+// to the given file as JSON, and returns the number of peers written
+func (self *Control) ExportPeers(file string) (int, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return self.hive.ExportPeers(f)
+}
+
+// ImportPeers registers the known peers of a snapshot written by
+// ExportPeers, and returns the number of peers imported
+func (self *Control) ImportPeers(file string) (int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return self.hive.ImportPeers(f)
+}
+
+// Notarize records the root hash on the chain with the account of the node
+// and returns the hash of the transaction, see Notary
+func (self *Control) Notarize(ctx context.Context, root storage.Key) (common.Hash, error) {
+	return self.api.Notarize(ctx, root)
+}
+
+// Notarization looks up the notarization recorded by the transaction
+func (self *Control) Notarization(ctx context.Context, tx common.Hash) (*Notarization, error) {
+	return self.api.Notarization(ctx, tx)
+}
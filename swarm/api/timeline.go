@@ -0,0 +1,263 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const (
+	// DefaultResourceTimelineLimit is the number of entries returned by ResourceTimeline if no limit is given
+	DefaultResourceTimelineLimit = 20
+	// MaxResourceTimelineLimit is the maximum number of entries returned by ResourceTimeline
+	MaxResourceTimelineLimit = 200
+	// MaxResourceTimelineFeeds is the maximum number of feeds merged by ResourceTimeline
+	MaxResourceTimelineFeeds = 64
+)
+
+// ResourceCursor is the position of an update in a feed
+type ResourceCursor struct {
+	Period  uint32 `json:"period"`
+	Version uint32 `json:"version"`
+}
+
+// ResourceTimelineFeed selects a feed of a timeline and the position to read
+// it from. It is encoded as text in the form parsed by
+// ParseResourceTimelineFeed.
+type ResourceTimelineFeed struct {
+	NameHash common.Hash
+	Cursor   *ResourceCursor // the newest update to return, the latest if nil
+}
+
+// ParseResourceTimelineFeed parses a feed of a timeline in the form
+// <feed>[@<period>.<version>], where the feed is either the namehash of a
+// resource in hex or the name of a topic feed, see storage.Topic.Name. This is
+// the form returned by String.
+func ParseResourceTimelineFeed(s string) (*ResourceTimelineFeed, error) {
+	feed := &ResourceTimelineFeed{}
+	id := s
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		id = s[:i]
+		parts := strings.Split(s[i+1:], ".")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid cursor in feed %q", s)
+		}
+		period, err := strconv.ParseUint(parts[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid period in feed %q", s)
+		}
+		version, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version in feed %q", s)
+		}
+		feed.Cursor = &ResourceCursor{Period: uint32(period), Version: uint32(version)}
+	}
+	if _, _, err := storage.ParseTopicName(id); err == nil {
+		feed.NameHash = storage.ResourceNameHash(id)
+		return feed, nil
+	}
+	b, err := hexutil.Decode(id)
+	if err != nil || len(b) != common.HashLength {
+		return nil, fmt.Errorf("invalid feed %q, expected namehash or topic name", id)
+	}
+	feed.NameHash = common.BytesToHash(b)
+	return feed, nil
+}
+
+func (f *ResourceTimelineFeed) String() string {
+	if f.Cursor == nil {
+		return f.NameHash.Hex()
+	}
+	return fmt.Sprintf("%s@%d.%d", f.NameHash.Hex(), f.Cursor.Period, f.Cursor.Version)
+}
+
+func (f *ResourceTimelineFeed) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+func (f *ResourceTimelineFeed) UnmarshalText(text []byte) error {
+	feed, err := ParseResourceTimelineFeed(string(text))
+	if err != nil {
+		return err
+	}
+	*f = *feed
+	return nil
+}
+
+// ResourceTimelineEntry is an update of a feed in a timeline
+type ResourceTimelineEntry struct {
+	Name     string            `json:"name"`
+	NameHash common.Hash       `json:"nameHash"`
+	Period   uint32            `json:"period"`
+	Version  uint32            `json:"version"`
+	ModTime  time.Time         `json:"modTime"` // creation time of the update, as claimed by its signer
+	Signer   common.Address    `json:"signer"`  // zero if the update is not signed
+	Envelope *ResourceEnvelope `json:"envelope"`
+}
+
+// ResourceTimeline is a page of the merged updates of several feeds
+type ResourceTimeline struct {
+	Entries []*ResourceTimelineEntry `json:"entries"` // newest first
+	// Next selects the feeds for the next page, with the cursors at their
+	// newest updates not returned yet. Feeds without older updates are left
+	// out, the timeline ends when it is empty.
+	Next []*ResourceTimelineFeed `json:"next"`
+	// Errors holds the errors of the feeds which could not be read, by namehash
+	Errors map[common.Hash]string `json:"errors,omitempty"`
+}
+
+// the head of a feed in the merge of a timeline, the newest update not
+// merged yet
+type timelineHead struct {
+	nameHash common.Hash
+	view     *storage.ResourceView
+	entry    *ResourceTimelineEntry // nil when the feed is exhausted
+}
+
+// ResourceTimeline merges the updates of the feeds into a single timeline,
+// newest first by the creation time of the updates, returning at most limit
+// entries. The updates are rendered with their structured envelope, see
+// ResourceHistoryEntry.Envelope.
+//
+// Each feed is read from its cursor, or from its latest update if it has no
+// cursor, and the timeline continues with the feeds returned in Next. Only
+// the updates which end up in the page are looked up, plus the head of each
+// feed. The feeds must be loaded in the resource handler; feeds which cannot
+// be read are reported in Errors without failing the timeline.
+func (self *Api) ResourceTimeline(ctx context.Context, feeds []*ResourceTimelineFeed, limit int) (*ResourceTimeline, error) {
+	if limit <= 0 {
+		limit = DefaultResourceTimelineLimit
+	} else if limit > MaxResourceTimelineLimit {
+		limit = MaxResourceTimelineLimit
+	}
+	if len(feeds) == 0 {
+		return nil, storage.NewResourceError(storage.ErrInvalidValue, "No feeds given")
+	} else if len(feeds) > MaxResourceTimelineFeeds {
+		return nil, storage.NewResourceError(storage.ErrInvalidValue, fmt.Sprintf("Too many feeds: %d / %d", len(feeds), MaxResourceTimelineFeeds))
+	}
+
+	timeline := &ResourceTimeline{
+		Entries: []*ResourceTimelineEntry{},
+		Next:    []*ResourceTimelineFeed{},
+		Errors:  make(map[common.Hash]string),
+	}
+	heads := make([]*timelineHead, 0, len(feeds))
+	seen := make(map[common.Hash]bool)
+	for _, feed := range feeds {
+		// the updates of a feed given twice would be merged twice
+		if seen[feed.NameHash] {
+			return nil, storage.NewResourceError(storage.ErrInvalidValue, fmt.Sprintf("Feed %s given more than once", feed.NameHash.Hex()))
+		}
+		seen[feed.NameHash] = true
+
+		view, err := self.timelineHead(ctx, feed)
+		var entry *ResourceTimelineEntry
+		if err == nil {
+			entry, err = timelineEntry(view)
+		}
+		if err != nil {
+			log.Debug("resource timeline feed failed", "namehash", feed.NameHash, "err", err)
+			timeline.Errors[feed.NameHash] = err.Error()
+			continue
+		}
+		heads = append(heads, &timelineHead{nameHash: feed.NameHash, view: view, entry: entry})
+	}
+
+	for len(timeline.Entries) < limit {
+		// the newest head, the feed given first on ties
+		var newest *timelineHead
+		for _, head := range heads {
+			if head.entry != nil && (newest == nil || head.entry.ModTime.After(newest.entry.ModTime)) {
+				newest = head
+			}
+		}
+		if newest == nil {
+			break
+		}
+		timeline.Entries = append(timeline.Entries, newest.entry)
+		newest.entry = nil
+		// an error here means the oldest update was reached
+		view, err := self.resource.LookupPrevious(ctx, newest.view, nil)
+		if err != nil {
+			continue
+		}
+		entry, err := timelineEntry(view)
+		if err != nil {
+			timeline.Errors[newest.nameHash] = err.Error()
+			continue
+		}
+		newest.view, newest.entry = view, entry
+	}
+
+	for _, head := range heads {
+		if head.entry == nil {
+			continue
+		}
+		timeline.Next = append(timeline.Next, &ResourceTimelineFeed{
+			NameHash: head.nameHash,
+			Cursor: &ResourceCursor{
+				Period:  head.entry.Period,
+				Version: head.entry.Version,
+			},
+		})
+	}
+	return timeline, nil
+}
+
+// looks up the newest update of the feed to merge into the timeline
+func (self *Api) timelineHead(ctx context.Context, feed *ResourceTimelineFeed) (*storage.ResourceView, error) {
+	if feed.Cursor == nil {
+		return self.resource.LookupLatest(ctx, feed.NameHash, true, nil)
+	}
+	return self.resource.LookupVersion(ctx, feed.NameHash, feed.Cursor.Period, feed.Cursor.Version, true, nil)
+}
+
+// returns the timeline entry of the update of a view
+func timelineEntry(view *storage.ResourceView) (*ResourceTimelineEntry, error) {
+	meta, err := view.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	data, err := view.Data()
+	if err != nil {
+		return nil, err
+	}
+	entry := &ResourceHistoryEntry{
+		Period:    meta.Period,
+		Version:   meta.Version,
+		Multihash: view.Multihash,
+		Data:      data,
+	}
+	return &ResourceTimelineEntry{
+		Name:     meta.Name,
+		NameHash: view.NameHash(),
+		Period:   meta.Period,
+		Version:  meta.Version,
+		ModTime:  meta.ModTime,
+		Signer:   meta.Signer,
+		Envelope: entry.Envelope(),
+	}, nil
+}
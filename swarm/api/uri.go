@@ -39,6 +39,14 @@ type URI struct {
 	// * bzz-immutable - immutable URI of an entry in a swarm manifest
 	//                   (address is not resolved)
 	// * bzz-list      -  list of all files contained in a swarm manifest
+	// * bzz-pin       - pinning of content in the local store
+	// * bzz-signer    - publisher keys managed by the gateway on behalf of
+	//                   its users
+	// * bzz-timeline  - merged updates of several mutable resources
+	// * bzz-search    - full-text search of the content of the node
+	// * bzz-keys      - API keys issued by the gateway and their usage
+	// * bzz-access    - grants of access to encrypted content and private
+	//                   resources
 	//
 	Scheme string
 
@@ -63,7 +71,9 @@ type URI struct {
 // * <scheme>://<addr>
 // * <scheme>://<addr>/<path>
 //
-// with scheme one of bzz, bzz-raw, bzz-immutable, bzz-list or bzz-hash
+// with scheme one of bzz, bzz-raw, bzz-immutable, bzz-list, bzz-hash,
+// bzz-resource, bzz-pin, bzz-signer, bzz-timeline, bzz-search, bzz-keys or
+// bzz-access
 func Parse(rawuri string) (*URI, error) {
 	u, err := url.Parse(rawuri)
 	if err != nil {
@@ -73,7 +83,7 @@ func Parse(rawuri string) (*URI, error) {
 
 	// check the scheme is valid
 	switch uri.Scheme {
-	case "bzz", "bzz-raw", "bzz-immutable", "bzz-list", "bzz-hash", "bzz-resource":
+	case "bzz", "bzz-raw", "bzz-immutable", "bzz-list", "bzz-hash", "bzz-resource", "bzz-pin", "bzz-signer", "bzz-timeline", "bzz-search", "bzz-keys", "bzz-access":
 	default:
 		return nil, fmt.Errorf("unknown scheme %q", u.Scheme)
 	}
@@ -115,6 +125,30 @@ func (u *URI) Hash() bool {
 	return u.Scheme == "bzz-hash"
 }
 
+func (u *URI) Pin() bool {
+	return u.Scheme == "bzz-pin"
+}
+
+func (u *URI) Signer() bool {
+	return u.Scheme == "bzz-signer"
+}
+
+func (u *URI) Timeline() bool {
+	return u.Scheme == "bzz-timeline"
+}
+
+func (u *URI) Search() bool {
+	return u.Scheme == "bzz-search"
+}
+
+func (u *URI) GatewayKeys() bool {
+	return u.Scheme == "bzz-keys"
+}
+
+func (u *URI) Access() bool {
+	return u.Scheme == "bzz-access"
+}
+
 func (u *URI) String() string {
 	return u.Scheme + ":/" + u.Addr + "/" + u.Path
 }
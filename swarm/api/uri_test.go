@@ -104,6 +104,30 @@ func TestParseURI(t *testing.T) {
 			uri:       "bzz://abc123/path/to/entry",
 			expectURI: &URI{Scheme: "bzz", Addr: "abc123", Path: "path/to/entry"},
 		},
+		{
+			uri:       "bzz-pin:/abc123",
+			expectURI: &URI{Scheme: "bzz-pin", Addr: "abc123"},
+		},
+		{
+			uri:       "bzz-signer:/alice/key",
+			expectURI: &URI{Scheme: "bzz-signer", Addr: "alice", Path: "key"},
+		},
+		{
+			uri:       "bzz-timeline:/",
+			expectURI: &URI{Scheme: "bzz-timeline"},
+		},
+		{
+			uri:       "bzz-search:/",
+			expectURI: &URI{Scheme: "bzz-search"},
+		},
+		{
+			uri:       "bzz-keys:/alice/0123abcd",
+			expectURI: &URI{Scheme: "bzz-keys", Addr: "alice", Path: "0123abcd"},
+		},
+		{
+			uri:       "bzz-access:/",
+			expectURI: &URI{Scheme: "bzz-access"},
+		},
 		{
 			uri:        "bzz-hash:",
 			expectURI:  &URI{Scheme: "bzz-hash"},
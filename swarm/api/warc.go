@@ -0,0 +1,212 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	WarcContentType = "application/warc"
+
+	warcIndexFile = "index.html"
+)
+
+// WarcRecord is a single record of a WARC (Web ARChive, ISO 28500) file
+type WarcRecord struct {
+	Type      string               // WARC-Type, e.g. "response" or "resource"
+	TargetURI string               // WARC-Target-URI
+	Date      time.Time            // WARC-Date
+	Header    textproto.MIMEHeader // all named fields of the record
+	Block     []byte               // the record content block
+}
+
+// WarcReader reads consecutive records from a WARC file. Gzipped WARC files
+// (one gzip member per record, or the whole file compressed) are detected and
+// decompressed transparently.
+type WarcReader struct {
+	r *bufio.Reader
+	t *textproto.Reader
+}
+
+func NewWarcReader(r io.Reader) (*WarcReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("error reading gzipped warc: %s", err)
+		}
+		br = bufio.NewReader(gz)
+	}
+	return &WarcReader{
+		r: br,
+		t: textproto.NewReader(br),
+	}, nil
+}
+
+// Next returns the next record of the archive, or io.EOF if there are no more records
+func (w *WarcReader) Next() (*WarcRecord, error) {
+	// skip the blank lines separating records
+	var version string
+	for version == "" {
+		line, err := w.t.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		version = strings.TrimSpace(line)
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("invalid warc record version line %q", version)
+	}
+	header, err := w.t.ReadMIMEHeader()
+	if err != nil {
+		return nil, fmt.Errorf("error reading warc record header: %s", err)
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid warc record Content-Length %q", header.Get("Content-Length"))
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(w.r, block); err != nil {
+		return nil, fmt.Errorf("error reading warc record block: %s", err)
+	}
+	rec := &WarcRecord{
+		Type:      header.Get("WARC-Type"),
+		TargetURI: strings.Trim(header.Get("WARC-Target-URI"), "<>"),
+		Header:    header,
+		Block:     block,
+	}
+	if date := header.Get("WARC-Date"); date != "" {
+		rec.Date, _ = time.Parse(time.RFC3339, date)
+	}
+	return rec, nil
+}
+
+// Content returns the content type and the payload of the resource captured in
+// the record. For response records the HTTP response is parsed and its body
+// returned, for resource records the block is returned verbatim.
+// Records which do not capture a resource return an error.
+func (rec *WarcRecord) Content() (string, []byte, error) {
+	switch rec.Type {
+	case "resource":
+		return rec.Header.Get("Content-Type"), rec.Block, nil
+	case "response":
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(rec.Block)), nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading http response of %s: %s", rec.TargetURI, err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return "", nil, fmt.Errorf("response status of %s is %s", rec.TargetURI, res.Status)
+		}
+		var body io.Reader = res.Body
+		if res.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(res.Body)
+			if err != nil {
+				return "", nil, fmt.Errorf("error reading gzipped response of %s: %s", rec.TargetURI, err)
+			}
+			body = gz
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return "", nil, fmt.Errorf("error reading http response of %s: %s", rec.TargetURI, err)
+		}
+		return res.Header.Get("Content-Type"), data, nil
+	}
+	return "", nil, fmt.Errorf("warc record of type %q has no content", rec.Type)
+}
+
+// WarcURIToPath maps a captured URL to a manifest path, made up of the host
+// and the path of the URL. The query and fragment are dropped, and paths
+// denoting a directory are mapped to its index.html
+func WarcURIToPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("url %q has no host", uri)
+	}
+	p := u.Path
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += warcIndexFile
+	}
+	return path.Join(strings.ToLower(u.Host), path.Clean("/"+p)), nil
+}
+
+// AddWarc adds the resources captured in a WARC archive under the given path
+// prefix, preserving the captured URLs as paths and the content types of the
+// captured responses. If a URL was captured more than once the last capture
+// wins. It returns the number of entries added.
+func (m *ManifestWriter) AddWarc(r io.Reader, prefix string) (int, error) {
+	wr, err := NewWarcReader(r)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for {
+		rec, err := wr.Next()
+		if err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, err
+		}
+		if rec.Type != "response" && rec.Type != "resource" {
+			continue
+		}
+		p, err := WarcURIToPath(rec.TargetURI)
+		if err != nil {
+			log.Debug("skipping warc record", "uri", rec.TargetURI, "err", err)
+			continue
+		}
+		contentType, data, err := rec.Content()
+		if err != nil {
+			log.Debug("skipping warc record", "uri", rec.TargetURI, "err", err)
+			continue
+		}
+		modTime := rec.Date
+		if modTime.IsZero() {
+			modTime = time.Now()
+		}
+		entry := &ManifestEntry{
+			Path:        path.Join(prefix, p),
+			ContentType: contentType,
+			Mode:        0644,
+			Size:        int64(len(data)),
+			ModTime:     modTime,
+		}
+		if _, err := m.AddEntry(bytes.NewReader(data), entry); err != nil {
+			return count, fmt.Errorf("error adding manifest entry for %s: %s", rec.TargetURI, err)
+		}
+		count++
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// writeWarcRecord appends a WARC record with the given type, target and block to buf
+func writeWarcRecord(buf *bytes.Buffer, typ, uri, contentType, block string) {
+	fmt.Fprintf(buf, "WARC/1.0\r\nWARC-Type: %s\r\nWARC-Target-URI: %s\r\nWARC-Date: 2018-05-04T20:35:22Z\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n", typ, uri, contentType, len(block), block)
+}
+
+func testWarcArchive() *bytes.Buffer {
+	buf := new(bytes.Buffer)
+	writeWarcRecord(buf, "warcinfo", "", "application/warc-fields", "software: test\r\n")
+	writeWarcRecord(buf, "request", "http://example.com/", "application/http; msgtype=request", "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	writeWarcRecord(buf, "response", "http://example.com/", "application/http; msgtype=response", "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 5\r\n\r\nindex")
+	writeWarcRecord(buf, "response", "<http://Example.com/css/style.css?v=1>", "application/http; msgtype=response", "HTTP/1.1 200 OK\r\nContent-Type: text/css\r\n\r\nbody{}")
+	writeWarcRecord(buf, "response", "http://example.com/missing", "application/http; msgtype=response", "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+	writeWarcRecord(buf, "resource", "http://example.com/img/logo.png", "image/png", "png")
+	return buf
+}
+
+func TestWarcURIToPath(t *testing.T) {
+	for uri, exp := range map[string]string{
+		"http://example.com":                   "example.com/index.html",
+		"http://example.com/":                  "example.com/index.html",
+		"https://EXAMPLE.com/a/b/":             "example.com/a/b/index.html",
+		"http://example.com/a/../b.html?x=1#y": "example.com/b.html",
+	} {
+		p, err := WarcURIToPath(uri)
+		if err != nil {
+			t.Fatalf("%s: %v", uri, err)
+		}
+		if p != exp {
+			t.Fatalf("expected path of %s to be %q, got %q", uri, exp, p)
+		}
+	}
+	if _, err := WarcURIToPath("/relative"); err == nil {
+		t.Fatal("expected url without host to fail")
+	}
+}
+
+func TestWarcReader(t *testing.T) {
+	archive := testWarcArchive()
+	gzipped := new(bytes.Buffer)
+	gw := gzip.NewWriter(gzipped)
+	gw.Write(archive.Bytes())
+	gw.Close()
+
+	for _, input := range []*bytes.Buffer{archive, gzipped} {
+		wr, err := NewWarcReader(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for {
+			rec, err := wr.Next()
+			if err != nil {
+				break
+			}
+			types = append(types, rec.Type)
+		}
+		if fmt.Sprint(types) != "[warcinfo request response response response resource]" {
+			t.Fatalf("unexpected record types %v", types)
+		}
+	}
+}
+
+func TestManifestWriterAddWarc(t *testing.T) {
+	testApi(t, func(api *Api, toEncrypt bool) {
+		key, err := api.NewManifest(toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mw, err := api.NewManifestWriter(key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := mw.AddWarc(testWarcArchive(), "archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 3 {
+			t.Fatalf("expected 3 entries to be added, got %d", count)
+		}
+		key, err = mw.Store()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for p, exp := range map[string][2]string{
+			"archive/example.com/index.html":    {"text/html", "index"},
+			"archive/example.com/css/style.css": {"text/css", "body{}"},
+			"archive/example.com/img/logo.png":  {"image/png", "png"},
+		} {
+			reader, contentType, _, _, err := api.Get(key, p)
+			if err != nil {
+				t.Fatalf("%s: %v", p, err)
+			}
+			if contentType != exp[0] {
+				t.Fatalf("expected content type of %s to be %q, got %q", p, exp[0], contentType)
+			}
+			data, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != exp[1] {
+				t.Fatalf("expected content of %s to be %q, got %q", p, exp[1], data)
+			}
+		}
+		if _, _, _, _, err := api.Get(key, "archive/example.com/missing"); err == nil {
+			t.Fatal("expected non-200 response not to be imported")
+		}
+	})
+}
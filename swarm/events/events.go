@@ -0,0 +1,202 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package events exports the events of a swarm node, such as completed
+// uploads and published resource updates, to external sinks so operators can
+// feed them into their own pipelines.
+package events
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// DefaultQueueSize is the number of events queued for a sink before further
+// events are dropped
+const DefaultQueueSize = 256
+
+// Type is the type of a node event
+type Type string
+
+const (
+	Upload         Type = "upload"   // content was uploaded through the http gateway, see UploadData
+	ResourceUpdate Type = "resource" // the node published a resource update, see ResourceData
+	ChunkGC        Type = "gc"       // the garbage collector deleted chunks, see GCData
+	PeerDrop       Type = "peer"     // a peer was disconnected, see PeerData
+)
+
+// Types are the types of the events exported by the node
+var Types = []Type{Upload, ResourceUpdate, ChunkGC, PeerDrop}
+
+// ParseType parses the name of an event type
+func ParseType(s string) (Type, error) {
+	for _, t := range Types {
+		if string(t) == s {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown event type %q", s)
+}
+
+// Event is an event of the node as it is sent to the sinks
+type Event struct {
+	Type Type        `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// UploadData is the data of an Upload event
+type UploadData struct {
+	Key      string `json:"key"`
+	Manifest bool   `json:"manifest"` // the key is the one of a manifest, not of raw content
+	Size     int64  `json:"size"`     // size of the request body, -1 if unknown
+}
+
+// GCData is the data of a ChunkGC event
+type GCData struct {
+	Count int      `json:"count"`
+	Keys  []string `json:"keys"`
+}
+
+// PeerData is the data of a PeerDrop event
+type PeerData struct {
+	Peer  string `json:"peer"`
+	Error string `json:"error,omitempty"` // the reason the peer was dropped
+}
+
+// Sink is an external system the events are exported to, such as a message
+// queue. Sinks are called from a single goroutine each.
+type Sink interface {
+	Send(ev *Event) error
+	Close() error
+}
+
+// the queue of the events of a sink
+type sinkQueue struct {
+	sink  Sink
+	types map[Type]bool // all types if empty
+	queue chan *Event
+	done  chan struct{}
+}
+
+func (self *sinkQueue) run() {
+	defer close(self.done)
+	for ev := range self.queue {
+		if err := self.sink.Send(ev); err != nil {
+			metrics.GetOrRegisterCounter("events.fail", nil).Inc(1)
+			log.Warn("event export failed", "type", ev.Type, "err", err)
+			continue
+		}
+		metrics.GetOrRegisterCounter("events.sent", nil).Inc(1)
+	}
+}
+
+// Exporter sends the events published by the node to the sinks which take
+// their type. Sinks are sent the events in the order they were published,
+// each from its own queue, so a slow sink does not hold up the node or the
+// other sinks. Events are dropped when the queue of a sink is full.
+//
+// A nil exporter drops all events.
+type Exporter struct {
+	sinks     []*sinkQueue
+	queueSize int
+	lock      sync.RWMutex
+	closed    bool
+}
+
+// NewExporter returns an exporter without sinks
+func NewExporter() *Exporter {
+	return &Exporter{
+		queueSize: DefaultQueueSize,
+	}
+}
+
+// AddSink adds a sink taking the events of the types, or of all types if no
+// type is given
+func (self *Exporter) AddSink(sink Sink, types ...Type) {
+	q := &sinkQueue{
+		sink:  sink,
+		types: make(map[Type]bool),
+		queue: make(chan *Event, self.queueSize),
+		done:  make(chan struct{}),
+	}
+	for _, t := range types {
+		q.types[t] = true
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.sinks = append(self.sinks, q)
+	go q.run()
+}
+
+// Publish sends an event of the type with the data to the sinks taking it. It
+// never blocks.
+func (self *Exporter) Publish(typ Type, data interface{}) {
+	if self == nil {
+		return
+	}
+	ev := &Event{
+		Type: typ,
+		Time: time.Now(),
+		Data: data,
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	if self.closed {
+		return
+	}
+	for _, q := range self.sinks {
+		if len(q.types) > 0 && !q.types[typ] {
+			continue
+		}
+		select {
+		case q.queue <- ev:
+		default:
+			metrics.GetOrRegisterCounter("events.drop", nil).Inc(1)
+			log.Warn("event sink not keeping up, dropping event", "type", typ)
+		}
+	}
+}
+
+// Close sends the queued events and closes the sinks
+func (self *Exporter) Close() error {
+	self.lock.Lock()
+	if self.closed {
+		self.lock.Unlock()
+		return nil
+	}
+	self.closed = true
+	sinks := self.sinks
+	self.lock.Unlock()
+
+	var errs []string
+	for _, q := range sinks {
+		close(q.queue)
+		<-q.done
+		if err := q.sink.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("closing event sinks: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// records the events sent to it
+type testSink struct {
+	lock   sync.Mutex
+	events []*Event
+	block  chan struct{} // Send waits for it to be closed if not nil
+	closed bool
+}
+
+func (self *testSink) Send(ev *Event) error {
+	if self.block != nil {
+		<-self.block
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.events = append(self.events, ev)
+	return nil
+}
+
+func (self *testSink) Close() error {
+	self.closed = true
+	return nil
+}
+
+func (self *testSink) types() []Type {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	var types []Type
+	for _, ev := range self.events {
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+// TestExporter checks that the sinks are sent the events of their types in
+// order, and that a sink which does not keep up drops events without holding
+// up the others
+func TestExporter(t *testing.T) {
+	var nilExporter *Exporter
+	nilExporter.Publish(Upload, nil)
+
+	exporter := NewExporter()
+	all, uploads, slow := &testSink{}, &testSink{}, &testSink{block: make(chan struct{})}
+	exporter.AddSink(all)
+	exporter.AddSink(uploads, Upload)
+	exporter.queueSize = 2
+	exporter.AddSink(slow)
+
+	published := []Type{Upload, ResourceUpdate, ChunkGC, Upload, PeerDrop}
+	for _, typ := range published {
+		exporter.Publish(typ, nil)
+	}
+	close(slow.block)
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	exporter.Publish(Upload, nil)
+
+	check := func(name string, sink *testSink, expected []Type) {
+		t.Helper()
+		got := sink.types()
+		if len(got) != len(expected) {
+			t.Fatalf("%s: expected events %v, got %v", name, expected, got)
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Fatalf("%s: expected events %v, got %v", name, expected, got)
+			}
+		}
+		if !sink.closed {
+			t.Fatalf("%s: expected sink to be closed", name)
+		}
+	}
+	check("all", all, published)
+	check("uploads", uploads, []Type{Upload, Upload})
+	// the slow sink takes one event, queues two and drops the others
+	if n := len(slow.types()); n < 2 || n > 3 {
+		t.Fatalf("expected the slow sink to drop events, got %d events", n)
+	}
+}
+
+// TestSources checks the events published by the resource middleware and
+// the gc listener
+func TestSources(t *testing.T) {
+	exporter := NewExporter()
+	sink := &testSink{}
+	exporter.AddSink(sink)
+
+	key := storage.Key(make([]byte, 32))
+	key[0] = 1
+	exporter.ResourceMiddleware().PostStore(context.Background(), &storage.ResourcePublication{
+		Name:    "foo.eth",
+		Key:     key,
+		Period:  3,
+		Version: 1,
+		Data:    []byte("bar"),
+	})
+	exporter.GCListener()([]storage.Key{key, key})
+	exporter.Close()
+
+	if len(sink.events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(sink.events))
+	}
+	if data, ok := sink.events[0].Data.(*ResourceData); !ok || data.Name != "foo.eth" || data.Period != 3 || data.Version != 1 || data.Size != 3 || data.Key != key.Hex() {
+		t.Fatalf("unexpected resource event %+v", sink.events[0].Data)
+	}
+	if data, ok := sink.events[1].Data.(*GCData); !ok || data.Count != 2 || data.Keys[1] != key.Hex() {
+		t.Fatalf("unexpected gc event %+v", sink.events[1].Data)
+	}
+}
+
+// TestParseSink checks the webhook and file sinks and their configuration
+func TestParseSink(t *testing.T) {
+	var lock sync.Mutex
+	var posted []map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		posted = append(posted, ev)
+		lock.Unlock()
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "swarm-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.json")
+
+	exporter := NewExporter()
+	for _, s := range []string{"upload+gc@" + srv.URL, "file://" + path} {
+		sink, types, err := ParseSink(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exporter.AddSink(sink, types...)
+	}
+	exporter.Publish(Upload, &UploadData{Key: "abcd", Size: 4})
+	exporter.Publish(PeerDrop, &PeerData{Peer: "ef01", Error: "bad message"})
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(posted) != 1 || posted[0]["type"] != "upload" || posted[0]["data"].(map[string]interface{})["key"] != "abcd" {
+		t.Fatalf("expected the upload to be posted to the webhook, got %v", posted)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines []*Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		ev := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), ev); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, ev)
+	}
+	if len(lines) != 2 || lines[0].Type != Upload || lines[1].Type != PeerDrop {
+		t.Fatalf("expected both events in the file, got %v", lines)
+	}
+
+	for _, s := range []string{"nats://localhost:4222", "upload+foo@http://localhost/hook", "relative/path"} {
+		if _, _, err := ParseSink(s); err == nil {
+			t.Fatalf("expected sink %q to be invalid", s)
+		}
+	}
+}
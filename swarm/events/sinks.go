@@ -0,0 +1,144 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWebhookTimeout is the time a webhook is given to take an event
+const DefaultWebhookTimeout = 10 * time.Second
+
+// WebhookSink posts each event as a JSON object to a URL, for message queues
+// with an http bridge and for custom receivers
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink returns a sink posting the events to the URL
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: DefaultWebhookTimeout},
+	}
+}
+
+// Send posts the event to the webhook, which must respond with a 2xx status
+func (self *WebhookSink) Send(ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	res, err := self.client.Post(self.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded %s", self.url, res.Status)
+	}
+	return nil
+}
+
+func (self *WebhookSink) Close() error {
+	return nil
+}
+
+// FileSink appends each event as a line of JSON to a file, which can be a
+// named pipe read by the producer of a message queue
+type FileSink struct {
+	file *os.File
+	lock sync.Mutex
+}
+
+// NewFileSink opens the file at path for appending events, creating it if
+// it does not exist
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+// Send appends the event to the file
+func (self *FileSink) Send(ev *Event) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	_, err = self.file.Write(append(line, '\n'))
+	return err
+}
+
+func (self *FileSink) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.file.Close()
+}
+
+// ParseSink parses a sink configuration of the form [types@]url and opens
+// the sink. The types are event type names separated by '+', the sink takes
+// the events of all types if none are given, for example
+//
+//   upload+resource@https://example.com/hook
+//   file:///var/log/swarm/events.json
+//
+// http and https URLs are webhooks, see WebhookSink, file URLs and absolute
+// paths are files, see FileSink. Message queues without an http interface
+// are fed from a file, or by a program implementing Sink.
+func ParseSink(s string) (Sink, []Type, error) {
+	var types []Type
+	if i := strings.Index(s, "@"); i > 0 && !strings.Contains(s[:i], "/") {
+		for _, name := range strings.Split(s[:i], "+") {
+			t, err := ParseType(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			types = append(types, t)
+		}
+		s = s[i+1:]
+	}
+	if strings.HasPrefix(s, "/") {
+		sink, err := NewFileSink(s)
+		return sink, types, err
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid event sink %q: %v", s, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return NewWebhookSink(s), types, nil
+	case "file":
+		sink, err := NewFileSink(u.Path)
+		return sink, types, err
+	default:
+		return nil, nil, fmt.Errorf("unsupported event sink %q, use a webhook or a file", s)
+	}
+}
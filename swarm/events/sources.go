@@ -0,0 +1,108 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package events
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// ResourceData is the data of a ResourceUpdate event
+type ResourceData struct {
+	Name      string `json:"name"`
+	NameHash  string `json:"nameHash"`
+	Key       string `json:"key"` // key of the update chunk
+	Period    uint32 `json:"period"`
+	Version   uint32 `json:"version"`
+	Multihash bool   `json:"multihash"`
+	Tombstone bool   `json:"tombstone"`
+	Signer    string `json:"signer"`
+	Size      int    `json:"size"`
+}
+
+// ResourceMiddleware returns the resource update middleware publishing the
+// updates the node stores, see storage.ResourceHandler.AddUpdateMiddleware
+func (self *Exporter) ResourceMiddleware() storage.ResourceUpdateMiddleware {
+	return &resourceMiddleware{exporter: self}
+}
+
+type resourceMiddleware struct {
+	exporter *Exporter
+}
+
+func (self *resourceMiddleware) PreSign(ctx context.Context, pub *storage.ResourcePublication) error {
+	return nil
+}
+
+func (self *resourceMiddleware) PostStore(ctx context.Context, pub *storage.ResourcePublication) {
+	self.exporter.Publish(ResourceUpdate, &ResourceData{
+		Name:      pub.Name,
+		NameHash:  pub.NameHash.Hex(),
+		Key:       pub.Key.Hex(),
+		Period:    pub.Period,
+		Version:   pub.Version,
+		Multihash: pub.Multihash,
+		Tombstone: pub.Tombstone,
+		Signer:    pub.Signer.Hex(),
+		Size:      len(pub.Data),
+	})
+}
+
+// GCListener returns the listener publishing the chunks deleted by the
+// garbage collector of a store, see storage.LDBStore.AddGCListener
+func (self *Exporter) GCListener() storage.GCListener {
+	return func(keys []storage.Key) {
+		data := &GCData{
+			Count: len(keys),
+			Keys:  make([]string, len(keys)),
+		}
+		for i, key := range keys {
+			data.Keys[i] = key.Hex()
+		}
+		self.Publish(ChunkGC, data)
+	}
+}
+
+// WatchPeers publishes the peers the p2p server drops, with the reason they
+// were dropped, until the returned subscription is unsubscribed. Swarm drops
+// peers which break its protocols, it has no other ban.
+func (self *Exporter) WatchPeers(srv *p2p.Server) event.Subscription {
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		peerC := make(chan *p2p.PeerEvent, DefaultQueueSize)
+		sub := srv.SubscribeEvents(peerC)
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev := <-peerC:
+				if ev.Type != p2p.PeerEventTypeDrop {
+					continue
+				}
+				self.Publish(PeerDrop, &PeerData{
+					Peer:  ev.Peer.String(),
+					Error: ev.Error,
+				})
+			case err := <-sub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package multihash
+
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	b58 "github.com/jbenet/go-base58"
+)
+
+// CID codec constants (https://github.com/multiformats/multicodec)
+const (
+	CodecRaw         = 0x55
+	CodecDagProtobuf = 0x70
+	CodecDagCBOR     = 0x71
+)
+
+var (
+	ErrInvalidCid       = errors.New("input isn't a valid cid")
+	ErrUnknownMultibase = errors.New("unknown multibase encoding")
+)
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Cid is a self-describing content identifier as used by IPFS.
+// See https://github.com/multiformats/cid for the specification.
+type Cid struct {
+	Version uint64
+	Codec   uint64
+	Hash    Multihash
+}
+
+// ParseCid parses the string representation of a CID.
+// CIDv0 is the base58 encoding of a sha2-256 multihash (Qm...), CIDv1 is
+// multibase encoded; base32, base58btc and base16 are supported.
+func ParseCid(s string) (*Cid, error) {
+	if len(s) == 46 && strings.HasPrefix(s, "Qm") {
+		m, err := FromB58String(s)
+		if err != nil {
+			return nil, err
+		}
+		return NewCidV0(m)
+	}
+	if len(s) < 2 {
+		return nil, ErrInvalidCid
+	}
+	var buf []byte
+	var err error
+	switch s[0] {
+	case 'b':
+		buf, err = base32Lower.DecodeString(s[1:])
+	case 'B':
+		buf, err = base32Lower.DecodeString(strings.ToLower(s[1:]))
+	case 'z':
+		buf = b58.Decode(s[1:])
+		if len(buf) == 0 {
+			err = ErrInvalidCid
+		}
+	case 'f', 'F':
+		buf, err = hex.DecodeString(s[1:])
+	default:
+		return nil, ErrUnknownMultibase
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid cid encoding: %v", err)
+	}
+	return CastCid(buf)
+}
+
+// CastCid parses the binary representation of a CID
+func CastCid(buf []byte) (*Cid, error) {
+	// a binary CIDv0 is a bare sha2-256 multihash
+	if len(buf) == 34 && buf[0] == SHA2_256 && buf[1] == 32 {
+		return NewCidV0(Multihash(buf))
+	}
+	version, rest, err := uvarint(buf)
+	if err != nil {
+		return nil, err
+	}
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported cid version %d", version)
+	}
+	codec, rest, err := uvarint(rest)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Cast(rest)
+	if err != nil {
+		return nil, err
+	}
+	return &Cid{
+		Version: 1,
+		Codec:   codec,
+		Hash:    m,
+	}, nil
+}
+
+// NewCidV0 returns the CIDv0 of a dag-pb node with the given sha2-256 multihash
+func NewCidV0(m Multihash) (*Cid, error) {
+	dm, err := Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	if dm.Code != SHA2_256 || dm.Length != 32 {
+		return nil, fmt.Errorf("cidv0 requires a sha2-256 multihash, got %s", dm.Name)
+	}
+	return &Cid{
+		Version: 0,
+		Codec:   CodecDagProtobuf,
+		Hash:    m,
+	}, nil
+}
+
+// NewCidV1 returns the CIDv1 of content with the given codec and multihash
+func NewCidV1(codec uint64, m Multihash) *Cid {
+	return &Cid{
+		Version: 1,
+		Codec:   codec,
+		Hash:    m,
+	}
+}
+
+// Bytes returns the binary representation of the CID
+func (c *Cid) Bytes() []byte {
+	if c.Version == 0 {
+		return []byte(c.Hash)
+	}
+	buf := make([]byte, 2*binary.MaxVarintLen64+len(c.Hash))
+	n := binary.PutUvarint(buf, c.Version)
+	n += binary.PutUvarint(buf[n:], c.Codec)
+	n += copy(buf[n:], c.Hash)
+	return buf[:n]
+}
+
+// String returns the canonical string representation of the CID,
+// base58 for CIDv0 and multibase base32 for CIDv1
+func (c *Cid) String() string {
+	if c.Version == 0 {
+		return c.Hash.B58String()
+	}
+	return "b" + base32Lower.EncodeToString(c.Bytes())
+}
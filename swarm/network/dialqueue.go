@@ -0,0 +1,85 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"container/heap"
+	"time"
+)
+
+// DialCandidate is a known peer which is not connected, scored by
+// KadParams.DialScore when SuggestPeer chooses the peer to dial
+type DialCandidate struct {
+	Address    OverlayAddr
+	PO         int           // proximity order of the peer, the bin it belongs to
+	Deficit    int           // number of connections the bin lacks up to MinBinSize
+	SeenAgo    time.Duration // time since the peer was last seen
+	Retries    int           // number of dials of the peer since it was last seen
+	Reputation float64       // reputation of the peer between -1 and 1, see Kademlia.Report
+}
+
+// DefaultDialScore prefers the peers of the bins lacking the most
+// connections. Among the peers of bins lacking as many, it weighs the
+// reputation of the peers most, then prefers the peers dialed the fewest
+// times and the peers seen the most recently.
+func DefaultDialScore(c *DialCandidate) float64 {
+	reputation := 0.25 * (1 + c.Reputation)
+	retries := 0.25 / float64(1+c.Retries)
+	seen := 0.125 / (1 + c.SeenAgo.Minutes())
+	return float64(c.Deficit) + reputation + retries + seen
+}
+
+// a candidate in the dial queue
+type dialItem struct {
+	e     *entry
+	score float64
+	seq   int // candidates with the same score are dialed in the order they were pushed
+}
+
+// dialQueue is a priority queue of the candidates of SuggestPeer, the
+// highest score first
+type dialQueue []*dialItem
+
+func (q dialQueue) Len() int { return len(q) }
+
+func (q dialQueue) Less(i, j int) bool {
+	if q[i].score != q[j].score {
+		return q[i].score > q[j].score
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q dialQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *dialQueue) Push(x interface{}) { *q = append(*q, x.(*dialItem)) }
+
+func (q *dialQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// adds a candidate with its score
+func (q *dialQueue) push(e *entry, score float64) {
+	heap.Push(q, &dialItem{e: e, score: score, seq: len(*q)})
+}
+
+// removes and returns the candidate with the highest score
+func (q *dialQueue) pop() *entry {
+	return heap.Pop(q).(*dialItem).e
+}
@@ -31,8 +31,9 @@ type discPeer struct {
 	overlay   Overlay
 	sentPeers bool // whether we already sent peer closer to this address
 	mtx       sync.RWMutex
-	peers     map[string]bool // tracks node records sent to the peer
-	depth     uint8           // the proximity order advertised by remote as depth of saturation
+	peers     map[string]bool          // tracks node records sent to the peer
+	depth     uint8                    // the proximity order advertised by remote as depth of saturation
+	pings     map[uint64]chan struct{} // pongs awaited by pings, by nonce
 }
 
 // NewDiscovery constructs a discovery peer
@@ -57,6 +58,15 @@ func (d *discPeer) HandleMsg(msg interface{}) error {
 	case *subPeersMsg:
 		return d.handleSubPeersMsg(msg)
 
+	case *connectRequestMsg:
+		return d.handleConnectRequestMsg(msg)
+
+	case *pingMsg:
+		return d.handlePingMsg(msg)
+
+	case *pongMsg:
+		return d.handlePongMsg(msg)
+
 	default:
 		return fmt.Errorf("unknown message type: %T", msg)
 	}
@@ -146,6 +156,11 @@ func (d *discPeer) handlePeersMsg(msg *peersMsg) error {
 		return nil
 	}
 
+	for _, a := range msg.Peers {
+		if err := checkAddr(a, d.overlay.BaseAddr()); err != nil {
+			return err
+		}
+	}
 	for _, a := range msg.Peers {
 		d.seen(a)
 		NotifyPeer(a, d.overlay)
@@ -0,0 +1,110 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package network
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// The go-fuzz harnesses of the bzz handshake and the hive messages, built
+// with go-fuzz-build -func FuzzHandshake or -func FuzzHive. The input is a
+// message code followed by the RLP encoded payload, the corpus is recorded
+// from the overlay simulation with its -corpus flag.
+
+var (
+	fuzzOnce sync.Once
+	fuzzBzz  *Bzz
+)
+
+func fuzzSetup() {
+	addr := NewAddrFromNodeID(discover.NodeID{1})
+	kad := NewKademlia(addr.Over(), NewKadParams())
+	fuzzBzz = NewBzz(&BzzConfig{
+		OverlayAddr:  addr.Over(),
+		UnderlayAddr: addr.Under(),
+		HiveParams:   NewHiveParams(),
+		NetworkID:    DefaultNetworkID,
+	}, kad, nil, nil, nil)
+	// relayed connection requests are dialed into the void
+	fuzzBzz.addPeer = func(*discover.Node) {}
+}
+
+// fuzzMsg decodes the message of the spec an input stands for
+func fuzzMsg(spec *protocols.Spec, data []byte) (interface{}, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	msg, ok := spec.NewMsg(uint64(data[0]))
+	if !ok {
+		return nil, false
+	}
+	if err := rlp.DecodeBytes(data[1:], msg); err != nil {
+		return nil, false
+	}
+	return msg, true
+}
+
+// FuzzHandshake checks the bzz handshake received from a peer, and enters
+// the address of the peer into the table if it is accepted
+func FuzzHandshake(data []byte) int {
+	fuzzOnce.Do(fuzzSetup)
+	msg, ok := fuzzMsg(BzzSpec, data)
+	if !ok {
+		return 0
+	}
+	if err := fuzzBzz.checkHandshake(msg); err != nil {
+		return 0
+	}
+	if err := fuzzBzz.Register(toOverlayAddrs(msg.(*HandshakeMsg).Addr)); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzHive handles a hive message received from a peer, the messages sent
+// back to the peer are discarded
+func FuzzHive(data []byte) int {
+	fuzzOnce.Do(fuzzSetup)
+	msg, ok := fuzzMsg(DiscoverySpec, data)
+	if !ok {
+		return 0
+	}
+	rw, remote := p2p.MsgPipe()
+	defer rw.Close()
+	go func() {
+		for {
+			m, err := remote.ReadMsg()
+			if err != nil {
+				return
+			}
+			m.Discard()
+		}
+	}()
+	p := protocols.NewPeer(p2p.NewPeer(discover.NodeID{2}, "fuzz", nil), rw, DiscoverySpec)
+	d := newDiscovery(NewBzzTestPeer(p, fuzzBzz.localAddr), fuzzBzz.Hive)
+	if err := d.HandleMsg(msg); err != nil {
+		return 0
+	}
+	return 1
+}
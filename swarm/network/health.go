@@ -0,0 +1,112 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/pot"
+)
+
+// HealthInfo is the diagnosis of the connectivity of the kademlia table,
+// see HealthInfo
+type HealthInfo struct {
+	Depth        int             `json:"depth"`        // neighbourhood depth of the connected peers
+	KnownDepth   int             `json:"knownDepth"`   // neighbourhood depth of the known peers, the one the node should have
+	Connected    int             `json:"connected"`    // number of connected peers
+	Known        int             `json:"known"`        // number of known peer addresses
+	Bins         []*BinHealth    `json:"bins"`         // the bins up to the deepest known peer
+	MissingNN    []hexutil.Bytes `json:"missingNN"`    // nearest neighbours by the known peers which are not connected
+	EmptyBins    []int           `json:"emptyBins"`    // bins shallower than the known depth without connected peers
+	Connectivity float64         `json:"connectivity"` // share of the bins shallower than the known depth with known peers which have connected peers
+	Healthy      bool            `json:"healthy"`      // connected to all the nearest neighbours and to a peer in each bin with known peers
+}
+
+// BinHealth is the saturation of a bin of the kademlia table
+type BinHealth struct {
+	PO        int  `json:"po"`
+	Known     int  `json:"known"`
+	Connected int  `json:"connected"`
+	Saturated bool `json:"saturated"` // at least MinBinSize connected peers, or all the known ones
+}
+
+// HealthInfo returns the diagnosis of the connectivity of the table. Unlike
+// Healthy, the nearest neighbours are not given by the test but derived from
+// the known peers, so it can be computed on a live node.
+func (k *Kademlia) HealthInfo() *HealthInfo {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	h := &HealthInfo{
+		Depth:     k.neighbourhoodDepth(),
+		Connected: k.conns.Size(),
+		Known:     k.addrs.Size(),
+		MissingNN: []hexutil.Bytes{},
+		EmptyBins: []int{},
+	}
+
+	// the nearest neighbours by the known peers are the MinProxBinSize
+	// nearest ones, and all the others of the bin of the farthest of them
+	var nn int
+	k.addrs.EachNeighbour(k.base, pof, func(val pot.Val, po int) bool {
+		if nn >= k.MinProxBinSize && po < h.KnownDepth {
+			return false
+		}
+		nn++
+		h.KnownDepth = po
+		if e := val.(*entry); e.conn() == nil {
+			h.MissingNN = append(h.MissingNN, e.Address())
+		}
+		return true
+	})
+	if h.Known < k.MinProxBinSize {
+		h.KnownDepth = 0
+	}
+
+	k.addrs.EachBin(k.base, pof, 0, func(po, size int, _ func(func(val pot.Val, i int) bool) bool) bool {
+		for len(h.Bins) <= po {
+			h.Bins = append(h.Bins, &BinHealth{PO: len(h.Bins)})
+		}
+		h.Bins[po].Known = size
+		return true
+	})
+	k.conns.EachBin(k.base, pof, 0, func(po, size int, _ func(func(val pot.Val, i int) bool) bool) bool {
+		h.Bins[po].Connected = size
+		return true
+	})
+
+	var bins, connected int
+	for _, b := range h.Bins {
+		b.Saturated = b.Connected >= k.MinBinSize || b.Connected == b.Known
+		if b.PO >= h.KnownDepth {
+			continue
+		}
+		if b.Connected == 0 {
+			h.EmptyBins = append(h.EmptyBins, b.PO)
+		}
+		if b.Known > 0 {
+			bins++
+			if b.Connected > 0 {
+				connected++
+			}
+		}
+	}
+	h.Connectivity = 1
+	if bins > 0 {
+		h.Connectivity = float64(connected) / float64(bins)
+	}
+	h.Healthy = h.Connected > 0 && len(h.MissingNN) == 0 && connected == bins
+	return h
+}
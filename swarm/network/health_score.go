@@ -0,0 +1,118 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/pot"
+)
+
+const (
+	// disconnections within this window count towards the churn rate
+	healthChurnWindow = 10 * time.Minute
+
+	// weights of the components of the health score, adding up to 100
+	healthBinFillWeight = 50
+	healthNNWeight      = 30
+	healthChurnWeight   = 20
+)
+
+// HealthScore rates the connectivity of the kademlia table from 0 to 100
+type HealthScore struct {
+	Score          int     `json:"score"`          // weighted sum of the other fields
+	BinFill        float64 `json:"binFill"`        // average fill ratio of the bins shallower than the depth, up to MinBinSize peers
+	NNCompleteness float64 `json:"nnCompleteness"` // share of the known peers in the neighbourhood that are connected
+	Churn          float64 `json:"churn"`          // disconnections within the last ten minutes per connected peer, at most 1
+}
+
+// HealthScore returns the health score of the connectivity. Unlike Healthy,
+// it does not need to know the expected nearest neighbours, and can be
+// computed on a live node.
+func (k *Kademlia) HealthScore() *HealthScore {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.healthScore(time.Now())
+}
+
+func (k *Kademlia) healthScore(now time.Time) *HealthScore {
+	h := &HealthScore{}
+	connected := k.conns.Size()
+	if connected < k.MinProxBinSize {
+		return h
+	}
+	depth := k.neighbourhoodDepth()
+
+	// bins shallower than the depth
+	if depth == 0 {
+		h.BinFill = 1
+	} else if k.MinBinSize > 0 {
+		fill := make([]float64, depth)
+		k.conns.EachBin(k.base, pof, 0, func(po, size int, _ func(func(val pot.Val, i int) bool) bool) bool {
+			if po >= depth {
+				return false
+			}
+			fill[po] = float64(size) / float64(k.MinBinSize)
+			if fill[po] > 1 {
+				fill[po] = 1
+			}
+			return true
+		})
+		for _, f := range fill {
+			h.BinFill += f / float64(depth)
+		}
+	} else {
+		h.BinFill = 1
+	}
+
+	// the neighbourhood
+	var known, nn int
+	k.addrs.EachNeighbour(k.base, pof, func(val pot.Val, po int) bool {
+		if po < depth {
+			return false
+		}
+		known++
+		if val.(*entry).conn() != nil {
+			nn++
+		}
+		return true
+	})
+	if known > 0 {
+		h.NNCompleteness = float64(nn) / float64(known)
+	}
+
+	h.Churn = float64(len(recentOffs(k.offs, now))) / float64(connected)
+	if h.Churn > 1 {
+		h.Churn = 1
+	}
+	h.Score = int(healthBinFillWeight*h.BinFill + healthNNWeight*h.NNCompleteness + healthChurnWeight*(1-h.Churn) + 0.5)
+	return h
+}
+
+// updates the health score metric, the caller must hold the lock
+func (k *Kademlia) updateHealthGauge() {
+	metrics.GetOrRegisterGauge("network.kademlia.health", nil).Update(int64(k.healthScore(time.Now()).Score))
+}
+
+// returns the times of the disconnections within the churn window before now
+func recentOffs(offs []time.Time, now time.Time) []time.Time {
+	for len(offs) > 0 && now.Sub(offs[0]) > healthChurnWindow {
+		offs = offs[1:]
+	}
+	return offs
+}
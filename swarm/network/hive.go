@@ -17,7 +17,11 @@
 package network
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -57,12 +61,44 @@ type Overlay interface {
 	Healthy(*PeerPot) *Health
 }
 
+// Rebalancer is implemented by overlays which can rebalance their bins
+// proactively, see Kademlia.Rebalance
+type Rebalancer interface {
+	// known peers to dial and connected peers to drop, the nearest
+	// neighbours are never dropped
+	Rebalance() ([]OverlayAddr, []OverlayConn)
+}
+
+// KnownPeerTable is implemented by overlays which keep the dial history of
+// their known peers, which is then saved along with their addresses, see
+// Kademlia.KnownPeers
+type KnownPeerTable interface {
+	KnownPeers() []*KnownPeer
+	RegisterKnown([]*KnownPeer) error
+}
+
+// Pruner is implemented by overlays which prune their overfull bins with a
+// loop of their own, the hive starts and stops it, see Kademlia.Start
+type Pruner interface {
+	Start()
+	Stop()
+}
+
+// errRebalanced is the reason of dropping peers from overfull bins
+var errRebalanced = errors.New("dropped to rebalance the overlay bins")
+
 // HiveParams holds the config options to hive
 type HiveParams struct {
 	Discovery             bool  // if want discovery of not
 	PeersBroadcastSetSize uint8 // how many peers to use when relaying
 	MaxPeersPerRequest    uint8 // max size for peer address batches
 	KeepAliveInterval     time.Duration
+	RebalanceInterval     time.Duration // interval of rebalancing the bins of the overlay, 0 disables it
+	Relay                 bool          // forward connection requests to peers behind NAT, see connectRequestMsg
+	RelayAfter            int           // failed dials of a peer after which a connection request is relayed, 0 disables it
+	SaveInterval          time.Duration // interval of saving the known peers to the state store, 0 saves them only on stop
+	ProbeTimeout          time.Duration // timeout of the quality probes of peers contending for a full bin, 0 disables them
+	Prober                Prober        `toml:"-"` // quality probe of the contending peers, the round trip time of a ping if nil
 }
 
 // NewHiveParams returns hive config with only the
@@ -72,6 +108,9 @@ func NewHiveParams() *HiveParams {
 		PeersBroadcastSetSize: 3,
 		MaxPeersPerRequest:    5,
 		KeepAliveInterval:     500 * time.Millisecond,
+		RebalanceInterval:     10 * time.Minute,
+		SaveInterval:          5 * time.Minute,
+		RelayAfter:            defaultRelayAfter,
 	}
 }
 
@@ -84,6 +123,11 @@ type Hive struct {
 	// bookkeeping
 	lock   sync.Mutex
 	ticker *time.Ticker
+	quitC  chan struct{}
+	savedC chan struct{}          // closed when the periodic saving of the peers stops
+	dials  map[string]*relayDials // failed dials of peers, see RelayAfter
+
+	connectRequests map[string]*relayWindow // connection requests by peer, see connectRequestMsg
 }
 
 // NewHive constructs a new hive
@@ -117,6 +161,17 @@ func (h *Hive) Start(server *p2p.Server) error {
 	h.ticker = time.NewTicker(h.KeepAliveInterval)
 	// this loop is doing bootstrapping and maintains a healthy table
 	go h.connect()
+	h.quitC = make(chan struct{})
+	if r, ok := h.Overlay.(Rebalancer); ok && h.RebalanceInterval > 0 {
+		go h.rebalance(r)
+	}
+	if p, ok := h.Overlay.(Pruner); ok {
+		p.Start()
+	}
+	if h.Store != nil && h.SaveInterval > 0 {
+		h.savedC = make(chan struct{})
+		go h.save()
+	}
 	return nil
 }
 
@@ -124,6 +179,13 @@ func (h *Hive) Start(server *p2p.Server) error {
 func (h *Hive) Stop() error {
 	log.Info(fmt.Sprintf("%08x hive stopping, saving peers", h.BaseAddr()[:4]))
 	h.ticker.Stop()
+	close(h.quitC)
+	if p, ok := h.Overlay.(Pruner); ok {
+		p.Stop()
+	}
+	if h.savedC != nil {
+		<-h.savedC
+	}
 	if h.Store != nil {
 		if err := h.savePeers(); err != nil {
 			return fmt.Errorf("could not save peers to persistence store: %v", err)
@@ -158,20 +220,92 @@ func (h *Hive) connect() {
 		}
 
 		log.Trace(fmt.Sprintf("%08x hive connect() suggested %08x", h.BaseAddr()[:4], addr.Address()[:4]))
-		under, err := discover.ParseNode(string(addr.(Addr).Under()))
-		if err != nil {
-			log.Warn(fmt.Sprintf("%08x unable to connect to bee %08x: invalid node URL: %v", h.BaseAddr()[:4], addr.Address()[:4], err))
-			continue
+		h.dial(addr)
+		// a peer suggested again was not connected by the previous dials,
+		// it may be behind NAT
+		if h.dialed(addr) {
+			h.requestConnection(addr)
+		}
+	}
+}
+
+// dial connects to the peer with the given address
+func (h *Hive) dial(addr OverlayAddr) {
+	under, err := discover.ParseNode(string(addr.(Addr).Under()))
+	if err != nil {
+		log.Warn(fmt.Sprintf("%08x unable to connect to bee %08x: invalid node URL: %v", h.BaseAddr()[:4], addr.Address()[:4], err))
+		return
+	}
+	log.Trace(fmt.Sprintf("%08x attempt to connect to bee %08x", h.BaseAddr()[:4], addr.Address()[:4]))
+	h.addPeer(under)
+}
+
+// rebalance is a loop which periodically dials peers for the underpopulated
+// bins of the overlay and drops the excess peers of its overfull bins, to
+// counter the drift of the connections towards the deep bins over long
+// uptimes that the connect loop does not correct
+func (h *Hive) rebalance(r Rebalancer) {
+	ticker := time.NewTicker(h.RebalanceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.quitC:
+			return
+		}
+		dial, drop := r.Rebalance()
+		if len(dial) > 0 || len(drop) > 0 {
+			log.Debug(fmt.Sprintf("%08x hive rebalancing: dialing %d peers, dropping %d peers", h.BaseAddr()[:4], len(dial), len(drop)))
+		}
+		for _, addr := range dial {
+			h.dial(addr)
+		}
+		for _, p := range drop {
+			log.Trace(fmt.Sprintf("%08x hive rebalancing: dropping peer %08x", h.BaseAddr()[:4], p.Address()[:4]))
+			p.Drop(errRebalanced)
+		}
+	}
+}
+
+// save saves the known peers to the state store periodically, so that they
+// are not lost if the node is not stopped cleanly
+func (h *Hive) save() {
+	defer close(h.savedC)
+	ticker := time.NewTicker(h.SaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-h.quitC:
+			return
+		}
+		if err := h.savePeers(); err != nil {
+			log.Warn(fmt.Sprintf("%08x hive could not save peers", h.BaseAddr()[:4]), "err", err)
 		}
-		log.Trace(fmt.Sprintf("%08x attempt to connect to bee %08x", h.BaseAddr()[:4], addr.Address()[:4]))
-		h.addPeer(under)
 	}
 }
 
 // Run protocol run function
 func (h *Hive) Run(p *BzzPeer) error {
 	dp := newDiscovery(p, h)
+	// a peer contending for a full bin is probed before it is admitted, its
+	// messages are handled meanwhile to answer the probe
+	var errc chan error
+	if h.ProbeTimeout > 0 {
+		errc = make(chan error, 1)
+		go func() {
+			errc <- dp.Run(dp.HandleMsg)
+		}()
+	}
+	rival, err := h.contest(dp)
+	if err != nil {
+		return err
+	}
 	depth, changed := h.On(dp)
+	if rival != nil {
+		rival.Drop(errOutcompeted)
+	}
+	h.connected(dp)
 	// if we want discovery, advertise change of depth
 	if h.Discovery {
 		if changed {
@@ -184,6 +318,9 @@ func (h *Hive) Run(p *BzzPeer) error {
 	}
 	NotifyPeer(p.Off(), h)
 	defer h.Off(dp)
+	if errc != nil {
+		return <-errc
+	}
 	return dp.Run(dp.HandleMsg)
 }
 
@@ -217,10 +354,107 @@ func ToAddr(pa OverlayPeer) *BzzAddr {
 	return pa.(*BzzPeer).BzzAddr
 }
 
+// PeerRecord is the serialisable record of a known peer with its dial
+// history, as saved to the state store and in snapshots of the known peers,
+// see ExportPeers
+type PeerRecord struct {
+	Addr       *BzzAddr  `json:"addr"`
+	SeenAt     time.Time `json:"seenAt"`
+	Retries    int       `json:"retries"`
+	Reputation float64   `json:"reputation,omitempty"`
+}
+
+// PeerRecords returns the records of the known peers of the overlay, with
+// their dial history if the overlay is a KnownPeerTable
+func (h *Hive) PeerRecords() (records []*PeerRecord) {
+	if t, ok := h.Overlay.(KnownPeerTable); ok {
+		for _, kp := range t.KnownPeers() {
+			records = append(records, &PeerRecord{Addr: ToAddr(kp.Addr), SeenAt: kp.SeenAt, Retries: kp.Retries, Reputation: kp.Reputation})
+		}
+		return records
+	}
+	h.Overlay.EachAddr(nil, 256, func(pa OverlayAddr, i int, _ bool) bool {
+		if pa == nil {
+			log.Warn(fmt.Sprintf("empty addr: %v", i))
+			return true
+		}
+		records = append(records, &PeerRecord{Addr: ToAddr(pa)})
+		return true
+	})
+	return records
+}
+
+// RegisterPeerRecords registers the known peers of the records with the
+// overlay, the records of the node itself are skipped. It returns the number
+// of records registered.
+func (h *Hive) RegisterPeerRecords(records []*PeerRecord) (int, error) {
+	var known []*KnownPeer
+	for _, r := range records {
+		if r == nil || r.Addr == nil || bytes.Equal(r.Addr.Address(), h.BaseAddr()) {
+			continue
+		}
+		known = append(known, &KnownPeer{Addr: r.Addr, SeenAt: r.SeenAt, Retries: r.Retries, Reputation: r.Reputation})
+	}
+	if len(known) == 0 {
+		return 0, nil
+	}
+	if t, ok := h.Overlay.(KnownPeerTable); ok {
+		return len(known), t.RegisterKnown(known)
+	}
+	addrs := make([]OverlayAddr, len(known))
+	for i, kp := range known {
+		addrs[i] = kp.Addr
+	}
+	return len(known), h.Register(addrs)
+}
+
+// ExportPeers writes a snapshot of the known peers with their dial history
+// to w as JSON (nodes.json), it returns the number of peers written
+func (h *Hive) ExportPeers(w io.Writer) (int, error) {
+	records := h.PeerRecords()
+	if records == nil {
+		records = []*PeerRecord{}
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+// ImportPeers registers the known peers of a snapshot written by
+// ExportPeers, the peers already known keep their dial history. It returns
+// the number of peers imported.
+func (h *Hive) ImportPeers(r io.Reader) (int, error) {
+	var records []*PeerRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return 0, fmt.Errorf("invalid peers snapshot: %v", err)
+	}
+	return h.RegisterPeerRecords(records)
+}
+
 // loadPeers, savePeer implement persistence callback/
+// the peers saved with their dial history under "known-peers" are loaded,
+// or the bare addresses saved under "peers" by earlier versions
 func (h *Hive) loadPeers() error {
+	var records []*PeerRecord
+	err := h.Store.Get("known-peers", &records)
+	if err == nil {
+		n, err := h.RegisterPeerRecords(records)
+		if err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("hive %08x: %d peers loaded", h.BaseAddr()[:4], n))
+		return nil
+	}
+	if err != state.ErrNotFound {
+		return err
+	}
 	var as []*BzzAddr
-	err := h.Store.Get("peers", &as)
+	err = h.Store.Get("peers", &as)
 	if err != nil {
 		if err == state.ErrNotFound {
 			log.Info(fmt.Sprintf("hive %08x: no persisted peers found", h.BaseAddr()[:4]))
@@ -243,19 +477,13 @@ func toOverlayAddrs(as ...*BzzAddr) (oas []OverlayAddr) {
 
 // savePeers, savePeer implement persistence callback/
 func (h *Hive) savePeers() error {
-	var peers []*BzzAddr
-	h.Overlay.EachAddr(nil, 256, func(pa OverlayAddr, i int, _ bool) bool {
-		if pa == nil {
-			log.Warn(fmt.Sprintf("empty addr: %v", i))
-			return true
-		}
-		apa := ToAddr(pa)
-		log.Trace("saving peer", "peer", apa)
-		peers = append(peers, apa)
-		return true
-	})
-	if err := h.Store.Put("peers", peers); err != nil {
+	records := h.PeerRecords()
+	log.Trace(fmt.Sprintf("hive %08x: saving %d peers", h.BaseAddr()[:4], len(records)))
+	if err := h.Store.Put("known-peers", records); err != nil {
 		return fmt.Errorf("could not save peers: %v", err)
 	}
+	if err := h.Store.Delete("peers"); err != nil && err != state.ErrNotFound {
+		return fmt.Errorf("could not delete peers saved by an earlier version: %v", err)
+	}
 	return nil
 }
@@ -17,10 +17,12 @@
 package network
 
 import (
+	"bytes"
 	"io/ioutil"
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
 	"github.com/ethereum/go-ethereum/swarm/state"
@@ -106,3 +108,75 @@ func TestHiveStatePersistance(t *testing.T) {
 		t.Fatalf("invalid peers loaded")
 	}
 }
+
+// TestHivePeerHistory checks that the known peers are saved and exported
+// with their dial history, and restored with it
+func TestHivePeerHistory(t *testing.T) {
+	store := state.NewInmemoryStore()
+	base := RandomAddr()
+	k := NewKademlia(base.OAddr, NewKadParams())
+	h := NewHive(NewHiveParams(), k, store)
+
+	seenAt := time.Unix(1500000000, 0).UTC()
+	peers := make(map[string]*KnownPeer)
+	var known []*KnownPeer
+	for i := 0; i < 5; i++ {
+		kp := &KnownPeer{Addr: RandomAddr(), SeenAt: seenAt.Add(time.Duration(i) * time.Minute), Retries: i}
+		peers[kp.Addr.(*BzzAddr).String()] = kp
+		known = append(known, kp)
+	}
+	if err := k.RegisterKnown(known); err != nil {
+		t.Fatal(err)
+	}
+	check := func(k *Kademlia) {
+		t.Helper()
+		restored := k.KnownPeers()
+		if len(restored) != len(peers) {
+			t.Fatalf("expected %d known peers, got %d", len(peers), len(restored))
+		}
+		for _, kp := range restored {
+			exp, ok := peers[ToAddr(kp.Addr).String()]
+			if !ok {
+				t.Fatalf("unexpected peer %v", kp.Addr)
+			}
+			if !kp.SeenAt.Equal(exp.SeenAt) || kp.Retries != exp.Retries {
+				t.Fatalf("expected peer seen at %v with %d retries, got %v with %d", exp.SeenAt, exp.Retries, kp.SeenAt, kp.Retries)
+			}
+		}
+	}
+
+	// the history is kept across a restart
+	if err := h.savePeers(); err != nil {
+		t.Fatal(err)
+	}
+	k1 := NewKademlia(base.OAddr, NewKadParams())
+	if err := NewHive(NewHiveParams(), k1, store).loadPeers(); err != nil {
+		t.Fatal(err)
+	}
+	check(k1)
+
+	// and in a snapshot, the node itself is not imported
+	var buf bytes.Buffer
+	if n, err := h.ExportPeers(&buf); err != nil || n != len(peers) {
+		t.Fatalf("expected %d peers exported, got %d (%v)", len(peers), n, err)
+	}
+	k2 := NewKademlia(known[0].Addr.Address(), NewKadParams())
+	if n, err := NewHive(NewHiveParams(), k2, nil).ImportPeers(&buf); err != nil || n != len(peers)-1 {
+		t.Fatalf("expected %d peers imported, got %d (%v)", len(peers)-1, n, err)
+	}
+	delete(peers, known[0].Addr.(*BzzAddr).String())
+	check(k2)
+
+	// peers saved without their history by earlier versions are loaded
+	store = state.NewInmemoryStore()
+	if err := store.Put("peers", []*BzzAddr{known[1].Addr.(*BzzAddr)}); err != nil {
+		t.Fatal(err)
+	}
+	k3 := NewKademlia(base.OAddr, NewKadParams())
+	if err := NewHive(NewHiveParams(), k3, store).loadPeers(); err != nil {
+		t.Fatal(err)
+	}
+	if restored := k3.KnownPeers(); len(restored) != 1 || restored[0].Retries != 0 {
+		t.Fatalf("expected the legacy peer to be loaded, got %v", restored)
+	}
+}
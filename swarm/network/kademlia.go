@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -61,34 +62,54 @@ type KadParams struct {
 	RetryInterval  int64 // initial interval before a peer is first redialed
 	RetryExponent  int   // exponent to multiply retry intervals with
 	MaxRetries     int   // maximum number of redial attempts
+	// bootnodes are the re-entry points of the node, they are redialed at a
+	// steady interval rather than given up after MaxRetries
+	Bootnodes             [][]byte // overlay addresses of the bootnodes
+	BootnodeRetryInterval int64    // interval between the redials of a bootnode
+	BootnodeMaxRetries    int      // maximum number of redial attempts of a bootnode, 0 for no limit
 	// function to sanction or prevent suggesting a peer
 	Reachable func(OverlayAddr) bool
+	// function scoring the peers to dial, SuggestPeer tries the highest score
+	// first, see DefaultDialScore
+	DialScore func(*DialCandidate) float64
+	// latency of a delivery which halves its credit to the reputation of the
+	// peer, latencies are not taken into account if 0, see Report
+	ReputationLatency time.Duration
+	// interval of pruning the overfull bins by the table itself, disabled if
+	// 0, see Start
+	PruneInterval time.Duration
 }
 
 // NewKadParams returns a params struct with default values
 func NewKadParams() *KadParams {
 	return &KadParams{
-		MaxProxDisplay: 16,
-		MinProxBinSize: 2,
-		MinBinSize:     2,
-		MaxBinSize:     4,
-		RetryInterval:  4200000000, // 4.2 sec
-		MaxRetries:     42,
-		RetryExponent:  2,
+		MaxProxDisplay:        16,
+		MinProxBinSize:        2,
+		MinBinSize:            2,
+		MaxBinSize:            4,
+		RetryInterval:         4200000000, // 4.2 sec
+		MaxRetries:            42,
+		RetryExponent:         2,
+		BootnodeRetryInterval: 30000000000, // 30 sec
+		DialScore:             DefaultDialScore,
+		ReputationLatency:     time.Second,
 	}
 }
 
 // Kademlia is a table of live peers and a db of known peers (node records)
 type Kademlia struct {
 	lock       sync.RWMutex
-	*KadParams          // Kademlia configuration parameters
-	base       []byte   // immutable baseaddress of the table
-	addrs      *pot.Pot // pots container for known peer addresses
-	conns      *pot.Pot // pots container for live peer connections
-	depth      uint8    // stores the last current depth of saturation
-	nDepth     int      // stores the last neighbourhood depth
-	nDepthC    chan int // returned by DepthC function to signal neighbourhood depth change
-	addrCountC chan int // returned by AddrCountC function to signal peer count change
+	*KadParams             // Kademlia configuration parameters
+	base       []byte      // immutable baseaddress of the table
+	addrs      *pot.Pot    // pots container for known peer addresses
+	conns      *pot.Pot    // pots container for live peer connections
+	depth      uint8       // stores the last current depth of saturation
+	nDepth     int         // stores the last neighbourhood depth
+	nDepthC    chan int    // returned by DepthC function to signal neighbourhood depth change
+	addrCountC chan int    // returned by AddrCountC function to signal peer count change
+	offs       []time.Time // times of the recent disconnections, see HealthScore
+	events     kadEvents   // notifications of the changes delivered outside of the lock
+	pruner     *pruner     // the pruning loop if started, see Start
 }
 
 // NewKademlia creates a Kademlia table for base address addr
@@ -128,8 +149,10 @@ type OverlayAddr interface {
 // entry represents a Kademlia table entry (an extension of OverlayPeer)
 type entry struct {
 	OverlayPeer
-	seenAt  time.Time
-	retries int
+	seenAt     time.Time
+	retries    int
+	attempts   int     // dials it took to connect the peer, see excess
+	reputation float64 // see Report
 }
 
 // newEntry creates a kademlia peer from an OverlayPeer interface
@@ -172,13 +195,34 @@ func (e *entry) conn() OverlayConn {
 	return c
 }
 
+// KnownPeer is a known peer address with its dial history, see
+// Kademlia.KnownPeers
+type KnownPeer struct {
+	Addr       OverlayAddr
+	SeenAt     time.Time // when the peer was last seen
+	Retries    int       // number of dials of the peer since it was last seen
+	Reputation float64   // see Kademlia.Report
+}
+
 // Register enters each OverlayAddr as kademlia peer record into the
 // database of known peer addresses
 func (k *Kademlia) Register(peers []OverlayAddr) error {
+	known := make([]*KnownPeer, len(peers))
+	for i, p := range peers {
+		known[i] = &KnownPeer{Addr: p}
+	}
+	return k.RegisterKnown(known)
+}
+
+// RegisterKnown enters known peers into the database of known peer
+// addresses like Register, the peers which are not yet known keep their dial
+// history, so that a table restored from a snapshot dials the same peers
+func (k *Kademlia) RegisterKnown(peers []*KnownPeer) error {
 	k.lock.Lock()
 	defer k.lock.Unlock()
 	var known, size int
-	for _, p := range peers {
+	for _, kp := range peers {
+		p := kp.Addr
 		// error if self received, peer should know better
 		// and should be punished for this
 		if bytes.Equal(p.Address(), k.base) {
@@ -189,7 +233,13 @@ func (k *Kademlia) Register(peers []OverlayAddr) error {
 			// if not found
 			if v == nil {
 				// insert new offline peer into conns
-				return newEntry(p)
+				e := newEntry(p)
+				if !kp.SeenAt.IsZero() {
+					e.seenAt = kp.SeenAt
+				}
+				e.retries = kp.Retries
+				e.reputation = kp.Reputation
+				return e
 			}
 			// found among known peers, do nothing
 			return v
@@ -201,7 +251,7 @@ func (k *Kademlia) Register(peers []OverlayAddr) error {
 	}
 	// send new address count value only if there are new addresses
 	if k.addrCountC != nil && size-known > 0 {
-		k.addrCountC <- k.addrs.Size()
+		k.notify(k.addrCountC, k.addrs.Size())
 	}
 	// log.Trace(fmt.Sprintf("%x registered %v peers, %v known, total: %v", k.BaseAddr()[:4], size, known, k.addrs.Size()))
 
@@ -209,9 +259,10 @@ func (k *Kademlia) Register(peers []OverlayAddr) error {
 	return nil
 }
 
-// SuggestPeer returns a known peer for the lowest proximity bin for the
-// lowest bincount below depth
-// naturally if there is an empty row it returns a peer for that
+// SuggestPeer returns a known peer to dial for the bins below depth lacking
+// peers, the one with the highest DialScore, so that deep bins are not
+// starved by shallow ones
+// if there is no such peer, it requests peers for the lowest short bin
 func (k *Kademlia) SuggestPeer() (a OverlayAddr, o int, want bool) {
 	k.lock.Lock()
 	defer k.lock.Unlock()
@@ -235,8 +286,10 @@ func (k *Kademlia) SuggestPeer() (a OverlayAddr, o int, want bool) {
 	// log.Trace(fmt.Sprintf("%08x no candidate nearest neighbours to connect to (Depth: %v, minProxSize: %v) %#v", k.BaseAddr()[:4], depth, k.MinProxBinSize, a))
 
 	var bpo []int
+	sizes := make(map[int]int)
 	prev := -1
 	k.conns.EachBin(k.base, pof, 0, func(po, size int, f func(func(val pot.Val, i int) bool) bool) bool {
+		sizes[po] = size
 		prev++
 		for ; prev < po; prev++ {
 			bpo = append(bpo, prev)
@@ -255,22 +308,45 @@ func (k *Kademlia) SuggestPeer() (a OverlayAddr, o int, want bool) {
 	}
 	// as long as we got candidate peers to connect to
 	// dont ask for new peers (want = false)
-	// try to select a candidate peer
-	// find the first callable peer
+	// queue the candidate peers of the bins from the lowest short bin up
+	// until depth by score and find the best callable one
 	nxt := bpo[0]
+	score := k.DialScore
+	if score == nil {
+		score = DefaultDialScore
+	}
+	now := time.Now()
+	queue := &dialQueue{}
 	k.addrs.EachBin(k.base, pof, nxt, func(po, _ int, f func(func(pot.Val, int) bool) bool) bool {
-		// for each bin (up until depth) we find callable candidate peers
 		if po >= depth {
 			return false
 		}
+		deficit := k.MinBinSize - sizes[po]
+		if deficit < 0 {
+			deficit = 0
+		}
 		return f(func(val pot.Val, _ int) bool {
-			a = k.callable(val)
-			return a == nil
+			e := val.(*entry)
+			if e.conn() != nil {
+				return true
+			}
+			queue.push(e, score(&DialCandidate{
+				Address:    e.addr(),
+				PO:         po,
+				Deficit:    deficit,
+				SeenAgo:    now.Sub(e.seenAt),
+				Retries:    e.retries,
+				Reputation: e.reputation,
+			}))
+			return true
 		})
 	})
-	// found a candidate
-	if a != nil {
-		return a, 0, false
+	for queue.Len() > 0 {
+		a = k.callable(queue.pop())
+		// found a candidate
+		if a != nil {
+			return a, 0, false
+		}
 	}
 	// no candidate peer found, request for the short bin
 	var changed bool
@@ -298,16 +374,22 @@ func (k *Kademlia) On(p OverlayConn) (uint8, bool) {
 		return v
 	})
 	if ins {
-		// insert new online peer into addrs
+		// insert new online peer into addrs, keeping its reputation and the
+		// number of dials it took
 		k.addrs, _, _, _ = pot.Swap(k.addrs, p, pof, func(v pot.Val) pot.Val {
+			if v != nil {
+				e.reputation = v.(*entry).reputation
+				e.attempts = v.(*entry).retries
+			}
 			return e
 		})
 		// send new address count value only if the peer is inserted
 		if k.addrCountC != nil {
-			k.addrCountC <- k.addrs.Size()
+			k.notify(k.addrCountC, k.addrs.Size())
 		}
 	}
 	log.Trace(k.string())
+	k.notifyHealth()
 	// calculate if depth of saturation changed
 	depth := uint8(k.saturation(k.MinBinSize))
 	var changed bool
@@ -321,37 +403,47 @@ func (k *Kademlia) On(p OverlayConn) (uint8, bool) {
 
 // NeighbourhoodDepthC returns the channel that sends a new kademlia
 // neighbourhood depth on each change.
-// Not receiving from the returned channel will block On function
-// when the neighbourhood depth is changed.
+// The changes are delivered outside of the table lock and coalesced, a
+// receiver which has not received a depth yet receives the latest one only.
 func (k *Kademlia) NeighbourhoodDepthC() <-chan int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
 	if k.nDepthC == nil {
-		k.nDepthC = make(chan int)
+		k.nDepthC = make(chan int, 1)
 	}
 	return k.nDepthC
 }
 
-// sendNeighbourhoodDepthChange sends new neighbourhood depth to k.nDepth channel
-// if it is initialized.
+// sendNeighbourhoodDepthChange queues the new neighbourhood depth for the
+// k.nDepth channel if it is initialized and for the subscriptions of
+// SubscribeDepthChange, see notify.
 func (k *Kademlia) sendNeighbourhoodDepthChange() {
 	// nDepthC is initialized when NeighbourhoodDepthC is called and returned by it.
-	// It provides signaling of neighbourhood depth change.
-	// This part of the code is sending new neighbourhood depth to nDepthC if that condition is met.
-	if k.nDepthC != nil {
-		nDepth := k.neighbourhoodDepth()
-		if nDepth != k.nDepth {
-			k.nDepth = nDepth
-			k.nDepthC <- nDepth
+	// It provides signaling of neighbourhood depth change, as do the
+	// subscriptions of SubscribeDepthChange.
+	// This part of the code is queueing new neighbourhood depth if that condition is met.
+	if k.nDepthC == nil && !k.depthSubscribed() {
+		return
+	}
+	nDepth := k.neighbourhoodDepth()
+	if nDepth != k.nDepth {
+		k.nDepth = nDepth
+		if k.nDepthC != nil {
+			k.notify(k.nDepthC, nDepth)
 		}
+		k.notifyDepth(nDepth)
 	}
 }
 
 // AddrCountC returns the channel that sends a new
 // address count value on each change.
-// Not receiving from the returned channel will block Register function
-// when address count value changes.
+// The changes are delivered and coalesced like the ones of
+// NeighbourhoodDepthC.
 func (k *Kademlia) AddrCountC() <-chan int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
 	if k.addrCountC == nil {
-		k.addrCountC = make(chan int)
+		k.addrCountC = make(chan int, 1)
 	}
 	return k.addrCountC
 }
@@ -367,7 +459,9 @@ func (k *Kademlia) Off(p OverlayConn) {
 			panic(fmt.Sprintf("connected peer not found %v", p))
 		}
 		del = true
-		return newEntry(p.Off())
+		e := newEntry(p.Off())
+		e.reputation = v.(*entry).reputation
+		return e
 	})
 
 	if del {
@@ -377,8 +471,17 @@ func (k *Kademlia) Off(p OverlayConn) {
 		})
 		// send new address count value only if the peer is deleted
 		if k.addrCountC != nil {
-			k.addrCountC <- k.addrs.Size()
+			k.notify(k.addrCountC, k.addrs.Size())
 		}
+		now := time.Now()
+		k.offs = append(recentOffs(k.offs, now), now)
+		// the bootnodes are the way back into the network
+		if k.conns.Size() == 0 {
+			if n := k.resetRetries(false); n > 0 {
+				log.Debug(fmt.Sprintf("%08x: last peer disconnected, reset retries of %d bootnodes", k.BaseAddr()[:4], n))
+			}
+		}
+		k.notifyHealth()
 		k.sendNeighbourhoodDepthChange()
 	}
 }
@@ -475,17 +578,33 @@ func (k *Kademlia) neighbourhoodDepth() (depth int) {
 // callable when called with val,
 func (k *Kademlia) callable(val pot.Val) OverlayAddr {
 	e := val.(*entry)
-	// not callable if peer is live or exceeded maxRetries
-	if e.conn() != nil || e.retries > k.MaxRetries {
+	if e.conn() != nil {
 		return nil
 	}
-	// calculate the allowed number of retries based on time lapsed since last seen
 	timeAgo := int64(time.Since(e.seenAt))
-	div := int64(k.RetryExponent)
-	div += (150000 - rand.Int63n(300000)) * div / 1000000
 	var retries int
-	for delta := timeAgo; delta > k.RetryInterval; delta /= div {
-		retries++
+	if k.isBootnode(e) {
+		// not callable if bootnode exceeded its own maxRetries
+		if k.BootnodeMaxRetries > 0 && e.retries > k.BootnodeMaxRetries {
+			return nil
+		}
+		// bootnodes are retried once per interval since last seen
+		if k.BootnodeRetryInterval > 0 {
+			retries = int(timeAgo / k.BootnodeRetryInterval)
+		} else {
+			retries = e.retries
+		}
+	} else {
+		// not callable if peer exceeded maxRetries
+		if e.retries > k.MaxRetries {
+			return nil
+		}
+		// calculate the allowed number of retries based on time lapsed since last seen
+		div := int64(k.RetryExponent)
+		div += (150000 - rand.Int63n(300000)) * div / 1000000
+		for delta := timeAgo; delta > k.RetryInterval; delta /= div {
+			retries++
+		}
 	}
 	// this is never called concurrently, so safe to increment
 	// peer can be retried again
@@ -504,6 +623,49 @@ func (k *Kademlia) callable(val pot.Val) OverlayAddr {
 	return e.addr()
 }
 
+// isBootnode returns true if the peer is one of the bootnodes
+func (k *Kademlia) isBootnode(p OverlayPeer) bool {
+	for _, b := range k.Bootnodes {
+		if bytes.Equal(b, p.Address()) {
+			return true
+		}
+	}
+	return false
+}
+
+// KnownPeers returns the known peer addresses with their dial history
+func (k *Kademlia) KnownPeers() (peers []*KnownPeer) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	k.addrs.Each(func(val pot.Val, _ int) bool {
+		e := val.(*entry)
+		peers = append(peers, &KnownPeer{Addr: e.addr(), SeenAt: e.seenAt, Retries: e.retries, Reputation: e.reputation})
+		return true
+	})
+	return peers
+}
+
+// ResetRetries resets the redial attempts of the known bootnodes, or of all
+// known peers if all is set, so that they are callable again. It returns the
+// number of peers reset.
+func (k *Kademlia) ResetRetries(all bool) int {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.resetRetries(all)
+}
+
+func (k *Kademlia) resetRetries(all bool) (n int) {
+	k.addrs.Each(func(val pot.Val, _ int) bool {
+		e := val.(*entry)
+		if e.retries > 0 && (all || k.isBootnode(e)) {
+			e.retries = 0
+			n++
+		}
+		return true
+	})
+	return n
+}
+
 // BaseAddr return the kademlia base address
 func (k *Kademlia) BaseAddr() []byte {
 	return k.base
@@ -653,6 +815,126 @@ func (k *Kademlia) saturation(n int) int {
 	return prev
 }
 
+// Saturation summarises how well the bins of the kademlia table are filled
+type Saturation struct {
+	Depth       int   `json:"depth"`       // neighbourhood depth
+	Connected   int   `json:"connected"`   // number of connected peers
+	Known       int   `json:"known"`       // number of known peer addresses
+	MinBinSize  int   `json:"minBinSize"`  // number of peers expected in each bin shallower than the depth
+	Bins        []int `json:"bins"`        // number of connected peers in the bins shallower than the depth
+	Unsaturated []int `json:"unsaturated"` // proximity orders of the bins with less than MinBinSize peers
+}
+
+// Saturation returns the number of connected peers in the bins shallower
+// than the neighbourhood depth compared to the minimum bin size
+func (k *Kademlia) Saturation() *Saturation {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	depth := k.neighbourhoodDepth()
+	s := &Saturation{
+		Depth:       depth,
+		Connected:   k.conns.Size(),
+		Known:       k.addrs.Size(),
+		MinBinSize:  k.MinBinSize,
+		Bins:        make([]int, depth),
+		Unsaturated: []int{},
+	}
+	k.conns.EachBin(k.base, pof, 0, func(po, size int, f func(func(val pot.Val, i int) bool) bool) bool {
+		if po >= depth {
+			return false
+		}
+		s.Bins[po] = size
+		return true
+	})
+	for po, size := range s.Bins {
+		if size < k.MinBinSize {
+			s.Unsaturated = append(s.Unsaturated, po)
+		}
+	}
+	return s
+}
+
+// Rebalance returns known peers to dial for the bins shallower than the
+// neighbourhood depth with less than MinBinSize connected peers, and the
+// connected peers to drop from the bins shallower than the depth with more
+// than MaxBinSize peers. The peers of a bin with the lowest reputation are the
+// ones dropped, the most recently connected first among peers of the same
+// reputation.
+//
+// Bins at or beyond the depth are exempt, their connections are never
+// released even beyond MaxBinSize: they hold the nearest neighbours, which
+// the node must stay connected to in order to serve its neighbourhood, and
+// their number is bounded by the depth adjusting to the population anyway.
+//
+// Unlike SuggestPeer, which suggests a single peer for the shallowest
+// unsaturated bin, all bins are considered at once.
+func (k *Kademlia) Rebalance() (dial []OverlayAddr, drop []OverlayConn) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	for po, conns := range k.shallowBins() {
+		if missing := k.MinBinSize - len(conns); missing > 0 {
+			k.addrs.EachBin(k.base, pof, po, func(bpo, _ int, f func(func(val pot.Val, i int) bool) bool) bool {
+				if bpo != po {
+					return false
+				}
+				f(func(val pot.Val, _ int) bool {
+					if a := k.callable(val); a != nil {
+						dial = append(dial, a)
+						missing--
+					}
+					return missing > 0
+				})
+				return false
+			})
+		}
+		drop = append(drop, k.excess(conns)...)
+	}
+	return dial, drop
+}
+
+// shallowBins returns the connected peers of the bins shallower than the
+// neighbourhood depth, indexed by proximity order, caller must hold the lock
+func (k *Kademlia) shallowBins() [][]*entry {
+	depth := k.neighbourhoodDepth()
+	if depth == 0 {
+		return nil
+	}
+	bins := make([][]*entry, depth)
+	k.conns.EachBin(k.base, pof, 0, func(po, _ int, f func(func(val pot.Val, i int) bool) bool) bool {
+		if po >= depth {
+			return false
+		}
+		f(func(val pot.Val, _ int) bool {
+			bins[po] = append(bins[po], val.(*entry))
+			return true
+		})
+		return true
+	})
+	return bins
+}
+
+// excess returns the peers of a bin beyond MaxBinSize, the ones of the
+// lowest reputation, among them the ones which took the most dials to
+// connect and then the most recently connected
+func (k *Kademlia) excess(conns []*entry) (drop []OverlayConn) {
+	if k.MaxBinSize <= 0 || len(conns) <= k.MaxBinSize {
+		return nil
+	}
+	sort.Slice(conns, func(i, j int) bool {
+		if conns[i].reputation != conns[j].reputation {
+			return conns[i].reputation < conns[j].reputation
+		}
+		if conns[i].attempts != conns[j].attempts {
+			return conns[i].attempts > conns[j].attempts
+		}
+		return conns[i].seenAt.After(conns[j].seenAt)
+	})
+	for _, e := range conns[:len(conns)-k.MaxBinSize] {
+		drop = append(drop, e.conn())
+	}
+	return drop
+}
+
 // full returns true if all required bins have connected peers.
 // It is used in Healthy function.
 func (k *Kademlia) full(emptyBins []int) (full bool) {
@@ -746,6 +1028,8 @@ type Health struct {
 
 // Healthy reports the health state of the kademlia connectivity
 // returns a Health struct
+// The expected nearest neighbours and empty bins are only known in tests,
+// live nodes are diagnosed with HealthInfo.
 func (k *Kademlia) Healthy(pp *PeerPot) *Health {
 	k.lock.RLock()
 	defer k.lock.RUnlock()
@@ -19,6 +19,7 @@ package network
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"os"
 	"testing"
 	"time"
@@ -348,6 +349,128 @@ func TestSuggestPeerRetries(t *testing.T) {
 
 }
 
+// TestSuggestPeerDialScore checks that the candidate peers are suggested
+// by score, the peers of the bins lacking the most peers first with the
+// default score, and by the score set in the params otherwise
+func TestSuggestPeerDialScore(t *testing.T) {
+	newKad := func() *testKademlia {
+		k := newTestKademlia("00000000")
+		k.MinBinSize = 2
+		k.On("10000000", "11000000", "01000000", "00010000", "00011000")
+		k.Register("10100000", "01100000", "00100000")
+		return k
+	}
+	suggest := func(k *testKademlia, expAddrs ...string) {
+		t.Helper()
+		for _, expAddr := range expAddrs {
+			addr, _, _ := k.SuggestPeer()
+			if binStr(addr) != expAddr {
+				t.Fatalf("incorrect peer address suggested. expected %v, got %v", expAddr, binStr(addr))
+			}
+		}
+	}
+
+	// the empty bin 2 lacks the most peers, then bin 1, bin 0 is full
+	suggest(newKad(), "00100000", "01100000")
+
+	k := newKad()
+	k.DialScore = func(c *DialCandidate) float64 {
+		return -float64(c.PO)
+	}
+	suggest(k, "01100000", "00100000")
+}
+
+// TestKademliaNotifications checks that the latest depth and address count
+// are delivered, without blocking the table nor each other while they are
+// not received
+func TestKademliaNotifications(t *testing.T) {
+	k := newTestKademlia("00000000")
+	depthC := k.NeighbourhoodDepthC()
+	addrCountC := k.AddrCountC()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		k.Register("10000000", "01000000")
+		k.On("10000000", "01000000", "00100000")
+		k.Off("00100000")
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the table to be updated")
+	}
+
+	// the receivers may use the table meanwhile, and the address count is
+	// delivered while the depth is not received
+	receive := func(c <-chan int, expected int) {
+		t.Helper()
+		_ = k.String()
+		var received []int
+		for len(received) == 0 || received[len(received)-1] != expected {
+			select {
+			case v := <-c:
+				received = append(received, v)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for %d, got %v", expected, received)
+			}
+		}
+		select {
+		case v := <-c:
+			t.Fatalf("unexpected value %d after %v", v, received)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	receive(addrCountC, 3)
+	receive(depthC, 0)
+}
+
+// TestKademliaSubscribeDepthChange checks that the subscriptions receive the
+// latest depth only, and are closed when cancelled
+func TestKademliaSubscribeDepthChange(t *testing.T) {
+	k := newTestKademlia("00000000")
+	depthC, cancel := k.SubscribeDepthChange()
+	depthC2, cancel2 := k.SubscribeDepthChange()
+	defer cancel2()
+
+	k.On("10000000", "01000000", "00100000")
+	k.Off("00100000")
+	k.On("00100000", "00010000")
+
+	receive := func(c <-chan uint8) {
+		expected := uint8(k.neighbourhoodDepth())
+		t.Helper()
+		// earlier depths may be received while the latest changes are
+		// delivered
+		var received []uint8
+		for len(received) == 0 || received[len(received)-1] != expected {
+			select {
+			case depth := <-c:
+				received = append(received, depth)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("timed out waiting for depth %d, got %v", expected, received)
+			}
+		}
+		select {
+		case depth, ok := <-c:
+			if ok {
+				t.Fatalf("unexpected depth %d", depth)
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	receive(depthC)
+	receive(depthC2)
+
+	cancel()
+	cancel()
+	if _, ok := <-depthC; ok {
+		t.Fatal("expected the cancelled subscription to be closed")
+	}
+	k.Off("00100000", "00010000")
+	receive(depthC2)
+}
+
 func TestKademliaHiveString(t *testing.T) {
 	k := newTestKademlia("00000000").On("01000000", "00100000").Register("10000000", "10000001")
 	k.MaxProxDisplay = 8
@@ -358,6 +481,294 @@ func TestKademliaHiveString(t *testing.T) {
 	}
 }
 
+func TestKademliaSaturation(t *testing.T) {
+	k := newTestKademlia("00000000").On("10000000", "01000000", "00010000", "00011000").Register("00100000")
+	s := k.Saturation()
+	if s.Depth != 3 || s.Connected != 4 || s.Known != 5 || s.MinBinSize != 1 {
+		t.Fatalf("unexpected saturation %+v", s)
+	}
+	if fmt.Sprint(s.Bins) != "[1 1 0]" || fmt.Sprint(s.Unsaturated) != "[2]" {
+		t.Fatalf("expected bins [1 1 0] with bin 2 unsaturated, got %v and %v", s.Bins, s.Unsaturated)
+	}
+}
+
+func TestKademliaHealthScore(t *testing.T) {
+	k := newTestKademlia("00000000")
+	if h := k.HealthScore(); h.Score != 0 {
+		t.Fatalf("expected score 0 without peers, got %+v", h)
+	}
+	// bins 0 and 1 of 0, 1 and 2 are filled, 2 of 3 known nearest neighbours
+	// are connected and no peer disconnected
+	k.On("10000000", "01000000", "00010000", "00011000").Register("00100000", "00010001")
+	if h := k.HealthScore(); h.Score != 73 || h.Churn != 0 {
+		t.Fatalf("expected score 73, got %+v", h)
+	}
+	// one of three peers disconnected and bin 1 is empty
+	k.Off("01000000")
+	if h := k.HealthScore(); h.Score != 50 {
+		t.Fatalf("expected score 50, got %+v", h)
+	}
+}
+
+// TestKademliaHealthInfo checks the diagnosis of the connectivity, with the
+// nearest neighbours derived from the known peers
+func TestKademliaHealthInfo(t *testing.T) {
+	k := newTestKademlia("00000000")
+	k.On("10000000", "00010000", "00011000").Register("01000000", "00010001")
+	h := k.HealthInfo()
+	if h.Depth != 3 || h.KnownDepth != 3 || h.Connected != 3 || h.Known != 5 {
+		t.Fatalf("unexpected health %+v", h)
+	}
+	var bins []string
+	for _, b := range h.Bins {
+		bins = append(bins, fmt.Sprintf("%d/%d %v", b.Connected, b.Known, b.Saturated))
+	}
+	if exp := "[1/1 true 0/1 false 0/0 true 2/3 true]"; fmt.Sprint(bins) != exp {
+		t.Fatalf("expected bins %s, got %v", exp, bins)
+	}
+	if len(h.MissingNN) != 1 || !bytes.Equal(h.MissingNN[0], testKadPeerAddr("00010001").Address()) {
+		t.Fatalf("expected 00010001 to be the missing nearest neighbour, got %v", h.MissingNN)
+	}
+	if fmt.Sprint(h.EmptyBins) != "[1 2]" || h.Connectivity != 0.5 || h.Healthy {
+		t.Fatalf("expected bins 1 and 2 empty, half of the bins connected and unhealthy, got %+v", h)
+	}
+
+	k.On("01000000", "00010001")
+	h = k.HealthInfo()
+	if len(h.MissingNN) != 0 || fmt.Sprint(h.EmptyBins) != "[2]" || h.Connectivity != 1 || !h.Healthy {
+		t.Fatalf("expected healthy connectivity, got %+v", h)
+	}
+}
+
+func TestKademliaRebalance(t *testing.T) {
+	k := newTestKademlia("00000000")
+	k.MaxBinSize = 2
+	k.On("10000000", "11000000", "10100000", "00010000", "00011000").Register("01000000")
+	dial, drop := k.Rebalance()
+	if len(dial) != 1 || binStr(dial[0]) != "01000000" {
+		t.Fatalf("expected to dial 01000000 for the empty bin 1, got %d peers", len(dial))
+	}
+	if len(drop) != 1 || binStr(drop[0]) != "10100000" {
+		t.Fatalf("expected to drop the last connected peer 10100000 of the overfull bin 0, got %d peers", len(drop))
+	}
+
+	// the peers in the neighbourhood are never dropped, and dialed peers
+	// are not dialed again before their retry interval
+	k.On("00010001", "00011001")
+	if dial, drop := k.Rebalance(); len(dial) != 0 || len(drop) != 1 {
+		t.Fatalf("expected to drop one peer of bin 0 only, got %d to dial and %d to drop", len(dial), len(drop))
+	}
+}
+
+// TestKademliaPrune checks that the excess peers of the overfull bins are
+// dropped, by Prune and by the pruning loop, but never the nearest neighbours
+func TestKademliaPrune(t *testing.T) {
+	k := newTestKademlia("00000000")
+	k.dropc = make(chan error, 16)
+	k.MaxBinSize = 2
+	// bin 3 is the neighbourhood, overfull but never pruned
+	k.On("10000000", "11000000", "10100000", "00010000", "00011000", "00010001")
+	expectDrop := func() {
+		t.Helper()
+		select {
+		case err := <-k.dropc:
+			derr := err.(*dropError)
+			if derr.addr != "10100000" || derr.error != errPruned {
+				t.Fatalf("expected 10100000 to be pruned, got %v dropped: %v", derr.addr, derr.error)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a peer to be pruned")
+		}
+	}
+	if n := k.Prune(); n != 1 {
+		t.Fatalf("expected 1 peer to be pruned, got %d", n)
+	}
+	expectDrop()
+
+	// the test peers are not taken off the table when dropped, so the loop
+	// prunes the same peer until it is stopped
+	k.PruneInterval = 10 * time.Millisecond
+	k.Start()
+	expectDrop()
+	k.Stop()
+	for len(k.dropc) > 0 {
+		<-k.dropc
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(k.dropc) != 0 {
+		t.Fatal("expected no peers to be pruned after stopping")
+	}
+
+	// a peer pruned from a bin which has become part of the neighbourhood
+	// since is spared
+	peer := k.newTestKadPeer("10100000").(OverlayConn)
+	if k.nearest(peer) {
+		t.Fatal("expected a peer of bin 0 not to be a nearest neighbour")
+	}
+	k.Off("00010000", "00011000", "00010001")
+	if !k.nearest(peer) {
+		t.Fatal("expected a peer of bin 0 to be a nearest neighbour once the nearer peers are gone")
+	}
+}
+
+// TestKademliaPruneAttempts checks that among the peers of equal reputation
+// the ones which took the most dials to connect are pruned first
+func TestKademliaPruneAttempts(t *testing.T) {
+	k := newTestKademlia("00000000")
+	k.dropc = make(chan error, 16)
+	k.MaxBinSize = 2
+	k.Register("10000000")
+	k.lock.Lock()
+	k.knownEntry(testKadPeerAddr("10000000").Address()).retries = 3
+	k.lock.Unlock()
+	k.On("10000000", "11000000", "10100000", "00010000", "00011000", "00010001")
+	if n := k.Prune(); n != 1 {
+		t.Fatalf("expected 1 peer to be pruned, got %d", n)
+	}
+	derr := (<-k.dropc).(*dropError)
+	if derr.addr != "10000000" {
+		t.Fatalf("expected 10000000 which took 3 retries to be pruned, got %v", derr.addr)
+	}
+}
+
+func TestKademliaRivals(t *testing.T) {
+	k := newTestKademlia("00000000")
+	k.On("10000000", "11000000", "00010000", "00011000")
+	if rivals := k.Rivals(testKadPeerAddr("10100000")); rivals != nil {
+		t.Fatalf("expected no rivals without a maximum bin size, got %d", len(rivals))
+	}
+	k.MaxBinSize = 2
+	rivals := k.Rivals(testKadPeerAddr("10100000"))
+	if len(rivals) != 2 || binStr(rivals[0]) != "10000000" && binStr(rivals[1]) != "10000000" {
+		t.Fatalf("expected the 2 peers of the full bin 0 as rivals, got %d peers", len(rivals))
+	}
+	// bins which are not full, the neighbourhood and connected peers have no rivals
+	for _, s := range []string{"01000000", "00010001", "10000000"} {
+		if rivals := k.Rivals(testKadPeerAddr(s)); rivals != nil {
+			t.Fatalf("expected no rivals of %s, got %d", s, len(rivals))
+		}
+	}
+}
+
+// TestKademliaReputation checks that the reported events feed the reputation
+// of the known peers, which is kept across reconnects, and that the peers of
+// better reputation are preferred to dial and to keep
+func TestKademliaReputation(t *testing.T) {
+	addr := func(s string) []byte {
+		return testKadPeerAddr(s).Address()
+	}
+	k := newTestKademlia("00000000")
+	k.MaxBinSize = 2
+	k.On("10000000", "11000000", "10100000", "00010000", "00011000")
+
+	// events about unknown peers are ignored
+	k.Report(addr("01000000"), PeerEvent{Type: DeliverySuccess})
+	if _, ok := k.Reputation(addr("01000000")); ok {
+		t.Fatal("expected no reputation of an unknown peer")
+	}
+
+	// slow deliveries count less than fast ones
+	k.Report(addr("10000000"), PeerEvent{Type: Misbehaviour})
+	k.Report(addr("11000000"), PeerEvent{Type: DeliverySuccess})
+	k.Report(addr("10100000"), PeerEvent{Type: DeliverySuccess, Latency: time.Second})
+	k.Report(addr("00010000"), PeerEvent{Type: DeliveryFailure})
+	for s, expected := range map[string]float64{
+		"10000000": -0.5,
+		"11000000": 0.1,
+		"10100000": 0.05,
+		"00010000": -0.1,
+		"00011000": 0,
+	} {
+		if rep, _ := k.Reputation(addr(s)); math.Abs(rep-expected) > 1e-9 {
+			t.Fatalf("expected reputation %v of %s, got %v", expected, s, rep)
+		}
+	}
+
+	// the peer of the lowest reputation is dropped from the overfull bin 0
+	// rather than the last connected one
+	if _, drop := k.Rebalance(); len(drop) != 1 || binStr(drop[0]) != "10000000" {
+		t.Fatalf("expected to drop 10000000 of the overfull bin 0, got %d peers", len(drop))
+	}
+	k.Off("10000000").On("10000000")
+	if rep, _ := k.Reputation(addr("10000000")); rep != -0.5 {
+		t.Fatalf("expected the reputation to be kept across reconnects, got %v", rep)
+	}
+	// reputations restored from a previous connection are bounded
+	if !k.SetReputation(addr("00011000"), 2) {
+		t.Fatal("expected the reputation of a known peer to be set")
+	}
+	if rep, _ := k.Reputation(addr("00011000")); rep != 1 {
+		t.Fatalf("expected the reputation to be bounded to 1, got %v", rep)
+	}
+	if k.SetReputation(addr("01000000"), 0.5) {
+		t.Fatal("expected no reputation of an unknown peer to be set")
+	}
+
+	// among the candidates of a bin, the one of better reputation is dialed first
+	for _, s := range []string{"01100000", "01010000"} {
+		k := newTestKademlia("00000000")
+		k.MinBinSize = 2
+		k.On("10000000", "11000000", "00010000", "00011000").Register("01100000", "01010000")
+		k.Report(addr(s), PeerEvent{Type: DeliverySuccess})
+		if a, _, _ := k.SuggestPeer(); binStr(a) != s {
+			t.Fatalf("expected %s to be suggested, got %v", s, binStr(a))
+		}
+	}
+}
+
+func TestKademliaBootnodeRetries(t *testing.T) {
+	k := newTestKademlia("00000000")
+	k.Bootnodes = [][]byte{testKadPeerAddr("10000000").Address()}
+	k.MaxRetries = 2
+	k.BootnodeRetryInterval = 1000000000
+	k.Register("10000000", "01000000")
+	// returns how often each known peer is callable when called n times
+	call := func(n int) map[string]int {
+		called := make(map[string]int)
+		for i := 0; i < n; i++ {
+			k.addrs.Each(func(val pot.Val, _ int) bool {
+				val.(*entry).seenAt = time.Now().Add(-time.Hour)
+				if a := k.callable(val); a != nil {
+					called[binStr(a)]++
+				}
+				return true
+			})
+		}
+		return called
+	}
+
+	// the regular peer is given up after MaxRetries, the bootnode is not
+	called := call(10)
+	if called["01000000"] != 3 || called["10000000"] != 10 {
+		t.Fatalf("expected the peer to be callable 3 times and the bootnode 10 times, got %v", called)
+	}
+	k.BootnodeMaxRetries = 5
+	if called := call(1); len(called) != 0 {
+		t.Fatalf("expected no callable peers after the bootnode exceeded its retries, got %v", called)
+	}
+
+	// the retries of the bootnodes are reset, or of all peers
+	if n := k.ResetRetries(false); n != 1 {
+		t.Fatalf("expected the retries of 1 bootnode to be reset, got %d", n)
+	}
+	if called := call(1); len(called) != 1 || called["10000000"] != 1 {
+		t.Fatalf("expected the bootnode to be callable after reset, got %v", called)
+	}
+	if n := k.ResetRetries(true); n != 2 {
+		t.Fatalf("expected the retries of 2 peers to be reset, got %d", n)
+	}
+	if called := call(1); len(called) != 2 {
+		t.Fatalf("expected both peers to be callable after reset, got %v", called)
+	}
+
+	// the retries of the bootnodes are reset when the last peer disconnects
+	call(10)
+	k.On("00100000").Off("00100000")
+	if called := call(1); called["10000000"] != 1 || called["01000000"] != 0 {
+		t.Fatalf("expected the bootnode only to be callable after the last peer disconnected, got %v", called)
+	}
+}
+
 // testKademliaCase constructs the kademlia and PeerPot map to validate
 // the SuggestPeer and Healthy methods for provided hex-encoded addresses.
 // Argument pivotAddr is the address of the kademlia.
@@ -0,0 +1,172 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import "sync"
+
+// kadEvents keeps the notifications of the changes of the kademlia table,
+// which are delivered by an event loop outside of the table lock. Delivering
+// them under the lock blocked the table while a receiver was waiting for it.
+//
+// The notifications are coalesced, only the latest value of each channel,
+// the latest depth for the subscriptions and one update of the health gauge
+// are kept until the event loop delivers them. The event loop replaces the
+// values the receivers have not received yet, so that a slow receiver
+// neither delays the others nor makes the notifications pile up.
+type kadEvents struct {
+	mu      sync.Mutex
+	values  map[chan int]int // latest values to deliver, by channel
+	depth   int              // latest depth for the subscriptions
+	pending bool             // the depth is to be delivered
+	health  bool             // an update of the health gauge is to be done
+	running bool             // the event loop is running
+
+	depthSubs map[chan uint8]struct{} // see SubscribeDepthChange
+}
+
+// SubscribeDepthChange returns a channel which receives the new neighbourhood
+// depth on each change, and the function to cancel the subscription which
+// closes the channel. The changes are coalesced, a subscriber which has not
+// received a depth yet receives the latest one only.
+func (k *Kademlia) SubscribeDepthChange() (<-chan uint8, func()) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.events.mu.Lock()
+	defer k.events.mu.Unlock()
+	// the depth is only kept track of while someone is notified of it
+	if k.nDepthC == nil && len(k.events.depthSubs) == 0 {
+		k.nDepth = k.neighbourhoodDepth()
+	}
+	if k.events.depthSubs == nil {
+		k.events.depthSubs = make(map[chan uint8]struct{})
+	}
+	c := make(chan uint8, 1)
+	k.events.depthSubs[c] = struct{}{}
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			k.events.mu.Lock()
+			defer k.events.mu.Unlock()
+			delete(k.events.depthSubs, c)
+			close(c)
+		})
+	}
+}
+
+// returns true if the neighbourhood depth has subscribers
+func (k *Kademlia) depthSubscribed() bool {
+	k.events.mu.Lock()
+	defer k.events.mu.Unlock()
+	return len(k.events.depthSubs) > 0
+}
+
+// keeps the new value of the channel c for delivery, replacing the one not
+// delivered yet
+func (k *Kademlia) notify(c chan int, value int) {
+	k.events.mu.Lock()
+	defer k.events.mu.Unlock()
+	if k.events.values == nil {
+		k.events.values = make(map[chan int]int)
+	}
+	k.events.values[c] = value
+	k.runEvents()
+}
+
+// keeps the new depth for delivery to the subscriptions
+func (k *Kademlia) notifyDepth(depth int) {
+	k.events.mu.Lock()
+	defer k.events.mu.Unlock()
+	if len(k.events.depthSubs) == 0 {
+		return
+	}
+	k.events.depth = depth
+	k.events.pending = true
+	k.runEvents()
+}
+
+// requests an update of the health gauge
+func (k *Kademlia) notifyHealth() {
+	k.events.mu.Lock()
+	defer k.events.mu.Unlock()
+	k.events.health = true
+	k.runEvents()
+}
+
+// starts the event loop unless it is running, must be called with the lock
+// of the events held
+func (k *Kademlia) runEvents() {
+	if k.events.running {
+		return
+	}
+	k.events.running = true
+	go k.eventLoop()
+}
+
+// eventLoop delivers the notifications until there are none left
+func (k *Kademlia) eventLoop() {
+	for {
+		k.events.mu.Lock()
+		values, pending, depth, health := k.events.values, k.events.pending, k.events.depth, k.events.health
+		if len(values) == 0 && !pending && !health {
+			k.events.running = false
+			k.events.mu.Unlock()
+			return
+		}
+		k.events.values = nil
+		k.events.pending = false
+		k.events.health = false
+		k.events.mu.Unlock()
+
+		for c, value := range values {
+			sendLatest(c, value)
+		}
+		if pending {
+			k.sendDepth(uint8(depth))
+		}
+		if health {
+			k.lock.RLock()
+			k.updateHealthGauge()
+			k.lock.RUnlock()
+		}
+	}
+}
+
+// sends the value on a channel with a buffer of one, replacing the value not
+// received yet. The event loop is the only sender, so that the send never
+// blocks.
+func sendLatest(c chan int, value int) {
+	select {
+	case <-c:
+	default:
+	}
+	c <- value
+}
+
+// sends the depth to the subscriptions, replacing the depth they have not
+// received yet. The event loop is the only sender, so that the sends never
+// block.
+func (k *Kademlia) sendDepth(depth uint8) {
+	k.events.mu.Lock()
+	defer k.events.mu.Unlock()
+	for c := range k.events.depthSubs {
+		select {
+		case <-c:
+		default:
+		}
+		c <- depth
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/pot"
+)
+
+/*
+Quality probes of peers contending for a full bin
+
+The bins shallower than the neighbourhood depth keep at most MaxBinSize
+peers, the excess peers are dropped by Rebalance. A peer connecting to a full
+bin is admitted anyway, only for it or another peer of the bin to be dropped
+later, whatever their quality.
+
+With ProbeTimeout set, the hive probes a peer connecting to a full bin before
+it admits it, together with the peers of the bin. By default the probe is the
+round trip time of a pingMsg, a Prober can measure a tiny retrieval instead.
+The new peer is admitted only if it is faster than the slowest peer of the
+bin, which is dropped in its place. Otherwise the new peer is dropped. Peers
+of the bin failing their probe are taken as the slowest.
+*/
+
+var (
+	// ErrProbeUnsupported is returned by Probers which cannot probe the peer,
+	// such peers do not take part in the contest for their bin
+	ErrProbeUnsupported = errors.New("peer cannot be probed")
+
+	// errOutcompeted is the reason of dropping the slower peer contending for a full bin
+	errOutcompeted = errors.New("outcompeted by a faster peer for a full bin")
+
+	probeAdmitCount  = metrics.NewRegisteredCounter("network.probe.admit", nil)
+	probeRejectCount = metrics.NewRegisteredCounter("network.probe.reject", nil)
+)
+
+// Prober measures the quality of a connected peer within the timeout, as a
+// latency which is lower for better peers
+type Prober func(p OverlayConn, timeout time.Duration) (time.Duration, error)
+
+// Contender is implemented by overlays which prune the peers of full bins,
+// see Kademlia.Rivals
+type Contender interface {
+	// connected peers the peer contends with for its bin
+	Rivals(OverlayPeer) []OverlayConn
+}
+
+// pingMsg asks the peer for a pongMsg with the same nonce, to measure the
+// round trip time of the connection
+type pingMsg struct {
+	Nonce uint64
+}
+
+// pongMsg is the answer to a pingMsg
+type pongMsg struct {
+	Nonce uint64
+}
+
+// Rivals returns the connected peers of the bin of the peer if the bin is
+// shallower than the neighbourhood depth and has MaxBinSize peers already, so
+// that admitting the peer would make one of them excess, see Rebalance.
+// Otherwise, or if the peer is connected already, it returns nil.
+func (k *Kademlia) Rivals(p OverlayPeer) []OverlayConn {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	if k.MaxBinSize <= 0 {
+		return nil
+	}
+	po, _ := pof(k.base, p, 0)
+	if po >= k.neighbourhoodDepth() {
+		return nil
+	}
+	var rivals []OverlayConn
+	var connected bool
+	k.conns.EachBin(k.base, pof, po, func(bpo, _ int, f func(func(val pot.Val, i int) bool) bool) bool {
+		if bpo != po {
+			return false
+		}
+		f(func(val pot.Val, _ int) bool {
+			c := val.(*entry).conn()
+			if _, eq := pof(c, p, 0); eq {
+				connected = true
+				return false
+			}
+			rivals = append(rivals, c)
+			return true
+		})
+		return false
+	})
+	if connected || len(rivals) < k.MaxBinSize {
+		return nil
+	}
+	return rivals
+}
+
+// contest probes the peer if it contends for a full bin, and returns the
+// slowest peer of the bin, to be dropped once the peer is admitted. It returns
+// an error if the peer failed its probe or is the slowest itself.
+func (h *Hive) contest(dp *discPeer) (OverlayConn, error) {
+	c, ok := h.Overlay.(Contender)
+	if !ok || h.ProbeTimeout <= 0 {
+		return nil, nil
+	}
+	rivals := c.Rivals(dp)
+	if len(rivals) == 0 {
+		return nil, nil
+	}
+	probe := h.Prober
+	if probe == nil {
+		probe = pingProbe
+	}
+
+	// the peer and its rivals are probed at the same time
+	peers := append([]OverlayConn{dp}, rivals...)
+	latencies := make([]time.Duration, len(peers))
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Add(1)
+		go func(i int, p OverlayConn) {
+			defer wg.Done()
+			latencies[i], errs[i] = probe(p, h.ProbeTimeout)
+		}(i, p)
+	}
+	wg.Wait()
+	if errs[0] == ErrProbeUnsupported {
+		return nil, nil
+	}
+	if errs[0] != nil {
+		probeRejectCount.Inc(1)
+		return nil, fmt.Errorf("probe failed: %v", errs[0])
+	}
+
+	var slowest OverlayConn
+	var probed bool
+	max := latencies[0]
+	for i := 1; i < len(peers); i++ {
+		if errs[i] == ErrProbeUnsupported {
+			continue
+		}
+		probed = true
+		if errs[i] != nil {
+			log.Trace(fmt.Sprintf("%08x probe of peer %08x failed: %v", h.BaseAddr()[:4], peers[i].Address()[:4], errs[i]))
+			latencies[i] = h.ProbeTimeout
+		}
+		if latencies[i] > max {
+			max, slowest = latencies[i], peers[i]
+		}
+	}
+	if !probed {
+		return nil, nil
+	}
+	if slowest == nil {
+		probeRejectCount.Inc(1)
+		log.Debug(fmt.Sprintf("%08x peer %08x is slower than the peers of its full bin (%v)", h.BaseAddr()[:4], dp.Address()[:4], latencies[0]))
+		return nil, errOutcompeted
+	}
+	probeAdmitCount.Inc(1)
+	log.Debug(fmt.Sprintf("%08x peer %08x (%v) outcompetes %08x (%v) for its full bin", h.BaseAddr()[:4], dp.Address()[:4], latencies[0], slowest.Address()[:4], max))
+	return slowest, nil
+}
+
+// pingProbe is the default Prober, measuring the round trip time of a ping
+func pingProbe(p OverlayConn, timeout time.Duration) (time.Duration, error) {
+	dp, ok := p.(*discPeer)
+	if !ok {
+		return 0, ErrProbeUnsupported
+	}
+	return dp.ping(timeout)
+}
+
+// ping returns the round trip time of a pingMsg to the peer
+func (d *discPeer) ping(timeout time.Duration) (time.Duration, error) {
+	nonce := rand.Uint64()
+	pong := make(chan struct{})
+	d.mtx.Lock()
+	if d.pings == nil {
+		d.pings = make(map[uint64]chan struct{})
+	}
+	d.pings[nonce] = pong
+	d.mtx.Unlock()
+	defer func() {
+		d.mtx.Lock()
+		delete(d.pings, nonce)
+		d.mtx.Unlock()
+	}()
+
+	start := time.Now()
+	if err := d.Send(&pingMsg{Nonce: nonce}); err != nil {
+		return 0, err
+	}
+	select {
+	case <-pong:
+		return time.Since(start), nil
+	case <-time.After(timeout):
+		return 0, fmt.Errorf("no pong within %v", timeout)
+	}
+}
+
+func (d *discPeer) handlePingMsg(msg *pingMsg) error {
+	go d.Send(&pongMsg{Nonce: msg.Nonce})
+	return nil
+}
+
+// handlePongMsg ends the ping with the nonce, pongs of no ping are ignored
+func (d *discPeer) handlePongMsg(msg *pongMsg) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if pong, ok := d.pings[msg.Nonce]; ok {
+		close(pong)
+		delete(d.pings, msg.Nonce)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+)
+
+func TestHiveContest(t *testing.T) {
+	k := newTestKademlia("00000000")
+	k.MaxBinSize = 2
+	k.On("10000000", "11000000", "00010000", "00011000")
+	h := NewHive(NewHiveParams(), k.Kademlia, nil)
+
+	var mu sync.Mutex
+	latencies := map[string]time.Duration{
+		"10000000": 30 * time.Millisecond,
+		"11000000": 20 * time.Millisecond,
+	}
+	errs := make(map[string]error)
+	var probed []string
+	h.Prober = func(p OverlayConn, timeout time.Duration) (time.Duration, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		probed = append(probed, binStr(p))
+		return latencies[binStr(p)], errs[binStr(p)]
+	}
+	contest := func(s string, latency time.Duration) (OverlayConn, error) {
+		mu.Lock()
+		latencies[s] = latency
+		probed = nil
+		mu.Unlock()
+		return h.contest(newDiscovery(&BzzPeer{BzzAddr: testKadPeerAddr(s)}, h))
+	}
+
+	// probes are disabled by default
+	if rival, err := contest("10100000", 10*time.Millisecond); rival != nil || err != nil || len(probed) != 0 {
+		t.Fatalf("expected no probes without a probe timeout, got %v, %v and %d probes", rival, err, len(probed))
+	}
+	h.ProbeTimeout = time.Second
+
+	// a faster peer outcompetes the slowest peer of the full bin
+	rival, err := contest("10100000", 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rival == nil || binStr(rival) != "10000000" || len(probed) != 3 {
+		t.Fatalf("expected 3 probes and 10000000 to be outcompeted, got %v and %d probes", rival, len(probed))
+	}
+
+	// a slower peer is not admitted
+	if _, err := contest("10110000", 40*time.Millisecond); err != errOutcompeted {
+		t.Fatalf("expected the slower peer to be outcompeted, got %v", err)
+	}
+
+	// peers failing their probe are the slowest
+	errs["11000000"] = errors.New("no pong")
+	if rival, err := contest("10111000", 35*time.Millisecond); err != nil || rival == nil || binStr(rival) != "11000000" {
+		t.Fatalf("expected 11000000 failing its probe to be outcompeted, got %v, %v", rival, err)
+	}
+	errs["10111100"] = errors.New("no pong")
+	if _, err := contest("10111100", 0); err == nil {
+		t.Fatal("expected a peer failing its probe not to be admitted")
+	}
+
+	// peers in bins which are not full are not probed
+	if rival, err := contest("01000000", 50*time.Millisecond); rival != nil || err != nil || len(probed) != 0 {
+		t.Fatalf("expected no probes for a bin which is not full, got %v, %v and %d probes", rival, err, len(probed))
+	}
+}
+
+func TestDiscPeerPing(t *testing.T) {
+	local := RandomAddr()
+	h := NewHive(NewHiveParams(), NewKademlia(local.Over(), NewKadParams()), nil)
+	dp, rw := newRelayTestPeer(h, local, RandomAddr())
+	defer rw.Close()
+	go dp.Run(dp.HandleMsg)
+	pingCode, _ := DiscoverySpec.GetCode(&pingMsg{})
+	pongCode, _ := DiscoverySpec.GetCode(&pongMsg{})
+
+	// pings are answered with a pong of the same nonce
+	if err := p2p.Send(rw, pingCode, &pingMsg{Nonce: 42}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2p.ExpectMsg(rw, pongCode, &pongMsg{Nonce: 42}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the round trip time of a ping is measured until its pong
+	go func() {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return
+		}
+		var ping pingMsg
+		if err := msg.Decode(&ping); err != nil {
+			return
+		}
+		p2p.Send(rw, pongCode, &pongMsg{Nonce: ping.Nonce + 1})
+		p2p.Send(rw, pongCode, &pongMsg{Nonce: ping.Nonce})
+	}()
+	if _, err := dp.ping(time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// pings without pong time out
+	go func() {
+		if msg, err := rw.ReadMsg(); err == nil {
+			msg.Discard()
+		}
+	}()
+	if _, err := dp.ping(100 * time.Millisecond); err == nil {
+		t.Fatal("expected the ping without pong to time out")
+	}
+}
@@ -54,11 +54,14 @@ var BzzSpec = &protocols.Spec{
 // DiscoverySpec is the spec for the bzz discovery subprotocols
 var DiscoverySpec = &protocols.Spec{
 	Name:       "hive",
-	Version:    3,
+	Version:    5,
 	MaxMsgSize: 10 * 1024 * 1024,
 	Messages: []interface{}{
 		peersMsg{},
 		subPeersMsg{},
+		connectRequestMsg{},
+		pingMsg{},
+		pongMsg{},
 	},
 }
 
@@ -317,7 +320,7 @@ func (b *Bzz) checkHandshake(hs interface{}) error {
 	if rhs.Version != uint64(BzzSpec.Version) {
 		return fmt.Errorf("version mismatch %d (!= %d)", rhs.Version, BzzSpec.Version)
 	}
-	return nil
+	return checkAddr(rhs.Addr, b.BaseAddr())
 }
 
 // removeHandshake removes handshake for peer with peerID
@@ -386,6 +389,19 @@ func (a *BzzAddr) String() string {
 	return fmt.Sprintf("%x <%s>", a.OAddr, a.UAddr)
 }
 
+// checkAddr returns an error unless the address received from a peer has an
+// overlay address of the length of the base address, others cannot be put
+// into or looked up in the kademlia table
+func checkAddr(a *BzzAddr, base []byte) error {
+	if a == nil {
+		return errors.New("missing peer address")
+	}
+	if len(a.OAddr) != len(base) {
+		return fmt.Errorf("invalid overlay address %x of %d bytes (!= %d)", a.OAddr, len(a.OAddr), len(base))
+	}
+	return nil
+}
+
 // RandomAddr is a utility method generating an address from a public key
 func RandomAddr() *BzzAddr {
 	key, err := crypto.GenerateKey()
@@ -224,6 +224,24 @@ func TestBzzHandshakeVersionMismatch(t *testing.T) {
 	}
 }
 
+// TestBzzHandshakeInvalidAddr checks that peers advertising overlay addresses
+// which do not fit the table are disconnected in the handshake
+func TestBzzHandshakeInvalidAddr(t *testing.T) {
+	addr := RandomAddr()
+	s := newBzzHandshakeTester(t, 1, addr)
+	id := s.IDs[0]
+
+	err := s.testHandshake(
+		correctBzzHandshake(addr),
+		&HandshakeMsg{Version: 3, NetworkID: 3, Addr: &BzzAddr{OAddr: []byte{1}, UAddr: NewAddrFromNodeID(id).UAddr}},
+		&p2ptest.Disconnect{Peer: id, Error: fmt.Errorf("Handshake error: Message handler error: (msg code 0): invalid overlay address 01 of 1 bytes (!= 32)")},
+	)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestBzzHandshakeSuccess(t *testing.T) {
 	addr := RandomAddr()
 	s := newBzzHandshakeTester(t, 1, addr)
@@ -0,0 +1,119 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// errPruned is the reason of dropping the peers pruned from overfull bins
+var errPruned = errors.New("pruned from an overfull kademlia bin")
+
+// pruner is the pruning loop of a table, see Kademlia.Start
+type pruner struct {
+	quitC chan struct{}
+	doneC chan struct{} // closed when the loop returns
+}
+
+// Prune drops the connected peers of the bins shallower than the
+// neighbourhood depth beyond MaxBinSize, the ones Rebalance returns to drop,
+// and returns the number of peers dropped.
+//
+// The nearest neighbours are never pruned, the bins at or beyond the depth
+// are left alone and the peers dropped from the shallower bins cannot make
+// the depth any shallower. Since the peers are dropped outside of the lock,
+// a peer which has become a nearest neighbour in the meantime, because
+// nearer peers disconnected, is spared.
+func (k *Kademlia) Prune() int {
+	k.lock.RLock()
+	var drop []OverlayConn
+	for _, conns := range k.shallowBins() {
+		drop = append(drop, k.excess(conns)...)
+	}
+	k.lock.RUnlock()
+	// the peers are dropped outside of the lock, since dropping a peer takes
+	// it off the table
+	var n int
+	for _, p := range drop {
+		if k.nearest(p) {
+			log.Trace(fmt.Sprintf("%08x: not pruning nearest neighbour %08x", k.BaseAddr()[:4], p.Address()[:4]))
+			continue
+		}
+		log.Trace(fmt.Sprintf("%08x: pruning peer %08x", k.BaseAddr()[:4], p.Address()[:4]))
+		p.Drop(errPruned)
+		n++
+	}
+	return n
+}
+
+// nearest returns true if the peer is within the current neighbourhood depth
+func (k *Kademlia) nearest(p OverlayConn) bool {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	po, _ := pof(k.base, p, 0)
+	return po >= k.neighbourhoodDepth()
+}
+
+// Start starts pruning the overfull bins of the table every PruneInterval,
+// unless the interval is 0 or the table is pruning already. The hive starts
+// and stops the tables which implement Pruner with itself.
+func (k *Kademlia) Start() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if k.PruneInterval <= 0 || k.pruner != nil {
+		return
+	}
+	k.pruner = &pruner{
+		quitC: make(chan struct{}),
+		doneC: make(chan struct{}),
+	}
+	go k.prune(k.pruner, k.PruneInterval)
+}
+
+// Stop stops pruning the table and waits for the pruning loop to return
+func (k *Kademlia) Stop() {
+	k.lock.Lock()
+	p := k.pruner
+	k.pruner = nil
+	k.lock.Unlock()
+	if p == nil {
+		return
+	}
+	close(p.quitC)
+	<-p.doneC
+}
+
+// prune is the pruning loop started by Start
+func (k *Kademlia) prune(p *pruner, interval time.Duration) {
+	defer close(p.doneC)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.quitC:
+			return
+		}
+		if n := k.Prune(); n > 0 {
+			log.Debug(fmt.Sprintf("%08x: pruned %d peers from overfull bins", k.BaseAddr()[:4], n))
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/pot"
+)
+
+/*
+Relayed connection setup for peers behind NAT
+
+A node behind a NAT (including symmetric NATs) can dial out, but cannot be
+dialed. Its address record still spreads over the overlay, so other nodes
+keep trying in vain to connect to it, and its bins are populated only by
+the peers it dials itself.
+
+When a node fails to dial a peer RelayAfter times, it sends a connectRequestMsg
+to its connected peers closest to the target. Nodes with Relay enabled, which
+are typically publicly reachable, forward the request towards the target
+along their connections. A NATed node stays connected to its neighbours, so
+the request reaches it, and it dials the requester back. Once connected, the
+peer serves chunks like any other.
+
+This requires the requester to be reachable. Two nodes both behind NAT cannot
+connect to each other this way, and keep relying on the routing of the
+overlay to exchange chunks.
+
+A request sent by the requester itself must name the sending peer as the
+requester, otherwise the peer is dropped. Forwarded requests cannot be checked
+this way, so each peer may send at most maxConnectRequests requests per
+connectRequestWindow, the others are ignored.
+*/
+
+const (
+	// maximum number of times a connection request is forwarded
+	maxRelayHops = 3
+	// default number of failed dials after which connection requests are relayed
+	defaultRelayAfter = 3
+	// connection requests handled per peer within connectRequestWindow
+	maxConnectRequests   = 10
+	connectRequestWindow = time.Minute
+	// failed dials are forgotten if the address was not dialed for this long
+	relayDialExpiry = time.Hour
+	// maximum number of peers whose failed dials or requests are counted
+	maxRelayEntries = 1024
+)
+
+var (
+	relayRequestCount  = metrics.NewRegisteredCounter("network.relay.request", nil)
+	relayForwardCount  = metrics.NewRegisteredCounter("network.relay.forward", nil)
+	relayDialBackCount = metrics.NewRegisteredCounter("network.relay.dialback", nil)
+	relayLimitCount    = metrics.NewRegisteredCounter("network.relay.limit", nil)
+)
+
+// the failed dials of an address, see Hive.dialed
+type relayDials struct {
+	count int
+	last  time.Time
+}
+
+// the connection requests of a peer in the current window
+type relayWindow struct {
+	start time.Time
+	count int
+}
+
+// connectRequestMsg asks the node with the Target overlay address to dial the
+// requester, it is forwarded by relaying peers
+type connectRequestMsg struct {
+	Target    []byte
+	Requester *BzzAddr
+	Hops      uint8
+}
+
+// String pretty prints a connectRequestMsg
+func (msg connectRequestMsg) String() string {
+	return fmt.Sprintf("%T: %x requests connection from %x (hops %d)", msg, msg.Requester.Address(), msg.Target, msg.Hops)
+}
+
+// relayer is implemented by overlays handling connection requests, see Hive
+type relayer interface {
+	handleConnectRequest(from *discPeer, msg *connectRequestMsg) error
+}
+
+func (d *discPeer) handleConnectRequestMsg(msg *connectRequestMsg) error {
+	base := d.overlay.BaseAddr()
+	if len(msg.Target) != len(base) || checkAddr(msg.Requester, base) != nil {
+		return fmt.Errorf("invalid connection request from %x", d.Address())
+	}
+	// requests which are not forwarded come from the requester
+	if msg.Hops == 0 && (!bytes.Equal(msg.Requester.Over(), d.Over()) || !bytes.Equal(msg.Requester.Under(), d.Under())) {
+		return fmt.Errorf("connection request from %x for another requester %x", d.Address(), msg.Requester.Address())
+	}
+	if r, ok := d.overlay.(relayer); ok {
+		return r.handleConnectRequest(d, msg)
+	}
+	return nil
+}
+
+// handleConnectRequest dials the requester if this node is the target of the
+// request, otherwise relaying nodes forward it to their connected peer
+// closest to the target
+func (h *Hive) handleConnectRequest(from *discPeer, msg *connectRequestMsg) error {
+	if !h.allowConnectRequest(from) {
+		relayLimitCount.Inc(1)
+		log.Trace(fmt.Sprintf("%08x ignoring connection request from %08x over the limit", h.BaseAddr()[:4], from.Address()[:4]))
+		return nil
+	}
+	if bytes.Equal(msg.Target, h.BaseAddr()) {
+		log.Debug(fmt.Sprintf("%08x dialing back %08x on relayed request", h.BaseAddr()[:4], msg.Requester.Address()[:4]))
+		relayDialBackCount.Inc(1)
+		h.dial(msg.Requester)
+		return nil
+	}
+	if !h.Relay || msg.Hops >= maxRelayHops {
+		return nil
+	}
+	fwd := &connectRequestMsg{
+		Target:    msg.Target,
+		Requester: msg.Requester,
+		Hops:      msg.Hops + 1,
+	}
+	h.EachConn(msg.Target, 256, func(p OverlayConn, _ int, _ bool) bool {
+		dp, ok := p.(*discPeer)
+		if !ok || bytes.Equal(dp.Address(), from.Address()) || bytes.Equal(dp.Address(), msg.Requester.Address()) {
+			return true
+		}
+		// only forward towards the target
+		if pot.ProxCmp(msg.Target, dp.Address(), h.BaseAddr()) != -1 {
+			return false
+		}
+		log.Trace(fmt.Sprintf("%08x forwarding connection request for %08x to %08x", h.BaseAddr()[:4], msg.Target[:4], dp.Address()[:4]))
+		relayForwardCount.Inc(1)
+		go dp.Send(fwd)
+		return false
+	})
+	return nil
+}
+
+// requestConnection asks the connected peers closest to the target to relay
+// a request to dial this node
+func (h *Hive) requestConnection(target OverlayAddr) {
+	var sent uint8
+	h.EachConn(target.Address(), 256, func(p OverlayConn, _ int, _ bool) bool {
+		dp, ok := p.(*discPeer)
+		if !ok {
+			return true
+		}
+		msg := &connectRequestMsg{
+			Target:    target.Address(),
+			Requester: dp.localAddr,
+		}
+		go dp.Send(msg)
+		sent++
+		return sent < h.PeersBroadcastSetSize
+	})
+	if sent > 0 {
+		log.Debug(fmt.Sprintf("%08x requested connection from %08x through %d peers", h.BaseAddr()[:4], target.Address()[:4], sent))
+		relayRequestCount.Inc(1)
+	}
+}
+
+// counts a connection request of the peer, and returns false if it sent
+// more than maxConnectRequests within connectRequestWindow
+func (h *Hive) allowConnectRequest(from OverlayPeer) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.connectRequests == nil {
+		h.connectRequests = make(map[string]*relayWindow)
+	}
+	now := time.Now()
+	k := string(from.Address())
+	w, ok := h.connectRequests[k]
+	if !ok || now.Sub(w.start) >= connectRequestWindow {
+		if !ok && len(h.connectRequests) >= maxRelayEntries {
+			for addr, w := range h.connectRequests {
+				if now.Sub(w.start) >= connectRequestWindow {
+					delete(h.connectRequests, addr)
+				}
+			}
+			if len(h.connectRequests) >= maxRelayEntries {
+				return false
+			}
+		}
+		w = &relayWindow{start: now}
+		h.connectRequests[k] = w
+	}
+	w.count++
+	return w.count <= maxConnectRequests
+}
+
+// records a dial of the address, and returns true if the previous RelayAfter
+// dials failed and a connection request is to be relayed to it
+//
+// At most maxRelayEntries addresses are counted, those not dialed within
+// relayDialExpiry are forgotten to make room for others.
+func (h *Hive) dialed(addr OverlayAddr) bool {
+	if h.RelayAfter <= 0 {
+		return false
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.dials == nil {
+		h.dials = make(map[string]*relayDials)
+	}
+	now := time.Now()
+	k := string(addr.Address())
+	d, ok := h.dials[k]
+	if !ok {
+		if len(h.dials) >= maxRelayEntries {
+			for addr, d := range h.dials {
+				if now.Sub(d.last) >= relayDialExpiry {
+					delete(h.dials, addr)
+				}
+			}
+			if len(h.dials) >= maxRelayEntries {
+				return false
+			}
+		}
+		d = &relayDials{}
+		h.dials[k] = d
+	} else if now.Sub(d.last) >= relayDialExpiry {
+		d.count = 0
+	}
+	d.count++
+	d.last = now
+	return d.count > h.RelayAfter
+}
+
+// forgets the failed dials of a connected peer
+func (h *Hive) connected(p OverlayPeer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.dials, string(p.Address()))
+}
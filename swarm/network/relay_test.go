@@ -0,0 +1,190 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/pot"
+)
+
+// connects a discovery peer with the given address to the hive, the messages
+// sent to it are read from the returned pipe
+func newRelayTestPeer(h *Hive, local *BzzAddr, addr *BzzAddr) (*discPeer, *p2p.MsgPipeRW) {
+	rw, remote := p2p.MsgPipe()
+	p := protocols.NewPeer(p2p.NewPeer(NewNodeIDFromAddr(addr), "", nil), rw, DiscoverySpec)
+	dp := newDiscovery(&BzzPeer{Peer: p, localAddr: local, BzzAddr: addr}, h)
+	h.On(dp)
+	return dp, remote
+}
+
+// expects no message to be sent on the pipe
+func expectNoMsg(t *testing.T, rw *p2p.MsgPipeRW) {
+	c := make(chan p2p.Msg, 1)
+	go func() {
+		if msg, err := rw.ReadMsg(); err == nil {
+			c <- msg
+		}
+	}()
+	select {
+	case msg := <-c:
+		t.Fatalf("unexpected message %v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRelayConnectRequest(t *testing.T) {
+	local := RandomAddr()
+	params := NewHiveParams()
+	params.Relay = true
+	h := NewHive(params, NewKademlia(local.Over(), NewKadParams()), nil)
+	dialed := make(chan *discover.Node, 1)
+	h.addPeer = func(n *discover.Node) {
+		dialed <- n
+	}
+
+	requester, requesterRW := newRelayTestPeer(h, local, RandomAddr())
+	defer requesterRW.Close()
+	target, targetRW := newRelayTestPeer(h, local, RandomAddr())
+	defer targetRW.Close()
+
+	// the request is forwarded to the target
+	msg := &connectRequestMsg{
+		Target:    target.Address(),
+		Requester: requester.BzzAddr,
+	}
+	if err := requester.HandleMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2p.ExpectMsg(targetRW, 2, &connectRequestMsg{Target: msg.Target, Requester: msg.Requester, Hops: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the request is dropped after the maximum number of hops
+	msg.Hops = maxRelayHops
+	if err := requester.HandleMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	expectNoMsg(t, targetRW)
+
+	// the target dials the requester of a forwarded request
+	msg = &connectRequestMsg{
+		Target:    local.Over(),
+		Requester: RandomAddr(),
+		Hops:      1,
+	}
+	if err := requester.HandleMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-dialed:
+		if n.String() != string(msg.Requester.Under()) {
+			t.Fatalf("expected to dial %s, got %s", msg.Requester.Under(), n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the target to dial the requester")
+	}
+
+	// requests which are not forwarded must come from the requester
+	msg.Hops = 0
+	if err := requester.HandleMsg(msg); err == nil {
+		t.Fatal("expected request for another requester to fail")
+	}
+}
+
+func TestRelayConnectRequestLimit(t *testing.T) {
+	local := RandomAddr()
+	params := NewHiveParams()
+	params.Relay = true
+	h := NewHive(params, NewKademlia(local.Over(), NewKadParams()), nil)
+
+	requester, requesterRW := newRelayTestPeer(h, local, RandomAddr())
+	defer requesterRW.Close()
+	target, targetRW := newRelayTestPeer(h, local, RandomAddr())
+	defer targetRW.Close()
+
+	msg := &connectRequestMsg{
+		Target:    target.Address(),
+		Requester: requester.BzzAddr,
+	}
+	for i := 0; i < maxConnectRequests; i++ {
+		if err := requester.HandleMsg(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := p2p.ExpectMsg(targetRW, 2, &connectRequestMsg{Target: msg.Target, Requester: msg.Requester, Hops: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// requests over the limit are ignored
+	if err := requester.HandleMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	expectNoMsg(t, targetRW)
+}
+
+func TestRelayRequestConnection(t *testing.T) {
+	local := RandomAddr()
+	params := NewHiveParams()
+	params.PeersBroadcastSetSize = 1
+	params.RelayAfter = 2
+	h := NewHive(params, NewKademlia(local.Over(), NewKadParams()), nil)
+
+	a, aRW := newRelayTestPeer(h, local, RandomAddr())
+	defer aRW.Close()
+	b, bRW := newRelayTestPeer(h, local, RandomAddr())
+	defer bRW.Close()
+
+	// the connection request is sent after RelayAfter failed dials
+	// the target is at different proximity orders from the peers, the order
+	// of the peers in the same bin is not by distance
+	target := RandomAddr()
+	for {
+		apo, _ := pof(target.Over(), a.Address(), 0)
+		bpo, _ := pof(target.Over(), b.Address(), 0)
+		if apo != bpo {
+			break
+		}
+		target = RandomAddr()
+	}
+	for i := 0; i < params.RelayAfter; i++ {
+		if h.dialed(target) {
+			t.Fatalf("expected no connection request after %d dials", i+1)
+		}
+	}
+	if !h.dialed(target) {
+		t.Fatalf("expected connection request after %d failed dials", params.RelayAfter)
+	}
+	h.connected(target)
+	if h.dialed(target) {
+		t.Fatal("expected the failed dials to be reset on connection")
+	}
+
+	// the request is sent to the connected peer closest to the target
+	closest, closestRW, otherRW := a, aRW, bRW
+	if pot.ProxCmp(target.Over(), b.Address(), a.Address()) == -1 {
+		closest, closestRW, otherRW = b, bRW, aRW
+	}
+	h.requestConnection(target)
+	if err := p2p.ExpectMsg(closestRW, 2, &connectRequestMsg{Target: target.Over(), Requester: local}); err != nil {
+		t.Fatalf("expected request to closest peer %x: %v", closest.Address(), err)
+	}
+	expectNoMsg(t, otherRW)
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/pot"
+)
+
+// PeerEventType is the kind of an event reported about a peer
+type PeerEventType int
+
+const (
+	// DeliverySuccess is reported when the peer delivered a requested chunk
+	DeliverySuccess PeerEventType = iota
+	// DeliveryFailure is reported when the peer did not deliver a requested
+	// chunk in time
+	DeliveryFailure
+	// Misbehaviour is reported when the peer violated the protocol, such as
+	// delivering an invalid chunk
+	Misbehaviour
+)
+
+func (t PeerEventType) String() string {
+	switch t {
+	case DeliverySuccess:
+		return "delivery-success"
+	case DeliveryFailure:
+		return "delivery-failure"
+	case Misbehaviour:
+		return "misbehaviour"
+	}
+	return fmt.Sprintf("PeerEventType(%d)", int(t))
+}
+
+// PeerEvent is an event reported about a peer, which feeds the reputation of
+// the peer, see Kademlia.Report
+type PeerEvent struct {
+	Type    PeerEventType
+	Latency time.Duration // time the peer took to deliver, 0 if not measured
+}
+
+// Reporter is implemented by overlays which keep the reputation of the peers
+// from the events reported by the streaming and retrieval layers
+type Reporter interface {
+	Report(addr []byte, ev PeerEvent)
+}
+
+// the impact of a single event on the reputation of a peer, misbehaviour
+// weighs as much as several failed deliveries
+var reputationImpact = map[PeerEventType]float64{
+	DeliverySuccess: 0.1,
+	DeliveryFailure: 0.1,
+	Misbehaviour:    0.5,
+}
+
+// Report feeds an event into the reputation of the known peer with the
+// overlay address addr. Events about unknown peers are ignored.
+//
+// The reputation is a moving average between -1 and 1 of the events, a
+// successful delivery counts 1, less the slower it is, a failed delivery or
+// misbehaviour -1. Known peers start with a reputation of 0.
+func (k *Kademlia) Report(addr []byte, ev PeerEvent) {
+	impact, ok := reputationImpact[ev.Type]
+	if !ok {
+		log.Warn(fmt.Sprintf("%08x: unknown event %v reported about peer %x", k.BaseAddr()[:4], ev.Type, addr))
+		return
+	}
+	sample := -1.0
+	if ev.Type == DeliverySuccess {
+		sample = 1
+		if k.ReputationLatency > 0 && ev.Latency > 0 {
+			sample /= 1 + float64(ev.Latency)/float64(k.ReputationLatency)
+		}
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	e := k.knownEntry(addr)
+	if e == nil {
+		return
+	}
+	e.reputation = (1-impact)*e.reputation + impact*sample
+	log.Trace(fmt.Sprintf("%08x: %v reported about peer %v, reputation %.3f", k.BaseAddr()[:4], ev.Type, e, e.reputation))
+}
+
+// Reputation returns the reputation of the known peer with the overlay
+// address addr, see Report, and false if the peer is not known
+func (k *Kademlia) Reputation(addr []byte) (float64, bool) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	e := k.knownEntry(addr)
+	if e == nil {
+		return 0, false
+	}
+	return e.reputation, true
+}
+
+// SetReputation sets the reputation of the known peer with the overlay
+// address addr, kept from a previous connection, and returns false if the
+// peer is not known
+func (k *Kademlia) SetReputation(addr []byte, reputation float64) bool {
+	if reputation > 1 {
+		reputation = 1
+	} else if reputation < -1 {
+		reputation = -1
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	e := k.knownEntry(addr)
+	if e == nil {
+		return false
+	}
+	e.reputation = reputation
+	return true
+}
+
+// knownEntry returns the entry of the known peer with the overlay address
+// addr, nil if the peer is not known, caller must hold the lock
+func (k *Kademlia) knownEntry(addr []byte) (e *entry) {
+	k.addrs.EachNeighbour(addr, pof, func(val pot.Val, _ int) bool {
+		if _, eq := pof(val, addr, 0); eq {
+			e = val.(*entry)
+		}
+		return false
+	})
+	return e
+}
@@ -33,6 +33,7 @@ import (
 	"github.com/ethereum/go-ethereum/p2p/simulations"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
 	"github.com/ethereum/go-ethereum/swarm/network"
+	streamTesting "github.com/ethereum/go-ethereum/swarm/network/stream/testing"
 	"github.com/ethereum/go-ethereum/swarm/state"
 	colorable "github.com/mattn/go-colorable"
 )
@@ -41,6 +42,7 @@ var (
 	noDiscovery = flag.Bool("no-discovery", false, "disable discovery (useful if you want to load a snapshot)")
 	vmodule     = flag.String("vmodule", "", "log filters for logger via Vmodule")
 	verbosity   = flag.Int("verbosity", 0, "log filters for logger via Vmodule")
+	corpus      = flag.String("corpus", "", "directory to record the bzz and hive messages into as fuzzing corpus")
 	httpSimPort = 8888
 )
 
@@ -60,6 +62,11 @@ func init() {
 		glogger.Vmodule(*vmodule)
 		log.Root().SetHandler(glogger)
 	}
+	if *corpus != "" {
+		if err := streamTesting.RecordCorpus(*corpus, network.BzzSpec, network.DiscoverySpec); err != nil {
+			panic(err)
+		}
+	}
 }
 
 type Simulation struct {
@@ -0,0 +1,222 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+/*
+Authorization lets the nodes of a private swarm refuse to serve chunks to, and
+accept chunks from, nodes outside it. Each node checks the requests of its
+peers with its ChunkAuthorizer:
+
+- retrieve requests and the hashes wanted by syncing peers with AuthorizeRetrieve;
+  requests which are not authorized are ignored and time out at the peer
+- chunk deliveries and the hashes offered by syncing peers with AuthorizeStore;
+  chunks which are not authorized are not requested or dropped
+
+Peers are identified by their node ID, which the transport authenticates, and
+the overlay address derived from it. Nodes present their capability token to
+their peers with an AuthMsg, sent before any other message.
+*/
+
+var (
+	errUnauthorized = errors.New("peer not authorized")
+
+	authDeniedRetrieveCount = metrics.NewRegisteredCounter("network.stream.auth.denied_retrieve.count", nil)
+	authDeniedStoreCount    = metrics.NewRegisteredCounter("network.stream.auth.denied_store.count", nil)
+)
+
+// ChunkAuthorizer authorizes the chunk requests of peers, see
+// RegistryOptions.Authorizer
+type ChunkAuthorizer interface {
+	// AuthorizeRetrieve returns an error if the peer may not retrieve the
+	// chunk from this node
+	AuthorizeRetrieve(peer *PeerCredentials, key storage.Key) error
+	// AuthorizeStore returns an error if the chunk of the peer may not be
+	// stored by this node
+	AuthorizeStore(peer *PeerCredentials, key storage.Key) error
+}
+
+// PeerCredentials identify a peer to a ChunkAuthorizer
+type PeerCredentials struct {
+	ID      discover.NodeID
+	Overlay []byte // overlay address derived from the node ID, see NodeOverlayAddr
+	Token   []byte // capability token presented by the peer, nil if none
+}
+
+// AuthMsg is the protocol msg presenting the capability token of the node to
+// a peer
+type AuthMsg struct {
+	Token []byte
+}
+
+// NodeOverlayAddr returns the overlay address of the node with the ID, the
+// hash of its public key like the BzzKey of api.Config. Authorizers use it
+// rather than the address the peer claims in the handshake, which any node
+// could claim.
+func NodeOverlayAddr(id discover.NodeID) []byte {
+	return crypto.Keccak256(append([]byte{0x04}, id[:]...))
+}
+
+// IssueAuthToken returns the capability token of the node with the overlay
+// address, which is accepted by TokenAuthorizers with the same secret. The
+// token is bound to the address, so it is of no use to other nodes.
+func IssueAuthToken(secret []byte, overlay []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(overlay)
+	return mac.Sum(nil)
+}
+
+// AllowlistAuthorizer authorizes the peers with the overlay addresses in its
+// list to retrieve and store any chunk
+type AllowlistAuthorizer struct {
+	allowed map[string]bool
+}
+
+// NewAllowlistAuthorizer returns an authorizer of the peers with the overlay
+// addresses
+func NewAllowlistAuthorizer(overlays [][]byte) *AllowlistAuthorizer {
+	a := &AllowlistAuthorizer{
+		allowed: make(map[string]bool),
+	}
+	for _, overlay := range overlays {
+		a.allowed[string(overlay)] = true
+	}
+	return a
+}
+
+func (a *AllowlistAuthorizer) AuthorizeRetrieve(peer *PeerCredentials, key storage.Key) error {
+	return a.authorize(peer)
+}
+
+func (a *AllowlistAuthorizer) AuthorizeStore(peer *PeerCredentials, key storage.Key) error {
+	return a.authorize(peer)
+}
+
+func (a *AllowlistAuthorizer) authorize(peer *PeerCredentials) error {
+	if !a.allowed[string(peer.Overlay)] {
+		return errUnauthorized
+	}
+	return nil
+}
+
+// TokenAuthorizer authorizes the peers presenting a capability token issued
+// with its secret to retrieve and store any chunk, see IssueAuthToken
+type TokenAuthorizer struct {
+	secret []byte
+}
+
+// NewTokenAuthorizer returns an authorizer of the tokens issued with the
+// shared secret
+func NewTokenAuthorizer(secret []byte) *TokenAuthorizer {
+	return &TokenAuthorizer{
+		secret: secret,
+	}
+}
+
+func (a *TokenAuthorizer) AuthorizeRetrieve(peer *PeerCredentials, key storage.Key) error {
+	return a.authorize(peer)
+}
+
+func (a *TokenAuthorizer) AuthorizeStore(peer *PeerCredentials, key storage.Key) error {
+	return a.authorize(peer)
+}
+
+func (a *TokenAuthorizer) authorize(peer *PeerCredentials) error {
+	if len(peer.Token) == 0 || !hmac.Equal(peer.Token, IssueAuthToken(a.secret, peer.Overlay)) {
+		return errUnauthorized
+	}
+	return nil
+}
+
+// AnyAuthorizer authorizes the requests authorized by any of its authorizers
+type AnyAuthorizer []ChunkAuthorizer
+
+func (a AnyAuthorizer) AuthorizeRetrieve(peer *PeerCredentials, key storage.Key) (err error) {
+	for _, authorizer := range a {
+		if err = authorizer.AuthorizeRetrieve(peer, key); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (a AnyAuthorizer) AuthorizeStore(peer *PeerCredentials, key storage.Key) (err error) {
+	for _, authorizer := range a {
+		if err = authorizer.AuthorizeStore(peer, key); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// handleAuthMsg records the capability token of the peer
+func (p *Peer) handleAuthMsg(msg *AuthMsg) error {
+	p.authMu.Lock()
+	defer p.authMu.Unlock()
+	p.token = msg.Token
+	return nil
+}
+
+// returns the credentials of the peer for its authorization
+func (p *Peer) credentials() *PeerCredentials {
+	p.authMu.RLock()
+	defer p.authMu.RUnlock()
+	return &PeerCredentials{
+		ID:      p.ID(),
+		Overlay: NodeOverlayAddr(p.ID()),
+		Token:   p.token,
+	}
+}
+
+// authorizeRetrieve returns an error if the peer may not retrieve the chunk
+func (p *Peer) authorizeRetrieve(key storage.Key) error {
+	a := p.streamer.authorizer
+	if a == nil {
+		return nil
+	}
+	if err := a.AuthorizeRetrieve(p.credentials(), key); err != nil {
+		authDeniedRetrieveCount.Inc(1)
+		log.Trace("unauthorized retrieve", "peer", p.ID(), "hash", key, "err", err)
+		return err
+	}
+	return nil
+}
+
+// authorizeStore returns an error if the chunk of the peer may not be stored
+func (p *Peer) authorizeStore(key storage.Key) error {
+	a := p.streamer.authorizer
+	if a == nil {
+		return nil
+	}
+	if err := a.AuthorizeStore(p.credentials(), key); err != nil {
+		authDeniedStoreCount.Inc(1)
+		log.Trace("unauthorized store", "peer", p.ID(), "hash", key, "err", err)
+		return err
+	}
+	return nil
+}
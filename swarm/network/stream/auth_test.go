@@ -0,0 +1,154 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// allowlists authorize the listed overlay addresses, token authorizers the
+// tokens issued for the overlay address of the peer
+func TestChunkAuthorizers(t *testing.T) {
+	secret := []byte("private swarm")
+	key := storage.Key(hash0[:])
+
+	var allowed, other discover.NodeID
+	allowed[0], other[0] = 1, 2
+	allowedPeer := &PeerCredentials{ID: allowed, Overlay: NodeOverlayAddr(allowed)}
+	tokenPeer := &PeerCredentials{ID: other, Overlay: NodeOverlayAddr(other), Token: IssueAuthToken(secret, NodeOverlayAddr(other))}
+	stolenPeer := &PeerCredentials{ID: allowed, Overlay: NodeOverlayAddr(allowed), Token: tokenPeer.Token}
+
+	allowlist := NewAllowlistAuthorizer([][]byte{NodeOverlayAddr(allowed)})
+	tokens := NewTokenAuthorizer(secret)
+	any := AnyAuthorizer{allowlist, tokens}
+
+	for i, c := range []struct {
+		authorizer ChunkAuthorizer
+		peer       *PeerCredentials
+		authorized bool
+	}{
+		{allowlist, allowedPeer, true},
+		{allowlist, tokenPeer, false},
+		{tokens, allowedPeer, false},
+		{tokens, tokenPeer, true},
+		{tokens, stolenPeer, false},
+		{any, allowedPeer, true},
+		{any, tokenPeer, true},
+		{any, &PeerCredentials{ID: other, Overlay: NodeOverlayAddr(other)}, false},
+	} {
+		if err := c.authorizer.AuthorizeRetrieve(c.peer, key); (err == nil) != c.authorized {
+			t.Errorf("case %d: expected retrieve authorized %v, got error %v", i, c.authorized, err)
+		}
+		if err := c.authorizer.AuthorizeStore(c.peer, key); (err == nil) != c.authorized {
+			t.Errorf("case %d: expected store authorized %v, got error %v", i, c.authorized, err)
+		}
+	}
+}
+
+// retrieve requests are served only while the peer presents a valid token
+func TestStreamerRetrieveRequestAuth(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("private swarm")
+	streamer.authorizer = NewTokenAuthorizer(secret)
+
+	peerID := tester.IDs[0]
+	peer := streamer.getPeer(peerID)
+
+	stream := NewStream(swarmChunkServerStreamName, "", false)
+
+	peer.handleSubscribeMsg(&SubscribeMsg{
+		Stream:   stream,
+		History:  nil,
+		Priority: Top,
+	})
+
+	authExchange := func(token []byte) p2ptest.Exchange {
+		return p2ptest.Exchange{
+			Label: "AuthMsg",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 11,
+					Msg: &AuthMsg{
+						Token: token,
+					},
+					Peer: peerID,
+				},
+			},
+		}
+	}
+	retrieveExchange := func(hash storage.Key) p2ptest.Exchange {
+		chunk := storage.NewChunk(hash, nil)
+		chunk.SData = hash
+		localStore.Put(chunk)
+		chunk.WaitToStore()
+
+		return p2ptest.Exchange{
+			Label: "RetrieveRequestMsg",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 5,
+					Msg: &RetrieveRequestMsg{
+						Key: hash,
+					},
+					Peer: peerID,
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: hash,
+						From:   0,
+						To:     32,
+						Stream: stream,
+					},
+					Peer: peerID,
+				},
+			},
+		}
+	}
+
+	err = tester.TestExchanges(
+		authExchange(IssueAuthToken(secret, NodeOverlayAddr(peerID))),
+		retrieveExchange(storage.Key(hash0[:])),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a token issued for another node is not valid for the peer
+	var other discover.NodeID
+	err = tester.TestExchanges(
+		authExchange(IssueAuthToken(secret, NodeOverlayAddr(other))),
+		retrieveExchange(storage.Key(hash1[:])),
+	)
+	expectedError := `exchange #1 "RetrieveRequestMsg": timed out`
+	if err == nil || err.Error() != expectedError {
+		t.Fatalf("Expected error %v, got %v", expectedError, err)
+	}
+}
@@ -38,6 +38,7 @@ import (
 	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/swarm/network"
+	streamTesting "github.com/ethereum/go-ethereum/swarm/network/stream/testing"
 	"github.com/ethereum/go-ethereum/swarm/state"
 	"github.com/ethereum/go-ethereum/swarm/storage"
 	"github.com/ethereum/go-ethereum/swarm/storage/mock"
@@ -55,6 +56,7 @@ var (
 	nodes        = flag.Int("nodes", 0, "number of nodes")
 	chunks       = flag.Int("chunks", 0, "number of chunks")
 	useMockStore = flag.Bool("mockstore", false, "disabled mock store (default: enabled)")
+	corpus       = flag.String("corpus", "", "directory to record the messages of the simulations into as fuzzing corpus")
 )
 
 var (
@@ -80,6 +82,12 @@ func init() {
 	// protocol when using the exec adapter
 	adapters.RegisterServices(services)
 
+	if *corpus != "" {
+		if err := streamTesting.RecordCorpus(*corpus, network.BzzSpec, network.DiscoverySpec, Spec); err != nil {
+			panic(err)
+		}
+	}
+
 	log.PrintOrigins(true)
 	log.Root().SetHandler(log.LvlFilterHandler(log.Lvl(*loglevel), log.StreamHandler(colorable.NewColorableStderr(), log.TerminalFormat(true))))
 }
@@ -48,6 +48,12 @@ type Delivery struct {
 	overlay  network.Overlay
 	receiveC chan *ChunkDeliveryMsg
 	getPeer  func(discover.NodeID) *Peer
+	// called for the requested chunks delivered by peers
+	delivered func(discover.NodeID, storage.Key)
+	// transit keys and routes of sealed deliveries, see SealedChunkDeliveryMsg
+	transit *transit
+	// requests of this node ask for sealed deliveries if set
+	encryptTransit bool
 }
 
 func NewDelivery(overlay network.Overlay, db *storage.DBAPI) *Delivery {
@@ -55,6 +61,7 @@ func NewDelivery(overlay network.Overlay, db *storage.DBAPI) *Delivery {
 		db:       db,
 		overlay:  overlay,
 		receiveC: make(chan *ChunkDeliveryMsg, deliveryCap),
+		transit:  newTransit(),
 	}
 
 	go d.processReceivedChunks()
@@ -132,14 +139,22 @@ func (s *SwarmChunkServer) GetData(key []byte) ([]byte, error) {
 
 // RetrieveRequestMsg is the protocol msg for chunk retrieve requests
 type RetrieveRequestMsg struct {
-	Key       storage.Key
-	SkipCheck bool
+	Key        storage.Key
+	SkipCheck  bool
+	TransitKey []byte // the chunk is delivered sealed to this key if set, see SealedChunkDeliveryMsg
 }
 
 func (d *Delivery) handleRetrieveRequestMsg(sp *Peer, req *RetrieveRequestMsg) error {
 	log.Trace("received request", "peer", sp.ID(), "hash", req.Key)
 	handleRetrieveRequestMsgCount.Inc(1)
 
+	if sp.authorizeRetrieve(req.Key) != nil {
+		return nil
+	}
+	if len(req.TransitKey) > 0 {
+		return d.handleTransitRequest(sp, req)
+	}
+
 	s, err := sp.getServer(NewStream(swarmChunkServerStreamName, "", false))
 	if err != nil {
 		return err
@@ -197,6 +212,9 @@ type ChunkDeliveryMsg struct {
 }
 
 func (d *Delivery) handleChunkDeliveryMsg(sp *Peer, req *ChunkDeliveryMsg) error {
+	if sp.authorizeStore(req.Key) != nil {
+		return nil
+	}
 	req.peer = sp
 	d.receiveC <- req
 	return nil
@@ -209,14 +227,17 @@ R:
 
 		// this should be has locally
 		chunk, err := d.db.Get(req.Key)
-		if !bytes.Equal(chunk.Key, req.Key) {
-			panic(fmt.Errorf("processReceivedChunks: chunk key %s != req key %s (peer %s)", chunk.Key.Hex(), req.Key.Hex(), req.peer.ID()))
-		}
 		if err == nil {
 			continue R
 		}
 		if err != storage.ErrFetching {
-			panic(fmt.Sprintf("not in db? key %v chunk %v", req.Key, chunk))
+			// the chunk was never requested, or the request is gone, peers
+			// cannot push chunks into the store
+			log.Debug("unrequested chunk delivered", "peer", req.peer.ID(), "hash", req.Key, "err", err)
+			continue R
+		}
+		if !bytes.Equal(chunk.Key, req.Key) {
+			panic(fmt.Errorf("processReceivedChunks: chunk key %s != req key %s (peer %s)", chunk.Key.Hex(), req.Key.Hex(), req.peer.ID()))
 		}
 		select {
 		case <-chunk.ReqC:
@@ -226,18 +247,45 @@ R:
 		}
 		chunk.SData = req.SData
 		d.db.Put(chunk)
+		if d.delivered != nil {
+			d.delivered(req.peer.ID(), req.Key)
+		}
 
 		go func(req *ChunkDeliveryMsg) {
 			err := chunk.WaitToStore()
 			if err == storage.ErrChunkInvalid {
+				d.report(req.peer, network.PeerEvent{Type: network.Misbehaviour})
 				req.peer.Drop(err)
 			}
 		}(req)
 	}
 }
 
+// report feeds an event about the peer into its reputation if the overlay
+// keeps reputations, see network.Reporter
+func (d *Delivery) report(sp *Peer, ev network.PeerEvent) {
+	r, ok := d.overlay.(network.Reporter)
+	if !ok || sp == nil || len(sp.overlay) == 0 {
+		return
+	}
+	r.Report(sp.overlay, ev)
+}
+
 // RequestFromPeers sends a chunk retrieve request to
 func (d *Delivery) RequestFromPeers(hash []byte, skipCheck bool, peersToSkip ...discover.NodeID) error {
+	var transitKey []byte
+	if d.encryptTransit {
+		var err error
+		if transitKey, err = d.transit.requestKey(hash); err != nil {
+			return err
+		}
+	}
+	return d.requestFromPeers(hash, skipCheck, transitKey, peersToSkip...)
+}
+
+// requestFromPeers sends a chunk retrieve request with the transit key to
+// the closest peer not skipped
+func (d *Delivery) requestFromPeers(hash []byte, skipCheck bool, transitKey []byte, peersToSkip ...discover.NodeID) error {
 	var success bool
 	var err error
 	requestFromPeersCount.Inc(1)
@@ -256,8 +304,9 @@ func (d *Delivery) RequestFromPeers(hash []byte, skipCheck bool, peersToSkip ...
 		}
 		// TODO: skip light nodes that do not accept retrieve requests
 		err = sp.SendPriority(&RetrieveRequestMsg{
-			Key:       hash,
-			SkipCheck: skipCheck,
+			Key:        hash,
+			SkipCheck:  skipCheck,
+			TransitKey: transitKey,
 		}, Top)
 		if err != nil {
 			return true
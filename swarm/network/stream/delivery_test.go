@@ -296,6 +296,58 @@ func TestStreamerDownstreamChunkDeliveryMsgExchange(t *testing.T) {
 
 }
 
+// TestStreamerUnrequestedChunkDelivery checks that chunks delivered without
+// being requested are ignored rather than crashing the node
+func TestStreamerUnrequestedChunkDelivery(t *testing.T) {
+	tester, _, localStore, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerID := tester.IDs[0]
+	requested, created := localStore.GetOrCreateRequest(hash1[:])
+	if !created {
+		t.Fatal("chunk already exists")
+	}
+
+	// the deliveries are processed in order, once the requested chunk is
+	// stored the unrequested one has been processed
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "ChunkDeliveryRequest messages",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 6,
+				Msg: &ChunkDeliveryMsg{
+					Key:   hash0[:],
+					SData: hash2[:],
+				},
+				Peer: peerID,
+			},
+			{
+				Code: 6,
+				Msg: &ChunkDeliveryMsg{
+					Key:   hash1[:],
+					SData: hash2[:],
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	select {
+	case <-time.After(1 * time.Second):
+		t.Fatal("timeout receiving chunk")
+	case <-requested.ReqC:
+	}
+	if _, err := localStore.Get(hash0[:]); err == nil {
+		t.Fatal("expected the unrequested chunk not to be stored")
+	}
+}
+
 func TestDeliveryFromNodes(t *testing.T) {
 	testDeliveryFromNodes(t, 2, 1, dataChunkCount, true)
 	testDeliveryFromNodes(t, 2, 1, dataChunkCount, false)
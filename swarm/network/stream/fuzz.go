@@ -0,0 +1,99 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package stream
+
+import (
+	"io/ioutil"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// The go-fuzz harness of the stream messages, the store and retrieve
+// requests, deliveries and subscriptions. The input is a message code
+// followed by the RLP encoded payload, the corpus is recorded from the
+// simulations of this package with the -corpus flag of its tests.
+
+var (
+	fuzzOnce     sync.Once
+	fuzzRegistry *Registry
+)
+
+func fuzzSetup() {
+	addr := network.NewAddrFromNodeID(discover.NodeID{1})
+	datadir, err := ioutil.TempDir("", "stream-fuzz")
+	if err != nil {
+		panic(err)
+	}
+	params := storage.NewDefaultLocalStoreParams()
+	params.Init(datadir)
+	params.BaseKey = addr.Over()
+	localStore, err := storage.NewTestLocalStoreForAddr(params)
+	if err != nil {
+		panic(err)
+	}
+	db := storage.NewDBAPI(localStore)
+	kad := network.NewKademlia(addr.Over(), network.NewKadParams())
+	fuzzRegistry = NewRegistry(addr, NewDelivery(kad, db), db, state.NewInmemoryStore(), &RegistryOptions{
+		SkipCheck: true,
+	})
+}
+
+// Fuzz handles a stream message received from a peer, the messages sent
+// back to the peer are discarded
+func Fuzz(data []byte) int {
+	fuzzOnce.Do(fuzzSetup)
+	if len(data) == 0 {
+		return 0
+	}
+	msg, ok := Spec.NewMsg(uint64(data[0]))
+	if !ok {
+		return 0
+	}
+	if err := rlp.DecodeBytes(data[1:], msg); err != nil {
+		return 0
+	}
+	rw, remote := p2p.MsgPipe()
+	defer rw.Close()
+	go func() {
+		for {
+			m, err := remote.ReadMsg()
+			if err != nil {
+				return
+			}
+			m.Discard()
+		}
+	}()
+	p := NewPeer(protocols.NewPeer(p2p.NewPeer(discover.NodeID{2}, "fuzz", nil), rw, Spec), fuzzRegistry)
+	// the peer is set up and torn down like by Registry.Run
+	fuzzRegistry.setPeer(p)
+	defer fuzzRegistry.deletePeer(p)
+	defer close(p.quit)
+	defer p.close()
+	if err := p.HandleMsg(msg); err != nil {
+		return 0
+	}
+	return 1
+}
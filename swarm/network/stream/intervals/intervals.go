@@ -149,6 +149,24 @@ func (i *Intervals) Last() (end uint64) {
 	return i.ranges[l-1][1]
 }
 
+// Count returns the number of values in the intervals that are not
+// greater than end.
+func (i *Intervals) Count(end uint64) (count uint64) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	for _, r := range i.ranges {
+		if r[0] > end {
+			break
+		}
+		if r[1] > end {
+			return count + end - r[0] + 1
+		}
+		count += r[1] - r[0] + 1
+	}
+	return count
+}
+
 // String returns a descriptive representation of range intervals
 // in [] notation, as a list of two element vectors.
 func (i *Intervals) String() string {
@@ -393,3 +393,45 @@ func TestMerge(t *testing.T) {
 		}
 	}
 }
+
+func TestCount(t *testing.T) {
+	for i, tc := range []struct {
+		initial  [][2]uint64
+		end      uint64
+		expected uint64
+	}{
+		{
+			initial:  nil,
+			end:      100,
+			expected: 0,
+		},
+		{
+			initial:  [][2]uint64{{0, 10}},
+			end:      100,
+			expected: 11,
+		},
+		{
+			initial:  [][2]uint64{{0, 10}, {20, 30}},
+			end:      25,
+			expected: 17,
+		},
+		{
+			initial:  [][2]uint64{{0, 10}, {20, 30}},
+			end:      15,
+			expected: 11,
+		},
+		{
+			initial:  [][2]uint64{{5, 10}, {20, 30}},
+			end:      0,
+			expected: 0,
+		},
+	} {
+		intervals := NewIntervals(0)
+		intervals.ranges = tc.initial
+
+		got := intervals.Count(tc.end)
+		if got != tc.expected {
+			t.Errorf("interval #%d: expected count %d, got %d", i, tc.expected, got)
+		}
+	}
+}
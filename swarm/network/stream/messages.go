@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/log"
@@ -194,11 +195,16 @@ func (p *Peer) handleOfferedHashesMsg(req *OfferedHashesMsg) error {
 		return fmt.Errorf("error initiaising bitvector of length %v: %v", len(hashes)/HashSize, err)
 	}
 	wg := sync.WaitGroup{}
+	var wanted int
 	for i := 0; i < len(hashes); i += HashSize {
 		hash := hashes[i : i+HashSize]
+		if p.authorizeStore(hash) != nil {
+			continue
+		}
 
 		if wait := c.NeedData(hash); wait != nil {
 			want.Set(i/HashSize, true)
+			wanted++
 			wg.Add(1)
 			// create request and wait until the chunk data arrives and is stored
 			go func(w func()) {
@@ -231,6 +237,10 @@ func (p *Peer) handleOfferedHashesMsg(req *OfferedHashesMsg) error {
 	// except
 	if c.stream.Live {
 		c.sessionAt = req.From
+		if atomic.LoadUint32(&c.sessionStarted) == 0 {
+			atomic.StoreUint64(&c.sessionStart, req.From)
+			atomic.StoreUint32(&c.sessionStarted, 1)
+		}
 	}
 	from, to := c.nextBatch(req.To + 1)
 	log.Trace("received offered batch", "peer", p.ID(), "stream", req.Stream, "from", req.From, "to", req.To)
@@ -259,6 +269,10 @@ func (p *Peer) handleOfferedHashesMsg(req *OfferedHashesMsg) error {
 		case <-c.quit:
 			return
 		}
+		// throttle the historical syncing before the wanted chunks are delivered
+		if !c.stream.Live && !p.streamer.historyLimiter.wait(wanted*wantedChunkSize, c.quit) {
+			return
+		}
 		log.Trace("sending want batch", "peer", p.ID(), "stream", msg.Stream, "from", msg.From, "to", msg.To)
 		err := p.SendPriority(msg, c.priority)
 		if err != nil {
@@ -314,6 +328,9 @@ func (p *Peer) handleWantedHashesMsg(req *WantedHashesMsg) error {
 			metrics.GetOrRegisterCounter("peer.handlewantedhashesmsg.actualget", nil).Inc(1)
 
 			hash := hashes[i*HashSize : (i+1)*HashSize]
+			if p.authorizeRetrieve(hash) != nil {
+				continue
+			}
 			data, err := s.GetData(hash)
 			if err != nil {
 				return fmt.Errorf("handleWantedHashesMsg get data %x: %v", hash, err)
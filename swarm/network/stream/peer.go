@@ -60,6 +60,9 @@ type Peer struct {
 	// on creating a new client in offered hashes handler.
 	clientParams map[Stream]*clientParams
 	quit         chan struct{}
+	overlay      []byte // overlay address, the key of the state kept across reconnects
+	authMu       sync.RWMutex
+	token        []byte // capability token presented by the peer, see AuthMsg
 }
 
 // NewPeer is the constructor for Peer
@@ -257,8 +260,24 @@ func (p *Peer) getOrSetClient(s Stream, from, to uint64) (c *client, created boo
 		}
 	}
 
-	if err := p.streamer.intervalsStore.Put(intervalsKey, intervals.NewIntervals(from)); err != nil {
-		return nil, false, err
+	// the intervals of a history stream synced before the peer disconnected
+	// are kept, so that syncing resumes where it left off
+	var resume bool
+	if !s.Live {
+		err := p.streamer.intervalsStore.Get(intervalsKey, &intervals.Intervals{})
+		switch err {
+		case nil:
+			resume = true
+			metrics.GetOrRegisterCounter("stream.history.resume", nil).Inc(1)
+		case state.ErrNotFound:
+		default:
+			log.Error("stream set client: get history intervals", "stream", s, "peer", p, "err", err)
+		}
+	}
+	if !resume {
+		if err := p.streamer.intervalsStore.Put(intervalsKey, intervals.NewIntervals(from)); err != nil {
+			return nil, false, err
+		}
 	}
 
 	next := make(chan error, 1)
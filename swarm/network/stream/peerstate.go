@@ -0,0 +1,173 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/swarm/state"
+)
+
+const (
+	// state of peers kept for longer than this is not restored when they
+	// reconnect, and is deleted
+	peerStateTTL = time.Hour
+	// the number of peer states kept, the oldest ones are deleted first
+	maxPeerStates = 1000
+
+	// key of the index of the saved peer states, by overlay address
+	peerStateIndexKey = "peerstate-index"
+)
+
+// peerState is the state of a peer kept across reconnects, together with the
+// sync intervals of its streams
+type peerState struct {
+	RTT        time.Duration // estimated round trip time of retrievals
+	Reputation float64       // see network.Kademlia.Report
+	Saved      time.Time
+}
+
+// reputationKeeper is implemented by overlays keeping the reputation of the
+// peers, like network.Kademlia
+type reputationKeeper interface {
+	Reputation(addr []byte) (float64, bool)
+	SetReputation(addr []byte, reputation float64) bool
+}
+
+func peerStateKey(overlay string) string {
+	return "peerstate-" + overlay
+}
+
+// returns the overlay keeping the reputation of the peers, if any
+func (r *Registry) reputations() (reputationKeeper, bool) {
+	if r.delivery == nil {
+		return nil, false
+	}
+	k, ok := r.delivery.overlay.(reputationKeeper)
+	return k, ok
+}
+
+// savePeerState stores the state of a disconnecting peer
+func (r *Registry) savePeerState(p *Peer) {
+	if len(p.overlay) == 0 {
+		return
+	}
+	ps := &peerState{Saved: time.Now()}
+	var known bool
+	if r.retrieval != nil {
+		ps.RTT = r.retrieval.peerRTT(p.ID())
+		known = ps.RTT != 0
+	}
+	if k, ok := r.reputations(); ok {
+		if reputation, ok := k.Reputation(p.overlay); ok {
+			ps.Reputation, known = reputation, true
+		}
+	}
+	if !known {
+		return
+	}
+
+	r.peerStateMu.Lock()
+	defer r.peerStateMu.Unlock()
+	overlay := fmt.Sprintf("%x", p.overlay)
+	if err := r.intervalsStore.Put(peerStateKey(overlay), ps); err != nil {
+		log.Warn("cannot save peer state", "peer", p.ID(), "err", err)
+		return
+	}
+	index := r.peerStateIndex()
+	index[overlay] = ps.Saved
+	r.expirePeerStates(index, ps.Saved)
+	if err := r.intervalsStore.Put(peerStateIndexKey, index); err != nil {
+		log.Warn("cannot save peer state index", "err", err)
+	}
+}
+
+// loadPeerState restores the state of a peer saved when it disconnected, so
+// that a peer reconnecting after a blip is not treated as a new one. The
+// saved state is deleted, it is saved again when the peer disconnects.
+func (r *Registry) loadPeerState(p *Peer) {
+	if len(p.overlay) == 0 {
+		return
+	}
+	r.peerStateMu.Lock()
+	defer r.peerStateMu.Unlock()
+	overlay := fmt.Sprintf("%x", p.overlay)
+	var ps peerState
+	if err := r.intervalsStore.Get(peerStateKey(overlay), &ps); err != nil {
+		if err != state.ErrNotFound {
+			log.Warn("cannot load peer state", "peer", p.ID(), "err", err)
+		}
+		return
+	}
+	index := r.peerStateIndex()
+	delete(index, overlay)
+	if err := r.intervalsStore.Delete(peerStateKey(overlay)); err != nil {
+		log.Warn("cannot delete peer state", "peer", p.ID(), "err", err)
+	}
+	if err := r.intervalsStore.Put(peerStateIndexKey, index); err != nil {
+		log.Warn("cannot save peer state index", "err", err)
+	}
+	if time.Since(ps.Saved) > peerStateTTL {
+		return
+	}
+	if r.retrieval != nil && ps.RTT != 0 {
+		r.retrieval.setPeerRTT(p.ID(), ps.RTT)
+	}
+	if k, ok := r.reputations(); ok {
+		k.SetReputation(p.overlay, ps.Reputation)
+	}
+	metrics.GetOrRegisterCounter("stream.peerstate.restore", nil).Inc(1)
+	log.Trace("peer state restored", "peer", p.ID(), "rtt", ps.RTT, "reputation", ps.Reputation)
+}
+
+// returns the index of the saved peer states, caller must hold peerStateMu
+func (r *Registry) peerStateIndex() map[string]time.Time {
+	index := make(map[string]time.Time)
+	if err := r.intervalsStore.Get(peerStateIndexKey, &index); err != nil && err != state.ErrNotFound {
+		log.Warn("cannot load peer state index", "err", err)
+	}
+	return index
+}
+
+// deletes the peer states older than peerStateTTL and the oldest ones beyond
+// maxPeerStates from the store and the index, caller must hold peerStateMu
+func (r *Registry) expirePeerStates(index map[string]time.Time, now time.Time) {
+	remove := func(overlay string) {
+		delete(index, overlay)
+		if err := r.intervalsStore.Delete(peerStateKey(overlay)); err != nil {
+			log.Warn("cannot delete peer state", "overlay", overlay, "err", err)
+		}
+		metrics.GetOrRegisterCounter("stream.peerstate.expire", nil).Inc(1)
+	}
+	for overlay, saved := range index {
+		if now.Sub(saved) > peerStateTTL {
+			remove(overlay)
+		}
+	}
+	for len(index) > maxPeerStates {
+		var oldest string
+		for overlay, saved := range index {
+			if oldest == "" || saved.Before(index[oldest]) {
+				oldest = overlay
+			}
+		}
+		remove(oldest)
+	}
+}
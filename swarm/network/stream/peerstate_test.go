@@ -0,0 +1,124 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/state"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+func TestPeerStateReconnect(t *testing.T) {
+	overlay := make([]byte, 32)
+	overlay[0] = 0xaa
+	key := peerStateKey(fmt.Sprintf("%x", overlay))
+	kad := network.NewKademlia(make([]byte, 32), network.NewKadParams())
+	if err := kad.Register([]network.OverlayAddr{&network.BzzAddr{OAddr: overlay, UAddr: overlay}}); err != nil {
+		t.Fatal(err)
+	}
+	r := &Registry{
+		intervalsStore: state.NewInmemoryStore(),
+		delivery:       &Delivery{overlay: kad},
+		retrieval: newRetrievalScheduler(NewRetrievalParams(), func(storage.Key) []discover.NodeID {
+			return nil
+		}, nil),
+	}
+	newPeer := func(id discover.NodeID) *Peer {
+		p := NewPeer(protocols.NewPeer(p2p.NewPeer(id, "", nil), nil, Spec), r)
+		p.overlay = overlay
+		return p
+	}
+	rtt := 300 * time.Millisecond
+	p := newPeer(discover.NodeID{1})
+	r.retrieval.setPeerRTT(p.ID(), rtt)
+	kad.Report(overlay, network.PeerEvent{Type: network.Misbehaviour})
+	reputation, _ := kad.Reputation(overlay)
+	r.savePeerState(p)
+	r.retrieval.removePeer(p.ID())
+	kad.SetReputation(overlay, 0)
+
+	// the peer reconnects with the same overlay address
+	p = newPeer(discover.NodeID{1})
+	r.loadPeerState(p)
+	if got := r.retrieval.peerRTT(p.ID()); got != rtt {
+		t.Fatalf("expected restored rtt %v, got %v", rtt, got)
+	}
+	if got, _ := kad.Reputation(overlay); got != reputation {
+		t.Fatalf("expected restored reputation %v, got %v", reputation, got)
+	}
+	s := NewStream("SYNC", "1", false)
+	if peerStreamIntervalsKey(p, s) != peerStreamIntervalsKey(newPeer(discover.NodeID{2}), s) {
+		t.Fatal("expected intervals keyed by overlay address")
+	}
+	// the restored state is deleted
+	if err := r.intervalsStore.Get(key, &peerState{}); err != state.ErrNotFound {
+		t.Fatalf("expected restored state to be deleted, got %v", err)
+	}
+
+	// an outdated state is not restored
+	r.retrieval.removePeer(p.ID())
+	if err := r.intervalsStore.Put(key, &peerState{RTT: rtt, Saved: time.Now().Add(-2 * peerStateTTL)}); err != nil {
+		t.Fatal(err)
+	}
+	r.loadPeerState(p)
+	if got := r.retrieval.peerRTT(p.ID()); got != 0 {
+		t.Fatalf("expected no rtt from outdated state, got %v", got)
+	}
+}
+
+// the states of peers which do not reconnect are deleted once outdated, and
+// the oldest ones beyond the maximum number of states
+func TestPeerStateExpire(t *testing.T) {
+	r := &Registry{
+		intervalsStore: state.NewInmemoryStore(),
+	}
+	now := time.Now()
+	index := make(map[string]time.Time)
+	put := func(overlay string, saved time.Time) {
+		if err := r.intervalsStore.Put(peerStateKey(overlay), &peerState{RTT: time.Second, Saved: saved}); err != nil {
+			t.Fatal(err)
+		}
+		index[overlay] = saved
+	}
+	put("old", now.Add(-2*peerStateTTL))
+	for i := 0; i <= maxPeerStates; i++ {
+		put(fmt.Sprintf("%04x", i), now.Add(time.Duration(i-maxPeerStates)*time.Second))
+	}
+	r.expirePeerStates(index, now)
+
+	if len(index) != maxPeerStates {
+		t.Fatalf("expected %d peer states, got %d", maxPeerStates, len(index))
+	}
+	for _, overlay := range []string{"old", "0000"} {
+		if _, ok := index[overlay]; ok {
+			t.Fatalf("expected state of %s to be expired", overlay)
+		}
+		if err := r.intervalsStore.Get(peerStateKey(overlay), &peerState{}); err != state.ErrNotFound {
+			t.Fatalf("expected state of %s to be deleted, got %v", overlay, err)
+		}
+	}
+	if err := r.intervalsStore.Get(peerStateKey("0001"), &peerState{}); err != nil {
+		t.Fatal(err)
+	}
+}
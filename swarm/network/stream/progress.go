@@ -0,0 +1,163 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/network/stream/intervals"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// estimated amount of bytes transferred for a wanted chunk
+const wantedChunkSize = int(storage.DefaultChunkSize) + 8
+
+// SyncProgress reports the progress of the historical syncing of a
+// proximity bin from a peer
+type SyncProgress struct {
+	Peer      discover.NodeID `json:"peer"`
+	Bin       uint8           `json:"bin"`
+	Total     uint64          `json:"total"`     // chunks stored by the peer in the bin when the session started
+	Synced    uint64          `json:"synced"`    // chunks of the history that are synced
+	Remaining uint64          `json:"remaining"` // chunks of the history still to be synced
+}
+
+// SyncProgress returns the historical syncing backlog per peer and bin.
+//
+// The history of a bin ends where the live stream of the bin started, so
+// bins are only reported once the first live batch has been offered.
+func (r *Registry) SyncProgress() []*SyncProgress {
+	r.peersMu.RLock()
+	defer r.peersMu.RUnlock()
+
+	var progress []*SyncProgress
+	for id, p := range r.peers {
+		p.clientMu.RLock()
+		for s, c := range p.clients {
+			if s.Name != "SYNC" || s.Live {
+				continue
+			}
+			bin, err := ParseSyncBinKey(s.Key)
+			if err != nil {
+				continue
+			}
+			var total uint64
+			if c.to > 0 {
+				total = c.to + 1
+			} else if live, ok := p.clients[NewStream(s.Name, s.Key, true)]; ok && atomic.LoadUint32(&live.sessionStarted) == 1 {
+				total = atomic.LoadUint64(&live.sessionStart)
+			} else {
+				continue
+			}
+			sp := &SyncProgress{
+				Peer:  id,
+				Bin:   bin,
+				Total: total,
+			}
+			if total > 0 {
+				i := &intervals.Intervals{}
+				if err := r.intervalsStore.Get(c.intervalsKey, i); err != nil {
+					log.Debug("sync progress: get intervals", "peer", id, "stream", s, "err", err)
+					continue
+				}
+				sp.Synced = i.Count(total - 1)
+			}
+			sp.Remaining = sp.Total - sp.Synced
+			progress = append(progress, sp)
+		}
+		p.clientMu.RUnlock()
+	}
+	return progress
+}
+
+// rateLimiter limits the bandwidth of historical syncing.
+//
+// Requests reserve their bytes and wait until the reservation is covered
+// at the configured rate, allowing a burst of one second worth of bytes.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   int // bytes per second, unlimited if 0
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	return &rateLimiter{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// setRate changes the rate of the limiter, 0 disables limiting
+func (l *rateLimiter) setRate(rate int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rate = rate
+	l.tokens = float64(rate)
+	l.last = time.Now()
+}
+
+func (l *rateLimiter) getRate() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// reserve takes n bytes from the bucket and returns how long to wait
+// before they may be transferred
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// wait blocks until n bytes may be transferred, it returns false if quit
+// is closed while waiting
+func (l *rateLimiter) wait(n int, quit chan struct{}) bool {
+	d := l.reserve(n)
+	if d == 0 {
+		return true
+	}
+	metrics.GetOrRegisterCounter("stream.history.throttled", nil).Inc(1)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-quit:
+		return false
+	}
+}
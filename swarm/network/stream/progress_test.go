@@ -0,0 +1,161 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
+)
+
+func TestSyncProgress(t *testing.T) {
+	tester, streamer, _, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streamer.RegisterClientFunc("SYNC", func(p *Peer, t string, live bool) (Client, error) {
+		return newTestClient(t), nil
+	})
+
+	peerID := tester.IDs[0]
+	stream := NewStream("SYNC", FormatSyncBinKey(1), true)
+	if err := streamer.Subscribe(peerID, stream, NewRange(0, 0), Top); err != nil {
+		t.Fatal(err)
+	}
+
+	// hashes not waited for by the test client
+	batch := make([]byte, 3*HashSize)
+
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "Subscribe message",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 4,
+				Msg: &SubscribeMsg{
+					Stream:   stream,
+					History:  NewRange(0, 0),
+					Priority: Top,
+				},
+				Peer: peerID,
+			},
+		},
+	},
+		p2ptest.Exchange{
+			Label: "Live offered hashes",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: batch,
+						From:   10,
+						To:     12,
+						Stream: stream,
+					},
+					Peer: peerID,
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 2,
+					Msg: &WantedHashesMsg{
+						Stream: stream,
+						Want:   []byte{0},
+						From:   13,
+						To:     0,
+					},
+					Peer: peerID,
+				},
+			},
+		},
+		p2ptest.Exchange{
+			Label: "History offered hashes",
+			Triggers: []p2ptest.Trigger{
+				{
+					Code: 1,
+					Msg: &OfferedHashesMsg{
+						HandoverProof: &HandoverProof{
+							Handover: &Handover{},
+						},
+						Hashes: batch,
+						From:   0,
+						To:     3,
+						Stream: getHistoryStream(stream),
+					},
+					Peer: peerID,
+				},
+			},
+			Expects: []p2ptest.Expect{
+				{
+					Code: 2,
+					Msg: &WantedHashesMsg{
+						Stream: getHistoryStream(stream),
+						Want:   []byte{0},
+						From:   4,
+						To:     math.MaxUint64,
+					},
+					Peer: peerID,
+				},
+			},
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the history batch is added to the intervals when it is done
+	var progress []*SyncProgress
+	for i := 0; i < 100; i++ {
+		progress = streamer.api.SyncProgress()
+		if len(progress) == 1 && progress[0].Synced > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(progress) != 1 {
+		t.Fatalf("expected progress of 1 bin, got %d", len(progress))
+	}
+	p := progress[0]
+	if p.Peer != peerID || p.Bin != 1 || p.Total != 10 || p.Synced != 4 || p.Remaining != 6 {
+		t.Fatalf("unexpected sync progress %+v", p)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(1000)
+	if d := l.reserve(1000); d != 0 {
+		t.Fatalf("expected burst within the rate, waiting %v", d)
+	}
+	if d := l.reserve(500); d < 400*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("expected to wait about 500ms, waiting %v", d)
+	}
+	quit := make(chan struct{})
+	close(quit)
+	if l.wait(1000, quit) {
+		t.Fatal("expected wait to be interrupted")
+	}
+
+	l.setRate(0)
+	if d := l.reserve(1000000); d != 0 {
+		t.Fatalf("expected no limit, waiting %v", d)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+const (
+	rttImpact              = 0.25 // impact of a single measurement on the estimated round trip time of a peer
+	maxRetrievalCandidates = 8    // number of closest peers considered for a retrieval
+)
+
+var (
+	errNoRetrievalPeer = errors.New("no peer found")
+
+	retrievalScheduledCount = metrics.NewRegisteredCounter("network.stream.retrieval.scheduled", nil)
+	retrievalQueuedCount    = metrics.NewRegisteredCounter("network.stream.retrieval.queued", nil)
+	retrievalReassignCount  = metrics.NewRegisteredCounter("network.stream.retrieval.reassign", nil)
+	retrievalGiveUpCount    = metrics.NewRegisteredCounter("network.stream.retrieval.giveup", nil)
+)
+
+// RetrievalParams configures the scheduling of chunk retrievals across peers
+type RetrievalParams struct {
+	PeerCapacity     int           // concurrent requests to a peer before its round trip time is measured
+	MaxPeerCapacity  int           // concurrent requests to peers answering within TargetRTT
+	TargetRTT        time.Duration // slower peers get proportionally fewer concurrent requests
+	StragglerTimeout time.Duration // minimum time before a request is re-assigned to another peer
+	MaxAttempts      int           // number of peers asked for a chunk before giving up, unlimited if 0
+}
+
+func NewRetrievalParams() *RetrievalParams {
+	return &RetrievalParams{
+		PeerCapacity:     4,
+		MaxPeerCapacity:  32,
+		TargetRTT:        500 * time.Millisecond,
+		StragglerTimeout: 2 * time.Second,
+		MaxAttempts:      3,
+	}
+}
+
+type peerRetrievalStats struct {
+	inflight int
+	rtt      time.Duration // estimated round trip time, 0 until measured
+}
+
+// a scheduled chunk retrieval
+type retrieval struct {
+	key      storage.Key
+	peer     discover.NodeID               // peer currently assigned
+	sent     map[discover.NodeID]time.Time // peers asked so far
+	attempts int
+	timer    *time.Timer
+	done     bool
+}
+
+// retrievalScheduler distributes chunk retrieve requests among the peers
+// closest to the chunks, similar to the block downloader of eth.
+//
+// Each peer gets a number of concurrent requests depending on its measured
+// round trip time, requests exceeding the capacity of the candidate peers
+// are queued and sent as soon as requests of the peers are delivered. If a
+// peer does not deliver in time, the request is re-assigned to the next
+// closest peer not asked yet.
+type retrievalScheduler struct {
+	params  *RetrievalParams
+	peers   func(key storage.Key) []discover.NodeID // candidate peers, closest first
+	request func(id discover.NodeID, key storage.Key) error
+	// called with the deliveries and the stragglers, to feed the reputation
+	// of the peers, if set
+	report func(id discover.NodeID, ev network.PeerEvent)
+
+	mu      sync.Mutex
+	stats   map[discover.NodeID]*peerRetrievalStats
+	pending map[string]*retrieval
+	queue   []*retrieval
+}
+
+func newRetrievalScheduler(params *RetrievalParams, peers func(storage.Key) []discover.NodeID, request func(discover.NodeID, storage.Key) error) *retrievalScheduler {
+	return &retrievalScheduler{
+		params:  params,
+		peers:   peers,
+		request: request,
+		stats:   make(map[discover.NodeID]*peerRetrievalStats),
+		pending: make(map[string]*retrieval),
+	}
+}
+
+// schedule requests the chunk from the closest peer with spare capacity,
+// it returns an error if there is no peer to request it from
+func (s *retrievalScheduler) schedule(key storage.Key) error {
+	s.mu.Lock()
+	if _, ok := s.pending[string(key)]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	r := &retrieval{
+		key:  key,
+		sent: make(map[discover.NodeID]time.Time),
+	}
+	s.pending[string(key)] = r
+	s.mu.Unlock()
+	retrievalScheduledCount.Inc(1)
+	return s.dispatch(r)
+}
+
+// must be called with the lock held
+func (s *retrievalScheduler) getStats(id discover.NodeID) *peerRetrievalStats {
+	st, ok := s.stats[id]
+	if !ok {
+		st = &peerRetrievalStats{}
+		s.stats[id] = st
+	}
+	return st
+}
+
+// the number of concurrent requests a peer gets
+func (s *retrievalScheduler) capacity(st *peerRetrievalStats) int {
+	if st.rtt == 0 {
+		return s.params.PeerCapacity
+	}
+	c := int(float64(s.params.MaxPeerCapacity) * float64(s.params.TargetRTT) / float64(st.rtt))
+	if c < 1 {
+		return 1
+	} else if c > s.params.MaxPeerCapacity {
+		return s.params.MaxPeerCapacity
+	}
+	return c
+}
+
+// the time a peer has to deliver before the request is re-assigned
+func (s *retrievalScheduler) timeout(st *peerRetrievalStats) time.Duration {
+	if t := 3 * st.rtt; t > s.params.StragglerTimeout {
+		return t
+	}
+	return s.params.StragglerTimeout
+}
+
+// must be called with the lock held
+func (st *peerRetrievalStats) measure(rtt time.Duration) {
+	if st.rtt == 0 {
+		st.rtt = rtt
+		return
+	}
+	st.rtt = time.Duration((1-rttImpact)*float64(st.rtt) + rttImpact*float64(rtt))
+}
+
+// returns true if the retrieval was sent to MaxAttempts peers, must be called
+// with the lock held
+func (s *retrievalScheduler) exhausted(r *retrieval) bool {
+	return s.params.MaxAttempts > 0 && r.attempts >= s.params.MaxAttempts
+}
+
+// assigns the retrieval to the closest peer not asked yet, or queues it if
+// all of them are busy. The retrieval is given up once MaxAttempts peers did
+// not deliver.
+func (s *retrievalScheduler) dispatch(r *retrieval) error {
+	candidates := s.peers(r.key)
+	for {
+		s.mu.Lock()
+		if r.done {
+			s.mu.Unlock()
+			return nil
+		}
+		if s.exhausted(r) {
+			delete(s.pending, string(r.key))
+			r.done = true
+			s.mu.Unlock()
+			retrievalGiveUpCount.Inc(1)
+			log.Debug("retrieval scheduler: giving up", "key", r.key, "attempts", r.attempts)
+			return nil
+		}
+		var id discover.NodeID
+		var st *peerRetrievalStats
+		var found, busy bool
+		for _, c := range candidates {
+			if _, ok := r.sent[c]; ok {
+				continue
+			}
+			cst := s.getStats(c)
+			if cst.inflight >= s.capacity(cst) {
+				busy = true
+				continue
+			}
+			id, st, found = c, cst, true
+			break
+		}
+		if !found {
+			if busy {
+				s.queue = append(s.queue, r)
+				s.mu.Unlock()
+				retrievalQueuedCount.Inc(1)
+				return nil
+			}
+			delete(s.pending, string(r.key))
+			s.mu.Unlock()
+			if r.attempts > 0 {
+				retrievalGiveUpCount.Inc(1)
+				log.Debug("retrieval scheduler: giving up", "key", r.key, "attempts", r.attempts)
+				return nil
+			}
+			return errNoRetrievalPeer
+		}
+		r.peer = id
+		r.sent[id] = time.Now()
+		r.attempts++
+		st.inflight++
+		s.mu.Unlock()
+
+		if err := s.request(id, r.key); err != nil {
+			log.Debug("retrieval scheduler: request failed", "peer", id, "key", r.key, "err", err)
+			s.mu.Lock()
+			st.inflight--
+			r.attempts--
+			s.mu.Unlock()
+			continue
+		}
+
+		s.mu.Lock()
+		if !r.done && r.peer == id {
+			r.timer = time.AfterFunc(s.timeout(st), func() { s.straggler(r, id) })
+		}
+		s.mu.Unlock()
+		return nil
+	}
+}
+
+// re-assigns a request the peer did not deliver in time
+func (s *retrievalScheduler) straggler(r *retrieval, id discover.NodeID) {
+	s.mu.Lock()
+	if r.done || r.peer != id {
+		s.mu.Unlock()
+		return
+	}
+	if st, ok := s.stats[id]; ok {
+		st.inflight--
+		st.measure(s.timeout(st))
+	}
+	r.peer = discover.NodeID{}
+	s.mu.Unlock()
+	if s.report != nil {
+		s.report(id, network.PeerEvent{Type: network.DeliveryFailure})
+	}
+	retrievalReassignCount.Inc(1)
+	log.Trace("retrieval scheduler: re-assigning straggler", "peer", id, "key", r.key)
+	s.dispatch(r)
+	s.pump()
+}
+
+// delivered is called when a chunk is delivered by a peer
+func (s *retrievalScheduler) delivered(id discover.NodeID, key storage.Key) {
+	s.mu.Lock()
+	r, ok := s.pending[string(key)]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.pending, string(key))
+	r.done = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	if st, ok := s.stats[r.peer]; ok && r.peer != (discover.NodeID{}) {
+		st.inflight--
+	}
+	var latency time.Duration
+	sent, asked := r.sent[id]
+	if asked {
+		latency = time.Since(sent)
+		if st, ok := s.stats[id]; ok {
+			st.measure(latency)
+		}
+	}
+	s.mu.Unlock()
+	if asked && s.report != nil {
+		s.report(id, network.PeerEvent{Type: network.DeliverySuccess, Latency: latency})
+	}
+	s.pump()
+}
+
+// dispatches the queued retrievals to peers with spare capacity
+func (s *retrievalScheduler) pump() {
+	s.mu.Lock()
+	queue := s.queue
+	s.queue = nil
+	s.mu.Unlock()
+	for _, r := range queue {
+		s.dispatch(r)
+	}
+}
+
+// peerRTT returns the estimated round trip time of a peer, 0 if not measured
+func (s *retrievalScheduler) peerRTT(id discover.NodeID) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.stats[id]; ok {
+		return st.rtt
+	}
+	return 0
+}
+
+// setPeerRTT sets the estimated round trip time of a peer, measured in a
+// previous connection
+func (s *retrievalScheduler) setPeerRTT(id discover.NodeID, rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.getStats(id).rtt = rtt
+}
+
+// removePeer forgets the statistics of a disconnected peer, its requests
+// are re-assigned when they time out
+func (s *retrievalScheduler) removePeer(id discover.NodeID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.stats, id)
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// records the retrieve requests sent by the scheduler
+type schedulerTester struct {
+	mu       sync.Mutex
+	requests map[discover.NodeID][]storage.Key
+	sentC    chan discover.NodeID
+}
+
+func (st *schedulerTester) request(id discover.NodeID, key storage.Key) error {
+	st.mu.Lock()
+	st.requests[id] = append(st.requests[id], key)
+	st.mu.Unlock()
+	st.sentC <- id
+	return nil
+}
+
+func (st *schedulerTester) count(id discover.NodeID) int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.requests[id])
+}
+
+func (st *schedulerTester) expectSent(t *testing.T, id discover.NodeID) {
+	select {
+	case got := <-st.sentC:
+		if got != id {
+			t.Fatalf("expected request to %v, got %v", id.TerminalString(), got.TerminalString())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected request to %v", id.TerminalString())
+	}
+}
+
+func (st *schedulerTester) expectNotSent(t *testing.T) {
+	select {
+	case got := <-st.sentC:
+		t.Fatalf("unexpected request to %v", got.TerminalString())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestRetrievalScheduler(t *testing.T) {
+	closest := discover.NodeID{1}
+	next := discover.NodeID{2}
+	st := &schedulerTester{
+		requests: make(map[discover.NodeID][]storage.Key),
+		sentC:    make(chan discover.NodeID, 16),
+	}
+	params := &RetrievalParams{
+		PeerCapacity:     2,
+		MaxPeerCapacity:  4,
+		TargetRTT:        100 * time.Millisecond,
+		StragglerTimeout: 200 * time.Millisecond,
+		MaxAttempts:      2,
+	}
+	s := newRetrievalScheduler(params, func(storage.Key) []discover.NodeID {
+		return []discover.NodeID{closest, next}
+	}, st.request)
+
+	keys := make([]storage.Key, 5)
+	for i := range keys {
+		keys[i] = storage.Key{byte(i + 1)}
+	}
+
+	// the capacity of the closest peer is used up first, then the next one,
+	// the last retrieval is queued
+	for _, key := range keys {
+		if err := s.schedule(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st.expectSent(t, closest)
+	st.expectSent(t, closest)
+	st.expectSent(t, next)
+	st.expectSent(t, next)
+	st.expectNotSent(t)
+
+	// a fast delivery frees the capacity for the queued retrieval and
+	// increases the capacity of the peer
+	s.delivered(closest, keys[0])
+	st.expectSent(t, closest)
+	s.delivered(closest, keys[1])
+	s.delivered(closest, keys[4])
+	s.mu.Lock()
+	capacity := s.capacity(s.stats[closest])
+	s.mu.Unlock()
+	if capacity != params.MaxPeerCapacity {
+		t.Fatalf("expected capacity %d of fast peer, got %d", params.MaxPeerCapacity, capacity)
+	}
+
+	// the retrievals of the next peer are stragglers and re-assigned to the
+	// closest peer, the first delivery wins
+	st.expectSent(t, closest)
+	st.expectSent(t, closest)
+	s.delivered(next, keys[2])
+	s.delivered(closest, keys[3])
+	if st.count(closest) != 5 || st.count(next) != 2 {
+		t.Fatalf("unexpected number of requests %d %d", st.count(closest), st.count(next))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) != 0 || len(s.queue) != 0 {
+		t.Fatalf("expected no pending retrievals, got %d pending %d queued", len(s.pending), len(s.queue))
+	}
+	for id, ps := range s.stats {
+		if ps.inflight != 0 {
+			t.Fatalf("expected no requests in flight to %v, got %d", id.TerminalString(), ps.inflight)
+		}
+	}
+}
+
+// retrievals are given up once MaxAttempts peers did not deliver, even if
+// other peers could be asked
+func TestRetrievalSchedulerMaxAttempts(t *testing.T) {
+	peers := []discover.NodeID{{1}, {2}, {3}}
+	st := &schedulerTester{
+		requests: make(map[discover.NodeID][]storage.Key),
+		sentC:    make(chan discover.NodeID, 16),
+	}
+	params := NewRetrievalParams()
+	params.StragglerTimeout = 50 * time.Millisecond
+	params.MaxAttempts = 2
+	s := newRetrievalScheduler(params, func(storage.Key) []discover.NodeID {
+		return peers
+	}, st.request)
+
+	if err := s.schedule(storage.Key{1}); err != nil {
+		t.Fatal(err)
+	}
+	st.expectSent(t, peers[0])
+	st.expectSent(t, peers[1])
+	st.expectNotSent(t)
+	time.Sleep(params.StragglerTimeout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) != 0 || len(s.queue) != 0 {
+		t.Fatalf("expected retrieval to be given up, got %d pending %d queued", len(s.pending), len(s.queue))
+	}
+	if st.count(peers[2]) != 0 {
+		t.Fatal("expected no request beyond the max attempts")
+	}
+}
+
+func TestRetrievalSchedulerNoPeer(t *testing.T) {
+	s := newRetrievalScheduler(NewRetrievalParams(), func(storage.Key) []discover.NodeID {
+		return nil
+	}, func(discover.NodeID, storage.Key) error {
+		return nil
+	})
+	if err := s.schedule(storage.Key{1}); err != errNoRetrievalPeer {
+		t.Fatalf("expected %v, got %v", errNoRetrievalPeer, err)
+	}
+	if len(s.pending) != 0 {
+		t.Fatal("expected retrieval to be dropped")
+	}
+}
@@ -54,20 +54,39 @@ type Registry struct {
 	clientMu       sync.RWMutex
 	serverMu       sync.RWMutex
 	peersMu        sync.RWMutex
+	peerStateMu    sync.Mutex // guards the peer states kept across reconnects, see savePeerState
 	serverFuncs    map[string]func(*Peer, string, bool) (Server, error)
 	clientFuncs    map[string]func(*Peer, string, bool) (Client, error)
 	peers          map[discover.NodeID]*Peer
 	delivery       *Delivery
 	intervalsStore state.Store
 	doRetrieve     bool
+	historyLimiter *rateLimiter
+	retrieval      *retrievalScheduler
+	authorizer     ChunkAuthorizer
+	authToken      []byte
+	quit           chan struct{} // closed on Stop or Close, ends the sync update loop
+	quitOnce       sync.Once
+	depthMu        sync.Mutex
+	depthCancel    func() // cancels the depth change subscription of the sync update loop
 }
 
+// minSyncHealthDelay is the least interval the health of the kademlia table
+// is checked at before syncing starts
+const minSyncHealthDelay = time.Second
+
 // RegistryOptions holds optional values for NewRegistry constructor.
 type RegistryOptions struct {
 	SkipCheck       bool
 	DoSync          bool
 	DoRetrieve      bool
 	SyncUpdateDelay time.Duration
+	SyncHealth      int              // health score the kademlia table must reach before syncing starts, see network.HealthScore
+	SyncHistoryRate int              // bandwidth limit of historical syncing in bytes per second, unlimited if 0
+	Retrieval       *RetrievalParams // schedules retrievals across peers if set, otherwise chunks are requested from the closest peer
+	EncryptTransit  bool             // retrieved chunks are delivered sealed to this node, see SealedChunkDeliveryMsg
+	Authorizer      ChunkAuthorizer  // authorizes the chunk requests of peers if set, see ChunkAuthorizer
+	AuthToken       []byte           // capability token presented to the peers if set, see AuthMsg
 }
 
 // NewRegistry is Streamer constructor
@@ -87,9 +106,21 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 		delivery:       delivery,
 		intervalsStore: intervalsStore,
 		doRetrieve:     options.DoRetrieve,
+		historyLimiter: newRateLimiter(options.SyncHistoryRate),
+		authorizer:     options.Authorizer,
+		authToken:      options.AuthToken,
+		quit:           make(chan struct{}),
 	}
 	streamer.api = NewAPI(streamer)
 	delivery.getPeer = streamer.getPeer
+	delivery.encryptTransit = options.EncryptTransit
+	if options.Retrieval != nil {
+		streamer.retrieval = newRetrievalScheduler(options.Retrieval, streamer.retrievalPeers, streamer.requestChunk)
+		delivery.delivered = streamer.retrieval.delivered
+		streamer.retrieval.report = func(id discover.NodeID, ev network.PeerEvent) {
+			delivery.report(streamer.getPeer(id), ev)
+		}
+	}
 	streamer.RegisterServerFunc(swarmChunkServerStreamName, func(_ *Peer, _ string, _ bool) (Server, error) {
 		return NewSwarmChunkServer(delivery.db), nil
 	})
@@ -103,9 +134,9 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 		// latestIntC function ensures that
 		//   - receiving from the in chan is not blocked by processing inside the for loop
 		// 	 - the latest int value is delivered to the loop after the processing is done
-		// In context of NeighbourhoodDepthC:
+		// In context of AddrCountC:
 		// after the syncing is done updating inside the loop, we do not need to update on the intermediate
-		// depth changes, only to the latest one
+		// address count changes, only to the latest one
 		latestIntC := func(in <-chan int) <-chan int {
 			out := make(chan int, 1)
 
@@ -125,11 +156,42 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 		}
 
 		go func() {
+			// returns false if the registry is stopped before the delay passed
+			wait := func(delay time.Duration) bool {
+				timer := time.NewTimer(delay)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+					return true
+				case <-streamer.quit:
+					return false
+				}
+			}
 			// wait for kademlia table to be healthy
-			time.Sleep(options.SyncUpdateDelay)
+			if !wait(options.SyncUpdateDelay) {
+				return
+			}
 
 			kad := streamer.delivery.overlay.(*network.Kademlia)
-			depthC := latestIntC(kad.NeighbourhoodDepthC())
+			healthDelay := options.SyncUpdateDelay
+			if healthDelay < minSyncHealthDelay {
+				healthDelay = minSyncHealthDelay
+			}
+			for options.SyncHealth > 0 {
+				score := kad.HealthScore().Score
+				if score >= options.SyncHealth {
+					break
+				}
+				log.Debug("Kademlia not healthy enough to start syncing", "score", score, "required", options.SyncHealth)
+				if !wait(healthDelay) {
+					return
+				}
+			}
+			// the depth changes are coalesced by the subscription
+			depthC, cancel := kad.SubscribeDepthChange()
+			if !streamer.setDepthCancel(cancel) {
+				return
+			}
 			addressBookSizeC := latestIntC(kad.AddrCountC())
 
 			// initial requests for syncing subscription to peers
@@ -161,6 +223,10 @@ func NewRegistry(addr *network.BzzAddr, delivery *Delivery, db *storage.DBAPI, i
 						// request for syncing subscription to new peers
 						streamer.updateSyncing()
 						break loop
+					case <-streamer.quit:
+						timer.Stop()
+						maxTimer.Stop()
+						return
 					case size := <-addressBookSizeC:
 						log.Trace("Kademlia address book size changed on depth change", "size", size)
 						// new peers has been added to kademlia,
@@ -324,9 +390,45 @@ func (r *Registry) Quit(peerId discover.NodeID, s Stream) error {
 }
 
 func (r *Registry) Retrieve(chunk *storage.Chunk) error {
+	if r.retrieval != nil {
+		return r.retrieval.schedule(chunk.Key)
+	}
 	return r.delivery.RequestFromPeers(chunk.Key[:], r.skipCheck)
 }
 
+// retrievalPeers returns the connected peers closest to the chunk
+func (r *Registry) retrievalPeers(key storage.Key) (ids []discover.NodeID) {
+	r.delivery.overlay.EachConn(key, 255, func(p network.OverlayConn, po int, nn bool) bool {
+		id := p.(network.Peer).ID()
+		if r.getPeer(id) != nil {
+			ids = append(ids, id)
+		}
+		return len(ids) < maxRetrievalCandidates
+	})
+	return ids
+}
+
+// requestChunk sends a retrieve request for the chunk to the peer
+func (r *Registry) requestChunk(id discover.NodeID, key storage.Key) error {
+	sp := r.getPeer(id)
+	if sp == nil {
+		return fmt.Errorf("peer not found %v", id)
+	}
+	var transitKey []byte
+	if r.delivery.encryptTransit {
+		var err error
+		if transitKey, err = r.delivery.transit.requestKey(key); err != nil {
+			return err
+		}
+	}
+	requestFromPeersEachCount.Inc(1)
+	return sp.SendPriority(&RetrieveRequestMsg{
+		Key:        key,
+		SkipCheck:  r.skipCheck,
+		TransitKey: transitKey,
+	}, Top)
+}
+
 func (r *Registry) NodeInfo() interface{} {
 	return nil
 }
@@ -336,9 +438,37 @@ func (r *Registry) PeerInfo(id discover.NodeID) interface{} {
 }
 
 func (r *Registry) Close() error {
+	r.stop()
 	return r.intervalsStore.Close()
 }
 
+// ends the sync update loop and cancels its depth change subscription
+func (r *Registry) stop() {
+	r.quitOnce.Do(func() { close(r.quit) })
+	r.depthMu.Lock()
+	defer r.depthMu.Unlock()
+	if r.depthCancel != nil {
+		r.depthCancel()
+		r.depthCancel = nil
+	}
+}
+
+// keeps the function cancelling the depth change subscription of the sync
+// update loop, it is called right away and false returned if the registry is
+// already stopped
+func (r *Registry) setDepthCancel(cancel func()) bool {
+	r.depthMu.Lock()
+	defer r.depthMu.Unlock()
+	select {
+	case <-r.quit:
+		cancel()
+		return false
+	default:
+	}
+	r.depthCancel = cancel
+	return true
+}
+
 func (r *Registry) getPeer(peerId discover.NodeID) *Peer {
 	r.peersMu.RLock()
 	defer r.peersMu.RUnlock()
@@ -354,10 +484,14 @@ func (r *Registry) setPeer(peer *Peer) {
 }
 
 func (r *Registry) deletePeer(peer *Peer) {
+	r.savePeerState(peer)
 	r.peersMu.Lock()
 	delete(r.peers, peer.ID())
 	metrics.GetOrRegisterGauge("registry.peers", nil).Update(int64(len(r.peers)))
 	r.peersMu.Unlock()
+	if r.retrieval != nil {
+		r.retrieval.removePeer(peer.ID())
+	}
 }
 
 func (r *Registry) peersCount() (c int) {
@@ -370,6 +504,14 @@ func (r *Registry) peersCount() (c int) {
 // Run protocol run function
 func (r *Registry) Run(p *network.BzzPeer) error {
 	sp := NewPeer(p.Peer, r)
+	sp.overlay = p.Over()
+	// the token is queued before any request can be sent to the peer
+	if r.authToken != nil {
+		if err := sp.SendPriority(&AuthMsg{Token: r.authToken}, Top); err != nil {
+			return err
+		}
+	}
+	r.loadPeerState(sp)
 	r.setPeer(sp)
 	defer r.deletePeer(sp)
 	defer close(sp.quit)
@@ -487,12 +629,18 @@ func (p *Peer) HandleMsg(msg interface{}) error {
 	case *RetrieveRequestMsg:
 		return p.streamer.delivery.handleRetrieveRequestMsg(p, msg)
 
+	case *SealedChunkDeliveryMsg:
+		return p.streamer.delivery.handleSealedChunkDeliveryMsg(p, msg)
+
 	case *RequestSubscriptionMsg:
 		return p.handleRequestSubscription(msg)
 
 	case *QuitMsg:
 		return p.handleQuitMsg(msg)
 
+	case *AuthMsg:
+		return p.handleAuthMsg(msg)
+
 	default:
 		return fmt.Errorf("unknown message type: %T", msg)
 	}
@@ -521,12 +669,18 @@ type client struct {
 	next      chan error
 	quit      chan struct{}
 
+	// index where a live session started, set once by the first live batch
+	sessionStart   uint64
+	sessionStarted uint32
+
 	intervalsKey   string
 	intervalsStore state.Store
 }
 
+// the intervals are keyed by the overlay address of the peer, so that they
+// are found again when the peer reconnects
 func peerStreamIntervalsKey(p *Peer, s Stream) string {
-	return p.ID().String() + s.String()
+	return fmt.Sprintf("%x", p.overlay) + s.String()
 }
 
 func (c client) AddInterval(start, end uint64) (err error) {
@@ -645,7 +799,7 @@ func (c *clientParams) clientCreated() {
 // Spec is the spec of the streamer protocol
 var Spec = &protocols.Spec{
 	Name:       "stream",
-	Version:    3,
+	Version:    5,
 	MaxMsgSize: 10 * 1024 * 1024,
 	Messages: []interface{}{
 		UnsubscribeMsg{},
@@ -658,6 +812,8 @@ var Spec = &protocols.Spec{
 		SubscribeErrorMsg{},
 		RequestSubscriptionMsg{},
 		QuitMsg{},
+		SealedChunkDeliveryMsg{},
+		AuthMsg{},
 	},
 }
 
@@ -691,6 +847,7 @@ func (r *Registry) Start(server *p2p.Server) error {
 }
 
 func (r *Registry) Stop() error {
+	r.stop()
 	return nil
 }
 
@@ -737,3 +894,24 @@ func (api *API) SubscribeStream(peerId discover.NodeID, s Stream, history *Range
 func (api *API) UnsubscribeStream(peerId discover.NodeID, s Stream) error {
 	return api.streamer.Unsubscribe(peerId, s)
 }
+
+// SyncProgress returns the historical syncing backlog per peer and bin
+func (api *API) SyncProgress() []*SyncProgress {
+	return api.streamer.SyncProgress()
+}
+
+// SetSyncHistoryRate limits the bandwidth of historical syncing to rate
+// bytes per second, 0 removes the limit
+func (api *API) SetSyncHistoryRate(rate int) error {
+	if rate < 0 {
+		return fmt.Errorf("invalid sync history rate %d", rate)
+	}
+	api.streamer.historyLimiter.setRate(rate)
+	return nil
+}
+
+// SyncHistoryRate returns the bandwidth limit of historical syncing in
+// bytes per second, 0 if unlimited
+func (api *API) SyncHistoryRate() int {
+	return api.streamer.historyLimiter.getRate()
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package testing
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/protocols"
+)
+
+// RecordCorpus records the messages sent with the protocols of the specs
+// from then on as the fuzzing corpus of the protocols, such as the messages
+// exchanged by the nodes of a simulation. Each message is written to
+// <dir>/<protocol>/corpus as a file of the message code followed by the RLP
+// encoded payload, the input of the go-fuzz harnesses of the protocols, so
+// that <dir>/<protocol> is the workdir of go-fuzz.
+func RecordCorpus(dir string, specs ...*protocols.Spec) error {
+	for _, spec := range specs {
+		corpus := filepath.Join(dir, spec.Name, "corpus")
+		if err := os.MkdirAll(corpus, 0755); err != nil {
+			return err
+		}
+		spec.Record = func(code uint64, payload []byte) {
+			if code > 0xff {
+				return
+			}
+			data := append([]byte{byte(code)}, payload...)
+			// identical messages are recorded once
+			path := filepath.Join(corpus, fmt.Sprintf("%x", crypto.Keccak256(data)))
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				log.Warn("cannot record corpus message", "path", path, "err", err)
+			}
+		}
+	}
+	return nil
+}
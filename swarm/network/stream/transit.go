@@ -0,0 +1,273 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+var (
+	// transitTTL is how long transit keys and the routes of sealed deliveries are kept
+	transitTTL = time.Minute
+	// transitRand is the randomness of sealing chunks
+	transitRand io.Reader = rand.Reader
+)
+
+var (
+	errInvalidTransitKey = errors.New("invalid transit key")
+
+	transitSealedCount    = metrics.NewRegisteredCounter("network.stream.transit.sealed", nil)
+	transitOpenedCount    = metrics.NewRegisteredCounter("network.stream.transit.opened", nil)
+	transitForwardedCount = metrics.NewRegisteredCounter("network.stream.transit.forwarded", nil)
+	transitDroppedCount   = metrics.NewRegisteredCounter("network.stream.transit.dropped", nil)
+)
+
+/*
+Transit encryption keeps the data of retrieved chunks from the relays
+forwarding them, in addition to the hop-by-hop encryption of devp2p.
+
+A node requesting a chunk with transit encryption adds an ephemeral public
+key to its retrieve request. Relays forward the request with the same key
+and remember which peers asked for it. The node which has the chunk seals the
+chunk data to the key with ECIES, and the sealed delivery travels back along
+the route of the request. Relays can neither read nor store it, only the
+requester can open it, after which the chunk is validated and stored like
+any other delivered chunk.
+
+Chunks pushed to their neighbourhood by syncing are stored by every node on
+the way, so these are not sealed.
+*/
+
+// SealedChunkDeliveryMsg is the protocol msg delivering the data of a chunk
+// sealed to the transit key of a retrieve request
+type SealedChunkDeliveryMsg struct {
+	Key        storage.Key
+	TransitKey []byte // public key the data is sealed to
+	Sealed     []byte // ECIES encrypted chunk data
+}
+
+// ephemeral key of a retrieve request of this node
+type transitKey struct {
+	prv *ecdsa.PrivateKey
+	pub []byte
+}
+
+// transit keeps the transit keys of the retrieve requests of the node and
+// the peers waiting for sealed deliveries forwarded by it
+type transit struct {
+	mu     sync.Mutex
+	keys   map[string]*transitKey       // by chunk key
+	routes map[string][]discover.NodeID // by chunk key and transit key
+}
+
+func newTransit() *transit {
+	return &transit{
+		keys:   make(map[string]*transitKey),
+		routes: make(map[string][]discover.NodeID),
+	}
+}
+
+// requestKey returns the transit key of a request for the chunk, the same
+// key is used until the chunk is delivered or the key expires
+func (t *transit) requestKey(key storage.Key) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if k, ok := t.keys[string(key)]; ok {
+		return k.pub, nil
+	}
+	prv, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	k := &transitKey{
+		prv: prv,
+		pub: crypto.FromECDSAPub(&prv.PublicKey),
+	}
+	t.keys[string(key)] = k
+	time.AfterFunc(transitTTL, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.keys[string(key)] == k {
+			delete(t.keys, string(key))
+		}
+	})
+	return k.pub, nil
+}
+
+// open returns the chunk data of a sealed delivery for a request of this
+// node, ok is false if the delivery is not for this node
+func (t *transit) open(msg *SealedChunkDeliveryMsg) (data []byte, ok bool, err error) {
+	t.mu.Lock()
+	k, ok := t.keys[string(msg.Key)]
+	if !ok || !bytes.Equal(k.pub, msg.TransitKey) {
+		t.mu.Unlock()
+		return nil, false, nil
+	}
+	delete(t.keys, string(msg.Key))
+	t.mu.Unlock()
+	data, err = ecies.ImportECDSA(k.prv).Decrypt(msg.Sealed, msg.Key, nil)
+	return data, true, err
+}
+
+// addRoute records that the peer waits for the sealed delivery, it returns
+// true if no other peer waits for it yet and the request must be forwarded
+func (t *transit) addRoute(key storage.Key, transitKey []byte, id discover.NodeID) bool {
+	route := string(key) + string(transitKey)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	peers, ok := t.routes[route]
+	for _, p := range peers {
+		if p == id {
+			return false
+		}
+	}
+	t.routes[route] = append(peers, id)
+	if !ok {
+		time.AfterFunc(transitTTL, func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(t.routes, route)
+		})
+	}
+	return !ok
+}
+
+// takeRoute returns and forgets the peers waiting for the sealed delivery
+func (t *transit) takeRoute(key storage.Key, transitKey []byte) []discover.NodeID {
+	route := string(key) + string(transitKey)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	peers := t.routes[route]
+	delete(t.routes, route)
+	return peers
+}
+
+// sealChunk encrypts the chunk data to the transit key of a request, the
+// chunk key is used as shared information so the sealed data cannot be
+// passed off as another chunk
+func sealChunk(key storage.Key, transitKey []byte, data []byte) ([]byte, error) {
+	pub := crypto.ToECDSAPub(transitKey)
+	if pub == nil || pub.X == nil {
+		return nil, errInvalidTransitKey
+	}
+	return ecies.Encrypt(transitRand, ecies.ImportECDSAPublic(pub), data, key, nil)
+}
+
+// deliverSealed sends the chunk data sealed to the transit key to the peer
+func (d *Delivery) deliverSealed(sp *Peer, key storage.Key, transitKey []byte, data []byte) error {
+	sealed, err := sealChunk(key, transitKey, data)
+	if err != nil {
+		return err
+	}
+	transitSealedCount.Inc(1)
+	return sp.SendPriority(&SealedChunkDeliveryMsg{
+		Key:        key,
+		TransitKey: transitKey,
+		Sealed:     sealed,
+	}, Top)
+}
+
+// handleTransitRequest serves a retrieve request with a transit key, the
+// chunk is delivered sealed if it is found locally, otherwise the request is
+// forwarded with the same key
+func (d *Delivery) handleTransitRequest(sp *Peer, req *RetrieveRequestMsg) error {
+	chunk, err := d.db.Get(req.Key)
+	if err == nil {
+		return d.deliverSealed(sp, chunk.Key, req.TransitKey, chunk.SData)
+	}
+	if err == storage.ErrFetching {
+		// the chunk is being retrieved by this node
+		go func() {
+			t := time.NewTimer(transitTTL)
+			defer t.Stop()
+			select {
+			case <-chunk.ReqC:
+			case <-t.C:
+				return
+			}
+			if err := d.deliverSealed(sp, chunk.Key, req.TransitKey, chunk.SData); err != nil {
+				log.Debug("sealed delivery failed", "peer", sp.ID(), "hash", chunk.Key, "err", err)
+			}
+		}()
+		return nil
+	}
+	if crypto.ToECDSAPub(req.TransitKey).X == nil {
+		return errInvalidTransitKey
+	}
+	if !d.transit.addRoute(req.Key, req.TransitKey, sp.ID()) {
+		return nil
+	}
+	if err := d.requestFromPeers(req.Key, true, req.TransitKey, sp.ID()); err != nil {
+		log.Debug("unable to forward sealed chunk request", "peer", sp.ID(), "hash", req.Key, "err", err)
+		d.transit.takeRoute(req.Key, req.TransitKey)
+	}
+	return nil
+}
+
+// handleSealedChunkDeliveryMsg opens a sealed delivery for a request of this
+// node, or forwards it to the peers which requested it through this node
+func (d *Delivery) handleSealedChunkDeliveryMsg(sp *Peer, msg *SealedChunkDeliveryMsg) error {
+	data, ok, err := d.transit.open(msg)
+	if ok {
+		if err != nil {
+			// the chunk is requested again from the scheduler or by the next request
+			log.Warn("cannot open sealed delivery", "peer", sp.ID(), "hash", msg.Key, "err", err)
+			return nil
+		}
+		if sp.authorizeStore(msg.Key) != nil {
+			return nil
+		}
+		transitOpenedCount.Inc(1)
+		d.receiveC <- &ChunkDeliveryMsg{
+			Key:   msg.Key,
+			SData: data,
+			peer:  sp,
+		}
+		return nil
+	}
+	peers := d.transit.takeRoute(msg.Key, msg.TransitKey)
+	if len(peers) == 0 {
+		transitDroppedCount.Inc(1)
+		log.Trace("unrequested sealed delivery", "peer", sp.ID(), "hash", msg.Key)
+		return nil
+	}
+	for _, id := range peers {
+		p := d.getPeer(id)
+		if p == nil {
+			continue
+		}
+		if err := p.SendPriority(msg, Top); err != nil {
+			log.Debug("cannot forward sealed delivery", "peer", id, "hash", msg.Key, "err", err)
+			continue
+		}
+		transitForwardedCount.Inc(1)
+	}
+	return nil
+}
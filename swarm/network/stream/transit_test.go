@@ -0,0 +1,201 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package stream
+
+import (
+	"bytes"
+	"io"
+	mrand "math/rand"
+	"testing"
+
+	p2ptest "github.com/ethereum/go-ethereum/p2p/testing"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// sealed chunks can only be opened with the transit key they are sealed to,
+// and only as the chunk they were sealed as
+func TestTransitSealChunk(t *testing.T) {
+	tr := newTransit()
+	key := storage.Key(hash0[:])
+	transitKey, err := tr.requestKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := tr.requestKey(key); !bytes.Equal(again, transitKey) {
+		t.Fatal("expected the same transit key for repeated requests")
+	}
+
+	data := []byte("chunk data")
+	sealed, err := sealChunk(key, transitKey, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, data) {
+		t.Fatal("expected sealed data not to contain the chunk data")
+	}
+	if _, err := sealChunk(key, []byte("bogus"), data); err != errInvalidTransitKey {
+		t.Fatalf("expected %v, got %v", errInvalidTransitKey, err)
+	}
+
+	// a delivery for another request is not opened
+	other, err := newTransit().requestKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := tr.open(&SealedChunkDeliveryMsg{Key: key, TransitKey: other, Sealed: sealed}); ok {
+		t.Fatal("expected delivery sealed to another key not to be opened")
+	}
+
+	// the data cannot be opened as another chunk
+	tr.keys[string(hash1[:])] = tr.keys[string(key)]
+	if _, ok, err := tr.open(&SealedChunkDeliveryMsg{Key: storage.Key(hash1[:]), TransitKey: transitKey, Sealed: sealed}); !ok || err == nil {
+		t.Fatalf("expected data sealed as another chunk not to open, got ok %v err %v", ok, err)
+	}
+
+	opened, ok, err := tr.open(&SealedChunkDeliveryMsg{Key: key, TransitKey: transitKey, Sealed: sealed})
+	if !ok || err != nil {
+		t.Fatalf("expected sealed data to open, got ok %v err %v", ok, err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Fatalf("expected %q, got %q", data, opened)
+	}
+	if _, ok, _ := tr.open(&SealedChunkDeliveryMsg{Key: key, TransitKey: transitKey, Sealed: sealed}); ok {
+		t.Fatal("expected transit key to be forgotten after delivery")
+	}
+}
+
+// requests with transit keys are answered with sealed deliveries, relayed
+// sealed deliveries are forwarded as they are
+func TestStreamerTransitExchange(t *testing.T) {
+	tester, streamer, localStore, teardown, err := newStreamerTester(t)
+	defer teardown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerID := tester.IDs[0]
+
+	// requests of this node carry a transit key
+	streamer.delivery.encryptTransit = true
+	key := storage.Key(hash0[:])
+	if err := streamer.delivery.RequestFromPeers(key, true); err != nil {
+		t.Fatal(err)
+	}
+	transitKey, err := streamer.delivery.transit.requestKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "RetrieveRequestMsg",
+		Expects: []p2ptest.Expect{
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:        key,
+					SkipCheck:  true,
+					TransitKey: transitKey,
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// chunks found locally are sealed to the transit key of the request
+	requester := newTransit()
+	key = storage.Key(hash1[:])
+	transitKey, err = requester.requestKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := storage.NewChunk(key, nil)
+	chunk.SData = hash1[:]
+	localStore.Put(chunk)
+	chunk.WaitToStore()
+
+	defer func(r io.Reader) { transitRand = r }(transitRand)
+	transitRand = mrand.New(mrand.NewSource(1))
+	sealed, err := sealChunk(key, transitKey, chunk.SData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transitRand = mrand.New(mrand.NewSource(1))
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "SealedChunkDeliveryMsg",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 5,
+				Msg: &RetrieveRequestMsg{
+					Key:        key,
+					TransitKey: transitKey,
+				},
+				Peer: peerID,
+			},
+		},
+		Expects: []p2ptest.Expect{
+			{
+				Code: 10,
+				Msg: &SealedChunkDeliveryMsg{
+					Key:        key,
+					TransitKey: transitKey,
+					Sealed:     sealed,
+				},
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, ok, err := requester.open(&SealedChunkDeliveryMsg{Key: key, TransitKey: transitKey, Sealed: sealed})
+	if !ok || err != nil || !bytes.Equal(data, chunk.SData) {
+		t.Fatalf("expected sealed delivery to open to the chunk data, got %x (ok %v err %v)", data, ok, err)
+	}
+
+	// sealed deliveries for peers which requested through this node are forwarded
+	key = storage.Key(hash2[:])
+	streamer.delivery.transit.addRoute(key, transitKey, peerID)
+	msg := &SealedChunkDeliveryMsg{
+		Key:        key,
+		TransitKey: transitKey,
+		Sealed:     []byte("sealed"),
+	}
+	err = tester.TestExchanges(p2ptest.Exchange{
+		Label: "forward SealedChunkDeliveryMsg",
+		Triggers: []p2ptest.Trigger{
+			{
+				Code: 10,
+				Msg:  msg,
+				Peer: peerID,
+			},
+		},
+		Expects: []p2ptest.Expect{
+			{
+				Code: 10,
+				Msg:  msg,
+				Peer: peerID,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers := streamer.delivery.transit.takeRoute(key, transitKey); len(peers) != 0 {
+		t.Fatalf("expected route to be forgotten after delivery, got %v", peers)
+	}
+}
@@ -0,0 +1,403 @@
+package pss
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	defaultAckTimeout         = time.Second * 4
+	defaultAckMaxRetries      = 3
+	defaultDeadLetterCapacity = 256
+	ackIDLength               = 16
+)
+
+var (
+	// prefix of the payload of messages sent with acknowledgement
+	ackPrefix = []byte("\x00pss:ack")
+
+	ErrNoDeadLetter = errors.New("no dead letter with this id")
+)
+
+// acknowledgement layer message, wrapped in the payload of a normal pss message
+type ackMsg struct {
+	ID      []byte
+	Ack     bool
+	Payload []byte
+}
+
+// ReliableMsg is a message sent with end-to-end acknowledgement
+type ReliableMsg struct {
+	ID         string        `json:"id"`
+	Topic      Topic         `json:"topic"`
+	Key        string        `json:"key"`
+	Asymmetric bool          `json:"asymmetric"`
+	Msg        hexutil.Bytes `json:"msg"`
+	Attempts   int           `json:"attempts"`
+	Updated    time.Time     `json:"updated"` // time of the last attempt, or of the failure for dead letters
+}
+
+// internal representation of a message awaiting acknowledgement
+type pendingAck struct {
+	*ReliableMsg
+	deadline time.Time
+}
+
+// Initialization parameters for the AckController
+//
+// Timeout: Time to wait for the acknowledgement of the first attempt,
+// doubled on every retry (default 4 s)
+//
+// MaxRetries: Amount of resends before a message is moved to the
+// dead-letter queue (default 3)
+//
+// DeadLetterCapacity: Max amount of dead letters kept, the oldest
+// are dropped first (default 256)
+type AckParams struct {
+	Timeout            time.Duration
+	MaxRetries         int
+	DeadLetterCapacity int
+}
+
+// Sane defaults for AckController initialization
+func NewAckParams() *AckParams {
+	return &AckParams{
+		Timeout:            defaultAckTimeout,
+		MaxRetries:         defaultAckMaxRetries,
+		DeadLetterCapacity: defaultDeadLetterCapacity,
+	}
+}
+
+// AckController adds optional end-to-end acknowledgements to pss messages.
+//
+// Messages sent through the controller are resent with exponential backoff
+// until the recipient acknowledges them, and are moved to a dead-letter
+// queue when all retries fail. Recipients deliver retried messages to the
+// topic handlers only once.
+//
+// Both ends must have an AckController attached, and the recipient must know
+// the address of the sender for the key and topic, as the acknowledgement is
+// sent back with the key the message was received with.
+type AckController struct {
+	pss                *Pss
+	lock               sync.Mutex
+	timeout            time.Duration
+	maxRetries         int
+	deadLetterCapacity int
+	pending            map[string]*pendingAck
+	deadLetters        []*ReliableMsg
+	received           map[string]time.Time // ids of received messages, to deliver retries only once
+	receivedTTL        time.Duration
+}
+
+// Attach AckController to pss node
+//
+// Must be called before starting the pss node service
+func SetAckController(pss *Pss, params *AckParams) error {
+	if params.Timeout <= 0 {
+		return fmt.Errorf("invalid ack timeout %v", params.Timeout)
+	}
+	ctrl := &AckController{
+		pss:                pss,
+		timeout:            params.Timeout,
+		maxRetries:         params.MaxRetries,
+		deadLetterCapacity: params.DeadLetterCapacity,
+		pending:            make(map[string]*pendingAck),
+		received:           make(map[string]time.Time),
+	}
+	// retries of a message can arrive until all its backoff periods are over
+	ctrl.receivedTTL = ctrl.timeout * time.Duration(1<<uint(ctrl.maxRetries+1))
+	api := &AckAPI{
+		namespace: "pss",
+		ctrl:      ctrl,
+	}
+	pss.addAPI(rpc.API{
+		Namespace: api.namespace,
+		Version:   "0.2",
+		Service:   api,
+		Public:    true,
+	})
+	pss.ackCtrl = ctrl
+	go ctrl.run(pss.quitC)
+	return nil
+}
+
+// SendSym sends a message using symmetric encryption and tracks its acknowledgement
+//
+// Returns the id of the message
+func (self *AckController) SendSym(symkeyid string, topic Topic, msg []byte) (string, error) {
+	return self.sendNew(symkeyid, topic, msg, false)
+}
+
+// SendAsym sends a message using asymmetric encryption and tracks its acknowledgement
+//
+// Returns the id of the message
+func (self *AckController) SendAsym(pubkeyid string, topic Topic, msg []byte) (string, error) {
+	return self.sendNew(pubkeyid, topic, msg, true)
+}
+
+func (self *AckController) sendNew(keyid string, topic Topic, msg []byte, asymmetric bool) (string, error) {
+	id := make([]byte, ackIDLength)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	rmsg := &ReliableMsg{
+		ID:         hexutil.Encode(id),
+		Topic:      topic,
+		Key:        keyid,
+		Asymmetric: asymmetric,
+		Msg:        msg,
+	}
+	if err := self.track(rmsg); err != nil {
+		return "", err
+	}
+	return rmsg.ID, nil
+}
+
+// sends the first attempt of the message and adds it to the pending messages
+func (self *AckController) track(rmsg *ReliableMsg) error {
+	now := time.Now()
+	rmsg.Attempts = 1
+	rmsg.Updated = now
+	if err := self.send(rmsg, false); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	self.pending[rmsg.ID] = &pendingAck{
+		ReliableMsg: rmsg,
+		deadline:    now.Add(self.timeout),
+	}
+	self.lock.Unlock()
+	log.Trace("pss ack pending", "id", rmsg.ID, "topic", rmsg.Topic)
+	return nil
+}
+
+// wraps the message or its acknowledgement and sends it with its key
+func (self *AckController) send(rmsg *ReliableMsg, ack bool) error {
+	id, err := hexutil.Decode(rmsg.ID)
+	if err != nil {
+		return err
+	}
+	amsg := &ackMsg{
+		ID:  id,
+		Ack: ack,
+	}
+	if !ack {
+		amsg.Payload = rmsg.Msg
+	}
+	payload, err := rlp.EncodeToBytes(amsg)
+	if err != nil {
+		return err
+	}
+	payload = append(append([]byte{}, ackPrefix...), payload...)
+	if rmsg.Asymmetric {
+		return self.pss.SendAsym(rmsg.Key, rmsg.Topic, payload)
+	}
+	return self.pss.SendSym(rmsg.Key, rmsg.Topic, payload)
+}
+
+// handle processes the acknowledgement layer of an incoming message.
+//
+// It returns the payload to pass on to the topic handlers, and false if
+// the message is an acknowledgement or a duplicate which must not be passed on.
+// Messages not sent with acknowledgement are passed on unchanged.
+func (self *AckController) handle(topic Topic, payload []byte, asymmetric bool, keyid string) ([]byte, bool) {
+	if !bytes.HasPrefix(payload, ackPrefix) {
+		return payload, true
+	}
+	var amsg ackMsg
+	if err := rlp.DecodeBytes(payload[len(ackPrefix):], &amsg); err != nil {
+		log.Warn("pss ack invalid message", "err", err)
+		return nil, false
+	}
+	id := hexutil.Encode(amsg.ID)
+
+	if amsg.Ack {
+		self.lock.Lock()
+		delete(self.pending, id)
+		self.lock.Unlock()
+		log.Trace("pss ack received", "id", id)
+		return nil, false
+	}
+
+	// duplicates are acknowledged again, as the previous ack may have been lost
+	ack := &ReliableMsg{
+		ID:         id,
+		Topic:      topic,
+		Key:        keyid,
+		Asymmetric: asymmetric,
+	}
+	if err := self.send(ack, true); err != nil {
+		log.Warn("pss ack send failed", "id", id, "err", err)
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, ok := self.received[id]; ok {
+		log.Trace("pss ack duplicate", "id", id)
+		return nil, false
+	}
+	self.received[id] = time.Now()
+	return amsg.Payload, true
+}
+
+// resends the messages whose acknowledgement timed out until the controller is stopped
+func (self *AckController) run(quitC chan struct{}) {
+	ticker := time.NewTicker(self.timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			self.retry(now)
+		case <-quitC:
+			return
+		}
+	}
+}
+
+// resends the pending messages whose deadline passed, moves the ones
+// without retries left to the dead-letter queue and expires received ids
+func (self *AckController) retry(now time.Time) {
+	var resend []*ReliableMsg
+	self.lock.Lock()
+	for id, p := range self.pending {
+		if now.Before(p.deadline) {
+			continue
+		}
+		if p.Attempts > self.maxRetries {
+			delete(self.pending, id)
+			p.Updated = now
+			self.addDeadLetter(p.ReliableMsg)
+			log.Debug("pss ack failed, moved to dead letters", "id", id, "attempts", p.Attempts)
+			continue
+		}
+		p.deadline = now.Add(self.timeout * time.Duration(1<<uint(p.Attempts)))
+		p.Attempts++
+		p.Updated = now
+		resend = append(resend, p.ReliableMsg)
+	}
+	for id, t := range self.received {
+		if now.Sub(t) > self.receivedTTL {
+			delete(self.received, id)
+		}
+	}
+	self.lock.Unlock()
+
+	for _, rmsg := range resend {
+		log.Trace("pss ack retry", "id", rmsg.ID, "attempt", rmsg.Attempts)
+		if err := self.send(rmsg, false); err != nil {
+			log.Warn("pss ack retry failed", "id", rmsg.ID, "err", err)
+		}
+	}
+}
+
+// must be called with the lock held
+func (self *AckController) addDeadLetter(rmsg *ReliableMsg) {
+	self.deadLetters = append(self.deadLetters, rmsg)
+	if over := len(self.deadLetters) - self.deadLetterCapacity; over > 0 {
+		self.deadLetters = self.deadLetters[over:]
+	}
+}
+
+// Pending returns the messages awaiting acknowledgement
+func (self *AckController) Pending() []*ReliableMsg {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	msgs := make([]*ReliableMsg, 0, len(self.pending))
+	for _, p := range self.pending {
+		rmsg := *p.ReliableMsg
+		msgs = append(msgs, &rmsg)
+	}
+	return msgs
+}
+
+// DeadLetters returns the messages which were not acknowledged, oldest first
+func (self *AckController) DeadLetters() []*ReliableMsg {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	msgs := make([]*ReliableMsg, len(self.deadLetters))
+	for i, d := range self.deadLetters {
+		rmsg := *d
+		msgs[i] = &rmsg
+	}
+	return msgs
+}
+
+// RetryDeadLetter removes a message from the dead-letter queue and sends it
+// again with a fresh set of retries
+func (self *AckController) RetryDeadLetter(id string) error {
+	self.lock.Lock()
+	var rmsg *ReliableMsg
+	for i, d := range self.deadLetters {
+		if d.ID == id {
+			rmsg = d
+			self.deadLetters = append(self.deadLetters[:i], self.deadLetters[i+1:]...)
+			break
+		}
+	}
+	self.lock.Unlock()
+	if rmsg == nil {
+		return ErrNoDeadLetter
+	}
+	return self.track(rmsg)
+}
+
+// ClearDeadLetters empties the dead-letter queue
+//
+// Returns the amount of messages removed
+func (self *AckController) ClearDeadLetters() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	count := len(self.deadLetters)
+	self.deadLetters = nil
+	return count
+}
+
+// Additional pss API methods for messages with acknowledgement
+type AckAPI struct {
+	namespace string
+	ctrl      *AckController
+}
+
+// Send a symmetrically encrypted message and resend it until it is acknowledged
+//
+// Returns the id of the message
+func (self *AckAPI) SendSymWithAck(symkeyid string, topic Topic, msg hexutil.Bytes) (string, error) {
+	return self.ctrl.SendSym(symkeyid, topic, msg[:])
+}
+
+// Send an asymmetrically encrypted message and resend it until it is acknowledged
+//
+// Returns the id of the message
+func (self *AckAPI) SendAsymWithAck(pubkeyid string, topic Topic, msg hexutil.Bytes) (string, error) {
+	return self.ctrl.SendAsym(pubkeyid, topic, msg[:])
+}
+
+// Returns the messages awaiting acknowledgement
+func (self *AckAPI) GetPendingAcks() []*ReliableMsg {
+	return self.ctrl.Pending()
+}
+
+// Returns the messages which were not acknowledged after all retries
+func (self *AckAPI) GetDeadLetters() []*ReliableMsg {
+	return self.ctrl.DeadLetters()
+}
+
+// Send a message from the dead-letter queue again
+func (self *AckAPI) RetryDeadLetter(id string) error {
+	return self.ctrl.RetryDeadLetter(id)
+}
+
+// Empty the dead-letter queue
+func (self *AckAPI) ClearDeadLetters() int {
+	return self.ctrl.ClearDeadLetters()
+}
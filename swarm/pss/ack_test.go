@@ -0,0 +1,190 @@
+package pss
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/swarm/network"
+)
+
+// passes messages between two pss nodes, optionally dropping some of them
+type ackTestRelay struct {
+	lock      sync.Mutex
+	dropFromA int  // amount of messages from a to drop
+	dropFromB int  // amount of messages from b to drop
+	blackhole bool // drop all messages
+}
+
+func (r *ackTestRelay) drop(fromA bool) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.blackhole {
+		return true
+	}
+	if fromA && r.dropFromA > 0 {
+		r.dropFromA--
+		return true
+	}
+	if !fromA && r.dropFromB > 0 {
+		r.dropFromB--
+		return true
+	}
+	return false
+}
+
+func (r *ackTestRelay) run(a, b *Pss, quitC chan struct{}) {
+	for {
+		select {
+		case msg := <-a.outbox:
+			if !r.drop(true) {
+				b.handlePssMsg(msg)
+			}
+		case msg := <-b.outbox:
+			if !r.drop(false) {
+				a.handlePssMsg(msg)
+			}
+		case <-quitC:
+			return
+		}
+	}
+}
+
+func newAckTestPss(t *testing.T) *Pss {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := NewPss(network.NewKademlia(network.RandomAddr().Over(), network.NewKadParams()), NewPssParams().WithPrivateKey(privkey), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAckController(ps, &AckParams{
+		Timeout:            time.Millisecond * 100,
+		MaxRetries:         2,
+		DeadLetterCapacity: 8,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return ps
+}
+
+// test that lost messages and acknowledgements are retried, that retries are
+// delivered only once, and that messages without acknowledgement end up in the
+// dead-letter queue
+func TestAck(t *testing.T) {
+	a := newAckTestPss(t)
+	defer a.Stop()
+	b := newAckTestPss(t)
+	defer b.Stop()
+
+	topic := BytesToTopic([]byte("foo:42"))
+	addrA := PssAddress(a.BaseAddr())
+	addrB := PssAddress(b.BaseAddr())
+	key := network.RandomAddr().Over()
+	keyidA, err := a.SetSymmetricKey(key, topic, &addrB, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.SetSymmetricKey(key, topic, &addrA, true); err != nil {
+		t.Fatal(err)
+	}
+
+	receivedC := make(chan string, 16)
+	b.Register(&topic, func(msg []byte, p *p2p.Peer, asymmetric bool, keyid string) error {
+		receivedC <- string(msg)
+		return nil
+	})
+
+	relay := &ackTestRelay{}
+	quitC := make(chan struct{})
+	defer close(quitC)
+	go relay.run(a, b, quitC)
+
+	expectDelivery := func(msg string) {
+		select {
+		case got := <-receivedC:
+			if got != msg {
+				t.Fatalf("expected message %q, got %q", msg, got)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("message %q not delivered", msg)
+		}
+	}
+	expectAcked := func() {
+		for i := 0; i < 100; i++ {
+			if len(a.ackCtrl.Pending()) == 0 {
+				return
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		t.Fatalf("messages still pending: %v", a.ackCtrl.Pending())
+	}
+	expectNoDelivery := func() {
+		select {
+		case got := <-receivedC:
+			t.Fatalf("unexpected delivery of %q", got)
+		case <-time.After(time.Millisecond * 300):
+		}
+	}
+
+	// the first attempt is lost
+	relay.lock.Lock()
+	relay.dropFromA = 1
+	relay.lock.Unlock()
+	if _, err := a.ackCtrl.SendSym(keyidA, topic, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	expectDelivery("one")
+	expectAcked()
+	expectNoDelivery()
+
+	// the acknowledgement is lost, the retry must not be delivered again
+	relay.lock.Lock()
+	relay.dropFromB = 1
+	relay.lock.Unlock()
+	if _, err := a.ackCtrl.SendSym(keyidA, topic, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	expectDelivery("two")
+	expectAcked()
+	expectNoDelivery()
+
+	// nothing gets through
+	relay.lock.Lock()
+	relay.blackhole = true
+	relay.lock.Unlock()
+	id, err := a.ackCtrl.SendSym(keyidA, topic, []byte("three"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dead []*ReliableMsg
+	for i := 0; i < 100 && len(dead) == 0; i++ {
+		time.Sleep(time.Millisecond * 20)
+		dead = a.ackCtrl.DeadLetters()
+	}
+	if len(dead) != 1 || dead[0].ID != id || dead[0].Attempts != 3 || string(dead[0].Msg) != "three" {
+		t.Fatalf("unexpected dead letters %v", dead)
+	}
+	if len(a.ackCtrl.Pending()) != 0 {
+		t.Fatalf("dead letter still pending")
+	}
+
+	// retry the dead letter when the connection is back
+	relay.lock.Lock()
+	relay.blackhole = false
+	relay.lock.Unlock()
+	if err := a.ackCtrl.RetryDeadLetter(id); err != nil {
+		t.Fatal(err)
+	}
+	expectDelivery("three")
+	expectAcked()
+	if len(a.ackCtrl.DeadLetters()) != 0 {
+		t.Fatalf("expected empty dead-letter queue")
+	}
+	if err := a.ackCtrl.RetryDeadLetter(id); err != ErrNoDeadLetter {
+		t.Fatalf("expected %v, got %v", ErrNoDeadLetter, err)
+	}
+}
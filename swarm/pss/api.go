@@ -147,3 +147,13 @@ func (pssapi *API) GetPeerTopics(pubkeyhex string) ([]Topic, error) {
 func (pssapi *API) GetPeerAddress(pubkeyhex string, topic Topic) (PssAddress, error) {
 	return pssapi.Pss.getPeerAddress(pubkeyhex, topic)
 }
+
+// Returns the routing statistics of the node
+func (pssapi *API) GetRoutingStats() RoutingStats {
+	return pssapi.Pss.RoutingStats()
+}
+
+// Returns the routing traces of the most recent messages, if the node runs in debug mode
+func (pssapi *API) GetRoutingTraces() []*RoutingTrace {
+	return pssapi.Pss.RoutingTraces()
+}
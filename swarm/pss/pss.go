@@ -36,7 +36,7 @@ const (
 	defaultCleanInterval       = time.Second * 60 * 10
 	defaultOutboxCapacity      = 10000
 	pssProtocolName            = "pss"
-	pssVersion                 = 1
+	pssVersion                 = 2
 	hasherCount                = 8
 )
 
@@ -49,6 +49,7 @@ var (
 // and mailbox implementation
 type pssCacheEntry struct {
 	expiresAt time.Time
+	relayed   bool // the message was originated or forwarded by this node
 }
 
 // abstraction to enable access to p2p.protocols.Peer.Send
@@ -73,7 +74,9 @@ type PssParams struct {
 	CacheTTL            time.Duration
 	privateKey          *ecdsa.PrivateKey
 	SymKeyCacheCapacity int
-	AllowRaw            bool // If true, enables sending and receiving messages without builtin pss encryption
+	AllowRaw            bool  // If true, enables sending and receiving messages without builtin pss encryption
+	MaxHops             uint8 // Messages which travelled this many hops are not forwarded any further, 0 is unlimited
+	Debug               bool  // If true, records routing traces of the most recent messages
 }
 
 type EnsClient struct {
@@ -87,6 +90,7 @@ func NewPssParams() *PssParams {
 		MsgTTL:              defaultMsgTTL,
 		CacheTTL:            defaultDigestCacheTTL,
 		SymKeyCacheCapacity: defaultSymKeyCacheCapacity,
+		MaxHops:             defaultMaxHops,
 	}
 }
 
@@ -115,6 +119,8 @@ type Pss struct {
 	paddingByteSize int
 	capstring       string
 	outbox          chan *PssMsg
+	maxHops         uint8
+	routing         *routingDiag // routing statistics and traces
 
 	// keys and peers
 	pubKeyPool                 map[string]map[Topic]*pssPeer // mapping of hex public keys to peer address by topic.
@@ -130,6 +136,7 @@ type Pss struct {
 	handlersMu sync.RWMutex
 	allowRaw   bool
 	hashPool   sync.Pool
+	ackCtrl    *AckController // optional end-to-end acknowledgements, see SetAckController
 
 	// process
 	quitC chan struct{}
@@ -165,6 +172,8 @@ func NewPss(k network.Overlay, params *PssParams, ensClient *EnsClient) (*Pss, e
 		paddingByteSize: defaultPaddingByteSize,
 		capstring:       cap.String(),
 		outbox:          make(chan *PssMsg, defaultOutboxCapacity),
+		maxHops:         params.MaxHops,
+		routing:         newRoutingDiag(params.MaxHops, params.Debug, defaultTraceCapacity),
 
 		pubKeyPool:                 make(map[string]map[Topic]*pssPeer),
 		symKeyPool:                 make(map[string]map[Topic]*pssPeer),
@@ -361,11 +370,17 @@ func (self *Pss) handlePssMsg(msg interface{}) error {
 		log.Trace(fmt.Sprintf("pss filtered expired message FROM %x TO %x", self.Overlay.BaseAddr(), common.ToHex(pssmsg.To)))
 		return nil
 	}
-	if self.checkFwdCache(pssmsg) {
+	digest := self.digest(pssmsg)
+	if entry, ok := self.getFwdCache(digest); ok {
 		log.Trace(fmt.Sprintf("pss relay block-cache match (process): FROM %x TO %x", self.Overlay.BaseAddr(), common.ToHex(pssmsg.To)))
+		if entry.relayed {
+			log.Debug("pss forwarding loop detected", "digest", common.ToHex(digest[:]), "hops", pssmsg.Hops)
+		}
+		self.routing.duplicate(digest, pssmsg, entry.relayed)
 		return nil
 	}
 	self.addFwdCache(pssmsg)
+	self.routing.received(digest, pssmsg)
 
 	if !self.isSelfPossibleRecipient(pssmsg) {
 		log.Trace("pss was for someone else :'( ... forwarding", "pss", common.ToHex(self.BaseAddr()))
@@ -418,7 +433,14 @@ func (self *Pss) process(pssmsg *PssMsg) error {
 			return err
 		}
 	}
-	self.executeHandlers(psstopic, recvmsg.Payload, from, asymmetric, keyid)
+	payload := recvmsg.Payload
+	if self.ackCtrl != nil {
+		var ok bool
+		if payload, ok = self.ackCtrl.handle(psstopic, payload, asymmetric, keyid); !ok {
+			return nil
+		}
+	}
+	self.executeHandlers(psstopic, payload, from, asymmetric, keyid)
 
 	return nil
 
@@ -705,6 +727,7 @@ func (self *Pss) SendRaw(msg []byte, address PssAddress) error {
 		},
 	}
 	self.addFwdCache(pssmsg)
+	self.setFwdCacheRelayed(self.digest(pssmsg))
 	return self.enqueue(pssmsg)
 }
 
@@ -805,6 +828,19 @@ func (self *Pss) send(to []byte, topic Topic, msg []byte, asymmetric bool, key [
 // The recipient address can be of any length, and the byte slice will be matched to the MSB slice
 // of the peer address of the equivalent length.
 func (self *Pss) forward(msg *PssMsg) error {
+	digest := self.digest(msg)
+
+	// bound the damage of mis-routing
+	if self.maxHops > 0 && msg.Hops >= self.maxHops {
+		log.Debug("pss hop limit reached, not forwarding", "digest", common.ToHex(digest[:]), "hops", msg.Hops)
+		self.routing.dropped(digest, msg)
+		return nil
+	}
+
+	// the forwarded message counts the hop to the peer
+	fwdmsg := *msg
+	fwdmsg.Hops++
+
 	to := make([]byte, addressLength)
 	copy(to[:len(msg.To)], msg.To)
 
@@ -841,11 +877,12 @@ func (self *Pss) forward(msg *PssMsg) error {
 		self.fwdPoolMu.RUnlock()
 
 		// attempt to send the message
-		err := pp.Send(msg)
+		err := pp.Send(&fwdmsg)
 		if err != nil {
 			return true
 		}
 		sent++
+		self.routing.forwarded(digest, msg, op.Address())
 		log.Trace(fmt.Sprintf("%v: successfully forwarded", sendMsg))
 
 		// continue forwarding if:
@@ -876,6 +913,9 @@ func (self *Pss) forward(msg *PssMsg) error {
 
 	// cache the message
 	self.addFwdCache(msg)
+	if sent > 0 {
+		self.setFwdCacheRelayed(digest)
+	}
 	return nil
 }
 
@@ -913,18 +953,34 @@ func (self *Pss) addFwdCache(msg *PssMsg) error {
 
 // check if message is in the cache
 func (self *Pss) checkFwdCache(msg *PssMsg) bool {
+	_, ok := self.getFwdCache(self.digest(msg))
+	return ok
+}
+
+// get the unexpired cache entry of a message digest
+func (self *Pss) getFwdCache(digest pssDigest) (pssCacheEntry, bool) {
 	self.fwdCacheMu.Lock()
 	defer self.fwdCacheMu.Unlock()
 
-	digest := self.digest(msg)
 	entry, ok := self.fwdCache[digest]
 	if ok {
 		if entry.expiresAt.After(time.Now()) {
 			log.Trace(fmt.Sprintf("unexpired cache for digest %x", digest))
-			return true
+			return entry, true
 		}
 	}
-	return false
+	return pssCacheEntry{}, false
+}
+
+// mark a cached message as originated or forwarded by this node, so
+// that receiving it again is detected as a forwarding loop
+func (self *Pss) setFwdCacheRelayed(digest pssDigest) {
+	self.fwdCacheMu.Lock()
+	defer self.fwdCacheMu.Unlock()
+	if entry, ok := self.fwdCache[digest]; ok {
+		entry.relayed = true
+		self.fwdCache[digest] = entry
+	}
 }
 
 // Digest of message
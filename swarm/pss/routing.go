@@ -0,0 +1,159 @@
+package pss
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	defaultMaxHops       = 64
+	defaultTraceCapacity = 1024
+)
+
+// RoutingStats counts the messages routed by the pss node
+//
+// Duplicates are messages received again while still in the forward cache.
+// Loops are the duplicates of messages this node already originated or
+// forwarded, which means the message came back around.
+type RoutingStats struct {
+	Received       uint64 `json:"received"`
+	Forwarded      uint64 `json:"forwarded"` // sends to peers, a message can be forwarded to several peers
+	Duplicates     uint64 `json:"duplicates"`
+	Loops          uint64 `json:"loops"`
+	HopLimitDrops  uint64 `json:"hopLimitDrops"`
+	MaxHopsSeen    uint8  `json:"maxHopsSeen"`
+	MaxHopsAllowed uint8  `json:"maxHopsAllowed"`
+}
+
+// RoutingTrace records how a single message was routed by the pss node
+type RoutingTrace struct {
+	Digest     hexutil.Bytes   `json:"digest"`
+	To         PssAddress      `json:"to"`
+	Hops       uint8           `json:"hops"` // hops travelled when first received
+	FirstSeen  time.Time       `json:"firstSeen"`
+	Forwarded  []hexutil.Bytes `json:"forwarded"` // overlay addresses of the peers the message was sent to
+	Duplicates int             `json:"duplicates"`
+	Loops      int             `json:"loops"`
+	Dropped    bool            `json:"dropped"` // not forwarded since the hop limit was reached
+}
+
+// collects routing statistics, and in debug mode the routing traces of the
+// most recent messages
+type routingDiag struct {
+	lock     sync.Mutex
+	stats    RoutingStats
+	debug    bool
+	traces   map[pssDigest]*RoutingTrace
+	order    []pssDigest // digests of the traces, oldest first
+	capacity int
+}
+
+func newRoutingDiag(maxHops uint8, debug bool, capacity int) *routingDiag {
+	return &routingDiag{
+		stats: RoutingStats{
+			MaxHopsAllowed: maxHops,
+		},
+		debug:    debug,
+		traces:   make(map[pssDigest]*RoutingTrace),
+		capacity: capacity,
+	}
+}
+
+// must be called with the lock held
+func (self *routingDiag) trace(digest pssDigest, msg *PssMsg) *RoutingTrace {
+	if !self.debug {
+		return nil
+	}
+	if t, ok := self.traces[digest]; ok {
+		return t
+	}
+	t := &RoutingTrace{
+		Digest:    hexutil.Bytes(digest[:]),
+		To:        PssAddress(msg.To),
+		Hops:      msg.Hops,
+		FirstSeen: time.Now(),
+	}
+	self.traces[digest] = t
+	self.order = append(self.order, digest)
+	if len(self.order) > self.capacity {
+		delete(self.traces, self.order[0])
+		self.order = self.order[1:]
+	}
+	return t
+}
+
+func (self *routingDiag) received(digest pssDigest, msg *PssMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stats.Received++
+	if msg.Hops > self.stats.MaxHopsSeen {
+		self.stats.MaxHopsSeen = msg.Hops
+	}
+	self.trace(digest, msg)
+}
+
+func (self *routingDiag) duplicate(digest pssDigest, msg *PssMsg, loop bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stats.Duplicates++
+	if loop {
+		self.stats.Loops++
+	}
+	if t := self.trace(digest, msg); t != nil {
+		t.Duplicates++
+		if loop {
+			t.Loops++
+		}
+	}
+}
+
+func (self *routingDiag) forwarded(digest pssDigest, msg *PssMsg, peer []byte) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stats.Forwarded++
+	if t := self.trace(digest, msg); t != nil {
+		t.Forwarded = append(t.Forwarded, hexutil.Bytes(peer))
+	}
+}
+
+func (self *routingDiag) dropped(digest pssDigest, msg *PssMsg) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.stats.HopLimitDrops++
+	if t := self.trace(digest, msg); t != nil {
+		t.Dropped = true
+	}
+}
+
+func (self *routingDiag) getStats() RoutingStats {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.stats
+}
+
+// returns copies of the traces, oldest first
+func (self *routingDiag) getTraces() []*RoutingTrace {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	traces := make([]*RoutingTrace, 0, len(self.order))
+	for _, digest := range self.order {
+		t := *self.traces[digest]
+		t.Forwarded = append([]hexutil.Bytes{}, t.Forwarded...)
+		traces = append(traces, &t)
+	}
+	return traces
+}
+
+// Returns the routing statistics of the node
+func (self *Pss) RoutingStats() RoutingStats {
+	return self.routing.getStats()
+}
+
+// Returns the routing traces of the most recent messages
+//
+// Traces are only recorded if the node runs with PssParams.Debug
+func (self *Pss) RoutingTraces() []*RoutingTrace {
+	return self.routing.getTraces()
+}
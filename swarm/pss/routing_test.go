@@ -0,0 +1,109 @@
+package pss
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/network"
+	whisper "github.com/ethereum/go-ethereum/whisper/whisperv5"
+)
+
+// test duplicate and loop detection, the hop limit and routing traces
+func TestRoutingDiagnostics(t *testing.T) {
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := NewPssParams().WithPrivateKey(privkey)
+	params.AllowRaw = true
+	params.MaxHops = 4
+	params.Debug = true
+	ps, err := NewPss(network.NewKademlia(network.RandomAddr().Over(), network.NewKadParams()), params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an address which is not ours
+	to := make([]byte, addressLength)
+	copy(to, ps.BaseAddr())
+	to[0] ^= 0xff
+
+	// our own message coming back is a loop
+	if err := ps.SendRaw([]byte("foo"), to); err != nil {
+		t.Fatal(err)
+	}
+	sent := <-ps.outbox
+	looped := *sent
+	looped.Hops = 3
+	if err := ps.handlePssMsg(&looped); err != nil {
+		t.Fatal(err)
+	}
+	stats := ps.RoutingStats()
+	if stats.Received != 0 || stats.Duplicates != 1 || stats.Loops != 1 {
+		t.Fatalf("expected a looped duplicate, got %+v", stats)
+	}
+
+	// a message for someone else is forwarded, and suppressed when received again
+	msg := &PssMsg{
+		To:     to,
+		Expire: uint32(time.Now().Add(time.Minute).Unix()),
+		Hops:   2,
+		Payload: &whisper.Envelope{
+			Topic: whisper.TopicType(rawTopic),
+			Data:  []byte("bar"),
+		},
+	}
+	if err := ps.handlePssMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	if queued := <-ps.outbox; queued != msg {
+		t.Fatalf("expected message to be queued for forwarding")
+	}
+	if err := ps.handlePssMsg(msg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ps.outbox:
+		t.Fatal("duplicate should not be queued for forwarding")
+	default:
+	}
+	stats = ps.RoutingStats()
+	if stats.Received != 1 || stats.Duplicates != 2 || stats.Loops != 1 || stats.MaxHopsSeen != 2 {
+		t.Fatalf("unexpected stats after duplicate %+v", stats)
+	}
+
+	// messages at the hop limit are not forwarded
+	limited := *msg
+	limited.Payload = &whisper.Envelope{
+		Topic: whisper.TopicType(rawTopic),
+		Data:  []byte("baz"),
+	}
+	limited.Hops = params.MaxHops
+	if err := ps.forward(&limited); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ps.outbox:
+		t.Fatal("message over the hop limit should not be queued again")
+	default:
+	}
+	if stats = ps.RoutingStats(); stats.HopLimitDrops != 1 {
+		t.Fatalf("expected one hop limit drop, got %+v", stats)
+	}
+
+	traces := ps.RoutingTraces()
+	if len(traces) != 3 {
+		t.Fatalf("expected 3 traces, got %d", len(traces))
+	}
+	if traces[0].Loops != 1 {
+		t.Fatalf("expected loop in first trace, got %+v", traces[0])
+	}
+	if !bytes.Equal(traces[1].To, to) || traces[1].Hops != 2 || traces[1].Duplicates != 1 || traces[1].Loops != 0 {
+		t.Fatalf("unexpected second trace %+v", traces[1])
+	}
+	if !traces[2].Dropped {
+		t.Fatalf("expected third trace to be dropped, got %+v", traces[2])
+	}
+}
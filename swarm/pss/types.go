@@ -68,10 +68,14 @@ func (a *PssAddress) UnmarshalJSON(input []byte) error {
 type pssDigest [digestLength]byte
 
 // Encapsulates messages transported over pss.
+//
+// Hops counts the nodes the message was forwarded by. It is not part of the
+// digest, so the same message arriving over different paths is recognized.
 type PssMsg struct {
 	To      []byte
 	Expire  uint32
 	Payload *whisper.Envelope
+	Hops    uint8
 }
 
 // serializes the message for use in cache
@@ -88,7 +92,7 @@ func (msg *PssMsg) serialize() []byte {
 
 // String representation of PssMsg
 func (self *PssMsg) String() string {
-	return fmt.Sprintf("PssMsg: Recipient: %x", common.ToHex(self.To))
+	return fmt.Sprintf("PssMsg: Recipient: %x, Hops: %d", common.ToHex(self.To), self.Hops)
 }
 
 // Signature for a message handler function for a PssMsg
@@ -554,6 +554,117 @@ func (self *LazyChunkReader) Read(b []byte) (read int, err error) {
 	return
 }
 
+// pools the slices of the children retrieved by LazyChunkReader.WriteTo
+var childChunksPool = sync.Pool{
+	New: func() interface{} {
+		return make([]ChunkData, 0, DefaultChunkSize/KeyLength)
+	},
+}
+
+// WriteTo writes the data from the current offset to w, see io.WriterTo.
+//
+// The data of the leaf chunks is written straight from the chunk data
+// returned by the getter, without copying it to the buffer of the caller like
+// Read, so w must neither modify nor retain the slices it is given. The
+// children of a branching chunk are retrieved concurrently, then written in
+// order, so one level of the tree holds at most one branching chunk worth of
+// children at a time.
+func (self *LazyChunkReader) WriteTo(w io.Writer) (n int64, err error) {
+	log.Debug("lazychunkreader.writeto", "key", self.key)
+	metrics.GetOrRegisterCounter("lazychunkreader.writeto", nil).Inc(1)
+
+	size, err := self.Size(nil)
+	if err != nil {
+		return 0, err
+	}
+	if self.off >= size {
+		return 0, nil
+	}
+	// calculate depth and max treeSize like ReadAt
+	treeSize := self.chunkSize
+	var depth int
+	for ; treeSize < size; treeSize *= self.branches {
+		depth++
+	}
+	n, err = self.writeTo(w, self.off, depth, treeSize/self.branches, self.chunkData)
+	self.off += n
+	metrics.GetOrRegisterCounter("lazychunkreader.writeto.bytes", nil).Inc(n)
+	return n, err
+}
+
+// writes the data of the subtree of the chunk from the offset to w
+func (self *LazyChunkReader) writeTo(w io.Writer, off int64, depth int, treeSize int64, chunkData ChunkData) (int64, error) {
+	// find appropriate block level
+	for chunkData.Size() < treeSize && depth > self.depth {
+		treeSize /= self.branches
+		depth--
+	}
+
+	// leaf chunk found
+	if depth == self.depth {
+		if 8+off >= int64(len(chunkData)) {
+			return 0, nil
+		}
+		n, err := w.Write(chunkData[8+off:])
+		return int64(n), err
+	}
+
+	// retrieve the children from the one holding the offset
+	start := off / treeSize
+	end := int64(len(chunkData)-8) / self.hashSize
+	if start >= end {
+		return 0, nil
+	}
+	children := childChunksPool.Get().([]ChunkData)[:0]
+	for i := start; i < end; i++ {
+		children = append(children, nil)
+	}
+	defer func() {
+		for i := range children {
+			children[i] = nil
+		}
+		childChunksPool.Put(children[:0])
+	}()
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var err error
+	for i := start; i < end; i++ {
+		wg.Add(1)
+		go func(i int64) {
+			defer wg.Done()
+			childKey := chunkData[8+i*self.hashSize : 8+(i+1)*self.hashSize]
+			child, cerr := self.getter.Get(Reference(childKey))
+			if cerr != nil {
+				log.Error("lazychunkreader.writeto", "key", fmt.Sprintf("%x", childKey), "err", cerr)
+				lock.Lock()
+				err = fmt.Errorf("chunk %v-%v not found; key: %s", i*treeSize, (i+1)*treeSize, fmt.Sprintf("%x", childKey))
+				lock.Unlock()
+				return
+			}
+			children[i-start] = child
+		}(i)
+	}
+	wg.Wait()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for i, child := range children {
+		var soff int64
+		if i == 0 {
+			soff = off - start*treeSize
+		}
+		written, err := self.writeTo(w, soff, depth-1, treeSize/self.branches, child)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
 // completely analogous to standard SectionReader implementation
 var errWhence = errors.New("Seek: invalid whence")
 var errOffset = errors.New("Seek: invalid offset")
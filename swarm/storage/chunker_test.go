@@ -23,6 +23,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/crypto/sha3"
@@ -144,6 +145,23 @@ func testRandomData(usePyramid bool, hash string, n int, tester *chunkerTester)
 		}
 	}
 
+	// testing streaming from an offset
+	for _, off := range []int{0, n / 3, n - 1} {
+		if _, err := reader.Seek(int64(off), 0); err != nil {
+			tester.t.Fatalf("seek error with offset %v: %v", off, err)
+		}
+		buf := new(bytes.Buffer)
+		w, err := reader.WriteTo(buf)
+		if w != int64(n-off) || err != nil {
+			tester.t.Fatalf("writeTo error with offset %v written: %v  n = %v  err = %v\n", off, w, n-off, err)
+		}
+		if input != nil {
+			if !bytes.Equal(buf.Bytes(), input[off:]) {
+				tester.t.Fatalf("input and output mismatch\n IN: %v\nOUT: %v\n", input[off:], buf.Bytes())
+			}
+		}
+	}
+
 	return key
 }
 
@@ -376,6 +394,44 @@ func BenchmarkSplitJoin_7(t *testing.B) { benchmarkSplitJoin(10000000, t) }
 
 // func BenchmarkSplitJoin_8(t *testing.B) { benchmarkJoin(100000000, t) }
 
+// benchmarks serving content stored in a LevelDB store, with SetBytes the
+// allocations reported per op are the ones per MB served
+func benchmarkServe(n int, writeTo bool, t *testing.B) {
+	db, err := newTestDbStore(false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.close()
+	putGetter := newTestHasherStore(db, SHA3Hash)
+	key, wait, err := PyramidSplit(testDataReader(n), putGetter, putGetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+
+	buf := make([]byte, 32*1024)
+	t.SetBytes(int64(n))
+	t.ReportAllocs()
+	t.ResetTimer()
+	for i := 0; i < t.N; i++ {
+		reader := TreeJoin(key, putGetter, 0)
+		if writeTo {
+			_, err = reader.WriteTo(ioutil.Discard)
+		} else {
+			// hide WriterTo, like the copy buffers of http.ServeContent
+			_, err = io.CopyBuffer(ioutil.Discard, struct{ io.Reader }{reader}, buf)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkServeRead_1MB(t *testing.B)     { benchmarkServe(1000000, false, t) }
+func BenchmarkServeWriteTo_1MB(t *testing.B)  { benchmarkServe(1000000, true, t) }
+func BenchmarkServeRead_10MB(t *testing.B)    { benchmarkServe(10000000, false, t) }
+func BenchmarkServeWriteTo_10MB(t *testing.B) { benchmarkServe(10000000, true, t) }
+
 func BenchmarkSplitTreeSHA3_2(t *testing.B)  { benchmarkSplitTreeSHA3(100, t) }
 func BenchmarkSplitTreeSHA3_2h(t *testing.B) { benchmarkSplitTreeSHA3(500, t) }
 func BenchmarkSplitTreeSHA3_3(t *testing.B)  { benchmarkSplitTreeSHA3(1000, t) }
@@ -24,6 +24,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
@@ -46,6 +47,23 @@ func NewLDBDatabase(file string) (*LDBDatabase, error) {
 	return database, nil
 }
 
+// RecoverLDBDatabase recovers the database at path which cannot be opened
+// because it is corrupt, rebuilding its manifest from the table files. The
+// entries of corrupt tables are lost.
+func RecoverLDBDatabase(file string) error {
+	db, err := leveldb.RecoverFile(file, &opt.Options{OpenFilesCacheCapacity: openFileLimit})
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// IsCorruptedLDBDatabase returns true if the error opening or reading a
+// database is caused by corruption, see RecoverLDBDatabase
+func IsCorruptedLDBDatabase(err error) bool {
+	return errors.IsCorrupted(err)
+}
+
 func (self *LDBDatabase) Put(key []byte, value []byte) {
 	metrics.GetOrRegisterCounter("ldbdatabase.put", nil).Inc(1)
 
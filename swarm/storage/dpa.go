@@ -92,6 +92,14 @@ func (self *DPA) Store(data io.Reader, size int64, toEncrypt bool) (key Key, wai
 	return PyramidSplit(data, putter, putter)
 }
 
+// StoreConvergent stores the data with convergent encryption, so that identical data
+// results in identical encrypted chunks and references, see NewConvergentHasherStore
+// for the tradeoffs involved. Content stored with different secrets does not converge.
+func (self *DPA) StoreConvergent(data io.Reader, size int64, secret []byte) (key Key, wait func(), err error) {
+	putter := NewConvergentHasherStore(self.ChunkStore, self.hashFunc, secret)
+	return PyramidSplit(data, putter, putter)
+}
+
 func (self *DPA) HashSize() int {
 	return self.hashFunc().Size()
 }
@@ -162,3 +162,39 @@ func testDPA_capacity(toEncrypt bool, t *testing.T) {
 		t.Errorf("Comparison error after clearing memStore.")
 	}
 }
+
+func TestDPAConvergent(t *testing.T) {
+	dpa := NewDPA(NewMapChunkStore(), NewDPAParams())
+	_, slice := generateRandomData(5*int(DefaultChunkSize) + 100)
+
+	store := func(secret string) Key {
+		key, wait, err := dpa.StoreConvergent(bytes.NewReader(slice), int64(len(slice)), []byte(secret))
+		if err != nil {
+			t.Fatalf("Store error: %v", err)
+		}
+		wait()
+		return key
+	}
+	key := store("")
+
+	// identical content converges to the identical encrypted reference
+	if key2 := store(""); !bytes.Equal(key, key2) {
+		t.Fatalf("expected convergent references to be equal, got %x and %x", key, key2)
+	}
+	if key2 := store("secret"); bytes.Equal(key, key2) {
+		t.Fatal("expected references with different convergence secrets to differ")
+	}
+
+	resultReader, isEncrypted := dpa.Retrieve(key)
+	if !isEncrypted {
+		t.Fatal("expected convergent content to be encrypted")
+	}
+	resultSlice := make([]byte, len(slice))
+	n, err := resultReader.ReadAt(resultSlice, 0)
+	if err != io.EOF {
+		t.Fatalf("Retrieve error: %v", err)
+	}
+	if n != len(slice) || !bytes.Equal(slice, resultSlice) {
+		t.Fatal("Comparison error.")
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 )
 
 const (
@@ -16,6 +17,10 @@ const (
 	ErrInvalidSignature
 	ErrNotSynced
 	ErrPeriodDepth
+	ErrGone
+	ErrEncrypted
+	ErrNoChain
+	ErrPeriodOverflow
 	ErrCnt
 )
 
@@ -27,3 +32,130 @@ var (
 	ErrChunkUnavailable = errors.New("chunk unavailable")
 	ErrChunkTimeout     = errors.New("timeout")
 )
+
+// ResourceError is the error of a resource operation. It carries the code of
+// the error, the underlying error which caused it if any, and the resource,
+// period and version the operation was on if known.
+type ResourceError struct {
+	code    int
+	err     string
+	cause   error
+	name    string
+	period  uint32
+	version uint32
+}
+
+func (e *ResourceError) Error() string {
+	if e.cause == nil {
+		return e.err
+	} else if e.err == "" {
+		return e.cause.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.err, e.cause)
+}
+
+func (e *ResourceError) Code() int {
+	return e.code
+}
+
+// Unwrap returns the underlying error, nil if there is none
+func (e *ResourceError) Unwrap() error {
+	return e.cause
+}
+
+// Name returns the name of the resource, empty if unknown
+func (e *ResourceError) Name() string {
+	return e.name
+}
+
+// Period returns the period of the operation, 0 if unknown
+func (e *ResourceError) Period() uint32 {
+	return e.period
+}
+
+// Version returns the version of the operation, 0 if unknown
+func (e *ResourceError) Version() uint32 {
+	return e.version
+}
+
+func NewResourceError(code int, s string) error {
+	return WrapResourceError(code, s, nil)
+}
+
+// WrapResourceError returns a resource error caused by the given error. The
+// message of the cause is appended to s.
+func WrapResourceError(code int, s string, cause error) error {
+	if code < 0 || code >= ErrCnt {
+		panic("no such error code!")
+	}
+	r := &ResourceError{
+		err:   s,
+		cause: cause,
+	}
+	switch code {
+	case ErrNotFound, ErrIO, ErrUnauthorized, ErrInvalidValue, ErrDataOverflow, ErrNothingToReturn, ErrInvalidSignature, ErrNotSynced, ErrPeriodDepth, ErrCorruptData, ErrGone, ErrEncrypted, ErrNoChain, ErrPeriodOverflow:
+		r.code = code
+	}
+	return r
+}
+
+// adds the resource, period and version of an operation to the resource error
+// in *err, unless it already has a resource
+func annotateResourceError(err *error, name string, period uint32, version uint32) {
+	rerr, ok := (*err).(*ResourceError)
+	if !ok || rerr.name != "" {
+		return
+	}
+	annotated := *rerr
+	annotated.name, annotated.period, annotated.version = name, period, version
+	*err = &annotated
+}
+
+// ResourceErrorCode returns the code of the resource error in the chain of
+// err, and false if there is none
+func ResourceErrorCode(err error) (int, bool) {
+	var rerr *ResourceError
+	if !errors.As(err, &rerr) {
+		return 0, false
+	}
+	return rerr.Code(), true
+}
+
+// returns true if the error chain contains a resource error with the code
+func isResourceError(err error, code int) bool {
+	c, ok := ResourceErrorCode(err)
+	return ok && c == code
+}
+
+// IsNotFound returns true if the resource or the update was not found, which
+// may be temporary while the chunks are not retrievable
+func IsNotFound(err error) bool {
+	return isResourceError(err, ErrNotFound)
+}
+
+// IsNotSynced returns true if the resource is not synced yet
+func IsNotSynced(err error) bool {
+	return isResourceError(err, ErrNotSynced)
+}
+
+// IsGone returns true if the resource was deleted, see UpdateTombstone
+func IsGone(err error) bool {
+	return isResourceError(err, ErrGone)
+}
+
+// IsNoChain returns true if the block height of block based resources cannot
+// be retrieved from any chain source
+func IsNoChain(err error) bool {
+	return isResourceError(err, ErrNoChain)
+}
+
+// IsUnauthorized returns true if the signer may not update the resource
+func IsUnauthorized(err error) bool {
+	return isResourceError(err, ErrUnauthorized)
+}
+
+// IsPeriodOverflow returns true if a period number or the position of a
+// period on the period scale of a resource does not fit its encoding
+func IsPeriodOverflow(err error) bool {
+	return isResourceError(err, ErrPeriodOverflow)
+}
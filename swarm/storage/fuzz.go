@@ -0,0 +1,64 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package storage
+
+import "sync"
+
+// The go-fuzz harness of the parsing of the resource chunks received from
+// peers. The input is the key of the chunk followed by its data, which is
+// parsed as an update chunk, validated like the chunks stored from the
+// network and parsed as a metadata chunk.
+
+var (
+	fuzzOnce     sync.Once
+	fuzzResource *ResourceHandler
+)
+
+func fuzzSetup() {
+	signer, err := NewMockResourceSigner("fuzz")
+	if err != nil {
+		panic(err)
+	}
+	if fuzzResource, err = NewResourceHandler(&ResourceHandlerParams{Signer: signer}); err != nil {
+		panic(err)
+	}
+}
+
+// Fuzz parses and validates a resource chunk
+func Fuzz(data []byte) int {
+	fuzzOnce.Do(fuzzSetup)
+	if len(data) < KeyLength {
+		return -1
+	}
+	key, chunkdata := Key(data[:KeyLength]), data[KeyLength:]
+	valid := fuzzResource.Validate(key, chunkdata)
+	if decoded, err := decodeResourceChunk(chunkdata); err == nil {
+		if _, _, _, _, _, _, err := fuzzResource.parseUpdate(decoded); err == nil {
+			valid = true
+		}
+		var rsrc resource
+		if err := rsrc.UnmarshalBinary(decoded); err == nil {
+			valid = true
+		}
+	}
+	if valid {
+		return 1
+	}
+	return 0
+}
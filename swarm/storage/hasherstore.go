@@ -33,8 +33,10 @@ type hasherStore struct {
 	store           ChunkStore
 	hashFunc        SwarmHasher
 	chunkEncryption *chunkEncryption
-	hashSize        int   // content hash size
-	refSize         int64 // reference size (content hash + possibly encryption key)
+	convergent      bool   // derive the encryption keys from the content, see NewConvergentHasherStore
+	secret          []byte // convergence secret mixed into the derived encryption keys
+	hashSize        int    // content hash size
+	refSize         int64  // reference size (content hash + possibly encryption key)
 	wg              *sync.WaitGroup
 	closed          chan struct{}
 }
@@ -70,6 +72,24 @@ func NewHasherStore(chunkStore ChunkStore, hashFunc SwarmHasher, toEncrypt bool)
 	}
 }
 
+// NewConvergentHasherStore creates a hasherStore which encrypts chunks with keys derived
+// from their content (convergent encryption) instead of random keys. Identical data
+// encrypts to identical chunks, so uploads of the same file by different users are
+// deduplicated by the network, while storers still cannot read the chunks they hold.
+//
+// The tradeoff is that anyone who has (or can guess) the plaintext can compute the
+// reference and confirm that the content is stored in swarm and who requests it
+// (confirmation of a file attack). If the content is one of a small set of likely
+// candidates (e.g. a form letter differing in a few fields), the unknown parts can
+// even be recovered by trying all candidates. A non-empty secret mixed into the keys
+// restricts deduplication (and the attacks) to the uploaders who share the secret.
+func NewConvergentHasherStore(chunkStore ChunkStore, hashFunc SwarmHasher, secret []byte) *hasherStore {
+	h := NewHasherStore(chunkStore, hashFunc, true)
+	h.convergent = true
+	h.secret = secret
+	return h
+}
+
 // Put stores the chunkData into the ChunkStore of the hasherStore and returns the reference.
 // If hasherStore has a chunkEncryption object, the data will be encrypted.
 // Asynchronous function, the data will not necessarily be stored when it returns.
@@ -152,16 +172,29 @@ func (p *hasherStore) encryptChunkData(chunkData ChunkData) (ChunkData, encrypti
 		return nil, nil, fmt.Errorf("Invalid ChunkData, min length 8 got %v", len(chunkData))
 	}
 
-	encryptionKey, err := encryption.GenerateRandomKey()
-	if err != nil {
-		return nil, nil, err
+	var encryptionKey encryption.Key
+	data := chunkData[8:]
+	if p.convergent {
+		encryptionKey = p.convergentKey(chunkData)
+		// the padding is randomised by the encryption, so pad the data
+		// beforehand for it to encrypt deterministically
+		if int64(len(data)) < DefaultChunkSize {
+			data = make([]byte, DefaultChunkSize)
+			copy(data, chunkData[8:])
+		}
+	} else {
+		var err error
+		encryptionKey, err = encryption.GenerateRandomKey()
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	encryptedSpan, err := p.chunkEncryption.spanEncryption.Encrypt(chunkData[:8], encryptionKey)
 	if err != nil {
 		return nil, nil, err
 	}
-	encryptedData, err := p.chunkEncryption.dataEncryption.Encrypt(chunkData[8:], encryptionKey)
+	encryptedData, err := p.chunkEncryption.dataEncryption.Encrypt(data, encryptionKey)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -171,6 +204,15 @@ func (p *hasherStore) encryptChunkData(chunkData ChunkData) (ChunkData, encrypti
 	return c, encryptionKey, nil
 }
 
+// convergentKey derives the encryption key of a chunk from its content and the
+// convergence secret
+func (h *hasherStore) convergentKey(chunkData ChunkData) encryption.Key {
+	hasher := sha3.NewKeccak256()
+	hasher.Write(h.secret)
+	hasher.Write(chunkData)
+	return hasher.Sum(nil)
+}
+
 func (h *hasherStore) decryptChunkData(chunkData ChunkData, encryptionKey encryption.Key) (ChunkData, error) {
 	if len(chunkData) < 8 {
 		return nil, fmt.Errorf("Invalid ChunkData, min length 8 got %v", len(chunkData))
@@ -116,3 +116,44 @@ func TestHasherStore(t *testing.T) {
 		}
 	}
 }
+
+func TestConvergentHasherStore(t *testing.T) {
+	chunkStore := NewMapChunkStore()
+	hasherStore := NewConvergentHasherStore(chunkStore, MakeHashFunc(DefaultHash), nil)
+
+	chunkData := GenerateRandomChunk(100).SData
+	key1, err := hasherStore.Put(chunkData)
+	if err != nil {
+		t.Fatalf("Expected no error got \"%v\"", err)
+	}
+	key2, err := hasherStore.Put(chunkData)
+	if err != nil {
+		t.Fatalf("Expected no error got \"%v\"", err)
+	}
+	hasherStore.Close()
+	hasherStore.Wait()
+
+	if !bytes.Equal(key1, key2) {
+		t.Fatalf("Expected identical references for identical data, got %v and %v", key1, key2)
+	}
+
+	hash, _, err := parseReference(key1, hasherStore.hashSize)
+	if err != nil {
+		t.Fatalf("Expected no error, got \"%v\"", err)
+	}
+	chunkInStore, err := chunkStore.Get(hash)
+	if err != nil {
+		t.Fatalf("Expected no error got \"%v\"", err)
+	}
+	if bytes.Equal(chunkData, chunkInStore.SData) {
+		t.Fatalf("Chunk expected to be encrypted but it is stored without encryption")
+	}
+
+	retrievedChunkData, err := hasherStore.Get(key1)
+	if err != nil {
+		t.Fatalf("Expected no error, got \"%v\"", err)
+	}
+	if !bytes.Equal(chunkData, retrievedChunkData) {
+		t.Fatalf("Expected retrieved chunk data %v, got %v", common.Bytes2Hex(chunkData), common.Bytes2Hex(retrievedChunkData))
+	}
+}
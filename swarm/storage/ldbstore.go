@@ -54,6 +54,7 @@ var (
 	keyDataIdx     = []byte{4}
 	keyData        = byte(6)
 	keyDistanceCnt = byte(7)
+	keyNetworkID   = []byte{12}
 )
 
 type gcItem struct {
@@ -65,8 +66,11 @@ type gcItem struct {
 
 type LDBStoreParams struct {
 	*StoreParams
-	Path string
-	Po   func(Key) uint8
+	Path        string
+	Po          func(Key) uint8
+	ColdPath    string // path of the cold tier database, no tiering if empty
+	HotCapacity uint64 // chunks kept in the hot tier, a tenth of DbCapacity if 0
+	NetworkID   uint64 // network the chunks belong to, stores of other networks are not opened, any if 0
 }
 
 // NewLDBStoreParams constructs LDBStoreParams with the specified values.
@@ -104,8 +108,37 @@ type LDBStore struct {
 	// retrieving the chunk data instead from the local
 	// LevelDB database.
 	getDataFunc func(key Key) (data []byte, err error)
+
+	gcHooks     []GCHook
+	gcListeners []GCListener
+
+	// cold tier of the store, nil if the store has no tiers, see TierStats
+	cold        *LDBDatabase
+	coldCnt     uint64 // number of chunks in the cold tier
+	hotCapacity uint64
+	promotions  uint64
+	demotions   uint64
+
+	// index key of the last chunk the demotion considered, and the channels
+	// stopping the background demotion, see demote
+	demoteCursor []byte
+	demoteQuitC  chan struct{}
+	demoteDoneC  chan struct{}
+
+	// databases of the shards of the store, see SetShards
+	shards      []*LDBStore
+	shardRouter ShardRouter
 }
 
+// GCHook reports whether a chunk is garbage, which the garbage collector
+// deletes before the least accessed chunks
+type GCHook func(key Key, data []byte) bool
+
+// GCListener is told the keys of the chunks deleted by a run of the garbage
+// collector. It is called with the store locked, so it must not block or
+// access the store.
+type GCListener func(keys []Key)
+
 // TODO: Instead of passing the distance function, just pass the address from which distances are calculated
 // to avoid the appearance of a pluggable distance metric and opportunities of bugs associated with providing
 // a function different from the one that is actually used.
@@ -147,9 +180,52 @@ func NewLDBStore(params *LDBStoreParams) (s *LDBStore, err error) {
 	s.dataIdx = BytesToU64(data)
 	s.dataIdx++
 
+	if err := s.checkNetworkID(params.NetworkID); err != nil {
+		s.db.Close()
+		return nil, err
+	}
+	if err := s.openColdTier(params.ColdPath, params.HotCapacity); err != nil {
+		s.db.Close()
+		return nil, err
+	}
 	return s, nil
 }
 
+// checks that the store belongs to the network, and records the network of
+// a store which does not belong to one yet
+func (s *LDBStore) checkNetworkID(networkID uint64) error {
+	if networkID == 0 {
+		return nil
+	}
+	if data, err := s.db.Get(keyNetworkID); err == nil {
+		if stored := BytesToU64(data); stored != networkID {
+			return fmt.Errorf("chunk db belongs to network %d, not %d", stored, networkID)
+		}
+		return nil
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(keyNetworkID, U64ToBytes(networkID))
+	return s.db.Write(batch)
+}
+
+// StoreNetworkID returns the network the chunk db at path belongs to, 0 if it
+// does not belong to one yet. It fails if the db cannot be opened, e.g.
+// because a running node holds it.
+func StoreNetworkID(path string) (uint64, error) {
+	db, err := leveldb.OpenFile(path, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	data, err := db.Get(keyNetworkID, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return BytesToU64(data), nil
+}
+
 // NewMockDbStore creates a new instance of DbStore with
 // mockStore set to a provided value. If mockStore argument is nil,
 // this function behaves exactly as NewDbStore.
@@ -161,8 +237,10 @@ func NewMockDbStore(params *LDBStoreParams, mockStore *mock.NodeStore) (s *LDBSt
 
 	// replace put and get with mock store functionality
 	if mockStore != nil {
+		s.lock.Lock()
 		s.encodeDataFunc = newMockEncodeDataFunc(mockStore)
 		s.getDataFunc = newMockGetDataFunc(mockStore)
+		s.lock.Unlock()
 	}
 	return
 }
@@ -242,7 +320,44 @@ func decodeOldData(data []byte, chunk *Chunk) {
 	chunk.Size = int64(binary.BigEndian.Uint64(data[0:8]))
 }
 
-func (s *LDBStore) collectGarbage(ratio float32) {
+// AddGCHook adds a hook telling the garbage collector which chunks are garbage
+func (s *LDBStore) AddGCHook(hook GCHook) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.gcHooks = append(s.gcHooks, hook)
+}
+
+// AddGCListener adds a listener told the chunks the garbage collector deletes
+func (s *LDBStore) AddGCListener(listener GCListener) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.gcListeners = append(s.gcListeners, listener)
+}
+
+// reports whether a hook considers the chunk garbage
+func (s *LDBStore) isGarbage(key Key, idx uint64, po uint8) bool {
+	var data []byte
+	var err error
+	if s.getDataFunc != nil {
+		data, err = s.getDataFunc(key)
+	} else {
+		data, _, err = s.getData(getDataKey(idx, po))
+	}
+	if err != nil || len(data) < 32 {
+		return false
+	}
+	for _, hook := range s.gcHooks {
+		if hook(key, data[32:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// collectGarbage deletes the chunks the gc hooks report as garbage and the
+// least accessed chunks which are not pinned, and returns the number of
+// deleted chunks
+func (s *LDBStore) collectGarbage(ratio float32) int {
 	metrics.GetOrRegisterCounter("ldbstore.collectgarbage", nil).Inc(1)
 
 	it := s.db.NewIterator()
@@ -250,6 +365,7 @@ func (s *LDBStore) collectGarbage(ratio float32) {
 
 	garbage := []*gcItem{}
 	gcnt := 0
+	expired := 0
 
 	for ok := it.Seek([]byte{keyIndex}); ok && (gcnt < maxGCitems) && (uint64(gcnt) < s.entryCnt); ok = it.Next() {
 		itkey := it.Key()
@@ -267,6 +383,9 @@ func (s *LDBStore) collectGarbage(ratio float32) {
 		var index dpaDBIndex
 
 		hash := key[1:]
+		if s.refCount(hash) > 0 {
+			continue
+		}
 		decodeIndex(val, &index)
 		po := s.po(hash)
 
@@ -276,6 +395,10 @@ func (s *LDBStore) collectGarbage(ratio float32) {
 			value:  index.Access, // the smaller, the more likely to be gc'd. see sort comparator below.
 			po:     po,
 		}
+		if len(s.gcHooks) > 0 && s.isGarbage(hash, index.Idx, po) {
+			gci.value = 0
+			expired++
+		}
 
 		garbage = append(garbage, gci)
 		gcnt++
@@ -284,11 +407,24 @@ func (s *LDBStore) collectGarbage(ratio float32) {
 	sort.Slice(garbage[:gcnt], func(i, j int) bool { return garbage[i].value < garbage[j].value })
 
 	cutoff := int(float32(gcnt) * ratio)
+	if cutoff < expired {
+		cutoff = expired
+	}
 	metrics.GetOrRegisterCounter("ldbstore.collectgarbage.delete", nil).Inc(int64(cutoff))
 
 	for i := 0; i < cutoff; i++ {
 		s.delete(garbage[i].idx, garbage[i].idxKey, garbage[i].po)
 	}
+	if len(s.gcListeners) > 0 && cutoff > 0 {
+		keys := make([]Key, cutoff)
+		for i := range keys {
+			keys[i] = Key(garbage[i].idxKey[1:])
+		}
+		for _, listener := range s.gcListeners {
+			listener(keys)
+		}
+	}
+	return cutoff
 }
 
 // Export writes all chunks from the store to a tar archive, returning the
@@ -313,7 +449,7 @@ func (s *LDBStore) Export(out io.Writer) (int64, error) {
 		po := s.po(hash)
 		datakey := getDataKey(index.Idx, po)
 		log.Trace("store.export", "dkey", fmt.Sprintf("%x", datakey), "dataidx", index.Idx, "po", po)
-		data, err := s.db.Get(datakey)
+		data, _, err := s.getData(datakey)
 		if err != nil {
 			log.Warn(fmt.Sprintf("Chunk %x found but could not be accessed: %v", key[:], err))
 			continue
@@ -399,7 +535,7 @@ func (s *LDBStore) Cleanup() {
 			it.Next()
 			continue
 		}
-		data, err := s.db.Get(getDataKey(index.Idx, s.po(Key(key[1:]))))
+		data, _, err := s.getData(getDataKey(index.Idx, s.po(Key(key[1:]))))
 		if err != nil {
 			log.Warn(fmt.Sprintf("Chunk %x found but could not be accessed: %v", key[:], err))
 			s.delete(index.Idx, getIndexKey(key[1:]), s.po(Key(key[1:])))
@@ -465,6 +601,7 @@ func (s *LDBStore) ReIndex() {
 func (s *LDBStore) delete(idx uint64, idxKey []byte, po uint8) {
 	metrics.GetOrRegisterCounter("ldbstore.delete", nil).Inc(1)
 
+	s.deleteCold(getDataKey(idx, po))
 	batch := new(leveldb.Batch)
 	batch.Delete(idxKey)
 	batch.Delete(getDataKey(idx, po))
@@ -511,10 +648,18 @@ func (s *LDBStore) Put(chunk *Chunk) {
 	log.Trace("ldbstore.put: s.db.Get", "key", chunk.Key, "ikey", fmt.Sprintf("%x", ikey))
 	idata, err := s.db.Get(ikey)
 	if err != nil {
-		s.doPut(chunk, &index, po)
+		var sharded *Chunk
+		if shard, ok := s.shardOf(chunk); ok {
+			sharded = s.putSharded(chunk, &index, po, shard)
+		} else {
+			s.doPut(chunk, &index, po)
+		}
 		batchC := s.batchC
 		go func() {
 			<-batchC
+			if sharded != nil {
+				<-sharded.dbStoredC
+			}
 			chunk.markAsStored()
 		}()
 	} else {
@@ -532,9 +677,101 @@ func (s *LDBStore) Put(chunk *Chunk) {
 	}
 }
 
+// Resync moves the chunk to the head of the storage index of its proximity
+// bin, so that live syncing offers it to the peers again. Peers which still
+// have the chunk decline it, those which garbage collected it store it again.
+// It returns ErrChunkNotFound if the chunk is not in the store.
+//
+// The move is written right away along with the pending batch, so that later
+// puts of the chunk see its new index.
+func (s *LDBStore) Resync(key Key) error {
+	metrics.GetOrRegisterCounter("ldbstore.resync", nil).Inc(1)
+
+	ikey := getIndexKey(key)
+	po := s.po(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the pending batch may hold the index of the chunk, write it first
+	c := s.batchC
+	s.batchC = make(chan bool)
+	defer close(c)
+	if err := s.writeBatch(s.batch, s.entryCnt, s.dataIdx, s.accessCnt); err != nil {
+		return err
+	}
+	s.batch = new(leveldb.Batch)
+
+	idata, err := s.db.Get(ikey)
+	if err != nil {
+		return ErrChunkNotFound
+	}
+	var index dpaDBIndex
+	decodeIndex(idata, &index)
+	oldKey := getDataKey(index.Idx, po)
+	data, cold, err := s.getData(oldKey)
+	if err != nil {
+		return ErrChunkNotFound
+	}
+	// sharded chunks keep their stub
+	if stub, err := s.db.Get(oldKey); err == nil && isShardStub(stub) {
+		data = stub
+	}
+	// the chunk is moved to the hot tier along
+	if cold {
+		if err := s.promote(oldKey, data); err != nil {
+			return err
+		}
+	}
+	b := new(leveldb.Batch)
+	b.Delete(oldKey)
+	b.Put(getDataKey(s.dataIdx, po), data)
+	index.Idx = s.dataIdx
+	s.bucketCnt[po] = s.dataIdx
+	s.dataIdx++
+
+	cntKey := make([]byte, 2)
+	cntKey[0] = keyDistanceCnt
+	cntKey[1] = po
+	b.Put(cntKey, U64ToBytes(s.bucketCnt[po]))
+	b.Put(ikey, encodeIndex(&index))
+	return s.writeBatch(b, s.entryCnt, s.dataIdx, s.accessCnt)
+}
+
+// Delete removes the chunk from the store, like garbage collection does.
+// It returns ErrChunkNotFound if the chunk is not in the store.
+func (s *LDBStore) Delete(key Key) error {
+	metrics.GetOrRegisterCounter("ldbstore.delete.key", nil).Inc(1)
+
+	ikey := getIndexKey(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the pending batch may hold the index of the chunk, write it first
+	c := s.batchC
+	s.batchC = make(chan bool)
+	defer close(c)
+	if err := s.writeBatch(s.batch, s.entryCnt, s.dataIdx, s.accessCnt); err != nil {
+		return err
+	}
+	s.batch = new(leveldb.Batch)
+
+	idata, err := s.db.Get(ikey)
+	if err != nil {
+		return ErrChunkNotFound
+	}
+	var index dpaDBIndex
+	decodeIndex(idata, &index)
+	s.delete(index.Idx, ikey, s.po(key))
+	return nil
+}
+
 // force putting into db, does not check access index
 func (s *LDBStore) doPut(chunk *Chunk, index *dpaDBIndex, po uint8) {
-	data := s.encodeDataFunc(chunk)
+	s.putData(s.encodeDataFunc(chunk), index, po)
+}
+
+// adds the data entry and the index of a new chunk to the batch
+func (s *LDBStore) putData(data []byte, index *dpaDBIndex, po uint8) {
 	dkey := getDataKey(s.dataIdx, po)
 	s.batch.Put(dkey, data)
 	index.Idx = s.dataIdx
@@ -565,7 +802,10 @@ func (s *LDBStore) writeBatches() {
 		}
 		close(c)
 		for e > s.capacity {
-			s.collectGarbage(gcArrayFreeRatio)
+			if s.collectGarbage(gcArrayFreeRatio) == 0 {
+				log.Warn("DbStore: capacity exceeded by pinned chunks", "entries", e, "capacity", s.capacity)
+				break
+			}
 			e = s.entryCnt
 		}
 		s.lock.Unlock()
@@ -643,13 +883,23 @@ func (s *LDBStore) get(key Key) (chunk *Chunk, err error) {
 			// default DbStore functionality to retrieve chunk data
 			proximity := s.po(key)
 			datakey := getDataKey(indx.Idx, proximity)
-			data, err = s.db.Get(datakey)
+			var cold bool
+			data, cold, err = s.getData(datakey)
 			log.Trace("ldbstore.get retrieve", "key", key, "indexkey", indx.Idx, "datakey", fmt.Sprintf("%x", datakey), "proximity", proximity)
 			if err != nil {
 				log.Trace("ldbstore.get chunk found but could not be accessed", "key", key, "err", err)
-				s.delete(indx.Idx, getIndexKey(key), s.po(key))
+				// the shard may not have written the chunk yet
+				if err != errShardChunkMissing {
+					s.delete(indx.Idx, getIndexKey(key), s.po(key))
+				}
 				return
 			}
+			// chunks retrieved from the cold tier are hot again
+			if cold {
+				if err := s.promote(datakey, data); err != nil {
+					log.Warn("ldbstore.get cannot promote chunk to hot tier", "key", key, "err", err)
+				}
+			}
 		}
 
 		chunk = NewChunk(key, nil)
@@ -701,13 +951,19 @@ func (s *LDBStore) setCapacity(c uint64) {
 			ratio = 1
 		}
 		for s.entryCnt > c {
-			s.collectGarbage(ratio)
+			if s.collectGarbage(ratio) == 0 {
+				break
+			}
 		}
 	}
 }
 
 func (s *LDBStore) Close() {
+	s.stopDemote()
 	s.db.Close()
+	if s.cold != nil {
+		s.cold.Close()
+	}
 }
 
 // SyncIterator(start, stop, po, f) calls f on each hash of a bin po from start to stop
@@ -0,0 +1,243 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+An unclean shutdown can leave the entries of a store out of step with each
+other, when the writes of a batch or of the two tiers are not all on disk.
+Repair reconciles them:
+
+	keyIndex|key -> dangling if the data entry it refers to is missing or
+	                holds another chunk, the entry is removed
+	keyData|po|idx -> orphan if no index entry refers to it, in either tier,
+	                  the entry is removed
+	keyResourceRoot|namehash -> stale if the metadata chunk it refers to is
+	                            missing or of another resource
+
+A stale resource root is removed, and learned again from the next update of
+the owner of the resource which is validated (see ResourceRoot). It is not
+pointed to another stored metadata chunk of the resource, since anyone can
+make a metadata chunk for any name.
+
+Repair refuses to change a store if most index entries are dangling, which is
+more likely the sign of a wrong base key than of an unclean shutdown.
+
+The check keeps the data keys of all chunks in memory, it is meant for stores
+not in use, see swarm db repair.
+*/
+
+// ErrMostlyDangling is returned by Repair if most index entries of the store
+// are dangling
+var ErrMostlyDangling = errors.New("most index entries are dangling, check the base key")
+
+// ConsistencyReport is the result of a consistency check of a store
+type ConsistencyReport struct {
+	Chunks     int   // number of index entries
+	Dangling   []Key // index entries without the data of their chunk
+	Orphans    int   // data entries without an index entry, in either tier
+	Roots      int   // number of resource roots
+	StaleRoots int   // resource roots without the metadata chunk of their resource
+}
+
+func (r *ConsistencyReport) String() string {
+	return fmt.Sprintf("%d chunks, %d dangling index entries, %d orphaned data entries, %d resource roots, %d stale", r.Chunks, len(r.Dangling), r.Orphans, r.Roots, r.StaleRoots)
+}
+
+// Consistent returns true if no problems were found
+func (r *ConsistencyReport) Consistent() bool {
+	return len(r.Dangling) == 0 && r.Orphans == 0 && r.StaleRoots == 0
+}
+
+// MostlyDangling returns true if more than half of the index entries are
+// dangling, see ErrMostlyDangling
+func (r *ConsistencyReport) MostlyDangling() bool {
+	return len(r.Dangling) > 0 && 2*len(r.Dangling) > r.Chunks
+}
+
+// the changes which repair a store, see checkConsistency
+type repairPlan struct {
+	orphans     [][]byte      // data keys of the orphans in the hot tier
+	coldOrphans [][]byte      // data keys of the orphans only in the cold tier
+	roots       []common.Hash // namehashes of the stale resource roots
+}
+
+// CheckConsistency checks that the index entries, the data entries and the
+// resource roots of the store refer to each other. Corruption of the
+// database is returned as error, see IsCorruptedLDBDatabase.
+func (s *LDBStore) CheckConsistency() (*ConsistencyReport, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	report, _, err := s.checkConsistency()
+	return report, err
+}
+
+// Repair removes the dangling index entries, the orphaned data entries and
+// the stale resource roots of the store. It returns the report of the check
+// done before the repair, and ErrMostlyDangling without changing the store if
+// most index entries are dangling.
+func (s *LDBStore) Repair() (*ConsistencyReport, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	report, plan, err := s.checkConsistency()
+	if err != nil || report.Consistent() {
+		return report, err
+	} else if report.MostlyDangling() {
+		return report, ErrMostlyDangling
+	}
+
+	batch := new(leveldb.Batch)
+	for _, key := range report.Dangling {
+		batch.Delete(getIndexKey(key))
+		if s.entryCnt > 0 {
+			s.entryCnt--
+		}
+	}
+	batch.Put(keyEntryCnt, U64ToBytes(s.entryCnt))
+	for _, datakey := range plan.orphans {
+		s.deleteCold(datakey)
+		batch.Delete(datakey)
+	}
+	for _, nameHash := range plan.roots {
+		batch.Delete(getResourceRootKey(nameHash))
+	}
+	if len(plan.coldOrphans) > 0 {
+		coldBatch := new(leveldb.Batch)
+		for _, datakey := range plan.coldOrphans {
+			coldBatch.Delete(datakey)
+		}
+		if err := s.cold.Write(coldBatch); err != nil {
+			return report, err
+		}
+	}
+	log.Info("ldbstore.repair", "report", report)
+	return report, s.db.Write(batch)
+}
+
+// checkConsistency must be called with the lock held. It returns the report
+// and the changes which repair the store.
+func (s *LDBStore) checkConsistency() (*ConsistencyReport, *repairPlan, error) {
+	if s.getDataFunc != nil {
+		return nil, nil, errors.New("the data of the store is not in its database")
+	}
+	report := &ConsistencyReport{}
+	plan := &repairPlan{}
+
+	it := s.db.NewIterator()
+	defer it.Release()
+
+	roots := make(map[string]Key)
+	for ok := it.Seek([]byte{keyResourceRoot}); ok; ok = it.Next() {
+		key := it.Key()
+		if len(key) == 0 || key[0] != keyResourceRoot {
+			break
+		}
+		roots[common.BytesToHash(key[1:]).Hex()] = Key(append([]byte{}, it.Value()...))
+	}
+	report.Roots = len(roots)
+
+	// the data keys the index refers to, and the resources whose roots refer
+	// to their stored metadata chunk
+	referenced := make(map[string]bool)
+	found := make(map[string]bool)
+	for ok := it.Seek([]byte{keyIndex}); ok; ok = it.Next() {
+		ikey := it.Key()
+		if len(ikey) == 0 || ikey[0] != keyIndex {
+			break
+		}
+		report.Chunks++
+		key := Key(append([]byte{}, ikey[1:]...))
+		var index dpaDBIndex
+		if err := decodeIndex(it.Value(), &index); err != nil {
+			log.Warn("ldbstore.repair invalid index entry", "key", key, "err", err)
+			report.Dangling = append(report.Dangling, key)
+			continue
+		}
+		datakey := getDataKey(index.Idx, s.po(key))
+		data, _, err := s.getData(datakey)
+		if err != nil || len(data) < len(key) || !bytes.Equal(data[:len(key)], key) {
+			log.Warn("ldbstore.repair chunk data missing", "key", key, "datakey", fmt.Sprintf("%x", datakey), "err", err)
+			report.Dangling = append(report.Dangling, key)
+			continue
+		}
+		referenced[string(datakey)] = true
+		rsrc, err := parseResourceMetadata(data[len(key):])
+		if err != nil {
+			continue
+		}
+		nameHash := rsrc.nameHash.Hex()
+		if root, ok := roots[nameHash]; ok && bytes.Equal(root, key) {
+			found[nameHash] = true
+		}
+	}
+
+	for ok := it.Seek([]byte{keyData}); ok; ok = it.Next() {
+		datakey := it.Key()
+		if len(datakey) == 0 || datakey[0] != keyData {
+			break
+		}
+		if !referenced[string(datakey)] {
+			report.Orphans++
+			plan.orphans = append(plan.orphans, append([]byte{}, datakey...))
+		}
+	}
+	if err := it.Error(); err != nil {
+		return report, plan, err
+	}
+	if s.cold != nil {
+		coldIt := s.cold.NewIterator()
+		defer coldIt.Release()
+		for ok := coldIt.Seek([]byte{keyData}); ok; ok = coldIt.Next() {
+			datakey := coldIt.Key()
+			if len(datakey) == 0 || datakey[0] != keyData {
+				break
+			}
+			if referenced[string(datakey)] {
+				continue
+			}
+			// the orphans with a stub in the hot tier are removed with it
+			if _, err := s.db.Get(datakey); err == nil {
+				continue
+			}
+			report.Orphans++
+			plan.coldOrphans = append(plan.coldOrphans, append([]byte{}, datakey...))
+		}
+		if err := coldIt.Error(); err != nil {
+			return report, plan, err
+		}
+	}
+
+	for nameHash, root := range roots {
+		if found[nameHash] {
+			continue
+		}
+		report.StaleRoots++
+		log.Warn("ldbstore.repair stale resource root", "namehash", nameHash, "rootkey", root)
+		plan.roots = append(plan.roots, common.HexToHash(nameHash))
+	}
+	return report, plan, nil
+}
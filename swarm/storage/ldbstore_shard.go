@@ -0,0 +1,115 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Sharding keeps the data of some chunks in the databases of shards, which can
+live on other disks than the main database and compact separately, see
+ResourceHandler.SetShards.
+
+The main database keeps the index of all chunks, so syncing by storage index,
+retrieval and garbage collection see sharded chunks as any other. The data
+entry of a sharded chunk in the main database is a stub holding the chunk key,
+a marker and the number of the shard, its data is stored in the shard only:
+
+	main:  keyData|po|idx -> key|shardStubMarker|shard
+	shard: the chunk, as in any store
+
+Chunks are sharded whenever they are put in the main database, whether they
+are made locally, synced or retrieved. The stubs are longer than the ones of
+cold chunks and shorter than the data of any chunk. The shards collect their
+own garbage, a chunk deleted from the main database stays in its shard until
+then.
+*/
+
+const (
+	shardStubMarker = 0xff
+	shardStubLength = KeyLength + 2
+
+	// the number of the shard is a byte of the stub
+	maxShards = 256
+)
+
+var errShardChunkMissing = errors.New("sharded chunk missing in its shard")
+
+// ShardRouter returns the number of the shard the data of a chunk is stored
+// in, or -1 if it is stored in the main database
+type ShardRouter func(key Key, data []byte) int
+
+// SetShards stores the data of the chunks the router assigns to a shard in
+// the database of the shard. It must be called before the store is used.
+func (s *LDBStore) SetShards(shards []*LDBStore, router ShardRouter) error {
+	if len(shards) > maxShards {
+		return fmt.Errorf("at most %d shards, got %d", maxShards, len(shards))
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.shards = shards
+	s.shardRouter = router
+	return nil
+}
+
+// returns true if the data entry in the main database is the stub of a
+// sharded chunk
+func isShardStub(data []byte) bool {
+	return len(data) == shardStubLength && data[KeyLength] == shardStubMarker
+}
+
+// returns the shard of a new chunk, false if its data is stored in the main
+// database
+func (s *LDBStore) shardOf(chunk *Chunk) (int, bool) {
+	if s.shardRouter == nil || s.getDataFunc != nil {
+		return 0, false
+	}
+	shard := s.shardRouter(chunk.Key, chunk.SData)
+	return shard, shard >= 0 && shard < len(s.shards)
+}
+
+// stores the data of a new chunk in its shard and adds its index and stub to
+// the batch, must be called with the lock held. It returns the chunk put in
+// the shard, the chunk is stored once both are.
+func (s *LDBStore) putSharded(chunk *Chunk, index *dpaDBIndex, po uint8, shard int) *Chunk {
+	sharded := NewChunk(chunk.Key, nil)
+	sharded.SData = chunk.SData
+	sharded.Size = chunk.Size
+	s.shards[shard].Put(sharded)
+
+	stub := make([]byte, shardStubLength)
+	copy(stub, chunk.Key[:])
+	stub[KeyLength] = shardStubMarker
+	stub[KeyLength+1] = byte(shard)
+	s.putData(stub, index, po)
+	return sharded
+}
+
+// returns the data of the sharded chunk with the stub from its shard
+func (s *LDBStore) getSharded(stub []byte) ([]byte, error) {
+	shard := int(stub[KeyLength+1])
+	if shard >= len(s.shards) {
+		return nil, errShardChunkMissing
+	}
+	chunk, err := s.shards[shard].Get(Key(stub[:KeyLength]))
+	if err != nil {
+		return nil, errShardChunkMissing
+	}
+	return encodeData(chunk), nil
+}
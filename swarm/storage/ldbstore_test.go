@@ -21,11 +21,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/swarm/storage/mock/mem"
 
@@ -512,3 +514,417 @@ func TestLDBStoreRemoveThenCollectGarbage(t *testing.T) {
 		t.Fatal("expected to get the same data back, but got smth else")
 	}
 }
+
+// TestLDBStoreGCListener tests that the gc listeners are told the keys of
+// the chunks the garbage collector deletes
+func TestLDBStoreGCListener(t *testing.T) {
+	ldb, cleanup := newLDBStore(t)
+	defer cleanup()
+	ldb.setCapacity(100)
+
+	var deleted []Key
+	ldb.AddGCListener(func(keys []Key) {
+		deleted = append(deleted, keys...)
+	})
+
+	n := 20
+	chunks := make([]*Chunk, n)
+	for i := range chunks {
+		chunks[i] = NewRandomChunk(chunkSize)
+		ldb.Put(chunks[i])
+	}
+	for _, c := range chunks {
+		<-c.dbStoredC
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected no chunk deleted below capacity, got %d", len(deleted))
+	}
+
+	ldb.setCapacity(10)
+	if len(deleted) < n-10 {
+		t.Fatalf("expected at least %d deleted chunks, got %d", n-10, len(deleted))
+	}
+	for _, key := range deleted {
+		if _, err := ldb.Get(key); err == nil {
+			t.Fatalf("expected deleted chunk %v to be missing", key)
+		}
+	}
+}
+
+// TestLDBStoreResync tests that a resynced chunk is moved to the head of the
+// storage index of its bin, without being counted twice
+func TestLDBStoreResync(t *testing.T) {
+	ldb, cleanup := newLDBStore(t)
+	defer cleanup()
+
+	n := 10
+	chunks := []*Chunk{}
+	for i := 0; i < n; i++ {
+		c := NewRandomChunk(4096)
+		chunks = append(chunks, c)
+		ldb.Put(c)
+	}
+	for i := 0; i < n; i++ {
+		<-chunks[i].dbStoredC
+	}
+
+	chunk := chunks[0]
+	po := ldb.po(chunk.Key)
+	since := ldb.CurrentBucketStorageIndex(po)
+	entryCnt := ldb.entryCnt
+	if err := ldb.Resync(chunk.Key); err != nil {
+		t.Fatal(err)
+	}
+	if ldb.CurrentBucketStorageIndex(po) <= since {
+		t.Fatalf("expected the storage index of bin %d to move past %d, got %d", po, since, ldb.CurrentBucketStorageIndex(po))
+	}
+	if ldb.entryCnt != entryCnt {
+		t.Fatalf("expected entryCnt to stay %d, got %d", entryCnt, ldb.entryCnt)
+	}
+
+	// the chunk is synced again, once
+	var synced []Key
+	err := ldb.SyncIterator(since+1, ldb.CurrentBucketStorageIndex(po), po, func(key Key, _ uint64) bool {
+		synced = append(synced, key)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(synced) != 1 || !bytes.Equal(synced[0], chunk.Key) {
+		t.Fatalf("expected the resynced chunk only to be synced again, got %v", synced)
+	}
+	count := 0
+	err = ldb.SyncIterator(0, ldb.CurrentBucketStorageIndex(po), po, func(key Key, _ uint64) bool {
+		if bytes.Equal(key, chunk.Key) {
+			count++
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("expected the chunk once in its bin, got %d times", count)
+	}
+
+	ret, err := ldb.Get(chunk.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret.SData, chunk.SData) {
+		t.Fatal("expected to get the same data back, but got smth else")
+	}
+
+	if err := ldb.Resync(NewRandomChunk(4096).Key); err != ErrChunkNotFound {
+		t.Fatalf("expected ErrChunkNotFound resyncing a missing chunk, got %v", err)
+	}
+}
+
+// TestLDBStoreTiers tests that the least accessed chunks are demoted to the
+// cold tier and promoted back when retrieved
+func TestLDBStoreTiers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ldbparams := NewLDBStoreParams(NewDefaultStoreParams(), filepath.Join(dir, "hot"))
+	ldbparams.ColdPath = filepath.Join(dir, "cold")
+	ldbparams.HotCapacity = 5
+	ldb, err := NewLDBStore(ldbparams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := 10
+	chunks := []*Chunk{}
+	for i := 0; i < n; i++ {
+		c := NewRandomChunk(4096)
+		chunks = append(chunks, c)
+		ldb.Put(c)
+		<-c.dbStoredC
+	}
+	ldb.demote()
+
+	stats := ldb.TierStats()
+	if stats.Chunks != uint64(n) || stats.HotChunks != 5 || stats.ColdChunks != 5 || stats.Demotions != 5 {
+		t.Fatalf("expected 5 hot and 5 cold chunks, got %s", stats)
+	}
+	// the chunks stored first are the least accessed
+	for i := 0; i < n; i++ {
+		data, _ := ldb.db.Get(getDataKey(testIndex(t, ldb, chunks[i].Key).Idx, ldb.po(chunks[i].Key)))
+		if cold := ldb.isColdStub(data); cold != (i < 5) {
+			t.Fatalf("chunk %d: expected cold %v, got %v", i, i < 5, cold)
+		}
+	}
+
+	// cold chunks are still synced
+	count := 0
+	for po := 0; po < 0x100; po++ {
+		ldb.SyncIterator(0, ldb.CurrentBucketStorageIndex(uint8(po)), uint8(po), func(Key, uint64) bool {
+			count++
+			return true
+		})
+	}
+	if count != n {
+		t.Fatalf("expected %d chunks to be synced, got %d", n, count)
+	}
+
+	for i := 0; i < n; i++ {
+		ret, err := ldb.Get(chunks[i].Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ret.SData, chunks[i].SData) {
+			t.Fatalf("chunk %d: expected to get the same data back, but got smth else", i)
+		}
+	}
+	stats = ldb.TierStats()
+	if stats.ColdChunks != 0 || stats.Promotions != 5 {
+		t.Fatalf("expected the cold chunks to be promoted, got %s", stats)
+	}
+
+	// the cold tier count persists
+	ldb.demote()
+	ldb.Close()
+	hotparams := *ldbparams
+	hotparams.ColdPath = ""
+	if _, err := NewLDBStore(&hotparams); err == nil {
+		t.Fatal("expected opening the store without its cold tier to fail")
+	}
+	if ldb, err = NewLDBStore(ldbparams); err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	if stats := ldb.TierStats(); stats.ColdChunks != 5 {
+		t.Fatalf("expected 5 cold chunks after reopening, got %s", stats)
+	}
+}
+
+// TestLDBStoreTiersCursor tests that the demotion carries on where it stopped
+// when the hot tier exceeds its capacity by more than a window of the index
+func TestLDBStoreTiersCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ldbparams := NewLDBStoreParams(NewDefaultStoreParams(), filepath.Join(dir, "hot"))
+	ldbparams.ColdPath = filepath.Join(dir, "cold")
+	ldbparams.HotCapacity = 50
+	ldb, err := NewLDBStore(ldbparams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+
+	n := maxGCitems + 100
+	var last *Chunk
+	for i := 0; i < n; i++ {
+		last = NewRandomChunk(64)
+		ldb.Put(last)
+	}
+	<-last.dbStoredC
+
+	if demoted := ldb.demote(); demoted != n-50 {
+		t.Fatalf("expected %d chunks demoted, got %d", n-50, demoted)
+	}
+	if stats := ldb.TierStats(); stats.HotChunks != 50 {
+		t.Fatalf("expected 50 hot chunks, got %s", stats)
+	}
+}
+
+// TestLDBStoreTiersMock tests that the data entries of a store with a mock
+// store are not taken for the stubs of cold chunks
+func TestLDBStoreTiersMock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ldbparams := NewLDBStoreParams(NewDefaultStoreParams(), filepath.Join(dir, "hot"))
+	ldbparams.ColdPath = filepath.Join(dir, "cold")
+	mockStore := mem.NewGlobalStore().NewNodeStore(common.HexToAddress("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"))
+	ldb, err := NewMockDbStore(ldbparams, mockStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+
+	chunk := NewRandomChunk(4096)
+	ldb.Put(chunk)
+	<-chunk.dbStoredC
+	if err := ldb.Resync(chunk.Key); err != nil {
+		t.Fatal(err)
+	}
+	ret, err := ldb.Get(chunk.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ret.SData, chunk.SData) {
+		t.Fatal("expected to get the same data back, but got smth else")
+	}
+	if stats := ldb.TierStats(); stats.ColdChunks != 0 {
+		t.Fatalf("expected no cold chunks, got %s", stats)
+	}
+}
+
+// returns the index entry of the chunk
+func testIndex(t *testing.T, s *LDBStore, key Key) *dpaDBIndex {
+	idata, err := s.db.Get(getIndexKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index dpaDBIndex
+	decodeIndex(idata, &index)
+	return &index
+}
+
+func TestLDBStoreNetworkID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bzz-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ldbparams := NewLDBStoreParams(NewDefaultStoreParams(), dir)
+	ldbparams.NetworkID = 3
+	ldb, err := NewLDBStore(ldbparams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ldb.Close()
+
+	// the store belongs to the network it was first opened for
+	otherparams := *ldbparams
+	otherparams.NetworkID = 42
+	if _, err := NewLDBStore(&otherparams); err == nil {
+		t.Fatal("expected opening the store for another network to fail")
+	}
+	for _, networkID := range []uint64{3, 0} {
+		params := *ldbparams
+		params.NetworkID = networkID
+		ldb, err := NewLDBStore(&params)
+		if err != nil {
+			t.Fatalf("network %d: %v", networkID, err)
+		}
+		ldb.Close()
+	}
+}
+
+// TestLDBStoreRepair tests that the dangling index entries, the orphaned data
+// entries and the stale resource roots left by an unclean shutdown are found
+// and repaired
+func TestLDBStoreRepair(t *testing.T) {
+	ldb, err := newTestDbStore(false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.close()
+
+	chunks := []*Chunk{}
+	for i := 0; i < 3; i++ {
+		c := NewRandomChunk(4096)
+		chunks = append(chunks, c)
+		ldb.Put(c)
+	}
+	// the metadata chunks of two resources
+	var metas []*Chunk
+	for _, name := range []string{"foo.eth", "bar.eth"} {
+		rsrc := &resource{name: name, startBlock: 42, frequency: 13, hash: ResourceHashKeccak256}
+		meta, err := rsrc.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := encodeResourceChunk(append([]byte{0, 0}, meta...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := NewChunk(Key(crypto.Keccak256(data)), nil)
+		c.SData = data
+		metas = append(metas, c)
+		ldb.Put(c)
+	}
+	for _, c := range append(chunks, metas...) {
+		<-c.dbStoredC
+	}
+
+	report, err := ldb.CheckConsistency()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() {
+		t.Fatalf("expected the store to be consistent, got %s", report)
+	}
+
+	// the roots of foo.eth and baz.eth refer to metadata chunks which are not
+	// stored, the other metadata chunk of foo.eth is not trusted
+	foo, bar, baz := ResourceNameHash("foo.eth"), ResourceNameHash("bar.eth"), ResourceNameHash("baz.eth")
+	if err := ldb.PutResourceRoot(foo, Key(crypto.Keccak256([]byte("stale")))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.PutResourceRoot(bar, metas[1].Key); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.PutResourceRoot(baz, Key(crypto.Keccak256([]byte("missing")))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data of the first chunk and the index entry of the second are lost
+	if err := ldb.db.Delete(getDataKey(testIndex(t, ldb.LDBStore, chunks[0].Key).Idx, ldb.po(chunks[0].Key))); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.db.Delete(getIndexKey(chunks[1].Key)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = ldb.Repair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Chunks != 4 || len(report.Dangling) != 1 || !bytes.Equal(report.Dangling[0], chunks[0].Key) || report.Orphans != 1 {
+		t.Fatalf("expected 4 chunks, the first dangling and an orphan, got %s", report)
+	}
+	if report.Roots != 3 || report.StaleRoots != 2 {
+		t.Fatalf("expected 3 resource roots, 2 stale, got %s", report)
+	}
+
+	report, err = ldb.CheckConsistency()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() || report.Chunks != 3 || report.Roots != 1 {
+		t.Fatalf("expected 3 chunks and 1 resource root after the repair, got %s", report)
+	}
+	if _, err := ldb.Get(chunks[2].Key); err != nil {
+		t.Fatal(err)
+	}
+	for _, nameHash := range []common.Hash{foo, baz} {
+		if _, err := ldb.GetResourceRoot(nameHash); err == nil {
+			t.Fatalf("expected the stale root of %x to be removed", nameHash)
+		}
+	}
+	if root, err := ldb.GetResourceRoot(bar); err != nil || !bytes.Equal(root, metas[1].Key) {
+		t.Fatalf("expected the root of bar.eth to be kept, got %v (%v)", root, err)
+	}
+
+	// a store whose index entries are mostly dangling is not changed
+	for _, c := range chunks[2:] {
+		if err := ldb.db.Delete(getDataKey(testIndex(t, ldb.LDBStore, c.Key).Idx, ldb.po(c.Key))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ldb.db.Delete(getDataKey(testIndex(t, ldb.LDBStore, metas[0].Key).Idx, ldb.po(metas[0].Key))); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = ldb.Repair(); err != ErrMostlyDangling {
+		t.Fatalf("expected %v, got %v (%s)", ErrMostlyDangling, err, report)
+	}
+	if report, err = ldb.CheckConsistency(); err != nil || len(report.Dangling) != 2 || report.Chunks != 3 {
+		t.Fatalf("expected the store to be unchanged with 2 of 3 chunks dangling, got %s (%v)", report, err)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+Tiering keeps the data of the least accessed chunks in a second database, the
+cold tier, which can live on a slower and cheaper disk than the main database,
+the hot tier.
+
+The hot tier keeps the index of all chunks, so lookups and garbage collection
+never touch the cold tier. The data entry of a cold chunk in the hot tier is a
+stub holding only the chunk key, so that syncing by storage index still offers
+cold chunks. Its data is stored under the same data key in the cold tier:
+
+	hot:  keyData|po|idx -> key
+	hot:  keyColdTier -> empty, present once the store has a cold tier
+	cold: keyData|po|idx -> key|data
+	cold: keyEntryCnt -> number of cold chunks
+
+A store with a cold tier cannot be opened without it, as the stubs would be
+taken for chunk data.
+
+When the hot tier holds more chunks than its capacity, the least accessed are
+demoted to the cold tier by a background loop every ldbTierDemoteInterval. Cold
+chunks are promoted back to the hot tier when they are retrieved.
+
+Stores with a mock store keep the data of all chunks in the mock store, their
+data entries are never taken for stubs.
+*/
+
+var keyColdTier = []byte{11}
+
+// interval of the background demotion of chunks to the cold tier
+const ldbTierDemoteInterval = 10 * time.Second
+
+var (
+	ldbTierDemoteCount  = metrics.NewRegisteredCounter("ldbstore.tier.demote", nil)
+	ldbTierPromoteCount = metrics.NewRegisteredCounter("ldbstore.tier.promote", nil)
+)
+
+// TierStats are the chunk counts of the tiers of a store
+type TierStats struct {
+	Chunks      uint64 // chunks in the store
+	HotChunks   uint64 // chunks with their data in the hot tier
+	ColdChunks  uint64 // chunks with their data in the cold tier
+	HotCapacity uint64 // chunks kept in the hot tier, 0 if the store has no cold tier
+	Promotions  uint64 // chunks promoted to the hot tier since the store was opened
+	Demotions   uint64 // chunks demoted to the cold tier since the store was opened
+}
+
+func (self *TierStats) String() string {
+	return fmt.Sprintf("%d chunks, %d hot, %d cold, hot capacity %d, %d promoted, %d demoted", self.Chunks, self.HotChunks, self.ColdChunks, self.HotCapacity, self.Promotions, self.Demotions)
+}
+
+// opens the cold tier database at path, or checks that the store has no
+// cold tier if path is empty
+func (s *LDBStore) openColdTier(path string, hotCapacity uint64) (err error) {
+	if path == "" {
+		if _, err := s.db.Get(keyColdTier); err == nil {
+			return errors.New("chunk db has a cold tier, its path must be given")
+		}
+		return nil
+	}
+	if s.cold, err = NewLDBDatabase(path); err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(keyColdTier, nil)
+	if err := s.db.Write(batch); err != nil {
+		s.cold.Close()
+		return err
+	}
+	data, _ := s.cold.Get(keyEntryCnt)
+	s.coldCnt = BytesToU64(data)
+	s.hotCapacity = hotCapacity
+	if s.hotCapacity == 0 {
+		s.hotCapacity = s.capacity / 10
+	}
+	s.demoteQuitC = make(chan struct{})
+	s.demoteDoneC = make(chan struct{})
+	go s.demoteLoop()
+	return nil
+}
+
+// returns true if the data entry in the hot tier is the stub of a cold chunk.
+// The data entries of stores with a mock store hold the chunk key only, they
+// are not stubs.
+func (s *LDBStore) isColdStub(data []byte) bool {
+	return s.cold != nil && s.getDataFunc == nil && len(data) == KeyLength
+}
+
+// demotes chunks to the cold tier every ldbTierDemoteInterval until the
+// store is closed
+func (s *LDBStore) demoteLoop() {
+	defer close(s.demoteDoneC)
+	ticker := time.NewTicker(ldbTierDemoteInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.demoteQuitC:
+			return
+		case <-ticker.C:
+			s.demote()
+		}
+	}
+}
+
+// stops the background demotion, must be called before the databases are
+// closed
+func (s *LDBStore) stopDemote() {
+	if s.demoteQuitC == nil {
+		return
+	}
+	close(s.demoteQuitC)
+	<-s.demoteDoneC
+	s.demoteQuitC = nil
+}
+
+// returns the data of the chunk with the data key from the tier or the shard
+// it is in, and whether it is in the cold tier
+func (s *LDBStore) getData(datakey []byte) ([]byte, bool, error) {
+	data, err := s.db.Get(datakey)
+	if err != nil {
+		return nil, false, err
+	}
+	if isShardStub(data) {
+		data, err = s.getSharded(data)
+		return data, false, err
+	}
+	if !s.isColdStub(data) {
+		return data, false, nil
+	}
+	data, err = s.cold.Get(datakey)
+	if err != nil {
+		return nil, true, err
+	}
+	return data, true, nil
+}
+
+// moves the data of a cold chunk back to the hot tier, must be called with
+// the lock held
+func (s *LDBStore) promote(datakey []byte, data []byte) error {
+	batch := new(leveldb.Batch)
+	batch.Put(datakey, data)
+	if err := s.db.Write(batch); err != nil {
+		return err
+	}
+	s.coldCnt--
+	s.promotions++
+	ldbTierPromoteCount.Inc(1)
+	batch = new(leveldb.Batch)
+	batch.Delete(datakey)
+	batch.Put(keyEntryCnt, U64ToBytes(s.coldCnt))
+	return s.cold.Write(batch)
+}
+
+// deletes the data of a chunk from the cold tier if it is there, must be
+// called with the lock held before deleting the chunk from the hot tier
+func (s *LDBStore) deleteCold(datakey []byte) {
+	if s.cold == nil {
+		return
+	}
+	data, err := s.db.Get(datakey)
+	if err != nil || !s.isColdStub(data) {
+		return
+	}
+	s.coldCnt--
+	batch := new(leveldb.Batch)
+	batch.Delete(datakey)
+	batch.Put(keyEntryCnt, U64ToBytes(s.coldCnt))
+	if err := s.cold.Write(batch); err != nil {
+		log.Warn("ldbstore: cannot delete chunk from cold tier", "err", err)
+	}
+}
+
+// demotes the least accessed chunks of the hot tier to the cold tier until
+// it is within its capacity, and returns the number of demoted chunks.
+//
+// The index is scanned in windows of maxGCitems entries, each window from
+// where the last one stopped, also across calls, so that all chunks are
+// considered in turn. The lock is held for one window at a time, and a call
+// scans the index at most once.
+func (s *LDBStore) demote() int {
+	s.lock.RLock()
+	chunks := int(s.entryCnt)
+	s.lock.RUnlock()
+
+	demoted := 0
+	for scanned := 0; scanned < chunks; {
+		n, window, more := s.demoteWindow()
+		demoted += n
+		scanned += window
+		if !more || window == 0 {
+			break
+		}
+	}
+	if demoted > 0 {
+		log.Debug("ldbstore: demoted chunks to cold tier", "chunks", demoted)
+	}
+	return demoted
+}
+
+// demotes the least accessed chunks of the next window of the index, and
+// returns the number of demoted and scanned chunks, and whether the hot tier
+// still exceeds its capacity
+func (s *LDBStore) demoteWindow() (demoted int, scanned int, more bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// entryCnt starts at one
+	if s.cold == nil || s.getDataFunc != nil || s.entryCnt-1 <= s.coldCnt+s.hotCapacity {
+		return 0, 0, false
+	}
+	excess := int(s.entryCnt - 1 - s.coldCnt - s.hotCapacity)
+
+	items := s.demoteItems()
+	if len(items) == 0 && s.demoteCursor != nil {
+		// the last window ended the index, start over
+		s.demoteCursor = nil
+		items = s.demoteItems()
+	}
+	s.demoteCursor = nil
+	if len(items) == maxGCitems {
+		s.demoteCursor = items[len(items)-1].idxKey
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].value < items[j].value })
+
+	for _, item := range items {
+		if demoted == excess {
+			break
+		}
+		datakey := getDataKey(item.idx, item.po)
+		data, err := s.db.Get(datakey)
+		if err != nil || s.isColdStub(data) || isShardStub(data) {
+			continue
+		}
+		// the data is written to the cold tier before the stub replaces it
+		s.coldCnt++
+		batch := new(leveldb.Batch)
+		batch.Put(datakey, data)
+		batch.Put(keyEntryCnt, U64ToBytes(s.coldCnt))
+		if err := s.cold.Write(batch); err != nil {
+			s.coldCnt--
+			log.Error("ldbstore: cannot demote chunk to cold tier", "err", err)
+			return demoted, len(items), false
+		}
+		batch = new(leveldb.Batch)
+		batch.Put(datakey, data[:KeyLength])
+		if err := s.db.Write(batch); err != nil {
+			log.Error("ldbstore: cannot demote chunk to cold tier", "err", err)
+			return demoted, len(items), false
+		}
+		demoted++
+	}
+	s.demotions += uint64(demoted)
+	ldbTierDemoteCount.Inc(int64(demoted))
+	return demoted, len(items), demoted < excess
+}
+
+// returns at most maxGCitems index entries following the demotion cursor,
+// must be called with the lock held
+func (s *LDBStore) demoteItems() []*gcItem {
+	it := s.db.NewIterator()
+	defer it.Release()
+
+	start := []byte{keyIndex}
+	if s.demoteCursor != nil {
+		start = s.demoteCursor
+	}
+	ok := it.Seek(start)
+	// the cursor is the last entry of the previous window
+	if ok && s.demoteCursor != nil && bytes.Equal(it.Key(), s.demoteCursor) {
+		ok = it.Next()
+	}
+	var items []*gcItem
+	for ; ok && len(items) < maxGCitems; ok = it.Next() {
+		itkey := it.Key()
+		if (itkey == nil) || (itkey[0] != keyIndex) {
+			break
+		}
+		var index dpaDBIndex
+		decodeIndex(it.Value(), &index)
+		key := make([]byte, len(itkey))
+		copy(key, itkey)
+		items = append(items, &gcItem{
+			idxKey: key,
+			idx:    index.Idx,
+			value:  index.Access,
+			po:     s.po(Key(key[1:])),
+		})
+	}
+	return items
+}
+
+// TierStats returns the chunk counts of the tiers of the store
+func (s *LDBStore) TierStats() *TierStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	// entryCnt starts at one
+	chunks := s.entryCnt - 1
+	return &TierStats{
+		Chunks:      chunks,
+		HotChunks:   chunks - s.coldCnt,
+		ColdChunks:  s.coldCnt,
+		HotCapacity: s.hotCapacity,
+		Promotions:  s.promotions,
+		Demotions:   s.demotions,
+	}
+}
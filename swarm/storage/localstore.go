@@ -29,8 +29,11 @@ import (
 
 type LocalStoreParams struct {
 	*StoreParams
-	ChunkDbPath string
-	Validators  []ChunkValidator `toml:"-"`
+	ChunkDbPath   string
+	ColdDbPath    string           // path of the cold tier of the chunk db, no tiering if empty, see TierStats
+	HotDbCapacity uint64           // chunks kept in the hot tier, a tenth of DbCapacity if 0
+	NetworkID     uint64           // network the chunks belong to, see LDBStoreParams.NetworkID
+	Validators    []ChunkValidator `toml:"-"`
 }
 
 func NewDefaultLocalStoreParams() *LocalStoreParams {
@@ -59,6 +62,9 @@ type LocalStore struct {
 // This constructor uses MemStore and DbStore as components
 func NewLocalStore(params *LocalStoreParams, mockStore *mock.NodeStore) (*LocalStore, error) {
 	ldbparams := NewLDBStoreParams(params.StoreParams, params.ChunkDbPath)
+	ldbparams.ColdPath = params.ColdDbPath
+	ldbparams.HotCapacity = params.HotDbCapacity
+	ldbparams.NetworkID = params.NetworkID
 	dbStore, err := NewMockDbStore(ldbparams, mockStore)
 	if err != nil {
 		return nil, err
@@ -148,7 +154,10 @@ func (self *LocalStore) Put(chunk *Chunk) {
 		self.mu.Lock()
 		defer self.mu.Unlock()
 
-		self.memStore.Put(newc)
+		// the chunk may have been deleted meanwhile
+		if c, err := self.memStore.Get(chunk.Key); err == nil && c == chunk {
+			self.memStore.Put(newc)
+		}
 	}()
 }
 
@@ -220,6 +229,26 @@ func (self *LocalStore) RequestsCacheLen() int {
 	return self.memStore.requests.Len()
 }
 
+// Resync offers the chunk to the peers again, see LDBStore.Resync
+func (self *LocalStore) Resync(key Key) error {
+	return self.DbStore.Resync(key)
+}
+
+// Delete removes a stored chunk from the memory and db stores, see
+// LDBStore.Delete
+func (self *LocalStore) Delete(key Key) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.memStore.Delete(key)
+	return self.DbStore.Delete(key)
+}
+
+// AddGCHook adds a garbage collection hook to the db store, see GCHook
+func (self *LocalStore) AddGCHook(hook GCHook) {
+	self.DbStore.AddGCHook(hook)
+}
+
 // Close the local store
 func (self *LocalStore) Close() {
 	self.DbStore.Close()
@@ -90,7 +90,7 @@ func TestValidator(t *testing.T) {
 	goodChunk = GenerateRandomChunk(DefaultChunkSize)
 	key := rh.resourceHash(42, 1, ens.EnsNode("xyzzy.eth"))
 	data := []byte("bar")
-	uglyChunk := newUpdateChunk(key, nil, 42, 1, "xyzzy.eth", data, len(data))
+	uglyChunk := newUpdateChunk(key, nil, nil, 42, 1, "xyzzy.eth", data, 0)
 
 	putChunks(store, goodChunk, badChunk, uglyChunk)
 	if err := goodChunk.GetErrored(); err != nil {
@@ -112,7 +112,7 @@ func TestValidator(t *testing.T) {
 	goodChunk = GenerateRandomChunk(DefaultChunkSize)
 	key = rh.resourceHash(42, 2, ens.EnsNode("xyzzy.eth"))
 	data = []byte("baz")
-	uglyChunk = newUpdateChunk(key, nil, 42, 2, "xyzzy.eth", data, len(data))
+	uglyChunk = newUpdateChunk(key, nil, nil, 42, 2, "xyzzy.eth", data, 0)
 
 	putChunks(store, goodChunk, badChunk, uglyChunk)
 	if goodChunk.GetErrored() == nil {
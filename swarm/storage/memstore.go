@@ -119,6 +119,20 @@ func (m *MemStore) Put(c *Chunk) {
 	m.requests.Remove(string(c.Key))
 }
 
+// Delete removes the chunk from the cache, the chunk must have been stored
+// in the db store already
+func (m *MemStore) Delete(key Key) {
+	if m.disabled {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cache.Remove(string(key))
+	m.requests.Remove(string(key))
+}
+
 func (m *MemStore) setCapacity(n int) {
 	if n <= 0 {
 		m.disabled = true
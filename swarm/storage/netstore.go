@@ -165,10 +165,33 @@ func (self *NetStore) get(key Key, timeout time.Duration) (chunk *Chunk, err err
 	return chunk, nil
 }
 
+// retrieves the chunk from the local store only, it is not requested from
+// the network if missing
+func (self *NetStore) getLocal(key Key) (*Chunk, error) {
+	return self.localStore.Get(key)
+}
+
 // Put is the entrypoint for local store requests coming from storeLoop
 func (self *NetStore) Put(chunk *Chunk) {
 	self.localStore.Put(chunk)
 }
 
+// Resync offers the chunk in the local store to the peers again, see
+// LDBStore.Resync
+func (self *NetStore) Resync(key Key) error {
+	return self.localStore.Resync(key)
+}
+
+// Delete removes a stored chunk from the local store, peers which were
+// offered the chunk already keep it
+func (self *NetStore) Delete(key Key) error {
+	return self.localStore.Delete(key)
+}
+
+// AddGCHook adds a garbage collection hook to the local store, see GCHook
+func (self *NetStore) AddGCHook(hook GCHook) {
+	self.localStore.AddGCHook(hook)
+}
+
 // Close chunk store
 func (self *NetStore) Close() {}
@@ -0,0 +1,321 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+Pinning protects chunks from garbage collection.
+
+A pin is identified by a root key (typically a manifest) and lists all chunks
+reachable from it. Every chunk keeps a reference count of the pins listing it,
+so content shared by several pinned roots (e.g. two manifests referencing the
+same file) stays in the store until the last pin referencing it is removed.
+Chunks with a reference count above zero are skipped by the garbage collector.
+
+The db layout is:
+
+	keyPin|root -> concatenation of the keys of the pinned chunks
+	keyRefCnt|key -> number of pins referencing the chunk
+
+Since reference counts are derived data, CheckPins verifies them against the
+pin lists and RepairPins rebuilds them.
+*/
+
+var (
+	keyPin    = byte(8)
+	keyRefCnt = byte(9)
+)
+
+var (
+	ErrNotPinned = errors.New("root is not pinned")
+)
+
+// Pinner is implemented by chunk stores which can protect chunks from garbage collection
+type Pinner interface {
+	Pin(root Key, keys []Key) error
+	Unpin(root Key) error
+	PinnedRoots() ([]Key, error)
+}
+
+// PinReport is the result of a consistency check of the pins of a store
+type PinReport struct {
+	Roots      int   // number of pinned roots
+	Chunks     int   // number of distinct pinned chunks
+	Missing    []Key // pinned chunks not present in the store
+	Mismatched int   // chunks with a wrong or dangling reference count
+}
+
+func (r *PinReport) String() string {
+	return fmt.Sprintf("%d pinned roots, %d pinned chunks, %d missing chunks, %d wrong reference counts", r.Roots, r.Chunks, len(r.Missing), r.Mismatched)
+}
+
+// Consistent returns true if no problems were found
+func (r *PinReport) Consistent() bool {
+	return len(r.Missing) == 0 && r.Mismatched == 0
+}
+
+func getPinKey(root Key) []byte {
+	return append([]byte{keyPin}, root...)
+}
+
+func getRefCntKey(key Key) []byte {
+	return append([]byte{keyRefCnt}, key...)
+}
+
+// Pin protects the given chunks reachable from root from garbage collection.
+// Pinning an already pinned root has no effect.
+func (s *LDBStore) Pin(root Key, keys []Key) error {
+	metrics.GetOrRegisterCounter("ldbstore.pin", nil).Inc(1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, err := s.db.Get(getPinKey(root)); err == nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var list []byte
+	batch := new(leveldb.Batch)
+	for _, key := range keys {
+		if len(key) != KeyLength {
+			return fmt.Errorf("invalid chunk key length %d", len(key))
+		}
+		if seen[string(key)] {
+			continue
+		}
+		seen[string(key)] = true
+		list = append(list, key...)
+		batch.Put(getRefCntKey(key), U64ToBytes(s.refCount(key)+1))
+	}
+	batch.Put(getPinKey(root), list)
+	log.Debug("ldbstore.pin", "root", root, "chunks", len(seen))
+	return s.db.Write(batch)
+}
+
+// Unpin removes the pin of root, releasing the chunks which are not
+// referenced by other pins to garbage collection
+func (s *LDBStore) Unpin(root Key) error {
+	metrics.GetOrRegisterCounter("ldbstore.unpin", nil).Inc(1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	list, err := s.db.Get(getPinKey(root))
+	if err != nil {
+		return ErrNotPinned
+	}
+	batch := new(leveldb.Batch)
+	for i := 0; i+KeyLength <= len(list); i += KeyLength {
+		key := Key(list[i : i+KeyLength])
+		if cnt := s.refCount(key); cnt > 1 {
+			batch.Put(getRefCntKey(key), U64ToBytes(cnt-1))
+		} else {
+			batch.Delete(getRefCntKey(key))
+		}
+	}
+	batch.Delete(getPinKey(root))
+	log.Debug("ldbstore.unpin", "root", root, "chunks", len(list)/KeyLength)
+	return s.db.Write(batch)
+}
+
+// PinnedRoots returns the roots of all pins
+func (s *LDBStore) PinnedRoots() ([]Key, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var roots []Key
+	it := s.db.NewIterator()
+	defer it.Release()
+	for ok := it.Seek([]byte{keyPin}); ok; ok = it.Next() {
+		key := it.Key()
+		if len(key) == 0 || key[0] != keyPin {
+			break
+		}
+		root := make(Key, len(key)-1)
+		copy(root, key[1:])
+		roots = append(roots, root)
+	}
+	return roots, it.Error()
+}
+
+// RefCount returns the number of pins referencing the chunk
+func (s *LDBStore) RefCount(key Key) uint64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.refCount(key)
+}
+
+func (s *LDBStore) refCount(key Key) uint64 {
+	data, err := s.db.Get(getRefCntKey(key))
+	if err != nil {
+		return 0
+	}
+	return BytesToU64(data)
+}
+
+// CheckPins verifies that the reference counts of all chunks match the pins
+// and that all pinned chunks are present in the store
+func (s *LDBStore) CheckPins() (*PinReport, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	report, _, err := s.checkPins()
+	return report, err
+}
+
+// RepairPins rebuilds the reference counts from the pins. It returns the
+// report of the check done before the repair. Missing chunks cannot be
+// repaired locally, they need to be retrieved again by pinning their
+// roots on a connected node.
+func (s *LDBStore) RepairPins() (*PinReport, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	report, expected, err := s.checkPins()
+	if err != nil || report.Mismatched == 0 {
+		return report, err
+	}
+
+	batch := new(leveldb.Batch)
+	it := s.db.NewIterator()
+	for ok := it.Seek([]byte{keyRefCnt}); ok; ok = it.Next() {
+		key := it.Key()
+		if len(key) == 0 || key[0] != keyRefCnt {
+			break
+		}
+		batch.Delete(append([]byte{}, key...))
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return report, err
+	}
+	for key, cnt := range expected {
+		batch.Put(getRefCntKey(Key(key)), U64ToBytes(cnt))
+	}
+	log.Info("ldbstore.repairpins", "report", report)
+	return report, s.db.Write(batch)
+}
+
+// checkPins must be called with the lock held. It returns the report and the
+// expected reference counts.
+func (s *LDBStore) checkPins() (*PinReport, map[string]uint64, error) {
+	report := &PinReport{}
+	expected := make(map[string]uint64)
+
+	it := s.db.NewIterator()
+	defer it.Release()
+	for ok := it.Seek([]byte{keyPin}); ok; ok = it.Next() {
+		key := it.Key()
+		if len(key) == 0 || key[0] != keyPin {
+			break
+		}
+		report.Roots++
+		list := it.Value()
+		for i := 0; i+KeyLength <= len(list); i += KeyLength {
+			expected[string(list[i:i+KeyLength])]++
+		}
+	}
+	for key := range expected {
+		if _, err := s.db.Get(getIndexKey(Key(key))); err != nil {
+			report.Missing = append(report.Missing, Key(key))
+		}
+	}
+	report.Chunks = len(expected)
+
+	for ok := it.Seek([]byte{keyRefCnt}); ok; ok = it.Next() {
+		key := it.Key()
+		if len(key) == 0 || key[0] != keyRefCnt {
+			break
+		}
+		if cnt, ok := expected[string(key[1:])]; !ok || cnt != BytesToU64(it.Value()) {
+			report.Mismatched++
+		}
+	}
+	// pinned chunks without a reference count
+	for key := range expected {
+		if _, err := s.db.Get(getRefCntKey(Key(key))); err != nil {
+			report.Mismatched++
+		}
+	}
+	return report, expected, it.Error()
+}
+
+// Pin protects the chunks reachable from root in the local store from garbage collection
+func (self *LocalStore) Pin(root Key, keys []Key) error {
+	return self.DbStore.Pin(root, keys)
+}
+
+// Unpin releases a pin of the local store
+func (self *LocalStore) Unpin(root Key) error {
+	return self.DbStore.Unpin(root)
+}
+
+// PinnedRoots returns the roots of all pins of the local store
+func (self *LocalStore) PinnedRoots() ([]Key, error) {
+	return self.DbStore.PinnedRoots()
+}
+
+// Pin protects the chunks reachable from root in the local store from garbage collection
+func (self *NetStore) Pin(root Key, keys []Key) error {
+	return self.localStore.Pin(root, keys)
+}
+
+// Unpin releases a pin of the local store
+func (self *NetStore) Unpin(root Key) error {
+	return self.localStore.Unpin(root)
+}
+
+// PinnedRoots returns the roots of all pins of the local store
+func (self *NetStore) PinnedRoots() ([]Key, error) {
+	return self.localStore.PinnedRoots()
+}
+
+// Chunks calls f with the key of every chunk of the content tree with the
+// given reference, retrieving the intermediate chunks from the store.
+// Encrypted references are decrypted to find the children of intermediate chunks.
+func (self *DPA) Chunks(ref Key, f func(Key) error) error {
+	isEncrypted := len(ref) > self.hashFunc().Size()
+	getter := NewHasherStore(self.ChunkStore, self.hashFunc, isEncrypted)
+	return self.chunks(getter, ref, f)
+}
+
+func (self *DPA) chunks(getter *hasherStore, ref Key, f func(Key) error) error {
+	if err := f(ref[:getter.hashSize]); err != nil {
+		return err
+	}
+	data, err := getter.Get(Reference(ref))
+	if err != nil {
+		return fmt.Errorf("cannot retrieve chunk %v: %v", ref[:getter.hashSize], err)
+	}
+	if len(data) < 8 || data.Size() <= DefaultChunkSize {
+		return nil
+	}
+	// intermediate chunk: the payload is the list of references of the children
+	refSize := int(getter.refSize)
+	for i := 8; i+refSize <= len(data); i += refSize {
+		if err := self.chunks(getter, Key(data[i:i+refSize]), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
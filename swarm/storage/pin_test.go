@@ -0,0 +1,192 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestLDBStorePin checks that chunks shared by two pins survive garbage
+// collection after one of the pins is removed
+func TestLDBStorePin(t *testing.T) {
+	ldb, cleanup := newLDBStore(t)
+	defer cleanup()
+
+	n := 100
+	chunks := make([]*Chunk, n)
+	keys := make([]Key, n)
+	for i := 0; i < n; i++ {
+		chunks[i] = NewRandomChunk(4096)
+		keys[i] = chunks[i].Key
+		ldb.Put(chunks[i])
+	}
+	for i := 0; i < n; i++ {
+		<-chunks[i].dbStoredC
+	}
+
+	rootA, rootB := keys[0], keys[5]
+	if err := ldb.Pin(rootA, keys[0:10]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Pin(rootB, keys[5:15]); err != nil {
+		t.Fatal(err)
+	}
+	// pinning twice has no effect
+	if err := ldb.Pin(rootB, keys[5:15]); err != nil {
+		t.Fatal(err)
+	}
+	if cnt := ldb.RefCount(keys[7]); cnt != 2 {
+		t.Fatalf("expected reference count 2 for shared chunk, got %d", cnt)
+	}
+	roots, err := ldb.PinnedRoots()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 {
+		t.Fatalf("expected 2 pinned roots, got %d", len(roots))
+	}
+
+	if err := ldb.Unpin(rootA); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Unpin(rootA); err != ErrNotPinned {
+		t.Fatalf("expected ErrNotPinned, got %v", err)
+	}
+	if cnt := ldb.RefCount(keys[0]); cnt != 0 {
+		t.Fatalf("expected reference count 0 for released chunk, got %d", cnt)
+	}
+	if cnt := ldb.RefCount(keys[7]); cnt != 1 {
+		t.Fatalf("expected reference count 1 for shared chunk, got %d", cnt)
+	}
+
+	// garbage collect everything which is not pinned
+	ldb.setCapacity(1)
+	for i := 0; i < n; i++ {
+		_, err := ldb.Get(keys[i])
+		pinned := i >= 5 && i < 15
+		if pinned && err != nil {
+			t.Fatalf("pinned chunk %d was garbage collected: %v", i, err)
+		}
+		if !pinned && err == nil {
+			t.Fatalf("expected unpinned chunk %d to be garbage collected", i)
+		}
+	}
+}
+
+// TestLDBStoreRepairPins checks that inconsistent reference counts are
+// detected and rebuilt from the pins
+func TestLDBStoreRepairPins(t *testing.T) {
+	ldb, cleanup := newLDBStore(t)
+	defer cleanup()
+
+	chunks := make([]*Chunk, 4)
+	keys := make([]Key, len(chunks))
+	for i := range chunks {
+		chunks[i] = NewRandomChunk(4096)
+		keys[i] = chunks[i].Key
+		ldb.Put(chunks[i])
+		<-chunks[i].dbStoredC
+	}
+	if err := ldb.Pin(keys[0], keys[0:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Pin(keys[1], keys[1:3]); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ldb.CheckPins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Consistent() || report.Roots != 2 || report.Chunks != 3 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+
+	// lose a reference count, add a dangling one and a missing chunk
+	ldb.db.Delete(getRefCntKey(keys[2]))
+	ldb.db.Put(getRefCntKey(keys[3]), U64ToBytes(1))
+	ldb.db.Put(getRefCntKey(keys[1]), U64ToBytes(5))
+	ldb.delete(ldb.mustIndex(t, keys[0]), getIndexKey(keys[0]), ldb.po(keys[0]))
+
+	report, err = ldb.RepairPins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Mismatched != 3 || len(report.Missing) != 1 {
+		t.Fatalf("unexpected report: %v", report)
+	}
+	report, err = ldb.CheckPins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Mismatched != 0 || len(report.Missing) != 1 {
+		t.Fatalf("unexpected report after repair: %v", report)
+	}
+	if cnt := ldb.RefCount(keys[1]); cnt != 2 {
+		t.Fatalf("expected reference count 2 after repair, got %d", cnt)
+	}
+}
+
+func (s *LDBStore) mustIndex(t *testing.T, key Key) uint64 {
+	data, err := s.db.Get(getIndexKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index dpaDBIndex
+	decodeIndex(data, &index)
+	return index.Idx
+}
+
+// TestDPAChunks checks that all chunks of a content tree are visited
+func TestDPAChunks(t *testing.T) {
+	for _, toEncrypt := range []bool{false, true} {
+		datadir, err := ioutil.TempDir("", "bzz-storage-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(datadir)
+		dpa, err := NewLocalDPA(datadir, make([]byte, 32))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size := 100*int(DefaultChunkSize) + 17
+		key, wait, err := dpa.Store(testDataReader(size), int64(size), toEncrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait()
+
+		seen := make(map[string]bool)
+		err = dpa.Chunks(key, func(k Key) error {
+			if seen[string(k)] {
+				t.Fatalf("chunk %v visited twice", k)
+			}
+			seen[string(k)] = true
+			_, err := dpa.ChunkStore.Get(k)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 101 data chunks and at least a root intermediate chunk
+		if exp := 102; len(seen) < exp {
+			t.Fatalf("encrypted %v: expected at least %d chunks, got %d", toEncrypt, exp, len(seen))
+		}
+	}
+}
@@ -151,6 +151,7 @@ type PyramidChunker struct {
 	wg          *sync.WaitGroup
 	errC        chan error
 	quitC       chan bool
+	readErr     error
 	rootKey     []byte
 	chunkLevel  [][]*TreeEntry
 }
@@ -231,6 +232,9 @@ func (self *PyramidChunker) Split() (k Key, wait func(), err error) {
 		}
 	case <-time.NewTimer(splitTimeout).C:
 	}
+	if self.readErr != nil {
+		return nil, nil, self.readErr
+	}
 	return self.rootKey, self.putter.Wait, nil
 
 }
@@ -262,6 +266,9 @@ func (self *PyramidChunker) Append() (k Key, wait func(), err error) {
 		}
 	case <-time.NewTimer(splitTimeout).C:
 	}
+	if self.readErr != nil {
+		return nil, nil, self.readErr
+	}
 
 	return self.rootKey, self.putter.Wait, nil
 
@@ -467,7 +474,7 @@ func (self *PyramidChunker) prepareChunks(isAppend bool) {
 					break
 				}
 			} else {
-				close(self.quitC)
+				self.readErr = err
 				break
 			}
 		}
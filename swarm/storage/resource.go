@@ -4,20 +4,23 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/big"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/idna"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/contracts/ens"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	lru "github.com/hashicorp/golang-lru"
 )
 
 const (
@@ -29,8 +32,70 @@ const (
 	hasherCount             = 8
 	resourceHash            = SHA3Hash
 	defaultRetrieveTimeout  = 100 * time.Millisecond
+	defaultLookupFanOut     = 8 // periods probed concurrently by lookups, see ParallelLookup
+	defaultMaxIndexEntries  = 10000
+
+	// the fields of the update chunks, see ResourceHandler
+	resourceUpdateFlagsOffset  = 2
+	resourceUpdateLengthOffset = 3
+	resourceUpdatePrefixLength = 7                              // headerlength, flags and datalength fields
+	resourceUpdateHeaderOffset = resourceUpdatePrefixLength + 8 // the header follows period and version
+
+	// updates larger than a chunk are stored with the DPA and the update
+	// chunk holds the swarm reference of the data, which is indicated by
+	// this flag in the flags field
+	resourceDataRefFlag = 0x80
+
+	// multihash data is indicated by this flag in the flags field
+	resourceMultihashFlag = 0x08
+
+	// MaxResourceDataSize is the largest update data accepted, larger data must be uploaded as content
+	MaxResourceDataSize = 16 * 1024 * 1024
+
+	// time based resources are indicated by this flag in the frequency
+	// field of the metadata chunk
+	resourceTimeFlag = uint64(1) << 63
+
+	// updates may be ahead of the current period by this many periods, which
+	// allows for nodes lagging behind the chain
+	defaultMaxFuturePeriods = 1
+	// timeout of getting the current block height when validating updates
+	validateTimeout = 1 * time.Second
+	// block heights retrieved this recently are reused to validate updates
+	recentBlockTTL = 1 * time.Second
+	// block heights retrieved at the same time in the background for validations
+	maxBlockRefreshes = 16
+	// longest retrieve and store timeouts accepted
+	maxResourceTimeout = 5 * time.Minute
+	// the chunks of anchors and revocations found missing are not requested
+	// from the network again for this long, see probe
+	resourceProbeInterval = 1 * time.Minute
 )
 
+// ResourcePeriodScheme selects the unit in which the start and the frequency
+// of a resource are counted
+type ResourcePeriodScheme uint8
+
+const (
+	// BlockPeriods counts in block numbers of the connected chain
+	BlockPeriods ResourcePeriodScheme = iota
+
+	// TimePeriods counts in seconds, starting at a unix timestamp. Time
+	// based resources do not depend on a chain, so they work identically
+	// on any chain or offline.
+	TimePeriods
+)
+
+func (s ResourcePeriodScheme) String() string {
+	switch s {
+	case BlockPeriods:
+		return "block"
+	case TimePeriods:
+		return "time"
+	}
+	return fmt.Sprintf("ResourcePeriodScheme(%d)", uint8(s))
+}
+
 type blockEstimator struct {
 	Start   time.Time
 	Average time.Duration
@@ -57,88 +122,148 @@ func (b *blockEstimator) HeaderByNumber(context.Context, string, *big.Int) (*typ
 	}, nil
 }
 
-type ResourceError struct {
-	code int
-	err  string
-}
+type Signature [signatureLength]byte
 
-func (e *ResourceError) Error() string {
-	return e.err
-}
+// ResourceLookupParams selects how lookups search the periods for updates
+type ResourceLookupParams struct {
+	// Strategy searches the periods, ParallelLookup with the default
+	// fan-out if nil
+	Strategy ResourceLookupStrategy
 
-func (e *ResourceError) Code() int {
-	return e.code
+	// Maximum number of periods walked back by a lookup, unlimited if 0
+	MaxHops uint32
 }
 
-func NewResourceError(code int, s string) error {
-	if code < 0 || code >= ErrCnt {
-		panic("no such error code!")
+// returns the strategy of the lookup
+func (self *ResourceLookupParams) strategy() ResourceLookupStrategy {
+	if self.Strategy == nil {
+		return ParallelLookup{}
 	}
-	r := &ResourceError{
-		err: s,
-	}
-	switch code {
-	case ErrNotFound, ErrIO, ErrUnauthorized, ErrInvalidValue, ErrDataOverflow, ErrNothingToReturn, ErrInvalidSignature, ErrNotSynced, ErrPeriodDepth, ErrCorruptData:
-		r.code = code
-	}
-	return r
+	return self.Strategy
 }
 
-type Signature [signatureLength]byte
-
-type ResourceLookupParams struct {
-	Limit bool
-	Max   uint32
-}
-
-// Encapsulates an specific resource update. When synced it contains the most recent
-// version of the resource update data.
+// Encapsulates the metadata of a resource in the resource index. When synced
+// it holds the most recent update of the resource the handler knows of.
 type resource struct {
-	*bytes.Reader
-	Multihash  bool
 	name       string
 	nameHash   common.Hash
-	startBlock uint64
-	lastPeriod uint32
-	lastKey    Key
+	startBlock uint64 // unix time in seconds for time based resources
+	scheme     ResourcePeriodScheme
+	rootKey    Key // key of the metadata chunk, nil if not known
 	frequency  uint64
-	version    uint32
-	data       []byte
-	updated    time.Time
+	hash       ResourceHashAlgorithm
+
+	acl          *ResourceACL // nil if only the ENS owner may update
+	aclSignature Signature
+
+	indexed time.Time // last stored in the resource index, see ResourceHandlerParams.IndexTTL
+
+	latest     *ResourceView // the most recent update known, nil if not synced
+	latestLock sync.RWMutex
 }
 
-// TODO Expire content after a defined period (to force resync)
+// Resources not synced within the TTL of the index are dropped from it, see
+// ResourceHandlerParams.IndexTTL
 func (self *resource) isSynced() bool {
-	return !self.updated.IsZero()
+	return self.current() != nil
 }
 
-func (self *resource) NameHash() common.Hash {
-	return self.nameHash
+// returns the most recent update of the resource known, nil if it is not
+// synced
+func (self *resource) current() *ResourceView {
+	self.latestLock.RLock()
+	defer self.latestLock.RUnlock()
+	return self.latest
 }
 
-func (self *resource) Size(chan bool) (int64, error) {
-	if !self.isSynced() {
-		return 0, NewResourceError(ErrNotSynced, "Not synced")
+// records the update as the most recent one of the resource, unless a later
+// update is known already. Forced views, such as tombstones, always replace
+// the known update.
+func (self *resource) setCurrent(view *ResourceView, force bool) {
+	self.latestLock.Lock()
+	defer self.latestLock.Unlock()
+	if force || view.supersedes(self.latest) {
+		self.latest = view
 	}
-	return int64(len(self.data)), nil
+}
+
+func (self *resource) NameHash() common.Hash {
+	return self.nameHash
 }
 
 func (self *resource) Name() string {
 	return self.name
 }
 
+// Scheme returns the unit in which the periods of the resource are counted
+func (self *resource) Scheme() ResourcePeriodScheme {
+	return self.scheme
+}
+
+// ACL returns the access control list of the resource, nil if only the
+// ENS owner may update it
+func (self *resource) ACL() *ResourceACL {
+	return self.acl
+}
+
 func (self *resource) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid metadata length %d", len(data)))
+	}
 	self.startBlock = binary.LittleEndian.Uint64(data[:8])
 	self.frequency = binary.LittleEndian.Uint64(data[8:16])
-	self.name = string(data[16:])
+	self.scheme = BlockPeriods
+	if self.frequency&resourceTimeFlag != 0 {
+		self.scheme = TimePeriods
+		self.frequency &^= resourceTimeFlag
+	}
+	self.hash = ResourceHashKeccak256
+	data = data[16:]
+	if self.frequency&resourceHashFlag != 0 {
+		self.frequency &^= resourceHashFlag
+		if len(data) < 1 {
+			return NewResourceError(ErrCorruptData, "Missing hash algorithm")
+		}
+		self.hash = ResourceHashAlgorithm(data[0])
+		if !self.hash.valid() {
+			return NewResourceError(ErrCorruptData, fmt.Sprintf("Unsupported hash algorithm %v", self.hash))
+		}
+		data = data[1:]
+	}
+	self.acl = nil
+	if self.frequency&resourceACLFlag != 0 {
+		self.frequency &^= resourceACLFlag
+		return self.unmarshalACL(data)
+	}
+	self.name = string(data)
 	return nil
 }
 
 func (self *resource) MarshalBinary() ([]byte, error) {
-	b := make([]byte, 16+len(self.name))
+	frequency := self.frequency
+	if self.scheme == TimePeriods {
+		frequency |= resourceTimeFlag
+	}
+	fixed := 16
+	if self.hash != ResourceHashKeccak256 {
+		frequency |= resourceHashFlag
+		fixed++
+	}
+	var b []byte
+	if self.acl != nil {
+		frequency |= resourceACLFlag
+		acl := self.marshalACL()
+		b = make([]byte, fixed+len(acl))
+		copy(b[fixed:], acl)
+	} else {
+		b = make([]byte, fixed+len(self.name))
+		copy(b[fixed:], []byte(self.name))
+	}
+	if fixed > 16 {
+		b[16] = uint8(self.hash)
+	}
 	binary.LittleEndian.PutUint64(b, self.startBlock)
-	binary.LittleEndian.PutUint64(b[8:], self.frequency)
-	copy(b[16:], []byte(self.name))
+	binary.LittleEndian.PutUint64(b[8:], frequency)
 	return b, nil
 }
 
@@ -156,7 +281,8 @@ type ownerValidator interface {
 // a predictable, versionable pattern.
 //
 // Updates are defined to be periodic in nature, where periods are
-// expressed in terms of number of blocks.
+// expressed in terms of number of blocks, or for time based resources
+// in seconds.
 //
 // The root entry of a mutable resource is tied to a unique identifier,
 // typically - but not necessarily - an ens name.  The identifier must be
@@ -173,6 +299,20 @@ type ownerValidator interface {
 // (The two first zero-value bytes are used for disambiguation by the chunk validator,
 // and update chunk will always have a value > 0 there.)
 //
+// For time based resources the highest bit of frequency is set, startblock
+// holds the unix time the resource was created at and frequency is in seconds.
+// Periods are then calculated from the current time instead of the block height.
+//
+// If the third highest bit of frequency is set, the identifier is preceded by
+// a byte declaring the hash algorithm of the update chunk keys and signature
+// digests, see ResourceHashAlgorithm. Otherwise keccak256 is used.
+//
+// If the second highest bit of frequency is set, the identifier is followed by
+// an access control list of the addresses which may update the resource in
+// addition to the ENS owner, signed by the ENS owner:
+//
+// (0x0000|startblock|frequency|identifierlength|identifier|contract|ownercount|owners|signature)
+//
 // The root entry tells the requester from when the mutable resource was
 // first added (block number) and in which block number to look for the
 // actual updates. Thus, a resource update for identifier "føø.bar"
@@ -195,78 +335,286 @@ type ownerValidator interface {
 // A lookup agent need only know the identifier name in order to get the versions
 //
 // the resourcedata is:
-// headerlength|period|version|identifier|data
+// headerlength|flags|datalength|period|version|header|identifier|data
+//
+// the header starts with its version, followed by the creation time of the
+// update in unix seconds and the address of the signer, see ResourceMetadata.
+// The signature covers the creation time as well.
 //
 // if a validator is active, the chunk data is:
 // resourcedata|sign(resourcedata)
 // otherwise, the chunk data is the same as the resourcedata
 //
-// headerlength is a 16 bit value containing the byte length of period|version|name
+// headerlength is a 16 bit value containing the byte length of period|version|header|name,
+// and datalength a 32 bit value containing the byte length of the data
+//
+// data which does not fit in the update chunk is stored with the DPA, and the
+// data field holds its swarm reference. This is indicated by the highest bit
+// of the flags byte. The second highest bit of the flags byte marks the
+// tombstone of a deleted resource, see UpdateTombstone, the third highest bit
+// a revocation of a signing key, see RevokeKey, the fourth encrypted data and
+// the fifth multihash data.
 //
-// TODO: Include modtime in chunk data + signature
+// Metadata and update chunks are stored in a versioned format, which wraps
+// the layout described here, see resourceFormatCurrent.
 type ResourceHandler struct {
-	chunkStore      *NetStore
-	HashSize        int
-	signer          ResourceSigner
-	headerGetter    headerGetter
-	ownerValidator  ownerValidator
-	resources       map[string]*resource
-	hashPool        sync.Pool
-	resourceLock    sync.RWMutex
-	storeTimeout    time.Duration
-	queryMaxPeriods *ResourceLookupParams
+	chunkStore       *NetStore
+	dpa              *DPA
+	HashSize         int
+	signer           ResourceSigner
+	headerGetter     headerGetter
+	ownerValidator   ownerValidator
+	aclValidator     aclValidator
+	resources        *lru.Cache
+	maxIndexEntries  int
+	shards           []*resourceShard // partitions of the resources by namehash, see SetShards
+	indexTTL         time.Duration
+	hashPools        map[ResourceHashAlgorithm]*sync.Pool
+	resourceLock     sync.RWMutex
+	updateLock       sync.Mutex
+	storeTimeout     time.Duration
+	retrieveTimeout  time.Duration
+	queryMaxPeriods  *ResourceLookupParams
+	now              func() time.Time // clock of time based resources
+	pollInterval     time.Duration
+	maxFuturePeriods uint32
+	recentBlocks     *lru.Cache      // last block height retrieved by resource name
+	blockRefreshes   map[string]bool // resource names whose block heights are retrieved for validations
+	recentBlockLock  sync.Mutex
+	tombstones       map[string]*resourceTombstone // tombstones of deleted resources by namehash
+	tombstoneLock    sync.RWMutex
+	revocations      map[string][]ResourceRevocation // revoked signing keys by namehash
+	revocationLock   sync.RWMutex
+	anchors          map[string][]ResourceAnchor // frequency changes by namehash
+	anchorLock       sync.RWMutex
+	missedProbes     *lru.Cache // keys of the chunks found missing by probe, by the time of the probe
+	headSubscriber   headSubscriber
+	head             *uint64               // latest head of the chain, nil if not followed or unknown, see followHeads
+	headPeriods      map[string]headPeriod // current periods of the watched resources at the head by namehash
+	headLock         sync.RWMutex
+	headQuitC        chan struct{}
+	watches          map[string]*resourceWatch // subscriptions by namehash
+	watchLock        sync.Mutex
+	secrets          map[string][]byte // secrets of private resources by namehash, see SetSecret
+	secretLock       sync.RWMutex
+	signers          map[string]ResourceSigner // signers of resources by namehash, see SetSigner
+	signerLock       sync.RWMutex
+	middleware       []ResourceUpdateMiddleware // called around the publication of updates, see AddUpdateMiddleware
+	middlewareLock   sync.RWMutex
+	strict           *resourceStrictness // state of strict validation, nil if disabled
+	refreshInterval  time.Duration
+	refreshes        map[string]bool // resources opted in to refresh by namehash, see SetRefresh
+	refreshLock      sync.RWMutex
+	refreshQuitC     chan struct{}
+	roots            *lru.Cache        // metadata keys stored by namehash, see ResourceRoot
+	rootLock         sync.Mutex
+	ensUpdater       ENSUpdater
+	ensPollInterval  time.Duration
+	updateFilter     ResourceUpdateFilter
+	rateLimiter      *resourceRateLimiter // nil if updates are not rate limited
+	predictor        *resourcePredictor   // nil if the updates of subscribed resources are not prefetched
+
+	allowUnknownMultihash bool
 }
 
 type ResourceHandlerParams struct {
-	QueryMaxPeriods *ResourceLookupParams
-	Signer          ResourceSigner
-	HeaderGetter    headerGetter
-	OwnerValidator  ownerValidator
+	QueryMaxPeriods  *ResourceLookupParams
+	Signer           ResourceSigner
+	HeaderGetter     headerGetter
+	HeadSubscriber   headSubscriber // notifies the heads of the chain, which are then the current block of all block based resources
+	OwnerValidator   ownerValidator
+	ACLValidator     aclValidator         // queries the access control contracts of resources
+	PollInterval     time.Duration        // interval of the lookups for subscribed resources, see Subscribe
+	MaxFuturePeriods uint32               // periods an update may be ahead of the current period, updates further ahead are invalid
+	RetrieveTimeout  time.Duration        // timeout of retrieving a chunk in lookups, default if 0
+	StoreTimeout     time.Duration        // timeout of storing the chunks of an update, default if 0
+	MaxIndexEntries  int                  // resources kept in the index, the least recently used are evicted, default if 0
+	IndexTTL         time.Duration        // resources not synced for this long are dropped from the index, never if 0
+	StrictValidation bool                 // reject updates for periods earlier than the newest seen and record conflicting versions
+	RefreshInterval  time.Duration        // interval of re-publishing the chunks of resources opted in, disabled if 0, see SetRefresh
+	ENSUpdater       ENSUpdater           // sets the content records of the names of new resources to their metadata chunk, see WaitRegistered
+	UpdateFilter     ResourceUpdateFilter // rejects the update chunks of names and signers, all accepted if nil
+	UpdateRateLimit  *ResourceRateLimit   // bounds the update chunks accepted per name, per signer and in total, unlimited if nil
+	PrefetchAhead    time.Duration        // the updates of subscribed resources are retrieved this long before their period starts, not prefetched if 0
+
+	// AllowUnknownMultihash accepts multihash updates of codes other than
+	// sha2-256, keccak-256 and blake2b, whose digest length is not checked
+	AllowUnknownMultihash bool
+}
+
+// resourceTimeoutsKey is the context key of the timeouts set with WithResourceTimeouts
+type resourceTimeoutsKey struct{}
+
+type resourceTimeouts struct {
+	retrieve time.Duration
+	store    time.Duration
+}
+
+// WithResourceTimeouts returns a context which overrides the retrieve and
+// store timeouts of the ResourceHandler for the calls made with it.
+//
+// A zero timeout keeps the timeout of the handler. Timeouts out of the range
+// accepted by NewResourceHandler are ignored.
+func WithResourceTimeouts(ctx context.Context, retrieve time.Duration, store time.Duration) context.Context {
+	return context.WithValue(ctx, resourceTimeoutsKey{}, resourceTimeouts{
+		retrieve: retrieve,
+		store:    store,
+	})
+}
+
+// checks that a timeout is positive and not unreasonably long
+func validateTimeoutParam(name string, timeout time.Duration) error {
+	if timeout < 0 || timeout > maxResourceTimeout {
+		return NewResourceError(ErrInvalidValue, fmt.Sprintf("%s must be between 0 and %v, got %v", name, maxResourceTimeout, timeout))
+	}
+	return nil
 }
 
 // Create or open resource update chunk store
 func NewResourceHandler(params *ResourceHandlerParams) (*ResourceHandler, error) {
+	if err := validateTimeoutParam("RetrieveTimeout", params.RetrieveTimeout); err != nil {
+		return nil, err
+	} else if err := validateTimeoutParam("StoreTimeout", params.StoreTimeout); err != nil {
+		return nil, err
+	}
 	if params.QueryMaxPeriods == nil {
-		params.QueryMaxPeriods = &ResourceLookupParams{
-			Limit: false,
-		}
+		params.QueryMaxPeriods = &ResourceLookupParams{}
 	}
 	rh := &ResourceHandler{
-		headerGetter:   params.HeaderGetter,
-		ownerValidator: params.OwnerValidator,
-		resources:      make(map[string]*resource),
-		storeTimeout:   defaultStoreTimeout,
-		signer:         params.Signer,
-		hashPool: sync.Pool{
-			New: func() interface{} {
-				return MakeHashFunc(resourceHash)()
-			},
-		},
+		headerGetter:    params.HeaderGetter,
+		ownerValidator:  params.OwnerValidator,
+		aclValidator:    params.ACLValidator,
+		indexTTL:        params.IndexTTL,
+		storeTimeout:    params.StoreTimeout,
+		retrieveTimeout: params.RetrieveTimeout,
+		signer:          params.Signer,
+		hashPools:       newResourceHashPools(),
 		queryMaxPeriods: params.QueryMaxPeriods,
+		now:             time.Now,
+		pollInterval:    params.PollInterval,
+		watches:         make(map[string]*resourceWatch),
+		blockRefreshes:  make(map[string]bool),
+		tombstones:      make(map[string]*resourceTombstone),
+		revocations:     make(map[string][]ResourceRevocation),
+		anchors:         make(map[string][]ResourceAnchor),
+		headSubscriber:  params.HeadSubscriber,
+		headPeriods:     make(map[string]headPeriod),
+		secrets:         make(map[string][]byte),
+		signers:         make(map[string]ResourceSigner),
+		refreshInterval: params.RefreshInterval,
+		refreshes:       make(map[string]bool),
+		ensUpdater:      params.ENSUpdater,
+		ensPollInterval: defaultENSPollInterval,
+		updateFilter:    params.UpdateFilter,
+
+		allowUnknownMultihash: params.AllowUnknownMultihash,
+	}
+	if rh.pollInterval == 0 {
+		rh.pollInterval = defaultPollInterval
+	}
+	if rh.storeTimeout == 0 {
+		rh.storeTimeout = defaultStoreTimeout
+	}
+	if rh.retrieveTimeout == 0 {
+		rh.retrieveTimeout = defaultRetrieveTimeout
+	}
+	maxIndexEntries := params.MaxIndexEntries
+	if maxIndexEntries == 0 {
+		maxIndexEntries = defaultMaxIndexEntries
+	}
+	var err error
+	if rh.resources, err = rh.newIndex(maxIndexEntries); err != nil {
+		return nil, WrapResourceError(ErrInvalidValue, fmt.Sprintf("Invalid MaxIndexEntries %d", maxIndexEntries), err)
+	}
+	rh.maxIndexEntries = maxIndexEntries
+	if rh.missedProbes, err = lru.New(maxIndexEntries); err != nil {
+		return nil, err
+	}
+	if rh.roots, err = lru.New(maxIndexEntries); err != nil {
+		return nil, err
+	}
+	if rh.recentBlocks, err = lru.New(maxIndexEntries); err != nil {
+		return nil, err
+	}
+	if params.StrictValidation {
+		rh.strict = newResourceStrictness()
+	}
+	if params.PrefetchAhead > 0 {
+		rh.predictor = newResourcePredictor(params.PrefetchAhead)
+	}
+	if params.UpdateRateLimit != nil {
+		if rh.rateLimiter, err = newResourceRateLimiter(params.UpdateRateLimit); err != nil {
+			return nil, err
+		}
+	}
+	rh.maxFuturePeriods = params.MaxFuturePeriods
+	if rh.maxFuturePeriods == 0 {
+		rh.maxFuturePeriods = defaultMaxFuturePeriods
 	}
 
-	for i := 0; i < hasherCount; i++ {
-		hashfunc := MakeHashFunc(resourceHash)()
-		if rh.HashSize == 0 {
-			rh.HashSize = hashfunc.Size()
+	for hash, pool := range rh.hashPools {
+		for i := 0; i < hasherCount; i++ {
+			hashfunc := MakeHashFunc(resourceHashNames[hash])()
+			if rh.HashSize == 0 {
+				rh.HashSize = hashfunc.Size()
+			}
+			pool.Put(hashfunc)
 		}
-		rh.hashPool.Put(hashfunc)
 	}
 
 	return rh, nil
 }
 
+// returns the chunk retrieve timeout of lookups made with ctx
+func (self *ResourceHandler) getRetrieveTimeout(ctx context.Context) time.Duration {
+	if timeouts, ok := ctx.Value(resourceTimeoutsKey{}).(resourceTimeouts); ok && timeouts.retrieve > 0 && timeouts.retrieve <= maxResourceTimeout {
+		return timeouts.retrieve
+	}
+	return self.retrieveTimeout
+}
+
+// returns the chunk store timeout of updates made with ctx
+func (self *ResourceHandler) getStoreTimeout(ctx context.Context) time.Duration {
+	if timeouts, ok := ctx.Value(resourceTimeoutsKey{}).(resourceTimeouts); ok && timeouts.store > 0 && timeouts.store <= maxResourceTimeout {
+		return timeouts.store
+	}
+	return self.storeTimeout
+}
+
 // Sets the store backend for resource updates
 func (self *ResourceHandler) SetStore(store *NetStore) {
 	self.chunkStore = store
+	self.dpa = NewDPA(store, NewDPAParams())
+	store.AddGCHook(self.isGarbage)
+	if self.refreshInterval > 0 && self.refreshQuitC == nil {
+		self.refreshQuitC = make(chan struct{})
+		go self.refreshLoop(self.refreshQuitC)
+	}
+	if self.headSubscriber != nil && self.headQuitC == nil {
+		self.headQuitC = make(chan struct{})
+		go self.followHeads(self.headQuitC)
+	}
 }
 
 // Chunk Validation method (matches ChunkValidatorFunc signature)
 //
 // If resource update, owner is checked against ENS record of resource name inferred from chunk data
-// If parsed signature is nil, validates automatically
+// If the handler does not sign updates, unsigned updates validate automatically.
+// Otherwise updates without a signature are invalid.
 // If not resource update, it validates are metadata chunk if length is metadataChunkOffsetSize and first two bytes are 0
+//
+// Updates for periods further in the future than MaxFuturePeriods are invalid,
+// as are updates following the tombstone of a deleted resource and updates
+// signed with revoked keys. Revocations must be signed by the owner.
+//
+// With StrictValidation, updates for periods earlier than the newest seen are
+// invalid as well, see checkMonotonic.
 func (self *ResourceHandler) Validate(key Key, data []byte) bool {
+	data, err := decodeResourceChunk(data)
+	if err != nil {
+		log.Error("Invalid resource chunk format", "err", err)
+		return false
+	}
 	signature, period, version, name, parseddata, _, err := self.parseUpdate(data)
 	if err != nil {
 		if len(data) > metadataChunkOffsetSize { // identifier comes after this byte range, and must be at least one byte
@@ -276,18 +624,90 @@ func (self *ResourceHandler) Validate(key Key, data []byte) bool {
 		}
 		log.Error("Invalid resource chunk")
 		return false
+	} else if !self.filterName(name) {
+		return false
+	}
+	periodOk, bounded := self.checkPeriod(name, period)
+	if !periodOk {
+		return false
+	} else if tombstone := self.getTombstone(ResourceNameHash(name).Hex()); tombstone != nil && tombstone.follows(period, version) {
+		log.Warn("Resource update after tombstone", "name", name, "period", period, "version", version)
+		return false
 	} else if signature == nil {
-		return bytes.Equal(self.resourceHash(period, version, ens.EnsNode(name)), key)
+		// updates must be signed if we sign them, anchors and revocations always
+		if self.signer != nil || isAnchorUpdate(period, version) || isRevocationUpdate(data) {
+			log.Warn("Unsigned resource update", "name", name, "period", period, "version", version)
+			return false
+		}
+		nameHash := ResourceNameHash(name)
+		for _, hash := range self.hashAlgorithms(nameHash) {
+			if bytes.Equal(self.resourceHashWith(hash, period, version, nameHash), key) {
+				// unsigned updates are never recorded, anyone can make them
+				return self.checkMonotonic(nameHash, period) && self.checkRate(key, name, nameHash, common.Address{})
+			}
+		}
+		return false
 	}
 
-	digest := self.keyDataHash(key, parseddata)
-	addr, err := getAddressFromDataSig(digest, *signature)
+	addr, err := self.recoverSigner(ResourceNameHash(name), key, data, parseddata, signature)
 	if err != nil {
-		log.Error("Invalid signature on resource chunk")
+		log.Error("Invalid signature on resource chunk", "err", err)
+		return false
+	} else if !self.filterSigner(name, addr) {
 		return false
 	}
-	ok, _ := self.checkAccess(name, addr)
-	return ok
+	if isRevocationUpdate(data) || isAnchorUpdate(period, version) {
+		ok, _ := self.checkOwner(name, addr)
+		return ok
+	} else if self.isRevoked(ResourceNameHash(name).Hex(), addr, period) {
+		log.Warn("Resource update signed with revoked key", "name", name, "address", addr, "period", period)
+		return false
+	}
+	if ok, _ := self.checkAccess(name, addr); !ok {
+		return false
+	}
+	nameHash := ResourceNameHash(name)
+	if !self.checkMonotonic(nameHash, period) {
+		return false
+	} else if !self.checkRate(key, name, nameHash, addr) {
+		return false
+	}
+	self.recordMonotonic(nameHash, key, period, version, data, parseddata, bounded)
+	// a valid update of the owner confirms the metadata chunk of the resource in the index
+	self.confirmRoot(self.getResource(nameHash.Hex()), addr)
+	return true
+}
+
+// Checks that the period of an update is not further ahead of the current
+// period than allowed. bounded is true if the period was compared with the
+// current period.
+//
+// The periods are known for resources loaded in the handler, and for those
+// whose metadata chunk is in the local store, updates of other resources
+// pass. Validation does not wait for the chain: block based resources are
+// checked against the head of the chain or a block height retrieved recently.
+// Without either the update passes as well, and the block height is retrieved
+// in the background for the next updates.
+func (self *ResourceHandler) checkPeriod(name string, period uint32) (ok bool, bounded bool) {
+	rsrc := self.localResource(ResourceNameHash(name))
+	if rsrc == nil || rsrc.frequency == 0 {
+		return true, false
+	}
+	current, ok := self.cachedCurrent(rsrc)
+	if !ok {
+		log.Trace("No current period to validate resource update", "name", name)
+		return true, false
+	}
+	currentPeriod, err := self.getPeriod(rsrc, current)
+	if err != nil {
+		return true, false
+	}
+	if uint64(period) > uint64(currentPeriod)+uint64(self.maxFuturePeriods) {
+		metrics.GetOrRegisterCounter("resource.validate.future", nil).Inc(1)
+		log.Warn("Resource update period in the future", "name", name, "period", period, "current", currentPeriod)
+		return false, true
+	}
+	return true, true
 }
 
 // If no ens client is supplied, resource updates are not validated
@@ -296,54 +716,87 @@ func (self *ResourceHandler) IsValidated() bool {
 }
 
 // Create the resource update digest used in signatures
-func (self *ResourceHandler) keyDataHash(key Key, data []byte) common.Hash {
-	hasher := self.hashPool.Get().(SwarmHash)
-	defer self.hashPool.Put(hasher)
-	hasher.Reset()
-	hasher.Write(key[:])
-	hasher.Write(data)
-	return common.BytesToHash(hasher.Sum(nil))
+func (self *ResourceHandler) keyDataHash(nameHash common.Hash, key Key, modTime uint64, data []byte) common.Hash {
+	return self.keyDataHashWith(self.hashAlgorithm(nameHash), key, modTime, data)
 }
 
 // Checks if current address matches owner address of ENS
-func (self *ResourceHandler) checkAccess(name string, address common.Address) (bool, error) {
-	if self.ownerValidator == nil {
+//
+// Topic feeds are owned by the user address in their name, see Topic.Name.
+func (self *ResourceHandler) checkOwner(name string, address common.Address) (bool, error) {
+	if owner, ok := topicOwner(name); ok {
+		return owner == address, nil
+	} else if self.ownerValidator == nil {
 		return true, nil
 	}
 	return self.ownerValidator.ValidateOwner(name, address)
 }
 
-// Get the currently loaded data from the resource
+// returns an unauthorized error if the address does not have access to
+// update the resource of the given name, see checkAccess
+func (self *ResourceHandler) checkSignerAccess(name string, addr common.Address) error {
+	ok, err := self.checkAccess(name, addr)
+	if err != nil {
+		return WrapResourceError(ErrIO, "Access check fail", err)
+	} else if !ok {
+		return NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x does not have access to update %s", addr, name))
+	}
+	return nil
+}
+
+// IsOwner returns true if the address owns the resource of the given name,
+// see checkOwner. Handlers without owner validator take any address as owner.
+func (self *ResourceHandler) IsOwner(name string, address common.Address) (bool, error) {
+	return self.checkOwner(name, address)
+}
+
+// Get the data of the most recent update of the resource the handler knows of
+//
+// Lookups of earlier updates do not change it, their data is in the
+// ResourceView they return.
 func (self *ResourceHandler) GetContent(nameHash string) (string, []byte, error) {
-	rsrc := self.getResource(nameHash)
-	if rsrc == nil {
-		return "", nil, NewResourceError(ErrNotFound, "Resource does not exist")
-	} else if !rsrc.isSynced() {
-		return "", nil, NewResourceError(ErrNotSynced, "Resource is not synced")
+	view, err := self.getCurrentView(nameHash)
+	if err != nil {
+		return "", nil, err
+	} else if self.getTombstone(nameHash) != nil {
+		return "", nil, NewResourceError(ErrGone, "Resource was deleted")
+	}
+	data, err := view.Data()
+	if err != nil {
+		return "", nil, err
 	}
-	return rsrc.name, rsrc.data, nil
+	return view.name, data, nil
 }
 
-// Gets the period of the current data loaded in the resource
+// Gets the period of the most recent update of the resource the handler knows of
 func (self *ResourceHandler) GetLastPeriod(nameHash string) (uint32, error) {
-	rsrc := self.getResource(nameHash)
-	if rsrc == nil {
-		return 0, NewResourceError(ErrNotFound, "Resource does not exist")
-	} else if !rsrc.isSynced() {
-		return 0, NewResourceError(ErrNotSynced, "Resource is not synced")
+	view, err := self.getCurrentView(nameHash)
+	if err != nil {
+		return 0, err
 	}
-	return rsrc.lastPeriod, nil
+	return view.lastPeriod, nil
 }
 
-// Gets the version of the current data loaded in the resource
+// Gets the version of the most recent update of the resource the handler knows of
 func (self *ResourceHandler) GetVersion(nameHash string) (uint32, error) {
+	view, err := self.getCurrentView(nameHash)
+	if err != nil {
+		return 0, err
+	}
+	return view.version, nil
+}
+
+// returns the most recent update of the resource in the index
+func (self *ResourceHandler) getCurrentView(nameHash string) (*ResourceView, error) {
 	rsrc := self.getResource(nameHash)
 	if rsrc == nil {
-		return 0, NewResourceError(ErrNotFound, "Resource does not exist")
-	} else if !rsrc.isSynced() {
-		return 0, NewResourceError(ErrNotSynced, "Resource is not synced")
+		return nil, NewResourceError(ErrNotFound, "Resource does not exist")
+	}
+	view := rsrc.current()
+	if view == nil {
+		return nil, NewResourceError(ErrNotSynced, "Resource is not synced")
 	}
-	return rsrc.version, nil
+	return view, nil
 }
 
 // \TODO should be hashsize * branches from the chosen chunker, implement with dpa
@@ -356,11 +809,32 @@ func (self *ResourceHandler) chunkSize() int64 {
 // The signature data should match the hash of the idna-converted name by the validator's namehash function, NOT the raw name bytes.
 //
 // The start block of the resource update will be the actual current block height of the connected network.
+//
+// If the handler has an ENS updater, the transaction setting the content record of the name
+// to the key of the metadata chunk is submitted, see WaitRegistered.
 func (self *ResourceHandler) NewResource(ctx context.Context, name string, frequency uint64) (Key, *resource, error) {
+	return self.NewResourceWithScheme(ctx, name, frequency, BlockPeriods)
+}
+
+// Creates a new root entry for a mutable resource like NewResource, with
+// periods counted in the given scheme.
+//
+// Time based resources start at the current time and their frequency is in seconds.
+func (self *ResourceHandler) NewResourceWithScheme(ctx context.Context, name string, frequency uint64, scheme ResourcePeriodScheme) (Key, *resource, error) {
+	return self.newResource(ctx, name, frequency, scheme, ResourceHashKeccak256, nil)
+}
+
+func (self *ResourceHandler) newResource(ctx context.Context, name string, frequency uint64, scheme ResourcePeriodScheme, hash ResourceHashAlgorithm, acl *ResourceACL) (_ Key, _ *resource, err error) {
+	defer annotateResourceError(&err, name, 0, 0)
 
 	// frequency 0 is invalid
 	if frequency == 0 {
 		return nil, nil, NewResourceError(ErrInvalidValue, "Frequency cannot be 0")
+	} else if frequency&(resourceTimeFlag|resourceACLFlag|resourceHashFlag) != 0 {
+		return nil, nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Frequency too large: %d", frequency))
+	}
+	if scheme != BlockPeriods && scheme != TimePeriods {
+		return nil, nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid period scheme %v", scheme))
 	}
 
 	// make sure name only contains ascii values
@@ -368,19 +842,24 @@ func (self *ResourceHandler) NewResource(ctx context.Context, name string, frequ
 		return nil, nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid name: '%s'", name))
 	}
 
-	nameHash := ens.EnsNode(name)
+	nameHash := ResourceNameHash(name)
+
+	signer, err := self.signerFor(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// if the signer function is set, validate that the key of the signer has access to modify this ENS name
-	if self.signer != nil {
-		signature, err := self.signer.Sign(nameHash)
+	if signer != nil {
+		signature, err := signer.Sign(nameHash)
 		if err != nil {
-			return nil, nil, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Sign fail: %v", err))
+			return nil, nil, WrapResourceError(ErrInvalidSignature, "Sign fail", err)
 		}
 		addr, err := getAddressFromDataSig(nameHash, signature)
 		if err != nil {
-			return nil, nil, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Retrieve address from signature fail: %v", err))
+			return nil, nil, WrapResourceError(ErrInvalidSignature, "Retrieve address from signature fail", err)
 		}
-		ok, err := self.checkAccess(name, addr)
+		ok, err := self.checkOwner(name, addr)
 		if err != nil {
 			return nil, nil, err
 		} else if !ok {
@@ -388,58 +867,89 @@ func (self *ResourceHandler) NewResource(ctx context.Context, name string, frequ
 		}
 	}
 
-	// get our blockheight at this time
-	currentblock, err := self.getBlock(ctx, name)
+	// create the internal index for the resource and populate it with the data of the first version
+	rsrc := &resource{
+		frequency: frequency,
+		scheme:    scheme,
+		name:      name,
+		nameHash:  nameHash,
+		hash:      hash,
+		acl:       acl,
+	}
+
+	// get our blockheight (or time) at this time
+	currentblock, err := self.getCurrent(ctx, rsrc)
 	if err != nil {
 		return nil, nil, err
 	}
+	rsrc.startBlock = currentblock
 
-	chunk := self.newMetaChunk(name, currentblock, frequency)
+	// the access control list is signed by the owner along with the rest of the metadata
+	if acl != nil && signer != nil {
+		digest, err := self.aclDigest(rsrc)
+		if err != nil {
+			return nil, nil, err
+		}
+		rsrc.aclSignature, err = signer.Sign(digest)
+		if err != nil {
+			return nil, nil, WrapResourceError(ErrInvalidSignature, "Sign fail", err)
+		}
+	}
+
+	chunk, err := self.newMetaChunk(rsrc)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	self.chunkStore.Put(chunk)
-	log.Debug("new resource", "name", name, "key", nameHash, "startBlock", currentblock, "frequency", frequency)
+	log.Debug("new resource", "name", name, "key", nameHash, "startBlock", currentblock, "frequency", frequency, "scheme", scheme)
 
-	// create the internal index for the resource and populate it with the data of the first version
-	rsrc := &resource{
-		startBlock: currentblock,
-		frequency:  frequency,
-		name:       name,
-		nameHash:   nameHash,
-		updated:    time.Now(),
-	}
+	rsrc.rootKey = chunk.Key
+	rsrc.setCurrent(rsrc.newView(), true)
 	self.setResource(nameHash.Hex(), rsrc)
+	self.rememberRoot(nameHash, chunk.Key)
+
+	// the resource is created even if its content record cannot be set, so
+	// its key is returned along with the error. Topic feed names are not ENS
+	// names and have no content record.
+	if _, topic := topicOwner(name); self.ensUpdater != nil && !topic {
+		if err := self.registerName(name, chunk.Key); err != nil {
+			return chunk.Key, rsrc, err
+		}
+	}
 
 	return chunk.Key, rsrc, nil
 }
 
-func (self *ResourceHandler) newMetaChunk(name string, startBlock uint64, frequency uint64) *Chunk {
+func (self *ResourceHandler) newMetaChunk(rsrc *resource) (*Chunk, error) {
 	// the metadata chunk points to data of first blockheight + update frequency
 	// from this we know from what blockheight we should look for updates, and how often
 	// it also contains the name of the resource, so we know what resource we are working with
-	data := make([]byte, metadataChunkOffsetSize+len(name))
+	meta, err := rsrc.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
 
 	// root block has first two bytes both set to 0, which distinguishes from update bytes
-	val := make([]byte, 8)
-	binary.LittleEndian.PutUint64(val, startBlock)
-	copy(data[2:10], val)
-	binary.LittleEndian.PutUint64(val, frequency)
-	copy(data[10:18], val)
-	copy(data[18:], []byte(name))
+	data := make([]byte, 2+len(meta))
+	copy(data[2:], meta)
+	data, err = encodeResourceChunk(data)
+	if err != nil {
+		return nil, err
+	}
 
 	// the key of the metadata chunk is content-addressed
 	// if it wasn't we couldn't replace it later
 	// resolving this relationship is left up to external agents (for example ENS)
-	hasher := self.hashPool.Get().(SwarmHash)
-	hasher.Reset()
+	hasher := self.getHasher(rsrc.hash, len(data))
 	hasher.Write(data)
 	key := hasher.Sum(nil)
-	self.hashPool.Put(hasher)
+	self.putHasher(rsrc.hash, hasher)
 
 	// make the chunk and send it to swarm
 	chunk := NewChunk(key, nil)
-	chunk.SData = make([]byte, metadataChunkOffsetSize+len(name))
-	copy(chunk.SData, data)
-	return chunk
+	chunk.SData = data
+	return chunk, nil
 }
 
 // Searches and retrieves the specific version of the resource update identified by `name`
@@ -449,36 +959,69 @@ func (self *ResourceHandler) newMetaChunk(name string, startBlock uint64, freque
 // metadata chunk.
 // It is the callers responsibility to make sure that this chunk exists (if the resource
 // update root data was retrieved externally, it typically doesn't)
-func (self *ResourceHandler) LookupVersionByName(ctx context.Context, name string, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
-	return self.LookupVersion(ctx, ens.EnsNode(name), period, version, refresh, maxLookup)
+//
+// Resources not in the index are loaded with the key of their metadata chunk
+// if the handler created or loaded them before, see ResourceRoot.
+func (self *ResourceHandler) LookupVersionByName(ctx context.Context, name string, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*ResourceView, error) {
+	return self.LookupVersion(ctx, ResourceNameHash(name), period, version, refresh, maxLookup)
 }
 
-func (self *ResourceHandler) LookupVersion(ctx context.Context, nameHash common.Hash, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
-	rsrc := self.getResource(nameHash.Hex())
-	if rsrc == nil {
-		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
+func (self *ResourceHandler) LookupVersion(ctx context.Context, nameHash common.Hash, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*ResourceView, error) {
+	rsrc, err := self.loadResource(nameHash)
+	if err != nil {
+		return nil, err
 	}
-	return self.lookup(rsrc, period, version, refresh, maxLookup)
+	return self.lookup(ctx, rsrc, period, version, refresh, maxLookup)
 }
 
 // Retrieves the latest version of the resource update identified by `name`
 // at the specified block height
 //
 // If an update is found, version numbers are iterated until failure, and the last
-// successfully retrieved version is copied to the corresponding resources map entry
-// and returned.
+// successfully retrieved version is returned. It becomes the update the
+// resources map entry holds if it is the most recent one known.
 //
 // See also (*ResourceHandler).LookupVersion
-func (self *ResourceHandler) LookupHistoricalByName(ctx context.Context, name string, period uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
-	return self.LookupHistorical(ctx, ens.EnsNode(name), period, refresh, maxLookup)
+func (self *ResourceHandler) LookupHistoricalByName(ctx context.Context, name string, period uint32, refresh bool, maxLookup *ResourceLookupParams) (*ResourceView, error) {
+	return self.LookupHistorical(ctx, ResourceNameHash(name), period, refresh, maxLookup)
 }
 
-func (self *ResourceHandler) LookupHistorical(ctx context.Context, nameHash common.Hash, period uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
-	rsrc := self.getResource(nameHash.Hex())
-	if rsrc == nil {
-		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
+func (self *ResourceHandler) LookupHistorical(ctx context.Context, nameHash common.Hash, period uint32, refresh bool, maxLookup *ResourceLookupParams) (*ResourceView, error) {
+	rsrc, err := self.loadResource(nameHash)
+	if err != nil {
+		return nil, err
 	}
-	return self.lookup(rsrc, period, 0, refresh, maxLookup)
+	return self.lookup(ctx, rsrc, period, 0, refresh, maxLookup)
+}
+
+// LookupAt retrieves the resource as it was at the position on its period
+// scale, a block height for block based resources and a unix time for time
+// based ones: the latest version of the period of the position, or of the
+// last period before it with an update. Positions ahead of the current one are
+// taken as the current one.
+//
+// Updates are found by period, so versions of the period made after the
+// position are included.
+func (self *ResourceHandler) LookupAt(ctx context.Context, nameHash common.Hash, at uint64, refresh bool, maxLookup *ResourceLookupParams) (*ResourceView, error) {
+	rsrc, err := self.loadResource(nameHash)
+	if err != nil {
+		return nil, err
+	}
+	if refresh {
+		self.loadAnchors(ctx, rsrc)
+	}
+	current, err := self.getCurrent(ctx, rsrc)
+	if err != nil {
+		return nil, err
+	}
+	if at > current {
+		at = current
+	}
+	period, err := self.getPeriod(rsrc, at)
+	if err != nil {
+		return nil, err
+	}
+	return self.lookup(ctx, rsrc, period, 0, refresh, maxLookup)
 }
 
 // Retrieves the latest version of the resource update identified by `name`
@@ -490,62 +1033,76 @@ func (self *ResourceHandler) LookupHistorical(ctx context.Context, nameHash comm
 //
 // Version iteration is done as in (*ResourceHandler).LookupHistorical
 //
+// The periods are searched with the strategy of maxLookup, see
+// ResourceLookupStrategy
+//
 // See also (*ResourceHandler).LookupHistorical
-func (self *ResourceHandler) LookupLatestByName(ctx context.Context, name string, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
-	return self.LookupLatest(ctx, ens.EnsNode(name), refresh, maxLookup)
+func (self *ResourceHandler) LookupLatestByName(ctx context.Context, name string, refresh bool, maxLookup *ResourceLookupParams) (*ResourceView, error) {
+	return self.LookupLatest(ctx, ResourceNameHash(name), refresh, maxLookup)
+}
+
+func (self *ResourceHandler) LookupLatest(ctx context.Context, nameHash common.Hash, refresh bool, maxLookup *ResourceLookupParams) (*ResourceView, error) {
+	return self.lookupLatest(ctx, nameHash, refresh, maxLookup, self.getCurrent)
 }
 
-func (self *ResourceHandler) LookupLatest(ctx context.Context, nameHash common.Hash, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
+// like LookupLatest, with the current position of the resource on its period
+// scale given by current
+func (self *ResourceHandler) lookupLatest(ctx context.Context, nameHash common.Hash, refresh bool, maxLookup *ResourceLookupParams, current func(context.Context, *resource) (uint64, error)) (*ResourceView, error) {
 
 	// get our blockheight at this time and the next block of the update period
-	rsrc := self.getResource(nameHash.Hex())
-	if rsrc == nil {
-		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
+	rsrc, err := self.loadResource(nameHash)
+	if err != nil {
+		return nil, err
 	}
-	currentblock, err := self.getBlock(ctx, rsrc.name)
+	// the period depends on the frequency changes published since the last lookup
+	if refresh {
+		self.loadAnchors(ctx, rsrc)
+	}
+	// the periods of watched resources are known in advance when following the chain
+	if nextperiod, ok := self.getHeadPeriod(rsrc); ok {
+		return self.lookup(ctx, rsrc, nextperiod, 0, refresh, maxLookup)
+	}
+	currentblock, err := current(ctx, rsrc)
 	if err != nil {
 		return nil, err
 	}
-	nextperiod, err := getNextPeriod(rsrc.startBlock, currentblock, rsrc.frequency)
+	nextperiod, err := self.getPeriod(rsrc, currentblock)
 	if err != nil {
 		return nil, err
 	}
-	return self.lookup(rsrc, nextperiod, 0, refresh, maxLookup)
+	return self.lookup(ctx, rsrc, nextperiod, 0, refresh, maxLookup)
 }
 
-// Returns the resource before the one currently loaded in the resource index
+// Returns the update of the resource before the one of the view
 //
 // This is useful where resource updates are used incrementally in contrast to
-// merely replacing content.
+// merely replacing content. Updates are walked back by passing the returned
+// views in turn.
 //
-// Requires a synced resource object
-func (self *ResourceHandler) LookupPreviousByName(ctx context.Context, name string, maxLookup *ResourceLookupParams) (*resource, error) {
-	return self.LookupPrevious(ctx, ens.EnsNode(name), maxLookup)
-}
-
-func (self *ResourceHandler) LookupPrevious(ctx context.Context, nameHash common.Hash, maxLookup *ResourceLookupParams) (*resource, error) {
-	rsrc := self.getResource(nameHash.Hex())
+// Requires the resource to be loaded
+func (self *ResourceHandler) LookupPrevious(ctx context.Context, view *ResourceView, maxLookup *ResourceLookupParams) (*ResourceView, error) {
+	rsrc := self.getResource(view.nameHash.Hex())
 	if rsrc == nil {
 		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
 	}
-	if !rsrc.isSynced() {
-		return nil, NewResourceError(ErrNotSynced, "LookupPrevious requires synced resource.")
-	} else if rsrc.lastPeriod == 0 {
+	if view.lastPeriod == 0 {
 		return nil, NewResourceError(ErrNothingToReturn, "Resource not found")
 	}
-	if rsrc.version > 1 {
-		rsrc.version--
-	} else if rsrc.lastPeriod == 1 {
+	period, version := view.lastPeriod, view.version
+	if version > 1 {
+		version--
+	} else if period == 1 {
 		return nil, NewResourceError(ErrNothingToReturn, "Current update is the oldest")
 	} else {
-		rsrc.version = 0
-		rsrc.lastPeriod--
+		version = 0
+		period--
 	}
-	return self.lookup(rsrc, rsrc.lastPeriod, rsrc.version, false, maxLookup)
+	return self.lookup(ctx, rsrc, period, version, false, maxLookup)
 }
 
 // base code for public lookup methods
-func (self *ResourceHandler) lookup(rsrc *resource, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (*resource, error) {
+func (self *ResourceHandler) lookup(ctx context.Context, rsrc *resource, period uint32, version uint32, refresh bool, maxLookup *ResourceLookupParams) (_ *ResourceView, err error) {
+	defer annotateResourceError(&err, rsrc.name, period, version)
 
 	// we can't look for anything without a store
 	if self.chunkStore == nil {
@@ -557,6 +1114,17 @@ func (self *ResourceHandler) lookup(rsrc *resource, period uint32, version uint3
 		return nil, NewResourceError(ErrInvalidValue, "period must be >0")
 	}
 
+	// revocations and anchors may have been published since the last lookup
+	if refresh {
+		self.loadRevocations(ctx, rsrc)
+		self.loadAnchors(ctx, rsrc)
+	}
+
+	// the updates of deleted resources may have been garbage collected
+	if self.getTombstone(rsrc.nameHash.Hex()) != nil {
+		return nil, NewResourceError(ErrGone, fmt.Sprintf("Resource '%s' was deleted", rsrc.name))
+	}
+
 	// start from the last possible block period, and iterate previous ones until we find a match
 	// if we hit startBlock we're out of options
 	var specificversion bool
@@ -566,145 +1134,321 @@ func (self *ResourceHandler) lookup(rsrc *resource, period uint32, version uint3
 		version = 1
 	}
 
-	var hops uint32
 	if maxLookup == nil {
 		maxLookup = self.queryMaxPeriods
 	}
-	log.Trace("resource lookup", "period", period, "version", version, "limit", maxLookup.Limit, "max", maxLookup.Max)
-	for period > 0 {
-		if maxLookup.Limit && hops > maxLookup.Max {
-			return nil, NewResourceError(ErrPeriodDepth, fmt.Sprintf("Lookup exceeded max period hops (%d)", maxLookup.Max))
+	log.Trace("resource lookup", "period", period, "version", version, "strategy", maxLookup.strategy(), "maxhops", maxLookup.MaxHops)
+
+	// the period of the update loaded is where latest lookups may start
+	var hint uint32
+	if latest := rsrc.current(); !specificversion && latest != nil {
+		hint = latest.lastPeriod
+	}
+	period, chunk, err := self.searchPeriods(ctx, rsrc, period, version, hint, maxLookup)
+	if err != nil {
+		return nil, err
+	}
+	if specificversion {
+		if self.isRevokedUpdate(rsrc, chunk) {
+			return nil, NewResourceError(ErrUnauthorized, "Update is signed with a revoked key")
 		}
-		key := self.resourceHash(period, version, rsrc.nameHash)
-		chunk, err := self.chunkStore.get(key, defaultRetrieveTimeout)
-		if err == nil {
-			if specificversion {
-				return self.updateResourceIndex(rsrc, chunk)
-			}
-			// check if we have versions > 1. If a version fails, the previous version is used and returned.
-			log.Trace("rsrc update version 1 found, checking for version updates", "period", period, "key", key)
-			for {
-				newversion := version + 1
-				key := self.resourceHash(period, newversion, rsrc.nameHash)
-				newchunk, err := self.chunkStore.get(key, defaultRetrieveTimeout)
-				if err != nil {
-					return self.updateResourceIndex(rsrc, chunk)
-				}
-				chunk = newchunk
-				version = newversion
-				log.Trace("version update found, checking next", "version", version, "period", period, "key", key)
+		return self.updateResourceIndex(rsrc, chunk)
+	}
+	// check if we have versions > 1. If a version fails, the previous version is used and returned.
+	log.Trace("rsrc update version 1 found, checking for version updates", "period", period, "key", chunk.Key)
+	chunk = self.searchVersion(ctx, rsrc, period, chunk, maxLookup)
+
+	// skip the updates signed with revoked keys
+	for self.isRevokedUpdate(rsrc, chunk) {
+		log.Debug("skipping resource update signed with revoked key", "name", rsrc.name, "key", chunk.Key)
+		period, chunk, err = self.previousUpdate(ctx, rsrc, chunk, maxLookup)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return self.updateResourceIndex(rsrc, chunk)
+}
+
+// searches the latest period at or before period with an update of the given
+// version, with the strategy of maxLookup
+func (self *ResourceHandler) searchPeriods(ctx context.Context, rsrc *resource, period uint32, version uint32, hint uint32, maxLookup *ResourceLookupParams) (uint32, *Chunk, error) {
+	timeout := self.getRetrieveTimeout(ctx)
+	strategy := maxLookup.strategy()
+	// strategies may probe concurrently
+	var hops int64
+	defer func() {
+		lookupHistogram("hops", strategy).Update(atomic.LoadInt64(&hops))
+	}()
+	query := &ResourcePeriodQuery{
+		Period:  period,
+		Hint:    hint,
+		MaxHops: maxLookup.MaxHops,
+		Probe: func(period uint32) *Chunk {
+			atomic.AddInt64(&hops, 1)
+			key := self.resourceHash(period, version, rsrc.nameHash)
+			chunk, err := self.chunkStore.get(key, timeout)
+			if err != nil {
+				log.Trace("rsrc update not found", "period", period, "key", key)
+				return nil
 			}
+			return chunk
+		},
+	}
+	return strategy.Search(query)
+}
+
+// searches the latest version of the update in period, given the chunk of
+// version 1. Versions are consecutive, so the versions are probed with
+// doubling steps until one is missing, or the last version is reached, and
+// the range is then bisected.
+func (self *ResourceHandler) searchVersion(ctx context.Context, rsrc *resource, period uint32, chunk *Chunk, maxLookup *ResourceLookupParams) *Chunk {
+	timeout := self.getRetrieveTimeout(ctx)
+	var probes int64
+	defer func() {
+		lookupHistogram("versions", maxLookup.strategy()).Update(probes)
+	}()
+	probe := func(version uint32) *Chunk {
+		probes++
+		key := self.resourceHash(period, version, rsrc.nameHash)
+		newchunk, err := self.chunkStore.get(key, timeout)
+		if err != nil {
+			return nil
+		}
+		log.Trace("version update found", "version", version, "period", period, "key", key)
+		return newchunk
+	}
+
+	version := uint32(1)
+	missed := uint32(2)
+	for {
+		newchunk := probe(missed)
+		if newchunk == nil {
+			break
+		}
+		version, chunk = missed, newchunk
+		if missed == math.MaxUint32 {
+			return chunk
+		} else if missed > math.MaxUint32/2 {
+			missed = math.MaxUint32
+		} else {
+			missed *= 2
 		}
-		log.Trace("rsrc update not found, checking previous period", "period", period, "key", key)
-		period--
-		hops++
 	}
-	return nil, NewResourceError(ErrNotFound, "no updates found")
+	for missed-version > 1 {
+		mid := version + (missed-version)/2
+		if newchunk := probe(mid); newchunk != nil {
+			version, chunk = mid, newchunk
+		} else {
+			missed = mid
+		}
+	}
+	return chunk
+}
+
+// retrieves a chunk which usually does not exist yet, such as the next anchor
+// or revocation of a resource. The local store is always probed, but a chunk missing from
+// the network is not requested again within resourceProbeInterval, so that
+// refreshing lookups do not wait for the retrieve timeout every time.
+func (self *ResourceHandler) probe(store *NetStore, key Key, timeout time.Duration) (*Chunk, error) {
+	if chunk, err := store.getLocal(key); err == nil {
+		return chunk, nil
+	}
+	if probed, ok := self.missedProbes.Get(key.Hex()); ok && self.now().Sub(probed.(time.Time)) < resourceProbeInterval {
+		return nil, ErrChunkNotFound
+	}
+	chunk, err := store.get(key, timeout)
+	if err != nil {
+		self.missedProbes.Add(key.Hex(), self.now())
+		return nil, err
+	}
+	return chunk, nil
 }
 
 // Retrieves a resource metadata chunk and creates/updates the index entry for it
 // with the resulting metadata
+//
+// The key is not stored as the metadata key of the resource until an update of
+// its owner is read, see ResourceRoot.
 func (self *ResourceHandler) LoadResource(key Key) (*resource, error) {
-	chunk, err := self.chunkStore.get(key, defaultRetrieveTimeout)
+	chunk, err := self.chunkStore.get(key, self.retrieveTimeout)
+	if err != nil {
+		return nil, WrapResourceError(ErrNotFound, "", err)
+	}
+	// create the index entry
+	rsrc, err := parseResourceMetadata(chunk.SData)
+	if err != nil {
+		return nil, err
+	}
+	if err := self.checkACL(rsrc); err != nil {
+		return nil, err
+	}
+	rsrc.rootKey = key
+	self.setResource(rsrc.nameHash.Hex(), rsrc)
+	self.loadRevocations(context.Background(), rsrc)
+	self.loadAnchors(context.Background(), rsrc)
+	log.Trace("resource index load", "rootkey", key, "name", rsrc.name, "namehash", rsrc.nameHash, "startblock", rsrc.startBlock, "frequency", rsrc.frequency, "scheme", rsrc.scheme)
+	return rsrc, nil
+}
+
+// parses the data of a metadata chunk into a resource, which is not in the
+// index yet
+func parseResourceMetadata(data []byte) (*resource, error) {
+	chunkdata, err := decodeResourceChunk(data)
 	if err != nil {
-		return nil, NewResourceError(ErrNotFound, err.Error())
+		return nil, err
 	}
 
 	// minimum sanity check for chunk data (an update chunk first two bytes is headerlength uint16, and cannot be 0)
 	// \TODO this is not enough to make sure the data isn't bogus. A normal content addressed chunk could still satisfy these criteria
-	if !bytes.Equal(chunk.SData[:2], []byte{0x0, 0x0}) {
+	if len(chunkdata) < 2 || !bytes.Equal(chunkdata[:2], []byte{0x0, 0x0}) {
 		return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Chunk is not a resource metadata chunk"))
-	} else if len(chunk.SData) <= metadataChunkOffsetSize {
-		return nil, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Invalid chunk length %d, should be minimum %d", len(chunk.SData), metadataChunkOffsetSize+1))
+	} else if len(chunkdata) <= metadataChunkOffsetSize {
+		return nil, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Invalid chunk length %d, should be minimum %d", len(chunkdata), metadataChunkOffsetSize+1))
 	}
 
-	// create the index entry
 	rsrc := &resource{}
-	rsrc.UnmarshalBinary(chunk.SData[2:])
-	rsrc.nameHash = ens.EnsNode(rsrc.name)
-	self.setResource(rsrc.nameHash.Hex(), rsrc)
-	log.Trace("resource index load", "rootkey", key, "name", rsrc.name, "namehash", rsrc.nameHash, "startblock", rsrc.startBlock, "frequency", rsrc.frequency)
+	if err := rsrc.UnmarshalBinary(chunkdata[2:]); err != nil {
+		return nil, err
+	}
+	rsrc.nameHash = ResourceNameHash(rsrc.name)
 	return rsrc, nil
 }
 
-// update mutable resource index map with content from a retrieved update chunk
-func (self *ResourceHandler) updateResourceIndex(rsrc *resource, chunk *Chunk) (*resource, error) {
+// the content of an update chunk of a resource
+type resourceUpdateContent struct {
+	period    uint32
+	version   uint32
+	header    *resourceUpdateHeader
+	data      []byte
+	multihash bool
+	tombstone bool
+	encrypted bool // the data could not be decrypted
+}
+
+// reads the content of an update chunk of the resource, without changing the
+// resource index. The data of tombstones is not retrieved.
+func (self *ResourceHandler) readUpdate(rsrc *resource, chunk *Chunk) (*resourceUpdateContent, error) {
 
 	// retrieve metadata from chunk data and check that it matches this mutable resource
-	signature, period, version, name, data, multihash, err := self.parseUpdate(chunk.SData)
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return nil, err
+	}
+	signature, period, version, name, data, multihash, err := self.parseUpdate(chunkdata)
+	if err != nil {
+		return nil, err
+	}
+	header, err := parseUpdateHeader(chunkdata)
+	if err != nil {
+		return nil, err
+	}
 	if rsrc.name != name {
 		return nil, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Update belongs to '%s', but have '%s'", name, rsrc.name))
 	}
-	log.Trace("resource index update", "name", rsrc.name, "namehash", rsrc.nameHash, "updatekey", chunk.Key, "period", period, "version", version)
 
 	// check signature (if signer algorithm is present)
 	// \TODO maybe this check is redundant if also checked upon retrieval of chunk
 	if signature != nil {
-		digest := self.keyDataHash(chunk.Key, data)
-		_, err = getAddressFromDataSig(digest, *signature)
+		addr, err := self.recoverSigner(rsrc.nameHash, chunk.Key, chunkdata, data, signature)
+		if err != nil {
+			return nil, WrapResourceError(ErrUnauthorized, "", err)
+		}
+		self.confirmRoot(rsrc, addr)
+	} else if self.signer != nil {
+		return nil, NewResourceError(ErrUnauthorized, "Update is not signed")
+	}
+	content := &resourceUpdateContent{
+		period:    period,
+		version:   version,
+		header:    header,
+		multihash: multihash,
+	}
+
+	// a tombstone deletes the resource
+	if isTombstoneUpdate(chunkdata) {
+		content.tombstone = true
+		return content, nil
+	}
+
+	// large updates hold the reference of the data stored with the dpa
+	if isDataRefUpdate(chunkdata) {
+		data, err = self.retrieveData(data)
 		if err != nil {
-			return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Invalid signature: %v", err))
+			return nil, WrapResourceError(ErrNotFound, "Cannot retrieve update data", err)
+		}
+	}
+
+	// encrypted data is decrypted with the secret of the resource, if we have it
+	if header.encryption != nil {
+		content.encrypted = true
+		if secret := self.getSecret(rsrc.nameHash.Hex()); secret != nil {
+			plaintext, err := decryptUpdate(rsrc.nameHash, secret, header.encryption, data)
+			if err == nil {
+				data, content.encrypted = plaintext, false
+			} else {
+				log.Debug("resource update decryption failed", "name", rsrc.name, "key", chunk.Key, "err", err)
+			}
 		}
 	}
+	content.data = data
+	return content, nil
+}
+
+// returns the view of a retrieved update chunk, which the mutable resource
+// index map entry holds if it is the most recent update known
+func (self *ResourceHandler) updateResourceIndex(rsrc *resource, chunk *Chunk) (*ResourceView, error) {
+	content, err := self.readUpdate(rsrc, chunk)
+	if err != nil {
+		return nil, err
+	}
+	log.Trace("resource index update", "name", rsrc.name, "namehash", rsrc.nameHash, "updatekey", chunk.Key, "period", content.period, "version", content.version)
+	if content.tombstone {
+		return nil, self.setTombstone(rsrc, chunk.Key, content.period, content.version)
+	}
 
 	// update our rsrcs entry map
-	rsrc.lastKey = chunk.Key
-	rsrc.lastPeriod = period
-	rsrc.version = version
-	rsrc.updated = time.Now()
-	rsrc.data = make([]byte, len(data))
-	rsrc.Multihash = multihash
-	rsrc.Reader = bytes.NewReader(rsrc.data)
-	copy(rsrc.data, data)
-	log.Debug("Resource synced", "name", rsrc.name, "key", chunk.Key, "period", rsrc.lastPeriod, "version", rsrc.version)
+	view := rsrc.newView()
+	view.lastKey = chunk.Key
+	view.lastPeriod = content.period
+	view.version = content.version
+	view.data = content.data // owned by the content, no need to copy
+	view.modTime = content.header.modTime
+	view.signer = content.header.signer
+	view.encrypted = content.encrypted
+	if content.encrypted {
+		view.encryption = content.header.encryption
+	}
+	view.Multihash = content.multihash
+	view.dpa = self.dpa
+	log.Debug("Resource synced", "name", rsrc.name, "key", chunk.Key, "period", view.lastPeriod, "version", view.version)
+	rsrc.setCurrent(view, false)
 	self.setResource(rsrc.nameHash.Hex(), rsrc)
-	return rsrc, nil
+	return view, nil
 }
 
 // retrieve update metadata from chunk data
 // mirrors newUpdateChunk()
 func (self *ResourceHandler) parseUpdate(chunkdata []byte) (*Signature, uint32, uint32, string, []byte, bool, error) {
 	// absolute minimum an update chunk can contain:
-	// 14 = header + one byte of name + one byte of data
-	if len(chunkdata) < 14 {
-		return nil, 0, 0, "", nil, false, NewResourceError(ErrNothingToReturn, "chunk less than 13 bytes cannot be a resource update chunk")
+	// length fields + period + version + one byte of name + one byte of data
+	minlength := resourceUpdateHeaderOffset + 2
+	if len(chunkdata) < minlength {
+		return nil, 0, 0, "", nil, false, NewResourceError(ErrNothingToReturn, fmt.Sprintf("chunk less than %d bytes cannot be a resource update chunk", minlength))
 	}
 	cursor := 0
 	headerlength := binary.LittleEndian.Uint16(chunkdata[cursor : cursor+2])
 	cursor += 2
-	datalength := binary.LittleEndian.Uint16(chunkdata[cursor : cursor+2])
-	cursor += 2
-	var exclsignlength int
-	// we need extra magic if it's a multihash, since we used datalength 0 in header as an indicator of multihash content
-	// retrieve the second varint and set this as the data length
-	// TODO: merge with isMultihash code
-	if datalength == 0 {
-		uvarintbuf := bytes.NewBuffer(chunkdata[headerlength+4:])
-		r, err := binary.ReadUvarint(uvarintbuf)
-		if err != nil {
-			errstr := fmt.Sprintf("corrupt multihash, hash id varint could not be read: %v", err)
-			log.Warn(errstr)
-			return nil, 0, 0, "", nil, false, NewResourceError(ErrCorruptData, errstr)
-
-		}
-		r, err = binary.ReadUvarint(uvarintbuf)
-		if err != nil {
-			errstr := fmt.Sprintf("corrupt multihash, hash length field could not be read: %v", err)
-			log.Warn(errstr)
-			return nil, 0, 0, "", nil, false, NewResourceError(ErrCorruptData, errstr)
-
-		}
-		exclsignlength = int(headerlength + uint16(r))
-	} else {
-		exclsignlength = int(headerlength + datalength + 4)
-	}
+	flags := chunkdata[cursor]
+	cursor++
+	datalength := binary.LittleEndian.Uint32(chunkdata[cursor : cursor+4])
+	cursor += 4
 
-	// the total length excluding signature is headerlength and datalength fields plus the length of the header and the data given in these fields
-	exclsignlength = int(headerlength + datalength + 4)
-	if exclsignlength > len(chunkdata) || exclsignlength < 14 {
-		return nil, 0, 0, "", nil, false, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Reported headerlength %d + datalength %d longer than actual chunk data length %d", headerlength, exclsignlength, len(chunkdata)))
-	} else if exclsignlength < 14 {
-		return nil, 0, 0, "", nil, false, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Reported headerlength %d + datalength %d is smaller than minimum valid resource chunk length %d", headerlength, datalength, 14))
+	// the total length excluding signature is the length fields plus the length of the header and the data given in these fields
+	exclsignlength := uint64(resourceUpdatePrefixLength) + uint64(headerlength) + uint64(datalength)
+	if exclsignlength > uint64(len(chunkdata)) {
+		return nil, 0, 0, "", nil, false, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Reported headerlength %d + datalength %d longer than actual chunk data length %d", headerlength, datalength, len(chunkdata)))
+	} else if exclsignlength < uint64(minlength) {
+		return nil, 0, 0, "", nil, false, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Reported headerlength %d + datalength %d is smaller than minimum valid resource chunk length %d", headerlength, datalength, minlength))
 	}
 
 	// at this point we can be satisfied that the data integrity is ok
@@ -716,37 +1460,40 @@ func (self *ResourceHandler) parseUpdate(chunkdata []byte) (*Signature, uint32,
 	cursor += 4
 	version = binary.LittleEndian.Uint32(chunkdata[cursor : cursor+4])
 	cursor += 4
-	namelength := int(headerlength) - cursor + 4
+	header, err := parseUpdateHeader(chunkdata)
+	if err != nil {
+		return nil, 0, 0, "", nil, false, err
+	}
+	cursor += header.length()
+	namelength := int(headerlength) - cursor + resourceUpdatePrefixLength
+	if namelength < 1 {
+		return nil, 0, 0, "", nil, false, NewResourceError(ErrCorruptData, fmt.Sprintf("Reported headerlength %d leaves no room for the name", headerlength))
+	}
 	name = string(chunkdata[cursor : cursor+namelength])
 	cursor += namelength
 
 	// if multihash content is indicated we check the validity of the multihash
-	// \TODO the check above for multihash probably is sufficient also for this case (or can be with a small adjustment) and if so this code should be removed
-	var intdatalength int
-	var multihash bool
-	if datalength == 0 {
-		intdatalength = isMultihash(chunkdata[cursor:])
-		multihashboundary := cursor + intdatalength
-		if len(chunkdata) != multihashboundary && len(chunkdata) < multihashboundary+signatureLength {
-			log.Debug("multihash error", "chunkdatalen", len(chunkdata), "multihashboundary", multihashboundary)
-			return nil, 0, 0, "", nil, false, errors.New("Corrupt multihash data")
+	multihash := flags&resourceMultihashFlag != 0
+	if multihash {
+		length, err := self.multihashLength(chunkdata[cursor : cursor+int(datalength)])
+		if err != nil {
+			return nil, 0, 0, "", nil, false, err
+		} else if length != int(datalength) {
+			return nil, 0, 0, "", nil, false, NewResourceError(ErrCorruptData, fmt.Sprintf("Corrupt multihash data, multihash length %d, data length %d", length, datalength))
 		}
-		multihash = true
-	} else {
-		intdatalength = int(datalength)
 	}
-	data = make([]byte, intdatalength)
-	copy(data, chunkdata[cursor:cursor+intdatalength])
+	data = make([]byte, datalength)
+	copy(data, chunkdata[cursor:cursor+int(datalength)])
 
 	// omit signatures if we have no validator
 	var signature *Signature
-	cursor += intdatalength
+	cursor += int(datalength)
 	if self.signer != nil {
-		sigdata := chunkdata[cursor : cursor+signatureLength]
-		if len(sigdata) > 0 {
-			signature = &Signature{}
-			copy(signature[:], sigdata)
+		if len(chunkdata) < cursor+signatureLength {
+			return nil, 0, 0, "", nil, false, NewResourceError(ErrInvalidSignature, "Update chunk has no signature")
 		}
+		signature = &Signature{}
+		copy(signature[:], chunkdata[cursor:cursor+signatureLength])
 	}
 
 	return signature, period, version, name, data, multihash, nil
@@ -754,28 +1501,92 @@ func (self *ResourceHandler) parseUpdate(chunkdata []byte) (*Signature, uint32,
 
 // Adds an actual data update
 //
-// Uses the data currently loaded in the resources map entry.
-// It is the caller's responsibility to make sure that this data is not stale.
+// Uses the most recent update of the resource the handler knows of.
+// It is the caller's responsibility to make sure that it is not stale.
 //
-// A resource update cannot span chunks, and thus has max length 4096
+// A multihash update cannot span chunks, and thus has max length 4096
 func (self *ResourceHandler) UpdateMultihash(ctx context.Context, name string, data []byte) (Key, error) {
 	// \TODO perhaps this check should be in newUpdateChunk()
-	if isMultihash(data) == 0 {
-		return nil, NewResourceError(ErrNothingToReturn, "Invalid multihash")
+	if length, err := self.multihashLength(data); err != nil {
+		return nil, err
+	} else if length != len(data) {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid multihash, %d bytes trailing", len(data)-length))
+	}
+	signer, err := self.signerFor(ctx, name)
+	if err != nil {
+		return nil, err
 	}
-	return self.update(ctx, name, data, true)
+	return self.update(ctx, signer, name, data, true)
 }
 
+// Update adds a data update to the resource.
+//
+// Data which does not fit in the update chunk is stored with the DPA, and
+// the update chunk holds its swarm reference. Lookups retrieve the data
+// transparently.
+//
+// The update is signed with the signer selected by the context or registered
+// for the resource, see WithResourceSigner and SetSigner, and otherwise with
+// the signer of the handler.
 func (self *ResourceHandler) Update(ctx context.Context, name string, data []byte) (Key, error) {
-	return self.update(ctx, name, data, false)
+	signer, err := self.signerFor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return self.update(ctx, signer, name, data, false)
+}
+
+// UpdateWithSigner adds a data update to the resource like Update, but signs
+// it with the given signer instead of the signer of the handler.
+//
+// This lets a gateway publish updates on behalf of its users with their own
+// keys. The handler must have a signer, since otherwise updates are not
+// signed at all.
+func (self *ResourceHandler) UpdateWithSigner(ctx context.Context, name string, data []byte, signer ResourceSigner) (Key, error) {
+	if self.signer == nil {
+		return nil, NewResourceError(ErrInit, "Resource handler does not sign updates")
+	} else if signer == nil {
+		return nil, NewResourceError(ErrInvalidSignature, "No signer given")
+	}
+	return self.Update(WithResourceSigner(ctx, signer), name, data)
+}
+
+// UpdateBatch adds several data updates to the resource in the same period.
+//
+// The updates get consecutive versions, in the order given, and the returned
+// keys are in the same order. The block height is fetched once for the whole
+// batch, and all update chunks are created and signed before any of them is
+// stored.
+//
+// The batch is atomic: lookups find either all of its updates or none. The
+// chunks are stored from the last version to the first, and if storing one of
+// them fails or times out, the chunks of the batch stored before are deleted
+// from the local store and the resource index is not advanced. Chunks already
+// synced to peers cannot be recalled, but lookups do not reach them without
+// the first version of the batch. Large data stored with the dpa is kept.
+func (self *ResourceHandler) UpdateBatch(ctx context.Context, name string, updates [][]byte) ([]Key, error) {
+	signer, err := self.signerFor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return self.updateBatch(ctx, signer, name, updates, false, false)
 }
 
 // create and commit an update
-func (self *ResourceHandler) update(ctx context.Context, name string, data []byte, multihash bool) (Key, error) {
+func (self *ResourceHandler) update(ctx context.Context, signer ResourceSigner, name string, data []byte, multihash bool) (Key, error) {
+	keys, err := self.updateBatch(ctx, signer, name, [][]byte{data}, multihash, false)
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// create and commit a batch of updates in the same period, or the tombstone of the resource
+func (self *ResourceHandler) updateBatch(ctx context.Context, signer ResourceSigner, name string, updates [][]byte, multihash bool, tombstone bool) (_ []Key, err error) {
+	defer annotateResourceError(&err, name, 0, 0)
 
-	// zero-length updates are bogus
-	if len(data) == 0 {
-		return nil, NewResourceError(ErrInvalidValue, "I refuse to waste swarm space for updates with empty values, amigo (data length is 0)")
+	if len(updates) == 0 {
+		return nil, NewResourceError(ErrInvalidValue, "No updates in batch")
 	}
 
 	// we can't update anything without a store
@@ -785,33 +1596,91 @@ func (self *ResourceHandler) update(ctx context.Context, name string, data []byt
 
 	// signature length is 0 if we are not using them
 	var signaturelength int
-	if self.signer != nil {
+	if signer != nil {
 		signaturelength = signatureLength
 	}
 
-	// get the cached information
-	nameHash := ens.EnsNode(name)
+	// an update can be only one chunk long; data length less header and signature data
+	// the header offset is the length of the headerlength, flags and datalength fields plus the period and version fields (2xuint32)
+	datalimit := self.chunkSize() - int64(resourceFormatPrefixLength+signaturelength+len(name)+resourceUpdateHeaderOffset+resourceHeaderMetaLength)
+
+	// the data of private resources is encrypted before it is stored
+	nameHash := ResourceNameHash(name)
 	nameHashHex := nameHash.Hex()
+	payloads := updates
+	encs := make([]*resourceEncryption, len(updates))
+	if secret := self.getSecret(nameHashHex); secret != nil && !multihash && !tombstone {
+		payloads = make([][]byte, len(updates))
+		for i, data := range updates {
+			if len(data) == 0 {
+				continue
+			}
+			enc, ciphertext, err := encryptUpdate(nameHash, secret, data)
+			if err != nil {
+				return nil, WrapResourceError(ErrInvalidValue, "Could not encrypt update data", err)
+			}
+			encs[i], payloads[i] = enc, ciphertext
+		}
+		datalimit -= 1 + resourceNonceLength
+	}
+
+	refs := make([]Key, len(updates))
+	var checked bool
+	for i, data := range payloads {
+		// zero-length updates are bogus
+		if len(data) == 0 {
+			return nil, NewResourceError(ErrInvalidValue, "I refuse to waste swarm space for updates with empty values, amigo (data length is 0)")
+		}
+		if int64(len(data)) <= datalimit {
+			continue
+		}
+		if multihash || len(data) > MaxResourceDataSize {
+			return nil, NewResourceError(ErrDataOverflow, fmt.Sprintf("Data overflow: %d / %d bytes", len(data), datalimit))
+		}
+		// the access of the signer is checked before the data is stored, so
+		// that unauthorized updates do not store their data
+		if signer != nil && !checked {
+			addr, err := resourceSignerAddress(signer)
+			if err != nil {
+				return nil, WrapResourceError(ErrInvalidSignature, "Sign fail", err)
+			}
+			if err := self.checkSignerAccess(name, addr); err != nil {
+				return nil, err
+			}
+			checked = true
+		}
+		// the data does not fit in the update chunk, store it with the dpa
+		ref, err := self.storeData(data)
+		if err != nil {
+			return nil, WrapResourceError(ErrIO, "Could not store update data", err)
+		}
+		refs[i] = ref
+	}
+
+	// concurrent updates would compute the same versions
+	self.updateLock.Lock()
+	defer self.updateLock.Unlock()
+
+	// get the cached information
 	rsrc := self.getResource(nameHashHex)
 	if rsrc == nil {
 		return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Resource object '%s' not in index", name))
 	} else if !rsrc.isSynced() {
 		return nil, NewResourceError(ErrNotSynced, "Resource object not in sync")
+	} else if self.getTombstone(nameHashHex) != nil {
+		return nil, NewResourceError(ErrGone, fmt.Sprintf("Resource '%s' was deleted", name))
 	}
 
-	// an update can be only one chunk long; data length less header and signature data
-	// 12 = length of header and data length fields (2xuint16) plus period and frequency value fields (2xuint32)
-	datalimit := self.chunkSize() - int64(signaturelength-len(name)-12)
-	if int64(len(data)) > datalimit {
-		return nil, NewResourceError(ErrDataOverflow, fmt.Sprintf("Data overflow: %d / %d bytes", len(data), datalimit))
-	}
+	// the creation time and the signer are the same for the whole batch
+	modTime := uint64(self.now().Unix())
+	var signerAddr common.Address
 
 	// get our blockheight at this time and the next block of the update period
-	currentblock, err := self.getBlock(ctx, name)
+	currentblock, err := self.getCurrent(ctx, rsrc)
 	if err != nil {
-		return nil, NewResourceError(ErrIO, fmt.Sprintf("Could not get block height: %v", err))
+		return nil, WrapResourceError(ErrIO, "Could not get block height", err)
 	}
-	nextperiod, err := getNextPeriod(rsrc.startBlock, currentblock, rsrc.frequency)
+	nextperiod, err := self.getPeriod(rsrc, currentblock)
 	if err != nil {
 		return nil, err
 	}
@@ -819,128 +1688,347 @@ func (self *ResourceHandler) update(ctx context.Context, name string, data []byt
 	// if we already have an update for this block then increment version
 	// resource object MUST be in sync for version to be correct, but we checked this earlier in the method already
 	var version uint32
-	if self.hasUpdate(nameHashHex, nextperiod) {
-		version = rsrc.version
-	}
-	version++
+	if latest := rsrc.current(); latest.lastPeriod == nextperiod {
+		version = latest.version
+	}
+
+	middleware := self.getMiddleware()
+	keys := make([]Key, len(updates))
+	chunks := make([]*Chunk, len(updates))
+	pubs := make([]*ResourcePublication, len(updates))
+	for i, data := range payloads {
+		version++
+
+		// a large update is replaced by the reference of its data
+		var dataref bool
+		if refs[i] != nil {
+			data = refs[i]
+			dataref = true
+		}
 
-	// calculate the chunk key
-	key := self.resourceHash(nextperiod, version, rsrc.nameHash)
+		// calculate the chunk key
+		key := self.resourceHash(nextperiod, version, rsrc.nameHash)
+		header := &resourceUpdateHeader{
+			modTime:    modTime,
+			encryption: encs[i],
+		}
 
-	// if we have a signing function, sign the update
-	// \TODO this code should probably be consolidated with corresponding code in NewResource()
-	var signature *Signature
-	if self.signer != nil {
-		// sign the data hash with the key
-		digest := self.keyDataHash(key, data)
-		sig, err := self.signer.Sign(digest)
-		if err != nil {
-			return nil, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Sign fail: %v", err))
+		// the middleware may reject the update before it is signed
+		pubs[i] = &ResourcePublication{
+			Name:      name,
+			NameHash:  nameHash,
+			Key:       key,
+			Period:    nextperiod,
+			Version:   version,
+			Data:      updates[i],
+			Multihash: multihash,
+			Tombstone: tombstone,
+		}
+		if err := self.preSign(ctx, middleware, pubs[i]); err != nil {
+			return nil, err
 		}
-		signature = &sig
 
-		// get the address of the signer (which also checks that it's a valid signature)
-		addr, err := getAddressFromDataSig(digest, *signature)
-		if err != nil {
-			return nil, NewResourceError(ErrInvalidSignature, fmt.Sprintf("Invalid data/signature: %v", err))
+		// if we have a signing function, sign the update
+		// \TODO this code should probably be consolidated with corresponding code in NewResource()
+		var signature *Signature
+		if signer != nil {
+			// sign the data hash with the key
+			digest := self.keyDataHash(rsrc.nameHash, key, modTime, data)
+			sig, err := signer.Sign(digest)
+			if err != nil {
+				return nil, WrapResourceError(ErrInvalidSignature, "Sign fail", err)
+			}
+			signature = &sig
+
+			// get the address of the signer (which also checks that it's a valid signature)
+			addr, err := getAddressFromDataSig(digest, *signature)
+			if err != nil {
+				return nil, WrapResourceError(ErrInvalidSignature, "Invalid data/signature", err)
+			}
+			header.signer = addr
+			signerAddr = addr
+			pubs[i].Signer = addr
+
+			// check if the signer has access to update, the signer is the same for the whole batch
+			if i == 0 {
+				if err := self.checkSignerAccess(name, addr); err != nil {
+					return nil, err
+				} else if self.isRevoked(nameHashHex, addr, nextperiod) {
+					return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Key of address %x is revoked for %s", addr, name))
+				}
+			}
+		}
+
+		var flags uint8
+		if multihash {
+			flags |= resourceMultihashFlag
+		}
+		if dataref {
+			flags |= resourceDataRefFlag
+		}
+		if tombstone {
+			flags |= resourceTombstoneFlag
+		}
+		if encs[i] != nil {
+			flags |= resourceEncryptedFlag
 		}
-		if self.signer != nil {
-			// check if the signer has access to update
-			ok, err := self.checkAccess(name, addr)
+		keys[i] = key
+		chunks[i] = newUpdateChunk(key, signature, header, nextperiod, version, name, data, flags)
+	}
+
+	// send the chunks, the last version first: lookups stop at the first
+	// version which is missing, so the batch only becomes visible once its
+	// first chunk is stored. If a chunk fails, the chunks stored before are
+	// deleted again.
+	timeout := time.NewTimer(self.getStoreTimeout(ctx))
+	defer timeout.Stop()
+	for i := len(chunks) - 1; i >= 0; i-- {
+		chunk := chunks[i]
+		self.chunkStore.Put(chunk)
+		select {
+		case <-chunk.dbStoredC:
+			err = chunk.GetErrored()
 			if err != nil {
-				return nil, NewResourceError(ErrIO, fmt.Sprintf("Access check fail: %v", err))
-			} else if !ok {
-				return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x does not have access to update %s", addr, name))
+				err = WrapResourceError(ErrIO, "chunk not stored", err)
 			}
+		case <-timeout.C:
+			err = NewResourceError(ErrIO, "chunk store timeout")
+		}
+		if err != nil {
+			self.deleteChunks(chunks[i:])
+			return nil, err
+		}
+		log.Trace("resource update", "name", name, "key", keys[i], "currentblock", currentblock, "lastperiod", nextperiod, "version", version-uint32(len(chunks)-1-i), "data", chunk.SData, "multihash", multihash)
+	}
+	self.postStore(ctx, middleware, pubs)
+
+	// update our resources map entry and return the new keys
+	if tombstone {
+		self.setTombstone(rsrc, keys[0], nextperiod, version)
+		self.notify(rsrc.current())
+		return keys, nil
+	}
+	data := updates[len(updates)-1]
+	view := rsrc.newView()
+	view.lastKey = keys[len(keys)-1]
+	view.lastPeriod = nextperiod
+	view.version = version
+	view.Multihash = multihash
+	view.dpa = self.dpa
+	view.data = make([]byte, len(data))
+	view.modTime = modTime
+	view.signer = signerAddr
+	copy(view.data, data)
+	rsrc.setCurrent(view, true)
+	self.setResource(rsrc.nameHash.Hex(), rsrc)
+	self.notify(view)
+	return keys, nil
+}
+
+// deletes the update chunks of a failed batch from the local store, the
+// chunks which are still being stored are deleted once they are stored
+func (self *ResourceHandler) deleteChunks(chunks []*Chunk) {
+	remove := func(chunk *Chunk) {
+		if chunk.GetErrored() != nil {
+			return
+		}
+		if err := self.chunkStore.Delete(chunk.Key); err != nil && err != ErrChunkNotFound {
+			log.Warn("Could not delete update chunk of failed batch", "key", chunk.Key, "err", err)
 		}
 	}
+	for _, chunk := range chunks {
+		select {
+		case <-chunk.dbStoredC:
+			remove(chunk)
+		default:
+			go func(chunk *Chunk) {
+				<-chunk.dbStoredC
+				remove(chunk)
+			}(chunk)
+		}
+	}
+}
 
-	// a datalength field set to 0 means the content is a multihash
-	var datalength int
-	if !multihash {
-		datalength = len(data)
+// stores update data with the dpa and returns its reference
+func (self *ResourceHandler) storeData(data []byte) (Key, error) {
+	ref, wait, err := self.dpa.Store(bytes.NewReader(data), int64(len(data)), false)
+	if err != nil {
+		return nil, err
 	}
-	chunk := newUpdateChunk(key, signature, nextperiod, version, name, data, datalength)
+	wait()
+	return ref, nil
+}
 
-	// send the chunk
-	self.chunkStore.Put(chunk)
-	timeout := time.NewTimer(self.storeTimeout)
-	select {
-	case <-chunk.dbStoredC:
-		if err := chunk.GetErrored(); err != nil {
-			return nil, NewResourceError(ErrIO, fmt.Sprintf("chunk not stored: %v", err))
-		}
-	case <-timeout.C:
-		return nil, NewResourceError(ErrIO, "chunk store timeout")
+// retrieves update data stored with the dpa
+func (self *ResourceHandler) retrieveData(ref Key) ([]byte, error) {
+	if self.dpa == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before retrieving data")
+	}
+	reader, _ := self.dpa.Retrieve(ref)
+	size, err := reader.Size(nil)
+	if err != nil {
+		return nil, err
 	}
-	log.Trace("resource update", "name", name, "key", key, "currentblock", currentblock, "lastperiod", nextperiod, "version", version, "data", chunk.SData, "multihash", multihash)
+	if size > MaxResourceDataSize {
+		return nil, fmt.Errorf("data size %d exceeds maximum %d", size, MaxResourceDataSize)
+	}
+	data := make([]byte, size)
+	if _, err := reader.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
 
-	// update our resources map entry and return the new key
-	rsrc.lastPeriod = nextperiod
-	rsrc.version = version
-	rsrc.data = make([]byte, len(data))
-	copy(rsrc.data, data)
-	return key, nil
+// returns the flags of the update chunk
+func updateFlags(chunkdata []byte) uint8 {
+	if len(chunkdata) < resourceUpdatePrefixLength {
+		return 0
+	}
+	return chunkdata[resourceUpdateFlagsOffset]
+}
+
+// returns true if the update chunk holds the reference of the update data
+func isDataRefUpdate(chunkdata []byte) bool {
+	return updateFlags(chunkdata)&resourceDataRefFlag != 0
 }
 
 // Closes the datastore.
 // Always call this at shutdown to avoid data corruption.
 func (self *ResourceHandler) Close() {
+	self.closeWatches()
+	if self.refreshQuitC != nil {
+		close(self.refreshQuitC)
+		self.refreshQuitC = nil
+	}
+	if self.headQuitC != nil {
+		close(self.headQuitC)
+		self.headQuitC = nil
+	}
 	self.chunkStore.Close()
 }
 
+// get the current position of the resource on its period scale: the block
+// height for block based resources, the unix time for time based resources
+func (self *ResourceHandler) getCurrent(ctx context.Context, rsrc *resource) (uint64, error) {
+	if rsrc.scheme == TimePeriods {
+		return uint64(self.now().Unix()), nil
+	}
+	if head, ok := self.getHead(); ok {
+		return head, nil
+	}
+	if self.headerGetter == nil {
+		return 0, NewResourceError(ErrInit, "Block based resources need a header getter, use time based periods instead")
+	}
+	return self.getBlock(ctx, rsrc.name)
+}
+
+// a block height and when it was retrieved
+type recentBlock struct {
+	number  uint64
+	fetched time.Time
+}
+
+// like getCurrent, but reuses the block height if it was retrieved recently
+func (self *ResourceHandler) getRecent(ctx context.Context, rsrc *resource) (uint64, error) {
+	if rsrc.scheme == BlockPeriods {
+		if number, ok := self.recentBlock(rsrc.name); ok {
+			return number, nil
+		}
+	}
+	return self.getCurrent(ctx, rsrc)
+}
+
+// like getRecent, but does not retrieve the block height. It returns false if
+// the block height was not retrieved recently, and retrieves it in the
+// background then.
+func (self *ResourceHandler) cachedCurrent(rsrc *resource) (uint64, bool) {
+	if rsrc.scheme == TimePeriods {
+		return uint64(self.now().Unix()), true
+	}
+	if head, ok := self.getHead(); ok {
+		return head, true
+	}
+	if number, ok := self.recentBlock(rsrc.name); ok {
+		return number, true
+	}
+	self.refreshBlock(rsrc.name)
+	return 0, false
+}
+
+// returns the block height retrieved for the resource name within
+// recentBlockTTL
+func (self *ResourceHandler) recentBlock(name string) (uint64, bool) {
+	v, ok := self.recentBlocks.Get(name)
+	if !ok {
+		return 0, false
+	}
+	b := v.(recentBlock)
+	if self.now().Sub(b.fetched) >= recentBlockTTL {
+		return 0, false
+	}
+	return b.number, true
+}
+
+// retrieves the block height for the resource name in the background, unless
+// it is retrieved already or maxBlockRefreshes are
+func (self *ResourceHandler) refreshBlock(name string) {
+	if self.headerGetter == nil {
+		return
+	}
+	self.recentBlockLock.Lock()
+	defer self.recentBlockLock.Unlock()
+	if self.blockRefreshes[name] || len(self.blockRefreshes) >= maxBlockRefreshes {
+		return
+	}
+	self.blockRefreshes[name] = true
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+		defer cancel()
+		if _, err := self.getBlock(ctx, name); err != nil {
+			log.Warn("Cannot get current period to validate resource updates", "name", name, "err", err)
+		}
+		self.recentBlockLock.Lock()
+		delete(self.blockRefreshes, name)
+		self.recentBlockLock.Unlock()
+	}()
+}
+
 // gets the current block height
 func (self *ResourceHandler) getBlock(ctx context.Context, name string) (uint64, error) {
 	blockheader, err := self.headerGetter.HeaderByNumber(ctx, name, nil)
 	if err != nil {
 		return 0, err
 	}
+	self.recentBlocks.Add(name, recentBlock{
+		number:  blockheader.Number.Uint64(),
+		fetched: self.now(),
+	})
 	return blockheader.Number.Uint64(), nil
 }
 
+// drops the cached block heights, so that the next validations retrieve them
+func (self *ResourceHandler) forgetRecentBlocks() {
+	self.recentBlocks.Purge()
+}
+
 // Calculate the period index (aka major version number) from a given block number
 func (self *ResourceHandler) BlockToPeriod(name string, blocknumber uint64) (uint32, error) {
-	return getNextPeriod(self.resources[name].startBlock, blocknumber, self.resources[name].frequency)
+	rsrc := self.getResource(name)
+	return self.getPeriod(rsrc, blocknumber)
 }
 
 // Calculate the block number from a given period index (aka major version number)
-func (self *ResourceHandler) PeriodToBlock(name string, period uint32) uint64 {
-	return self.resources[name].startBlock + (uint64(period) * self.resources[name].frequency)
-}
-
-// Retrieves the resource index value for the given nameHash
-func (self *ResourceHandler) getResource(nameHash string) *resource {
-	self.resourceLock.RLock()
-	defer self.resourceLock.RUnlock()
-	rsrc := self.resources[nameHash]
-	return rsrc
-}
-
-// Sets the resource index value for the given nameHash
-func (self *ResourceHandler) setResource(nameHash string, rsrc *resource) {
-	self.resourceLock.Lock()
-	defer self.resourceLock.Unlock()
-	self.resources[nameHash] = rsrc
+func (self *ResourceHandler) PeriodToBlock(name string, period uint32) (uint64, error) {
+	rsrc := self.getResource(name)
+	return self.getPeriodEnd(rsrc, period)
 }
 
 // Create a new update chunk key
 // format is: hash(period|version|namehash)
+//
+// the hash algorithm is the one of the resource, see ResourceHashAlgorithm
 func (self *ResourceHandler) resourceHash(period uint32, version uint32, namehash common.Hash) Key {
-	hasher := self.hashPool.Get().(SwarmHash)
-	defer self.hashPool.Put(hasher)
-	hasher.Reset()
-	b := make([]byte, 4)
-	binary.LittleEndian.PutUint32(b, period)
-	hasher.Write(b)
-	binary.LittleEndian.PutUint32(b, version)
-	hasher.Write(b)
-	hasher.Write(namehash[:])
-	return hasher.Sum(nil)
-}
-
-// Checks if we already have an update on this resource, according to the value in the current state of the resource index
-func (self *ResourceHandler) hasUpdate(nameHash string, period uint32) bool {
-	return self.resources[nameHash].lastPeriod == period
+	return self.resourceHashWith(self.hashAlgorithm(namehash), period, version, namehash)
 }
 
 func getAddressFromDataSig(datahash common.Hash, signature Signature) (common.Address, error) {
@@ -952,7 +2040,14 @@ func getAddressFromDataSig(datahash common.Hash, signature Signature) (common.Ad
 }
 
 // create an update chunk
-func newUpdateChunk(key Key, signature *Signature, period uint32, version uint32, name string, data []byte, datalength int) *Chunk {
+//
+// the header holds the creation time, the signer and the encryption metadata,
+// a nil header is empty. The flags mark the kind of the data, see
+// resourceDataRefFlag.
+func newUpdateChunk(key Key, signature *Signature, header *resourceUpdateHeader, period uint32, version uint32, name string, data []byte, flags uint8) *Chunk {
+	if header == nil {
+		header = &resourceUpdateHeader{}
+	}
 
 	// no signatures if no validator
 	var signaturelength int
@@ -961,55 +2056,79 @@ func newUpdateChunk(key Key, signature *Signature, period uint32, version uint32
 	}
 
 	// prepend version and period to allow reverse lookups
-	headerlength := len(name) + 4 + 4
+	headerlength := len(name) + 4 + 4 + header.length()
 
-	actualdatalength := len(data)
-	chunk := NewChunk(key, nil)
-	chunk.SData = make([]byte, 4+signaturelength+headerlength+actualdatalength) // initial 4 are uint16 length descriptors for headerlength and datalength
+	datalength := len(data)
+	chunkdata := make([]byte, resourceUpdatePrefixLength+signaturelength+headerlength+datalength) // the prefix holds the uint16 header length, the flags byte and the uint32 data length
 
 	// data header length does NOT include the header length prefix bytes themselves
 	cursor := 0
-	binary.LittleEndian.PutUint16(chunk.SData[cursor:], uint16(headerlength))
+	binary.LittleEndian.PutUint16(chunkdata[cursor:], uint16(headerlength))
 	cursor += 2
 
+	chunkdata[cursor] = flags
+	cursor++
+
 	// data length
-	binary.LittleEndian.PutUint16(chunk.SData[cursor:], uint16(datalength))
-	cursor += 2
+	binary.LittleEndian.PutUint32(chunkdata[cursor:], uint32(datalength))
+	cursor += 4
 
-	// header = period + version + name
-	binary.LittleEndian.PutUint32(chunk.SData[cursor:], period)
+	// header = period + version + versioned header + name
+	binary.LittleEndian.PutUint32(chunkdata[cursor:], period)
 	cursor += 4
 
-	binary.LittleEndian.PutUint32(chunk.SData[cursor:], version)
+	binary.LittleEndian.PutUint32(chunkdata[cursor:], version)
 	cursor += 4
 
+	headerbytes, _ := header.MarshalBinary()
+	copy(chunkdata[cursor:], headerbytes)
+	cursor += len(headerbytes)
+
 	namebytes := []byte(name)
-	copy(chunk.SData[cursor:], namebytes)
+	copy(chunkdata[cursor:], namebytes)
 	cursor += len(namebytes)
 
 	// add the data
-	copy(chunk.SData[cursor:], data)
+	copy(chunkdata[cursor:], data)
 
 	// if signature is present it's the last item in the chunk data
 	if signature != nil {
-		cursor += actualdatalength
-		copy(chunk.SData[cursor:], signature[:])
+		cursor += datalength
+		copy(chunkdata[cursor:], signature[:])
 	}
 
+	// the chunk is stored in the current format
+	chunk := NewChunk(key, nil)
+	chunk.SData, _ = encodeResourceChunk(chunkdata)
 	chunk.Size = int64(len(chunk.SData))
 	return chunk
 }
 
 // Helper function to calculate the next update period number from the current block, start block and frequency
+//
+// Period numbers are encoded in 32 bits in the keys and in the headers of the
+// update chunks, a period past math.MaxUint32 is an ErrPeriodOverflow
 func getNextPeriod(start uint64, current uint64, frequency uint64) (uint32, error) {
 	if current < start {
 		return 0, NewResourceError(ErrInvalidValue, fmt.Sprintf("given current block value %d < start block %d", current, start))
 	}
 	blockdiff := current - start
 	period := blockdiff / frequency
+	if period >= math.MaxUint32 {
+		return 0, NewResourceError(ErrPeriodOverflow, fmt.Sprintf("period of block %d exceeds %d periods of %d blocks from block %d", current, uint32(math.MaxUint32), frequency, start))
+	}
 	return uint32(period + 1), nil
 }
 
+// Helper function to calculate the position on the period scale the given
+// number of periods after start, an ErrPeriodOverflow if it exceeds 64 bits
+func getPeriodOffset(start uint64, frequency uint64, periods uint32) (uint64, error) {
+	if frequency != 0 && uint64(periods) > (math.MaxUint64-start)/frequency {
+		return 0, NewResourceError(ErrPeriodOverflow, fmt.Sprintf("%d periods of %d blocks from block %d exceed the block range", periods, frequency, start))
+	}
+	return start + uint64(periods)*frequency, nil
+}
+
 // ToSafeName is a helper function to create an valid idna of a given resource update name
 func ToSafeName(name string) (string, error) {
 	return idna.ToASCII(name)
@@ -1028,31 +2147,6 @@ func isSafeName(name string) bool {
 	return validname == name
 }
 
-// if first byte is the start of a multihash this function will try to parse it
-// if successful it returns the length of multihash data, 0 otherwise
-func isMultihash(data []byte) int {
-	cursor := 0
-	_, c := binary.Uvarint(data)
-	if c <= 0 {
-		log.Warn("Corrupt multihash data, hashtype is unreadable")
-		return 0
-	}
-	cursor += c
-	hashlength, c := binary.Uvarint(data[cursor:])
-	if c <= 0 {
-		log.Warn("Corrupt multihash data, hashlength is unreadable")
-		return 0
-	}
-	cursor += c
-	// we cheekily assume hashlength < maxint
-	inthashlength := int(hashlength)
-	if len(data[cursor:]) < inthashlength {
-		log.Warn("Corrupt multihash data, hash does not align with data boundary")
-		return 0
-	}
-	return cursor + inthashlength
-}
-
 func NewTestResourceHandler(datadir string, params *ResourceHandlerParams) (*ResourceHandler, error) {
 	path := filepath.Join(datadir, DbDirName)
 	rh, err := NewResourceHandler(params)
@@ -1066,6 +2160,7 @@ func NewTestResourceHandler(datadir string, params *ResourceHandlerParams) (*Res
 		return nil, fmt.Errorf("localstore create fail, path %s: %v", path, err)
 	}
 	localStore.Validators = append(localStore.Validators, NewContentAddressValidator(MakeHashFunc(resourceHash)))
+	localStore.Validators = append(localStore.Validators, NewContentAddressValidator(MakeHashFunc(DefaultHash)))
 	localStore.Validators = append(localStore.Validators, rh)
 	dpaStore := NewNetStore(localStore, nil)
 	rh.SetStore(dpaStore)
@@ -0,0 +1,219 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// resources with an access control list are indicated by this flag in
+	// the frequency field of the metadata chunk
+	resourceACLFlag = uint64(1) << 62
+
+	// maximum number of owners in an access control list
+	MaxResourceOwners = 255
+)
+
+// ResourceACL lists the addresses authorized to update a resource in
+// addition to the owner of its ENS name
+type ResourceACL struct {
+	Owners   []common.Address
+	Contract common.Address // if set, the contract is queried for authorization of updaters not listed in Owners
+}
+
+func (self *ResourceACL) isOwner(address common.Address) bool {
+	for _, owner := range self.Owners {
+		if owner == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Queries the contracts authorizing resource updaters
+type aclValidator interface {
+	ValidateUpdater(contract common.Address, name string, address common.Address) (bool, error)
+}
+
+// the access control list follows the fixed fields of the metadata chunk:
+//
+// namelength|name|contract|ownercount|owners|signature
+//
+// namelength is a 16 bit value and ownercount a single byte. The signature of
+// the ENS owner covers the metadata preceding it, so that the list cannot
+// be forged by others.
+func (self *resource) marshalACL() []byte {
+	b := make([]byte, 2+len(self.name)+common.AddressLength+1+len(self.acl.Owners)*common.AddressLength+signatureLength)
+	binary.LittleEndian.PutUint16(b, uint16(len(self.name)))
+	cursor := 2
+	cursor += copy(b[cursor:], self.name)
+	cursor += copy(b[cursor:], self.acl.Contract[:])
+	b[cursor] = uint8(len(self.acl.Owners))
+	cursor++
+	for _, owner := range self.acl.Owners {
+		cursor += copy(b[cursor:], owner[:])
+	}
+	copy(b[cursor:], self.aclSignature[:])
+	return b
+}
+
+func (self *resource) unmarshalACL(data []byte) error {
+	if len(data) < 2 {
+		return NewResourceError(ErrCorruptData, "Access control list too short")
+	}
+	namelength := int(binary.LittleEndian.Uint16(data))
+	cursor := 2
+	if len(data) < cursor+namelength+common.AddressLength+1 {
+		return NewResourceError(ErrCorruptData, "Access control list too short")
+	}
+	self.name = string(data[cursor : cursor+namelength])
+	cursor += namelength
+	acl := &ResourceACL{}
+	cursor += copy(acl.Contract[:], data[cursor:])
+	count := int(data[cursor])
+	cursor++
+	if len(data) != cursor+count*common.AddressLength+signatureLength {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid access control list length %d for %d owners", len(data), count))
+	}
+	acl.Owners = make([]common.Address, count)
+	for i := range acl.Owners {
+		cursor += copy(acl.Owners[i][:], data[cursor:])
+	}
+	copy(self.aclSignature[:], data[cursor:])
+	self.acl = acl
+	return nil
+}
+
+// the digest of the metadata signed by the ENS owner
+func (self *ResourceHandler) aclDigest(rsrc *resource) (common.Hash, error) {
+	meta, err := rsrc.MarshalBinary()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	hasher := self.getHasher(rsrc.hash, len(meta)-signatureLength)
+	defer self.putHasher(rsrc.hash, hasher)
+	hasher.Write(meta[:len(meta)-signatureLength])
+	return common.BytesToHash(hasher.Sum(nil)), nil
+}
+
+// Creates a new root entry for a mutable resource like NewResourceWithScheme,
+// which can also be updated by the addresses in the access control list.
+//
+// The list is signed with the signer of the handler, which must be the owner
+// of the ENS name if owners are validated.
+func (self *ResourceHandler) NewResourceWithACL(ctx context.Context, name string, frequency uint64, scheme ResourcePeriodScheme, acl *ResourceACL) (Key, *resource, error) {
+	if acl != nil {
+		if len(acl.Owners) == 0 && acl.Contract == (common.Address{}) {
+			return nil, nil, NewResourceError(ErrInvalidValue, "Access control list without owners or contract")
+		} else if len(acl.Owners) > MaxResourceOwners {
+			return nil, nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Too many owners: %d / %d", len(acl.Owners), MaxResourceOwners))
+		}
+	}
+	return self.newResource(ctx, name, frequency, scheme, ResourceHashKeccak256, acl)
+}
+
+// Checks that the access control list of a loaded resource was signed by
+// the owner of its ENS name, or by the user of a topic feed
+func (self *ResourceHandler) checkACL(rsrc *resource) error {
+	if rsrc.acl == nil {
+		return nil
+	} else if _, ok := topicOwner(rsrc.name); !ok && self.ownerValidator == nil {
+		return nil
+	}
+	digest, err := self.aclDigest(rsrc)
+	if err != nil {
+		return err
+	}
+	addr, err := getAddressFromDataSig(digest, rsrc.aclSignature)
+	if err != nil {
+		return WrapResourceError(ErrUnauthorized, "Invalid access control list signature", err)
+	}
+	ok, err := self.checkOwner(rsrc.name, addr)
+	if err != nil {
+		return err
+	} else if !ok {
+		return NewResourceError(ErrUnauthorized, fmt.Sprintf("Access control list not signed by owner of '%s'", rsrc.name))
+	}
+	return nil
+}
+
+// Checks if the address may update the resource
+//
+// Any address listed in the access control list of the resource, or
+// authorized by its contract, may update it in addition to the ENS owner.
+// Without an owner validator all addresses may update resources without an
+// access control list.
+//
+// The access control list is taken from the index, or from the metadata chunk
+// in the local store if its key is known (see ResourceRoot). Updates of other
+// resources are validated against the ENS owner.
+func (self *ResourceHandler) checkAccess(name string, address common.Address) (bool, error) {
+	rsrc := self.localResource(ResourceNameHash(name))
+	if rsrc == nil || rsrc.acl == nil {
+		return self.checkOwner(name, address)
+	}
+	if rsrc.acl.isOwner(address) {
+		return true, nil
+	}
+	if rsrc.acl.Contract != (common.Address{}) && self.aclValidator != nil {
+		ok, err := self.aclValidator.ValidateUpdater(rsrc.acl.Contract, name, address)
+		if err != nil {
+			log.Warn("resource access control contract query failed", "name", name, "contract", rsrc.acl.Contract, "err", err)
+		} else if ok {
+			return true, nil
+		}
+	}
+	if _, ok := topicOwner(name); !ok && self.ownerValidator == nil {
+		return false, nil
+	}
+	return self.checkOwner(name, address)
+}
+
+// returns the resource from the index, or else parses it from its metadata
+// chunk if both its key and the chunk are in the local store. It returns nil
+// if neither, or if the access control list of the metadata chunk is not
+// signed by the owner. The parsed resource is not added to the index.
+func (self *ResourceHandler) localResource(nameHash common.Hash) *resource {
+	if rsrc := self.getResource(nameHash.Hex()); rsrc != nil {
+		return rsrc
+	} else if self.chunkStore == nil {
+		return nil
+	}
+	root, err := self.chunkStore.GetResourceRoot(nameHash)
+	if err != nil {
+		return nil
+	}
+	chunk, err := self.chunkStore.getLocal(root)
+	if err != nil {
+		return nil
+	}
+	rsrc, err := parseResourceMetadata(chunk.SData)
+	if err != nil || rsrc.nameHash != nameHash {
+		return nil
+	}
+	if err := self.checkACL(rsrc); err != nil {
+		log.Warn("resource access control list of stored metadata chunk rejected", "namehash", nameHash, "rootkey", root, "err", err)
+		return nil
+	}
+	return rsrc
+}
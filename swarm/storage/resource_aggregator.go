@@ -0,0 +1,152 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// FeedItem is an update in the stream of a FeedAggregator
+type FeedItem struct {
+	*ResourceView
+	// Also holds the namehashes of the other feeds which published the same
+	// data, as far as the stream has been read
+	Also []common.Hash
+}
+
+// FeedAggregator merges the updates of a follow-list of resources into a
+// single stream, newest first by the creation time of the updates, such as
+// the timeline of the accounts a user follows. Updates with the same data,
+// such as reposts, are returned once, with the other feeds publishing them
+// in FeedItem.Also.
+//
+// The latest updates of the feeds are looked up together on the first read,
+// see LookupLatestMany, and each feed is walked back to older updates as the
+// stream is read. The feeds must have been created or loaded by the handler.
+// An aggregator is not safe for concurrent use.
+type FeedAggregator struct {
+	rh         *ResourceHandler
+	nameHashes []common.Hash
+	heads      []*ResourceView // the newest update of each feed not merged yet, nil when exhausted
+	seen       map[common.Hash]*FeedItem
+	errors     map[common.Hash]error
+	started    bool
+}
+
+// NewFeedAggregator returns an aggregator of the feeds with the namehashes.
+// Feeds given more than once are merged once.
+func (self *ResourceHandler) NewFeedAggregator(nameHashes []common.Hash) *FeedAggregator {
+	var unique []common.Hash
+	given := make(map[common.Hash]bool)
+	for _, nameHash := range nameHashes {
+		if !given[nameHash] {
+			given[nameHash] = true
+			unique = append(unique, nameHash)
+		}
+	}
+	return &FeedAggregator{
+		rh:         self,
+		nameHashes: unique,
+		heads:      make([]*ResourceView, len(unique)),
+		seen:       make(map[common.Hash]*FeedItem),
+		errors:     make(map[common.Hash]error),
+	}
+}
+
+// Next returns at most n more updates of the stream, fewer once all feeds are
+// exhausted. Feeds whose latest update cannot be looked up are left out of
+// the stream, see Errors.
+func (self *FeedAggregator) Next(ctx context.Context, n int) ([]*FeedItem, error) {
+	if !self.started {
+		if err := self.start(ctx); err != nil {
+			return nil, err
+		}
+	}
+	var items []*FeedItem
+	for len(items) < n {
+		if err := ctx.Err(); err != nil {
+			return items, WrapResourceError(ErrIO, "Feed aggregation aborted", err)
+		}
+		i := self.newest()
+		if i < 0 {
+			break
+		}
+		view := self.heads[i]
+		// an error here means the oldest update of the feed was reached
+		if prev, err := self.rh.LookupPrevious(ctx, view, nil); err == nil {
+			self.heads[i] = prev
+		} else {
+			self.heads[i] = nil
+		}
+
+		key := crypto.Keccak256Hash(view.data)
+		if item, ok := self.seen[key]; ok {
+			metrics.GetOrRegisterCounter("resource.aggregator.duplicate", nil).Inc(1)
+			if item.nameHash != view.nameHash {
+				item.Also = append(item.Also, view.nameHash)
+			}
+			continue
+		}
+		item := &FeedItem{ResourceView: view}
+		self.seen[key] = item
+		items = append(items, item)
+	}
+	metrics.GetOrRegisterCounter("resource.aggregator.items", nil).Inc(int64(len(items)))
+	return items, nil
+}
+
+// Errors returns the errors of the lookups of the latest updates of the
+// feeds left out of the stream, by namehash
+func (self *FeedAggregator) Errors() map[common.Hash]error {
+	return self.errors
+}
+
+// looks up the latest updates of the feeds
+func (self *FeedAggregator) start(ctx context.Context) error {
+	results := self.rh.LookupLatestMany(ctx, self.nameHashes)
+	if err := ctx.Err(); err != nil {
+		return WrapResourceError(ErrIO, "Feed aggregation aborted", err)
+	}
+	for i, nameHash := range self.nameHashes {
+		result := results[nameHash]
+		if result.Err != nil {
+			log.Debug("aggregated feed lookup failed", "namehash", nameHash, "err", result.Err)
+			self.errors[nameHash] = result.Err
+			continue
+		}
+		self.heads[i] = result.Resource
+	}
+	self.started = true
+	return nil
+}
+
+// returns the index of the feed with the newest head, the feed given first
+// on ties, -1 if all feeds are exhausted
+func (self *FeedAggregator) newest() int {
+	newest := -1
+	for i, head := range self.heads {
+		if head != nil && (newest < 0 || head.modTime > self.heads[newest].modTime) {
+			newest = i
+		}
+	}
+	return newest
+}
@@ -0,0 +1,276 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	// anchors are stored in period 0 like revocations, with versions from
+	// this offset on
+	resourceAnchorVersionOffset = 0x80000000
+
+	// the data of anchor updates is period|startBlock|frequency
+	anchorDataLength = 4 + 8 + 8
+)
+
+// ResourceAnchor changes the frequency of a resource from Period on, which
+// starts at StartBlock. Like the start block of the metadata chunk, it is a
+// unix time for time based resources.
+type ResourceAnchor struct {
+	Period     uint32
+	StartBlock uint64
+	Frequency  uint64
+}
+
+// Reanchor changes the frequency of the resource, which is fixed by its
+// metadata chunk otherwise. The new frequency applies from the next period on,
+// which starts at the current block height, or time for time based resources.
+// Periods keep increasing across anchors, so lookups follow the anchors
+// transparently.
+//
+// Anchors must be signed by the owner of the resource name. They are stored
+// as update chunks of period 0 with consecutive versions after an offset, so
+// they do not collide with revocations:
+//
+// resourceHash(0|offset+index|namehash)
+//
+// Lookups only follow the anchors known to the handler, which are retrieved
+// when the resource is loaded and when lookups refresh it. Anchors missing
+// from the network are only requested again after resourceProbeInterval.
+func (self *ResourceHandler) Reanchor(ctx context.Context, name string, frequency uint64) (_ Key, err error) {
+	defer annotateResourceError(&err, name, 0, 0)
+
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before reanchoring")
+	} else if self.signer == nil {
+		return nil, NewResourceError(ErrInit, "Anchors must be signed, set ResourceHandlerParams.Signer")
+	}
+	if frequency == 0 {
+		return nil, NewResourceError(ErrInvalidValue, "Frequency cannot be 0")
+	} else if frequency&(resourceTimeFlag|resourceACLFlag|resourceHashFlag) != 0 {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Frequency too large: %d", frequency))
+	}
+	signer, err := self.signerFor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	self.updateLock.Lock()
+	defer self.updateLock.Unlock()
+
+	nameHash := ResourceNameHash(name)
+	rsrc := self.getResource(nameHash.Hex())
+	if rsrc == nil {
+		return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Resource object '%s' not in index", name))
+	} else if self.getTombstone(nameHash.Hex()) != nil {
+		return nil, NewResourceError(ErrGone, fmt.Sprintf("Resource '%s' was deleted", name))
+	}
+
+	// the anchors published by others must be known to get the next index
+	// and the current period
+	self.loadAnchors(ctx, rsrc)
+	index := uint32(len(self.Anchors(nameHash))) + 1
+	current, err := self.getCurrent(ctx, rsrc)
+	if err != nil {
+		return nil, WrapResourceError(ErrIO, "Could not get block height", err)
+	}
+	period, err := self.getPeriod(rsrc, current)
+	if err != nil {
+		return nil, err
+	}
+	anchor := ResourceAnchor{
+		Period:     period + 1,
+		StartBlock: current,
+		Frequency:  frequency,
+	}
+
+	data := make([]byte, anchorDataLength)
+	binary.LittleEndian.PutUint32(data, anchor.Period)
+	binary.LittleEndian.PutUint64(data[4:], anchor.StartBlock)
+	binary.LittleEndian.PutUint64(data[12:], anchor.Frequency)
+	version := resourceAnchorVersionOffset + index
+	key := self.resourceHash(0, version, nameHash)
+	modTime := uint64(self.now().Unix())
+	digest := self.keyDataHash(nameHash, key, modTime, data)
+	signature, err := signer.Sign(digest)
+	if err != nil {
+		return nil, WrapResourceError(ErrInvalidSignature, "Sign fail", err)
+	}
+	addr, err := getAddressFromDataSig(digest, signature)
+	if err != nil {
+		return nil, WrapResourceError(ErrInvalidSignature, "Invalid data/signature", err)
+	}
+	ok, err := self.checkOwner(name, addr)
+	if err != nil {
+		return nil, WrapResourceError(ErrIO, "Owner check fail", err)
+	} else if !ok {
+		return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x is not the owner of %s", addr, name))
+	}
+
+	header := &resourceUpdateHeader{
+		modTime: modTime,
+		signer:  addr,
+	}
+	chunk := newUpdateChunk(key, &signature, header, 0, version, name, data, 0)
+	self.chunkStore.Put(chunk)
+	timeout := time.NewTimer(self.getStoreTimeout(ctx))
+	defer timeout.Stop()
+	select {
+	case <-chunk.dbStoredC:
+		if err := chunk.GetErrored(); err != nil {
+			return nil, WrapResourceError(ErrIO, "chunk not stored", err)
+		}
+	case <-timeout.C:
+		return nil, NewResourceError(ErrIO, "chunk store timeout")
+	case <-ctx.Done():
+		return nil, NewResourceError(ErrIO, fmt.Sprintf("chunk not stored: %v", ctx.Err()))
+	}
+
+	self.addAnchor(nameHash.Hex(), index, anchor)
+	metrics.GetOrRegisterCounter("resource.reanchor", nil).Inc(1)
+	log.Debug("resource reanchored", "name", name, "key", key, "period", anchor.Period, "startblock", anchor.StartBlock, "frequency", anchor.Frequency)
+	return key, nil
+}
+
+// Anchors returns the anchors of the resource known to the handler, in the
+// order of their periods
+func (self *ResourceHandler) Anchors(nameHash common.Hash) []ResourceAnchor {
+	self.anchorLock.RLock()
+	defer self.anchorLock.RUnlock()
+	return append([]ResourceAnchor{}, self.anchors[nameHash.Hex()]...)
+}
+
+// retrieves the anchors of the resource following the ones already known
+func (self *ResourceHandler) loadAnchors(ctx context.Context, rsrc *resource) {
+	timeout := self.getRetrieveTimeout(ctx)
+	for {
+		index := uint32(len(self.Anchors(rsrc.nameHash))) + 1
+		chunk, err := self.probe(self.chunkStore, self.resourceHash(0, resourceAnchorVersionOffset+index, rsrc.nameHash), timeout)
+		if err != nil {
+			return
+		}
+		anchor, err := self.parseAnchor(rsrc, chunk)
+		if err != nil {
+			log.Warn("Invalid resource anchor", "name", rsrc.name, "index", index, "err", err)
+			return
+		}
+		self.addAnchor(rsrc.nameHash.Hex(), index, anchor)
+	}
+}
+
+// adds the anchor with the given index, unless it was added concurrently
+func (self *ResourceHandler) addAnchor(nameHash string, index uint32, anchor ResourceAnchor) {
+	self.anchorLock.Lock()
+	defer self.anchorLock.Unlock()
+	if uint32(len(self.anchors[nameHash])) == index-1 {
+		self.anchors[nameHash] = append(self.anchors[nameHash], anchor)
+		self.resetHeadPeriod(nameHash)
+	}
+}
+
+// retrieves the anchor from its chunk, and checks that it is signed by the
+// owner of the resource name and follows the anchors already known
+func (self *ResourceHandler) parseAnchor(rsrc *resource, chunk *Chunk) (ResourceAnchor, error) {
+	var anchor ResourceAnchor
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return anchor, err
+	}
+	signature, period, version, name, data, _, err := self.parseUpdate(chunkdata)
+	if err != nil {
+		return anchor, err
+	} else if !isAnchorUpdate(period, version) || len(data) != anchorDataLength {
+		return anchor, NewResourceError(ErrCorruptData, "Chunk is not a resource anchor")
+	} else if name != rsrc.name {
+		return anchor, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Anchor belongs to '%s', but have '%s'", name, rsrc.name))
+	}
+	if signature == nil {
+		return anchor, NewResourceError(ErrUnauthorized, "Anchor is not signed")
+	}
+	addr, err := self.recoverSigner(rsrc.nameHash, chunk.Key, chunkdata, data, signature)
+	if err != nil {
+		return anchor, err
+	}
+	if ok, _ := self.checkOwner(name, addr); !ok {
+		return anchor, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x is not the owner of %s", addr, name))
+	}
+	anchor.Period = binary.LittleEndian.Uint32(data)
+	anchor.StartBlock = binary.LittleEndian.Uint64(data[4:])
+	anchor.Frequency = binary.LittleEndian.Uint64(data[12:])
+
+	// anchors may only move forward
+	prevPeriod, prevStart := uint32(1), rsrc.startBlock
+	if anchors := self.Anchors(rsrc.nameHash); len(anchors) > 0 {
+		prevPeriod, prevStart = anchors[len(anchors)-1].Period, anchors[len(anchors)-1].StartBlock
+	}
+	if anchor.Frequency == 0 || anchor.Period <= prevPeriod || anchor.StartBlock < prevStart {
+		return anchor, NewResourceError(ErrCorruptData, fmt.Sprintf("Anchor of period %d at %d does not follow period %d at %d", anchor.Period, anchor.StartBlock, prevPeriod, prevStart))
+	}
+	return anchor, nil
+}
+
+// returns the period of the resource at the position current on its period
+// scale, following its anchors
+func (self *ResourceHandler) getPeriod(rsrc *resource, current uint64) (uint32, error) {
+	start, frequency, base := rsrc.startBlock, rsrc.frequency, uint32(0)
+	for _, anchor := range self.Anchors(rsrc.nameHash) {
+		if current < anchor.StartBlock {
+			break
+		}
+		start, frequency, base = anchor.StartBlock, anchor.Frequency, anchor.Period-1
+	}
+	period, err := getNextPeriod(start, current, frequency)
+	if err != nil {
+		return 0, err
+	}
+	if period > math.MaxUint32-base {
+		return 0, NewResourceError(ErrPeriodOverflow, fmt.Sprintf("period %d after anchor of period %d exceeds %d periods", period, base+1, uint32(math.MaxUint32)))
+	}
+	return base + period, nil
+}
+
+// returns the position of the end of the period on the period scale of the
+// resource, following its anchors. The last period before an anchor ends
+// early, where the anchor starts.
+func (self *ResourceHandler) getPeriodEnd(rsrc *resource, period uint32) (uint64, error) {
+	start, frequency, base := rsrc.startBlock, rsrc.frequency, uint32(0)
+	for _, anchor := range self.Anchors(rsrc.nameHash) {
+		if period < anchor.Period {
+			if end, err := getPeriodOffset(start, frequency, period-base); err == nil && end < anchor.StartBlock {
+				return end, nil
+			}
+			return anchor.StartBlock, nil
+		}
+		start, frequency, base = anchor.StartBlock, anchor.Frequency, anchor.Period-1
+	}
+	return getPeriodOffset(start, frequency, period-base)
+}
+
+// returns true if the update of the period and version is an anchor
+func isAnchorUpdate(period uint32, version uint32) bool {
+	return period == 0 && version > resourceAnchorVersionOffset
+}
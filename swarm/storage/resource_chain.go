@@ -0,0 +1,161 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const defaultChainRetryInterval = 30 * time.Second
+
+var headerChainFailoverCount = metrics.NewRegisteredCounter("resource.chain.failover", nil)
+
+// HeaderSource is a source of the block headers of a HeaderChain
+type HeaderSource struct {
+	Name   string // name of the source in logs
+	Getter headerGetter
+	// Estimate is true if the source estimates block heights rather than
+	// retrieving them from the chain, like the block estimator. Estimating
+	// sources are never used offline and their headers are not checked for
+	// their age.
+	Estimate bool
+}
+
+// HeaderChainParams configure a HeaderChain
+type HeaderChainParams struct {
+	Sources       []HeaderSource // in order of preference
+	RetryInterval time.Duration  // sources which failed are skipped for this long, default if 0
+	MaxHeaderAge  time.Duration  // latest headers older than this are taken as of a source which is not synced, not checked if 0
+	Offline       bool           // never estimate block heights, fail with ErrNoChain instead
+}
+
+// HeaderChain gets block headers from the first of its sources which is
+// healthy, for example a light client, an RPC endpoint and the block
+// estimator. A source is unhealthy for the retry interval after it failed,
+// or after it returned a latest header older than the maximum age, as light
+// clients do while they are not synced. The next source is tried in its place.
+//
+// If no source returns a header, ErrNoChain is returned. Offline, estimating
+// sources are left out, so that block based resources fail rather than use
+// wrong block heights.
+type HeaderChain struct {
+	sources       []*headerChainSource
+	retryInterval time.Duration
+	maxHeaderAge  time.Duration
+	offline       bool
+	now           func() time.Time
+	lock          sync.Mutex
+}
+
+// a source of a header chain and its health
+type headerChainSource struct {
+	HeaderSource
+	failedUntil time.Time // the source is skipped until then
+}
+
+// NewHeaderChain returns a header chain of the sources
+func NewHeaderChain(params *HeaderChainParams) *HeaderChain {
+	c := &HeaderChain{
+		retryInterval: params.RetryInterval,
+		maxHeaderAge:  params.MaxHeaderAge,
+		offline:       params.Offline,
+		now:           time.Now,
+	}
+	if c.retryInterval == 0 {
+		c.retryInterval = defaultChainRetryInterval
+	}
+	for _, source := range params.Sources {
+		if params.Offline && source.Estimate {
+			continue
+		}
+		c.sources = append(c.sources, &headerChainSource{
+			HeaderSource: source,
+		})
+	}
+	return c
+}
+
+// HeaderByNumber returns the header from the first healthy source which has
+// it, the latest header if number is nil
+func (c *HeaderChain) HeaderByNumber(ctx context.Context, name string, number *big.Int) (*types.Header, error) {
+	var lastErr error
+	for _, source := range c.healthy() {
+		header, err := source.Getter.HeaderByNumber(ctx, name, number)
+		if err == nil {
+			err = c.checkHeader(source, header, number)
+		}
+		if err == nil {
+			return header, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, WrapResourceError(ErrIO, "Block header retrieval aborted", ctxErr)
+		}
+		c.fail(source, err)
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, NewResourceError(ErrNoChain, "No chain source available")
+	}
+	return nil, WrapResourceError(ErrNoChain, "No chain source available", lastErr)
+}
+
+// returns the sources which are not skipped after a failure, in order
+func (c *HeaderChain) healthy() []*headerChainSource {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := c.now()
+	var sources []*headerChainSource
+	for _, source := range c.sources {
+		if !now.Before(source.failedUntil) {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// returns an error if the header cannot be used
+func (c *HeaderChain) checkHeader(source *headerChainSource, header *types.Header, number *big.Int) error {
+	if header == nil || header.Number == nil {
+		return errors.New("no header")
+	}
+	if number != nil || source.Estimate || c.maxHeaderAge == 0 || header.Time == nil {
+		return nil
+	}
+	age := c.now().Sub(time.Unix(header.Time.Int64(), 0))
+	if age > c.maxHeaderAge {
+		return fmt.Errorf("latest header %d is %v old, not synced", header.Number, age)
+	}
+	return nil
+}
+
+// skips the source for the retry interval
+func (c *HeaderChain) fail(source *headerChainSource, err error) {
+	headerChainFailoverCount.Inc(1)
+	log.Warn("resource: chain source failed", "source", source.Name, "retry", c.retryInterval, "err", err)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	source.failedUntil = c.now().Add(c.retryInterval)
+}
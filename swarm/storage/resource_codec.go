@@ -0,0 +1,327 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/contracts/ens"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Resource chunks carry the version of their format, so the layout of the
+// chunks can change while the chunks of earlier formats remain readable.
+//
+// Versioned chunks start with resourceFormatMarker followed by the format
+// version. The marker cannot start the chunks of the first, unversioned
+// format: metadata chunks start with two zero bytes, and update chunks with
+// their header length, which is less than the chunk size. The chunks of
+// each format are converted from and to the layout parsed by the handler by
+// the codec of the format.
+//
+// The update chunks of the unversioned format have either the baseline layout
+// of the first releases or the legacy layout. The baseline layout has no
+// header and no flags, a 16 bit data length field, and a data length of 0 for
+// multihash data. The legacy layout adds the header, see resourceUpdateHeader,
+// and the flags of the update in the highest bits of the data length field.
+// The update chunks of format 1 have the legacy layout. Format 2 has the
+// layout parsed by the handler, with a flags byte and a 32 bit data length,
+// see ResourceHandler.
+const (
+	resourceFormatMarker = 0xffff
+
+	resourceFormatLegacy = 0 // the unversioned format
+	resourceFormatV1     = 1
+	resourceFormatV2     = 2 // flags byte and 32 bit data length of updates
+
+	// the format of the chunks created by the handler
+	resourceFormatCurrent = resourceFormatV2
+
+	// length of the marker and the version of versioned chunks
+	resourceFormatPrefixLength = 3
+)
+
+// the fields of the legacy layout of the update chunks
+const (
+	// length of the headerlength and datalength fields
+	legacyUpdatePrefixLength = 4
+
+	// the flags are the highest bits of the datalength field, the flags
+	// byte shifted by 8 bits
+	legacyUpdateFlagsShift = 8
+	legacyUpdateFlagsMask  = 0xf000
+	legacyUpdateMaxLength  = 0x0fff
+)
+
+// resourceCodec converts the resource chunks of one format version from and
+// to the layout parsed by the handler, see parseUpdate and resource.UnmarshalBinary
+type resourceCodec interface {
+	decode(data []byte) ([]byte, error)
+	encode(data []byte) ([]byte, error)
+}
+
+// the codecs of the resource chunk formats by version
+var resourceCodecs = make(map[uint8]resourceCodec)
+
+func init() {
+	registerResourceCodec(resourceFormatLegacy, unversionedResourceCodec{})
+	registerResourceCodec(resourceFormatV1, prefixResourceCodec{version: resourceFormatV1, layout: legacyResourceCodec{}})
+	registerResourceCodec(resourceFormatV2, prefixResourceCodec{version: resourceFormatV2})
+}
+
+// registers the codec of a chunk format version, new formats add their
+// codec here
+func registerResourceCodec(version uint8, codec resourceCodec) {
+	if _, ok := resourceCodecs[version]; ok {
+		panic(fmt.Sprintf("resource codec %d registered twice", version))
+	}
+	resourceCodecs[version] = codec
+}
+
+// the unversioned chunks have the baseline or the legacy layout, which are
+// converted to the layout parsed by the handler. Metadata chunks have the same
+// layout in all of them. No chunks are made in the unversioned format.
+type unversionedResourceCodec struct{}
+
+func (unversionedResourceCodec) decode(data []byte) ([]byte, error) {
+	if len(data) < legacyUpdatePrefixLength || (data[0] == 0 && data[1] == 0) {
+		return data, nil
+	} else if hasLegacyUpdateHeader(data) {
+		return legacyResourceCodec{}.decode(data)
+	}
+	return decodeBaselineUpdate(data)
+}
+
+func (unversionedResourceCodec) encode(data []byte) ([]byte, error) {
+	return nil, NewResourceError(ErrInvalidValue, "Resource chunks are not made in the unversioned format")
+}
+
+// the name of baseline updates follows the period and the version, where the
+// legacy layout has the header version. Names never start with the header
+// version byte, which is a control character.
+func hasLegacyUpdateHeader(data []byte) bool {
+	offset := legacyUpdatePrefixLength + 8
+	headerlength := int(binary.LittleEndian.Uint16(data))
+	return len(data) > offset && data[offset] == resourceHeaderVersion && headerlength > 8+resourceHeaderMetaLength
+}
+
+// converts an update chunk of the baseline layout:
+//
+//	headerlength(2) | datalength(2) | period(4) | version(4) | name | data | signature
+//
+// where headerlength is the length of period, version and name, and a
+// datalength of 0 indicates multihash data. The header of the baseline
+// version is inserted before the name. Baseline updates have no modification
+// time, and their signature digest is the keccak256 hash of the key and the
+// data, see baselineUpdateDigest. The signer of the header is the one
+// recovered from this digest, zero if the update is not signed or the
+// signature is invalid.
+func decodeBaselineUpdate(data []byte) ([]byte, error) {
+	headerlength := int(binary.LittleEndian.Uint16(data))
+	datalength := int(binary.LittleEndian.Uint16(data[2:]))
+	offset := legacyUpdatePrefixLength + headerlength
+	if headerlength <= 8 {
+		return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Reported headerlength %d leaves no room for the name", headerlength))
+	} else if offset > len(data) {
+		return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Reported headerlength %d longer than actual chunk data length %d", headerlength, len(data)))
+	}
+	var flags uint8
+	if datalength == 0 {
+		var err error
+		if datalength, err = legacyMultihashLength(data[offset:]); err != nil {
+			return nil, err
+		}
+		flags = resourceMultihashFlag
+	}
+	if offset+datalength > len(data) {
+		return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Reported headerlength %d + datalength %d longer than actual chunk data length %d", headerlength, datalength, len(data)))
+	}
+
+	header := make([]byte, resourceHeaderMetaLength)
+	header[0] = resourceHeaderVersionBaseline
+	if trailer := data[offset+datalength:]; len(trailer) >= signatureLength {
+		var signature Signature
+		copy(signature[:], trailer)
+		period := binary.LittleEndian.Uint32(data[4:])
+		version := binary.LittleEndian.Uint32(data[8:])
+		key := baselineResourceHash(period, version, ens.EnsNode(string(data[12:offset])))
+		if addr, err := getAddressFromDataSig(baselineUpdateDigest(key, data[offset:offset+datalength]), signature); err == nil {
+			copy(header[9:], addr[:])
+		}
+	}
+
+	decoded := make([]byte, resourceUpdatePrefixLength-legacyUpdatePrefixLength+resourceHeaderMetaLength+len(data))
+	binary.LittleEndian.PutUint16(decoded, uint16(headerlength+resourceHeaderMetaLength))
+	decoded[resourceUpdateFlagsOffset] = flags
+	binary.LittleEndian.PutUint32(decoded[resourceUpdateLengthOffset:], uint32(datalength))
+	cursor := resourceUpdatePrefixLength
+	cursor += copy(decoded[cursor:], data[legacyUpdatePrefixLength:legacyUpdatePrefixLength+8])
+	cursor += copy(decoded[cursor:], header)
+	copy(decoded[cursor:], data[legacyUpdatePrefixLength+8:])
+	return decoded, nil
+}
+
+// the key of baseline updates, which always used keccak256
+func baselineResourceHash(period uint32, version uint32, nameHash common.Hash) Key {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, period)
+	binary.LittleEndian.PutUint32(b[4:], version)
+	return Key(crypto.Keccak256(b, nameHash[:]))
+}
+
+// the signature digest of baseline updates, without modification time
+func baselineUpdateDigest(key Key, data []byte) common.Hash {
+	return crypto.Keccak256Hash(key[:], data)
+}
+
+// returns the length of the multihash at the start of the data, which gives
+// the data length of the updates whose datalength field is 0
+func legacyMultihashLength(data []byte) (int, error) {
+	code, c := binary.Uvarint(data)
+	if c <= 0 {
+		return 0, NewResourceError(ErrCorruptData, "Corrupt multihash data, hash code is unreadable")
+	}
+	length, c2 := binary.Uvarint(data[c:])
+	if c2 <= 0 {
+		return 0, NewResourceError(ErrCorruptData, fmt.Sprintf("Corrupt multihash data of hash code %x, hash length is unreadable", code))
+	}
+	if length > uint64(len(data)) {
+		return 0, NewResourceError(ErrCorruptData, fmt.Sprintf("Corrupt multihash data, hash length %d exceeds chunk data length %d", length, len(data)))
+	}
+	return c + c2 + int(length), nil
+}
+
+// the legacy layout, which is converted from and to the layout parsed by the
+// handler. Metadata chunks have the same layout in both.
+type legacyResourceCodec struct{}
+
+func (legacyResourceCodec) decode(data []byte) ([]byte, error) {
+	if len(data) < legacyUpdatePrefixLength || (data[0] == 0 && data[1] == 0) {
+		return data, nil
+	}
+	headerlength := int(binary.LittleEndian.Uint16(data))
+	lengthfield := binary.LittleEndian.Uint16(data[2:])
+	flags := uint8((lengthfield & legacyUpdateFlagsMask) >> legacyUpdateFlagsShift)
+	datalength := int(lengthfield &^ legacyUpdateFlagsMask)
+	offset := legacyUpdatePrefixLength + headerlength
+	if offset > len(data) {
+		return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Reported headerlength %d longer than actual chunk data length %d", headerlength, len(data)))
+	}
+	// a data length of 0 indicates multihash data, whose length is the one
+	// of the multihash
+	if datalength == 0 {
+		var err error
+		if datalength, err = legacyMultihashLength(data[offset:]); err != nil {
+			return nil, err
+		}
+		flags |= resourceMultihashFlag
+	}
+	decoded := make([]byte, resourceUpdatePrefixLength-legacyUpdatePrefixLength+len(data))
+	binary.LittleEndian.PutUint16(decoded, uint16(headerlength))
+	decoded[resourceUpdateFlagsOffset] = flags
+	binary.LittleEndian.PutUint32(decoded[resourceUpdateLengthOffset:], uint32(datalength))
+	copy(decoded[resourceUpdatePrefixLength:], data[legacyUpdatePrefixLength:])
+	return decoded, nil
+}
+
+// the updates whose data length does not fit the 12 bits of the legacy
+// datalength field cannot be encoded in the legacy layout
+func (legacyResourceCodec) encode(data []byte) ([]byte, error) {
+	if len(data) < resourceUpdatePrefixLength || (data[0] == 0 && data[1] == 0) {
+		return data, nil
+	}
+	flags := data[resourceUpdateFlagsOffset]
+	datalength := binary.LittleEndian.Uint32(data[resourceUpdateLengthOffset:])
+	if flags&resourceMultihashFlag != 0 {
+		datalength = 0
+	} else if datalength == 0 || datalength > legacyUpdateMaxLength {
+		return nil, NewResourceError(ErrDataOverflow, fmt.Sprintf("Data length %d does not fit the legacy update layout", datalength))
+	}
+	encoded := make([]byte, legacyUpdatePrefixLength-resourceUpdatePrefixLength+len(data))
+	copy(encoded, data[:2])
+	binary.LittleEndian.PutUint16(encoded[2:], uint16(datalength)|uint16(flags&^resourceMultihashFlag)<<legacyUpdateFlagsShift)
+	copy(encoded[legacyUpdatePrefixLength:], data[resourceUpdatePrefixLength:])
+	return encoded, nil
+}
+
+// prefixes the layout of the chunks with the marker and the version. The
+// layout is the one parsed by the handler, unless converted by the layout
+// codec.
+type prefixResourceCodec struct {
+	version uint8
+	layout  resourceCodec
+}
+
+func (c prefixResourceCodec) decode(data []byte) ([]byte, error) {
+	data = data[resourceFormatPrefixLength:]
+	if c.layout != nil {
+		return c.layout.decode(data)
+	}
+	return data, nil
+}
+
+func (c prefixResourceCodec) encode(data []byte) ([]byte, error) {
+	if c.layout != nil {
+		var err error
+		if data, err = c.layout.encode(data); err != nil {
+			return nil, err
+		}
+	}
+	encoded := make([]byte, resourceFormatPrefixLength+len(data))
+	encoded[0] = resourceFormatMarker >> 8
+	encoded[1] = resourceFormatMarker & 0xff
+	encoded[2] = c.version
+	copy(encoded[resourceFormatPrefixLength:], data)
+	return encoded, nil
+}
+
+// returns the format version of the chunk data
+func resourceChunkFormat(data []byte) uint8 {
+	if len(data) >= resourceFormatPrefixLength && data[0] == resourceFormatMarker>>8 && data[1] == resourceFormatMarker&0xff {
+		return data[2]
+	}
+	return resourceFormatLegacy
+}
+
+// converts the chunk data of any known format to the layout parsed by the
+// handler. Only the unversioned format has updates of the baseline layout.
+func decodeResourceChunk(data []byte) ([]byte, error) {
+	version := resourceChunkFormat(data)
+	codec, ok := resourceCodecs[version]
+	if !ok {
+		return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Unknown resource chunk format %d", version))
+	}
+	decoded, err := codec.decode(data)
+	if err != nil {
+		return nil, err
+	} else if version != resourceFormatLegacy && isBaselineUpdate(decoded) {
+		return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Update chunk of format %d has the header of the baseline layout", version))
+	}
+	return decoded, nil
+}
+
+// tells if decoded chunk data is an update converted from the baseline layout
+func isBaselineUpdate(data []byte) bool {
+	return len(data) > resourceUpdateHeaderOffset && !(data[0] == 0 && data[1] == 0) && data[resourceUpdateHeaderOffset] == resourceHeaderVersionBaseline
+}
+
+// converts data in the layout parsed by the handler to the current chunk format
+func encodeResourceChunk(data []byte) ([]byte, error) {
+	return resourceCodecs[resourceFormatCurrent].encode(data)
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// ResourceVersionInfo describes an update of a period found by DetectConflicts
+type ResourceVersionInfo struct {
+	Version  uint32
+	Key      Key            // key of the update chunk
+	Signer   common.Address // zero if the update is not signed
+	DataHash common.Hash    // hash of the update chunk data, as in ResourceConflict
+	ModTime  time.Time      // creation time of the update, as claimed by its signer
+	Revoked  bool           // signed with a revoked key, skipped by lookups
+	// ContentHash is the hash of the update data, the same for updates of
+	// the same content whoever signed them
+	ContentHash common.Hash
+}
+
+// ResourceConflictReport is the result of DetectConflicts for a period of a
+// resource
+type ResourceConflictReport struct {
+	NameHash common.Hash
+	Period   uint32
+	// Versions are the updates of the period reachable from the node, by
+	// version. Lookups return the last one which is not revoked.
+	Versions []ResourceVersionInfo
+	// Signers are the distinct signers of the updates which are not revoked
+	Signers []common.Address
+	// Conflicts are the updates of the period which were seen with different
+	// data for the same version, recorded by strict validation
+	Conflicts []ResourceConflict
+	// Diverged is true if different content was seen for the same version.
+	// Successive versions by different signers, such as the owners of an
+	// ACL, are not a fork.
+	Diverged bool
+}
+
+// DetectConflicts retrieves all versions of the period of the resource
+// reachable from the node and reports whether they diverge, so that
+// applications can resolve forks of the resource.
+//
+// The chunk of a version has one key, so a node only ever holds one of
+// several competing updates for the same version, the one which arrived
+// first. Different data for the same version can only be detected by strict
+// validation as the chunks pass through the node, and is reported from its
+// record, see Conflicts. The period has diverged if the competing updates of
+// a version carry different content; the same content signed by different
+// owners is not a fork. Without strict validation, divergence cannot be
+// detected, and the versions are reported for the application to compare.
+//
+// Versions are retrieved until the first one which cannot be found, like in
+// lookups. The resource must have been created or loaded by the handler.
+func (self *ResourceHandler) DetectConflicts(ctx context.Context, nameHash common.Hash, period uint32) (*ResourceConflictReport, error) {
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before detecting conflicts")
+	} else if period == 0 {
+		return nil, NewResourceError(ErrInvalidValue, "Period 0 holds no updates")
+	}
+	rsrc, err := self.loadResource(nameHash)
+	if err != nil {
+		return nil, err
+	}
+	report := &ResourceConflictReport{
+		NameHash: nameHash,
+		Period:   period,
+	}
+	timeout := self.getRetrieveTimeout(ctx)
+	signers := make(map[common.Address]bool)
+	for version := uint32(1); ; version++ {
+		if err := ctx.Err(); err != nil {
+			return nil, WrapResourceError(ErrIO, "Conflict detection aborted", err)
+		}
+		chunk, err := self.chunkStore.get(self.resourceHash(period, version, nameHash), timeout)
+		if err != nil {
+			break
+		}
+		info, err := self.versionInfo(rsrc, chunk)
+		if err != nil {
+			return nil, WrapResourceError(ErrCorruptData, fmt.Sprintf("Invalid update of period %d version %d", period, version), err)
+		}
+		report.Versions = append(report.Versions, *info)
+		if !info.Revoked && !signers[info.Signer] {
+			signers[info.Signer] = true
+			report.Signers = append(report.Signers, info.Signer)
+		}
+	}
+	if len(report.Versions) == 0 {
+		return nil, NewResourceError(ErrNotFound, fmt.Sprintf("No updates found in period %d", period))
+	}
+	for _, conflict := range self.Conflicts(nameHash) {
+		if conflict.Period == period {
+			report.Conflicts = append(report.Conflicts, conflict)
+			report.Diverged = report.Diverged || !conflict.SameContent
+		}
+	}
+	if report.Diverged {
+		metrics.GetOrRegisterCounter("resource.conflict.detected", nil).Inc(1)
+	}
+	return report, nil
+}
+
+// returns the description of the update chunk of the resource
+func (self *ResourceHandler) versionInfo(rsrc *resource, chunk *Chunk) (*ResourceVersionInfo, error) {
+	content, err := self.readUpdate(rsrc, chunk)
+	if err != nil {
+		return nil, err
+	}
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return nil, err
+	}
+	return &ResourceVersionInfo{
+		Version:     content.version,
+		Key:         chunk.Key,
+		Signer:      content.header.signer,
+		DataHash:    crypto.Keccak256Hash(chunkdata),
+		ModTime:     time.Unix(int64(content.header.modTime), 0),
+		Revoked:     self.isRevokedUpdate(rsrc, chunk),
+		ContentHash: crypto.Keccak256Hash(content.data),
+	}, nil
+}
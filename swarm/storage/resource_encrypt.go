@@ -0,0 +1,156 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	// encrypted updates are indicated by this flag in the flags field
+	resourceEncryptedFlag = 0x10
+
+	// length of the nonce of AES-GCM encrypted updates
+	resourceNonceLength = 12
+)
+
+var (
+	resourceEncryptCount     = metrics.NewRegisteredCounter("resource.encrypt", nil)
+	resourceDecryptFailCount = metrics.NewRegisteredCounter("resource.decrypt.fail", nil)
+)
+
+// ResourceEncryptionScheme identifies the encryption of the data of an update
+type ResourceEncryptionScheme uint8
+
+const (
+	ResourceEncryptionNone   ResourceEncryptionScheme = iota
+	ResourceEncryptionAESGCM                          // AES-256-GCM with a 12 byte nonce
+)
+
+// the encryption metadata of an encrypted update, carried in the header of
+// the update chunk between the version and the name
+type resourceEncryption struct {
+	scheme ResourceEncryptionScheme
+	nonce  []byte
+}
+
+// length of the encryption metadata in the update chunk header
+func (e *resourceEncryption) headerLength() int {
+	return 1 + len(e.nonce)
+}
+
+func (e *resourceEncryption) MarshalBinary() ([]byte, error) {
+	data := make([]byte, e.headerLength())
+	data[0] = byte(e.scheme)
+	copy(data[1:], e.nonce)
+	return data, nil
+}
+
+// parses the encryption metadata at the start of the given data
+func (e *resourceEncryption) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return NewResourceError(ErrCorruptData, "Missing encryption scheme")
+	}
+	e.scheme = ResourceEncryptionScheme(data[0])
+	if e.scheme != ResourceEncryptionAESGCM {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Unknown encryption scheme %d", e.scheme))
+	} else if len(data) < 1+resourceNonceLength {
+		return NewResourceError(ErrCorruptData, "Encryption nonce too short")
+	}
+	e.nonce = make([]byte, resourceNonceLength)
+	copy(e.nonce, data[1:1+resourceNonceLength])
+	return nil
+}
+
+// SetSecret sets the secret of a resource, which makes a private feed of it.
+//
+// The data of the updates made afterwards is encrypted with a key derived
+// from the resource name and the secret, and lookups decrypt the data of
+// encrypted updates with it. Readers without the secret find the updates,
+// but GetContent returns ErrEncrypted for them. Multihash updates and
+// tombstones are not encrypted. A nil secret removes the secret.
+//
+// Secrets are kept in memory only.
+func (self *ResourceHandler) SetSecret(name string, secret []byte) {
+	nameHash := ResourceNameHash(name).Hex()
+	self.secretLock.Lock()
+	defer self.secretLock.Unlock()
+	if secret == nil {
+		delete(self.secrets, nameHash)
+		return
+	}
+	self.secrets[nameHash] = common.CopyBytes(secret)
+}
+
+// returns the secret of the resource, nil if it has none
+func (self *ResourceHandler) getSecret(nameHash string) []byte {
+	self.secretLock.RLock()
+	defer self.secretLock.RUnlock()
+	return self.secrets[nameHash]
+}
+
+// the AES-GCM cipher of the key derived from the namehash and the secret
+func newResourceCipher(nameHash common.Hash, secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(crypto.Keccak256(nameHash[:], secret))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypts update data with a fresh nonce
+func encryptUpdate(nameHash common.Hash, secret []byte, data []byte) (*resourceEncryption, []byte, error) {
+	aead, err := newResourceCipher(nameHash, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc := &resourceEncryption{
+		scheme: ResourceEncryptionAESGCM,
+		nonce:  make([]byte, resourceNonceLength),
+	}
+	if _, err := rand.Read(enc.nonce); err != nil {
+		return nil, nil, err
+	}
+	resourceEncryptCount.Inc(1)
+	return enc, aead.Seal(nil, enc.nonce, data, nil), nil
+}
+
+// decrypts update data, fails if the secret is wrong
+func decryptUpdate(nameHash common.Hash, secret []byte, enc *resourceEncryption, data []byte) ([]byte, error) {
+	aead, err := newResourceCipher(nameHash, secret)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, enc.nonce, data, nil)
+	if err != nil {
+		resourceDecryptFailCount.Inc(1)
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// returns true if the data of the update chunk is encrypted
+func isEncryptedUpdate(chunkdata []byte) bool {
+	return updateFlags(chunkdata)&resourceEncryptedFlag != 0
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// interval of the ENS queries of WaitRegistered
+const defaultENSPollInterval = 2 * time.Second
+
+// ENSUpdater sets the content records of ENS names, see
+// ResourceHandlerParams.ENSUpdater. It is implemented by ens.ENS.
+type ENSUpdater interface {
+	// SetContentHash submits the transaction setting the content record of
+	// the name
+	SetContentHash(name string, hash common.Hash) (*types.Transaction, error)
+	// Resolve returns the content record of the name
+	Resolve(name string) (common.Hash, error)
+}
+
+// submits the transaction pointing the content record of the name of a new
+// resource to its metadata chunk
+func (self *ResourceHandler) registerName(name string, root Key) error {
+	tx, err := self.ensUpdater.SetContentHash(name, common.BytesToHash(root))
+	if err != nil {
+		return WrapResourceError(ErrIO, fmt.Sprintf("Could not set the content record of '%s'", name), err)
+	}
+	if tx != nil {
+		log.Debug("resource content record", "name", name, "rootkey", root, "tx", tx.Hash())
+	}
+	return nil
+}
+
+// WaitRegistered waits until the content record of the name points to the
+// metadata chunk of the resource, which is once the transaction submitted by
+// NewResource is mined. It queries the ENS updater of the handler until the
+// context is done.
+func (self *ResourceHandler) WaitRegistered(ctx context.Context, name string) error {
+	if self.ensUpdater == nil {
+		return NewResourceError(ErrInit, "Resource handler has no ENS updater")
+	}
+	root, err := self.ResourceRoot(ResourceNameHash(name))
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(self.ensPollInterval)
+	defer ticker.Stop()
+	for {
+		content, err := self.ensUpdater.Resolve(name)
+		if err == nil && content == common.BytesToHash(root) {
+			return nil
+		}
+		log.Trace("resource content record pending", "name", name, "content", content, "err", err)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return WrapResourceError(ErrIO, fmt.Sprintf("Content record of '%s' not set", name), ctx.Err())
+		}
+	}
+}
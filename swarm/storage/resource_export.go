@@ -0,0 +1,195 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// Export writes the chunks of the resource with the namehash to w as a tar
+// archive, which Import reads back into the store of another node. The
+// archive holds the metadata chunk first, then the revocations, the update
+// chunks ordered by period and version and the data of large updates. The
+// entries are named by the chunk keys in hex, like the ones of LDBStore.Export,
+// and hold the chunk data. It returns the number of chunks written.
+//
+// Every period up to the latest update (or the tombstone) is probed in the
+// local store only, so that periods without updates do not each wait for a
+// retrieval from the network. Updates which are not stored locally and
+// updates signed with revoked keys are left out. The resource must have been
+// created or loaded by the handler.
+func (self *ResourceHandler) Export(ctx context.Context, nameHash common.Hash, w io.Writer) (int64, error) {
+	if self.chunkStore == nil {
+		return 0, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before exporting resources")
+	}
+	rsrc, err := self.loadResource(nameHash)
+	if err != nil {
+		return 0, err
+	}
+	root, err := self.ResourceRoot(nameHash)
+	if err != nil {
+		return 0, err
+	}
+	metrics.GetOrRegisterCounter("resource.export", nil).Inc(1)
+
+	// the last period to export
+	var last uint32
+	if latest, err := self.LookupLatest(ctx, nameHash, true, nil); err == nil {
+		last = latest.lastPeriod
+	} else if tombstone := self.getTombstone(nameHash.Hex()); IsGone(err) && tombstone != nil {
+		last = tombstone.period
+	} else if !IsNotFound(err) {
+		return 0, err
+	}
+
+	tw := tar.NewWriter(w)
+	var count int64
+	timeout := self.getRetrieveTimeout(ctx)
+	write := func(key Key) error {
+		chunk, err := self.chunkStore.get(key, timeout)
+		if err != nil {
+			return WrapResourceError(ErrNotFound, fmt.Sprintf("Cannot retrieve chunk %v", key), err)
+		}
+		hdr := &tar.Header{
+			Name: hex.EncodeToString(key),
+			Mode: 0644,
+			Size: int64(len(chunk.SData)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(chunk.SData); err != nil {
+			return err
+		}
+		count++
+		return nil
+	}
+
+	if err := write(root); err != nil {
+		return count, err
+	}
+	for i := range self.Revocations(nameHash) {
+		if err := write(self.resourceHash(0, uint32(i+1), nameHash)); err != nil {
+			return count, err
+		}
+	}
+	for period := uint32(1); period <= last; period++ {
+		for version := uint32(1); ; version++ {
+			if err := ctx.Err(); err != nil {
+				return count, WrapResourceError(ErrIO, "Export aborted", err)
+			}
+			chunk, err := self.chunkStore.getLocal(self.resourceHash(period, version, nameHash))
+			if err != nil {
+				break
+			}
+			if self.isRevokedUpdate(rsrc, chunk) {
+				continue
+			}
+			if err := write(chunk.Key); err != nil {
+				return count, err
+			}
+			if err := self.exportData(chunk, write); err != nil {
+				return count, err
+			}
+		}
+	}
+	log.Debug("resource export", "name", rsrc.name, "periods", last, "chunks", count)
+	return count, tw.Close()
+}
+
+// writes the chunks of the data of a large update
+func (self *ResourceHandler) exportData(chunk *Chunk, write func(Key) error) error {
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return err
+	}
+	if !isDataRefUpdate(chunkdata) || isTombstoneUpdate(chunkdata) {
+		return nil
+	}
+	_, _, _, _, ref, _, err := self.parseUpdate(chunkdata)
+	if err != nil {
+		return err
+	}
+	return self.dpa.Chunks(ref, write)
+}
+
+// Import stores the chunks of a resource archive written by Export and loads
+// the resource from its metadata chunk, the first chunk of the archive. It
+// returns the key of the metadata chunk.
+//
+// The chunks are validated like chunks received from the network, the import
+// fails if any of them is invalid.
+func (self *ResourceHandler) Import(r io.Reader) (Key, error) {
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before importing resources")
+	}
+	metrics.GetOrRegisterCounter("resource.import", nil).Inc(1)
+
+	tr := tar.NewReader(r)
+	var root Key
+	var chunks []*Chunk
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, WrapResourceError(ErrCorruptData, "Invalid resource archive", err)
+		}
+		key, err := hex.DecodeString(hdr.Name)
+		if err != nil || len(key) != self.HashSize {
+			return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Invalid chunk name '%s' in resource archive", hdr.Name))
+		} else if hdr.Size < 8 {
+			return nil, NewResourceError(ErrCorruptData, fmt.Sprintf("Chunk %s in resource archive is too short", hdr.Name))
+		} else if hdr.Size > DefaultChunkSize+8 {
+			return nil, NewResourceError(ErrDataOverflow, fmt.Sprintf("Chunk %s in resource archive exceeds the chunk size", hdr.Name))
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, WrapResourceError(ErrCorruptData, "Invalid resource archive", err)
+		}
+		chunk := NewChunk(Key(key), nil)
+		chunk.SData = data
+		self.chunkStore.Put(chunk)
+		chunks = append(chunks, chunk)
+		if root == nil {
+			root = chunk.Key
+		}
+	}
+	if root == nil {
+		return nil, NewResourceError(ErrNothingToReturn, "Empty resource archive")
+	}
+	for _, chunk := range chunks {
+		if err := chunk.WaitToStore(); err != nil {
+			return nil, WrapResourceError(ErrCorruptData, fmt.Sprintf("Invalid chunk %v in resource archive", chunk.Key), err)
+		}
+	}
+	rsrc, err := self.LoadResource(root)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("resource import", "name", rsrc.name, "rootkey", root, "chunks", len(chunks))
+	return root, nil
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// the clock of fixtures starts at this unix time
+const resourceFixtureEpoch = 1500000000
+
+// ManualChain is a chain of block headers which only grows with
+// ManualBlockAdvance, or with ManualTimeAdvance if it has a block time, so
+// that the current block of block based resources is known to tests at any
+// time. Unlike a simulated backend, querying its headers never advances it.
+//
+// A chain is safe for concurrent use, and can be shared by the handlers of
+// several simulated nodes, which see its blocks at the same time if they
+// follow it, see Follow.
+type ManualChain struct {
+	number    uint64
+	blockTime time.Duration      // time between blocks, blocks are only appended by steps if 0
+	elapsed   time.Duration      // time since the latest block was appended by ManualTimeAdvance
+	followers []*ResourceHandler // handlers whose cached block heights are dropped when the chain grows
+	lock      sync.Mutex
+}
+
+// NewManualChain returns a chain whose latest block has the number
+func NewManualChain(number uint64) *ManualChain {
+	return &ManualChain{
+		number: number,
+	}
+}
+
+// HeaderByNumber implements headerGetter. It returns the latest header if the
+// number is nil, and fails for blocks beyond it.
+func (c *ManualChain) HeaderByNumber(ctx context.Context, name string, number *big.Int) (*types.Header, error) {
+	c.lock.Lock()
+	latest, seconds := c.number, c.blockSeconds()
+	c.lock.Unlock()
+	if number == nil {
+		number = new(big.Int).SetUint64(latest)
+	} else if !number.IsUint64() || number.Uint64() > latest {
+		return nil, fmt.Errorf("block %v not found, latest is %d", number, latest)
+	}
+	return &types.Header{
+		Number: new(big.Int).Set(number),
+		Time:   new(big.Int).SetUint64(resourceFixtureEpoch + number.Uint64()*seconds),
+	}, nil
+}
+
+// SetBlockTime sets the time between the blocks of the chain, so that it
+// grows with ManualTimeAdvance. The timestamps of the headers are spaced by
+// the block time, by a second if it is shorter.
+func (c *ManualChain) SetBlockTime(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.blockTime = d
+	c.elapsed = 0
+}
+
+// ManualTimeAdvance appends the blocks mined in the duration at the block
+// time of the chain and returns the number of the latest block. The time
+// since the latest block carries over to the next advance, so that advancing
+// twice by half the block time appends one block. The chain does not grow
+// if it has no block time.
+func (c *ManualChain) ManualTimeAdvance(d time.Duration) uint64 {
+	c.lock.Lock()
+	var n uint64
+	if c.blockTime > 0 {
+		c.elapsed += d
+		n = uint64(c.elapsed / c.blockTime)
+		c.elapsed %= c.blockTime
+	}
+	c.lock.Unlock()
+	return c.ManualBlockAdvance(n)
+}
+
+// ManualBlockAdvance appends n blocks to the chain and returns the number of
+// the latest block. The handlers following the chain see the new block at
+// once.
+func (c *ManualChain) ManualBlockAdvance(n uint64) uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.number += n
+	for _, rh := range c.followers {
+		rh.forgetRecentBlocks()
+	}
+	return c.number
+}
+
+// Follow drops the cached block heights of the handler whenever the chain
+// grows, so that the handler sees new blocks at once. Handlers of the chain
+// which do not follow it see new blocks when their cached heights expire.
+func (c *ManualChain) Follow(rh *ResourceHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.followers = append(c.followers, rh)
+}
+
+// BlockNumber returns the number of the latest block of the chain
+func (c *ManualChain) BlockNumber() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.number
+}
+
+// seconds between the timestamps of the headers, the caller must hold the
+// lock
+func (c *ManualChain) blockSeconds() uint64 {
+	if seconds := uint64(c.blockTime / time.Second); seconds > 0 {
+		return seconds
+	}
+	return 1
+}
+
+// NewMockResourceSigner returns a signer whose key is derived from the seed,
+// so that the same seed always signs as the same address
+func NewMockResourceSigner(seed string) (*GenericResourceSigner, error) {
+	privKey, err := crypto.ToECDSA(crypto.Keccak256([]byte(seed)))
+	if err != nil {
+		return nil, err
+	}
+	return &GenericResourceSigner{
+		PrivKey: privKey,
+	}, nil
+}
+
+// ResourceFixture is a resource handler on a local store whose chain, clock
+// and signer are controlled by the test, so that the period and version
+// arithmetic of resources can be checked deterministically.
+//
+// The current block of block based resources is the latest block of Chain,
+// and the current time of time based resources only moves with AdvanceTime,
+// which also grows the chain if it has a block time, see
+// ManualChain.SetBlockTime.
+// Handlers added with Attach share the store, the clock and the signer, and
+// may follow chains of their own, e.g. one lagging behind.
+type ResourceFixture struct {
+	Handler *ResourceHandler
+	Chain   *ManualChain
+	Signer  *GenericResourceSigner
+
+	now      time.Time
+	handlers []*ResourceHandler
+	lock     sync.Mutex
+}
+
+// NewResourceFixture creates a fixture storing its chunks in datadir, whose
+// chain starts at the block number. The header getter and signer of the
+// params are replaced by the ones of the fixture, the params may be nil.
+func NewResourceFixture(datadir string, number uint64, params *ResourceHandlerParams) (*ResourceFixture, error) {
+	signer, err := NewMockResourceSigner("resource fixture")
+	if err != nil {
+		return nil, err
+	}
+	f := &ResourceFixture{
+		Chain:  NewManualChain(number),
+		Signer: signer,
+		now:    time.Unix(resourceFixtureEpoch, 0),
+	}
+	var p ResourceHandlerParams
+	if params != nil {
+		p = *params
+	}
+	p.HeaderGetter = f.Chain
+	p.Signer = f.Signer
+	rh, err := NewTestResourceHandler(datadir, &p)
+	if err != nil {
+		return nil, err
+	}
+	rh.now = f.Now
+	f.Chain.Follow(rh)
+	f.Handler = rh
+	f.handlers = append(f.handlers, rh)
+	return f, nil
+}
+
+// Attach returns another handler on the store of the fixture, following the
+// chain, as a second node which received the same chunks would
+func (f *ResourceFixture) Attach(chain *ManualChain) (*ResourceHandler, error) {
+	rh, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: chain,
+		Signer:       f.Signer,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rh.now = f.Now
+	chain.Follow(rh)
+	rh.SetStore(NewNetStore(f.Handler.chunkStore.localStore, nil))
+	f.lock.Lock()
+	f.handlers = append(f.handlers, rh)
+	f.lock.Unlock()
+	return rh, nil
+}
+
+// ManualBlockAdvance appends n blocks to the chain of the fixture and returns
+// the number of the latest block
+func (f *ResourceFixture) ManualBlockAdvance(n uint64) uint64 {
+	return f.Chain.ManualBlockAdvance(n)
+}
+
+// BlockNumber returns the number of the latest block of the chain of the
+// fixture
+func (f *ResourceFixture) BlockNumber() uint64 {
+	return f.Chain.BlockNumber()
+}
+
+// AdvanceTime moves the clock of the handlers forward, and appends the blocks
+// mined meanwhile to the chain of the fixture if it has a block time
+func (f *ResourceFixture) AdvanceTime(d time.Duration) time.Time {
+	f.lock.Lock()
+	f.now = f.now.Add(d)
+	now := f.now
+	f.lock.Unlock()
+	f.Chain.ManualTimeAdvance(d)
+	return now
+}
+
+// Now returns the time of the clock of the handlers
+func (f *ResourceFixture) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// Close closes the handlers of the fixture and its store
+func (f *ResourceFixture) Close() {
+	f.lock.Lock()
+	handlers := f.handlers
+	f.handlers = nil
+	f.lock.Unlock()
+	for _, rh := range handlers {
+		rh.Close()
+	}
+	// the handlers only close their net stores, which leave the local store open
+	if len(handlers) > 0 {
+		handlers[0].chunkStore.localStore.Close()
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// resources with a hash algorithm other than the default are indicated by
+// this flag in the frequency field of the metadata chunk, the algorithm is
+// then given by the byte following the frequency
+const resourceHashFlag = uint64(1) << 61
+
+// ResourceHashAlgorithm is the hash function of the update chunk keys and
+// the signature digests of a resource, declared in its metadata chunk
+type ResourceHashAlgorithm uint8
+
+const (
+	// ResourceHashKeccak256 is the default, used by resources without
+	// a declared hash algorithm
+	ResourceHashKeccak256 ResourceHashAlgorithm = iota
+	ResourceHashSHA256
+	ResourceHashBMT
+	resourceHashAlgorithmCount
+)
+
+// the swarm hash of the algorithms, see MakeHashFunc
+var resourceHashNames = [resourceHashAlgorithmCount]string{
+	ResourceHashKeccak256: SHA3Hash,
+	ResourceHashSHA256:    "SHA256",
+	ResourceHashBMT:       BMTHash,
+}
+
+func (a ResourceHashAlgorithm) String() string {
+	switch a {
+	case ResourceHashKeccak256:
+		return "keccak256"
+	case ResourceHashSHA256:
+		return "sha256"
+	case ResourceHashBMT:
+		return "bmt"
+	}
+	return fmt.Sprintf("ResourceHashAlgorithm(%d)", uint8(a))
+}
+
+// ParseResourceHashAlgorithm returns the hash algorithm named s, as returned
+// by String
+func ParseResourceHashAlgorithm(s string) (ResourceHashAlgorithm, error) {
+	for a := ResourceHashAlgorithm(0); a < resourceHashAlgorithmCount; a++ {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return 0, NewResourceError(ErrInvalidValue, fmt.Sprintf("Unknown hash algorithm '%s'", s))
+}
+
+func (a ResourceHashAlgorithm) valid() bool {
+	return a < resourceHashAlgorithmCount
+}
+
+// Creates a new root entry for a mutable resource like NewResourceWithScheme,
+// with update chunk keys and signature digests made with the given hash
+// algorithm.
+func (self *ResourceHandler) NewResourceWithHash(ctx context.Context, name string, frequency uint64, scheme ResourcePeriodScheme, hash ResourceHashAlgorithm) (Key, *resource, error) {
+	if !hash.valid() {
+		return nil, nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid hash algorithm %v", hash))
+	}
+	return self.newResource(ctx, name, frequency, scheme, hash, nil)
+}
+
+// HashAlgorithm returns the hash algorithm of the update chunk keys and the
+// signature digests of the resource
+func (self *resource) HashAlgorithm() ResourceHashAlgorithm {
+	return self.hash
+}
+
+func newResourceHashPools() map[ResourceHashAlgorithm]*sync.Pool {
+	pools := make(map[ResourceHashAlgorithm]*sync.Pool)
+	for a, name := range resourceHashNames {
+		hashfunc := MakeHashFunc(name)
+		pools[ResourceHashAlgorithm(a)] = &sync.Pool{
+			New: func() interface{} {
+				return hashfunc()
+			},
+		}
+	}
+	return pools
+}
+
+// the hash algorithm of the resource with the given nameHash, the default
+// if the resource is not loaded
+func (self *ResourceHandler) hashAlgorithm(nameHash common.Hash) ResourceHashAlgorithm {
+	if rsrc := self.getResource(nameHash.Hex()); rsrc != nil {
+		return rsrc.hash
+	}
+	return ResourceHashKeccak256
+}
+
+// the hash algorithms the chunks of the resource with the given nameHash may
+// have been made with. Chunks of resources which are not loaded are
+// validated with any of the algorithms.
+func (self *ResourceHandler) hashAlgorithms(nameHash common.Hash) []ResourceHashAlgorithm {
+	if rsrc := self.getResource(nameHash.Hex()); rsrc != nil {
+		return []ResourceHashAlgorithm{rsrc.hash}
+	}
+	algorithms := make([]ResourceHashAlgorithm, resourceHashAlgorithmCount)
+	for i := range algorithms {
+		algorithms[i] = ResourceHashAlgorithm(i)
+	}
+	return algorithms
+}
+
+// returns a hasher of the algorithm reset for hashing length bytes, to be
+// returned with putHasher. BMT hashes cover the length like the hashes of
+// content chunks, the other algorithms hash the data only.
+func (self *ResourceHandler) getHasher(hash ResourceHashAlgorithm, length int) SwarmHash {
+	hasher := self.hashPools[hash].Get().(SwarmHash)
+	if hash == ResourceHashBMT {
+		span := make([]byte, 8)
+		binary.LittleEndian.PutUint64(span, uint64(length))
+		hasher.ResetWithLength(span)
+	} else {
+		hasher.Reset()
+	}
+	return hasher
+}
+
+func (self *ResourceHandler) putHasher(hash ResourceHashAlgorithm, hasher SwarmHash) {
+	self.hashPools[hash].Put(hasher)
+}
+
+// Create a new update chunk key with the given hash algorithm
+// format is: hash(period|version|namehash)
+func (self *ResourceHandler) resourceHashWith(hash ResourceHashAlgorithm, period uint32, version uint32, namehash common.Hash) Key {
+	hasher := self.getHasher(hash, 8+len(namehash))
+	defer self.putHasher(hash, hasher)
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, period)
+	hasher.Write(b)
+	binary.LittleEndian.PutUint32(b, version)
+	hasher.Write(b)
+	hasher.Write(namehash[:])
+	return hasher.Sum(nil)
+}
+
+// Create the resource update digest used in signatures with the given hash
+// algorithm
+func (self *ResourceHandler) keyDataHashWith(hash ResourceHashAlgorithm, key Key, modTime uint64, data []byte) common.Hash {
+	hasher := self.getHasher(hash, len(key)+8+len(data))
+	defer self.putHasher(hash, hasher)
+	hasher.Write(key[:])
+	modTimeBytes := make([]byte, 8)
+	binary.LittleEndian.PutUint64(modTimeBytes, modTime)
+	hasher.Write(modTimeBytes)
+	hasher.Write(data)
+	return common.BytesToHash(hasher.Sum(nil))
+}
+
+// Create the signature digest of the update with the given header. Updates
+// of the baseline layout are signed without modification time, see
+// baselineUpdateDigest.
+func (self *ResourceHandler) updateDigest(hash ResourceHashAlgorithm, key Key, header *resourceUpdateHeader, data []byte) common.Hash {
+	if header.baseline {
+		return baselineUpdateDigest(key, data)
+	}
+	return self.keyDataHashWith(hash, key, header.modTime, data)
+}
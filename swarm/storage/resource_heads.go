@@ -0,0 +1,176 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	headRetryInterval = 30 * time.Second // wait before subscribing again after the head subscription failed
+	headBuffer        = 16
+)
+
+// headSubscriber notifies the new heads of the chain, like ethclient.Client
+type headSubscriber interface {
+	SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error)
+}
+
+// the current period of a watched resource at the head of the chain
+type headPeriod struct {
+	period uint32
+	since  uint64 // block at which the period started being the current one
+}
+
+// follows the heads of the chain until the handler is closed, subscribing
+// again whenever the subscription fails
+//
+// While the subscription is up, the latest head is the current block of all
+// block based resources, so that lookups need no header retrieval, and the
+// current periods of the watched resources are known in advance, see
+// advanceHead.
+func (self *ResourceHandler) followHeads(quitC chan struct{}) {
+	heads := make(chan *types.Header, headBuffer)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), headRetryInterval)
+		sub, err := self.headSubscriber.SubscribeNewHead(ctx, heads)
+		cancel()
+		if err == nil {
+			log.Debug("resource: following chain heads")
+			err = self.readHeads(heads, sub, quitC)
+			sub.Unsubscribe()
+			// the heads of a failed subscription are outdated
+			self.setHead(nil)
+		}
+		if err == nil {
+			return
+		}
+		metrics.GetOrRegisterCounter("resource.heads.fail", nil).Inc(1)
+		log.Debug("resource: chain head subscription failed", "retry", headRetryInterval, "err", err)
+		select {
+		case <-time.After(headRetryInterval):
+		case <-quitC:
+			return
+		}
+	}
+}
+
+// reads the heads of the subscription until it fails, or until the handler
+// is closed, which returns nil
+func (self *ResourceHandler) readHeads(heads chan *types.Header, sub ethereum.Subscription, quitC chan struct{}) error {
+	for {
+		select {
+		case header := <-heads:
+			if header != nil && header.Number != nil {
+				self.advanceHead(header.Number.Uint64())
+			}
+		case err := <-sub.Err():
+			return err
+		case <-quitC:
+			return nil
+		}
+	}
+}
+
+// sets the latest head, nil if it is unknown
+func (self *ResourceHandler) setHead(number *uint64) {
+	self.headLock.Lock()
+	defer self.headLock.Unlock()
+	self.head = number
+	if number == nil {
+		self.headPeriods = make(map[string]headPeriod)
+	}
+}
+
+// returns the latest head, false if it is unknown
+func (self *ResourceHandler) getHead() (uint64, bool) {
+	self.headLock.RLock()
+	defer self.headLock.RUnlock()
+	if self.head == nil {
+		return 0, false
+	}
+	return *self.head, true
+}
+
+// records the new head, and computes the current periods of the watched
+// resources at the head. The subscriptions of resources entering a new period
+// with the head or the previous one look up the latest update right away,
+// rather than at their next poll, as updates are scheduled at the start of
+// periods.
+func (self *ResourceHandler) advanceHead(number uint64) {
+	if head, ok := self.getHead(); ok && number < head {
+		// reorgs to shorter chains are left to the next head
+		return
+	}
+	self.setHead(&number)
+	metrics.GetOrRegisterGauge("resource.heads.number", nil).Update(int64(number))
+
+	self.watchLock.Lock()
+	watches := make([]*resourceWatch, 0, len(self.watches))
+	for _, w := range self.watches {
+		watches = append(watches, w)
+	}
+	self.watchLock.Unlock()
+
+	for _, w := range watches {
+		nameHash := w.nameHash.Hex()
+		rsrc := self.getResource(nameHash)
+		if rsrc == nil || rsrc.scheme != BlockPeriods {
+			continue
+		}
+		period, err := self.getPeriod(rsrc, number)
+		if err != nil {
+			continue
+		}
+		self.headLock.Lock()
+		current, ok := self.headPeriods[nameHash]
+		if !ok || current.period != period {
+			current = headPeriod{
+				period: period,
+				since:  number,
+			}
+			self.headPeriods[nameHash] = current
+		}
+		self.headLock.Unlock()
+		if ok && number <= current.since+1 {
+			w.wake()
+		}
+	}
+}
+
+// returns the current period of the resource at the latest head, false if it
+// is not known in advance
+func (self *ResourceHandler) getHeadPeriod(rsrc *resource) (uint32, bool) {
+	self.headLock.RLock()
+	defer self.headLock.RUnlock()
+	current, ok := self.headPeriods[rsrc.nameHash.Hex()]
+	return current.period, ok
+}
+
+// forgets the current period of the resource at the latest head, after its
+// periods changed
+func (self *ResourceHandler) resetHeadPeriod(nameHash string) {
+	self.headLock.Lock()
+	defer self.headLock.Unlock()
+	delete(self.headPeriods, nameHash)
+}
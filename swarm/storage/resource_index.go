@@ -0,0 +1,98 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/ethereum/go-ethereum/metrics"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+var (
+	resourceIndexEvictCount  = metrics.NewRegisteredCounter("resource.index.evict", nil)
+	resourceIndexExpireCount = metrics.NewRegisteredCounter("resource.index.expire", nil)
+)
+
+// Retrieves the resource index value for the given nameHash
+//
+// Resources which were not stored in the index within its TTL are dropped,
+// so that they are loaded and synced again.
+func (self *ResourceHandler) getResource(nameHash string) *resource {
+	resources, lock := self.indexOf(nameHash)
+	lock.RLock()
+	v, ok := resources.Get(nameHash)
+	lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	rsrc := v.(*resource)
+	if self.indexTTL > 0 && self.now().Sub(rsrc.indexed) > self.indexTTL {
+		lock.Lock()
+		defer lock.Unlock()
+		// the resource may have been stored again meanwhile
+		if v, ok := resources.Peek(nameHash); ok && v.(*resource) == rsrc {
+			resources.Remove(nameHash)
+			resourceIndexExpireCount.Inc(1)
+		}
+		return nil
+	}
+	return rsrc
+}
+
+// Sets the resource index value for the given nameHash
+//
+// The least recently used resource is evicted if the index is full, or the
+// index of its shard, see SetShards.
+func (self *ResourceHandler) setResource(nameHash string, rsrc *resource) {
+	resources, lock := self.indexOf(nameHash)
+	lock.Lock()
+	defer lock.Unlock()
+	rsrc.indexed = self.now()
+	if resources.Add(nameHash, rsrc) {
+		resourceIndexEvictCount.Inc(1)
+	}
+}
+
+// returns an index of resources which forgets the state derived from the
+// chunks of the resources dropped from it, see forgetResource
+func (self *ResourceHandler) newIndex(size int) (*lru.Cache, error) {
+	return lru.NewWithEvict(size, func(key interface{}, _ interface{}) {
+		self.forgetResource(key.(string))
+	})
+}
+
+// forgets the tombstone, the revocations, the anchors and the head period of
+// a resource dropped from the index, so that the handler keeps them for the
+// resources of the index only. They are retrieved again when the resource is
+// loaded and looked up. The secrets, signers, refreshes and watches of
+// resources are set explicitly and kept until they are unset.
+//
+// Caller must hold the lock of the index of the resource.
+func (self *ResourceHandler) forgetResource(nameHash string) {
+	self.tombstoneLock.Lock()
+	delete(self.tombstones, nameHash)
+	self.tombstoneLock.Unlock()
+
+	self.revocationLock.Lock()
+	delete(self.revocations, nameHash)
+	self.revocationLock.Unlock()
+
+	self.anchorLock.Lock()
+	delete(self.anchors, nameHash)
+	self.anchorLock.Unlock()
+
+	self.resetHeadPeriod(nameHash)
+}
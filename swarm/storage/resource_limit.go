@@ -0,0 +1,219 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// names and signers counted by the rate limit of update chunks
+const defaultMaxRateTracked = 10000
+
+var (
+	resourceValidateDeniedCount  = metrics.NewRegisteredCounter("resource.validate.denied", nil)
+	resourceValidateLimitedCount = metrics.NewRegisteredCounter("resource.validate.ratelimited", nil)
+)
+
+// ResourceUpdateFilter decides which update chunks Validate accepts, see
+// ResourceHandlerParams.UpdateFilter. Unsigned updates are only filtered by
+// their name.
+type ResourceUpdateFilter interface {
+	// AllowName returns false if the updates of the resource with the name
+	// are rejected
+	AllowName(name string) bool
+	// AllowSigner returns false if the updates signed by the address are
+	// rejected
+	AllowSigner(addr common.Address) bool
+}
+
+// ResourceListFilter is a ResourceUpdateFilter of listed names and signers.
+// Names and signers are rejected if they are denied, or if there are allowed
+// ones and they are not among them.
+type ResourceListFilter struct {
+	AllowedNames   map[string]bool
+	DeniedNames    map[string]bool
+	AllowedSigners map[common.Address]bool
+	DeniedSigners  map[common.Address]bool
+}
+
+func (self *ResourceListFilter) AllowName(name string) bool {
+	if self.DeniedNames[name] {
+		return false
+	}
+	return len(self.AllowedNames) == 0 || self.AllowedNames[name]
+}
+
+func (self *ResourceListFilter) AllowSigner(addr common.Address) bool {
+	if self.DeniedSigners[addr] {
+		return false
+	}
+	return len(self.AllowedSigners) == 0 || self.AllowedSigners[addr]
+}
+
+// ResourceRateLimit bounds the update chunks Validate accepts per name, per
+// signer and in total in each interval, see
+// ResourceHandlerParams.UpdateRateLimit.
+//
+// Unsigned updates share a single signer, so that anonymous writers cannot
+// exceed the signer limit together. Each update chunk is counted once, chunks
+// validated again, as when they are synced from several peers, do not count
+// against the limits. The total limit bounds updates spread across many names
+// and signers.
+//
+// The updates published by the node itself are validated as well, so they
+// count against the limits too.
+type ResourceRateLimit struct {
+	Interval   time.Duration // length of the intervals the updates are counted in
+	PerName    int           // updates accepted per resource name in an interval, unlimited if 0
+	PerSigner  int           // updates accepted per signer in an interval, unlimited if 0
+	Total      int           // updates accepted in an interval, unlimited if 0
+	MaxTracked int           // names, signers and chunks counted at most, the least recently seen are forgotten, default if 0
+}
+
+// update chunks accepted in the current interval of a name or signer
+type resourceRateWindow struct {
+	start time.Time
+	count int
+}
+
+// rate limit state of the handler
+type resourceRateLimiter struct {
+	limit   ResourceRateLimit
+	names   *lru.Cache // *resourceRateWindow by namehash
+	signers *lru.Cache // *resourceRateWindow by address, zero for unsigned updates
+	counted *lru.Cache // keys of the counted update chunks
+	total   resourceRateWindow
+	lock    sync.Mutex
+}
+
+func newResourceRateLimiter(limit *ResourceRateLimit) (*resourceRateLimiter, error) {
+	if limit.PerName < 0 || limit.PerSigner < 0 || limit.Total < 0 {
+		return nil, NewResourceError(ErrInvalidValue, "Rate limits cannot be negative")
+	} else if limit.Interval <= 0 && (limit.PerName > 0 || limit.PerSigner > 0 || limit.Total > 0) {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid rate limit interval %v", limit.Interval))
+	}
+	maxTracked := limit.MaxTracked
+	if maxTracked == 0 {
+		maxTracked = defaultMaxRateTracked
+	}
+	names, err := lru.New(maxTracked)
+	if err != nil {
+		return nil, WrapResourceError(ErrInvalidValue, fmt.Sprintf("Invalid MaxTracked %d", maxTracked), err)
+	}
+	signers, _ := lru.New(maxTracked)
+	counted, _ := lru.New(maxTracked)
+	return &resourceRateLimiter{
+		limit:   *limit,
+		names:   names,
+		signers: signers,
+		counted: counted,
+	}, nil
+}
+
+// returns the window of the key in the cache, restarted if its interval is over
+func (self *resourceRateLimiter) window(cache *lru.Cache, key interface{}, now time.Time) *resourceRateWindow {
+	if v, ok := cache.Get(key); ok {
+		return self.restart(v.(*resourceRateWindow), now)
+	}
+	w := &resourceRateWindow{start: now}
+	cache.Add(key, w)
+	return w
+}
+
+// restarts the window if its interval is over
+func (self *resourceRateLimiter) restart(w *resourceRateWindow, now time.Time) *resourceRateWindow {
+	if now.Sub(w.start) >= self.limit.Interval {
+		w.start, w.count = now, 0
+	}
+	return w
+}
+
+// counts the update chunk with the key of the resource with the namehash and
+// the signer, zero if unsigned, and returns false if it exceeds a limit.
+// Updates exceeding a limit are not counted, chunks counted before pass.
+func (self *resourceRateLimiter) allow(key Key, nameHash common.Hash, signer common.Address, now time.Time) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.counted.Contains(string(key)) {
+		return true
+	}
+	var windows []*resourceRateWindow
+	if self.limit.Total > 0 {
+		w := self.restart(&self.total, now)
+		if w.count >= self.limit.Total {
+			return false
+		}
+		windows = append(windows, w)
+	}
+	if self.limit.PerName > 0 {
+		w := self.window(self.names, nameHash, now)
+		if w.count >= self.limit.PerName {
+			return false
+		}
+		windows = append(windows, w)
+	}
+	if self.limit.PerSigner > 0 {
+		w := self.window(self.signers, signer, now)
+		if w.count >= self.limit.PerSigner {
+			return false
+		}
+		windows = append(windows, w)
+	}
+	for _, w := range windows {
+		w.count++
+	}
+	self.counted.Add(string(key), struct{}{})
+	return true
+}
+
+// checks the name of an update chunk with the update filter
+func (self *ResourceHandler) filterName(name string) bool {
+	if self.updateFilter == nil || self.updateFilter.AllowName(name) {
+		return true
+	}
+	resourceValidateDeniedCount.Inc(1)
+	log.Debug("Resource update of denied name", "name", name)
+	return false
+}
+
+// checks the signer of an update chunk with the update filter
+func (self *ResourceHandler) filterSigner(name string, addr common.Address) bool {
+	if self.updateFilter == nil || self.updateFilter.AllowSigner(addr) {
+		return true
+	}
+	resourceValidateDeniedCount.Inc(1)
+	log.Debug("Resource update of denied signer", "name", name, "address", addr)
+	return false
+}
+
+// counts a valid update chunk against the rate limits, and returns false if
+// it exceeds one
+func (self *ResourceHandler) checkRate(key Key, name string, nameHash common.Hash, addr common.Address) bool {
+	if self.rateLimiter == nil || self.rateLimiter.allow(key, nameHash, addr, self.now()) {
+		return true
+	}
+	resourceValidateLimitedCount.Inc(1)
+	log.Debug("Resource update rate limited", "name", name, "address", addr)
+	return false
+}
@@ -0,0 +1,357 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// ResourceLookupStrategy searches the latest period with an update, trading
+// the latency of the lookup against the number of chunks retrieved.
+//
+// Strategies are selected per lookup with ResourceLookupParams, new ones can
+// be added without changing the lookup methods.
+type ResourceLookupStrategy interface {
+	// Search returns the latest period at or before query.Period with an
+	// update, and the chunk of the update
+	Search(query *ResourcePeriodQuery) (uint32, *Chunk, error)
+	String() string
+}
+
+// ResourcePeriodQuery is the search of a ResourceLookupStrategy
+type ResourcePeriodQuery struct {
+	Period  uint32 // latest period to consider
+	Hint    uint32 // period of the last update known, 0 if none
+	MaxHops uint32 // periods walked back from Period at most, unlimited if 0
+
+	// Probe retrieves the update of a period, nil if there is none. It is
+	// safe for concurrent use.
+	Probe func(period uint32) *Chunk
+}
+
+// returns true if hops periods probed exceed the max hops
+func (q *ResourcePeriodQuery) exceeded(hops uint32) bool {
+	return q.MaxHops > 0 && hops > q.MaxHops
+}
+
+func (q *ResourcePeriodQuery) errPeriodDepth() error {
+	return NewResourceError(ErrPeriodDepth, fmt.Sprintf("Lookup exceeded max period hops (%d)", q.MaxHops))
+}
+
+// LinearLookup probes the periods back one by one. It retrieves the fewest
+// chunks when the latest update is recent.
+type LinearLookup struct{}
+
+func (LinearLookup) Search(query *ResourcePeriodQuery) (uint32, *Chunk, error) {
+	return ParallelLookup{FanOut: 1}.Search(query)
+}
+
+func (LinearLookup) String() string {
+	return "linear"
+}
+
+// ParallelLookup walks the periods back like LinearLookup, probing FanOut
+// periods concurrently, or the default number if 0. The latest update found
+// is the same as with LinearLookup, but the retrieval timeouts of the periods
+// without updates overlap.
+type ParallelLookup struct {
+	FanOut int
+}
+
+// walks the periods back, and returns the latest period found as soon as all
+// later periods missed
+func (s ParallelLookup) Search(query *ResourcePeriodQuery) (uint32, *Chunk, error) {
+	fanOut := uint32(defaultLookupFanOut)
+	if s.FanOut > 0 {
+		fanOut = uint32(s.FanOut)
+	}
+	period := query.Period
+	var hops uint32
+	for period > 0 {
+		if query.exceeded(hops) {
+			return 0, nil, query.errPeriodDepth()
+		}
+		n := fanOut
+		if n > period {
+			n = period
+		}
+		if query.MaxHops > 0 && n > query.MaxHops+1-hops {
+			n = query.MaxHops + 1 - hops
+		}
+		results := make([]chan *Chunk, n)
+		for i := uint32(0); i < n; i++ {
+			results[i] = make(chan *Chunk, 1)
+			go func(period uint32, c chan *Chunk) {
+				c <- query.Probe(period)
+			}(period-i, results[i])
+		}
+		for i := uint32(0); i < n; i++ {
+			if chunk := <-results[i]; chunk != nil {
+				return period - i, chunk, nil
+			}
+		}
+		period -= n
+		hops += n
+	}
+	return 0, nil, NewResourceError(ErrNotFound, "no updates found")
+}
+
+func (s ParallelLookup) String() string {
+	return "parallel/" + strconv.Itoa(s.FanOut)
+}
+
+// BinaryLookup searches the periods with exponentially growing steps back
+// from the current period followed by a binary search, converging in
+// O(log n) retrievals instead of O(n). The search assumes that the resource
+// was updated in every period between the update it first hits and the latest
+// one. The assumption is checked by probing the middle of the periods skipped
+// above the update found, and against the hint: if it does not hold, the
+// periods are probed back one by one like LinearLookup. A sparsely updated
+// resource may still resolve to an earlier update than the latest if all
+// probes miss its later updates.
+type BinaryLookup struct{}
+
+// Periods are probed back from the period with doubling steps until an
+// update is found, then the periods between the update and the last miss are
+// bisected. If the steps skip all updates, the skipped periods are searched
+// with increasing resolution.
+func (BinaryLookup) Search(query *ResourcePeriodQuery) (uint32, *Chunk, error) {
+	var hops uint32
+	probed := make(map[uint32]*Chunk)
+	probe := func(period uint32) (*Chunk, error) {
+		if chunk, ok := probed[period]; ok {
+			return chunk, nil
+		}
+		if query.exceeded(hops) {
+			return nil, query.errPeriodDepth()
+		}
+		hops++
+		chunk := query.Probe(period)
+		probed[period] = chunk
+		return chunk, nil
+	}
+
+	// gallop back until an update is found
+	var found *Chunk
+	period := query.Period
+	missed := period + 1
+	step := uint32(1)
+	for {
+		chunk, err := probe(period)
+		if err != nil {
+			return 0, nil, err
+		}
+		if chunk != nil {
+			found = chunk
+			break
+		}
+		if period == 1 {
+			break
+		}
+		missed = period
+		if step >= period {
+			period = 1
+		} else {
+			period -= step
+			if step <= math.MaxUint32/2 {
+				step *= 2
+			}
+		}
+	}
+
+	// the gallop skipped all updates, search the periods between period 1
+	// and the last miss level by level, higher periods first
+	if found == nil {
+		gaps := [][2]uint32{{1, missed}}
+		for found == nil && len(gaps) > 0 {
+			var next [][2]uint32
+			for _, gap := range gaps {
+				if gap[1]-gap[0] < 2 {
+					continue
+				}
+				mid := gap[0] + (gap[1]-gap[0])/2
+				chunk, err := probe(mid)
+				if err != nil {
+					return 0, nil, err
+				}
+				if chunk != nil {
+					period, missed, found = mid, gap[1], chunk
+					break
+				}
+				next = append(next, [2]uint32{mid, gap[1]}, [2]uint32{gap[0], mid})
+			}
+			gaps = next
+		}
+		if found == nil {
+			return 0, nil, NewResourceError(ErrNotFound, "no updates found")
+		}
+	}
+
+	// bisect between the update found and the last miss
+	for missed-period > 1 {
+		mid := period + (missed-period)/2
+		chunk, err := probe(mid)
+		if err != nil {
+			return 0, nil, err
+		}
+		if chunk != nil {
+			period, found = mid, chunk
+		} else {
+			missed = mid
+		}
+	}
+
+	monotonic, err := binaryMonotonic(query, period, probed, probe)
+	if err != nil {
+		return 0, nil, err
+	} else if !monotonic {
+		metrics.GetOrRegisterCounter("resource.lookup.binary.fallback", nil).Inc(1)
+		linear := *query
+		linear.Probe = func(period uint32) *Chunk {
+			if chunk, ok := probed[period]; ok {
+				return chunk
+			}
+			return query.Probe(period)
+		}
+		return LinearLookup{}.Search(&linear)
+	}
+	return period, found, nil
+}
+
+// checks that the periods after the update of the period found by the binary
+// search have no updates: the hinted period must not be later, and the
+// middle periods of the ranges skipped between the missed probes must miss
+// as well
+func binaryMonotonic(query *ResourcePeriodQuery, period uint32, probed map[uint32]*Chunk, probe func(uint32) (*Chunk, error)) (bool, error) {
+	if query.Hint > period && query.Hint <= query.Period {
+		return false, nil
+	}
+	misses := []uint32{period}
+	for p := range probed {
+		if p > period {
+			misses = append(misses, p)
+		}
+	}
+	sort.Slice(misses, func(i, j int) bool { return misses[i] < misses[j] })
+	for i := 1; i < len(misses); i++ {
+		if misses[i]-misses[i-1] < 2 {
+			continue
+		}
+		chunk, err := probe(misses[i-1] + (misses[i]-misses[i-1])/2)
+		if err != nil {
+			return false, err
+		} else if chunk != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (BinaryLookup) String() string {
+	return "binary"
+}
+
+// HintFirstLookup probes the period of the last update known first. If it
+// has the update, only the later periods are searched with the Fallback
+// strategy, otherwise all periods are. The Fallback is ParallelLookup if nil.
+//
+// Resources polled for new updates mostly stay at the hinted period, which
+// then costs a single retrieval more than the fallback alone.
+type HintFirstLookup struct {
+	Fallback ResourceLookupStrategy
+}
+
+func (s HintFirstLookup) Search(query *ResourcePeriodQuery) (uint32, *Chunk, error) {
+	fallback := s.Fallback
+	if fallback == nil {
+		fallback = ParallelLookup{}
+	}
+	if query.Hint == 0 || query.Hint > query.Period {
+		return fallback.Search(query)
+	}
+	hinted := query.Probe(query.Hint)
+	if hinted == nil {
+		return fallback.Search(query)
+	} else if query.Hint == query.Period {
+		return query.Period, hinted, nil
+	}
+
+	// the periods up to the hint resolve to the hinted update
+	after := *query
+	after.Probe = func(period uint32) *Chunk {
+		if period <= query.Hint {
+			return hinted
+		}
+		return query.Probe(period)
+	}
+	period, chunk, err := fallback.Search(&after)
+	if err != nil {
+		return 0, nil, err
+	} else if chunk == hinted {
+		period = query.Hint
+	}
+	return period, chunk, nil
+}
+
+func (s HintFirstLookup) String() string {
+	if s.Fallback == nil {
+		return "hint"
+	}
+	return "hint/" + s.Fallback.String()
+}
+
+// lookupHistogram returns the histogram of the periods ("hops") or the
+// versions ("versions") probed by the lookups with the strategy, named like
+// resource.lookup.hops.parallel. The fan-out is left out of the name, so that
+// clients choosing it cannot register any number of histograms.
+func lookupHistogram(kind string, strategy ResourceLookupStrategy) metrics.Histogram {
+	var parts []string
+	for _, part := range strings.Split(strategy.String(), "/") {
+		if _, err := strconv.Atoi(part); err != nil {
+			parts = append(parts, part)
+		}
+	}
+	name := fmt.Sprintf("resource.lookup.%s.%s", kind, strings.Join(parts, "."))
+	return metrics.GetOrRegisterHistogram(name, nil, metrics.NewExpDecaySample(1028, 0.015))
+}
+
+// ParseResourceLookupStrategy returns the lookup strategy of the given name,
+// one of linear, binary, parallel and hint. fanOut is the fan-out of the
+// parallel strategy, which is also the fallback of the hint strategy, the
+// default if 0.
+func ParseResourceLookupStrategy(name string, fanOut int) (ResourceLookupStrategy, error) {
+	if fanOut < 0 {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Invalid lookup fan-out %d", fanOut))
+	}
+	switch name {
+	case "linear":
+		return LinearLookup{}, nil
+	case "binary":
+		return BinaryLookup{}, nil
+	case "parallel":
+		return ParallelLookup{FanOut: fanOut}, nil
+	case "hint":
+		return HintFirstLookup{Fallback: ParallelLookup{FanOut: fanOut}}, nil
+	}
+	return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Unknown lookup strategy '%s'", name))
+}
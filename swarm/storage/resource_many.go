@@ -0,0 +1,124 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"path"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// resources looked up concurrently by LookupLatestMany
+const lookupManyWorkers = 16
+
+// ResourceResult is the outcome of the lookup of a resource by
+// LookupLatestMany
+type ResourceResult struct {
+	Resource *ResourceView // the latest update, nil if the lookup failed
+	Err      error
+}
+
+// LookupLatestMany looks up the latest updates of the resources with the
+// namehashes, like LookupLatest with refresh, and returns the results by
+// namehash. The resources must have been created or loaded by the handler.
+//
+// The lookups run concurrently on a pool of workers. Each resource is looked
+// up once even if its namehash is given more than once, and the block height
+// of block based resources is retrieved once for all names of the same top
+// level domain, which are resolved by the same chain.
+func (self *ResourceHandler) LookupLatestMany(ctx context.Context, nameHashes []common.Hash) map[common.Hash]*ResourceResult {
+	results := make(map[common.Hash]*ResourceResult, len(nameHashes))
+	var jobs []common.Hash
+	for _, nameHash := range nameHashes {
+		if _, ok := results[nameHash]; ok {
+			continue
+		}
+		results[nameHash] = &ResourceResult{}
+		jobs = append(jobs, nameHash)
+	}
+	metrics.GetOrRegisterCounter("resource.lookupmany", nil).Inc(1)
+	metrics.GetOrRegisterCounter("resource.lookupmany.resources", nil).Inc(int64(len(jobs)))
+
+	heights := &resourceHeights{
+		rh:      self,
+		heights: make(map[string]*resourceHeight),
+	}
+	workers := lookupManyWorkers
+	if len(jobs) < workers {
+		workers = len(jobs)
+	}
+	jobC := make(chan common.Hash)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the results are only read until all workers are done
+			for nameHash := range jobC {
+				result := results[nameHash]
+				result.Resource, result.Err = self.lookupLatest(ctx, nameHash, true, nil, heights.current)
+			}
+		}()
+	}
+	for _, nameHash := range jobs {
+		jobC <- nameHash
+	}
+	close(jobC)
+	wg.Wait()
+	return results
+}
+
+// the block heights shared by the lookups of LookupLatestMany, by top level
+// domain
+type resourceHeights struct {
+	rh      *ResourceHandler
+	heights map[string]*resourceHeight
+	lock    sync.Mutex
+}
+
+// a block height retrieved once
+type resourceHeight struct {
+	once   sync.Once
+	number uint64
+	err    error
+}
+
+// like ResourceHandler.getCurrent, but retrieves the block height once for
+// the names of a top level domain
+func (h *resourceHeights) current(ctx context.Context, rsrc *resource) (uint64, error) {
+	if rsrc.scheme == TimePeriods {
+		return h.rh.getCurrent(ctx, rsrc)
+	}
+	if head, ok := h.rh.getHead(); ok {
+		return head, nil
+	}
+	tld := path.Ext(rsrc.name)
+	h.lock.Lock()
+	height, ok := h.heights[tld]
+	if !ok {
+		height = &resourceHeight{}
+		h.heights[tld] = height
+	}
+	h.lock.Unlock()
+	height.once.Do(func() {
+		height.number, height.err = h.rh.getCurrent(ctx, rsrc)
+	})
+	return height.number, height.err
+}
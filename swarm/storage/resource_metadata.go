@@ -0,0 +1,165 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// version of the header of update chunks, following the period and the version
+	resourceHeaderVersion = 1
+
+	// version of the header inserted in the update chunks of the baseline
+	// layout, which are signed without modification time, see decodeBaselineUpdate
+	resourceHeaderVersionBaseline = 0
+
+	// length of the header version, the modification time and the signer address
+	resourceHeaderMetaLength = 1 + 8 + common.AddressLength
+)
+
+// ResourceMetadata is the provenance of the update loaded in a resource
+type ResourceMetadata struct {
+	Name    string
+	Period  uint32
+	Version uint32
+	ModTime time.Time      // creation time of the update, as claimed by its signer
+	Signer  common.Address // zero if the update is not signed
+}
+
+// the versioned header of update chunks, between the version and the name
+type resourceUpdateHeader struct {
+	modTime    uint64              // creation time in unix seconds
+	signer     common.Address      // zero if the update is not signed
+	encryption *resourceEncryption // nil if the data is not encrypted
+	baseline   bool                // converted from the baseline layout
+}
+
+// length of the header in the update chunk
+func (h *resourceUpdateHeader) length() int {
+	if h.encryption == nil {
+		return resourceHeaderMetaLength
+	}
+	return resourceHeaderMetaLength + h.encryption.headerLength()
+}
+
+func (h *resourceUpdateHeader) MarshalBinary() ([]byte, error) {
+	data := make([]byte, h.length())
+	data[0] = resourceHeaderVersion
+	if h.baseline {
+		data[0] = resourceHeaderVersionBaseline
+	}
+	binary.LittleEndian.PutUint64(data[1:], h.modTime)
+	copy(data[9:], h.signer[:])
+	if h.encryption != nil {
+		encdata, err := h.encryption.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		copy(data[resourceHeaderMetaLength:], encdata)
+	}
+	return data, nil
+}
+
+// parses the header at the start of the given data, encrypted tells if the
+// header contains encryption metadata
+func (h *resourceUpdateHeader) unmarshalBinary(data []byte, encrypted bool) error {
+	if len(data) < resourceHeaderMetaLength {
+		return NewResourceError(ErrCorruptData, "Update header too short")
+	} else if data[0] != resourceHeaderVersion && data[0] != resourceHeaderVersionBaseline {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Unknown update header version %d", data[0]))
+	}
+	h.baseline = data[0] == resourceHeaderVersionBaseline
+	h.modTime = binary.LittleEndian.Uint64(data[1:])
+	copy(h.signer[:], data[9:resourceHeaderMetaLength])
+	h.encryption = nil
+	if encrypted {
+		h.encryption = &resourceEncryption{}
+		if err := h.encryption.UnmarshalBinary(data[resourceHeaderMetaLength:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parses the header of an update chunk, which follows the length fields,
+// the period and the version
+func parseUpdateHeader(chunkdata []byte) (*resourceUpdateHeader, error) {
+	if len(chunkdata) < resourceUpdateHeaderOffset {
+		return nil, NewResourceError(ErrCorruptData, "Chunk too short for update header")
+	}
+	header := &resourceUpdateHeader{}
+	if err := header.unmarshalBinary(chunkdata[resourceUpdateHeaderOffset:], isEncryptedUpdate(chunkdata)); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// ResourceMetadata returns the creation time and the signer of the most
+// recent update of the resource the handler knows of, so clients can tell its
+// freshness and provenance
+func (self *ResourceHandler) ResourceMetadata(nameHash string) (*ResourceMetadata, error) {
+	view, err := self.getCurrentView(nameHash)
+	if err != nil {
+		return nil, err
+	} else if self.getTombstone(nameHash) != nil {
+		return nil, NewResourceError(ErrGone, "Resource was deleted")
+	}
+	return view.Metadata()
+}
+
+// Metadata returns the creation time and the signer of the update
+func (self *ResourceView) Metadata() (*ResourceMetadata, error) {
+	if self.lastKey == nil {
+		return nil, NewResourceError(ErrNothingToReturn, "Resource has no updates")
+	}
+	return &ResourceMetadata{
+		Name:    self.name,
+		Period:  self.lastPeriod,
+		Version: self.version,
+		ModTime: self.ModTime(),
+		Signer:  self.signer,
+	}, nil
+}
+
+// recovers the address of the signer of an update from its signature, and
+// checks that it is the signer given in the header of the update chunk
+//
+// The digest of updates of resources which are not loaded may have been made
+// with any of the hash algorithms, the one recovering the signer in the
+// header is used.
+func (self *ResourceHandler) recoverSigner(nameHash common.Hash, key Key, chunkdata []byte, data []byte, signature *Signature) (addr common.Address, err error) {
+	header, err := parseUpdateHeader(chunkdata)
+	if err != nil {
+		return common.Address{}, err
+	}
+	for _, hash := range self.hashAlgorithms(nameHash) {
+		addr, err = getAddressFromDataSig(self.updateDigest(hash, key, header, data), *signature)
+		if err != nil {
+			err = WrapResourceError(ErrInvalidSignature, "Invalid signature", err)
+		} else if addr != header.signer {
+			err = NewResourceError(ErrInvalidSignature, fmt.Sprintf("Update signed by %x, but header has %x", addr, header.signer))
+		} else {
+			return addr, nil
+		}
+	}
+	return common.Address{}, err
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// ResourcePublication is an update of a resource passed to the update
+// middleware, see ResourceUpdateMiddleware
+type ResourcePublication struct {
+	Name      string
+	NameHash  common.Hash
+	Key       Key // key of the update chunk
+	Period    uint32
+	Version   uint32
+	Data      []byte         // data of the update as given, before encryption
+	Multihash bool           // the data is a multihash
+	Tombstone bool           // the update deletes the resource, see UpdateTombstone
+	Signer    common.Address // zero before signing, and if the update is not signed
+}
+
+// ResourceUpdateMiddleware is called around the publication of resource
+// updates, so applications can audit the updates, mirror them to other
+// systems or apply their own policies without changing the handler.
+//
+// The middleware must not modify the publications.
+type ResourceUpdateMiddleware interface {
+	// PreSign is called for each update before it is signed. An error
+	// rejects the update, and no update of the batch is published.
+	PreSign(ctx context.Context, pub *ResourcePublication) error
+
+	// PostStore is called for each update once all updates of the batch are
+	// stored. It is called while further updates wait, so it must not block.
+	PostStore(ctx context.Context, pub *ResourcePublication)
+}
+
+// AddUpdateMiddleware adds middleware called around the publication of
+// updates. Middleware is called in the order it was added.
+func (self *ResourceHandler) AddUpdateMiddleware(m ResourceUpdateMiddleware) {
+	self.middlewareLock.Lock()
+	defer self.middlewareLock.Unlock()
+	self.middleware = append(self.middleware, m)
+}
+
+// returns the middleware of the handler
+func (self *ResourceHandler) getMiddleware() []ResourceUpdateMiddleware {
+	self.middlewareLock.RLock()
+	defer self.middlewareLock.RUnlock()
+	return self.middleware
+}
+
+// calls the PreSign middleware, the first error rejects the update
+func (self *ResourceHandler) preSign(ctx context.Context, middleware []ResourceUpdateMiddleware, pub *ResourcePublication) error {
+	for _, m := range middleware {
+		if err := m.PreSign(ctx, pub); err != nil {
+			metrics.GetOrRegisterCounter("resource.middleware.reject", nil).Inc(1)
+			log.Debug("resource update rejected by middleware", "name", pub.Name, "period", pub.Period, "version", pub.Version, "err", err)
+			if _, ok := ResourceErrorCode(err); ok {
+				return err
+			}
+			return WrapResourceError(ErrUnauthorized, "Update rejected", err)
+		}
+	}
+	return nil
+}
+
+// calls the PostStore middleware for the stored updates
+func (self *ResourceHandler) postStore(ctx context.Context, middleware []ResourceUpdateMiddleware, pubs []*ResourcePublication) {
+	for _, pub := range pubs {
+		for _, m := range middleware {
+			m.PostStore(ctx, pub)
+		}
+	}
+}
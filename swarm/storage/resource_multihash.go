@@ -0,0 +1,67 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/swarm/multihash"
+)
+
+// resourceMultihashCodes are the digest lengths of the multihash codes known
+// to resource updates: swarm content, ipfs content and the blake2b family,
+// whose codes encode their digest length
+var resourceMultihashCodes = map[uint64]int{
+	multihash.SHA2_256:   32,
+	multihash.KECCAK_256: 32,
+}
+
+func init() {
+	for code := uint64(multihash.BLAKE2B_MIN); code <= multihash.BLAKE2B_MAX; code++ {
+		resourceMultihashCodes[code] = int(code - multihash.BLAKE2B_MIN + 1)
+	}
+}
+
+// returns the length of the multihash at the start of the data
+//
+// The digest must have the length of its code. Multihashes of unknown codes
+// are rejected, unless ResourceHandlerParams.AllowUnknownMultihash is set, in
+// which case only their structure is checked.
+func (self *ResourceHandler) multihashLength(data []byte) (int, error) {
+	code, c := binary.Uvarint(data)
+	if c <= 0 {
+		return 0, NewResourceError(ErrCorruptData, "Corrupt multihash data, hash code is unreadable")
+	}
+	cursor := c
+	length, c := binary.Uvarint(data[cursor:])
+	if c <= 0 {
+		return 0, NewResourceError(ErrCorruptData, "Corrupt multihash data, hash length is unreadable")
+	}
+	cursor += c
+	if length > uint64(len(data)-cursor) {
+		return 0, NewResourceError(ErrCorruptData, fmt.Sprintf("Corrupt multihash data, hash length %d exceeds data length %d", length, len(data)-cursor))
+	}
+	if expected, ok := resourceMultihashCodes[code]; ok {
+		if int(length) != expected {
+			return 0, NewResourceError(ErrInvalidValue, fmt.Sprintf("Multihash %s has digest length %d, expected %d", multihash.Codes[code], length, expected))
+		}
+	} else if !self.allowUnknownMultihash {
+		return 0, NewResourceError(ErrInvalidValue, fmt.Sprintf("Unknown multihash code %x", code))
+	}
+	return cursor + int(length), nil
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// resourcePredictor predicts when the next periods of resources start, so
+// that the updates of subscribed resources are retrieved ahead of time, see
+// ResourceHandlerParams.PrefetchAhead.
+//
+// The retrieval of the first version of the next period is issued ahead of
+// the predicted start and stays pending until the update is delivered, so
+// that the lookup after the period started finds it in the local store.
+type resourcePredictor struct {
+	ahead     time.Duration // prefetch this long before the period starts
+	blockTime time.Duration // average time between blocks
+}
+
+// returns a predictor prefetching updates ahead of their period
+func newResourcePredictor(ahead time.Duration) *resourcePredictor {
+	return &resourcePredictor{
+		ahead:     ahead,
+		blockTime: NewBlockEstimator().Average,
+	}
+}
+
+// returns the time until the position start is reached from current, on the
+// period scale of the resource
+func (p *resourcePredictor) until(rsrc *resource, current uint64, start uint64) time.Duration {
+	if start <= current {
+		return 0
+	}
+	if rsrc.scheme == TimePeriods {
+		return time.Duration(start-current) * time.Second
+	}
+	return time.Duration(start-current) * p.blockTime
+}
+
+// prefetches the update of the next period of the watched resource ahead of
+// the predicted start of the period, until the watch is cancelled
+//
+// Predictions further away than the poll interval are made again after it,
+// so that they follow the actual block times.
+func (self *ResourceHandler) prefetchLoop(w *resourceWatch) {
+	var prefetched uint32
+	for {
+		period, wait, ok := self.nextPrefetch(w, prefetched)
+		if !ok || wait > self.pollInterval {
+			wait, ok = self.pollInterval, false
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-w.quitC:
+			timer.Stop()
+			return
+		}
+		if ok {
+			self.prefetch(w, period)
+			prefetched = period
+		}
+	}
+}
+
+// returns the next period of the watched resource and the time until its
+// update is prefetched, false if the resource is not loaded or the period was
+// prefetched already
+func (self *ResourceHandler) nextPrefetch(w *resourceWatch, prefetched uint32) (uint32, time.Duration, bool) {
+	rsrc := self.getResource(w.nameHash.Hex())
+	if rsrc == nil || !rsrc.isSynced() {
+		return 0, 0, false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), self.pollInterval)
+	defer cancel()
+	current, err := self.getRecent(ctx, rsrc)
+	if err != nil {
+		return 0, 0, false
+	}
+	period, err := self.getPeriod(rsrc, current)
+	if err != nil || period == math.MaxUint32 || period+1 <= prefetched {
+		return 0, 0, false
+	}
+	start, err := self.getPeriodEnd(rsrc, period)
+	if err != nil {
+		return 0, 0, false
+	}
+	return period + 1, self.predictor.until(rsrc, current, start) - self.predictor.ahead, true
+}
+
+// issues the retrieval of the first update of the period of the watched
+// resource, which is pending until the period started and the retrieve
+// timeout passed
+func (self *ResourceHandler) prefetch(w *resourceWatch, period uint32) {
+	metrics.GetOrRegisterCounter("resource.prefetch", nil).Inc(1)
+	key := self.resourceHash(period, 1, w.nameHash)
+	timeout := self.predictor.ahead + self.retrieveTimeout
+	log.Trace("resource prefetch", "namehash", w.nameHash, "period", period, "key", key)
+	go func() {
+		if _, err := self.chunkStore.get(key, timeout); err != nil {
+			log.Trace("resource prefetch failed", "namehash", w.nameHash, "period", period, "err", err)
+			return
+		}
+		metrics.GetOrRegisterCounter("resource.prefetch.found", nil).Inc(1)
+	}()
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// ResourceProof bundles the chunks of a resource update with its signature,
+// so that clients can verify the update without trusting the node which
+// served it, see VerifyProof.
+//
+// The proof does not include the state of the chain. Clients check that the
+// signer was the ENS owner of the name at OwnerBlock themselves, or that it is
+// listed in the access control list of the metadata chunk, signed by the
+// owner. The data of large updates is referenced by the update chunk and
+// retrieved like other content.
+type ResourceProof struct {
+	Name       string         `json:"name"`
+	NameHash   common.Hash    `json:"nameHash"`
+	Period     uint32         `json:"period"`
+	Version    uint32         `json:"version"`
+	RootKey    hexutil.Bytes  `json:"rootKey"`    // key of the metadata chunk
+	RootChunk  hexutil.Bytes  `json:"rootChunk"`  // data of the metadata chunk
+	Key        hexutil.Bytes  `json:"key"`        // key of the update chunk
+	Chunk      hexutil.Bytes  `json:"chunk"`      // data of the update chunk
+	Digest     common.Hash    `json:"digest"`     // signed digest of the update
+	Signature  hexutil.Bytes  `json:"signature"`  // signature of the digest
+	Signer     common.Address `json:"signer"`     // address recovered from the signature
+	OwnerBlock uint64         `json:"ownerBlock"` // block at which the node found the signer may update the resource, 0 if unknown
+}
+
+// Proof returns the proof of the most recent update of the resource the
+// handler knows of. Unsigned updates cannot be proven.
+func (self *ResourceHandler) Proof(ctx context.Context, nameHash common.Hash) (*ResourceProof, error) {
+	view, err := self.getCurrentView(nameHash.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return self.ProofOf(ctx, view)
+}
+
+// ProofOf returns the proof of the update of a view returned by a lookup
+func (self *ResourceHandler) ProofOf(ctx context.Context, view *ResourceView) (*ResourceProof, error) {
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before proving updates")
+	}
+	nameHash := view.nameHash
+	rsrc := self.getResource(nameHash.Hex())
+	if rsrc == nil {
+		return nil, NewResourceError(ErrNotFound, "Resource does not exist")
+	} else if self.getTombstone(nameHash.Hex()) != nil {
+		return nil, NewResourceError(ErrGone, "Resource was deleted")
+	} else if view.lastKey == nil {
+		return nil, NewResourceError(ErrNothingToReturn, "No update of the resource was looked up")
+	}
+	root, err := self.ResourceRoot(nameHash)
+	if err != nil {
+		return nil, err
+	}
+	timeout := self.getRetrieveTimeout(ctx)
+	rootChunk, err := self.chunkStore.get(root, timeout)
+	if err != nil {
+		return nil, WrapResourceError(ErrNotFound, "Cannot retrieve metadata chunk", err)
+	}
+	chunk, err := self.chunkStore.get(view.lastKey, timeout)
+	if err != nil {
+		return nil, WrapResourceError(ErrNotFound, "Cannot retrieve update chunk", err)
+	}
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return nil, err
+	}
+	_, period, version, _, data, _, err := self.parseUpdate(chunkdata)
+	if err != nil {
+		return nil, err
+	}
+	header, err := parseUpdateHeader(chunkdata)
+	if err != nil {
+		return nil, err
+	} else if header.signer == (common.Address{}) {
+		return nil, NewResourceError(ErrInvalidSignature, "Update is not signed")
+	}
+	proof := &ResourceProof{
+		Name:      rsrc.name,
+		NameHash:  nameHash,
+		Period:    period,
+		Version:   version,
+		RootKey:   hexutil.Bytes(root),
+		RootChunk: rootChunk.SData,
+		Key:       hexutil.Bytes(chunk.Key),
+		Chunk:     chunk.SData,
+		Digest:    self.updateDigest(rsrc.hash, chunk.Key, header, data),
+		Signature: chunkdata[len(chunkdata)-signatureLength:],
+		Signer:    header.signer,
+	}
+
+	// the access of the signer is checked at the latest block
+	if self.headerGetter != nil {
+		if proof.OwnerBlock, err = self.getBlock(ctx, rsrc.name); err != nil {
+			return nil, WrapResourceError(ErrIO, "Cannot get the block of the owner check", err)
+		}
+	}
+	ok, err := self.checkAccess(rsrc.name, proof.Signer)
+	if err != nil {
+		return nil, WrapResourceError(ErrIO, "Access check fail", err)
+	} else if !ok {
+		return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x does not have access to update %s", proof.Signer, rsrc.name))
+	}
+	metrics.GetOrRegisterCounter("resource.proof", nil).Inc(1)
+	return proof, nil
+}
+
+// VerifyProof checks that the chunks of the proof belong together and that
+// the update was signed by the signer of the proof. It does not check the
+// chain, see ResourceProof.
+func (self *ResourceHandler) VerifyProof(proof *ResourceProof) error {
+	// the metadata chunk names the resource and its hash algorithm
+	rootdata, err := decodeResourceChunk(proof.RootChunk)
+	if err != nil {
+		return err
+	} else if len(rootdata) <= metadataChunkOffsetSize || !bytes.Equal(rootdata[:2], []byte{0, 0}) {
+		return NewResourceError(ErrCorruptData, "Invalid metadata chunk")
+	}
+	rsrc := &resource{}
+	if err := rsrc.UnmarshalBinary(rootdata[2:]); err != nil {
+		return err
+	}
+	hasher := self.getHasher(rsrc.hash, len(proof.RootChunk))
+	hasher.Write(proof.RootChunk)
+	rootKey := hasher.Sum(nil)
+	self.putHasher(rsrc.hash, hasher)
+	if !bytes.Equal(rootKey, proof.RootKey) {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Metadata chunk has key %x, not %x", rootKey, []byte(proof.RootKey)))
+	} else if rsrc.name != proof.Name || ResourceNameHash(rsrc.name) != proof.NameHash {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Metadata chunk is of '%s', not '%s'", rsrc.name, proof.Name))
+	}
+
+	// the update chunk is of the period and version of the resource
+	chunkdata, err := decodeResourceChunk(proof.Chunk)
+	if err != nil {
+		return err
+	}
+	_, period, version, name, data, _, err := self.parseUpdate(chunkdata)
+	if err != nil {
+		return err
+	} else if name != proof.Name || period != proof.Period || version != proof.Version {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Update chunk is of '%s' period %d version %d", name, period, version))
+	} else if key := self.resourceHashWith(rsrc.hash, period, version, proof.NameHash); !bytes.Equal(key, proof.Key) {
+		return NewResourceError(ErrCorruptData, fmt.Sprintf("Update chunk has key %x, not %x", key, []byte(proof.Key)))
+	}
+
+	// the update is signed by the signer
+	header, err := parseUpdateHeader(chunkdata)
+	if err != nil {
+		return err
+	}
+	// signed update chunks end with the signature
+	if len(proof.Signature) != signatureLength || !bytes.HasSuffix(chunkdata, proof.Signature) {
+		return NewResourceError(ErrInvalidSignature, "Signature is not the one of the update chunk")
+	} else if digest := self.updateDigest(rsrc.hash, Key(proof.Key), header, data); digest != proof.Digest {
+		return NewResourceError(ErrInvalidSignature, fmt.Sprintf("Update has digest %x, not %x", digest, proof.Digest))
+	}
+	var signature Signature
+	copy(signature[:], proof.Signature)
+	addr, err := getAddressFromDataSig(proof.Digest, signature)
+	if err != nil {
+		return WrapResourceError(ErrInvalidSignature, "Invalid signature", err)
+	} else if addr != proof.Signer || header.signer != proof.Signer {
+		return NewResourceError(ErrInvalidSignature, fmt.Sprintf("Update signed by %x, not %x", addr, proof.Signer))
+	}
+	return nil
+}
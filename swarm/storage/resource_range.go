@@ -0,0 +1,101 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// the most periods a range lookup may span, since every period without
+// updates costs a retrieval
+const maxResourceRangePeriods = 4096
+
+// LookupRange returns all updates of the resource with the given namehash in
+// the periods fromPeriod through toPeriod, ordered by period and version.
+//
+// Periods without updates are skipped. Updates signed with revoked keys are
+// left out, and the range ends at a tombstone, which is returned as an update
+// with Gone set. The resource must be loaded in the handler, and the resource
+// index is not changed by the lookup.
+func (self *ResourceHandler) LookupRange(ctx context.Context, nameHash common.Hash, fromPeriod, toPeriod uint32) ([]*ResourceUpdate, error) {
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before performing lookups")
+	}
+	rsrc := self.getResource(nameHash.Hex())
+	if rsrc == nil {
+		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
+	}
+	if fromPeriod == 0 {
+		return nil, NewResourceError(ErrInvalidValue, "period must be >0")
+	} else if fromPeriod > toPeriod {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Range from period %d to %d is empty", fromPeriod, toPeriod))
+	} else if toPeriod-fromPeriod >= maxResourceRangePeriods {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Range of %d periods exceeds the maximum of %d", uint64(toPeriod-fromPeriod)+1, maxResourceRangePeriods))
+	}
+	if self.getTombstone(nameHash.Hex()) != nil {
+		return nil, NewResourceError(ErrGone, fmt.Sprintf("Resource '%s' was deleted", rsrc.name))
+	}
+	metrics.GetOrRegisterCounter("resource.lookup.range", nil).Inc(1)
+
+	var updates []*ResourceUpdate
+	timeout := self.getRetrieveTimeout(ctx)
+	for period := fromPeriod; ; period++ {
+		for version := uint32(1); ; version++ {
+			if err := ctx.Err(); err != nil {
+				return nil, WrapResourceError(ErrIO, "Range lookup aborted", err)
+			}
+			key := self.resourceHash(period, version, nameHash)
+			chunk, err := self.chunkStore.get(key, timeout)
+			if err != nil {
+				break
+			}
+			if self.isRevokedUpdate(rsrc, chunk) {
+				log.Debug("skipping resource update signed with revoked key", "name", rsrc.name, "key", key)
+				continue
+			}
+			content, err := self.readUpdate(rsrc, chunk)
+			if err != nil {
+				return nil, err
+			}
+			update := &ResourceUpdate{
+				Name:      rsrc.name,
+				NameHash:  nameHash,
+				Key:       key,
+				Period:    period,
+				Version:   version,
+				Multihash: content.multihash,
+				Data:      content.data,
+				Gone:      content.tombstone,
+				Encrypted: content.encrypted,
+			}
+			updates = append(updates, update)
+			if content.tombstone {
+				return updates, nil
+			}
+		}
+		if period == toPeriod {
+			break
+		}
+	}
+	log.Trace("resource range lookup", "name", rsrc.name, "from", fromPeriod, "to", toPeriod, "updates", len(updates))
+	return updates, nil
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// SetRefresh opts the resource in or out of the periodic re-publication of
+// its chunks, see ResourceHandlerParams.RefreshInterval.
+//
+// Every refresh interval, the metadata chunk and the latest update chunk of
+// the resources opted in are offered to the peers again, as if they were new.
+// Nodes which garbage collected them store them again, so the feeds of
+// publishers which are mostly offline stay retrievable as long as one node
+// refreshing them is online.
+//
+// Only resources loaded in the handler are refreshed, and only their chunks
+// which are still in the local store. The opt-ins are kept in memory only.
+func (self *ResourceHandler) SetRefresh(name string, refresh bool) error {
+	if self.refreshInterval == 0 {
+		return NewResourceError(ErrInit, "Resource refresh is disabled")
+	}
+	nameHash := ResourceNameHash(name).Hex()
+	self.refreshLock.Lock()
+	defer self.refreshLock.Unlock()
+	if refresh {
+		self.refreshes[nameHash] = true
+	} else {
+		delete(self.refreshes, nameHash)
+	}
+	return nil
+}
+
+// refreshes the resources opted in every refresh interval until the handler
+// is closed
+func (self *ResourceHandler) refreshLoop(quitC chan struct{}) {
+	ticker := time.NewTicker(self.refreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-quitC:
+			return
+		}
+		self.refresh()
+	}
+}
+
+// re-publishes the chunks of the resources opted in
+func (self *ResourceHandler) refresh() {
+	self.refreshLock.RLock()
+	nameHashes := make([]string, 0, len(self.refreshes))
+	for nameHash := range self.refreshes {
+		nameHashes = append(nameHashes, nameHash)
+	}
+	self.refreshLock.RUnlock()
+
+	for _, nameHash := range nameHashes {
+		rsrc := self.getResource(nameHash)
+		if rsrc == nil || !rsrc.isSynced() {
+			log.Trace("resource refresh skipped, not loaded", "namehash", nameHash)
+			continue
+		}
+		// deleted resources are left to expire
+		if self.getTombstone(nameHash) != nil {
+			continue
+		}
+		self.refreshResource(rsrc)
+	}
+}
+
+// re-publishes the metadata chunk and the latest update chunk of the resource
+func (self *ResourceHandler) refreshResource(rsrc *resource) {
+	metrics.GetOrRegisterCounter("resource.refresh", nil).Inc(1)
+	meta, err := self.newMetaChunk(rsrc)
+	if err != nil {
+		log.Warn("resource refresh failed", "name", rsrc.name, "err", err)
+		return
+	}
+	if err := self.chunkStore.Resync(meta.Key); err != nil {
+		log.Debug("resource refresh of metadata chunk failed", "name", rsrc.name, "key", meta.Key, "err", err)
+	}
+	latest := rsrc.current()
+	if latest == nil || latest.lastKey == nil {
+		return
+	}
+	if err := self.chunkStore.Resync(latest.lastKey); err != nil {
+		log.Debug("resource refresh of update chunk failed", "name", rsrc.name, "key", latest.lastKey, "err", err)
+		return
+	}
+	log.Trace("resource refreshed", "name", rsrc.name, "period", latest.lastPeriod, "version", latest.version)
+}
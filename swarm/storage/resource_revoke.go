@@ -0,0 +1,261 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	// revocation updates are indicated by this flag in the flags field
+	resourceRevocationFlag = 0x20
+
+	// the data of revocation updates is address|period
+	revocationDataLength = common.AddressLength + 4
+)
+
+// ResourceRevocation invalidates the updates signed by Address in the periods
+// after Period
+type ResourceRevocation struct {
+	Address common.Address
+	Period  uint32
+}
+
+// RevokeKey publishes the revocation of a signing key of the resource. The
+// updates signed with the key in the periods after the given period are
+// invalid, both when validating chunks and when looking up updates, which
+// fall back to the latest update not signed with a revoked key.
+//
+// Revocations must be signed by the owner of the resource name. They are
+// stored as update chunks of period 0, which is never used by updates, with
+// consecutive versions:
+//
+// resourceHash(0|index|namehash)
+//
+// Revocations are only honoured by handlers which have a signer, since
+// signatures are not parsed otherwise, and only for resources loaded in the
+// handler. Unsigned revocations are always invalid. Revocations missing from
+// the network are only requested again after resourceProbeInterval.
+func (self *ResourceHandler) RevokeKey(ctx context.Context, name string, address common.Address, period uint32) (_ Key, err error) {
+	defer annotateResourceError(&err, name, period, 0)
+
+	// we can't revoke anything without a store
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before revoking keys")
+	} else if self.signer == nil {
+		return nil, NewResourceError(ErrInit, "Revocations must be signed, set ResourceHandlerParams.Signer")
+	}
+	signer, err := self.signerFor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	self.updateLock.Lock()
+	defer self.updateLock.Unlock()
+
+	nameHash := ResourceNameHash(name)
+	rsrc := self.getResource(nameHash.Hex())
+	if rsrc == nil {
+		return nil, NewResourceError(ErrNotFound, fmt.Sprintf("Resource object '%s' not in index", name))
+	}
+
+	// the revocations published by others must be known to get the next index
+	self.loadRevocations(ctx, rsrc)
+	index := uint32(len(self.Revocations(nameHash))) + 1
+
+	data := make([]byte, revocationDataLength)
+	copy(data, address[:])
+	binary.LittleEndian.PutUint32(data[common.AddressLength:], period)
+	key := self.resourceHash(0, index, nameHash)
+	modTime := uint64(self.now().Unix())
+	digest := self.keyDataHash(nameHash, key, modTime, data)
+	signature, err := signer.Sign(digest)
+	if err != nil {
+		return nil, WrapResourceError(ErrInvalidSignature, "Sign fail", err)
+	}
+	addr, err := getAddressFromDataSig(digest, signature)
+	if err != nil {
+		return nil, WrapResourceError(ErrInvalidSignature, "Invalid data/signature", err)
+	}
+	ok, err := self.checkOwner(name, addr)
+	if err != nil {
+		return nil, WrapResourceError(ErrIO, "Owner check fail", err)
+	} else if !ok {
+		return nil, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x is not the owner of %s", addr, name))
+	}
+
+	header := &resourceUpdateHeader{
+		modTime: modTime,
+		signer:  addr,
+	}
+	chunk := newUpdateChunk(key, &signature, header, 0, index, name, data, resourceRevocationFlag)
+	self.chunkStore.Put(chunk)
+	timeout := time.NewTimer(self.getStoreTimeout(ctx))
+	defer timeout.Stop()
+	select {
+	case <-chunk.dbStoredC:
+		if err := chunk.GetErrored(); err != nil {
+			return nil, WrapResourceError(ErrIO, "chunk not stored", err)
+		}
+	case <-timeout.C:
+		return nil, NewResourceError(ErrIO, "chunk store timeout")
+	case <-ctx.Done():
+		return nil, NewResourceError(ErrIO, fmt.Sprintf("chunk not stored: %v", ctx.Err()))
+	}
+
+	self.addRevocation(nameHash.Hex(), index, ResourceRevocation{
+		Address: address,
+		Period:  period,
+	})
+	metrics.GetOrRegisterCounter("resource.revoke", nil).Inc(1)
+	log.Debug("resource key revoked", "name", name, "key", key, "address", address, "period", period)
+	return key, nil
+}
+
+// Revocations returns the revocations of the signing keys of the resource
+// known to the handler
+func (self *ResourceHandler) Revocations(nameHash common.Hash) []ResourceRevocation {
+	self.revocationLock.RLock()
+	defer self.revocationLock.RUnlock()
+	return append([]ResourceRevocation{}, self.revocations[nameHash.Hex()]...)
+}
+
+// retrieves the revocations of the resource following the ones already known
+func (self *ResourceHandler) loadRevocations(ctx context.Context, rsrc *resource) {
+	timeout := self.getRetrieveTimeout(ctx)
+	for {
+		index := uint32(len(self.Revocations(rsrc.nameHash))) + 1
+		chunk, err := self.probe(self.chunkStore, self.resourceHash(0, index, rsrc.nameHash), timeout)
+		if err != nil {
+			return
+		}
+		revocation, err := self.parseRevocation(rsrc, chunk)
+		if err != nil {
+			log.Warn("Invalid resource revocation", "name", rsrc.name, "index", index, "err", err)
+			return
+		}
+		self.addRevocation(rsrc.nameHash.Hex(), index, revocation)
+	}
+}
+
+// adds the revocation with the given index, unless it was added concurrently
+func (self *ResourceHandler) addRevocation(nameHash string, index uint32, revocation ResourceRevocation) {
+	self.revocationLock.Lock()
+	defer self.revocationLock.Unlock()
+	if uint32(len(self.revocations[nameHash])) == index-1 {
+		self.revocations[nameHash] = append(self.revocations[nameHash], revocation)
+	}
+}
+
+// retrieves the revocation from its chunk, and checks that it is signed by
+// the owner of the resource name
+func (self *ResourceHandler) parseRevocation(rsrc *resource, chunk *Chunk) (ResourceRevocation, error) {
+	var revocation ResourceRevocation
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return revocation, err
+	}
+	signature, _, _, name, data, _, err := self.parseUpdate(chunkdata)
+	if err != nil {
+		return revocation, err
+	} else if !isRevocationUpdate(chunkdata) || len(data) != revocationDataLength {
+		return revocation, NewResourceError(ErrCorruptData, "Chunk is not a resource revocation")
+	} else if name != rsrc.name {
+		return revocation, NewResourceError(ErrNothingToReturn, fmt.Sprintf("Revocation belongs to '%s', but have '%s'", name, rsrc.name))
+	}
+	if signature == nil {
+		return revocation, NewResourceError(ErrUnauthorized, "Revocation is not signed")
+	}
+	addr, err := self.recoverSigner(rsrc.nameHash, chunk.Key, chunkdata, data, signature)
+	if err != nil {
+		return revocation, err
+	}
+	if ok, _ := self.checkOwner(name, addr); !ok {
+		return revocation, NewResourceError(ErrUnauthorized, fmt.Sprintf("Address %x is not the owner of %s", addr, name))
+	}
+	copy(revocation.Address[:], data)
+	revocation.Period = binary.LittleEndian.Uint32(data[common.AddressLength:])
+	return revocation, nil
+}
+
+// returns true if the key of address is revoked in the given period
+func (self *ResourceHandler) isRevoked(nameHash string, address common.Address, period uint32) bool {
+	self.revocationLock.RLock()
+	defer self.revocationLock.RUnlock()
+	for _, revocation := range self.revocations[nameHash] {
+		if revocation.Address == address && period > revocation.Period {
+			return true
+		}
+	}
+	return false
+}
+
+// returns true if the update chunk is signed with a revoked key
+func (self *ResourceHandler) isRevokedUpdate(rsrc *resource, chunk *Chunk) bool {
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return false
+	}
+	signature, period, _, _, data, _, err := self.parseUpdate(chunkdata)
+	if err != nil || signature == nil {
+		return false
+	}
+	addr, err := self.recoverSigner(rsrc.nameHash, chunk.Key, chunkdata, data, signature)
+	if err != nil {
+		return false
+	}
+	return self.isRevoked(rsrc.nameHash.Hex(), addr, period)
+}
+
+// retrieves the update preceding the update chunk, which is the previous
+// version in the same period or the latest version of an earlier period
+func (self *ResourceHandler) previousUpdate(ctx context.Context, rsrc *resource, chunk *Chunk, maxLookup *ResourceLookupParams) (uint32, *Chunk, error) {
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return 0, nil, err
+	}
+	_, period, version, _, _, _, err := self.parseUpdate(chunkdata)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > 1 {
+		chunk, err := self.chunkStore.get(self.resourceHash(period, version-1, rsrc.nameHash), self.getRetrieveTimeout(ctx))
+		if err != nil {
+			return 0, nil, WrapResourceError(ErrNotFound, "Previous version not found", err)
+		}
+		return period, chunk, nil
+	} else if period == 1 {
+		return 0, nil, NewResourceError(ErrNotFound, "no updates found")
+	}
+	period, chunk, err = self.searchPeriods(ctx, rsrc, period-1, 1, 0, maxLookup)
+	if err != nil {
+		return 0, nil, err
+	}
+	return period, self.searchVersion(ctx, rsrc, period, chunk, maxLookup), nil
+}
+
+// returns true if the update chunk is a revocation
+func isRevocationUpdate(chunkdata []byte) bool {
+	return updateFlags(chunkdata)&resourceRevocationFlag != 0
+}
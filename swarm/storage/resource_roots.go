@@ -0,0 +1,156 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+The resource handler remembers the key of the metadata chunk of every resource
+it created or loaded, so that the resource can be looked up by its namehash
+alone once it dropped out of the resource index, or after a restart.
+
+The db layout is:
+
+	keyResourceRoot|namehash -> key of the metadata chunk of the resource
+
+The mapping is learned when a resource is created, and when an update signed
+by the owner of a resource in the index is validated or read. It is never
+learned from metadata chunks alone, since anyone can make a metadata chunk
+for any name, so loading a resource with LoadResource does not store it
+either.
+*/
+
+const keyResourceRoot = byte(10)
+
+func getResourceRootKey(nameHash common.Hash) []byte {
+	return append([]byte{keyResourceRoot}, nameHash[:]...)
+}
+
+// PutResourceRoot stores the key of the metadata chunk of the resource with
+// the namehash, replacing the key stored before
+func (s *LDBStore) PutResourceRoot(nameHash common.Hash, root Key) error {
+	batch := new(leveldb.Batch)
+	batch.Put(getResourceRootKey(nameHash), root)
+	return s.db.Write(batch)
+}
+
+// GetResourceRoot returns the key of the metadata chunk of the resource with
+// the namehash, or leveldb.ErrNotFound if it is not known
+func (s *LDBStore) GetResourceRoot(nameHash common.Hash) (Key, error) {
+	root, err := s.db.Get(getResourceRootKey(nameHash))
+	if err != nil {
+		return nil, err
+	}
+	return Key(root), nil
+}
+
+// PutResourceRoot stores the key of the metadata chunk of a resource in the
+// local store
+func (self *LocalStore) PutResourceRoot(nameHash common.Hash, root Key) error {
+	return self.DbStore.PutResourceRoot(nameHash, root)
+}
+
+// GetResourceRoot returns the key of the metadata chunk of a resource from
+// the local store
+func (self *LocalStore) GetResourceRoot(nameHash common.Hash) (Key, error) {
+	return self.DbStore.GetResourceRoot(nameHash)
+}
+
+// PutResourceRoot stores the key of the metadata chunk of a resource in the
+// local store
+func (self *NetStore) PutResourceRoot(nameHash common.Hash, root Key) error {
+	return self.localStore.PutResourceRoot(nameHash, root)
+}
+
+// GetResourceRoot returns the key of the metadata chunk of a resource from
+// the local store
+func (self *NetStore) GetResourceRoot(nameHash common.Hash) (Key, error) {
+	return self.localStore.GetResourceRoot(nameHash)
+}
+
+// ResourceRoot returns the key of the metadata chunk of the resource with the
+// namehash, which is the key to load it with. It returns an error if the
+// resource is not in the index and its key was never learned on the store.
+func (self *ResourceHandler) ResourceRoot(nameHash common.Hash) (Key, error) {
+	if rsrc := self.getResource(nameHash.Hex()); rsrc != nil && rsrc.rootKey != nil {
+		return rsrc.rootKey, nil
+	}
+	if self.chunkStore == nil {
+		return nil, NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before looking up resources")
+	}
+	root, err := self.chunkStore.GetResourceRoot(nameHash)
+	if err != nil {
+		return nil, NewResourceError(ErrNotFound, "Metadata chunk of the resource not known")
+	}
+	return root, nil
+}
+
+// returns the resource from the index, and loads it with the key of its
+// metadata chunk remembered from earlier if it is not in the index
+func (self *ResourceHandler) loadResource(nameHash common.Hash) (*resource, error) {
+	if rsrc := self.getResource(nameHash.Hex()); rsrc != nil {
+		return rsrc, nil
+	}
+	root, err := self.ResourceRoot(nameHash)
+	if err != nil {
+		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
+	}
+	rsrc, err := self.LoadResource(root)
+	if err != nil {
+		return nil, err
+	}
+	// the stored key may be stale
+	if rsrc.nameHash != nameHash {
+		return nil, NewResourceError(ErrNothingToReturn, "resource not loaded")
+	}
+	log.Trace("resource loaded from remembered metadata chunk", "namehash", nameHash, "rootkey", root)
+	return rsrc, nil
+}
+
+// stores the key of the metadata chunk of the resource in the index if the
+// update signed by addr was made by its owner, see rememberRoot
+func (self *ResourceHandler) confirmRoot(rsrc *resource, addr common.Address) {
+	if rsrc == nil || rsrc.rootKey == nil {
+		return
+	}
+	if ok, err := self.checkOwner(rsrc.name, addr); err != nil || !ok {
+		return
+	}
+	self.rememberRoot(rsrc.nameHash, rsrc.rootKey)
+}
+
+// stores the key of the metadata chunk of the resource, unless it was
+// stored before
+func (self *ResourceHandler) rememberRoot(nameHash common.Hash, root Key) {
+	if self.chunkStore == nil || root == nil {
+		return
+	}
+	self.rootLock.Lock()
+	defer self.rootLock.Unlock()
+	if stored, ok := self.roots.Get(nameHash.Hex()); ok && stored.(string) == root.Hex() {
+		return
+	}
+	if err := self.chunkStore.PutResourceRoot(nameHash, root); err != nil {
+		log.Warn("Cannot store resource metadata key", "namehash", nameHash, "rootkey", root, "err", err)
+		return
+	}
+	self.roots.Add(nameHash.Hex(), root.Hex())
+}
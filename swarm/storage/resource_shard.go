@@ -0,0 +1,132 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// the namehash prefixes are split evenly among at most this many shards
+const maxResourceShards = maxShards
+
+// a partition of the resources of the handler, with their index entries
+type resourceShard struct {
+	resources *lru.Cache
+	lock      sync.RWMutex
+}
+
+// SetShards partitions the resources of the handler across the stores by the
+// first byte of their namehash, which is split into even ranges. Each shard
+// keeps the index entries of its resources, so that the index can hold more
+// resources than a single cache without contention, and its store holds the
+// data of their metadata and update chunks, so that their local stores can
+// live on separate disks and compact separately.
+//
+// The chunks are routed to the shards by the database of the store set with
+// SetStore, which keeps their index and serves them to the network, see
+// LDBStore.SetShards. Resource chunks made locally, synced or retrieved are
+// stored in their shard only.
+//
+// SetShards must be called after SetStore and before the handler is used.
+// Each shard has an index of the size of MaxIndexEntries.
+func (self *ResourceHandler) SetShards(stores []*LocalStore) error {
+	if self.chunkStore == nil {
+		return NewResourceError(ErrInit, "Call ResourceHandler.SetStore() before setting shards")
+	} else if len(stores) > maxResourceShards {
+		return NewResourceError(ErrInvalidValue, fmt.Sprintf("At most %d shards, got %d", maxResourceShards, len(stores)))
+	}
+	shards := make([]*resourceShard, len(stores))
+	dbs := make([]*LDBStore, len(stores))
+	for i, store := range stores {
+		resources, err := self.newIndex(self.maxIndexEntries)
+		if err != nil {
+			return WrapResourceError(ErrInvalidValue, fmt.Sprintf("Invalid MaxIndexEntries %d", self.maxIndexEntries), err)
+		}
+		shards[i] = &resourceShard{
+			resources: resources,
+		}
+		dbs[i] = store.DbStore
+		store.AddGCHook(self.isGarbage)
+	}
+	if err := self.chunkStore.localStore.DbStore.SetShards(dbs, self.routeChunk); err != nil {
+		return WrapResourceError(ErrInvalidValue, "Cannot shard the chunk store", err)
+	}
+	self.shards = shards
+	return nil
+}
+
+// returns the number of the shard of the resource with the namehash
+func (self *ResourceHandler) shardNumber(nameHash common.Hash) int {
+	return int(nameHash[0]) * len(self.shards) / maxResourceShards
+}
+
+// returns the shard of the resource with the namehash, nil if the handler is
+// not sharded
+func (self *ResourceHandler) shardOf(nameHash common.Hash) *resourceShard {
+	if len(self.shards) == 0 {
+		return nil
+	}
+	return self.shards[self.shardNumber(nameHash)]
+}
+
+// returns the index of the resource with the namehash and its lock
+func (self *ResourceHandler) indexOf(nameHash string) (*lru.Cache, *sync.RWMutex) {
+	if shard := self.shardOf(common.HexToHash(nameHash)); shard != nil {
+		return shard.resources, &shard.lock
+	}
+	return self.resources, &self.resourceLock
+}
+
+// returns the shard of a chunk put in the chunk store, -1 if it is not a
+// resource chunk, see ShardRouter. Metadata chunks are recognized by their
+// content address and update chunks by their key, as anyone can make chunks
+// which parse as either.
+func (self *ResourceHandler) routeChunk(key Key, data []byte) int {
+	if len(self.shards) == 0 {
+		return -1
+	}
+	if rsrc, err := parseResourceMetadata(data); err == nil {
+		hasher := self.getHasher(rsrc.hash, len(data))
+		hasher.Write(data)
+		sum := hasher.Sum(nil)
+		self.putHasher(rsrc.hash, hasher)
+		if bytes.Equal(sum, key) {
+			return self.shardNumber(rsrc.nameHash)
+		}
+		return -1
+	}
+	chunkdata, err := decodeResourceChunk(data)
+	if err != nil {
+		return -1
+	}
+	_, period, version, name, _, _, err := self.parseUpdate(chunkdata)
+	if err != nil {
+		return -1
+	}
+	nameHash := ResourceNameHash(name)
+	for _, hash := range self.hashAlgorithms(nameHash) {
+		if bytes.Equal(self.resourceHashWith(hash, period, version, nameHash), key) {
+			return self.shardNumber(nameHash)
+		}
+	}
+	return -1
+}
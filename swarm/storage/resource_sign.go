@@ -16,6 +16,11 @@ type GenericResourceSigner struct {
 	PrivKey *ecdsa.PrivateKey
 }
 
+// Address returns the address of the key of the signer
+func (self *GenericResourceSigner) Address() common.Address {
+	return crypto.PubkeyToAddress(self.PrivKey.PublicKey)
+}
+
 func (self *GenericResourceSigner) Sign(data common.Hash) (signature Signature, err error) {
 	signaturebytes, err := crypto.Sign(data.Bytes(), self.PrivKey)
 	if err != nil {
@@ -24,3 +29,19 @@ func (self *GenericResourceSigner) Sign(data common.Hash) (signature Signature,
 	copy(signature[:], signaturebytes)
 	return
 }
+
+// returns the address of the signer, recovered from the signature of a probe
+// digest unless the signer tells its address
+func resourceSignerAddress(signer ResourceSigner) (common.Address, error) {
+	if s, ok := signer.(interface {
+		Address() common.Address
+	}); ok {
+		return s.Address(), nil
+	}
+	digest := crypto.Keccak256Hash([]byte("swarm resource signer"))
+	signature, err := signer.Sign(digest)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return getAddressFromDataSig(digest, signature)
+}
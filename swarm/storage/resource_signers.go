@@ -0,0 +1,92 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// resourceSignerKey is the context key of the signer set with WithResourceSigner
+type resourceSignerKey struct{}
+
+// WithResourceSigner returns a context which selects the signer of the
+// resources created and updated with it, in place of the signer registered
+// for the resource or the signer of the handler.
+func WithResourceSigner(ctx context.Context, signer ResourceSigner) context.Context {
+	return context.WithValue(ctx, resourceSignerKey{}, signer)
+}
+
+// SetSigner registers the signer of a resource, which signs its creation and
+// updates instead of the signer of the handler. This lets one handler publish
+// several feeds with a different identity each. A nil signer removes the
+// registered signer.
+//
+// The signer of the handler still decides whether updates are signed at all,
+// since the chunks of handlers without signer carry no signature. Registering
+// a signer with such a handler fails.
+//
+// Signers are kept in memory only.
+func (self *ResourceHandler) SetSigner(name string, signer ResourceSigner) error {
+	if self.signer == nil && signer != nil {
+		return NewResourceError(ErrInit, "Resource handler does not sign updates")
+	}
+	nameHash := ResourceNameHash(name).Hex()
+	self.signerLock.Lock()
+	defer self.signerLock.Unlock()
+	if signer == nil {
+		delete(self.signers, nameHash)
+		return nil
+	}
+	self.signers[nameHash] = signer
+	return nil
+}
+
+// returns the signer of an operation on the resource; the signer of the
+// context if any, then the one registered for the resource, then the signer
+// of the handler. It returns nil if the handler does not sign updates.
+func (self *ResourceHandler) signerFor(ctx context.Context, name string) (ResourceSigner, error) {
+	signer, _ := ctx.Value(resourceSignerKey{}).(ResourceSigner)
+	if self.signer == nil {
+		if signer != nil {
+			return nil, NewResourceError(ErrInit, "Resource handler does not sign updates")
+		}
+		return nil, nil
+	} else if signer != nil {
+		return signer, nil
+	}
+	self.signerLock.RLock()
+	defer self.signerLock.RUnlock()
+	if signer, ok := self.signers[ResourceNameHash(name).Hex()]; ok {
+		return signer, nil
+	}
+	return self.signer, nil
+}
+
+// SignFor signs the digest with the signer of the resource, as selected by
+// the context, see signerFor. This lets the owner of a resource vouch for
+// documents about it, e.g. the access documents of private resources.
+func (self *ResourceHandler) SignFor(ctx context.Context, name string, digest common.Hash) (Signature, error) {
+	signer, err := self.signerFor(ctx, name)
+	if err != nil {
+		return Signature{}, err
+	} else if signer == nil {
+		return Signature{}, NewResourceError(ErrInit, "Resource handler does not sign updates")
+	}
+	return signer.Sign(digest)
+}
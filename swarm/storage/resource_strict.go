@@ -0,0 +1,168 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	// update chunks remembered by strict validation to detect conflicting versions
+	defaultMaxSeenUpdates = 10000
+	// resources whose newest period and conflicts are remembered by strict validation
+	maxStrictResources = 10000
+	// conflicts kept per resource, the oldest are dropped
+	maxResourceConflicts = 16
+)
+
+// ResourceConflict records two different update chunks seen for the same
+// period and version of a resource, which can only be made by a signer
+// publishing competing updates (or an attacker with a leaked key)
+type ResourceConflict struct {
+	Key       Key         // key of the update chunks
+	Period    uint32      // period of the conflicting updates
+	Version   uint32      // version of the conflicting updates
+	DataHash  common.Hash // hash of the chunk data seen first
+	OtherHash common.Hash // hash of the chunk data conflicting with it
+	Seen      time.Time   // time the conflict was detected
+	// SameContent is true if both updates carry the same data, only signed
+	// differently, for instance by two owners of the resource
+	SameContent bool
+}
+
+// update chunk seen by strict validation
+type seenUpdate struct {
+	dataHash    common.Hash // hash of the chunk data
+	contentHash common.Hash // hash of the update data
+}
+
+// strict validation state of the handler, see ResourceHandlerParams.StrictValidation
+type resourceStrictness struct {
+	newest    *lru.Cache // newest period seen by namehash
+	seen      *lru.Cache // seenUpdate of the update chunks by key
+	conflicts *lru.Cache // conflicts detected by namehash
+	lock      sync.Mutex
+}
+
+func newResourceStrictness() *resourceStrictness {
+	newest, _ := lru.New(maxStrictResources)
+	seen, _ := lru.New(defaultMaxSeenUpdates)
+	conflicts, _ := lru.New(maxStrictResources)
+	return &resourceStrictness{
+		newest:    newest,
+		seen:      seen,
+		conflicts: conflicts,
+	}
+}
+
+// checkMonotonic is the strict mode of Validate. It rejects updates for
+// periods earlier than the newest period seen locally for the resource, so
+// that a stale period cannot be republished undetected with an old key.
+//
+// Revocations are exempt as they are stored in period 0.
+//
+// Since older periods are rejected, a strict node cannot retrieve updates
+// older than the newest it has seen from the network; historical lookups
+// only succeed for updates which are already stored locally.
+func (self *ResourceHandler) checkMonotonic(nameHash common.Hash, period uint32) bool {
+	s := self.strict
+	if s == nil {
+		return true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if newest, ok := s.newest.Get(nameHash.Hex()); ok && period < newest.(uint32) {
+		metrics.GetOrRegisterCounter("resource.validate.stale", nil).Inc(1)
+		log.Warn("Resource update period earlier than the newest seen", "namehash", nameHash, "period", period, "newest", newest)
+		return false
+	}
+	return true
+}
+
+// recordMonotonic records a signed update which passed all of Validate for
+// checkMonotonic. Updates for the same period and version as an update seen
+// before, but with different data, are recorded as conflicts, see Conflicts.
+//
+// The newest period is only raised if bounded is set, that is if checkPeriod
+// compared the period with the current period of the resource. Otherwise a
+// single update for a period far in the future would block all later ones.
+func (self *ResourceHandler) recordMonotonic(nameHash common.Hash, key Key, period uint32, version uint32, data []byte, content []byte, bounded bool) {
+	s := self.strict
+	if s == nil {
+		return
+	}
+	update := seenUpdate{
+		dataHash:    crypto.Keccak256Hash(data),
+		contentHash: crypto.Keccak256Hash(content),
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if v, ok := s.seen.Get(key.Hex()); ok && v.(seenUpdate).dataHash != update.dataHash {
+		seen := v.(seenUpdate)
+		metrics.GetOrRegisterCounter("resource.validate.conflict", nil).Inc(1)
+		log.Warn("Conflicting resource update", "namehash", nameHash, "period", period, "version", version)
+		var conflicts []ResourceConflict
+		if c, ok := s.conflicts.Get(nameHash.Hex()); ok {
+			conflicts = c.([]ResourceConflict)
+		}
+		conflicts = append(conflicts, ResourceConflict{
+			Key:         key,
+			Period:      period,
+			Version:     version,
+			DataHash:    seen.dataHash,
+			OtherHash:   update.dataHash,
+			Seen:        time.Now(),
+			SameContent: seen.contentHash == update.contentHash,
+		})
+		if len(conflicts) > maxResourceConflicts {
+			conflicts = conflicts[len(conflicts)-maxResourceConflicts:]
+		}
+		s.conflicts.Add(nameHash.Hex(), conflicts)
+	} else if !ok {
+		s.seen.Add(key.Hex(), update)
+	}
+	if !bounded {
+		return
+	}
+	if newest, ok := s.newest.Get(nameHash.Hex()); !ok || period > newest.(uint32) {
+		s.newest.Add(nameHash.Hex(), period)
+	}
+}
+
+// Conflicts returns the conflicting updates of the resource detected by
+// strict validation, the oldest first. It returns nil if strict validation
+// is disabled.
+func (self *ResourceHandler) Conflicts(nameHash common.Hash) []ResourceConflict {
+	if self.strict == nil {
+		return nil
+	}
+	self.strict.lock.Lock()
+	defer self.strict.lock.Unlock()
+	conflicts, ok := self.strict.conflicts.Get(nameHash.Hex())
+	if !ok {
+		return nil
+	}
+	return append([]ResourceConflict(nil), conflicts.([]ResourceConflict)...)
+}
@@ -0,0 +1,209 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	defaultPollInterval        = 5 * time.Second
+	resourceSubscriptionBuffer = 16
+)
+
+// ResourceUpdate is the notification of a new update of a subscribed resource
+type ResourceUpdate struct {
+	Name      string
+	NameHash  common.Hash
+	Key       Key
+	Period    uint32
+	Version   uint32
+	Multihash bool
+	Data      []byte
+	Gone      bool // the update is the tombstone of the resource, see UpdateTombstone
+	Encrypted bool // the data is encrypted and could not be decrypted, see SetSecret
+}
+
+// a watched resource and the channels of its subscribers
+type resourceWatch struct {
+	nameHash common.Hash
+	subs     map[chan ResourceUpdate]struct{}
+	period   uint32 // period and version of the last notified update
+	version  uint32
+	wakeC    chan struct{} // looks up the latest update before the next poll, see wake
+	quitC    chan struct{}
+}
+
+// Subscribe returns a channel receiving the new updates of the resource with
+// the given namehash, and a function cancelling the subscription.
+//
+// Updates made through this handler are notified right away, updates made by
+// others are found by looking up the latest update every poll interval (see
+// ResourceHandlerParams.PollInterval). Only updates newer than the one loaded
+// when subscribing are notified, and when several updates are made between
+// two lookups only the latest one is. The resource must be loaded in the
+// handler for updates of others to be found. When the handler follows the
+// heads of the chain (see ResourceHandlerParams.HeadSubscriber), block based
+// resources are also looked up as soon as they enter a new period. With
+// ResourceHandlerParams.PrefetchAhead, the update of the next period is
+// retrieved before the period starts.
+//
+// Notifications are dropped if the subscriber does not keep up with them.
+// The channel is closed when the subscription is cancelled or the handler
+// is closed.
+func (self *ResourceHandler) Subscribe(nameHash common.Hash) (<-chan ResourceUpdate, func()) {
+	metrics.GetOrRegisterCounter("resource.subscribe", nil).Inc(1)
+	c := make(chan ResourceUpdate, resourceSubscriptionBuffer)
+
+	self.watchLock.Lock()
+	w, ok := self.watches[nameHash.Hex()]
+	if !ok {
+		w = &resourceWatch{
+			nameHash: nameHash,
+			subs:     make(map[chan ResourceUpdate]struct{}),
+			wakeC:    make(chan struct{}, 1),
+			quitC:    make(chan struct{}),
+		}
+		if rsrc := self.getResource(nameHash.Hex()); rsrc != nil {
+			if latest := rsrc.current(); latest != nil {
+				w.period, w.version = latest.lastPeriod, latest.version
+			}
+		}
+		self.watches[nameHash.Hex()] = w
+		go self.poll(w)
+		if self.predictor != nil {
+			go self.prefetchLoop(w)
+		}
+	}
+	w.subs[c] = struct{}{}
+	self.watchLock.Unlock()
+	log.Debug("resource subscribe", "namehash", nameHash, "subscribers", len(w.subs))
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			self.watchLock.Lock()
+			defer self.watchLock.Unlock()
+			if _, ok := w.subs[c]; !ok {
+				return // already closed with the handler
+			}
+			delete(w.subs, c)
+			close(c)
+			if len(w.subs) == 0 {
+				close(w.quitC)
+				delete(self.watches, nameHash.Hex())
+				self.resetHeadPeriod(nameHash.Hex())
+			}
+		})
+	}
+	return c, cancel
+}
+
+// looks up the latest update of a watched resource every poll interval
+func (self *ResourceHandler) poll(w *resourceWatch) {
+	ticker := time.NewTicker(self.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.wakeC:
+		case <-w.quitC:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), self.pollInterval)
+		view, err := self.LookupLatest(ctx, w.nameHash, true, nil)
+		cancel()
+		if IsGone(err) {
+			// notify the tombstone
+			rsrc := self.getResource(w.nameHash.Hex())
+			if rsrc == nil {
+				continue
+			}
+			if view = rsrc.current(); view == nil {
+				continue
+			}
+		} else if err != nil {
+			log.Trace("resource subscription lookup failed", "namehash", w.nameHash, "err", err)
+			continue
+		}
+		self.notify(view)
+	}
+}
+
+// makes the poll of the watch look up the latest update right away
+func (w *resourceWatch) wake() {
+	select {
+	case w.wakeC <- struct{}{}:
+	default:
+	}
+}
+
+// notifies the subscribers of the resource if the update is newer than the
+// last one notified
+func (self *ResourceHandler) notify(view *ResourceView) {
+	self.watchLock.Lock()
+	defer self.watchLock.Unlock()
+	w, ok := self.watches[view.nameHash.Hex()]
+	if !ok {
+		return
+	}
+	if view.lastPeriod < w.period || (view.lastPeriod == w.period && view.version <= w.version) {
+		return
+	}
+	w.period, w.version = view.lastPeriod, view.version
+	update := ResourceUpdate{
+		Name:      view.name,
+		NameHash:  view.nameHash,
+		Key:       view.lastKey,
+		Period:    view.lastPeriod,
+		Version:   view.version,
+		Multihash: view.Multihash,
+		Gone:      view.tombstone || self.getTombstone(view.nameHash.Hex()) != nil,
+		Encrypted: view.encrypted,
+	}
+	for c := range w.subs {
+		update.Data = make([]byte, len(view.data))
+		copy(update.Data, view.data)
+		select {
+		case c <- update:
+		default:
+			metrics.GetOrRegisterCounter("resource.subscribe.drop", nil).Inc(1)
+			log.Warn("resource subscriber not keeping up, dropping update", "name", view.name, "period", update.Period, "version", update.Version)
+		}
+	}
+}
+
+// cancels all subscriptions
+func (self *ResourceHandler) closeWatches() {
+	self.watchLock.Lock()
+	defer self.watchLock.Unlock()
+	for nameHash, w := range self.watches {
+		for c := range w.subs {
+			close(c)
+		}
+		w.subs = nil
+		close(w.quitC)
+		delete(self.watches, nameHash)
+		self.resetHeadPeriod(nameHash)
+	}
+}
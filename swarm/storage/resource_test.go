@@ -5,15 +5,23 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"math/big"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	ethereum "github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
 	"github.com/ethereum/go-ethereum/common"
@@ -22,7 +30,9 @@ import (
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/swarm/multihash"
 )
 
@@ -70,6 +80,20 @@ func (f *fakeBackend) HeaderByNumber(context context.Context, name string, bigbl
 	}, nil
 }
 
+// fake eth backend notifying heads, the channels of its subscriptions are
+// passed on to the test
+type fakeHeads struct {
+	subs chan chan<- *types.Header
+}
+
+func (f *fakeHeads) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	f.subs <- ch
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		return nil
+	}), nil
+}
+
 // check that signature address matches update signer address
 func TestResourceReverse(t *testing.T) {
 
@@ -100,20 +124,23 @@ func TestResourceReverse(t *testing.T) {
 	}
 	testHasher.Reset()
 	testHasher.Write(data)
-	digest := rh.keyDataHash(key, data)
+	digest := rh.keyDataHash(ens.EnsNode(safeName), key, 0, data)
 	sig, err := rh.signer.Sign(digest)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	chunk := newUpdateChunk(key, &sig, period, version, safeName, data, len(data))
+	header := &resourceUpdateHeader{
+		signer: crypto.PubkeyToAddress(signer.PrivKey.PublicKey),
+	}
+	chunk := newUpdateChunk(key, &sig, header, period, version, safeName, data, 0)
 
 	// check that we can recover the owner account from the update chunk's signature
-	checksig, checkperiod, checkversion, checkname, checkdata, _, err := rh.parseUpdate(chunk.SData)
+	checksig, checkperiod, checkversion, checkname, checkdata, _, err := rh.parseUpdate(mustDecodeChunk(t, chunk))
 	if err != nil {
 		t.Fatal(err)
 	}
-	checkdigest := rh.keyDataHash(chunk.Key, checkdata)
+	checkdigest := rh.keyDataHash(ens.EnsNode(checkname), chunk.Key, 0, checkdata)
 	recoveredaddress, err := getAddressFromDataSig(checkdigest, *checksig)
 	if err != nil {
 		t.Fatalf("Retrieve address from signature fail: %v", err)
@@ -167,11 +194,15 @@ func TestResourceHandler(t *testing.T) {
 	chunk, err := rh.chunkStore.localStore.memStore.Get(rootChunkKey)
 	if err != nil {
 		t.Fatal(err)
-	} else if len(chunk.SData) < 16 {
-		t.Fatalf("chunk data must be minimum 16 bytes, is %d", len(chunk.SData))
+	} else if resourceChunkFormat(chunk.SData) != resourceFormatCurrent {
+		t.Fatalf("expected chunk format %d, got %d", resourceFormatCurrent, resourceChunkFormat(chunk.SData))
+	}
+	chunkdata := mustDecodeChunk(t, chunk)
+	if len(chunkdata) < 16 {
+		t.Fatalf("chunk data must be minimum 16 bytes, is %d", len(chunkdata))
 	}
-	startblocknumber := binary.LittleEndian.Uint64(chunk.SData[2:10])
-	chunkfrequency := binary.LittleEndian.Uint64(chunk.SData[10:])
+	startblocknumber := binary.LittleEndian.Uint64(chunkdata[2:10])
+	chunkfrequency := binary.LittleEndian.Uint64(chunkdata[10:])
 	if startblocknumber != uint64(backend.blocknumber) {
 		t.Fatalf("stored block number %d does not match provided block number %d", startblocknumber, backend.blocknumber)
 	}
@@ -227,9 +258,7 @@ func TestResourceHandler(t *testing.T) {
 	fwdBlocks(int(resourceFrequency*2)-1, backend)
 
 	rhparams := &ResourceHandlerParams{
-		QueryMaxPeriods: &ResourceLookupParams{
-			Limit: false,
-		},
+		QueryMaxPeriods: &ResourceLookupParams{},
 		Signer:       nil,
 		HeaderGetter: rh.headerGetter,
 	}
@@ -239,8 +268,10 @@ func TestResourceHandler(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	rsrc2, err := rh2.LoadResource(rootChunkKey)
-	_, err = rh2.LookupLatest(ctx, nameHash, true, nil)
+	if _, err := rh2.LoadResource(rootChunkKey); err != nil {
+		t.Fatal(err)
+	}
+	rsrc2, err := rh2.LookupLatest(ctx, nameHash, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -282,7 +313,7 @@ func TestResourceHandler(t *testing.T) {
 	// we are now at third update
 	// check backwards stepping to the first
 	for i := 1; i >= 0; i-- {
-		rsrc, err := rh2.LookupPreviousByName(ctx, safeName, rh2.queryMaxPeriods)
+		rsrc, err = rh2.LookupPrevious(ctx, rsrc, rh2.queryMaxPeriods)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -293,389 +324,4366 @@ func TestResourceHandler(t *testing.T) {
 	}
 
 	// beyond the first should yield an error
-	rsrc, err = rh2.LookupPreviousByName(ctx, safeName, rh2.queryMaxPeriods)
+	_, err = rh2.LookupPrevious(ctx, rsrc, rh2.queryMaxPeriods)
 	if err == nil {
 		t.Fatalf("expeected previous to fail, returned period %d version %d data %v", rsrc2.lastPeriod, rsrc2.version, rsrc2.data)
 	}
 
 }
 
-// create ENS enabled resource update, with and without valid owner
-func TestResourceENSOwner(t *testing.T) {
+// check that a batch of updates gets consecutive versions in the same period
+// and fetches the block height only once
+func TestResourceUpdateBatch(t *testing.T) {
 
-	// signer containing private key
 	signer, err := newTestSigner()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// ens address and transact options
-	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
-	transactOpts := bind.NewKeyedTransactor(signer.PrivKey)
-
-	// set up ENS sim
-	domainparts := strings.Split(safeName, ".")
-	contractAddr, contractbackend, err := setupENS(addr, transactOpts, domainparts[0], domainparts[1])
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer teardownTest()
 
-	ensClient, err := ens.NewENS(transactOpts, contractAddr, contractbackend)
-	if err != nil {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
 		t.Fatal(err)
 	}
 
-	// set up rpc and create resourcehandler with ENS sim backend
-	rh, _, teardownTest, err := setupTest(contractbackend, ensClient, signer)
-	if err != nil {
-		t.Fatal(err)
+	// empty batches and empty updates are refused
+	if _, err := rh.UpdateBatch(ctx, safeName, nil); err == nil {
+		t.Fatal("expected error on empty batch")
+	}
+	if _, err := rh.UpdateBatch(ctx, safeName, [][]byte{[]byte("blinky"), nil}); err == nil {
+		t.Fatal("expected error on batch with empty update")
 	}
-	defer teardownTest()
 
-	// create new resource when we are owner = ok
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	_, _, err = rh.NewResource(ctx, safeName, resourceFrequency)
-	if err != nil {
-		t.Fatalf("Create resource fail: %v", err)
+	fwdBlocks(int(resourceFrequency/2), backend)
+	if _, err := rh.Update(ctx, safeName, []byte("blinky")); err != nil {
+		t.Fatal(err)
 	}
 
-	data := []byte("foo")
-	// update resource when we are owner = ok
-	_, err = rh.Update(ctx, safeName, data)
+	updates := [][]byte{
+		[]byte("pinky"),
+		[]byte("inky"),
+		[]byte("clyde"),
+	}
+	blocknumber := backend.blocknumber
+	keys, err := rh.UpdateBatch(ctx, safeName, updates)
 	if err != nil {
-		t.Fatalf("Update resource fail: %v", err)
+		t.Fatal(err)
+	}
+	if backend.blocknumber != blocknumber+1 {
+		t.Fatalf("expected one block height fetch, got %d", backend.blocknumber-blocknumber)
+	}
+	if len(keys) != len(updates) {
+		t.Fatalf("expected %d keys, got %d", len(updates), len(keys))
+	}
+	for i, key := range keys {
+		// the single update got version 1
+		if expKey := rh.resourceHash(1, uint32(i+2), nameHash); !bytes.Equal(key, expKey) {
+			t.Fatalf("update %d: expected key %x, got %x", i, expKey, key)
+		}
+		data, err := getUpdateDirect(rh, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, updates[i]) {
+			t.Fatalf("update %d: expected data %q, got %q", i, updates[i], data)
+		}
 	}
 
-	// update resource when we are not owner = !ok
-	signertwo, err := newTestSigner()
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	rh.signer = signertwo
-	_, err = rh.Update(ctx, safeName, data)
-	if err == nil {
-		t.Fatalf("Expected resource update fail due to owner mismatch")
+	if rsrc.lastPeriod != 1 || rsrc.version != 4 {
+		t.Fatalf("expected period 1 version 4, got period %d version %d", rsrc.lastPeriod, rsrc.version)
+	}
+	if !bytes.Equal(rsrc.data, updates[len(updates)-1]) {
+		t.Fatalf("expected latest data %q, got %q", updates[len(updates)-1], rsrc.data)
 	}
 }
 
-func TestResourceMultihash(t *testing.T) {
+// rejects the chunk of the given key, and validates other chunks with the
+// validators it wraps
+type rejectKeyValidator struct {
+	key        Key
+	validators []ChunkValidator
+}
+
+func (self *rejectKeyValidator) Validate(key Key, data []byte) bool {
+	if bytes.Equal(key, self.key) {
+		return false
+	}
+	for _, v := range self.validators {
+		if v.Validate(key, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// check that a batch of updates is stored entirely or not at all
+func TestResourceUpdateBatchAtomic(t *testing.T) {
 
-	// signer containing private key
 	signer, err := newTestSigner()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	// make fake backend, set up rpc and create resourcehandler
 	backend := &fakeBackend{
 		blocknumber: int64(startBlock),
 	}
-
-	// set up rpc and create resourcehandler
-	rh, datadir, teardownTest, err := setupTest(backend, nil, nil)
+	rh, datadir, teardownTest, err := setupTest(backend, nil, signer)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer teardownTest()
 
-	// create a new resource
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	_, _, err = rh.NewResource(ctx, safeName, resourceFrequency)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// we're naïvely assuming keccak256 for swarm hashes
-	// if it ever changes this test should also change
-	swarmhashbytes := ens.EnsNode("foo")
-	swarmhashmulti, err := multihash.Encode(swarmhashbytes.Bytes(), multihash.KECCAK_256)
+	rootChunkKey, _, err := rh.NewResource(ctx, safeName, resourceFrequency)
 	if err != nil {
 		t.Fatal(err)
 	}
-	swarmhashkey, err := rh.UpdateMultihash(ctx, safeName, swarmhashmulti)
-	if err != nil {
+	fwdBlocks(int(resourceFrequency/2), backend)
+	if _, err := rh.Update(ctx, safeName, []byte("blinky")); err != nil {
 		t.Fatal(err)
 	}
 
-	sha1bytes := make([]byte, multihash.DefaultLengths[multihash.SHA1])
-	sha1multi, err := multihash.Encode(sha1bytes, multihash.SHA1)
-	if err != nil {
-		t.Fatal(err)
+	// the chunk of the second update of the batch fails to be stored
+	localStore := rh.chunkStore.localStore
+	localStore.Validators = []ChunkValidator{&rejectKeyValidator{
+		key:        rh.resourceHash(1, 3, nameHash),
+		validators: localStore.Validators,
+	}}
+	if _, err := rh.UpdateBatch(ctx, safeName, [][]byte{[]byte("pinky"), []byte("inky"), []byte("clyde")}); err == nil {
+		t.Fatal("expected batch with a chunk failing to be stored to fail")
+	}
+	for version := uint32(2); version <= 4; version++ {
+		if _, err := localStore.Get(rh.resourceHash(1, version, nameHash)); err == nil {
+			t.Fatalf("expected version %d of the failed batch not to be stored", version)
+		}
 	}
-	sha1key, err := rh.UpdateMultihash(ctx, safeName, sha1multi)
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	// invalid multihashes
-	_, err = rh.UpdateMultihash(ctx, safeName, swarmhashmulti[1:])
-	if err == nil {
-		t.Fatalf("Expected update to fail with first byte skipped")
-	}
-	_, err = rh.UpdateMultihash(ctx, safeName, swarmhashmulti[:len(swarmhashmulti)-2])
-	if err == nil {
-		t.Fatalf("Expected update to fail with last byte skipped")
+	if rsrc.version != 1 || !bytes.Equal(rsrc.data, []byte("blinky")) {
+		t.Fatalf("expected version 1 with data %q, got version %d with data %q", "blinky", rsrc.version, rsrc.data)
 	}
 
-	data, err := getUpdateDirect(rh, swarmhashkey)
-	if err != nil {
-		t.Fatal(err)
-	}
-	swarmhashdecode, err := multihash.Decode(data)
+	// a new handler finds no version of the failed batch either
+	rh.chunkStore.localStore.Close()
+	rh2, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+		Signer:          signer,
+		HeaderGetter:    backend,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(swarmhashdecode.Digest, swarmhashbytes.Bytes()) {
-		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", swarmhashdecode.Digest, swarmhashbytes.Bytes())
-	}
-	data, err = getUpdateDirect(rh, sha1key)
-	if err != nil {
+	if _, err := rh2.LoadResource(rootChunkKey); err != nil {
 		t.Fatal(err)
 	}
-	sha1decode, err := multihash.Decode(data)
+	rsrc, err = rh2.LookupLatest(ctx, nameHash, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(sha1decode.Digest, sha1bytes) {
-		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", sha1decode.Digest, sha1bytes)
+	if rsrc.version != 1 {
+		t.Fatalf("expected version 1 for a new handler, got %d", rsrc.version)
 	}
-	rh.Close()
+}
 
-	rhparams := &ResourceHandlerParams{
-		QueryMaxPeriods: &ResourceLookupParams{
-			Limit: false,
-		},
-		Signer:         signer,
-		HeaderGetter:   rh.headerGetter,
-		OwnerValidator: rh.ownerValidator,
-	}
-	// test with signed data
-	rh.chunkStore.localStore.Close()
-	rh2, err := NewTestResourceHandler(datadir, rhparams)
+// check that updates larger than a chunk are stored with the dpa and
+// transparently retrieved on lookup
+func TestResourceLargeUpdate(t *testing.T) {
+
+	signer, err := newTestSigner()
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, _, err = rh2.NewResource(ctx, safeName, resourceFrequency)
-	if err != nil {
-		t.Fatal(err)
+
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
 	}
-	swarmhashsignedkey, err := rh2.UpdateMultihash(ctx, safeName, swarmhashmulti)
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
 	if err != nil {
 		t.Fatal(err)
 	}
-	sha1signedkey, err := rh2.UpdateMultihash(ctx, safeName, sha1multi)
-	if err != nil {
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
 		t.Fatal(err)
 	}
 
-	data, err = getUpdateDirect(rh2, swarmhashsignedkey)
-	if err != nil {
-		t.Fatal(err)
+	large := make([]byte, 3*chunkSize+42)
+	for i := range large {
+		large[i] = byte(i % 251)
 	}
-	swarmhashdecode, err = multihash.Decode(data)
+	fwdBlocks(int(resourceFrequency/2), backend)
+	keys, err := rh.UpdateBatch(ctx, safeName, [][]byte{[]byte("small"), large})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(swarmhashdecode.Digest, swarmhashbytes.Bytes()) {
-		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", swarmhashdecode.Digest, swarmhashbytes.Bytes())
+
+	// the update chunk holds the reference of the data
+	chunk := rh.mustGetChunk(t, keys[1])
+	if !isDataRefUpdate(mustDecodeChunk(t, chunk)) {
+		t.Fatal("expected update chunk to hold a data reference")
 	}
-	data, err = getUpdateDirect(rh2, sha1signedkey)
-	if err != nil {
-		t.Fatal(err)
+	if isDataRefUpdate(mustDecodeChunk(t, rh.mustGetChunk(t, keys[0]))) {
+		t.Fatal("expected small update chunk to hold the data")
 	}
-	sha1decode, err = multihash.Decode(data)
+	ref, err := getUpdateDirect(rh, keys[1])
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !bytes.Equal(sha1decode.Digest, sha1bytes) {
-		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", sha1decode.Digest, sha1bytes)
+	if len(ref) != rh.dpa.hashFunc().Size() {
+		t.Fatalf("expected data reference of %d bytes, got %d", rh.dpa.hashFunc().Size(), len(ref))
+	}
+	if !rh.Validate(keys[1], chunk.SData) {
+		t.Fatal("expected update chunk with data reference to be valid")
 	}
-}
 
-func TestResourceChunkValidator(t *testing.T) {
-	// signer containing private key
-	signer, err := newTestSigner()
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if rsrc.version != 2 {
+		t.Fatalf("expected version 2, got %d", rsrc.version)
+	}
+	if !bytes.Equal(rsrc.data, large) {
+		t.Fatalf("expected reassembled data of %d bytes, got %d bytes", len(large), len(rsrc.data))
+	}
 
-	// ens address and transact options
-	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
-	transactOpts := bind.NewKeyedTransactor(signer.PrivKey)
+	// multihash updates must still fit in a chunk, and data is limited
+	if _, err := rh.UpdateMultihash(ctx, safeName, large); err == nil {
+		t.Fatal("expected error on large multihash update")
+	}
+	if _, err := rh.Update(ctx, safeName, make([]byte, MaxResourceDataSize+1)); err == nil {
+		t.Fatal("expected error on update exceeding the maximum data size")
+	}
+}
 
-	// set up ENS sim
-	domainparts := strings.Split(safeName, ".")
-	contractAddr, contractbackend, err := setupENS(addr, transactOpts, domainparts[0], domainparts[1])
+func (self *ResourceHandler) mustGetChunk(t *testing.T, key Key) *Chunk {
+	chunk, err := self.chunkStore.localStore.memStore.Get(key)
 	if err != nil {
 		t.Fatal(err)
 	}
+	return chunk
+}
 
-	ensClient, err := ens.NewENS(transactOpts, contractAddr, contractbackend)
+// returns the chunk data in the layout parsed by the handler
+func mustDecodeChunk(t *testing.T, chunk *Chunk) []byte {
+	data, err := decodeResourceChunk(chunk.SData)
 	if err != nil {
 		t.Fatal(err)
 	}
+	return data
+}
 
-	// set up rpc and create resourcehandler with ENS sim backend
-	rh, _, teardownTest, err := setupTest(contractbackend, ensClient, signer)
+// check that the binary search finds the latest update of a resource which
+// has not been updated for many periods in a logarithmic number of hops
+func TestResourceBinaryLookup(t *testing.T) {
+
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer teardownTest()
 
-	// create new resource when we are owner = ok
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	key, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
-	if err != nil {
-		t.Fatalf("Create resource fail: %v", err)
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
 	}
 
-	data := []byte("foo")
-	key = rh.resourceHash(1, 1, rsrc.nameHash)
-	digest := rh.keyDataHash(key, data)
-	sig, err := rh.signer.Sign(digest)
-	if err != nil {
-		t.Fatalf("sign fail: %v", err)
+	// update in each of the first periods, with several versions in the last one
+	periods := 5
+	for i := 0; i < periods; i++ {
+		fwdBlocks(int(resourceFrequency)-1, backend)
+		if _, err := rh.Update(ctx, safeName, []byte(fmt.Sprintf("update %d", i))); err != nil {
+			t.Fatal(err)
+		}
 	}
-	chunk := newUpdateChunk(key, &sig, 1, 1, safeName, data, len(data))
-	if !rh.Validate(chunk.Key, chunk.SData) {
-		t.Fatal("Chunk validator fail on update chunk")
+	if _, err := rh.UpdateBatch(ctx, safeName, [][]byte{[]byte("inky"), []byte("clyde")}); err != nil {
+		t.Fatal(err)
 	}
+	lastPeriod := rh.getResource(nameHash.Hex()).current().lastPeriod
 
-	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
-	startBlock, err := rh.getBlock(ctx, safeName)
+	// no updates for a long time
+	fwdBlocks(int(resourceFrequency)*100, backend)
+
+	params := &ResourceLookupParams{
+		Strategy: LinearLookup{},
+		MaxHops:  40,
+	}
+	if _, err := rh.LookupLatest(ctx, nameHash, true, params); err == nil {
+		t.Fatal("expected linear lookup to exceed the max period hops")
+	}
+	params.Strategy = BinaryLookup{}
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, params)
 	if err != nil {
 		t.Fatal(err)
 	}
-	chunk = rh.newMetaChunk(safeName, startBlock, resourceFrequency)
-	if !rh.Validate(chunk.Key, chunk.SData) {
-		t.Fatal("Chunk validator fail on metadata chunk")
+	if rsrc.lastPeriod != lastPeriod || rsrc.version != 3 {
+		t.Fatalf("expected period %d version 3, got period %d version %d", lastPeriod, rsrc.lastPeriod, rsrc.version)
 	}
-}
-
-// fast-forward blockheight
-func fwdBlocks(count int, backend *fakeBackend) {
-	for i := 0; i < count; i++ {
-		backend.Commit()
+	if !bytes.Equal(rsrc.data, []byte("clyde")) {
+		t.Fatalf("expected data %q, got %q", "clyde", rsrc.data)
 	}
 }
 
-type ensOwnerValidator struct {
-	*ens.ENS
-}
+// check that concurrent probing of the periods finds the same update as
+// sequential probing, and respects the max period hops
+func TestResourceLookupFanOut(t *testing.T) {
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
 
-func (e ensOwnerValidator) ValidateOwner(name string, address common.Address) (bool, error) {
-	addr, err := e.Owner(ens.EnsNode(name))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
 	if err != nil {
-		return false, err
+		t.Fatal(err)
 	}
-	return address == addr, nil
-}
 
-// create rpc and resourcehandler
-func setupTest(backend headerGetter, ensBackend *ens.ENS, signer ResourceSigner) (rh *ResourceHandler, datadir string, teardown func(), err error) {
+	// sparse updates, with several versions in the last period
+	for i := 0; i < 2; i++ {
+		fwdBlocks(int(resourceFrequency)*3, backend)
+		if _, err := rh.Update(ctx, safeName, []byte(fmt.Sprintf("update %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("blinky")); err != nil {
+		t.Fatal(err)
+	}
+	lastPeriod := rsrc.current().lastPeriod
 
-	var fsClean func()
-	var rpcClean func()
-	cleanF = func() {
-		if fsClean != nil {
-			fsClean()
+	gap := uint32(30)
+	for _, fanOut := range []int{1, 3, 8, 64} {
+		params := &ResourceLookupParams{
+			Strategy: ParallelLookup{FanOut: fanOut},
+			MaxHops:  gap - 1,
 		}
-		if rpcClean != nil {
-			rpcClean()
+		if _, err := rh.LookupHistorical(ctx, rsrc.nameHash, lastPeriod+gap, true, params); err == nil {
+			t.Fatalf("fan-out %d: expected lookup to exceed the max period hops", fanOut)
+		}
+		params.MaxHops = gap
+		found, err := rh.LookupHistorical(ctx, rsrc.nameHash, lastPeriod+gap, true, params)
+		if err != nil {
+			t.Fatalf("fan-out %d: %v", fanOut, err)
+		}
+		if found.lastPeriod != lastPeriod || found.version != 2 || !bytes.Equal(found.data, []byte("blinky")) {
+			t.Fatalf("fan-out %d: expected period %d version 2, got period %d version %d data %q", fanOut, lastPeriod, found.lastPeriod, found.version, found.data)
 		}
 	}
+}
 
-	// temp datadir
-	datadir, err = ioutil.TempDir("", "rh")
+// check that time based resources count periods in seconds and work without a chain
+// Timeouts are validated, and can be overridden per call with the context
+func TestResourceTimeouts(t *testing.T) {
+	for _, params := range []*ResourceHandlerParams{
+		{RetrieveTimeout: -time.Millisecond},
+		{StoreTimeout: maxResourceTimeout + time.Second},
+	} {
+		if _, err := NewResourceHandler(params); err == nil {
+			t.Fatalf("expected invalid timeouts %v/%v to be rejected", params.RetrieveTimeout, params.StoreTimeout)
+		}
+	}
+
+	rh, err := NewResourceHandler(&ResourceHandlerParams{
+		RetrieveTimeout: time.Second,
+	})
 	if err != nil {
-		return nil, "", nil, err
+		t.Fatal(err)
 	}
-	fsClean = func() {
-		os.RemoveAll(datadir)
+	ctx := context.Background()
+	if timeout := rh.getRetrieveTimeout(ctx); timeout != time.Second {
+		t.Fatalf("expected retrieve timeout %v, got %v", time.Second, timeout)
+	}
+	if timeout := rh.getStoreTimeout(ctx); timeout != defaultStoreTimeout {
+		t.Fatalf("expected default store timeout %v, got %v", defaultStoreTimeout, timeout)
 	}
 
-	var ov ownerValidator
-	if ensBackend != nil {
-		ov = ensOwnerValidator{ensBackend}
+	ctx = WithResourceTimeouts(ctx, 0, 10*time.Second)
+	if timeout := rh.getRetrieveTimeout(ctx); timeout != time.Second {
+		t.Fatalf("expected retrieve timeout %v, got %v", time.Second, timeout)
+	}
+	if timeout := rh.getStoreTimeout(ctx); timeout != 10*time.Second {
+		t.Fatalf("expected overridden store timeout %v, got %v", 10*time.Second, timeout)
 	}
 
-	rhparams := &ResourceHandlerParams{
-		QueryMaxPeriods: &ResourceLookupParams{
-			Limit: false,
-		},
-		Signer:         signer,
-		HeaderGetter:   backend,
-		OwnerValidator: ov,
+	ctx = WithResourceTimeouts(ctx, time.Hour, 0)
+	if timeout := rh.getRetrieveTimeout(ctx); timeout != time.Second {
+		t.Fatalf("expected invalid override to be ignored, got %v", timeout)
+	}
+}
+
+func TestResourceTimePeriods(t *testing.T) {
+
+	rh, _, teardownTest, err := setupTest(nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	clock := time.Unix(1500000000, 0)
+	rh.now = func() time.Time {
+		return clock
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err == nil {
+		t.Fatal("expected block based resource creation to fail without header getter")
+	}
+	key, rsrc, err := rh.NewResourceWithScheme(ctx, safeName, 60, TimePeriods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.startBlock != uint64(clock.Unix()) || rsrc.Scheme() != TimePeriods {
+		t.Fatalf("expected time resource starting at %d, got %v resource starting at %d", clock.Unix(), rsrc.Scheme(), rsrc.startBlock)
+	}
+
+	if _, err := rh.Update(ctx, safeName, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(time.Second * 59)
+	if _, err := rh.Update(ctx, safeName, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(time.Second)
+	if _, err := rh.Update(ctx, safeName, []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	if period, version := rh.getResource(nameHash.Hex()).current().lastPeriod, rh.getResource(nameHash.Hex()).current().version; period != 2 || version != 1 {
+		t.Fatalf("expected update in period 2 version 1, got period %d version %d", period, version)
+	}
+
+	// reload the resource from the metadata chunk
+	clock = clock.Add(time.Hour)
+	rh.resources.Purge()
+	rsrc, err = rh.LoadResource(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.Scheme() != TimePeriods || rsrc.frequency != 60 {
+		t.Fatalf("expected time resource with frequency 60, got %v resource with frequency %d", rsrc.Scheme(), rsrc.frequency)
+	}
+	view, err := rh.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(view.data, []byte("three")) {
+		t.Fatalf("expected data %q, got %q", "three", view.data)
+	}
+	view, err = rh.LookupHistorical(ctx, nameHash, 1, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(view.data, []byte("two")) {
+		t.Fatalf("expected data %q, got %q", "two", view.data)
+	}
+}
+
+// check that subscribers are notified of local updates and of updates found by polling
+func TestResourceSubscribe(t *testing.T) {
+
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	rh.pollInterval = time.Millisecond * 50
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key, _, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updates, unsubscribe := rh.Subscribe(nameHash)
+	defer unsubscribe()
+	expectUpdate := func(period uint32, version uint32, data string) {
+		select {
+		case update := <-updates:
+			if update.Period != period || update.Version != version || string(update.Data) != data || update.Name != safeName {
+				t.Fatalf("expected update %d.%d %q, got %d.%d %q", period, version, data, update.Period, update.Version, update.Data)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("no notification of update %d.%d", period, version)
+		}
+	}
+
+	// local update
+	if _, err := rh.Update(ctx, safeName, []byte("local")); err != nil {
+		t.Fatal(err)
+	}
+	expectUpdate(1, 1, "local")
+
+	// update by another handler on the same store
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if _, err := rh2.LoadResource(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LookupLatest(ctx, nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh2.Update(ctx, safeName, []byte("remote")); err != nil {
+		t.Fatal(err)
+	}
+	expectUpdate(2, 1, "remote")
+
+	// nothing new
+	select {
+	case update := <-updates:
+		t.Fatalf("unexpected notification of update %d.%d", update.Period, update.Version)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	unsubscribe()
+	if _, ok := <-updates; ok {
+		t.Fatal("expected closed channel after unsubscribing")
+	}
+	if len(rh.watches) != 0 {
+		t.Fatalf("expected no watches left, got %d", len(rh.watches))
+	}
+}
+
+// Following the chain heads, lookups need no header retrieval and
+// subscriptions look up updates as soon as a new period starts
+func TestResourceFollowHeads(t *testing.T) {
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	datadir, err := ioutil.TempDir("", "rh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	subscriber := &fakeHeads{
+		subs: make(chan chan<- *types.Header, 1),
+	}
+	rh, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+		HeaderGetter:    backend,
+		HeadSubscriber:  subscriber,
+		PollInterval:    time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+	var heads chan<- *types.Header
+	select {
+	case heads = <-subscriber.subs:
+	case <-time.After(time.Second):
+		t.Fatal("no head subscription")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates, unsubscribe := rh.Subscribe(nameHash)
+	defer unsubscribe()
+	waitPeriod := func(period uint32) {
+		for i := 0; i < 100; i++ {
+			if current, ok := rh.getHeadPeriod(rsrc); ok && current == period {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected period %d at the head", period)
+	}
+	heads <- &types.Header{Number: new(big.Int).SetUint64(rsrc.startBlock)}
+	waitPeriod(1)
+	if _, err := rh.Update(ctx, safeName, []byte("local")); err != nil {
+		t.Fatal(err)
+	}
+	<-updates
+
+	// update by another handler on the same store in the next period
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if _, err := rh2.LoadResource(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LookupLatest(ctx, nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh2.Update(ctx, safeName, []byte("remote")); err != nil {
+		t.Fatal(err)
+	}
+	blocknumber := backend.blocknumber
+
+	// the subscription does not wait for its next poll
+	heads <- &types.Header{Number: new(big.Int).SetUint64(rsrc.startBlock + resourceFrequency)}
+	select {
+	case update := <-updates:
+		if update.Period != 2 || string(update.Data) != "remote" {
+			t.Fatalf("expected update 2 %q, got %d %q", "remote", update.Period, update.Data)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("no notification of update in the new period")
+	}
+	if current, err := rh.getCurrent(ctx, rsrc); err != nil || current != rsrc.startBlock+resourceFrequency {
+		t.Fatalf("expected the head %d as the current block, got %d (%v)", rsrc.startBlock+resourceFrequency, current, err)
+	}
+	if backend.blocknumber != blocknumber {
+		t.Fatalf("expected no header retrieval, got %d", backend.blocknumber-blocknumber)
+	}
+}
+
+// The update of the next period of subscribed resources is retrieved before
+// the period starts
+func TestResourcePrefetch(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "rh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	rh, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+		PollInterval:    time.Hour,
+		RetrieveTimeout: 100 * time.Millisecond,
+		PrefetchAhead:   time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+	requests := make(chan Key, 16)
+	rh.chunkStore.retrieve = func(chunk *Chunk) error {
+		select {
+		case requests <- chunk.Key:
+		default:
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResourceWithScheme(ctx, safeName, 3600, TimePeriods); err != nil {
+		t.Fatal(err)
+	}
+	_, unsubscribe := rh.Subscribe(nameHash)
+	defer unsubscribe()
+
+	// the next period starts within the hour
+	expected := rh.resourceHash(2, 1, nameHash)
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case key := <-requests:
+			if bytes.Equal(key, expected) {
+				return
+			}
+		case <-timeout:
+			t.Fatal("no prefetch of the update of the next period")
+		}
+	}
+}
+
+// Sharded resources keep their index entries and chunks in the shard of their
+// namehash, and their chunks in the main store
+func TestResourceShards(t *testing.T) {
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, datadir, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	var stores []*LocalStore
+	for i := 0; i < 2; i++ {
+		params := NewDefaultLocalStoreParams()
+		params.Init(filepath.Join(datadir, fmt.Sprintf("shard%d", i)))
+		store, err := NewLocalStore(params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.Validators = append(store.Validators, NewContentAddressValidator(MakeHashFunc(resourceHash)), rh)
+		stores = append(stores, store)
+	}
+	if err := rh.SetShards(stores); err != nil {
+		t.Fatal(err)
+	}
+
+	// a resource in each half of the namehashes
+	names := make([]string, 2)
+	for i := 0; names[0] == "" || names[1] == ""; i++ {
+		name := fmt.Sprintf("shard%d.eth", i)
+		names[ResourceNameHash(name)[0]>>7] = name
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i, name := range names {
+		if _, _, err := rh.NewResource(ctx, name, resourceFrequency); err != nil {
+			t.Fatal(err)
+		}
+		key, err := rh.Update(ctx, name, []byte(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stores[i].Get(key); err != nil {
+			t.Fatalf("expected update of %s in shard %d: %v", name, i, err)
+		} else if _, err := stores[1-i].Get(key); err == nil {
+			t.Fatalf("expected update of %s not in shard %d", name, 1-i)
+		} else if _, err := rh.chunkStore.localStore.DbStore.Get(key); err != nil {
+			t.Fatalf("expected update of %s in the main store: %v", name, err)
+		}
+		// the main database holds the index and the stub of the chunk only
+		db := rh.chunkStore.localStore.DbStore
+		idata, err := db.db.Get(getIndexKey(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index dpaDBIndex
+		decodeIndex(idata, &index)
+		if data, err := db.db.Get(getDataKey(index.Idx, db.po(key))); err != nil || !isShardStub(data) {
+			t.Fatalf("expected stub of the update of %s in the main database, got %x (%v)", name, data, err)
+		}
+		if rh.shards[i].resources.Len() != 1 {
+			t.Fatalf("expected 1 resource in the index of shard %d, got %d", i, rh.shards[i].resources.Len())
+		}
+	}
+	if rh.resources.Len() != 0 {
+		t.Fatalf("expected no resources in the main index, got %d", rh.resources.Len())
+	}
+
+	// lookups read the shards
+	for _, name := range names {
+		rsrc, err := rh.LookupLatestByName(ctx, name, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rsrc.data, []byte(name)) {
+			t.Fatalf("expected data %q, got %q", name, rsrc.data)
+		}
+	}
+}
+
+// sets up a resource fixture with its chain at the start block in a temporary
+// directory
+func setupFixture(t *testing.T, params *ResourceHandlerParams) (*ResourceFixture, func()) {
+	datadir, err := ioutil.TempDir("", "rh-fixture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewResourceFixture(datadir, startBlock, params)
+	if err != nil {
+		os.RemoveAll(datadir)
+		t.Fatal(err)
+	}
+	return f, func() {
+		f.Close()
+		os.RemoveAll(datadir)
+	}
+}
+
+// updates roll over to the next period exactly at the block the period ends
+func TestResourcePeriodRollover(t *testing.T) {
+	f, teardown := setupFixture(t, nil)
+	defer teardown()
+	rh := f.Handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(period uint32, version uint32) {
+		t.Helper()
+		if _, err := rh.Update(ctx, safeName, []byte(fmt.Sprintf("%d.%d", period, version))); err != nil {
+			t.Fatal(err)
+		}
+		rsrc, err := rh.LookupLatest(ctx, nameHash, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rsrc.lastPeriod != period || rsrc.version != version {
+			t.Fatalf("block %d: expected update %d.%d, got %d.%d", f.BlockNumber(), period, version, rsrc.lastPeriod, rsrc.version)
+		}
+	}
+
+	// the creation block is the first block of period 1
+	expect(1, 1)
+	f.ManualBlockAdvance(resourceFrequency - 1)
+	expect(1, 2)
+	f.ManualBlockAdvance(1)
+	expect(2, 1)
+	f.ManualBlockAdvance(resourceFrequency*3 - 1)
+	expect(4, 1)
+	f.ManualBlockAdvance(1)
+	expect(5, 1)
+
+	// period 4 ends at the first block of period 5
+	if block, err := rh.PeriodToBlock(nameHash.Hex(), 4); err != nil || block != f.BlockNumber() {
+		t.Fatalf("expected period 4 to end at block %d, got %d (%v)", f.BlockNumber(), block, err)
+	}
+}
+
+// period numbers fit 32 bits and the ends of periods 64 bits, past that the
+// period arithmetic fails with ErrPeriodOverflow instead of wrapping around
+func TestResourcePeriodOverflow(t *testing.T) {
+	for _, c := range []struct {
+		start, current, frequency uint64
+		period                    uint32
+	}{
+		{0, 0, 1, 1},
+		{0, math.MaxUint32 - 2, 1, math.MaxUint32 - 1},
+		{0, math.MaxUint32 - 1, 1, math.MaxUint32},
+		{0, math.MaxUint32, 1, 0},
+		{0, math.MaxUint64, 1, 0},
+		{100, 100 + (math.MaxUint32-1)*2 + 1, 2, math.MaxUint32},
+		{100, 100 + math.MaxUint32*2, 2, 0},
+		{0, math.MaxUint64, math.MaxUint64, 2},
+	} {
+		period, err := getNextPeriod(c.start, c.current, c.frequency)
+		if c.period == 0 {
+			if !IsPeriodOverflow(err) {
+				t.Fatalf("expected period of %d from %d every %d to overflow, got %d (%v)", c.current, c.start, c.frequency, period, err)
+			}
+			continue
+		}
+		if err != nil || period != c.period {
+			t.Fatalf("expected period %d of %d from %d every %d, got %d (%v)", c.period, c.current, c.start, c.frequency, period, err)
+		}
+	}
+
+	if end, err := getPeriodOffset(10, 1<<32, math.MaxUint32); err != nil || end != 10+math.MaxUint32<<32 {
+		t.Fatalf("expected end %d, got %d (%v)", uint64(10+math.MaxUint32<<32), end, err)
+	}
+	if _, err := getPeriodOffset(1<<32, 1<<32, math.MaxUint32); !IsPeriodOverflow(err) {
+		t.Fatalf("expected end past the block range to overflow, got %v", err)
+	}
+	if _, err := getPeriodOffset(math.MaxUint64, 1, 1); !IsPeriodOverflow(err) {
+		t.Fatalf("expected end past the block range to overflow, got %v", err)
+	}
+}
+
+// a handler which did not look up the updates of another one reuses their
+// versions, the chunk stored first is kept
+func TestResourceVersionCollision(t *testing.T) {
+	f, teardown := setupFixture(t, nil)
+	defer teardown()
+	rh := f.Handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, _, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a second node on the same chain takes the next version after its lookup
+	rh2, err := f.Attach(f.Chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LoadResource(rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LookupLatest(ctx, nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	key2, err := rh2.Update(ctx, safeName, []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := rh2.GetVersion(nameHash.Hex()); version != 2 {
+		t.Fatalf("expected version 2, got %d", version)
+	}
+
+	// the first handler computes the same version from its stale index
+	key1, err := rh.Update(ctx, safeName, []byte("third"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key1, key2) {
+		t.Fatalf("expected colliding keys, got %x and %x", key1, key2)
+	}
+	rsrc, err := rh2.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.version != 2 || !bytes.Equal(rsrc.data, []byte("second")) {
+		t.Fatalf("expected version 2 with data %q, got version %d with data %q", "second", rsrc.version, rsrc.data)
+	}
+
+	// in the next period the versions start over
+	f.ManualBlockAdvance(resourceFrequency)
+	if _, err := rh2.Update(ctx, safeName, []byte("fourth")); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := rh2.GetVersion(nameHash.Hex()); version != 1 {
+		t.Fatalf("expected version 1, got %d", version)
+	}
+}
+
+// a handler whose chain is behind the start block of a resource cannot look
+// it up or update it until the chain reaches the start block
+func TestResourceFutureStartBlock(t *testing.T) {
+	f, teardown := setupFixture(t, nil)
+	defer teardown()
+	rh := f.Handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, _, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	lagging := NewManualChain(startBlock - 10)
+	rh2, err := f.Attach(lagging)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsrc, err := rh2.LoadResource(rootKey)
+	if err != nil {
+		t.Fatal(err)
+	} else if rsrc.startBlock != startBlock {
+		t.Fatalf("expected start block %d, got %d", startBlock, rsrc.startBlock)
+	}
+	if _, err := rh2.LookupLatest(ctx, nameHash, true, nil); !isResourceError(err, ErrInvalidValue) {
+		t.Fatalf("expected invalid value error looking up a resource starting in the future, got %v", err)
+	}
+	if _, err := rh2.Update(ctx, safeName, []byte("bar")); err == nil {
+		t.Fatal("expected update of a resource starting in the future to fail")
+	}
+
+	lagging.ManualBlockAdvance(10)
+	view, err := rh2.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.lastPeriod != 1 || !bytes.Equal(view.data, []byte("foo")) {
+		t.Fatalf("expected update 1 with data %q, got %d with data %q", "foo", view.lastPeriod, view.data)
+	}
+}
+
+// the chain of a fixture with a block time grows with its clock, and the
+// handlers sharing it roll over to the next period at the same block
+func TestResourceBlockTime(t *testing.T) {
+	f, teardown := setupFixture(t, nil)
+	defer teardown()
+	f.Chain.SetBlockTime(15 * time.Second)
+	rh := f.Handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	frequency := uint64(4)
+	rootKey, _, err := rh.NewResource(ctx, safeName, frequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2, err := f.Attach(f.Chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LoadResource(rootKey); err != nil {
+		t.Fatal(err)
+	}
+
+	update := func(data string, block uint64, period uint32, version uint32) {
+		t.Helper()
+		if number := f.BlockNumber(); number != block {
+			t.Fatalf("expected block %d, got %d", block, number)
+		}
+		if _, err := rh.Update(ctx, safeName, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		for i, h := range []*ResourceHandler{rh, rh2} {
+			view, err := h.LookupLatest(ctx, nameHash, true, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if view.lastPeriod != period || view.version != version || string(view.data) != data {
+				t.Fatalf("handler %d at block %d: expected update %d.%d with data %q, got %d.%d with data %q", i, block, period, version, data, view.lastPeriod, view.version, view.data)
+			}
+		}
+	}
+
+	// less than a block time does not grow the chain, the rest carries over
+	f.AdvanceTime(14 * time.Second)
+	update("a", startBlock, 1, 1)
+	f.AdvanceTime(31 * time.Second)
+	update("b", startBlock+frequency-1, 1, 2)
+	// the update exactly at the boundary is the first of the next period
+	f.AdvanceTime(15 * time.Second)
+	update("c", startBlock+frequency, 2, 1)
+
+	// lookups spanning the boundary
+	view, err := rh2.LookupHistorical(ctx, nameHash, 1, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.lastPeriod != 1 || view.version != 2 || string(view.data) != "b" {
+		t.Fatalf("expected update 1.2 with data %q, got %d.%d with data %q", "b", view.lastPeriod, view.version, view.data)
+	}
+	updates, err := rh2.LookupRange(ctx, nameHash, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var datas []string
+	for _, u := range updates {
+		datas = append(datas, string(u.Data))
+	}
+	if strings.Join(datas, ",") != "a,b,c" {
+		t.Fatalf("expected updates a,b,c across the boundary, got %v", datas)
+	}
+
+	// the headers are spaced by the block time
+	header, err := f.Chain.HeaderByNumber(ctx, safeName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := uint64(resourceFixtureEpoch + (startBlock+frequency)*15); header.Time.Uint64() != expected {
+		t.Fatalf("expected header time %d, got %d", expected, header.Time.Uint64())
+	}
+}
+
+// counts the header retrievals of the chain
+type countingHeaders struct {
+	headerGetter
+	count int32
+}
+
+func (c *countingHeaders) HeaderByNumber(ctx context.Context, name string, number *big.Int) (*types.Header, error) {
+	atomic.AddInt32(&c.count, 1)
+	return c.headerGetter.HeaderByNumber(ctx, name, number)
+}
+
+// many resources are looked up with one block height retrieval
+func TestResourceLookupLatestMany(t *testing.T) {
+	f, teardown := setupFixture(t, nil)
+	defer teardown()
+	rh := f.Handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var nameHashes []common.Hash
+	for i := 0; i < lookupManyWorkers+4; i++ {
+		name := fmt.Sprintf("many%d.eth", i)
+		if _, _, err := rh.NewResource(ctx, name, resourceFrequency); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rh.Update(ctx, name, []byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		nameHashes = append(nameHashes, ResourceNameHash(name))
+	}
+	f.ManualBlockAdvance(resourceFrequency * 2)
+
+	// duplicates are looked up once, unknown resources fail
+	unknown := ResourceNameHash("unknown.eth")
+	nameHashes = append(nameHashes, nameHashes[0], unknown)
+	counter := &countingHeaders{headerGetter: rh.headerGetter}
+	rh.headerGetter = counter
+	results := rh.LookupLatestMany(ctx, nameHashes)
+	if len(results) != lookupManyWorkers+5 {
+		t.Fatalf("expected %d results, got %d", lookupManyWorkers+5, len(results))
+	}
+	if count := atomic.LoadInt32(&counter.count); count != 1 {
+		t.Fatalf("expected 1 header retrieval, got %d", count)
+	}
+	for i := 0; i < lookupManyWorkers+4; i++ {
+		name := fmt.Sprintf("many%d.eth", i)
+		result := results[ResourceNameHash(name)]
+		if result.Err != nil {
+			t.Fatalf("%s: %v", name, result.Err)
+		}
+		if result.Resource.lastPeriod != 1 || !bytes.Equal(result.Resource.data, []byte(name)) {
+			t.Fatalf("%s: expected update 1 with data %q, got %d with data %q", name, name, result.Resource.lastPeriod, result.Resource.data)
+		}
+	}
+	if result := results[unknown]; result.Err == nil || result.Resource != nil {
+		t.Fatalf("expected lookup of unknown resource to fail, got %v", result.Resource)
+	}
+}
+
+// the updates of a follow-list are merged newest first, with reposts merged
+// into the newest update with the same data
+func TestResourceFeedAggregator(t *testing.T) {
+	f, teardown := setupFixture(t, nil)
+	defer teardown()
+	rh := f.Handler
+	clock := time.Unix(1500000000, 0)
+	rh.now = func() time.Time { return clock }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, name := range []string{"alice.eth", "bob.eth", "carol.eth"} {
+		if _, _, err := rh.NewResource(ctx, name, resourceFrequency); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, update := range []struct {
+		name string
+		data string
+	}{
+		{"alice.eth", "a1"},
+		{"bob.eth", "b1"},
+		{"alice.eth", "a2"},
+		{"carol.eth", "b1"}, // repost
+		{"bob.eth", "b2"},
+	} {
+		clock = clock.Add(10 * time.Second)
+		f.ManualBlockAdvance(resourceFrequency)
+		if _, err := rh.Update(ctx, update.name, []byte(update.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	unknown := ResourceNameHash("unknown.eth")
+	agg := rh.NewFeedAggregator([]common.Hash{ResourceNameHash("alice.eth"), ResourceNameHash("bob.eth"), ResourceNameHash("carol.eth"), ResourceNameHash("alice.eth"), unknown})
+	var stream []*FeedItem
+	for _, n := range []int{2, 10, 10} {
+		items, err := agg.Next(ctx, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream = append(stream, items...)
+	}
+	expected := []struct {
+		name string
+		data string
+		also []string
+	}{
+		{"bob.eth", "b2", nil},
+		{"carol.eth", "b1", []string{"bob.eth"}},
+		{"alice.eth", "a2", nil},
+		{"alice.eth", "a1", nil},
+	}
+	if len(stream) != len(expected) {
+		t.Fatalf("expected %d updates, got %d", len(expected), len(stream))
+	}
+	for i, item := range stream {
+		if item.Name() != expected[i].name || !bytes.Equal(item.data, []byte(expected[i].data)) || len(item.Also) != len(expected[i].also) {
+			t.Fatalf("update %d: expected %s %q also in %v, got %s %q also in %v", i, expected[i].name, expected[i].data, expected[i].also, item.Name(), item.data, item.Also)
+		}
+		for j, name := range expected[i].also {
+			if item.Also[j] != ResourceNameHash(name) {
+				t.Fatalf("update %d: expected repost by %s, got %v", i, name, item.Also[j])
+			}
+		}
+	}
+	if errs := agg.Errors(); len(errs) != 1 || errs[unknown] == nil {
+		t.Fatalf("expected the unknown feed to fail, got %v", errs)
+	}
+}
+
+// resources are looked up as they were at a block
+func TestResourceLookupAt(t *testing.T) {
+	f, teardown := setupFixture(t, nil)
+	defer teardown()
+	rh := f.Handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	f.ManualBlockAdvance(resourceFrequency * 2)
+	if _, err := rh.Update(ctx, safeName, []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		at     uint64
+		period uint32
+		data   string
+	}{
+		{startBlock, 1, "one"},
+		{startBlock + resourceFrequency + 1, 1, "one"}, // no update in period 2
+		{startBlock + resourceFrequency*2, 3, "three"},
+		{startBlock + resourceFrequency*10, 3, "three"}, // the future is now
+	} {
+		rsrc, err := rh.LookupAt(ctx, nameHash, c.at, true, nil)
+		if err != nil {
+			t.Fatalf("at %d: %v", c.at, err)
+		}
+		if rsrc.lastPeriod != c.period || !bytes.Equal(rsrc.data, []byte(c.data)) {
+			t.Fatalf("at %d: expected update %d with data %q, got %d with data %q", c.at, c.period, c.data, rsrc.lastPeriod, rsrc.data)
+		}
+	}
+
+	// there is nothing before the start
+	if _, err := rh.LookupAt(ctx, nameHash, startBlock-1, true, nil); err == nil {
+		t.Fatal("expected lookup before the start block to fail")
+	}
+}
+
+// check that lookups return views which do not change with later lookups and
+// updates, and that looking up earlier updates does not rewind the handler
+func TestResourceViews(t *testing.T) {
+	f, teardown := setupFixture(t, nil)
+	defer teardown()
+	rh := f.Handler
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	f.ManualBlockAdvance(resourceFrequency * 2)
+	if _, err := rh.Update(ctx, safeName, []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := rh.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(view *ResourceView, period uint32, data string) {
+		t.Helper()
+		vdata, err := view.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if view.Period() != period || !bytes.Equal(vdata, []byte(data)) {
+			t.Fatalf("expected update %d with data %q, got %d with data %q", period, data, view.Period(), vdata)
+		}
+	}
+	check(latest, 3, "two")
+
+	// concurrent lookups of different updates get their own views
+	var wg sync.WaitGroup
+	errC := make(chan error, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			view, err := rh.LookupHistorical(ctx, nameHash, 1, true, nil)
+			if err == nil && !bytes.Equal(view.data, []byte("one")) {
+				err = fmt.Errorf("expected data %q in period 1, got %q", "one", view.data)
+			}
+			errC <- err
+		}()
+		go func() {
+			defer wg.Done()
+			view, err := rh.LookupPrevious(ctx, latest, nil)
+			if err == nil && !bytes.Equal(view.data, []byte("one")) {
+				err = fmt.Errorf("expected previous data %q, got %q", "one", view.data)
+			}
+			errC <- err
+		}()
+	}
+	wg.Wait()
+	close(errC)
+	for err := range errC {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(latest, 3, "two")
+	if _, data, err := rh.GetContent(nameHash.Hex()); err != nil || !bytes.Equal(data, []byte("two")) {
+		t.Fatalf("expected lookups of earlier updates to leave the latest update %q, got %q (%v)", "two", data, err)
+	}
+
+	if _, err := rh.Update(ctx, safeName, []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	check(latest, 3, "two")
+	if _, data, err := rh.GetContent(nameHash.Hex()); err != nil || !bytes.Equal(data, []byte("three")) {
+		t.Fatalf("expected content %q after update, got %q (%v)", "three", data, err)
+	}
+}
+
+// create ENS enabled resource update, with and without valid owner
+func TestResourceENSOwner(t *testing.T) {
+
+	// signer containing private key
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ens address and transact options
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	transactOpts := bind.NewKeyedTransactor(signer.PrivKey)
+
+	// set up ENS sim
+	domainparts := strings.Split(safeName, ".")
+	contractAddr, contractbackend, err := setupENS(addr, transactOpts, domainparts[0], domainparts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensClient, err := ens.NewENS(transactOpts, contractAddr, contractbackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// set up rpc and create resourcehandler with ENS sim backend
+	rh, _, teardownTest, err := setupTest(contractbackend, ensClient, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	// create new resource when we are owner = ok
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _, err = rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatalf("Create resource fail: %v", err)
+	}
+
+	data := []byte("foo")
+	// update resource when we are owner = ok
+	_, err = rh.Update(ctx, safeName, data)
+	if err != nil {
+		t.Fatalf("Update resource fail: %v", err)
+	}
+
+	// update resource when we are not owner = !ok
+	signertwo, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh.signer = signertwo
+	_, err = rh.Update(ctx, safeName, data)
+	if err == nil {
+		t.Fatalf("Expected resource update fail due to owner mismatch")
+	}
+
+	// the data of large updates is not stored when we are not owner
+	large := make([]byte, 3*chunkSize+42)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+	ref, wait, err := NewDPA(NewMapChunkStore(), NewDPAParams()).Store(bytes.NewReader(large), int64(len(large)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	if _, err := rh.Update(ctx, safeName, large); err == nil || err.(*ResourceError).Code() != ErrUnauthorized {
+		t.Fatalf("Expected unauthorized error for large update, got %v", err)
+	}
+	if _, err := rh.chunkStore.getLocal(ref); err == nil {
+		t.Fatal("Expected the data of the unauthorized update not to be stored")
+	}
+}
+
+// test that users own their feeds on a topic without name registration, and
+// that the feeds of different users on the same topic are separate resources
+func TestResourceTopic(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	otherUser := crypto.PubkeyToAddress(other.PrivKey.PublicKey)
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	topic := NewTopic([]byte("weather"))
+	name := topic.Name(user)
+	if !isSafeName(name) {
+		t.Fatalf("expected topic name '%s' to be safe", name)
+	}
+	parsedTopic, parsedUser, err := ParseTopicName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsedTopic != topic || parsedUser != user {
+		t.Fatalf("expected topic %x of %x, got %x of %x", topic, user, parsedTopic, parsedUser)
+	}
+	if _, _, err := ParseTopicName(strings.ToUpper(name[:2*TopicLength]) + name[2*TopicLength:]); err == nil {
+		t.Fatal("expected topic name in upper case to be invalid")
+	}
+	if ResourceNameHash(name) == ResourceNameHash(topic.Name(otherUser)) {
+		t.Fatal("expected feeds of different users on the same topic to have different name hashes")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, topic.Name(otherUser), resourceFrequency); err == nil {
+		t.Fatal("expected creating the feed of another user to fail")
+	}
+	rootKey, rsrc, err := rh.NewResource(ctx, name, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.nameHash != ResourceNameHash(name) {
+		t.Fatalf("expected name hash %x, got %x", ResourceNameHash(name), rsrc.nameHash)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	key, err := rh.Update(ctx, name, []byte("sunny"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := rh.chunkStore.get(key, defaultRetrieveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update signed by the user to be valid")
+	}
+	if _, err := rh.UpdateWithSigner(ctx, name, []byte("rainy"), other); err == nil {
+		t.Fatal("expected update signed by another user to fail")
+	}
+
+	// the other user publishes on the same topic independently
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+		Signer:       other,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	otherRootKey, _, err := rh2.NewResource(ctx, topic.Name(otherUser), resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.Update(ctx, topic.Name(otherUser), []byte("rainy")); err != nil {
+		t.Fatal(err)
+	}
+
+	rh3, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh3.SetStore(rh.chunkStore)
+	for _, feed := range []struct {
+		root Key
+		name string
+		data string
+	}{
+		{rootKey, name, "sunny"},
+		{otherRootKey, topic.Name(otherUser), "rainy"},
+	} {
+		if _, err := rh3.LoadResource(feed.root); err != nil {
+			t.Fatal(err)
+		}
+		rsrc, err := rh3.LookupLatestByName(ctx, feed.name, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rsrc.data, []byte(feed.data)) {
+			t.Fatalf("expected data %q of '%s', got %q", feed.data, feed.name, rsrc.data)
+		}
+	}
+}
+
+// test that a feed is created on a raw topic without a name, and is updated
+// and looked up by the name of the topic feed of the signer
+func TestResourceNewWithTopic(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	topic := common.HexToHash("0x1f2a")
+	_, rsrc, err := rh.NewResourceWithTopic(ctx, topic, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := Topic(topic).Name(crypto.PubkeyToAddress(signer.PrivKey.PublicKey))
+	if rsrc.Name() != name {
+		t.Fatalf("expected the topic feed '%s' of the signer, got '%s'", name, rsrc.Name())
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.Update(ctx, rsrc.Name(), []byte("hello room")); err != nil {
+		t.Fatal(err)
+	}
+	view, err := rh.LookupLatest(ctx, ResourceNameHash(name), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(view.data, []byte("hello room")) {
+		t.Fatalf("expected data 'hello room', got %q", view.data)
+	}
+
+	// the feeds on topics are owned by their signers
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if _, _, err := rh2.NewResourceWithTopic(ctx, topic, resourceFrequency); !isResourceError(err, ErrInit) {
+		t.Fatalf("expected ErrInit without a signer, got %v", err)
+	}
+}
+
+// authorizes the updaters of a single contract
+type testACLValidator struct {
+	contract common.Address
+	updater  common.Address
+}
+
+func (v *testACLValidator) ValidateUpdater(contract common.Address, name string, address common.Address) (bool, error) {
+	return contract == v.contract && address == v.updater, nil
+}
+
+func TestResourceACL(t *testing.T) {
+
+	// signer containing private key
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ens address and transact options
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	transactOpts := bind.NewKeyedTransactor(signer.PrivKey)
+
+	// set up ENS sim
+	domainparts := strings.Split(safeName, ".")
+	contractAddr, contractbackend, err := setupENS(addr, transactOpts, domainparts[0], domainparts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensClient, err := ens.NewENS(transactOpts, contractAddr, contractbackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// set up rpc and create resourcehandler with ENS sim backend
+	rh, _, teardownTest, err := setupTest(contractbackend, ensClient, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	signertwo, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerthree, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	aclContract := common.HexToAddress("0x1234")
+	rh.aclValidator = &testACLValidator{
+		contract: aclContract,
+		updater:  crypto.PubkeyToAddress(signerthree.PrivKey.PublicKey),
+	}
+
+	// the owner creates a resource which can be updated by the second signer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key, _, err := rh.NewResourceWithACL(ctx, safeName, resourceFrequency, BlockPeriods, &ResourceACL{
+		Owners: []common.Address{crypto.PubkeyToAddress(signertwo.PrivKey.PublicKey)},
+	})
+	if err != nil {
+		t.Fatalf("Create resource fail: %v", err)
+	}
+
+	data := []byte("foo")
+	rh.signer = signertwo
+	if _, err := rh.Update(ctx, safeName, data); err != nil {
+		t.Fatalf("Update by listed owner fail: %v", err)
+	}
+	rh.signer = signerthree
+	if _, err := rh.Update(ctx, safeName, data); err == nil {
+		t.Fatal("Expected update by unlisted address to fail")
+	}
+	rh.signer = signer
+	if _, err := rh.Update(ctx, safeName, data); err != nil {
+		t.Fatalf("Update by ENS owner fail: %v", err)
+	}
+
+	// updaters authorized by the contract
+	if _, _, err = rh.NewResourceWithACL(ctx, safeName, resourceFrequency, BlockPeriods, &ResourceACL{
+		Contract: aclContract,
+	}); err != nil {
+		t.Fatalf("Create resource fail: %v", err)
+	}
+	rh.signer = signerthree
+	if _, err := rh.Update(ctx, safeName, data); err != nil {
+		t.Fatalf("Update by contract authorized address fail: %v", err)
+	}
+	rh.signer = signertwo
+	if _, err := rh.Update(ctx, safeName, data); err == nil {
+		t.Fatal("Expected update by address not authorized by contract to fail")
+	}
+
+	// an access control list not signed by the ENS owner is rejected
+	forged := &resource{
+		name:       safeName,
+		startBlock: startBlock,
+		frequency:  resourceFrequency,
+		acl: &ResourceACL{
+			Owners: []common.Address{crypto.PubkeyToAddress(signertwo.PrivKey.PublicKey)},
+		},
+	}
+	digest, err := rh.aclDigest(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if forged.aclSignature, err = signertwo.Sign(digest); err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := rh.newMetaChunk(forged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh.chunkStore.Put(chunk)
+	if _, err := rh.LoadResource(chunk.Key); err == nil {
+		t.Fatal("Expected forged access control list to be rejected")
+	} else if rerr, ok := err.(*ResourceError); !ok || rerr.Code() != ErrUnauthorized {
+		t.Fatalf("Expected unauthorized error, got %v", err)
+	}
+
+	// the access control list signed by the owner is loaded from the metadata chunk
+	rsrc, err := rh.LoadResource(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acl := rsrc.ACL(); acl == nil || len(acl.Owners) != 1 || acl.Owners[0] != crypto.PubkeyToAddress(signertwo.PrivKey.PublicKey) {
+		t.Fatalf("unexpected access control list %v", acl)
+	}
+
+	// handlers which did not load the resource validate against the access
+	// control list of its metadata chunk in the local store
+	if err := rh.chunkStore.PutResourceRoot(rsrc.nameHash, key); err != nil {
+		t.Fatal(err)
+	}
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		Signer:         signertwo,
+		HeaderGetter:   contractbackend,
+		OwnerValidator: rh.ownerValidator,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	listed := newTestUpdateChunk(t, rh2, rh2.resourceHash(42, 1, rsrc.nameHash), 42, 1, data)
+	if !rh2.Validate(listed.Key, listed.SData) {
+		t.Fatal("Expected update by listed owner to be valid on a handler which did not load the resource")
+	}
+	rh2.signer = signerthree
+	unlisted := newTestUpdateChunk(t, rh2, rh2.resourceHash(42, 2, rsrc.nameHash), 42, 2, data)
+	if rh2.Validate(unlisted.Key, unlisted.SData) {
+		t.Fatal("Expected update by unlisted address to be invalid on a handler which did not load the resource")
+	}
+}
+
+func TestResourceMultihash(t *testing.T) {
+
+	// signer containing private key
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// make fake backend, set up rpc and create resourcehandler
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+
+	// set up rpc and create resourcehandler
+	rh, datadir, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	// create a new resource
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, _, err = rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// we're naïvely assuming keccak256 for swarm hashes
+	// if it ever changes this test should also change
+	swarmhashbytes := ens.EnsNode("foo")
+	swarmhashmulti, err := multihash.Encode(swarmhashbytes.Bytes(), multihash.KECCAK_256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmhashkey, err := rh.UpdateMultihash(ctx, safeName, swarmhashmulti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blake2bbytes := make([]byte, multihash.DefaultLengths[multihash.BLAKE2B_MIN+31])
+	blake2bmulti, err := multihash.Encode(blake2bbytes, multihash.BLAKE2B_MIN+31)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blake2bkey, err := rh.UpdateMultihash(ctx, safeName, blake2bmulti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid multihashes
+	_, err = rh.UpdateMultihash(ctx, safeName, swarmhashmulti[1:])
+	if err == nil {
+		t.Fatalf("Expected update to fail with first byte skipped")
+	}
+	_, err = rh.UpdateMultihash(ctx, safeName, swarmhashmulti[:len(swarmhashmulti)-2])
+	if err == nil {
+		t.Fatalf("Expected update to fail with last byte skipped")
+	}
+
+	data, err := getUpdateDirect(rh, swarmhashkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmhashdecode, err := multihash.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(swarmhashdecode.Digest, swarmhashbytes.Bytes()) {
+		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", swarmhashdecode.Digest, swarmhashbytes.Bytes())
+	}
+	data, err = getUpdateDirect(rh, blake2bkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blake2bdecode, err := multihash.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blake2bdecode.Digest, blake2bbytes) {
+		t.Fatalf("Decoded BLAKE2B hash '%x' does not match original hash '%x'", blake2bdecode.Digest, blake2bbytes)
+	}
+	rh.Close()
+
+	rhparams := &ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+		Signer:         signer,
+		HeaderGetter:   rh.headerGetter,
+		OwnerValidator: rh.ownerValidator,
+	}
+	// test with signed data
+	rh.chunkStore.localStore.Close()
+	rh2, err := NewTestResourceHandler(datadir, rhparams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = rh2.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmhashsignedkey, err := rh2.UpdateMultihash(ctx, safeName, swarmhashmulti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blake2bsignedkey, err := rh2.UpdateMultihash(ctx, safeName, blake2bmulti)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = getUpdateDirect(rh2, swarmhashsignedkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmhashdecode, err = multihash.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(swarmhashdecode.Digest, swarmhashbytes.Bytes()) {
+		t.Fatalf("Decoded SHA1 hash '%x' does not match original hash '%x'", swarmhashdecode.Digest, swarmhashbytes.Bytes())
+	}
+	data, err = getUpdateDirect(rh2, blake2bsignedkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blake2bdecode, err = multihash.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blake2bdecode.Digest, blake2bbytes) {
+		t.Fatalf("Decoded BLAKE2B hash '%x' does not match original hash '%x'", blake2bdecode.Digest, blake2bbytes)
+	}
+}
+
+// multihash updates are checked against the known codes and their digest
+// lengths
+func TestResourceMultihashCodes(t *testing.T) {
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+
+	encode := func(length int, code uint64) []byte {
+		mh, err := multihash.Encode(make([]byte, length), code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mh
+	}
+	sha1multi := encode(multihash.DefaultLengths[multihash.SHA1], multihash.SHA1)
+	for _, test := range []struct {
+		desc string
+		data []byte
+		ok   bool
+	}{
+		{"keccak-256", encode(32, multihash.KECCAK_256), true},
+		{"sha2-256", encode(32, multihash.SHA2_256), true},
+		{"blake2b-160", encode(20, multihash.BLAKE2B_MIN+19), true},
+		{"short keccak-256", encode(20, multihash.KECCAK_256), false},
+		{"long sha2-256", encode(64, multihash.SHA2_256), false},
+		{"blake2b-160 of blake2b-256 length", encode(32, multihash.BLAKE2B_MIN+19), false},
+		{"sha1", sha1multi, false},
+		{"trailing data", append(encode(32, multihash.KECCAK_256), 0x00), false},
+	} {
+		_, err := rh.UpdateMultihash(ctx, safeName, test.data)
+		if test.ok && err != nil {
+			t.Fatalf("%s: %v", test.desc, err)
+		} else if !test.ok && !isResourceError(err, ErrInvalidValue) {
+			t.Fatalf("%s: expected invalid value error, got %v", test.desc, err)
+		}
+	}
+
+	// unknown codes are only checked for their structure if allowed
+	rh.allowUnknownMultihash = true
+	if _, err := rh.UpdateMultihash(ctx, safeName, sha1multi); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.UpdateMultihash(ctx, safeName, sha1multi[:len(sha1multi)-1]); !isResourceError(err, ErrCorruptData) {
+		t.Fatalf("expected corrupt data error for truncated sha1 multihash, got %v", err)
+	}
+	if _, err := rh.UpdateMultihash(ctx, safeName, encode(20, multihash.KECCAK_256)); !isResourceError(err, ErrInvalidValue) {
+		t.Fatalf("expected invalid value error for short keccak-256 multihash, got %v", err)
+	}
+}
+
+func TestResourceChunkValidator(t *testing.T) {
+	// signer containing private key
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ens address and transact options
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	transactOpts := bind.NewKeyedTransactor(signer.PrivKey)
+
+	// set up ENS sim
+	domainparts := strings.Split(safeName, ".")
+	contractAddr, contractbackend, err := setupENS(addr, transactOpts, domainparts[0], domainparts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ensClient, err := ens.NewENS(transactOpts, contractAddr, contractbackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// set up rpc and create resourcehandler with ENS sim backend
+	rh, _, teardownTest, err := setupTest(contractbackend, ensClient, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	// create new resource when we are owner = ok
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	key, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatalf("Create resource fail: %v", err)
+	}
+
+	data := []byte("foo")
+	key = rh.resourceHash(1, 1, rsrc.nameHash)
+	chunk := newTestUpdateChunk(t, rh, key, 1, 1, data)
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("Chunk validator fail on update chunk")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	startBlock, err := rh.getBlock(ctx, safeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err = rh.newMetaChunk(&resource{
+		name:       safeName,
+		startBlock: startBlock,
+		frequency:  resourceFrequency,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("Chunk validator fail on metadata chunk")
+	}
+}
+
+// test that resource errors wrap their cause and carry the resource, period
+// and version of the failed operation
+func TestResourceErrors(t *testing.T) {
+	cause := errors.New("disk on fire")
+	err := WrapResourceError(ErrIO, "Could not store update data", cause)
+	if err.Error() != "Could not store update data: disk on fire" {
+		t.Fatalf("unexpected error message %q", err.Error())
+	}
+	if !errors.Is(err, cause) {
+		t.Fatal("expected error to wrap its cause")
+	}
+	if code, ok := ResourceErrorCode(fmt.Errorf("update failed: %w", err)); !ok || code != ErrIO {
+		t.Fatalf("expected code %d of wrapped resource error, got %d", ErrIO, code)
+	}
+	if _, ok := ResourceErrorCode(cause); ok {
+		t.Fatal("expected no code for other errors")
+	}
+
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = rh.Update(ctx, safeName, []byte("foo"))
+	if !IsNotFound(err) {
+		t.Fatalf("expected update of unknown resource to be not found, got %v", err)
+	}
+	if rerr := err.(*ResourceError); rerr.Name() != safeName {
+		t.Fatalf("expected error of resource '%s', got '%s'", safeName, rerr.Name())
+	}
+
+	_, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rh.LookupVersion(ctx, rsrc.nameHash, 1, 3, true, nil)
+	rerr, ok := err.(*ResourceError)
+	if !ok {
+		t.Fatalf("expected resource error, got %v", err)
+	}
+	if rerr.Name() != safeName || rerr.Period() != 1 || rerr.Version() != 3 {
+		t.Fatalf("expected error of '%s' 1.3, got '%s' %d.%d", safeName, rerr.Name(), rerr.Period(), rerr.Version())
+	}
+	if IsNotSynced(err) || IsGone(err) {
+		t.Fatalf("unexpected error code %d", rerr.Code())
+	}
+}
+
+// updates too far ahead of the current period are rejected
+func TestResourceFuturePeriod(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("foo")
+	newChunk := func(period uint32) *Chunk {
+		return newTestUpdateChunk(t, rh, rh.resourceHash(period, 1, rsrc.nameHash), period, 1, data)
+	}
+
+	// the current period and the next one are valid
+	for period := uint32(1); period <= 1+defaultMaxFuturePeriods; period++ {
+		chunk := newChunk(period)
+		if !rh.Validate(chunk.Key, chunk.SData) {
+			t.Fatalf("expected update of period %d to be valid", period)
+		}
+	}
+	chunk := newChunk(2 + defaultMaxFuturePeriods)
+	if rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update in the future to be invalid")
+	}
+
+	// the period becomes valid in time, once the recent block height expired
+	fwdBlocks(int(resourceFrequency), backend)
+	time.Sleep(recentBlockTTL)
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update of the next period to be valid")
+	}
+
+	// resources which are not loaded are checked with their stored metadata
+	// chunk, validation does not wait for the block height
+	rh.resources.Purge()
+	headers := &blockingHeaders{headerGetter: rh.headerGetter, releaseC: make(chan struct{})}
+	rh.headerGetter = headers
+	time.Sleep(recentBlockTTL)
+	chunk = newChunk(3 + defaultMaxFuturePeriods)
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update to be valid while the block height is retrieved")
+	}
+	close(headers.releaseC)
+	for i := 0; ; i++ {
+		if _, ok := rh.recentBlock(safeName); ok {
+			break
+		} else if i == 100 {
+			t.Fatal("block height was not retrieved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update in the future of a resource which is not loaded to be invalid")
+	}
+}
+
+// a header getter waiting to be released
+type blockingHeaders struct {
+	headerGetter
+	releaseC chan struct{}
+}
+
+func (c *blockingHeaders) HeaderByNumber(ctx context.Context, name string, number *big.Int) (*types.Header, error) {
+	select {
+	case <-c.releaseC:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return c.headerGetter.HeaderByNumber(ctx, name, number)
+}
+
+// with strict validation, updates of periods earlier than the newest seen are
+// rejected and conflicting versions are recorded
+func TestResourceStrictValidation(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	rh.strict = newResourceStrictness()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newChunk := func(period uint32, data string) *Chunk {
+		return newTestUpdateChunk(t, rh, rh.resourceHash(period, 1, rsrc.nameHash), period, 1, []byte(data))
+	}
+
+	chunk := newChunk(2, "foo")
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update to be valid")
+	}
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected the same update to be valid again")
+	}
+	if len(rh.Conflicts(rsrc.nameHash)) != 0 {
+		t.Fatal("expected no conflicts for the same update")
+	}
+
+	stale := newChunk(1, "foo")
+	if rh.Validate(stale.Key, stale.SData) {
+		t.Fatal("expected update of an earlier period to be invalid")
+	}
+
+	other := newChunk(2, "bar")
+	if !rh.Validate(other.Key, other.SData) {
+		t.Fatal("expected conflicting update to be valid")
+	}
+	conflicts := rh.Conflicts(rsrc.nameHash)
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %d", len(conflicts))
+	}
+	if c := conflicts[0]; !bytes.Equal(c.Key, chunk.Key) || c.Period != 2 || c.Version != 1 || c.DataHash != crypto.Keccak256Hash(mustDecodeChunk(t, chunk)) {
+		t.Fatalf("unexpected conflict %v", c)
+	}
+
+	// periods which cannot be bounded by a handler which has not loaded the
+	// resource are not recorded as the newest
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		Signer:       signer,
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.strict = newResourceStrictness()
+	future := newChunk(math.MaxUint32, "foo")
+	if !rh2.Validate(future.Key, future.SData) {
+		t.Fatal("expected update of an unloaded resource to be valid")
+	}
+	next := newChunk(3, "foo")
+	if !rh2.Validate(next.Key, next.SData) {
+		t.Fatal("expected update after an unbounded period to be valid")
+	}
+}
+
+// test that lookups of a deleted resource fail with ErrGone, and that the
+// updates preceding the tombstone are garbage collected first
+func TestResourceTombstone(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []Key
+	for _, data := range []string{"foo", "bar"} {
+		fwdBlocks(int(resourceFrequency), backend)
+		key, err := rh.Update(ctx, safeName, []byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	tombstoneKey, err := rh.UpdateTombstone(ctx, safeName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rh.LookupLatest(ctx, rsrc.nameHash, true, nil); !IsGone(err) {
+		t.Fatalf("expected lookup of deleted resource to fail with ErrGone, got %v", err)
+	}
+	if _, _, err := rh.GetContent(rsrc.nameHash.Hex()); !IsGone(err) {
+		t.Fatalf("expected content of deleted resource to fail with ErrGone, got %v", err)
+	}
+	if _, err := rh.Update(ctx, safeName, []byte("baz")); !IsGone(err) {
+		t.Fatalf("expected update of deleted resource to fail with ErrGone, got %v", err)
+	}
+
+	// updates following the tombstone are invalid
+	period := rsrc.current().lastPeriod
+	key := rh.resourceHash(period, rsrc.current().version+1, rsrc.nameHash)
+	chunk := newTestUpdateChunk(t, rh, key, period, rsrc.current().version+1, []byte("baz"))
+	if rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update after tombstone to be invalid")
+	}
+
+	// another handler finds the tombstone
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if _, err := rh2.LoadResource(rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LookupLatest(ctx, rsrc.nameHash, true, nil); !IsGone(err) {
+		t.Fatalf("expected lookup of deleted resource to fail with ErrGone, got %v", err)
+	}
+	if tombstone := rh2.getTombstone(rsrc.nameHash.Hex()); tombstone == nil || !bytes.Equal(tombstone.key, tombstoneKey) {
+		t.Fatalf("expected tombstone %v, got %v", tombstoneKey, tombstone)
+	}
+
+	// the updates are collected before other chunks, the tombstone stays
+	ldb := rh.chunkStore.localStore.DbStore
+	ldb.lock.Lock()
+	collected := ldb.collectGarbage(0)
+	ldb.lock.Unlock()
+	if collected != len(keys) {
+		t.Fatalf("expected %d chunks collected, got %d", len(keys), collected)
+	}
+	for _, key := range keys {
+		if _, err := ldb.Get(key); err == nil {
+			t.Fatalf("expected update %v to be garbage collected", key)
+		}
+	}
+	for _, key := range []Key{rootKey, tombstoneKey} {
+		if _, err := ldb.Get(key); err != nil {
+			t.Fatalf("expected chunk %v to be kept: %v", key, err)
+		}
+	}
+}
+
+// test that updates signed with a revoked key are invalid and skipped by lookups
+func TestResourceRevocation(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.Update(ctx, safeName, []byte("good")); err != nil {
+		t.Fatal(err)
+	}
+	goodPeriod := rsrc.current().lastPeriod
+
+	// another key updates the resource in the next period
+	compromised, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+		Signer:       compromised,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if _, err := rh2.LoadResource(rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LookupLatest(ctx, rsrc.nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	evilKey, err := rh2.Update(ctx, safeName, []byte("evil"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	evilChunk, err := rh.chunkStore.get(evilKey, defaultRetrieveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// revoke the key after the period of the good update
+	address := crypto.PubkeyToAddress(compromised.PrivKey.PublicKey)
+	if _, err := rh.RevokeKey(ctx, safeName, address, goodPeriod); err != nil {
+		t.Fatal(err)
+	}
+	if rh.Validate(evilChunk.Key, evilChunk.SData) {
+		t.Fatal("expected update signed with revoked key to be invalid")
+	}
+	for _, h := range []*ResourceHandler{rh, rh2} {
+		rsrc, err := h.LookupLatest(ctx, rsrc.nameHash, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(rsrc.data, []byte("good")) || rsrc.lastPeriod != goodPeriod {
+			t.Fatalf("expected update before revocation, got %q in period %d", rsrc.data, rsrc.lastPeriod)
+		}
+	}
+	if revocations := rh2.Revocations(rsrc.nameHash); len(revocations) != 1 || revocations[0].Address != address || revocations[0].Period != goodPeriod {
+		t.Fatalf("unexpected revocations %v", revocations)
+	}
+	if _, err := rh2.Update(ctx, safeName, []byte("evil")); err == nil {
+		t.Fatal("expected update with revoked key to fail")
+	}
+
+	// unsigned revocations are invalid, also for handlers which do not sign
+	unsigned, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, revocationDataLength)
+	copy(data, crypto.PubkeyToAddress(signer.PrivKey.PublicKey).Bytes())
+	forgedKey := unsigned.resourceHash(0, 2, rsrc.nameHash)
+	forged := newUpdateChunk(forgedKey, nil, nil, 0, 2, safeName, data, resourceRevocationFlag)
+	if unsigned.Validate(forged.Key, forged.SData) {
+		t.Fatal("expected unsigned revocation chunk to be invalid")
+	}
+	if _, err := unsigned.parseRevocation(rsrc, forged); err == nil {
+		t.Fatal("expected unsigned revocation to be rejected")
+	}
+}
+
+// Anchors change the frequency of a resource, lookups follow them
+func TestResourceReanchor(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.Update(ctx, safeName, []byte("one")); err != nil {
+		t.Fatal(err)
+	} else if rsrc.current().lastPeriod != 2 {
+		t.Fatalf("expected update in period 2, got %d", rsrc.current().lastPeriod)
+	}
+
+	// the anchor doubles the frequency from the next period on
+	anchorKey, err := rh.Reanchor(ctx, safeName, resourceFrequency*2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchors := rh.Anchors(rsrc.nameHash)
+	if len(anchors) != 1 || anchors[0].Period != 3 || anchors[0].Frequency != resourceFrequency*2 {
+		t.Fatalf("unexpected anchors %v", anchors)
+	}
+	anchorStart := anchors[0].StartBlock
+	for i, data := range []string{"two", "three", "four"} {
+		if i > 0 {
+			fwdBlocks(int(resourceFrequency), backend)
+		}
+		if _, err := rh.Update(ctx, safeName, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if expected := uint32(3 + i/2); rsrc.current().lastPeriod != expected {
+			t.Fatalf("expected update %q in period %d, got %d", data, expected, rsrc.current().lastPeriod)
+		}
+	}
+	if end, err := rh.PeriodToBlock(rsrc.nameHash.Hex(), 3); err != nil || end != anchorStart+resourceFrequency*2 {
+		t.Fatalf("expected period 3 to end at %d, got %d (%v)", anchorStart+resourceFrequency*2, end, err)
+	}
+
+	// another handler follows the anchor once it loads the resource
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+		Signer:       signer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if _, err := rh2.LoadResource(rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if anchors2 := rh2.Anchors(rsrc.nameHash); len(anchors2) != 1 || anchors2[0] != anchors[0] {
+		t.Fatalf("expected anchors %v, got %v", anchors, anchors2)
+	}
+	latest, err := rh2.LookupLatest(ctx, rsrc.nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(latest.data, []byte("four")) || latest.lastPeriod != 4 {
+		t.Fatalf("expected latest update in period 4, got %q in period %d", latest.data, latest.lastPeriod)
+	}
+
+	anchorChunk, err := rh.chunkStore.get(anchorKey, defaultRetrieveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rh2.Validate(anchorChunk.Key, anchorChunk.SData) {
+		t.Fatal("expected anchor chunk to be valid")
+	}
+	if _, err := rh.Reanchor(ctx, safeName, 0); err == nil {
+		t.Fatal("expected anchor with frequency 0 to fail")
+	}
+
+	// unsigned anchors are invalid, also for handlers which do not sign
+	unsigned, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, anchorDataLength)
+	binary.LittleEndian.PutUint32(data, 10)
+	binary.LittleEndian.PutUint64(data[4:], anchorStart+resourceFrequency*10)
+	binary.LittleEndian.PutUint64(data[12:], 1)
+	forgedKey := unsigned.resourceHash(0, resourceAnchorVersionOffset+2, rsrc.nameHash)
+	forged := newUpdateChunk(forgedKey, nil, nil, 0, resourceAnchorVersionOffset+2, safeName, data, 0)
+	if unsigned.Validate(forged.Key, forged.SData) {
+		t.Fatal("expected unsigned anchor chunk to be invalid")
+	}
+	if _, err := unsigned.parseAnchor(rsrc, forged); err == nil {
+		t.Fatal("expected unsigned anchor to be rejected")
+	}
+}
+
+// Updates signed on behalf of another key need the access of that key
+func TestResourceUpdateWithSigner(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	acl := &ResourceACL{
+		Owners: []common.Address{crypto.PubkeyToAddress(user.PrivKey.PublicKey)},
+	}
+	_, rsrc, err := rh.NewResourceWithACL(ctx, safeName, resourceFrequency, BlockPeriods, acl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+
+	if _, err := rh.UpdateWithSigner(ctx, safeName, []byte("stranger"), stranger); err == nil {
+		t.Fatal("expected update signed by key without access to fail")
+	}
+	key, err := rh.UpdateWithSigner(ctx, safeName, []byte("user"), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := rh.chunkStore.get(key, defaultRetrieveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update signed by user to be valid")
+	}
+	chunkdata := mustDecodeChunk(t, chunk)
+	signature, _, _, _, _, _, err := rh.parseUpdate(chunkdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := rh.recoverSigner(rsrc.nameHash, key, chunkdata, []byte("user"), signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != crypto.PubkeyToAddress(user.PrivKey.PublicKey) {
+		t.Fatalf("expected update signed by %x, got %x", crypto.PubkeyToAddress(user.PrivKey.PublicKey), addr)
+	}
+	if !bytes.Equal(rsrc.current().data, []byte("user")) {
+		t.Fatalf("expected resource data %q, got %q", "user", rsrc.current().data)
+	}
+
+	// handlers without signer do not sign at all
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if _, err := rh2.UpdateWithSigner(ctx, safeName, []byte("user"), user); err == nil {
+		t.Fatal("expected update with signer on handler without signer to fail")
+	}
+}
+
+// test that one handler publishes the feeds of several users, with the signer
+// selected per call or registered for the feed
+func TestResourceSigners(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	topic := NewTopic([]byte("news"))
+	aliceName := topic.Name(crypto.PubkeyToAddress(alice.PrivKey.PublicKey))
+	bobName := topic.Name(crypto.PubkeyToAddress(bob.PrivKey.PublicKey))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	aliceCtx := WithResourceSigner(ctx, alice)
+	if _, _, err := rh.NewResource(aliceCtx, aliceName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rh.NewResource(ctx, bobName, resourceFrequency); err == nil {
+		t.Fatal("expected creating a feed with the signer of the handler to fail")
+	}
+	if err := rh.SetSigner(bobName, bob); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rh.NewResource(ctx, bobName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+
+	for _, update := range []struct {
+		ctx  context.Context
+		name string
+	}{
+		{aliceCtx, aliceName},
+		{ctx, bobName},
+	} {
+		key, err := rh.Update(update.ctx, update.name, []byte("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, err := rh.chunkStore.get(key, defaultRetrieveTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rh.Validate(chunk.Key, chunk.SData) {
+			t.Fatalf("expected update of '%s' to be valid", update.name)
+		}
+	}
+
+	// the signer of the context takes precedence over the registered signer
+	if _, err := rh.Update(aliceCtx, bobName, []byte("hello")); err == nil {
+		t.Fatal("expected update signed by another user to fail")
+	}
+	if err := rh.SetSigner(bobName, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Update(ctx, bobName, []byte("hello")); err == nil {
+		t.Fatal("expected update with the signer of the handler to fail after removing the registered signer")
+	}
+
+	// handlers without signer do not sign at all
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if err := rh2.SetSigner(aliceName, alice); err == nil {
+		t.Fatal("expected registering a signer with a handler without signer to fail")
+	}
+	if _, err := rh2.Update(aliceCtx, aliceName, []byte("hello")); err == nil {
+		t.Fatal("expected update with signer on handler without signer to fail")
+	}
+}
+
+// test that resources can be looked up by name on handlers which were never
+// given their metadata chunk key, once it was learned by a handler on the store
+func TestResourceRoot(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, _, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.Update(ctx, safeName, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	key, err := rh2.ResourceRoot(ResourceNameHash(safeName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, rootKey) {
+		t.Fatalf("expected metadata key %v, got %v", rootKey, key)
+	}
+	rsrc, err := rh2.LookupLatestByName(ctx, safeName, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc.data, []byte("hello")) {
+		t.Fatalf("expected data 'hello', got '%s'", rsrc.data)
+	}
+
+	if _, err := rh2.ResourceRoot(ResourceNameHash("unknown.eth")); err == nil {
+		t.Fatal("expected no metadata key of an unknown resource")
+	}
+	if _, err := rh2.LookupLatestByName(ctx, "unknown.eth", true, nil); err == nil {
+		t.Fatal("expected lookup of an unknown resource to fail")
+	}
+
+	// loading a forged metadata chunk does not replace the key learned before
+	forged, err := rh.newMetaChunk(&resource{
+		name:       safeName,
+		startBlock: startBlock + 1,
+		frequency:  resourceFrequency,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh.chunkStore.Put(forged)
+	rh3, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh3.SetStore(rh.chunkStore)
+	if _, err := rh3.LoadResource(forged.Key); err != nil {
+		t.Fatal(err)
+	}
+	rh4, err := NewResourceHandler(&ResourceHandlerParams{
+		HeaderGetter: backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh4.SetStore(rh.chunkStore)
+	if key, err := rh4.ResourceRoot(ResourceNameHash(safeName)); err != nil || !bytes.Equal(key, rootKey) {
+		t.Fatalf("expected metadata key %v after loading a forged metadata chunk, got %v (%v)", rootKey, key, err)
+	}
+}
+
+// test that the chunks of resources opted in to refresh are offered to the
+// peers again, and only those
+func TestResourceRefresh(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	if err := rh.SetRefresh(safeName, true); err == nil {
+		t.Fatal("expected opting in to refresh to fail with refresh disabled")
+	}
+
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{
+		Signer:          signer,
+		HeaderGetter:    backend,
+		RefreshInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	defer close(rh2.refreshQuitC)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, _, err := rh2.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	updateKey, err := rh2.Update(ctx, safeName, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// storage index of the chunk, which is what syncing goes by
+	ldb := rh.chunkStore.localStore.DbStore
+	storageIndex := func(key Key) uint64 {
+		idata, err := ldb.db.Get(getIndexKey(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index dpaDBIndex
+		decodeIndex(idata, &index)
+		return index.Idx
+	}
+	rootIdx, updateIdx := storageIndex(rootKey), storageIndex(updateKey)
+
+	rh2.refresh()
+	if storageIndex(rootKey) != rootIdx || storageIndex(updateKey) != updateIdx {
+		t.Fatal("expected chunks of a resource not opted in to stay in place")
+	}
+
+	if err := rh2.SetRefresh(safeName, true); err != nil {
+		t.Fatal(err)
+	}
+	rh2.refresh()
+	if storageIndex(rootKey) <= rootIdx {
+		t.Fatal("expected the metadata chunk to be resynced")
+	}
+	if storageIndex(updateKey) <= updateIdx {
+		t.Fatal("expected the update chunk to be resynced")
+	}
+	chunk, err := rh.chunkStore.get(updateKey, defaultRetrieveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected resynced update to stay valid")
+	}
+
+	rootIdx, updateIdx = storageIndex(rootKey), storageIndex(updateKey)
+	if err := rh2.SetRefresh(safeName, false); err != nil {
+		t.Fatal(err)
+	}
+	rh2.refresh()
+	if storageIndex(rootKey) != rootIdx || storageIndex(updateKey) != updateIdx {
+		t.Fatal("expected chunks of a resource opted out to stay in place")
+	}
+}
+
+// test that the data of private resources is encrypted with the secret and
+// only readers knowing the secret can decrypt it
+func TestResourceEncryption(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("open sesame")
+	rh.SetSecret(safeName, secret)
+
+	data := []byte("private feed")
+	large := make([]byte, chunkSize+1)
+	copy(large, data)
+	for _, update := range [][]byte{data, large} {
+		fwdBlocks(int(resourceFrequency), backend)
+		key, err := rh.Update(ctx, safeName, update)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunk, err := rh.chunkStore.get(key, defaultRetrieveTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isEncryptedUpdate(mustDecodeChunk(t, chunk)) {
+			t.Fatal("expected update to be encrypted")
+		} else if bytes.Contains(chunk.SData, data) {
+			t.Fatal("expected update chunk not to contain the plaintext")
+		} else if !rh.Validate(chunk.Key, chunk.SData) {
+			t.Fatal("expected encrypted update to be valid")
+		}
+		_, _, _, name, _, _, err := rh.parseUpdate(mustDecodeChunk(t, chunk))
+		if err != nil {
+			t.Fatal(err)
+		} else if name != safeName {
+			t.Fatalf("expected update of %q, got %q", safeName, name)
+		}
+		if _, content, err := rh.GetContent(rsrc.nameHash.Hex()); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(content, update) {
+			t.Fatalf("expected content of %d bytes, got %d bytes", len(update), len(content))
+		}
+
+		// readers without the secret or with the wrong one cannot decrypt the data
+		rh2, err := NewResourceHandler(&ResourceHandlerParams{
+			HeaderGetter: backend,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rh2.SetStore(rh.chunkStore)
+		if _, err := rh2.LoadResource(rootKey); err != nil {
+			t.Fatal(err)
+		}
+		for _, readerSecret := range [][]byte{nil, []byte("open barley")} {
+			rh2.SetSecret(safeName, readerSecret)
+			if _, err := rh2.LookupLatest(ctx, rsrc.nameHash, true, nil); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := rh2.GetContent(rsrc.nameHash.Hex()); err == nil || err.(*ResourceError).Code() != ErrEncrypted {
+				t.Fatalf("expected content to fail with ErrEncrypted, got %v", err)
+			}
+		}
+		rh2.SetSecret(safeName, secret)
+		if _, err := rh2.LookupLatest(ctx, rsrc.nameHash, true, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, content, err := rh2.GetContent(rsrc.nameHash.Hex()); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(content, update) {
+			t.Fatalf("expected decrypted content of %d bytes, got %d bytes", len(update), len(content))
+		}
+	}
+
+	// updates made after removing the secret are not encrypted
+	rh.SetSecret(safeName, nil)
+	fwdBlocks(int(resourceFrequency), backend)
+	key, err := rh.Update(ctx, safeName, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk, err := rh.chunkStore.get(key, defaultRetrieveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isEncryptedUpdate(mustDecodeChunk(t, chunk)) {
+		t.Fatal("expected update without secret not to be encrypted")
+	}
+}
+
+// check that the lookup strategies find the latest update, and that the hint
+// strategy needs a single retrieval when the hinted period is the latest
+func TestResourceLookupStrategies(t *testing.T) {
+	updates := map[uint32]bool{2: true, 3: true, 4: true, 9: true}
+	var probes uint32
+	query := &ResourcePeriodQuery{
+		Period: 12,
+		Probe: func(period uint32) *Chunk {
+			atomic.AddUint32(&probes, 1)
+			if updates[period] {
+				return NewChunk(Key{byte(period)}, nil)
+			}
+			return nil
+		},
+	}
+	for _, name := range []string{"linear", "parallel", "hint", "binary"} {
+		strategy, err := ParseResourceLookupStrategy(name, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, hint := range []uint32{0, 4, 9, 10} {
+			query.Hint = hint
+			period, chunk, err := strategy.Search(query)
+			if err != nil {
+				t.Fatalf("%v with hint %d: %v", strategy, hint, err)
+			}
+			if period != 9 || chunk.Key[0] != 9 {
+				t.Fatalf("%v with hint %d: expected update of period 9, got period %d", strategy, hint, period)
+			}
+		}
+	}
+
+	// the binary search probes back one by one if it skipped later updates,
+	// found by probing the skipped periods or given by the hint
+	for _, sparse := range []struct {
+		updates map[uint32]bool
+		hint    uint32
+	}{
+		{map[uint32]bool{1: true, 5: true}, 0},
+		{map[uint32]bool{1: true, 6: true}, 6},
+	} {
+		updates = sparse.updates
+		query.Period, query.Hint = 10, sparse.hint
+		period, _, err := (BinaryLookup{}).Search(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !updates[period] || period == 1 {
+			t.Fatalf("expected latest update of sparse updates %v, got period %d", updates, period)
+		}
+	}
+
+	// the steps back from the last period do not overflow
+	updates = map[uint32]bool{1: true, 2: true}
+	query.Period, query.Hint = math.MaxUint32, 0
+	if period, _, err := (BinaryLookup{}).Search(query); err != nil || period != 2 {
+		t.Fatalf("expected update of period 2 from the last period, got period %d: %v", period, err)
+	}
+
+	probes = 0
+	updates = map[uint32]bool{2: true, 3: true, 4: true, 9: true}
+	query.Period, query.Hint = 9, 9
+	if period, _, err := (HintFirstLookup{}).Search(query); err != nil || period != 9 || probes != 1 {
+		t.Fatalf("expected hinted update found with one probe, got period %d with %d probes: %v", period, probes, err)
+	}
+	if _, err := ParseResourceLookupStrategy("bogus", 0); err == nil {
+		t.Fatal("expected unknown strategy to fail")
+	}
+}
+
+// check that chunks of the unversioned format and of the legacy layout remain
+// readable, and chunks of unknown formats are rejected
+func TestResourceChunkFormats(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	key, err := rh.Update(ctx, safeName, []byte("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the unversioned metadata chunk has the content address of its data
+	legacyMeta := mustDecodeChunk(t, rh.mustGetChunk(t, rootKey))
+	if resourceChunkFormat(legacyMeta) != resourceFormatLegacy {
+		t.Fatal("expected unversioned metadata chunk")
+	}
+	testHasher.Reset()
+	testHasher.Write(legacyMeta)
+	metaChunk := NewChunk(testHasher.Sum(nil), nil)
+	metaChunk.SData = legacyMeta
+	if !rh.Validate(metaChunk.Key, metaChunk.SData) {
+		t.Fatal("expected unversioned metadata chunk to be valid")
+	}
+	rh.chunkStore.Put(metaChunk)
+	if _, err := rh.LoadResource(metaChunk.Key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the update chunks of format 1 and the unversioned ones with header have
+	// the legacy layout. No chunks are made in the unversioned format, its
+	// chunks are the ones of format 1 without prefix.
+	if _, err := resourceCodecs[resourceFormatLegacy].encode(mustDecodeChunk(t, rh.mustGetChunk(t, key))); err == nil {
+		t.Fatal("expected encoding in the unversioned format to fail")
+	}
+	for _, format := range []uint8{resourceFormatLegacy, resourceFormatV1} {
+		updateChunk := NewChunk(key, nil)
+		updateChunk.SData, err = resourceCodecs[resourceFormatV1].encode(mustDecodeChunk(t, rh.mustGetChunk(t, key)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format == resourceFormatLegacy {
+			updateChunk.SData = updateChunk.SData[resourceFormatPrefixLength:]
+		}
+		if resourceChunkFormat(updateChunk.SData) != format {
+			t.Fatalf("expected update chunk of format %d, got %d", format, resourceChunkFormat(updateChunk.SData))
+		}
+		if !rh.Validate(updateChunk.Key, updateChunk.SData) {
+			t.Fatalf("expected update chunk of format %d to be valid", format)
+		}
+		view, err := rh.updateResourceIndex(rsrc, updateChunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(view.data, []byte("foo")) {
+			t.Fatalf("expected data %q, got %q", "foo", view.data)
+		}
+	}
+
+	// data longer than the 12 bits of the legacy data length fits the
+	// current format only
+	large := newUpdateChunk(key, &Signature{}, nil, 2, 1, safeName, make([]byte, legacyUpdateMaxLength+1), 0)
+	if _, err := resourceCodecs[resourceFormatV1].encode(mustDecodeChunk(t, large)); err == nil {
+		t.Fatal("expected data too long for the legacy layout to fail")
+	}
+	if _, _, _, _, data, _, err := rh.parseUpdate(mustDecodeChunk(t, large)); err != nil || len(data) != legacyUpdateMaxLength+1 {
+		t.Fatalf("expected data of %d bytes, got %d (%v)", legacyUpdateMaxLength+1, len(data), err)
+	}
+
+	unknown := make([]byte, len(rh.mustGetChunk(t, key).SData))
+	copy(unknown, rh.mustGetChunk(t, key).SData)
+	unknown[2] = resourceFormatCurrent + 1
+	if rh.Validate(key, unknown) {
+		t.Fatal("expected chunk of unknown format to be invalid")
+	}
+}
+
+// test that updates carry their creation time and signer, and that the
+// signer in the header must match the signature
+func TestResourceMetadata(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, _, teardownTest, err := setupTest(nil, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	clock := time.Unix(1500000000, 0)
+	rh.now = func() time.Time {
+		return clock
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, rsrc, err := rh.NewResourceWithScheme(ctx, safeName, 60, TimePeriods)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.ResourceMetadata(rsrc.nameHash.Hex()); err == nil {
+		t.Fatal("expected metadata of resource without updates to fail")
+	}
+	clock = clock.Add(time.Minute)
+	if _, err := rh.Update(ctx, safeName, []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	meta, err := rh.ResourceMetadata(rsrc.nameHash.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !meta.ModTime.Equal(clock) || meta.Signer != addr || meta.Name != safeName || meta.Period != 2 || meta.Version != 1 {
+		t.Fatalf("expected update of %x at %v in period 2 version 1, got %+v", addr, clock, meta)
+	}
+
+	// lookups find the same metadata
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	rh2.now = rh.now
+	if _, err := rh2.LoadResource(rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh2.LookupLatest(ctx, rsrc.nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	meta2, err := rh2.ResourceMetadata(rsrc.nameHash.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *meta2 != *meta {
+		t.Fatalf("expected metadata %+v, got %+v", meta, meta2)
+	}
+
+	// the signer in the header must be the one of the signature, and the
+	// signature covers the creation time
+	chunk := newTestUpdateChunk(t, rh, rh.resourceHash(2, 2, rsrc.nameHash), 2, 2, []byte("bar"))
+	if !rh.Validate(chunk.Key, chunk.SData) {
+		t.Fatal("expected update to be valid")
+	}
+	forged := make([]byte, len(chunk.SData))
+	copy(forged, chunk.SData)
+	forged[resourceFormatPrefixLength+resourceUpdateHeaderOffset+9] ^= 0xff
+	if rh.Validate(chunk.Key, forged) {
+		t.Fatal("expected update with forged signer to be invalid")
+	}
+	copy(forged, chunk.SData)
+	forged[resourceFormatPrefixLength+resourceUpdateHeaderOffset+1] ^= 0xff
+	if rh.Validate(chunk.Key, forged) {
+		t.Fatal("expected update with forged creation time to be invalid")
+	}
+}
+
+// middleware recording the publications, and rejecting updates with the
+// data "reject"
+type testUpdateMiddleware struct {
+	signed []*ResourcePublication
+	stored []*ResourcePublication
+}
+
+func (m *testUpdateMiddleware) PreSign(ctx context.Context, pub *ResourcePublication) error {
+	if bytes.Equal(pub.Data, []byte("reject")) {
+		return errors.New("rejected by policy")
+	}
+	m.signed = append(m.signed, pub)
+	return nil
+}
+
+func (m *testUpdateMiddleware) PostStore(ctx context.Context, pub *ResourcePublication) {
+	m.stored = append(m.stored, pub)
+}
+
+// test that the update middleware sees the updates before signing and after
+// storing, and can reject them
+func TestResourceUpdateMiddleware(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	m := &testUpdateMiddleware{}
+	rh.AddUpdateMiddleware(m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, rsrc, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	keys, err := rh.UpdateBatch(ctx, safeName, [][]byte{[]byte("foo"), []byte("bar")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.signed) != 2 || len(m.stored) != 2 {
+		t.Fatalf("expected 2 updates signed and stored, got %d and %d", len(m.signed), len(m.stored))
+	}
+	addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	for i, pub := range m.stored {
+		if !bytes.Equal(pub.Key, keys[i]) || pub.Version != uint32(i+1) || pub.Name != safeName || pub.Signer != addr {
+			t.Fatalf("unexpected publication %d: %+v", i, pub)
+		}
+	}
+
+	// a rejected update fails the whole batch
+	if _, err := rh.UpdateBatch(ctx, safeName, [][]byte{[]byte("baz"), []byte("reject")}); err == nil {
+		t.Fatal("expected rejected update to fail")
+	} else if rerr, ok := err.(*ResourceError); !ok || rerr.Code() != ErrUnauthorized {
+		t.Fatalf("expected unauthorized error, got %v", err)
+	}
+	if len(m.stored) != 2 {
+		t.Fatalf("expected no more updates stored, got %d", len(m.stored))
+	}
+	if rsrc.current().version != 2 || !bytes.Equal(rsrc.current().data, []byte("bar")) {
+		t.Fatalf("expected version 2 with data %q, got version %d with data %q", "bar", rsrc.current().version, rsrc.current().data)
+	}
+	if _, err := rh.chunkStore.get(rh.resourceHash(rsrc.current().lastPeriod, 3, rsrc.nameHash), defaultRetrieveTimeout); err == nil {
+		t.Fatal("expected update of rejected batch not to be stored")
+	}
+}
+
+// creates an update chunk of the test resource signed by the signer of the handler
+func newTestUpdateChunk(t *testing.T, rh *ResourceHandler, key Key, period uint32, version uint32, data []byte) *Chunk {
+	modTime := uint64(rh.now().Unix())
+	digest := rh.keyDataHash(ens.EnsNode(safeName), key, modTime, data)
+	sig, err := rh.signer.Sign(digest)
+	if err != nil {
+		t.Fatalf("sign fail: %v", err)
+	}
+	addr, err := getAddressFromDataSig(digest, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := &resourceUpdateHeader{
+		modTime: modTime,
+		signer:  addr,
+	}
+	return newUpdateChunk(key, &sig, header, period, version, safeName, data, 0)
+}
+
+// fast-forward blockheight
+func fwdBlocks(count int, backend *fakeBackend) {
+	for i := 0; i < count; i++ {
+		backend.Commit()
+	}
+}
+
+type ensOwnerValidator struct {
+	*ens.ENS
+}
+
+func (e ensOwnerValidator) ValidateOwner(name string, address common.Address) (bool, error) {
+	addr, err := e.Owner(ens.EnsNode(name))
+	if err != nil {
+		return false, err
+	}
+	return address == addr, nil
+}
+
+// create rpc and resourcehandler
+func setupTest(backend headerGetter, ensBackend *ens.ENS, signer ResourceSigner) (rh *ResourceHandler, datadir string, teardown func(), err error) {
+
+	var fsClean func()
+	var rpcClean func()
+	cleanF = func() {
+		if fsClean != nil {
+			fsClean()
+		}
+		if rpcClean != nil {
+			rpcClean()
+		}
+	}
+
+	// temp datadir
+	datadir, err = ioutil.TempDir("", "rh")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	fsClean = func() {
+		os.RemoveAll(datadir)
+	}
+
+	var ov ownerValidator
+	if ensBackend != nil {
+		ov = ensOwnerValidator{ensBackend}
+	}
+
+	rhparams := &ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+		Signer:         signer,
+		HeaderGetter:   backend,
+		OwnerValidator: ov,
 	}
 	rh, err = NewTestResourceHandler(datadir, rhparams)
 	return rh, datadir, cleanF, err
 }
 
-// Set up simulated ENS backend for use with ENSResourceHandler tests
-func setupENS(addr common.Address, transactOpts *bind.TransactOpts, sub string, top string) (common.Address, *fakeBackend, error) {
+// Set up simulated ENS backend for use with ENSResourceHandler tests
+func setupENS(addr common.Address, transactOpts *bind.TransactOpts, sub string, top string) (common.Address, *fakeBackend, error) {
+
+	// create the domain hash values to pass to the ENS contract methods
+	var tophash [32]byte
+	var subhash [32]byte
+
+	testHasher.Reset()
+	testHasher.Write([]byte(top))
+	copy(tophash[:], testHasher.Sum(nil))
+	testHasher.Reset()
+	testHasher.Write([]byte(sub))
+	copy(subhash[:], testHasher.Sum(nil))
+
+	// initialize contract backend and deploy
+	contractBackend := &fakeBackend{
+		SimulatedBackend: backends.NewSimulatedBackend(core.GenesisAlloc{addr: {Balance: big.NewInt(1000000000)}}),
+	}
+
+	contractAddress, _, ensinstance, err := contract.DeployENS(transactOpts, contractBackend)
+	if err != nil {
+		return zeroAddr, nil, fmt.Errorf("can't deploy: %v", err)
+	}
+
+	// update the registry for the correct owner address
+	if _, err = ensinstance.SetOwner(transactOpts, [32]byte{}, addr); err != nil {
+		return zeroAddr, nil, fmt.Errorf("can't setowner: %v", err)
+	}
+	contractBackend.Commit()
+
+	if _, err = ensinstance.SetSubnodeOwner(transactOpts, [32]byte{}, tophash, addr); err != nil {
+		return zeroAddr, nil, fmt.Errorf("can't register top: %v", err)
+	}
+	contractBackend.Commit()
+
+	if _, err = ensinstance.SetSubnodeOwner(transactOpts, ens.EnsNode(top), subhash, addr); err != nil {
+		return zeroAddr, nil, fmt.Errorf("can't register top: %v", err)
+	}
+	contractBackend.Commit()
+
+	return contractAddress, contractBackend, nil
+}
+
+func newTestSigner() (*GenericResourceSigner, error) {
+	privKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	return &GenericResourceSigner{
+		PrivKey: privKey,
+	}, nil
+}
+
+func getUpdateDirect(rh *ResourceHandler, key Key) ([]byte, error) {
+	chunk, err := rh.chunkStore.localStore.memStore.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	chunkdata, err := decodeResourceChunk(chunk.SData)
+	if err != nil {
+		return nil, err
+	}
+	_, _, _, _, data, _, err := rh.parseUpdate(chunkdata)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// range lookups return all updates of the periods in order, without changing
+// the update loaded in the resource index
+func TestResourceLookupRange(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, _, teardownTest, err := setupTest(nil, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	clock := time.Unix(1500000000, 0)
+	rh.now = func() time.Time {
+		return clock
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, rsrc, err := rh.NewResourceWithScheme(ctx, safeName, 60, TimePeriods)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// period 2 has no updates
+	for i, data := range []string{"a", "b", "", "c", "d"} {
+		if i == 2 {
+			clock = clock.Add(2 * time.Minute)
+			continue
+		} else if i == 4 {
+			clock = clock.Add(time.Minute)
+		}
+		if _, err := rh.Update(ctx, safeName, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updates, err := rh.LookupRange(ctx, rsrc.nameHash, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		period  uint32
+		version uint32
+		data    string
+	}{{1, 1, "a"}, {1, 2, "b"}, {3, 1, "c"}}
+	if len(updates) != len(expected) {
+		t.Fatalf("expected %d updates, got %d", len(expected), len(updates))
+	}
+	for i, update := range updates {
+		if update.Period != expected[i].period || update.Version != expected[i].version || string(update.Data) != expected[i].data {
+			t.Fatalf("expected update %d to be %v, got period %d version %d data %q", i, expected[i], update.Period, update.Version, update.Data)
+		}
+	}
+	if updates, err := rh.LookupRange(ctx, rsrc.nameHash, 2, 2); err != nil || len(updates) != 0 {
+		t.Fatalf("expected no updates in period 2, got %d (%v)", len(updates), err)
+	}
+	_, data, err := rh.GetContent(rsrc.nameHash.Hex())
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "d" {
+		t.Fatalf("expected the loaded update to stay 'd', got %q", data)
+	}
+
+	for _, r := range [][2]uint32{{0, 1}, {3, 2}, {1, maxResourceRangePeriods + 1}} {
+		if _, err := rh.LookupRange(ctx, rsrc.nameHash, r[0], r[1]); err == nil {
+			t.Fatalf("expected range %d-%d to be invalid", r[0], r[1])
+		}
+	}
+
+	// a handler which did not see the deletion gets the tombstone as the last update
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	if _, err := rh2.LoadResource(rootKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.UpdateTombstone(ctx, safeName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.LookupRange(ctx, rsrc.nameHash, 1, 4); err == nil {
+		t.Fatal("expected range lookup of deleted resource to fail")
+	}
+	updates, err = rh2.LookupRange(ctx, rsrc.nameHash, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(updates) != 5 || !updates[4].Gone {
+		t.Fatalf("expected 5 updates ending with the tombstone, got %d", len(updates))
+	}
+}
+
+// the update keys and signature digests of a resource are made with the hash
+// algorithm declared in its metadata chunk
+func TestResourceHashAlgorithm(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, _, teardownTest, err := setupTest(nil, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	clock := time.Unix(1500000000, 0)
+	rh.now = func() time.Time {
+		return clock
+	}
+
+	for a := ResourceHashAlgorithm(0); a < resourceHashAlgorithmCount; a++ {
+		if parsed, err := ParseResourceHashAlgorithm(a.String()); err != nil || parsed != a {
+			t.Fatalf("expected to parse %v, got %v (%v)", a, parsed, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResourceWithHash(ctx, safeName, 60, TimePeriods, resourceHashAlgorithmCount); err == nil {
+		t.Fatal("expected resource creation with unknown hash algorithm to fail")
+	}
+	rootKey, rsrc, err := rh.NewResourceWithHash(ctx, safeName, 60, TimePeriods, ResourceHashBMT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := rh.Update(ctx, safeName, []byte("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, rh.resourceHashWith(ResourceHashBMT, 1, 1, rsrc.nameHash)) {
+		t.Fatalf("expected update key made with %v", ResourceHashBMT)
+	} else if bytes.Equal(key, rh.resourceHashWith(ResourceHashKeccak256, 1, 1, rsrc.nameHash)) {
+		t.Fatalf("expected update key not made with %v", ResourceHashKeccak256)
+	}
+
+	// a handler which has not loaded the resource accepts its updates, and
+	// resolves it from the metadata chunk
+	rh2, err := NewResourceHandler(&ResourceHandlerParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh2.SetStore(rh.chunkStore)
+	rh2.now = rh.now
+	chunk, err := rh.chunkStore.get(key, defaultRetrieveTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rh2.Validate(key, chunk.SData) {
+		t.Fatal("expected update of resource which is not loaded to be valid")
+	}
+	rsrc, err = rh2.LoadResource(rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.HashAlgorithm() != ResourceHashBMT || rsrc.frequency != 60 || rsrc.Scheme() != TimePeriods {
+		t.Fatalf("expected %v time resource with frequency 60, got %v %v resource with frequency %d", ResourceHashBMT, rsrc.HashAlgorithm(), rsrc.Scheme(), rsrc.frequency)
+	}
+	view, err := rh2.LookupLatest(ctx, rsrc.nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(view.data, []byte("one")) {
+		t.Fatalf("expected data %q, got %q", "one", view.data)
+	} else if addr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey); view.signer != addr {
+		t.Fatalf("expected update signed by %x, got %x", addr, view.signer)
+	}
+}
+
+// the least recently used resources are evicted from a full index, and
+// resources not synced within the TTL of the index are dropped
+func TestResourceIndexLimits(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "rh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	rh, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		MaxIndexEntries: 2,
+		IndexTTL:        time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Unix(1500000000, 0)
+	rh.now = func() time.Time {
+		return clock
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	names := []string{"one.eth", "two.eth", "three.eth"}
+	keys := make([]Key, len(names))
+	for i, name := range names {
+		keys[i], _, err = rh.NewResourceWithScheme(ctx, name, 60, TimePeriods)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			// the first resource is used, so the second one is evicted
+			rh.getResource(ens.EnsNode(names[0]).Hex())
+			evicted := ens.EnsNode(names[1]).Hex()
+			rh.tombstones[evicted] = &resourceTombstone{}
+			rh.revocations[evicted] = []ResourceRevocation{{}}
+			rh.anchors[evicted] = []ResourceAnchor{{}}
+		}
+	}
+	if rh.resources.Len() != 2 {
+		t.Fatalf("expected 2 resources in the index, got %d", rh.resources.Len())
+	}
+	// the state of the evicted resource is forgotten with it
+	if evicted := ens.EnsNode(names[1]).Hex(); rh.getTombstone(evicted) != nil || len(rh.revocations[evicted]) != 0 || len(rh.anchors[evicted]) != 0 {
+		t.Fatalf("expected the tombstone, revocations and anchors of %s to be forgotten", names[1])
+	}
+	for i, evicted := range []bool{false, true, false} {
+		if rsrc := rh.getResource(ens.EnsNode(names[i]).Hex()); (rsrc == nil) != evicted {
+			t.Fatalf("expected resource %s evicted %v, got %v", names[i], evicted, rsrc == nil)
+		}
+	}
+
+	// a resource synced within the TTL is kept
+	clock = clock.Add(time.Minute)
+	if _, err := rh.Update(ctx, names[2], []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(time.Hour)
+	if rsrc := rh.getResource(ens.EnsNode(names[0]).Hex()); rsrc != nil {
+		t.Fatalf("expected resource %s to expire", names[0])
+	} else if rsrc := rh.getResource(ens.EnsNode(names[2]).Hex()); rsrc == nil {
+		t.Fatalf("expected resource %s synced within the TTL to be kept", names[2])
+	}
+
+	// an expired resource is loaded again from its metadata chunk
+	if _, err := rh.LoadResource(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if rsrc := rh.getResource(ens.EnsNode(names[0]).Hex()); rsrc == nil {
+		t.Fatalf("expected resource %s in the index after loading it", names[0])
+	}
+}
+
+// test that a resource exported from one node can be imported into the
+// store of another, with its history and the data of large updates
+func TestResourceExport(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, _, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	if _, err := rh.Update(ctx, safeName, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	large := make([]byte, 3*chunkSize+42)
+	for i := range large {
+		large[i] = byte(i % 251)
+	}
+	fwdBlocks(int(resourceFrequency*2), backend)
+	if _, err := rh.UpdateBatch(ctx, safeName, [][]byte{[]byte("second"), large}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	count, err := rh.Export(ctx, nameHash, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// metadata, three updates and the four data chunks of the large one with
+	// their root
+	if count != 9 {
+		t.Fatalf("expected 9 chunks exported, got %d", count)
+	}
+
+	datadir, err := ioutil.TempDir("", "rh-import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	rh2, err := NewTestResourceHandler(datadir, &ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+		HeaderGetter:    backend,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh2.Close()
+	key, err := rh2.Import(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, rootKey) {
+		t.Fatalf("expected metadata key %v, got %v", rootKey, key)
+	}
+	rsrc, err := rh2.LookupLatestByName(ctx, safeName, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.version != 2 {
+		t.Fatalf("expected version 2, got %d", rsrc.version)
+	}
+	if !bytes.Equal(rsrc.data, large) {
+		t.Fatalf("expected imported data of %d bytes, got %d bytes", len(large), len(rsrc.data))
+	}
+	period := rsrc.lastPeriod
+	rsrc, err = rh2.LookupPrevious(ctx, rsrc, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc.data, []byte("second")) {
+		t.Fatalf("expected data 'second', got '%s'", rsrc.data)
+	}
+	rsrc, err = rh2.LookupHistorical(ctx, nameHash, period-1, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rsrc.data, []byte("first")) {
+		t.Fatalf("expected data 'first', got '%s'", rsrc.data)
+	}
+
+	if _, err := rh2.Import(bytes.NewReader([]byte("not an archive"))); err == nil {
+		t.Fatal("expected import of an invalid archive to fail")
+	}
+}
+
+// test that updates of a period seen with different content for the same
+// version are reported as diverged, but not the updates of several owners
+func TestResourceDetectConflicts(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	rh.strict = newResourceStrictness()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signerAddr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+	userAddr := crypto.PubkeyToAddress(user.PrivKey.PublicKey)
+	acl := &ResourceACL{
+		Owners: []common.Address{signerAddr, userAddr},
+	}
+	if _, _, err := rh.NewResourceWithACL(ctx, safeName, resourceFrequency, BlockPeriods, acl); err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency), backend)
+	key, err := rh.Update(ctx, safeName, []byte("signer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	period := rsrc.lastPeriod
+
+	report, err := rh.DetectConflicts(ctx, nameHash, period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Diverged || len(report.Versions) != 1 || len(report.Signers) != 1 {
+		t.Fatalf("expected a single undiverged update, got %+v", report)
+	}
+	if v := report.Versions[0]; v.Version != 1 || !bytes.Equal(v.Key, key) || v.Signer != signerAddr || v.Revoked {
+		t.Fatalf("unexpected version %+v", v)
+	}
+
+	// a following update by the other owner in the same period
+	if _, err := rh.UpdateWithSigner(ctx, safeName, []byte("user"), user); err != nil {
+		t.Fatal(err)
+	}
+	report, err = rh.DetectConflicts(ctx, nameHash, period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Diverged || len(report.Versions) != 2 || len(report.Signers) != 2 {
+		t.Fatalf("expected two undiverged updates, got %+v", report)
+	}
+	if report.Signers[0] != signerAddr || report.Signers[1] != userAddr {
+		t.Fatalf("unexpected signers %v", report.Signers)
+	}
+	if report.Versions[0].ContentHash == report.Versions[1].ContentHash {
+		t.Fatal("expected the versions to have different content")
+	}
+	if len(report.Conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %v", report.Conflicts)
+	}
+
+	// the same data for the first version signed by the other owner
+	modTime := uint64(rh.now().Unix())
+	digest := rh.keyDataHash(ens.EnsNode(safeName), key, modTime, []byte("signer"))
+	sig, err := user.Sign(digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	same := newUpdateChunk(key, &sig, &resourceUpdateHeader{modTime: modTime, signer: userAddr}, period, 1, safeName, []byte("signer"), 0)
+	if !rh.Validate(same.Key, same.SData) {
+		t.Fatal("expected the update signed by the other owner to be valid")
+	}
+	report, err = rh.DetectConflicts(ctx, nameHash, period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Diverged || len(report.Conflicts) != 1 || !report.Conflicts[0].SameContent {
+		t.Fatalf("expected an undiverged conflict of the same content, got %+v", report)
+	}
+
+	// different data for the first version, seen by strict validation
+	other := newTestUpdateChunk(t, rh, key, period, 1, []byte("other"))
+	if !rh.Validate(other.Key, other.SData) {
+		t.Fatal("expected conflicting update to be valid")
+	}
+	report, err = rh.DetectConflicts(ctx, nameHash, period)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Diverged || len(report.Conflicts) != 2 || report.Conflicts[1].SameContent || report.Conflicts[1].DataHash != report.Versions[0].DataHash {
+		t.Fatalf("expected the diverged conflict of the first version, got %v", report.Conflicts)
+	}
+
+	if _, err := rh.DetectConflicts(ctx, nameHash, period+1); !IsNotFound(err) {
+		t.Fatalf("expected not found for a period without updates, got %v", err)
+	}
+}
+
+// the content referenced by multihash updates is streamed from the dpa
+func TestResourceResolveContent(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
 
-	// create the domain hash values to pass to the ENS contract methods
-	var tophash [32]byte
-	var subhash [32]byte
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
 
-	testHasher.Reset()
-	testHasher.Write([]byte(top))
-	copy(tophash[:], testHasher.Sum(nil))
-	testHasher.Reset()
-	testHasher.Write([]byte(sub))
-	copy(subhash[:], testHasher.Sum(nil))
+	content := make([]byte, 5*chunkSize+42)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	contentKey, wait, err := rh.dpa.Store(bytes.NewReader(content), int64(len(content)), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wait()
+	contentMultihash, err := multihash.Encode(contentKey, multihash.KECCAK_256)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// initialize contract backend and deploy
-	contractBackend := &fakeBackend{
-		SimulatedBackend: backends.NewSimulatedBackend(core.GenesisAlloc{addr: {Balance: big.NewInt(1000000000)}}),
+	fwdBlocks(int(resourceFrequency/2), backend)
+	if _, err := rh.Update(ctx, safeName, []byte("not a multihash")); err != nil {
+		t.Fatal(err)
+	}
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rsrc.ResolveContent(ctx); err == nil || err.(*ResourceError).Code() != ErrInvalidValue {
+		t.Fatalf("expected invalid value error for raw update data, got %v", err)
 	}
 
-	contractAddress, _, ensinstance, err := contract.DeployENS(transactOpts, contractBackend)
+	if _, err := rh.UpdateMultihash(ctx, safeName, contentMultihash); err != nil {
+		t.Fatal(err)
+	}
+	rsrc, err = rh.LookupLatest(ctx, nameHash, true, nil)
 	if err != nil {
-		return zeroAddr, nil, fmt.Errorf("can't deploy: %v", err)
+		t.Fatal(err)
+	}
+	reader, err := rsrc.ResolveContent(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := reader.Size(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(content)) {
+		t.Fatalf("expected content size %d, got %d", len(content), size)
+	}
+	data := make([]byte, size)
+	if _, err := reader.ReadAt(data, 0); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("resolved content does not match the stored content")
 	}
 
-	// update the registry for the correct owner address
-	if _, err = ensinstance.SetOwner(transactOpts, [32]byte{}, addr); err != nil {
-		return zeroAddr, nil, fmt.Errorf("can't setowner: %v", err)
+	// multihashes of other hash functions do not reference swarm content
+	sha2multi, err := multihash.Encode(make([]byte, multihash.DefaultLengths[multihash.SHA2_256]), multihash.SHA2_256)
+	if err != nil {
+		t.Fatal(err)
 	}
-	contractBackend.Commit()
+	if _, err := rh.UpdateMultihash(ctx, safeName, sha2multi); err != nil {
+		t.Fatal(err)
+	}
+	rsrc, err = rh.LookupLatest(ctx, nameHash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rsrc.ResolveContent(ctx); err == nil || err.(*ResourceError).Code() != ErrInvalidValue {
+		t.Fatalf("expected invalid value error for sha2-256 multihash, got %v", err)
+	}
+}
 
-	if _, err = ensinstance.SetSubnodeOwner(transactOpts, [32]byte{}, tophash, addr); err != nil {
-		return zeroAddr, nil, fmt.Errorf("can't register top: %v", err)
+// fakeENSUpdater sets content records once they were resolved a number of
+// times after the transaction was submitted, like a transaction being mined
+type fakeENSUpdater struct {
+	lock     sync.Mutex
+	records  map[string]common.Hash
+	pending  map[string]common.Hash
+	delay    int
+	resolves int
+	fail     bool
+}
+
+func (f *fakeENSUpdater) SetContentHash(name string, hash common.Hash) (*types.Transaction, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.fail {
+		return nil, errors.New("insufficient funds")
 	}
-	contractBackend.Commit()
+	f.pending[name] = hash
+	f.resolves = 0
+	return nil, nil
+}
 
-	if _, err = ensinstance.SetSubnodeOwner(transactOpts, ens.EnsNode(top), subhash, addr); err != nil {
-		return zeroAddr, nil, fmt.Errorf("can't register top: %v", err)
+func (f *fakeENSUpdater) Resolve(name string) (common.Hash, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.resolves++
+	if hash, ok := f.pending[name]; ok && f.resolves > f.delay {
+		f.records[name] = hash
+		delete(f.pending, name)
 	}
-	contractBackend.Commit()
+	return f.records[name], nil
+}
 
-	return contractAddress, contractBackend, nil
+// new resources have the content record of their name set to their
+// metadata chunk
+func TestResourceENSUpdater(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := rh.WaitRegistered(ctx, safeName); err == nil || err.(*ResourceError).Code() != ErrInit {
+		t.Fatalf("expected init error without ENS updater, got %v", err)
+	}
+
+	updater := &fakeENSUpdater{
+		records: make(map[string]common.Hash),
+		pending: make(map[string]common.Hash),
+		delay:   3,
+	}
+	rh.ensUpdater = updater
+	rh.ensPollInterval = 10 * time.Millisecond
+
+	rootKey, _, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updater.Resolve(safeName); err != nil {
+		t.Fatal(err)
+	}
+	if updater.records[safeName] == common.BytesToHash(rootKey) {
+		t.Fatal("expected the content record to be pending")
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, time.Second)
+	defer waitCancel()
+	if err := rh.WaitRegistered(waitCtx, safeName); err != nil {
+		t.Fatal(err)
+	}
+	if updater.records[safeName] != common.BytesToHash(rootKey) {
+		t.Fatalf("expected content record %x, got %x", rootKey, updater.records[safeName])
+	}
+
+	// the resource is created even if the transaction fails
+	updater.fail = true
+	otherName := "other." + safeName
+	rootKey, _, err = rh.NewResource(ctx, otherName, resourceFrequency)
+	if err == nil || err.(*ResourceError).Code() != ErrIO {
+		t.Fatalf("expected IO error for failed transaction, got %v", err)
+	}
+	if root, err := rh.ResourceRoot(ResourceNameHash(otherName)); err != nil || !bytes.Equal(root, rootKey) {
+		t.Fatalf("expected resource with root key %v, got %v (%v)", rootKey, root, err)
+	}
+	waitCtx, waitCancel = context.WithTimeout(ctx, 100*time.Millisecond)
+	defer waitCancel()
+	if err := rh.WaitRegistered(waitCtx, otherName); err == nil || err.(*ResourceError).Code() != ErrIO {
+		t.Fatalf("expected IO error waiting for unset content record, got %v", err)
+	}
+
+	// topic feeds are not registered, so the failing transaction is not sent
+	if _, _, err := rh.NewResourceWithTopic(ctx, common.HexToHash("0x01"), resourceFrequency); err != nil {
+		t.Fatalf("expected topic feed without content record, got %v", err)
+	}
 }
 
-func newTestSigner() (*GenericResourceSigner, error) {
-	privKey, err := crypto.GenerateKey()
+// the periods and versions probed by lookups are recorded by strategy
+func TestResourceLookupHistograms(t *testing.T) {
+	enabled := metrics.Enabled
+	metrics.Enabled = true
+	defer func() { metrics.Enabled = enabled }()
+	hopsName, versionsName := "resource.lookup.hops.binary", "resource.lookup.versions.binary"
+	metrics.DefaultRegistry.Unregister(hopsName)
+	metrics.DefaultRegistry.Unregister(versionsName)
+
+	signer, err := newTestSigner()
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, _, err := rh.NewResource(ctx, safeName, resourceFrequency); err != nil {
+		t.Fatal(err)
+	}
+	fwdBlocks(int(resourceFrequency/2), backend)
+	if _, err := rh.UpdateBatch(ctx, safeName, [][]byte{[]byte("one"), []byte("two"), []byte("three")}); err != nil {
+		t.Fatal(err)
+	}
+	params := &ResourceLookupParams{
+		Strategy: BinaryLookup{},
+	}
+	rsrc, err := rh.LookupLatest(ctx, nameHash, true, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsrc.version != 3 {
+		t.Fatalf("expected version 3, got %d", rsrc.version)
+	}
+
+	// the update is in the current period, versions 2, 4 and 3 are probed
+	hops := metrics.DefaultRegistry.Get(hopsName).(metrics.Histogram)
+	if hops.Count() != 1 || hops.Max() != 1 {
+		t.Fatalf("expected one lookup with one hop, got %d lookups with at most %d hops", hops.Count(), hops.Max())
+	}
+	versions := metrics.DefaultRegistry.Get(versionsName).(metrics.Histogram)
+	if versions.Count() != 1 || versions.Max() != 3 {
+		t.Fatalf("expected one lookup with 3 version probes, got %d lookups with at most %d probes", versions.Count(), versions.Max())
+	}
+
+	// the fan-out is not part of the names
+	if lookupHistogram("hops", ParallelLookup{FanOut: 3}) != lookupHistogram("hops", ParallelLookup{FanOut: 5}) {
+		t.Fatal("expected lookups of all fan-outs to share a histogram")
+	}
+	lookupHistogram("hops", HintFirstLookup{Fallback: ParallelLookup{FanOut: 3}})
+	if metrics.DefaultRegistry.Get("resource.lookup.hops.hint.parallel") == nil {
+		t.Fatal("expected histogram of the hint strategy without fan-out")
 	}
-	return &GenericResourceSigner{
-		PrivKey: privKey,
-	}, nil
 }
 
-func getUpdateDirect(rh *ResourceHandler, key Key) ([]byte, error) {
-	chunk, err := rh.chunkStore.localStore.memStore.Get(key)
+// update chunks are rejected beyond the rate limits, and for the names and
+// signers denied by the update filter
+func TestResourceValidateLimits(t *testing.T) {
+	signer, err := newTestSigner()
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
 	}
-	_, _, _, _, data, _, err := rh.parseUpdate(chunk.SData)
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
 	if err != nil {
-		return nil, err
+		t.Fatal(err)
+	}
+	defer teardownTest()
+	now := time.Unix(1500000000, 0)
+	rh.now = func() time.Time { return now }
+	signerAddr := crypto.PubkeyToAddress(signer.PrivKey.PublicKey)
+
+	validate := func(version uint32) bool {
+		chunk := newTestUpdateChunk(t, rh, rh.resourceHash(1, version, nameHash), 1, version, []byte("foo"))
+		return rh.Validate(chunk.Key, chunk.SData)
+	}
+
+	if _, err := newResourceRateLimiter(&ResourceRateLimit{PerName: 1}); err == nil {
+		t.Fatal("expected rate limit without interval to fail")
+	}
+	if rh.rateLimiter, err = newResourceRateLimiter(&ResourceRateLimit{Interval: time.Hour, PerName: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for version, valid := range []bool{true, true, false} {
+		if validate(uint32(version+1)) != valid {
+			t.Fatalf("version %d: expected valid %v", version+1, valid)
+		}
+	}
+	// the count restarts in the next interval
+	now = now.Add(time.Hour)
+	if !validate(3) {
+		t.Fatal("expected update to be valid in the next interval")
+	}
+
+	if rh.rateLimiter, err = newResourceRateLimiter(&ResourceRateLimit{Interval: time.Hour, PerSigner: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !validate(1) || validate(2) {
+		t.Fatal("expected one update of the signer to be valid")
+	}
+	// chunks are counted once
+	if rh.rateLimiter, err = newResourceRateLimiter(&ResourceRateLimit{Interval: time.Hour, PerName: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if !validate(1) || !validate(1) || validate(2) {
+		t.Fatal("expected the update to be counted once")
+	}
+
+	// the total limit bounds updates of distinct names, unsigned updates share
+	// a signer
+	for _, limit := range []*ResourceRateLimit{
+		{Interval: time.Hour, Total: 2},
+		{Interval: time.Hour, PerSigner: 2},
+	} {
+		limiter, err := newResourceRateLimiter(limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, valid := range []bool{true, true, false} {
+			key := Key(common.Hash{byte(i)}.Bytes())
+			if limiter.allow(key, common.Hash{byte(i)}, common.Address{}, now) != valid {
+				t.Fatalf("%+v: update %d: expected valid %v", limit, i, valid)
+			}
+		}
+	}
+	rh.rateLimiter = nil
+
+	for i, filter := range []*ResourceListFilter{
+		{DeniedNames: map[string]bool{safeName: true}},
+		{AllowedNames: map[string]bool{"other." + safeName: true}},
+		{DeniedSigners: map[common.Address]bool{signerAddr: true}},
+		{AllowedSigners: map[common.Address]bool{common.Address{1}: true}},
+	} {
+		rh.updateFilter = filter
+		if validate(1) {
+			t.Fatalf("filter %d: expected update to be denied", i)
+		}
+	}
+	rh.updateFilter = &ResourceListFilter{
+		AllowedNames:   map[string]bool{safeName: true},
+		AllowedSigners: map[common.Address]bool{signerAddr: true},
+	}
+	if !validate(1) {
+		t.Fatal("expected update of allowed name and signer to be valid")
+	}
+}
+
+// a chain source returning a fixed header or error
+type fakeHeaderSource struct {
+	header *types.Header
+	err    error
+	calls  int
+}
+
+func (f *fakeHeaderSource) HeaderByNumber(context.Context, string, *big.Int) (*types.Header, error) {
+	f.calls++
+	return f.header, f.err
+}
+
+// the header chain fails over to the next source, retries failed sources
+// after the retry interval and fails with ErrNoChain offline
+func TestHeaderChain(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	light := &fakeHeaderSource{
+		header: &types.Header{Number: big.NewInt(10), Time: big.NewInt(now.Unix())},
+	}
+	rpc := &fakeHeaderSource{
+		header: &types.Header{Number: big.NewInt(11), Time: big.NewInt(now.Unix())},
+	}
+	estimate := &fakeHeaderSource{
+		header: &types.Header{Number: big.NewInt(12)},
+	}
+	sources := []HeaderSource{
+		{Name: "light", Getter: light},
+		{Name: "rpc", Getter: rpc},
+		{Name: "estimate", Getter: estimate, Estimate: true},
+	}
+	chain := NewHeaderChain(&HeaderChainParams{
+		Sources:       sources,
+		RetryInterval: time.Minute,
+		MaxHeaderAge:  time.Minute,
+	})
+	chain.now = func() time.Time { return now }
+	expect := func(number int64) {
+		t.Helper()
+		header, err := chain.HeaderByNumber(context.Background(), safeName, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Number.Int64() != number {
+			t.Fatalf("expected block %d, got %d", number, header.Number)
+		}
+	}
+
+	expect(10)
+	// the light client is not synced
+	light.header.Time = big.NewInt(now.Add(-time.Hour).Unix())
+	expect(11)
+	light.header.Time = big.NewInt(now.Unix())
+	// failed sources are skipped until the retry interval has passed
+	rpc.err = errors.New("connection refused")
+	expect(12)
+	now = now.Add(time.Minute)
+	expect(10)
+	rpc.err = nil
+	light.err = errors.New("connection refused")
+	expect(11)
+	if light.calls != 4 || rpc.calls != 3 || estimate.calls != 1 {
+		t.Fatalf("expected 4 light client, 3 rpc and 1 estimate calls, got %d, %d and %d", light.calls, rpc.calls, estimate.calls)
+	}
+
+	// offline, the estimate is never used
+	rpc.err = errors.New("connection refused")
+	chain = NewHeaderChain(&HeaderChainParams{
+		Sources: sources,
+		Offline: true,
+	})
+	if _, err := chain.HeaderByNumber(context.Background(), safeName, nil); !IsNoChain(err) {
+		t.Fatalf("expected ErrNoChain, got %v", err)
+	}
+	if estimate.calls != 1 {
+		t.Fatalf("expected 1 estimate, got %d", estimate.calls)
+	}
+}
+
+// the proof of an update verifies, and fails to verify if any part of it is
+// changed
+func TestResourceProof(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &fakeBackend{
+		blocknumber: int64(startBlock),
+	}
+	rh, _, teardownTest, err := setupTest(backend, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer teardownTest()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rootKey, _, err := rh.NewResource(ctx, safeName, resourceFrequency)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.Proof(ctx, nameHash); err == nil || err.(*ResourceError).Code() != ErrNothingToReturn {
+		t.Fatalf("expected nothing to return error before the first update, got %v", err)
+	}
+	fwdBlocks(int(resourceFrequency/2), backend)
+	updateKey, err := rh.Update(ctx, safeName, []byte("proven"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rh.LookupLatest(ctx, nameHash, true, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := rh.Proof(ctx, nameHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(proof.RootKey, rootKey) || !bytes.Equal(proof.Key, updateKey) {
+		t.Fatalf("expected root key %x and update key %x, got %x and %x", rootKey, updateKey, proof.RootKey, proof.Key)
+	}
+	if proof.Period != 1 || proof.Version != 1 || proof.OwnerBlock == 0 {
+		t.Fatalf("expected period 1 version 1 and an owner block, got %d, %d and %d", proof.Period, proof.Version, proof.OwnerBlock)
+	}
+	if proof.Signer != crypto.PubkeyToAddress(signer.PrivKey.PublicKey) {
+		t.Fatalf("expected signer %x, got %x", crypto.PubkeyToAddress(signer.PrivKey.PublicKey), proof.Signer)
+	}
+	if err := rh.VerifyProof(proof); err != nil {
+		t.Fatal(err)
+	}
+
+	// the proof survives its json encoding
+	data, err := json.Marshal(proof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := &ResourceProof{}
+	if err := json.Unmarshal(data, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := rh.VerifyProof(decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tamper := range []func(p *ResourceProof){
+		func(p *ResourceProof) { p.Name = "other." + safeName },
+		func(p *ResourceProof) { p.Version = 2 },
+		func(p *ResourceProof) { p.RootChunk[len(p.RootChunk)-1] ^= 1 },
+		func(p *ResourceProof) { p.Key[0] ^= 1 },
+		func(p *ResourceProof) { p.Chunk[len(p.Chunk)-signatureLength-1] ^= 1 },
+		func(p *ResourceProof) { p.Signature[0] ^= 1 },
+		func(p *ResourceProof) { p.Signer = common.Address{1} },
+	} {
+		tampered := *proof
+		tampered.RootChunk = common.CopyBytes(proof.RootChunk)
+		tampered.Key = common.CopyBytes(proof.Key)
+		tampered.Chunk = common.CopyBytes(proof.Chunk)
+		tampered.Signature = common.CopyBytes(proof.Signature)
+		tamper(&tampered)
+		if err := rh.VerifyProof(&tampered); err == nil {
+			t.Fatalf("tampered proof %d: expected verification to fail", i)
+		}
 	}
-	return data, nil
 }
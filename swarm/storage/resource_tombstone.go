@@ -0,0 +1,133 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	// tombstone updates are indicated by this flag in the flags field
+	resourceTombstoneFlag = 0x40
+)
+
+// the data of tombstone updates
+var resourceTombstoneData = []byte("deleted")
+
+// the tombstone update of a resource
+type resourceTombstone struct {
+	key     Key
+	period  uint32
+	version uint32
+}
+
+// returns true if the update of the given period and version was made before the tombstone
+func (t *resourceTombstone) precedes(period uint32, version uint32) bool {
+	return period < t.period || (period == t.period && version < t.version)
+}
+
+// returns true if the update of the given period and version was made after the tombstone
+func (t *resourceTombstone) follows(period uint32, version uint32) bool {
+	return period > t.period || (period == t.period && version > t.version)
+}
+
+// UpdateTombstone deletes the resource by adding a signed tombstone update.
+//
+// Lookups of the resource return ErrGone once they find the tombstone, and
+// no more updates are accepted for it. The update chunks preceding the
+// tombstone are garbage for the local store, see GCHook.
+//
+// Tombstones are kept in memory only, after a restart they are found again
+// by looking up the latest update.
+func (self *ResourceHandler) UpdateTombstone(ctx context.Context, name string) (Key, error) {
+	signer, err := self.signerFor(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := self.updateBatch(ctx, signer, name, [][]byte{resourceTombstoneData}, false, true)
+	if err != nil {
+		return nil, err
+	}
+	metrics.GetOrRegisterCounter("resource.tombstone", nil).Inc(1)
+	return keys[0], nil
+}
+
+// records the tombstone of the resource in the index and returns ErrGone
+func (self *ResourceHandler) setTombstone(rsrc *resource, key Key, period uint32, version uint32) error {
+	self.tombstoneLock.Lock()
+	self.tombstones[rsrc.nameHash.Hex()] = &resourceTombstone{
+		key:     key,
+		period:  period,
+		version: version,
+	}
+	self.tombstoneLock.Unlock()
+
+	view := rsrc.newView()
+	view.lastKey = key
+	view.lastPeriod = period
+	view.version = version
+	view.tombstone = true
+	rsrc.setCurrent(view, true)
+	self.setResource(rsrc.nameHash.Hex(), rsrc)
+	log.Debug("Resource deleted", "name", rsrc.name, "key", key, "period", period, "version", version)
+	return NewResourceError(ErrGone, fmt.Sprintf("Resource '%s' was deleted", rsrc.name))
+}
+
+// returns the tombstone of the resource, nil if it was not deleted
+func (self *ResourceHandler) getTombstone(nameHash string) *resourceTombstone {
+	self.tombstoneLock.RLock()
+	defer self.tombstoneLock.RUnlock()
+	return self.tombstones[nameHash]
+}
+
+// returns true if the update chunk is a tombstone
+func isTombstoneUpdate(chunkdata []byte) bool {
+	return updateFlags(chunkdata)&resourceTombstoneFlag != 0
+}
+
+// Garbage collection hook of the local store (matches GCHook signature)
+//
+// Update chunks of deleted resources preceding the tombstone are garbage,
+// the tombstone itself is kept for lookups to find.
+func (self *ResourceHandler) isGarbage(key Key, data []byte) bool {
+	self.tombstoneLock.RLock()
+	n := len(self.tombstones)
+	self.tombstoneLock.RUnlock()
+	data, err := decodeResourceChunk(data)
+	if n == 0 || err != nil || len(data) < 2 || bytes.Equal(data[:2], []byte{0, 0}) {
+		return false
+	}
+	_, period, version, name, _, _, err := self.parseUpdate(data)
+	if err != nil {
+		return false
+	}
+	nameHash := ResourceNameHash(name)
+	tombstone := self.getTombstone(nameHash.Hex())
+	if tombstone == nil || !tombstone.precedes(period, version) {
+		return false
+	}
+	if !bytes.Equal(self.resourceHash(period, version, nameHash), key) {
+		return false
+	}
+	metrics.GetOrRegisterCounter("resource.tombstone.gc", nil).Inc(1)
+	return true
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/contracts/ens"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	// TopicLength is the length of a resource topic
+	TopicLength = 32
+
+	// the names of topic feeds end with this label
+	topicNameSuffix = ".topic"
+)
+
+// Topic identifies a resource without an ENS name. Every address has its own
+// feed on a topic, so users can publish on the same topic independently and
+// without registering a name.
+type Topic [TopicLength]byte
+
+// NewTopic derives the topic from an arbitrary byte string
+func NewTopic(data []byte) Topic {
+	var topic Topic
+	copy(topic[:], crypto.Keccak256(data))
+	return topic
+}
+
+// Hex returns the hex representation of the topic
+func (self Topic) Hex() string {
+	return hex.EncodeToString(self[:])
+}
+
+// Name returns the resource name of the feed of the user on the topic, which
+// is accepted in place of an ENS name by the resource handler.
+//
+// The name is <topic>.<user>.topic with the topic and the user address in
+// lower case hex. The owner of a topic feed is the user address in its name,
+// no name registration is needed to update it.
+func (self Topic) Name(user common.Address) string {
+	return fmt.Sprintf("%x.%x%s", self[:], user[:], topicNameSuffix)
+}
+
+// ParseTopicName returns the topic and the user address of a topic feed name
+func ParseTopicName(name string) (Topic, common.Address, error) {
+	var topic Topic
+	var user common.Address
+	if !strings.HasSuffix(name, topicNameSuffix) {
+		return topic, user, fmt.Errorf("not a topic name: '%s'", name)
+	}
+	labels := strings.Split(strings.TrimSuffix(name, topicNameSuffix), ".")
+	if len(labels) != 2 || len(labels[0]) != 2*TopicLength || len(labels[1]) != 2*common.AddressLength {
+		return topic, user, fmt.Errorf("invalid topic name: '%s'", name)
+	}
+	// upper case hex would be a different name for the same feed
+	if strings.ToLower(labels[0]) != labels[0] || strings.ToLower(labels[1]) != labels[1] {
+		return topic, user, fmt.Errorf("topic name not in lower case: '%s'", name)
+	}
+	if _, err := hex.Decode(topic[:], []byte(labels[0])); err != nil {
+		return topic, user, fmt.Errorf("invalid topic in name '%s': %v", name, err)
+	}
+	if _, err := hex.Decode(user[:], []byte(labels[1])); err != nil {
+		return topic, user, fmt.Errorf("invalid user address in name '%s': %v", name, err)
+	}
+	return topic, user, nil
+}
+
+// ResourceNameHash returns the hash identifying the resource with the name.
+//
+// For topic feeds the hash is derived from the topic and the user address, so
+// the feeds of different users on the same topic never share update keys.
+// Other names are hashed with the ENS namehash algorithm.
+func ResourceNameHash(name string) common.Hash {
+	if topic, user, err := ParseTopicName(name); err == nil {
+		return crypto.Keccak256Hash(topic[:], user[:])
+	}
+	return ens.EnsNode(name)
+}
+
+// returns the owner of the topic feed, and false if the name is not a topic
+// name
+func topicOwner(name string) (common.Address, bool) {
+	_, user, err := ParseTopicName(name)
+	return user, err == nil
+}
+
+// NewResourceWithTopic creates the feed of the signer of the handler on the
+// raw 32 byte topic, for applications keying their resources by arbitrary ids
+// like chat rooms rather than by names. The name of the resource, used to
+// update and look it up, is the one returned by Topic.Name with the address
+// of the signer, see resource.Name.
+func (self *ResourceHandler) NewResourceWithTopic(ctx context.Context, topic common.Hash, frequency uint64) (Key, *resource, error) {
+	// the handler signer, as no name is registered with a signer yet
+	signer, err := self.signerFor(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	} else if signer == nil {
+		return nil, nil, NewResourceError(ErrInit, "Resource handler does not sign updates, topic feeds are owned by their signer")
+	}
+	// the signer signs the topic once to reveal its address
+	signature, err := signer.Sign(topic)
+	if err != nil {
+		return nil, nil, WrapResourceError(ErrInvalidSignature, "Sign fail", err)
+	}
+	user, err := getAddressFromDataSig(topic, signature)
+	if err != nil {
+		return nil, nil, WrapResourceError(ErrInvalidSignature, "Retrieve address from signature fail", err)
+	}
+	return self.NewResource(ctx, Topic(topic).Name(user), frequency)
+}
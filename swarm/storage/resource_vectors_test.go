@@ -0,0 +1,343 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/contracts/ens"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+/*
+The golden test vectors of resource update chunks in
+testdata/resource_vectors.json let other implementations of swarm check that
+they make and read the same bytes. All byte fields are hex encoded with a 0x
+prefix.
+
+The inputs of a vector are:
+
+- name: the name of the resource
+- hash: the hash algorithm of the resource, keccak256, sha256 or bmt
+- period, version: the period and version of the update
+- modTime: the creation time of the update in unix seconds
+- data: the data of the update, a multihash if multihash is true
+- privateKey: the secp256k1 key signing the update, unsigned if empty
+
+The outputs are:
+
+- nameHash: the ENS namehash of the name
+- key: the key of the update chunk, hash(period|version|nameHash)
+- digest: the signature digest, hash(key|modTime|data)
+- signature: the 65 byte [R || S || V] signature of the digest, V is 0 or 1
+- signer: the address of the key, zero if unsigned
+- chunk: the data of the update chunk as stored and sent to peers
+
+Regenerate the outputs after a deliberate change of the format with
+
+	go test ./swarm/storage -run TestResourceVectors -update-vectors
+
+The vectors of earlier formats are kept in testdata/resource_vectors_v<n>.json,
+the handler still reads their chunks. The vectors of format 0 are unversioned
+chunks of the baseline layout, made by the handler of commit 17a8cf6. They
+have no modification time, and their digest is hash(key|data).
+*/
+
+var updateVectors = flag.Bool("update-vectors", false, "regenerate the outputs of the resource test vectors")
+
+const resourceVectorsFile = "testdata/resource_vectors.json"
+
+// the vectors of the update chunks of earlier formats by format, format 0
+// has the baseline layout and format 1 the legacy layout
+var resourceLegacyVectorsFiles = map[uint8]string{
+	resourceFormatLegacy: "testdata/resource_vectors_v0.json",
+	resourceFormatV1:     "testdata/resource_vectors_v1.json",
+}
+
+// a golden test vector of a resource update chunk
+type resourceVector struct {
+	Description string `json:"description"`
+
+	Name       string        `json:"name"`
+	Hash       string        `json:"hash"`
+	Period     uint32        `json:"period"`
+	Version    uint32        `json:"version"`
+	ModTime    uint64        `json:"modTime"`
+	Data       hexutil.Bytes `json:"data"`
+	Multihash  bool          `json:"multihash"`
+	PrivateKey hexutil.Bytes `json:"privateKey,omitempty"`
+
+	NameHash  common.Hash    `json:"nameHash"`
+	Key       hexutil.Bytes  `json:"key"`
+	Digest    common.Hash    `json:"digest"`
+	Signature hexutil.Bytes  `json:"signature,omitempty"`
+	Signer    common.Address `json:"signer"`
+	Chunk     hexutil.Bytes  `json:"chunk"`
+}
+
+// the update chunks made and read by the handler match the test vectors
+func TestResourceVectors(t *testing.T) {
+	data, err := ioutil.ReadFile(resourceVectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []*resourceVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, err := NewResourceHandler(&ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+		Signer:          signer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unsigned updates are only read by handlers which do not sign
+	unsigned, err := NewResourceHandler(&ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, v := range vectors {
+		got, err := makeResourceVector(rh, v)
+		if err != nil {
+			t.Fatalf("%s: %v", v.Description, err)
+		}
+		if *updateVectors {
+			*v = *got
+			continue
+		}
+		checkResourceVector(t, v, got)
+		readResourceVector(t, rh, unsigned, v)
+	}
+
+	if *updateVectors {
+		data, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(resourceVectorsFile, append(data, '\n'), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestResourceLegacyVectors checks that the update chunks of the earlier
+// formats are read as they were made
+func TestResourceLegacyVectors(t *testing.T) {
+	signer, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, err := NewResourceHandler(&ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+		Signer:          signer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// unsigned updates are only read by handlers which do not sign
+	unsigned, err := NewResourceHandler(&ResourceHandlerParams{
+		QueryMaxPeriods: &ResourceLookupParams{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for format, file := range resourceLegacyVectorsFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var vectors []*resourceVector
+		if err := json.Unmarshal(data, &vectors); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range vectors {
+			if resourceChunkFormat(v.Chunk) != format {
+				t.Fatalf("%s: expected chunk of format %d, got %d", v.Description, format, resourceChunkFormat(v.Chunk))
+			}
+			readResourceVector(t, rh, unsigned, v)
+
+			// the baseline layout has no header, its updates cannot be
+			// made again
+			if format == resourceFormatLegacy {
+				checkBaselineVector(t, v)
+				continue
+			}
+
+			// the chunks made now hold the same update
+			got, err := makeResourceVector(rh, v)
+			if err != nil {
+				t.Fatalf("%s: %v", v.Description, err)
+			}
+			legacy, err := decodeResourceChunk(v.Chunk)
+			if err != nil {
+				t.Fatalf("%s: %v", v.Description, err)
+			}
+			current, err := decodeResourceChunk(got.Chunk)
+			if err != nil {
+				t.Fatalf("%s: %v", v.Description, err)
+			}
+			if !bytes.Equal(legacy, current) {
+				t.Errorf("%s: expected update %x, got %x", v.Description, current, legacy)
+			}
+		}
+	}
+}
+
+// checks the key and the digest of a vector of the baseline layout, and that
+// its chunk is rejected in the versioned formats
+func checkBaselineVector(t *testing.T, v *resourceVector) {
+	if key := baselineResourceHash(v.Period, v.Version, ens.EnsNode(v.Name)); !bytes.Equal(key, v.Key) {
+		t.Errorf("%s: expected key %x, got %x", v.Description, []byte(v.Key), key)
+	}
+	if digest := baselineUpdateDigest(Key(v.Key), v.Data); digest != v.Digest {
+		t.Errorf("%s: expected digest %x, got %x", v.Description, v.Digest, digest)
+	}
+	decoded, err := decodeResourceChunk(v.Chunk)
+	if err != nil {
+		t.Fatalf("%s: %v", v.Description, err)
+	}
+	if _, err := decodeResourceChunk(append([]byte{resourceFormatMarker >> 8, resourceFormatMarker & 0xff, resourceFormatCurrent}, decoded...)); err == nil {
+		t.Errorf("%s: expected baseline header to be rejected in format %d", v.Description, resourceFormatCurrent)
+	}
+}
+
+// returns the vector with the outputs made from its inputs
+func makeResourceVector(rh *ResourceHandler, v *resourceVector) (*resourceVector, error) {
+	hash, err := ParseResourceHashAlgorithm(v.Hash)
+	if err != nil {
+		return nil, err
+	}
+	made := *v
+	made.NameHash = ens.EnsNode(v.Name)
+	made.Key = hexutil.Bytes(rh.resourceHashWith(hash, v.Period, v.Version, made.NameHash))
+	made.Digest = rh.keyDataHashWith(hash, Key(made.Key), v.ModTime, v.Data)
+	made.Signature = nil
+	made.Signer = common.Address{}
+
+	var signature *Signature
+	if len(v.PrivateKey) > 0 {
+		privKey, err := crypto.ToECDSA(v.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		sig, err := (&GenericResourceSigner{PrivKey: privKey}).Sign(made.Digest)
+		if err != nil {
+			return nil, err
+		}
+		signature = &sig
+		made.Signature = sig[:]
+		made.Signer = crypto.PubkeyToAddress(privKey.PublicKey)
+	}
+
+	var flags uint8
+	if v.Multihash {
+		flags = resourceMultihashFlag
+	}
+	header := &resourceUpdateHeader{
+		modTime: v.ModTime,
+		signer:  made.Signer,
+	}
+	chunk := newUpdateChunk(Key(made.Key), signature, header, v.Period, v.Version, v.Name, v.Data, flags)
+	made.Chunk = chunk.SData
+	return &made, nil
+}
+
+// checks the outputs of the vector against the ones made by the handler
+func checkResourceVector(t *testing.T, v *resourceVector, got *resourceVector) {
+	if got.NameHash != v.NameHash {
+		t.Errorf("%s: expected namehash %x, got %x", v.Description, v.NameHash, got.NameHash)
+	}
+	if !bytes.Equal(got.Key, v.Key) {
+		t.Errorf("%s: expected key %x, got %x", v.Description, v.Key, got.Key)
+	}
+	if got.Digest != v.Digest {
+		t.Errorf("%s: expected digest %x, got %x", v.Description, v.Digest, got.Digest)
+	}
+	if !bytes.Equal(got.Signature, v.Signature) {
+		t.Errorf("%s: expected signature %x, got %x", v.Description, v.Signature, got.Signature)
+	}
+	if got.Signer != v.Signer {
+		t.Errorf("%s: expected signer %x, got %x", v.Description, v.Signer, got.Signer)
+	}
+	if !bytes.Equal(got.Chunk, v.Chunk) {
+		t.Errorf("%s: expected chunk %x, got %x", v.Description, v.Chunk, got.Chunk)
+	}
+}
+
+// checks that the handler reads the inputs of the vector back from its chunk
+// and accepts the chunk. Unsigned chunks are read with the handler which does
+// not sign, the signing handler must reject them.
+func readResourceVector(t *testing.T, signed *ResourceHandler, unsigned *ResourceHandler, v *resourceVector) {
+	chunkdata, err := decodeResourceChunk(v.Chunk)
+	if err != nil {
+		t.Fatalf("%s: %v", v.Description, err)
+	}
+	rh := signed
+	if len(v.Signature) == 0 {
+		if _, _, _, _, _, _, err := signed.parseUpdate(chunkdata); err == nil {
+			t.Errorf("%s: expected unsigned chunk to fail parsing when signing", v.Description)
+		}
+		if signed.Validate(Key(v.Key), v.Chunk) {
+			t.Errorf("%s: expected unsigned chunk to be invalid when signing", v.Description)
+		}
+		rh = unsigned
+	}
+	signature, period, version, name, data, multihash, err := rh.parseUpdate(chunkdata)
+	if err != nil {
+		t.Fatalf("%s: %v", v.Description, err)
+	}
+	if period != v.Period || version != v.Version || name != v.Name || multihash != v.Multihash {
+		t.Errorf("%s: expected period %d version %d name %s multihash %v, got %d, %d, %s, %v", v.Description, v.Period, v.Version, v.Name, v.Multihash, period, version, name, multihash)
+	}
+	if !bytes.Equal(data, v.Data) {
+		t.Errorf("%s: expected data %x, got %x", v.Description, v.Data, data)
+	}
+	header, err := parseUpdateHeader(chunkdata)
+	if err != nil {
+		t.Fatalf("%s: %v", v.Description, err)
+	}
+	if header.modTime != v.ModTime || header.signer != v.Signer {
+		t.Errorf("%s: expected modtime %d signer %x, got %d, %x", v.Description, v.ModTime, v.Signer, header.modTime, header.signer)
+	}
+	if len(v.Signature) == 0 {
+		if signature != nil {
+			t.Errorf("%s: expected no signature, got %x", v.Description, signature[:])
+		}
+	} else if signature == nil || !bytes.Equal(signature[:], v.Signature) {
+		t.Errorf("%s: expected signature %x, got %v", v.Description, v.Signature, signature)
+	} else if addr, err := getAddressFromDataSig(v.Digest, *signature); err != nil || addr != v.Signer {
+		t.Errorf("%s: expected signature of %x, got %x (%v)", v.Description, v.Signer, addr, err)
+	}
+	if !rh.Validate(Key(v.Key), v.Chunk) {
+		t.Errorf("%s: expected chunk to be valid", v.Description)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/swarm/multihash"
+)
+
+// ResourceView is a resource as of one of its updates, as returned by the
+// lookups of the handler. Views are never modified, so they can be used while
+// other lookups of the same resource run concurrently.
+//
+// The handler keeps the latest update it knows of every resource apart from
+// the views it returns, see ResourceHandler.GetContent.
+type ResourceView struct {
+	Multihash  bool
+	name       string
+	nameHash   common.Hash
+	rootKey    Key
+	startBlock uint64
+	frequency  uint64
+	scheme     ResourcePeriodScheme
+	lastPeriod uint32 // period of the update, 0 if the resource has no update yet
+	lastKey    Key    // key of the update chunk, nil if the resource has no update yet
+	version    uint32
+	data       []byte
+	modTime    uint64              // creation time of the update in unix seconds, see ResourceMetadata
+	signer     common.Address      // signer of the update, zero if not signed
+	encrypted  bool                // the data is encrypted and could not be decrypted, see SetSecret
+	encryption *resourceEncryption // the encryption of the data if it could not be decrypted, see Decrypt
+	tombstone  bool                // the update deleted the resource
+
+	dpa *DPA // retrieves the content referenced by multihash updates, see ResolveContent
+}
+
+// returns a view of the resource without an update
+func (self *resource) newView() *ResourceView {
+	return &ResourceView{
+		name:       self.name,
+		nameHash:   self.nameHash,
+		rootKey:    self.rootKey,
+		startBlock: self.startBlock,
+		frequency:  self.frequency,
+		scheme:     self.scheme,
+	}
+}
+
+// returns true if the view is of a later update than other, or of the same
+// update
+func (self *ResourceView) supersedes(other *ResourceView) bool {
+	if other == nil || self.lastPeriod != other.lastPeriod {
+		return other == nil || self.lastPeriod > other.lastPeriod
+	}
+	return self.version >= other.version
+}
+
+// Name returns the name of the resource
+func (self *ResourceView) Name() string {
+	return self.name
+}
+
+// NameHash returns the namehash of the name of the resource
+func (self *ResourceView) NameHash() common.Hash {
+	return self.nameHash
+}
+
+// RootKey returns the key of the metadata chunk of the resource, nil if it is
+// not known
+func (self *ResourceView) RootKey() Key {
+	return self.rootKey
+}
+
+// Period returns the period of the update, 0 if the resource has no update
+func (self *ResourceView) Period() uint32 {
+	return self.lastPeriod
+}
+
+// Version returns the version of the update within its period
+func (self *ResourceView) Version() uint32 {
+	return self.version
+}
+
+// Key returns the key of the update chunk, nil if the resource has no update
+func (self *ResourceView) Key() Key {
+	return self.lastKey
+}
+
+// Data returns the data of the update, which must not be modified. It fails
+// for encrypted updates which could not be decrypted.
+func (self *ResourceView) Data() ([]byte, error) {
+	if self.encrypted {
+		return nil, NewResourceError(ErrEncrypted, "Resource data is encrypted")
+	}
+	return self.data, nil
+}
+
+// ModTime returns the creation time of the update as claimed by its signer
+func (self *ResourceView) ModTime() time.Time {
+	return time.Unix(int64(self.modTime), 0)
+}
+
+// Signer returns the signer of the update, zero if it is not signed
+func (self *ResourceView) Signer() common.Address {
+	return self.signer
+}
+
+// Encrypted returns true if the data of the update is encrypted and could not
+// be decrypted, see ResourceHandler.SetSecret
+func (self *ResourceView) Encrypted() bool {
+	return self.encrypted
+}
+
+// Decrypt returns a view of the update with the data decrypted with the
+// secret of the resource, for updates which the handler could not decrypt.
+// Unlike SetSecret of the handler it decrypts this view only, so that secrets
+// presented by a single reader are not used for anyone else.
+func (self *ResourceView) Decrypt(secret []byte) (*ResourceView, error) {
+	if !self.encrypted {
+		return self, nil
+	}
+	data, err := decryptUpdate(self.nameHash, secret, self.encryption, self.data)
+	if err != nil {
+		return nil, WrapResourceError(ErrEncrypted, "Cannot decrypt resource data", err)
+	}
+	view := *self
+	view.data = data
+	view.encrypted = false
+	view.encryption = nil
+	return &view, nil
+}
+
+// Size returns the length of the data of the update
+func (self *ResourceView) Size(chan bool) (int64, error) {
+	return int64(len(self.data)), nil
+}
+
+// NewReader returns a new reader of the data of the update
+func (self *ResourceView) NewReader() LazySectionReader {
+	return &resourceReader{bytes.NewReader(self.data)}
+}
+
+// reads the data of an update, see ResourceView.NewReader
+type resourceReader struct {
+	*bytes.Reader
+}
+
+func (self *resourceReader) Size(chan bool) (int64, error) {
+	return self.Reader.Size(), nil
+}
+
+// ResolveContent returns a reader of the swarm content referenced by the
+// multihash of the update, a manifest or a file, which is retrieved with the
+// dpa as it is read so that large content can be streamed. Only the root chunk
+// of the content is retrieved before it returns.
+func (self *ResourceView) ResolveContent(ctx context.Context) (LazySectionReader, error) {
+	if !self.Multihash {
+		return nil, NewResourceError(ErrInvalidValue, "Update data is not a multihash")
+	} else if self.dpa == nil {
+		return nil, NewResourceError(ErrInit, "Resource was not synced by a handler with a store")
+	}
+	decoded, err := multihash.Decode(self.data)
+	if err != nil {
+		return nil, WrapResourceError(ErrCorruptData, "Invalid multihash", err)
+	} else if decoded.Code != multihash.KECCAK_256 {
+		return nil, NewResourceError(ErrInvalidValue, fmt.Sprintf("Multihash code %x does not reference swarm content", decoded.Code))
+	}
+	reader, _ := self.dpa.Retrieve(Key(decoded.Digest))
+	errC := make(chan error, 1)
+	go func() {
+		_, err := reader.Size(nil)
+		errC <- err
+	}()
+	select {
+	case err := <-errC:
+		if err != nil {
+			return nil, WrapResourceError(ErrNotFound, "Cannot retrieve referenced content", err)
+		}
+	case <-ctx.Done():
+		return nil, WrapResourceError(ErrIO, "Content resolution aborted", ctx.Err())
+	}
+	return reader, nil
+}
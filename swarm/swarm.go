@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"math/big"
 	"net"
@@ -28,11 +29,16 @@ import (
 	"time"
 	"unicode"
 
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/contracts/chequebook"
 	"github.com/ethereum/go-ethereum/contracts/ens"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
@@ -42,6 +48,7 @@ import (
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/swarm/api"
 	httpapi "github.com/ethereum/go-ethereum/swarm/api/http"
+	"github.com/ethereum/go-ethereum/swarm/events"
 	"github.com/ethereum/go-ethereum/swarm/fuse"
 	"github.com/ethereum/go-ethereum/swarm/network"
 	"github.com/ethereum/go-ethereum/swarm/network/stream"
@@ -70,11 +77,17 @@ type Swarm struct {
 	bzz         *network.Bzz       // the logistic manager
 	backend     chequebook.Backend // simple blockchain Backend
 	privateKey  *ecdsa.PrivateKey
+	publisher   *ecdsa.PrivateKey // signs the resource updates, the publisher account or else privateKey
 	corsString  string
 	swapEnabled bool
-	lstore      *storage.LocalStore // local store, needs to store for releasing resources after node stopped
-	sfs         *fuse.SwarmFS       // need this to cleanup all the active mounts on node exit
+	lstore      *storage.LocalStore   // local store, needs to store for releasing resources after node stopped
+	shards      []*storage.LocalStore // local stores of the resource shards, see storage.ResourceHandler.SetShards
+	chainAPIs   []*ethclient.Client   // chain nodes the block heights of resources are got from, see newHeaderChain
+	sfs         *fuse.SwarmFS         // need this to cleanup all the active mounts on node exit
 	ps          *pss.Pss
+	stateStore  state.Store        // persisted node state, shared by bzz, the streamer and the s3 facade
+	events      *events.Exporter   // exports the events of the node, nil if there are no event sinks
+	peerEvents  event.Subscription // publishes the dropped peers to the event sinks
 }
 
 type SwarmAPI struct {
@@ -144,6 +157,7 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	if err != nil {
 		return
 	}
+	self.stateStore = stateStore
 
 	// set up high level api
 	var resolver *api.MultiResolver
@@ -169,17 +183,30 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	}
 
 	db := storage.NewDBAPI(self.lstore)
+	kp := network.NewKadParams()
+	kp.Bootnodes = bootnodeAddrs(config.BootNodes)
 	to := network.NewKademlia(
 		common.FromHex(config.BzzKey),
-		network.NewKadParams(),
+		kp,
 	)
 	delivery := stream.NewDelivery(to, db)
 
+	authorizer, authToken, err := newChunkAuthorizer(config)
+	if err != nil {
+		return nil, err
+	}
+
 	self.streamer = stream.NewRegistry(addr, delivery, db, stateStore, &stream.RegistryOptions{
 		SkipCheck:       config.DeliverySkipCheck,
 		DoSync:          config.SyncEnabled,
 		DoRetrieve:      true,
 		SyncUpdateDelay: config.SyncUpdateDelay,
+		SyncHealth:      config.SyncHealth,
+		SyncHistoryRate: config.SyncHistoryRate,
+		Retrieval:       stream.NewRetrievalParams(),
+		EncryptTransit:  config.TransitEncryption,
+		Authorizer:      authorizer,
+		AuthToken:       authToken,
 	})
 
 	// set up DPA, the cloud storage local access layer
@@ -187,24 +214,71 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	// Swarm Hash Merklised Chunking for Arbitrary-length Document/File storage
 	self.dpa = storage.NewDPA(dpaChunkStore, self.config.DPAParams)
 
+	// the publisher account signs the updates of the resources, which are
+	// validated against its address, so that the nodes of a publisher
+	// sharing it can replace one another
+	self.publisher = config.ShiftPublisherKey()
+	if self.publisher == nil {
+		self.publisher = self.privateKey
+	} else {
+		log.Info("Signing resource updates with the publisher account", "address", crypto.PubkeyToAddress(self.publisher.PublicKey))
+	}
+
+	headerChain, chainAPIs := newHeaderChain(config, resolver)
+	self.chainAPIs = chainAPIs
+	// the connections are closed by Stop once the service is set up
+	defer func() {
+		if err != nil {
+			for _, client := range chainAPIs {
+				client.Close()
+			}
+		}
+	}()
+
 	var resourceHandler *storage.ResourceHandler
 	rhparams := &storage.ResourceHandlerParams{
 		// TODO: config parameter to set limits
 		QueryMaxPeriods: &storage.ResourceLookupParams{
-			Limit: false,
+			Strategy: storage.ParallelLookup{FanOut: config.ResourceFanOut},
 		},
 		Signer: &storage.GenericResourceSigner{
-			PrivKey: self.privateKey,
+			PrivKey: self.publisher,
 		},
-		HeaderGetter:   resolver,
-		OwnerValidator: resolver,
+		HeaderGetter:     headerChain,
+		OwnerValidator:   resolver,
+		RetrieveTimeout:  config.ResourceRetrieveTimeout,
+		StoreTimeout:     config.ResourceStoreTimeout,
+		MaxIndexEntries:  config.ResourceIndexSize,
+		IndexTTL:         config.ResourceIndexTTL,
+		StrictValidation: config.ResourceStrict,
+		RefreshInterval:  config.ResourceRefreshInterval,
+		PrefetchAhead:    config.ResourcePrefetchAhead,
+
+		AllowUnknownMultihash: config.ResourceAnyMultihash,
+	}
+	// the eth backend notifies the heads of the chain, so that lookups need no
+	// header retrieval
+	if client, ok := backend.(*ethclient.Client); ok {
+		rhparams.HeadSubscriber = client
+	}
+	if config.ResourceNameRate > 0 || config.ResourceSignerRate > 0 || config.ResourceUpdateRate > 0 {
+		rhparams.UpdateRateLimit = &storage.ResourceRateLimit{
+			Interval:  time.Minute,
+			PerName:   config.ResourceNameRate,
+			PerSigner: config.ResourceSignerRate,
+			Total:     config.ResourceUpdateRate,
+		}
 	}
 	if resolver != nil {
 		resolver.SetNameHash(ens.EnsNode)
+		rhparams.ACLValidator = resolver
+		if config.ResourceENSUpdate {
+			rhparams.ENSUpdater = resolver
+		}
 	} else {
-		log.Warn("No ETH API specified, resource updates will use block height approximation")
-		// TODO: blockestimator should use saved values derived from last time ethclient was connected
-		rhparams.HeaderGetter = storage.NewBlockEstimator()
+		if config.ResourceENSUpdate {
+			log.Warn("No ETH API specified, content records of new resources will not be set")
+		}
 	}
 	resourceHandler, err = storage.NewResourceHandler(rhparams)
 	if err != nil {
@@ -219,6 +293,23 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	}
 	self.lstore.Validators = validators
 
+	if len(config.ResourceShards) > 0 {
+		for _, dir := range config.ResourceShards {
+			params := storage.NewDefaultLocalStoreParams()
+			params.Init(dir)
+			shard, err := storage.NewLocalStore(params, nil)
+			if err != nil {
+				return nil, fmt.Errorf("error opening resource shard %s: %v", dir, err)
+			}
+			shard.Validators = validators
+			self.shards = append(self.shards, shard)
+		}
+		if err := resourceHandler.SetShards(self.shards); err != nil {
+			return nil, err
+		}
+		log.Info("resources sharded", "shards", len(self.shards))
+	}
+
 	// setup local store
 	log.Debug(fmt.Sprintf("Set up local storage"))
 
@@ -247,6 +338,63 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	}
 
 	self.api = api.NewApi(self.dpa, self.dns, resourceHandler)
+	self.api.SetResourceNesting(config.ResourceNesting)
+	if config.AccessNodeKey {
+		log.Info("Unlocking the access grants to the node key for any request")
+		self.api.SetAccessKey(self.privateKey)
+	}
+	if config.IPFSGateway != "" {
+		log.Info("Enabling ipfs bridge", "gateway", config.IPFSGateway)
+		self.api.SetIPFSBridge(api.NewIPFSBridge(config.IPFSGateway, self.dpa, stateStore))
+	}
+	if config.ManagedKeys {
+		log.Info("Enabling managed publisher keys")
+		self.api.SetManagedSigners(api.NewManagedSigners(stateStore))
+	}
+	if config.GatewayKeys {
+		log.Info("Enabling gateway API keys")
+		self.api.SetGatewayKeys(api.NewGatewayKeys(stateStore))
+	}
+	if config.SearchIndex {
+		log.Info("Enabling search index")
+		self.api.SetSearchIndex(api.NewSearchIndex(self.api, stateStore))
+	}
+	if len(config.EventSinks) > 0 {
+		self.events = events.NewExporter()
+		for _, s := range config.EventSinks {
+			sink, types, err := events.ParseSink(s)
+			if err != nil {
+				self.events.Close()
+				return nil, fmt.Errorf("error opening event sink %s: %v", s, err)
+			}
+			self.events.AddSink(sink, types...)
+		}
+		self.api.SetEvents(self.events)
+		resourceHandler.AddUpdateMiddleware(self.events.ResourceMiddleware())
+		self.lstore.DbStore.AddGCListener(self.events.GCListener())
+		for _, shard := range self.shards {
+			shard.DbStore.AddGCListener(self.events.GCListener())
+		}
+		log.Info("Exporting node events", "sinks", len(config.EventSinks))
+	}
+	if config.NotaryAPI != "" {
+		log.Info("connecting to notary API", "url", config.NotaryAPI)
+		client, err := rpc.Dial(config.NotaryAPI)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to notary API %s: %s", config.NotaryAPI, err)
+		}
+		chainID := new(big.Int).SetUint64(config.NotaryChainID)
+		if chainID.Sign() == 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			chainID, err = ethclient.NewClient(client).NetworkID(ctx)
+			cancel()
+			if err != nil {
+				return nil, fmt.Errorf("error getting the chain id of notary API %s: %s", config.NotaryAPI, err)
+			}
+			log.Info("using the network id of the notary API as its chain id", "id", chainID)
+		}
+		self.api.SetNotary(api.NewNotary(client, bind.NewKeyedTransactor(self.privateKey), config.NotaryRegistry, chainID))
+	}
 	// Manifests for Smart Hosting
 	log.Debug(fmt.Sprintf("-> Web3 virtual server API"))
 
@@ -256,6 +404,44 @@ func NewSwarm(config *api.Config, mockStore *mock.NodeStore) (self *Swarm, err e
 	return self, nil
 }
 
+// newChunkAuthorizer returns the authorizer of the chunk requests of peers and
+// the capability token of the node, nil for an open swarm
+func newChunkAuthorizer(config *api.Config) (stream.ChunkAuthorizer, []byte, error) {
+	var authorizers stream.AnyAuthorizer
+	if config.AuthAllow != "" {
+		var overlays [][]byte
+		for _, s := range strings.Split(config.AuthAllow, ",") {
+			overlay, err := hexutil.Decode(strings.TrimSpace(s))
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid overlay address %q in allowed peers: %v", s, err)
+			}
+			overlays = append(overlays, overlay)
+		}
+		authorizers = append(authorizers, stream.NewAllowlistAuthorizer(overlays))
+	}
+	var secret []byte
+	if config.AuthSecret != "" {
+		var err error
+		if secret, err = hexutil.Decode(config.AuthSecret); err != nil {
+			return nil, nil, fmt.Errorf("invalid auth secret: %v", err)
+		}
+		authorizers = append(authorizers, stream.NewTokenAuthorizer(secret))
+	}
+	var token []byte
+	if config.AuthToken != "" {
+		var err error
+		if token, err = hexutil.Decode(config.AuthToken); err != nil {
+			return nil, nil, fmt.Errorf("invalid auth token: %v", err)
+		}
+	} else if secret != nil {
+		token = stream.IssueAuthToken(secret, common.FromHex(config.BzzKey))
+	}
+	if len(authorizers) == 0 {
+		return nil, token, nil
+	}
+	return authorizers, token, nil
+}
+
 // parseEnsAPIAddress parses string according to format
 // [tld:][contract-addr@]url and returns ENSClientConfig structure
 // with endpoint, contract address and TLD.
@@ -282,6 +468,80 @@ func parseEnsAPIAddress(s string) (tld, endpoint string, addr common.Address) {
 	return
 }
 
+// newHeaderChain returns the sources of the block heights of resources: the
+// chain nodes of the config, the ENS APIs and, unless offline, the block
+// estimator. It also returns the clients of the chain nodes, which are to be
+// closed by the caller.
+func newHeaderChain(config *api.Config, resolver *api.MultiResolver) (*storage.HeaderChain, []*ethclient.Client) {
+	var sources []storage.HeaderSource
+	var clients []*ethclient.Client
+	for _, endpoint := range config.ResourceChainAPIs {
+		client, err := ethclient.Dial(endpoint)
+		if err != nil {
+			log.Warn("cannot connect to resource chain API", "url", endpoint, "err", err)
+			continue
+		}
+		clients = append(clients, client)
+		sources = append(sources, storage.HeaderSource{
+			Name:   endpoint,
+			Getter: &chainHeaderGetter{client},
+		})
+	}
+	if resolver != nil {
+		sources = append(sources, storage.HeaderSource{
+			Name:   "ens",
+			Getter: resolver,
+		})
+	}
+	if config.ResourceOffline {
+		if len(sources) == 0 {
+			log.Warn("No ETH API specified, block based resources are unavailable offline")
+		}
+	} else {
+		if len(sources) == 0 {
+			log.Warn("No ETH API specified, resource updates will use block height approximation")
+		}
+		// TODO: blockestimator should use saved values derived from last time ethclient was connected
+		sources = append(sources, storage.HeaderSource{
+			Name:     "estimate",
+			Getter:   storage.NewBlockEstimator(),
+			Estimate: true,
+		})
+	}
+	return storage.NewHeaderChain(&storage.HeaderChainParams{
+		Sources:      sources,
+		MaxHeaderAge: config.ResourceMaxHeaderAge,
+		Offline:      config.ResourceOffline,
+	}), clients
+}
+
+// bootnodeAddrs returns the overlay addresses of the comma separated enode
+// URLs of the bootnodes
+func bootnodeAddrs(bootnodes string) [][]byte {
+	var addrs [][]byte
+	for _, url := range strings.Split(bootnodes, ",") {
+		if url == "" {
+			continue
+		}
+		n, err := discover.ParseNode(url)
+		if err != nil {
+			log.Warn("invalid bootnode", "url", url, "err", err)
+			continue
+		}
+		addrs = append(addrs, network.NewAddrFromNodeID(n.ID).Over())
+	}
+	return addrs
+}
+
+// chainHeaderGetter gets the block headers of resources from a chain node
+type chainHeaderGetter struct {
+	client *ethclient.Client
+}
+
+func (self *chainHeaderGetter) HeaderByNumber(ctx context.Context, name string, number *big.Int) (*types.Header, error) {
+	return self.client.HeaderByNumber(ctx, number)
+}
+
 // ensClient provides functionality for api.ResolveValidator
 type ensClient struct {
 	*ens.ENS
@@ -289,6 +549,22 @@ type ensClient struct {
 	EnsRoot common.Address
 }
 
+// resourceACLABI is the interface of the contracts authorizing the updaters
+// of mutable resources
+const resourceACLABI = `[{"constant":true,"inputs":[{"name":"node","type":"bytes32"},{"name":"updater","type":"address"}],"name":"isAuthorized","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"view","type":"function"}]`
+
+// IsAuthorized queries a resource access control contract if the address may
+// update the resource of the ENS node
+func (self *ensClient) IsAuthorized(contract common.Address, node [32]byte, address common.Address) (bool, error) {
+	parsed, err := abi.JSON(strings.NewReader(resourceACLABI))
+	if err != nil {
+		return false, err
+	}
+	var authorized bool
+	err = bind.NewBoundContract(contract, parsed, self.Client, self.Client, self.Client).Call(nil, &authorized, "isAuthorized", node, address)
+	return authorized, err
+}
+
 // newEnsClient creates a new ENS client for that is a consumer of
 // a ENS API on a specific endpoint. It is used as a helper function
 // for creating multiple resolvers in NewSwarm function.
@@ -406,19 +682,68 @@ func (self *Swarm) Start(srv *p2p.Server) error {
 
 	// start swarm http proxy server
 	if self.config.Port != "" {
+		powDifficulty, err := api.ParsePowDifficulty(self.config.PowDifficulty)
+		if err != nil {
+			return err
+		}
+		virtualHosts, err := httpapi.ParseVirtualHosts(self.config.VirtualHosts)
+		if err != nil {
+			return err
+		}
 		addr := net.JoinHostPort(self.config.ListenAddr, self.config.Port)
-		go httpapi.StartHttpServer(self.api, &httpapi.ServerConfig{
-			Addr:       addr,
-			CorsString: self.config.Cors,
+		var tlsConfig *httpapi.TLSConfig
+		if self.config.TLSPort != "" {
+			tlsConfig = &httpapi.TLSConfig{
+				Addr:     net.JoinHostPort(self.config.ListenAddr, self.config.TLSPort),
+				CertFile: self.config.TLSCert,
+				KeyFile:  self.config.TLSKey,
+				CertDir:  self.config.TLSCertDir,
+			}
+		}
+		err = httpapi.StartHttpServer(self.api, &httpapi.ServerConfig{
+			Addr:          addr,
+			CorsString:    self.config.Cors,
+			PowDifficulty: powDifficulty,
+			VirtualHosts:  virtualHosts,
+			TLS:           tlsConfig,
+			WriteAddr:     self.config.WriteAddr,
+			AdminAddr:     self.config.AdminAddr,
 		})
+		if err != nil {
+			return err
+		}
 	}
 
 	log.Debug(fmt.Sprintf("Swarm http proxy started on port: %v", self.config.Port))
 
+	// start the optional s3 compatible gateway, its requests bypass the API
+	// keys and the proof of work of the http writes, so it is bound to the
+	// host of the admin API
+	if self.config.S3Port != "" {
+		host := self.config.ListenAddr
+		if self.config.AdminAddr != "" {
+			var err error
+			if host, _, err = net.SplitHostPort(self.config.AdminAddr); err != nil {
+				return fmt.Errorf("invalid admin API address %q: %v", self.config.AdminAddr, err)
+			}
+		} else if self.api.GatewayKeys() != nil || self.config.PowDifficulty != "" {
+			return errors.New("the s3 gateway requires the admin API on a listener of its own if http writes need API keys or proof of work")
+		}
+		addr := net.JoinHostPort(host, self.config.S3Port)
+		if err := httpapi.StartS3Server(self.api, self.stateStore, addr); err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Swarm s3 gateway started on port: %v", self.config.S3Port))
+	}
+
 	if self.config.Cors != "" {
 		log.Debug(fmt.Sprintf("Swarm http proxy started with corsdomain: %v", self.config.Cors))
 	}
 
+	if self.events != nil {
+		self.peerEvents = self.events.WatchPeers(srv)
+	}
+
 	self.periodicallyUpdateGauges()
 
 	startCounter.Inc(1)
@@ -455,7 +780,29 @@ func (self *Swarm) Stop() error {
 	if self.lstore != nil {
 		self.lstore.DbStore.Close()
 	}
+	for _, shard := range self.shards {
+		shard.DbStore.Close()
+	}
+	for _, client := range self.chainAPIs {
+		client.Close()
+	}
 	self.sfs.Stop()
+	if self.peerEvents != nil {
+		self.peerEvents.Unsubscribe()
+	}
+	if self.events != nil {
+		if err := self.events.Close(); err != nil {
+			log.Warn("closing event sinks failed", "err", err)
+		}
+	}
+	if index := self.api.SearchIndex(); index != nil {
+		index.Close()
+	}
+	if keys := self.api.GatewayKeys(); keys != nil {
+		if err := keys.Save(); err != nil {
+			log.Warn("saving usage of gateway API keys failed", "err", err)
+		}
+	}
 	stopCounter.Inc(1)
 	self.streamer.Stop()
 	return self.bzz.Stop()
@@ -524,6 +871,19 @@ func (self *Swarm) APIs() []rpc.API {
 			Service:   api.NewFileSystem(self.api),
 			Public:    false,
 		},
+		// mutable resource APIs
+		{
+			Namespace: "mru",
+			Version:   "0.1",
+			Service:   api.NewPublicResourceAPI(self.api),
+			Public:    true,
+		},
+		{
+			Namespace: "mru",
+			Version:   "0.1",
+			Service:   api.NewResourceAPI(self.api),
+			Public:    false,
+		},
 		// {Namespace, Version, api.NewAdmin(self), false},
 	}
 
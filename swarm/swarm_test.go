@@ -63,6 +63,9 @@ func TestNewSwarm(t *testing.T) {
 				if s.privateKey == nil {
 					t.Error("private key is not set")
 				}
+				if s.publisher != s.privateKey {
+					t.Error("resource updates are not signed with the private key")
+				}
 				if !s.config.HiveParams.Discovery {
 					t.Error("config.HiveParams.Discovery is false, must be true regardless the configuration")
 				}
@@ -131,6 +134,24 @@ func TestNewSwarm(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "with publisher account",
+			configure: func(config *api.Config) {
+				key, err := crypto.GenerateKey()
+				if err != nil {
+					t.Fatal(err)
+				}
+				config.SetPublisherKey(key)
+			},
+			check: func(t *testing.T, s *Swarm, config *api.Config) {
+				if s.publisher == nil || s.publisher == s.privateKey {
+					t.Error("resource updates are not signed with the publisher key")
+				}
+				if config.ShiftPublisherKey() != nil {
+					t.Error("publisher key is left in the config")
+				}
+			},
+		},
 		{
 			name: "ens",
 			configure: func(config *api.Config) {
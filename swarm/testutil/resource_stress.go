@@ -0,0 +1,281 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// ResourceStressBackend is the resource subsystem under test of a stress run
+type ResourceStressBackend interface {
+	Create(ctx context.Context, name string, frequency uint64) error
+	Update(ctx context.Context, name string, data []byte) error
+	Lookup(ctx context.Context, name string) ([]byte, error)
+}
+
+// ResourceHandlerStressBackend runs a stress test directly against a resource handler
+type ResourceHandlerStressBackend struct {
+	Handler *storage.ResourceHandler
+}
+
+func (b *ResourceHandlerStressBackend) Create(ctx context.Context, name string, frequency uint64) error {
+	_, _, err := b.Handler.NewResourceWithScheme(ctx, name, frequency, storage.TimePeriods)
+	return err
+}
+
+func (b *ResourceHandlerStressBackend) Update(ctx context.Context, name string, data []byte) error {
+	_, err := b.Handler.Update(ctx, name, data)
+	return err
+}
+
+func (b *ResourceHandlerStressBackend) Lookup(ctx context.Context, name string) ([]byte, error) {
+	if _, err := b.Handler.LookupLatestByName(ctx, name, true, nil); err != nil {
+		return nil, err
+	}
+	_, data, err := b.Handler.GetContent(storage.ResourceNameHash(name).Hex())
+	return data, err
+}
+
+// ResourceStressParams sets the load of a stress run
+type ResourceStressParams struct {
+	Resources int     // number of resources created
+	Updates   int     // number of updates of each resource
+	Workers   int     // number of goroutines publishing updates, each resource is updated by one of them
+	Rate      float64 // updates per second over all workers, 0 for no limit
+	DataSize  int     // size of the update data in bytes
+	Frequency uint64  // update frequency of the resources in seconds
+	Seed      int64   // seed of the names and the data, runs with the same seed publish the same updates
+}
+
+// NewResourceStressParams returns the parameters of a small stress run
+func NewResourceStressParams() *ResourceStressParams {
+	return &ResourceStressParams{
+		Resources: 16,
+		Updates:   8,
+		Workers:   4,
+		DataSize:  64,
+		Frequency: 3600,
+		Seed:      1,
+	}
+}
+
+// LatencyPercentiles summarizes the latencies of an operation
+type LatencyPercentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+func (l LatencyPercentiles) String() string {
+	return fmt.Sprintf("p50=%v p90=%v p99=%v max=%v", l.P50, l.P90, l.P99, l.Max)
+}
+
+// ResourceStressReport is the outcome of a stress run
+type ResourceStressReport struct {
+	Resources     int
+	Updates       int
+	Lookups       int
+	Failures      int   // updates and lookups returning an error
+	Inconsistent  int   // lookups returning other data than the last update
+	FirstError    error // the first failure or inconsistency, if any
+	UpdateLatency LatencyPercentiles
+	LookupLatency LatencyPercentiles
+	Duration      time.Duration
+}
+
+// OK is true if all updates and lookups succeeded and read back what was published
+func (r *ResourceStressReport) OK() bool {
+	return r.Failures == 0 && r.Inconsistent == 0
+}
+
+func (r *ResourceStressReport) String() string {
+	var rate float64
+	if r.Duration > 0 {
+		rate = float64(r.Updates) / r.Duration.Seconds()
+	}
+	return fmt.Sprintf("resources=%d updates=%d lookups=%d failures=%d inconsistent=%d duration=%v rate=%.1f/s\nupdate latency: %v\nlookup latency: %v",
+		r.Resources, r.Updates, r.Lookups, r.Failures, r.Inconsistent, r.Duration, rate, r.UpdateLatency, r.LookupLatency)
+}
+
+// ResourceStressName returns the name of the resource with the given index in a run with the given seed
+func ResourceStressName(seed int64, index int) string {
+	return fmt.Sprintf("stress-%x-%d.eth", uint64(seed), index)
+}
+
+// the data of the given update of a resource, derived from the seed only
+// so that it does not depend on the scheduling of the workers
+func resourceStressData(seed int64, index int, update int, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed*31 + int64(index)<<20 + int64(update))).Read(data)
+	return data
+}
+
+// collects the outcome of the operations of the workers
+type resourceStressRecorder struct {
+	lock    sync.Mutex
+	report  *ResourceStressReport
+	updates []time.Duration
+	lookups []time.Duration
+}
+
+func (r *resourceStressRecorder) fail(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.report.Failures++
+	if r.report.FirstError == nil {
+		r.report.FirstError = err
+	}
+}
+
+func (r *resourceStressRecorder) update(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.report.Updates++
+	r.updates = append(r.updates, d)
+}
+
+func (r *resourceStressRecorder) lookup(d time.Duration, name string, want []byte, got []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.report.Lookups++
+	r.lookups = append(r.lookups, d)
+	if !bytes.Equal(want, got) {
+		r.report.Inconsistent++
+		if r.report.FirstError == nil {
+			r.report.FirstError = fmt.Errorf("resource %s: read back %x, expected %x", name, got, want)
+		}
+	}
+}
+
+// RunResourceStress creates the resources of the run, publishes their updates
+// from the workers and reads back every update after it is published, and all
+// resources again at the end of the run.
+//
+// The names and the data of the updates only depend on the seed, and the
+// updates of each resource are published in order by a single worker.
+func RunResourceStress(ctx context.Context, backend ResourceStressBackend, params *ResourceStressParams) (*ResourceStressReport, error) {
+	if params.Resources <= 0 || params.Updates <= 0 || params.Workers <= 0 {
+		return nil, fmt.Errorf("resources, updates and workers must be positive")
+	} else if params.DataSize <= 0 || params.Frequency == 0 {
+		return nil, fmt.Errorf("data size and frequency must be positive")
+	}
+	rec := &resourceStressRecorder{
+		report: &ResourceStressReport{},
+	}
+	start := time.Now()
+	for i := 0; i < params.Resources; i++ {
+		name := ResourceStressName(params.Seed, i)
+		if err := backend.Create(ctx, name, params.Frequency); err != nil {
+			return nil, fmt.Errorf("create resource %s: %v", name, err)
+		}
+		rec.report.Resources++
+	}
+
+	// each worker waits for its turn at the share of the rate it gets
+	var interval time.Duration
+	if params.Rate > 0 {
+		interval = time.Duration(float64(params.Workers) / params.Rate * float64(time.Second))
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < params.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var tick <-chan time.Time
+			if interval > 0 {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				tick = ticker.C
+			}
+			for u := 0; u < params.Updates; u++ {
+				for i := w; i < params.Resources; i += params.Workers {
+					if tick != nil {
+						select {
+						case <-tick:
+						case <-ctx.Done():
+							return
+						}
+					} else if ctx.Err() != nil {
+						return
+					}
+					name := ResourceStressName(params.Seed, i)
+					data := resourceStressData(params.Seed, i, u, params.DataSize)
+					t := time.Now()
+					if err := backend.Update(ctx, name, data); err != nil {
+						rec.fail(fmt.Errorf("update %d of resource %s: %v", u, name, err))
+						continue
+					}
+					rec.update(time.Since(t))
+					t = time.Now()
+					got, err := backend.Lookup(ctx, name)
+					if err != nil {
+						rec.fail(fmt.Errorf("lookup of resource %s: %v", name, err))
+						continue
+					}
+					rec.lookup(time.Since(t), name, data, got)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// all resources must end up at their last update
+	for i := 0; i < params.Resources; i++ {
+		name := ResourceStressName(params.Seed, i)
+		t := time.Now()
+		got, err := backend.Lookup(ctx, name)
+		if err != nil {
+			rec.fail(fmt.Errorf("final lookup of resource %s: %v", name, err))
+			continue
+		}
+		rec.lookup(time.Since(t), name, resourceStressData(params.Seed, i, params.Updates-1, params.DataSize), got)
+	}
+	rec.report.Duration = time.Since(start)
+	rec.report.UpdateLatency = latencyPercentiles(rec.updates)
+	rec.report.LookupLatency = latencyPercentiles(rec.lookups)
+	return rec.report, nil
+}
+
+func latencyPercentiles(latencies []time.Duration) LatencyPercentiles {
+	if len(latencies) == 0 {
+		return LatencyPercentiles{}
+	}
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	at := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	return LatencyPercentiles{
+		P50: at(50),
+		P90: at(90),
+		P99: at(99),
+		Max: sorted[len(sorted)-1],
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package testutil
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/storage"
+)
+
+// a short stress run publishes every update and reads all of them back
+func TestResourceStress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "swarm-resource-stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	privkey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh, err := storage.NewTestResourceHandler(dir, &storage.ResourceHandlerParams{
+		Signer: &storage.GenericResourceSigner{PrivKey: privkey},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rh.Close()
+
+	params := NewResourceStressParams()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	report, err := RunResourceStress(ctx, &ResourceHandlerStressBackend{Handler: rh}, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Fatalf("stress run failed: %v\n%v", report.FirstError, report)
+	}
+	if report.Resources != params.Resources || report.Updates != params.Resources*params.Updates {
+		t.Fatalf("expected %d resources with %d updates, got %v", params.Resources, params.Resources*params.Updates, report)
+	}
+	if report.Lookups != report.Updates+params.Resources {
+		t.Fatalf("expected %d lookups, got %d", report.Updates+params.Resources, report.Lookups)
+	}
+
+	// the data only depends on the seed
+	if string(resourceStressData(1, 2, 3, 32)) != string(resourceStressData(1, 2, 3, 32)) {
+		t.Fatal("stress data is not deterministic")
+	}
+	if string(resourceStressData(1, 2, 3, 32)) == string(resourceStressData(2, 2, 3, 32)) {
+		t.Fatal("stress data does not depend on the seed")
+	}
+}